@@ -0,0 +1,132 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit implements structured security events emitted by NTP client and server,
+// e.g. for SIEM pipelines to consume instead of scraping logs
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the type of security event
+type Kind string
+
+// Security events
+const (
+	// AuthFailure is a request or response failing MAC or NTS authentication
+	AuthFailure Kind = "auth_failure"
+	// CryptoNAK is crypto-NAK sent or received
+	CryptoNAK Kind = "crypto_nak"
+	// NTSNAK is NTS NAK sent or received
+	NTSNAK Kind = "nts_nak"
+	// CookieExhaustion is NTS client running out of cookies and repeating key establishment
+	CookieExhaustion Kind = "cookie_exhaustion"
+	// Replay is a response which doesn't match the request in flight: stale, duplicate or spoofed
+	Replay Kind = "replay"
+	// UnauthenticatedDrop is unauthenticated request dropped because its source is required to authenticate
+	UnauthenticatedDrop Kind = "unauthenticated_drop"
+	// CryptoBudget is a request dropped unverified because verification budget is exhausted
+	CryptoBudget Kind = "crypto_budget"
+	// RateLimited is a request dropped because its source sends more than its rate limit allows
+	RateLimited Kind = "rate_limited"
+	// SourceDivergence is time source disagreeing with independent reference, e.g. NTP source with PTP
+	SourceDivergence Kind = "source_divergence"
+)
+
+// Event is a single security event
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind Kind      `json:"kind"`
+	// Peer is the address of the other side
+	Peer string `json:"peer,omitempty"`
+	// KeyID is symmetric key the message claimed to be signed with
+	KeyID uint32 `json:"key_id,omitempty"`
+	// Reason describes what exactly went wrong
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink consumes security events. Emit is called from packet processing paths, so it must not block
+type Sink interface {
+	Emit(e Event)
+}
+
+// Emit sends the event to the sink if there is one, filling in the time if it's not set
+func Emit(sink Sink, e Event) {
+	if sink == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	sink.Emit(e)
+}
+
+// JSONSink writes events as JSON lines. It is safe for concurrent use, but writes synchronously,
+// so it is better wrapped into AsyncSink unless the writer is fast
+type JSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONSink returns sink writing to w
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w)}
+}
+
+// Emit writes the event, write errors are ignored
+func (s *JSONSink) Emit(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(e)
+}
+
+// AsyncSink passes events to another sink from a separate goroutine.
+// Events which don't fit into the queue are dropped, so floods can't slow down the caller
+type AsyncSink struct {
+	sink    Sink
+	queue   chan Event
+	dropped int64
+}
+
+// NewAsyncSink returns sink queueing up to size events for the other sink
+func NewAsyncSink(sink Sink, size int) *AsyncSink {
+	s := &AsyncSink{sink: sink, queue: make(chan Event, size)}
+	go func() {
+		for e := range s.queue {
+			s.sink.Emit(e)
+		}
+	}()
+	return s
+}
+
+// Emit queues the event
+func (s *AsyncSink) Emit(e Event) {
+	select {
+	case s.queue <- e:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns how many events didn't fit into the queue
+func (s *AsyncSink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSink collects events
+type testSink struct {
+	mu     sync.Mutex
+	events []Event
+	block  chan struct{}
+}
+
+func (s *testSink) Emit(e Event) {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+}
+
+func TestEmit(t *testing.T) {
+	Emit(nil, Event{Kind: Replay})
+
+	sink := &testSink{}
+	Emit(sink, Event{Kind: Replay, Peer: "127.0.0.1:123"})
+	require.Equal(t, 1, len(sink.events))
+	assert.False(t, sink.events[0].Time.IsZero())
+	assert.Equal(t, Replay, sink.events[0].Kind)
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONSink(&buf)
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink.Emit(Event{Time: ts, Kind: AuthFailure, Peer: "[::1]:123", KeyID: 5, Reason: "bad MAC"})
+	sink.Emit(Event{Time: ts, Kind: NTSNAK})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Equal(t, 2, len(lines))
+	assert.JSONEq(t, `{"time":"2021-01-02T03:04:05Z","kind":"auth_failure","peer":"[::1]:123","key_id":5,"reason":"bad MAC"}`, string(lines[0]))
+	var e Event
+	require.Nil(t, json.Unmarshal(lines[1], &e))
+	assert.Equal(t, Event{Time: ts, Kind: NTSNAK}, e)
+}
+
+func TestAsyncSink(t *testing.T) {
+	inner := &testSink{block: make(chan struct{})}
+	sink := NewAsyncSink(inner, 1)
+	// first event is picked up by the goroutine, which blocks, second one is queued, third one is dropped
+	sink.Emit(Event{Kind: Replay})
+	require.Eventually(t, func() bool { return len(sink.queue) == 0 }, time.Second, time.Millisecond)
+	sink.Emit(Event{Kind: Replay})
+	sink.Emit(Event{Kind: Replay})
+	assert.Equal(t, int64(1), sink.Dropped())
+
+	close(inner.block)
+	require.Eventually(t, func() bool {
+		inner.mu.Lock()
+		defer inner.mu.Unlock()
+		return len(inner.events) == 2
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,319 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package capture records NTP packets sent and received to pcap files, ring-buffered the way tcpdump -C -W does,
+// for post-hoc debugging of accuracy incidents in Wireshark and friends
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pcap file constants: nanosecond resolution magic, version 2.4 and raw IP link type
+const (
+	magicNanoseconds = 0xa1b23c4d
+	versionMajor     = 2
+	versionMinor     = 4
+	snapLen          = 65535
+	linkTypeRaw      = 101
+)
+
+// Sizes of headers synthesized around payloads
+const (
+	fileHeaderSize   = 24
+	recordHeaderSize = 16
+	ipv4HeaderSize   = 20
+	ipv6HeaderSize   = 40
+	udpHeaderSize    = 8
+	// protocolUDP is IP protocol number of UDP
+	protocolUDP = 17
+)
+
+// Packet is a captured UDP datagram
+type Packet struct {
+	// Time is kernel timestamp of the packet when there is one, the time it was captured otherwise
+	Time    time.Time
+	Src     *net.UDPAddr
+	Dst     *net.UDPAddr
+	Payload []byte
+}
+
+// Sink consumes captured packets. Capture is called from packet processing paths, so it must not block
+type Sink interface {
+	Capture(p *Packet)
+}
+
+// Capture sends the packet to the sink if there is one
+func Capture(sink Sink, p *Packet) {
+	if sink == nil {
+		return
+	}
+	sink.Capture(p)
+}
+
+// Writer writes packets in pcap format, wrapping payloads into synthesized IP and UDP headers. It is not safe for concurrent use
+type Writer struct {
+	w io.Writer
+	// Written is how many bytes were written, file header included
+	Written int64
+}
+
+// NewWriter writes pcap file header to w and returns writer of packets following it
+func NewWriter(w io.Writer) (*Writer, error) {
+	h := make([]byte, fileHeaderSize)
+	binary.LittleEndian.PutUint32(h[0:], magicNanoseconds)
+	binary.LittleEndian.PutUint16(h[4:], versionMajor)
+	binary.LittleEndian.PutUint16(h[6:], versionMinor)
+	// time zone and significant figures are always 0
+	binary.LittleEndian.PutUint32(h[16:], snapLen)
+	binary.LittleEndian.PutUint32(h[20:], linkTypeRaw)
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, Written: fileHeaderSize}, nil
+}
+
+// WritePacket writes the packet record
+func (w *Writer) WritePacket(p *Packet) error {
+	datagram, err := ipDatagram(p)
+	if err != nil {
+		return err
+	}
+	h := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint32(h[0:], uint32(p.Time.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(p.Time.Nanosecond()))
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(datagram)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(len(datagram)))
+	if _, err := w.w.Write(append(h, datagram...)); err != nil {
+		return err
+	}
+	w.Written += int64(len(h) + len(datagram))
+	return nil
+}
+
+// recordSize returns how many bytes the packet record takes
+func recordSize(p *Packet) int64 {
+	ipHeaderSize := ipv6HeaderSize
+	if p.Src != nil && p.Dst != nil && p.Src.IP.To4() != nil && p.Dst.IP.To4() != nil {
+		ipHeaderSize = ipv4HeaderSize
+	}
+	return int64(recordHeaderSize + ipHeaderSize + udpHeaderSize + len(p.Payload))
+}
+
+// ipDatagram wraps the payload into UDP and IPv4 or IPv6 header, depending on the addresses
+func ipDatagram(p *Packet) ([]byte, error) {
+	if p.Src == nil || p.Dst == nil {
+		return nil, errors.New("packet without addresses")
+	}
+	udpLen := udpHeaderSize + len(p.Payload)
+	udp := make([]byte, udpHeaderSize, udpLen)
+	binary.BigEndian.PutUint16(udp[0:], uint16(p.Src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(p.Dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	udp = append(udp, p.Payload...)
+
+	src4, dst4 := p.Src.IP.To4(), p.Dst.IP.To4()
+	if src4 != nil && dst4 != nil {
+		ip := make([]byte, ipv4HeaderSize)
+		ip[0] = 0x45 // version 4, 5 words of header
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderSize+udpLen))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64   // TTL
+		ip[9] = protocolUDP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(src4, dst4, udp))
+		return append(ip, udp...), nil
+	}
+	src6, dst6 := p.Src.IP.To16(), p.Dst.IP.To16()
+	if src6 == nil || dst6 == nil {
+		return nil, fmt.Errorf("invalid addresses %v and %v", p.Src.IP, p.Dst.IP)
+	}
+	ip := make([]byte, ipv6HeaderSize)
+	ip[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+	ip[6] = protocolUDP
+	ip[7] = 64 // hop limit
+	copy(ip[8:], src6)
+	copy(ip[24:], dst6)
+	binary.BigEndian.PutUint16(udp[6:], udpChecksum(src6, dst6, udp))
+	return append(ip, udp...), nil
+}
+
+// checksum returns ones' complement of ones' complement sum of b added to sum
+func checksum(sum uint32, b []byte) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// udpChecksum returns checksum of UDP datagram with the pseudo header. Zero means no checksum, so it's sent as all ones
+func udpChecksum(src, dst net.IP, udp []byte) uint16 {
+	var sum uint32
+	for _, ip := range []net.IP{src, dst} {
+		for i := 0; i < len(ip); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(ip[i:]))
+		}
+	}
+	sum += protocolUDP + uint32(len(udp))
+	c := checksum(sum, udp)
+	if c == 0 {
+		return 0xffff
+	}
+	return c
+}
+
+// Ring writes packets to a ring of pcap files: Path.0, Path.1 and so on up to Files, each up to FileSize bytes.
+// When the last file is full the first one is overwritten. It is safe for concurrent use, but writes synchronously,
+// so it is better wrapped into AsyncSink
+type Ring struct {
+	Path     string
+	FileSize int64
+	Files    int
+
+	mu     sync.Mutex
+	index  int
+	file   *os.File
+	w      *Writer
+	errors int64
+}
+
+// NewRing returns ring of files, opening the first one
+func NewRing(path string, fileSize int64, files int) (*Ring, error) {
+	if files < 1 || fileSize < fileHeaderSize {
+		return nil, fmt.Errorf("invalid capture ring of %d files of %d bytes", files, fileSize)
+	}
+	r := &Ring{Path: path, FileSize: fileSize, Files: files}
+	if err := r.open(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// name returns the name of the file with the index
+func (r *Ring) name(index int) string {
+	return fmt.Sprintf("%s.%d", r.Path, index)
+}
+
+func (r *Ring) open(index int) error {
+	f, err := os.OpenFile(r.name(index), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.index, r.file, r.w = index, f, w
+	return nil
+}
+
+// Capture writes the packet, moving to the next file if it doesn't fit into the current one. Errors are counted
+func (r *Ring) Capture(p *Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.capture(p); err != nil {
+		r.errors++
+	}
+}
+
+func (r *Ring) capture(p *Packet) error {
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	size := recordSize(p)
+	if r.w.Written > fileHeaderSize && r.w.Written+size > r.FileSize {
+		if err := r.file.Close(); err != nil {
+			return err
+		}
+		r.file = nil
+		if err := r.open((r.index + 1) % r.Files); err != nil {
+			return err
+		}
+	}
+	return r.w.WritePacket(p)
+}
+
+// Errors returns how many packets failed to be written
+func (r *Ring) Errors() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errors
+}
+
+// Close closes the current file
+func (r *Ring) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// AsyncSink passes packets to another sink from a separate goroutine.
+// Packets which don't fit into the queue are dropped, so bursts can't slow down the caller
+type AsyncSink struct {
+	sink    Sink
+	queue   chan *Packet
+	dropped int64
+}
+
+// NewAsyncSink returns sink queueing up to size packets for the other sink
+func NewAsyncSink(sink Sink, size int) *AsyncSink {
+	s := &AsyncSink{sink: sink, queue: make(chan *Packet, size)}
+	go func() {
+		for p := range s.queue {
+			s.sink.Capture(p)
+		}
+	}()
+	return s
+}
+
+// Capture queues copy of the packet, as callers reuse buffers
+func (s *AsyncSink) Capture(p *Packet) {
+	c := *p
+	c.Payload = append([]byte(nil), p.Payload...)
+	select {
+	case s.queue <- &c:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns how many packets didn't fit into the queue
+func (s *AsyncSink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
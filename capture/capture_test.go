@@ -0,0 +1,122 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPacket(src, dst string, payload []byte) *Packet {
+	return &Packet{
+		Time:    time.Unix(1600000000, 123456789),
+		Src:     &net.UDPAddr{IP: net.ParseIP(src), Port: 40000},
+		Dst:     &net.UDPAddr{IP: net.ParseIP(dst), Port: 123},
+		Payload: payload,
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b)
+	require.Nil(t, err)
+	require.Nil(t, w.WritePacket(testPacket("10.0.0.1", "10.0.0.2", make([]byte, 48))))
+
+	out := b.Bytes()
+	require.Len(t, out, fileHeaderSize+recordHeaderSize+ipv4HeaderSize+udpHeaderSize+48)
+	assert.Equal(t, int64(len(out)), w.Written)
+	assert.Equal(t, uint32(magicNanoseconds), binary.LittleEndian.Uint32(out))
+	assert.Equal(t, uint32(linkTypeRaw), binary.LittleEndian.Uint32(out[20:]))
+
+	record := out[fileHeaderSize:]
+	assert.Equal(t, uint32(1600000000), binary.LittleEndian.Uint32(record))
+	assert.Equal(t, uint32(123456789), binary.LittleEndian.Uint32(record[4:]))
+	assert.Equal(t, uint32(ipv4HeaderSize+udpHeaderSize+48), binary.LittleEndian.Uint32(record[8:]))
+
+	ip := record[recordHeaderSize:]
+	assert.Equal(t, byte(0x45), ip[0])
+	assert.Equal(t, uint16(0), checksum(0, ip[:ipv4HeaderSize]), "header with checksum sums to all ones")
+	assert.Equal(t, net.ParseIP("10.0.0.2").To4(), net.IP(ip[16:20]))
+	udp := ip[ipv4HeaderSize:]
+	assert.Equal(t, uint16(40000), binary.BigEndian.Uint16(udp))
+	assert.Equal(t, uint16(123), binary.BigEndian.Uint16(udp[2:]))
+}
+
+func TestWriterIPv6(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b)
+	require.Nil(t, err)
+	require.Nil(t, w.WritePacket(testPacket("2001:db8::1", "2001:db8::2", make([]byte, 48))))
+	ip := b.Bytes()[fileHeaderSize+recordHeaderSize:]
+	require.Len(t, ip, ipv6HeaderSize+udpHeaderSize+48)
+	assert.Equal(t, byte(0x60), ip[0])
+	assert.Equal(t, byte(protocolUDP), ip[6])
+	assert.Equal(t, net.ParseIP("2001:db8::2"), net.IP(ip[24:40]))
+
+	assert.NotNil(t, w.WritePacket(&Packet{Payload: []byte{1}}))
+}
+
+func TestRing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ntp.pcap")
+	packetSize := int64(recordHeaderSize + ipv4HeaderSize + udpHeaderSize + 48)
+	r, err := NewRing(path, fileHeaderSize+2*packetSize, 2)
+	require.Nil(t, err)
+	defer r.Close()
+	for i := 0; i < 5; i++ {
+		r.Capture(testPacket("10.0.0.1", "10.0.0.2", make([]byte, 48)))
+	}
+	assert.Equal(t, int64(0), r.Errors())
+
+	// 2 packets went to the first file, 2 to the second one, and the fifth one overwrote the first file
+	for name, packets := range map[string]int64{path + ".0": 1, path + ".1": 2} {
+		st, err := os.Stat(name)
+		require.Nil(t, err)
+		assert.Equal(t, fileHeaderSize+packets*packetSize, st.Size(), name)
+	}
+
+	_, err = NewRing(path, 10, 2)
+	assert.NotNil(t, err)
+}
+
+type testSink struct {
+	packets chan *Packet
+}
+
+func (s *testSink) Capture(p *Packet) {
+	s.packets <- p
+}
+
+func TestAsyncSink(t *testing.T) {
+	sink := &testSink{packets: make(chan *Packet)}
+	s := NewAsyncSink(sink, 1)
+	payload := []byte{1, 2, 3}
+	s.Capture(testPacket("10.0.0.1", "10.0.0.2", payload))
+	payload[0] = 0
+	p := <-sink.packets
+	assert.Equal(t, []byte{1, 2, 3}, p.Payload, "payload is copied")
+
+	var nilSink Sink
+	Capture(nilSink, p)
+}
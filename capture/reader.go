@@ -0,0 +1,217 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Link types read besides raw IP
+const (
+	linkTypeEthernet = 1
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+)
+
+// magicMicroseconds is magic of pcap files with microsecond timestamps, written by tcpdump by default
+const magicMicroseconds = 0xa1b2c3d4
+
+// Ethernet types of IP
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+)
+
+// ErrNotUDP is returned for packets which are not UDP over IP, the caller may skip them
+var ErrNotUDP = errors.New("not a UDP datagram")
+
+// Reader reads UDP packets from pcap file, be it written by Writer or tcpdump. It is not safe for concurrent use
+type Reader struct {
+	r         io.Reader
+	order     binary.ByteOrder
+	nanos     bool
+	linkType  uint32
+	recordBuf []byte
+}
+
+// NewReader reads pcap file header from r and returns reader of the packets following it
+func NewReader(r io.Reader) (*Reader, error) {
+	h := make([]byte, fileHeaderSize)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+	reader := &Reader{r: r, recordBuf: make([]byte, recordHeaderSize)}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(h) {
+		case magicNanoseconds:
+			reader.order, reader.nanos = order, true
+		case magicMicroseconds:
+			reader.order = order
+		}
+	}
+	if reader.order == nil {
+		return nil, fmt.Errorf("not a pcap file, magic %#x", binary.LittleEndian.Uint32(h))
+	}
+	reader.linkType = reader.order.Uint32(h[20:])
+	switch reader.linkType {
+	case linkTypeRaw, linkTypeEthernet, linkTypeLinuxSLL, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", reader.linkType)
+	}
+	return reader, nil
+}
+
+// Next returns the next packet. Records which are not UDP over IP are returned as ErrNotUDP, io.EOF marks the end of file
+func (r *Reader) Next() (*Packet, error) {
+	if _, err := io.ReadFull(r.r, r.recordBuf); err != nil {
+		return nil, err
+	}
+	sec := r.order.Uint32(r.recordBuf)
+	frac := r.order.Uint32(r.recordBuf[4:])
+	length := r.order.Uint32(r.recordBuf[8:])
+	if length > snapLen {
+		return nil, fmt.Errorf("record of %d bytes is too long", length)
+	}
+	if !r.nanos {
+		frac *= 1000
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, err
+	}
+	ip, err := r.stripLink(data)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseDatagram(ip)
+	if err != nil {
+		return nil, err
+	}
+	p.Time = time.Unix(int64(sec), int64(frac))
+	return p, nil
+}
+
+// stripLink returns IP datagram of the frame
+func (r *Reader) stripLink(frame []byte) ([]byte, error) {
+	var etherType uint16
+	switch r.linkType {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return nil, ErrNotUDP
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		if etherType == etherTypeVLAN && len(frame) >= 4 {
+			etherType, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil, ErrNotUDP
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	default:
+		return frame, nil
+	}
+	if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+		return nil, ErrNotUDP
+	}
+	return frame, nil
+}
+
+// parseDatagram returns UDP packet of IPv4 or IPv6 datagram. Extension headers of IPv6 and fragments are not supported
+func parseDatagram(b []byte) (*Packet, error) {
+	if len(b) < 1 {
+		return nil, ErrNotUDP
+	}
+	var src, dst net.IP
+	var udp []byte
+	switch b[0] >> 4 {
+	case 4:
+		headerSize := int(b[0]&0x0f) * 4
+		if len(b) < ipv4HeaderSize || headerSize < ipv4HeaderSize || len(b) < headerSize || b[9] != protocolUDP {
+			return nil, ErrNotUDP
+		}
+		// fragments other than the first one have offset, the first one has more fragments flag
+		if binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+			return nil, ErrNotUDP
+		}
+		src, dst = net.IP(b[12:16]), net.IP(b[16:20])
+		total := int(binary.BigEndian.Uint16(b[2:]))
+		if total > len(b) || total < headerSize {
+			total = len(b)
+		}
+		udp = b[headerSize:total]
+	case 6:
+		if len(b) < ipv6HeaderSize || b[6] != protocolUDP {
+			return nil, ErrNotUDP
+		}
+		src, dst = net.IP(b[8:24]), net.IP(b[24:40])
+		udp = b[ipv6HeaderSize:]
+	default:
+		return nil, ErrNotUDP
+	}
+	if len(udp) < udpHeaderSize {
+		return nil, ErrNotUDP
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < udpHeaderSize || length > len(udp) {
+		length = len(udp)
+	}
+	return &Packet{
+		Src:     &net.UDPAddr{IP: src, Port: int(binary.BigEndian.Uint16(udp))},
+		Dst:     &net.UDPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(udp[2:]))},
+		Payload: udp[udpHeaderSize:length],
+	}, nil
+}
+
+// Replay reads packets and passes them to fn, pacing them the way they were captured.
+// Speed scales the pacing: 1 is original timing, 10 is ten times faster, 0 is as fast as possible.
+// Records which are not UDP are skipped, fn error stops the replay
+func Replay(r *Reader, speed float64, fn func(p *Packet) error) error {
+	var first time.Time
+	start := time.Now()
+	for {
+		p, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, ErrNotUDP) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if first.IsZero() {
+			first = p.Time
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(p.Time.Sub(first)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b)
+	require.Nil(t, err)
+	sent := []*Packet{
+		testPacket("10.0.0.1", "10.0.0.2", []byte("request")),
+		testPacket("2001:db8::2", "2001:db8::1", []byte("response")),
+	}
+	for _, p := range sent {
+		require.Nil(t, w.WritePacket(p))
+	}
+
+	r, err := NewReader(&b)
+	require.Nil(t, err)
+	for _, want := range sent {
+		got, err := r.Next()
+		require.Nil(t, err)
+		assert.True(t, want.Time.Equal(got.Time))
+		assert.Equal(t, want.Src.String(), got.Src.String())
+		assert.Equal(t, want.Dst.String(), got.Dst.String())
+		assert.Equal(t, want.Payload, got.Payload)
+	}
+	_, err = r.Next()
+	assert.True(t, errors.Is(err, io.EOF))
+}
+
+// tcpdumpFile returns big endian pcap file with microsecond timestamps and Ethernet frames, as tcpdump on SPARC writes
+func tcpdumpFile(t *testing.T, frames ...[]byte) []byte {
+	var b bytes.Buffer
+	h := make([]byte, fileHeaderSize)
+	binary.BigEndian.PutUint32(h, magicMicroseconds)
+	binary.BigEndian.PutUint32(h[16:], snapLen)
+	binary.BigEndian.PutUint32(h[20:], linkTypeEthernet)
+	b.Write(h)
+	for i, frame := range frames {
+		r := make([]byte, recordHeaderSize)
+		binary.BigEndian.PutUint32(r, 1600000000)
+		binary.BigEndian.PutUint32(r[4:], uint32(i))
+		binary.BigEndian.PutUint32(r[8:], uint32(len(frame)))
+		binary.BigEndian.PutUint32(r[12:], uint32(len(frame)))
+		b.Write(r)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+func ethernetFrame(t *testing.T, etherType uint16, p *Packet) []byte {
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	datagram, err := ipDatagram(p)
+	require.Nil(t, err)
+	return append(frame, datagram...)
+}
+
+func TestReaderEthernet(t *testing.T) {
+	p := testPacket("10.0.0.1", "10.0.0.2", []byte("request"))
+	file := tcpdumpFile(t, ethernetFrame(t, 0x0806, p), ethernetFrame(t, etherTypeIPv4, p))
+	r, err := NewReader(bytes.NewReader(file))
+	require.Nil(t, err)
+	_, err = r.Next()
+	assert.ErrorIs(t, err, ErrNotUDP, "ARP is not UDP")
+	got, err := r.Next()
+	require.Nil(t, err)
+	assert.Equal(t, time.Unix(1600000000, 1000), got.Time)
+	assert.Equal(t, []byte("request"), got.Payload)
+
+	_, err = NewReader(bytes.NewReader(make([]byte, fileHeaderSize)))
+	assert.NotNil(t, err)
+}
+
+func TestReplay(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewWriter(&b)
+	require.Nil(t, err)
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 3; i++ {
+		p := testPacket("10.0.0.1", "10.0.0.2", []byte{byte(i)})
+		p.Time = start.Add(time.Duration(i) * 50 * time.Millisecond)
+		require.Nil(t, w.WritePacket(p))
+	}
+
+	replay := func(speed float64) ([]byte, time.Duration) {
+		r, err := NewReader(bytes.NewReader(b.Bytes()))
+		require.Nil(t, err)
+		var got []byte
+		began := time.Now()
+		require.Nil(t, Replay(r, speed, func(p *Packet) error {
+			got = append(got, p.Payload...)
+			return nil
+		}))
+		return got, time.Since(began)
+	}
+	got, took := replay(1)
+	assert.Equal(t, []byte{0, 1, 2}, got)
+	assert.GreaterOrEqual(t, int64(took), int64(100*time.Millisecond), "original timing is kept")
+	got, took = replay(0)
+	assert.Equal(t, []byte{0, 1, 2}, got)
+	assert.Less(t, int64(took), int64(100*time.Millisecond))
+}
@@ -0,0 +1,265 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package beevik is a shim for applications using github.com/beevik/ntp: it has the same Query functions and Response,
+// so such applications switch to this client, kernel timestamps and all, by changing the import path.
+// Responses of this client are converted to and from beevik Response shape for code mixing both
+package beevik
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// maxStratum is stratum of unsynchronized servers
+const maxStratum = 16
+
+// maxRootDistance is how far from the reference clock a server may be to be trusted, as in beevik/ntp
+const maxRootDistance = 1500 * time.Millisecond
+
+// defaultPort is used when address has no port
+const defaultPort = 123
+
+// LeapIndicator warns of impending leap second
+type LeapIndicator uint8
+
+// Leap indicators
+const (
+	LeapNoWarning LeapIndicator = 0
+	LeapAddSecond LeapIndicator = 1
+	LeapDelSecond LeapIndicator = 2
+	LeapNotInSync LeapIndicator = 3
+)
+
+// Response is server response in the shape of beevik/ntp Response
+type Response struct {
+	// ClockOffset is how far the local clock is behind the server one
+	ClockOffset time.Duration
+	// Time is server transmit time
+	Time time.Time
+	// RTT is round trip time less server processing time
+	RTT            time.Duration
+	Precision      time.Duration
+	Version        int
+	Stratum        uint8
+	ReferenceID    uint32
+	ReferenceTime  time.Time
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	// RootDistance is the error bound of the server time: half of the delay to the reference clock plus dispersion
+	RootDistance time.Duration
+	Leap         LeapIndicator
+	// MinError is the lower bound of the local clock error the timestamps prove, regardless of network delays
+	MinError time.Duration
+	// KissCode is set for kiss-o'-death responses
+	KissCode string
+	Poll     time.Duration
+}
+
+// QueryOptions of a query. Options of beevik/ntp not listed here are not supported
+type QueryOptions struct {
+	// Timeout is client.DefaultTimeout if not set
+	Timeout time.Duration
+	// Port is used if address has none, 123 if not set
+	Port int
+}
+
+// shortDuration converts NTP short format: 16 bits of seconds and 16 bits of fraction
+func shortDuration(v uint32) time.Duration {
+	return time.Duration(uint64(v) * uint64(time.Second) >> 16)
+}
+
+// durationShort converts duration to NTP short format
+func durationShort(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	return uint32(uint64(d) << 16 / uint64(time.Second))
+}
+
+// log2Duration converts power of two seconds
+func log2Duration(exp int8) time.Duration {
+	return time.Duration(math.Ldexp(float64(time.Second), int(exp)))
+}
+
+// durationLog2 converts duration to power of two seconds, rounded
+func durationLog2(d time.Duration) int8 {
+	if d <= 0 {
+		return 0
+	}
+	return int8(math.Round(math.Log2(d.Seconds())))
+}
+
+// FromResponse converts response of this client
+func FromResponse(r *client.Response) *Response {
+	p := r.Packet
+	b := &Response{
+		ClockOffset:    r.Offset(),
+		Time:           r.ServerTransmitTime,
+		RTT:            r.ClientReceiveTime.Sub(r.ClientTransmitTime) - r.ServerTransmitTime.Sub(r.ServerReceiveTime),
+		Precision:      log2Duration(p.Precision),
+		Version:        int(p.Settings>>3) & 0x7,
+		Stratum:        p.Stratum,
+		ReferenceID:    p.ReferenceID,
+		ReferenceTime:  ntp.Unix(p.RefTimeSec, p.RefTimeFrac),
+		RootDelay:      shortDuration(p.RootDelay),
+		RootDispersion: shortDuration(p.RootDispersion),
+		Leap:           LeapIndicator(p.Settings >> 6),
+		Poll:           log2Duration(p.Poll),
+	}
+	if b.RTT < 0 {
+		b.RTT = 0
+	}
+	b.RootDistance = (b.RTT+b.RootDelay)/2 + b.RootDispersion
+	// local clock is provably off if the server received the request before it was sent or sent the response after it was received
+	b.MinError = r.ClientTransmitTime.Sub(r.ServerReceiveTime)
+	if e := r.ServerTransmitTime.Sub(r.ClientReceiveTime); e > b.MinError {
+		b.MinError = e
+	}
+	if b.MinError < 0 {
+		b.MinError = 0
+	}
+	if p.Stratum == 0 {
+		b.KissCode = kissCode(p.ReferenceID)
+	}
+	return b
+}
+
+// ToResponse converts the response to response of this client. Server processing time is unknown,
+// so server receive time is taken to be transmit time and client times are derived from offset and round trip time
+func ToResponse(b *Response) *client.Response {
+	refSec, refFrac := ntp.Time(b.ReferenceTime)
+	txSec, txFrac := ntp.Time(b.Time)
+	return &client.Response{
+		Packet: &ntp.Packet{
+			Settings:       uint8(b.Leap)<<6 | uint8(b.Version&0x7)<<3 | 4,
+			Stratum:        b.Stratum,
+			Poll:           durationLog2(b.Poll),
+			Precision:      durationLog2(b.Precision),
+			RootDelay:      durationShort(b.RootDelay),
+			RootDispersion: durationShort(b.RootDispersion),
+			ReferenceID:    b.ReferenceID,
+			RefTimeSec:     refSec,
+			RefTimeFrac:    refFrac,
+			RxTimeSec:      txSec,
+			RxTimeFrac:     txFrac,
+			TxTimeSec:      txSec,
+			TxTimeFrac:     txFrac,
+		},
+		ClientTransmitTime: b.Time.Add(-b.ClockOffset - b.RTT/2),
+		ServerReceiveTime:  b.Time,
+		ServerTransmitTime: b.Time,
+		ClientReceiveTime:  b.Time.Add(-b.ClockOffset + b.RTT/2),
+	}
+}
+
+// kissCode returns ASCII code of kiss-o'-death reference ID
+func kissCode(refID uint32) string {
+	b := make([]byte, 0, 4)
+	for shift := 24; shift >= 0; shift -= 8 {
+		c := byte(refID >> uint(shift))
+		if c == 0 {
+			break
+		}
+		if c < 32 || c > 126 {
+			return ""
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// IsKissOfDeath tells if the server asked the client to go away
+func (r *Response) IsKissOfDeath() bool {
+	return r.Stratum == 0
+}
+
+// ReferenceString returns reference ID the way ntpq shows it: kiss code, reference clock name, IPv4 address
+// or hex of IPv6 address hash
+func (r *Response) ReferenceString() string {
+	switch {
+	case r.Stratum <= 1:
+		return kissCode(r.ReferenceID)
+	case r.Stratum < maxStratum:
+		return net.IPv4(byte(r.ReferenceID>>24), byte(r.ReferenceID>>16), byte(r.ReferenceID>>8), byte(r.ReferenceID)).String()
+	}
+	return fmt.Sprintf("0x%08x", r.ReferenceID)
+}
+
+// Validate checks the response can be used to set the clock
+func (r *Response) Validate() error {
+	if r.IsKissOfDeath() {
+		return fmt.Errorf("kiss of death received: %s", r.KissCode)
+	}
+	if r.Stratum >= maxStratum {
+		return errors.New("invalid stratum in response")
+	}
+	if r.Leap == LeapNotInSync {
+		return errors.New("invalid leap second")
+	}
+	if r.ReferenceTime.After(r.Time) {
+		return errors.New("server clock ticked backwards")
+	}
+	if r.RootDistance > maxRootDistance {
+		return fmt.Errorf("root distance %v too large", r.RootDistance)
+	}
+	return nil
+}
+
+// withPort returns host:port, adding the port if address has none
+func withPort(address string, port int) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	if port == 0 {
+		port = defaultPort
+	}
+	return net.JoinHostPort(address, strconv.Itoa(port))
+}
+
+// Query queries the server for time with default options
+func Query(address string) (*Response, error) {
+	return QueryWithOptions(address, QueryOptions{})
+}
+
+// QueryWithOptions queries the server for time
+func QueryWithOptions(address string, opt QueryOptions) (*Response, error) {
+	a := &client.Association{Addr: withPort(address, opt.Port), Timeout: opt.Timeout}
+	r, err := a.Query()
+	if err != nil {
+		return nil, err
+	}
+	return FromResponse(r), nil
+}
+
+// Time returns the current time according to the server
+func Time(address string) (time.Time, error) {
+	r, err := Query(address)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(r.ClockOffset), nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package beevik
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResponse() *client.Response {
+	t1 := time.Unix(1600000000, 0)
+	refSec, refFrac := ntp.Time(t1.Add(-time.Minute))
+	return &client.Response{
+		Packet: &ntp.Packet{
+			Settings:       0x24,
+			Stratum:        2,
+			Poll:           6,
+			Precision:      -20,
+			RootDelay:      1 << 15,
+			RootDispersion: 1 << 14,
+			ReferenceID:    0x0a000001,
+			RefTimeSec:     refSec,
+			RefTimeFrac:    refFrac,
+		},
+		// server is 1 second ahead, network takes 10ms each way and the server 1ms to respond
+		ClientTransmitTime: t1,
+		ServerReceiveTime:  t1.Add(time.Second + 10*time.Millisecond),
+		ServerTransmitTime: t1.Add(time.Second + 11*time.Millisecond),
+		ClientReceiveTime:  t1.Add(21 * time.Millisecond),
+	}
+}
+
+func TestFromResponse(t *testing.T) {
+	b := FromResponse(testResponse())
+	assert.Equal(t, time.Second, b.ClockOffset)
+	assert.Equal(t, 20*time.Millisecond, b.RTT)
+	assert.Equal(t, 4, b.Version)
+	assert.Equal(t, uint8(2), b.Stratum)
+	assert.Equal(t, 64*time.Second, b.Poll)
+	assert.Equal(t, time.Duration(953), b.Precision)
+	assert.Equal(t, 500*time.Millisecond, b.RootDelay)
+	assert.Equal(t, 250*time.Millisecond, b.RootDispersion)
+	assert.Equal(t, 510*time.Millisecond, b.RootDistance)
+	assert.Equal(t, 990*time.Millisecond, b.MinError)
+	assert.Equal(t, LeapNoWarning, b.Leap)
+	assert.Equal(t, "10.0.0.1", b.ReferenceString())
+	assert.False(t, b.IsKissOfDeath())
+	assert.Nil(t, b.Validate())
+}
+
+func TestToResponse(t *testing.T) {
+	want := FromResponse(testResponse())
+	r := ToResponse(want)
+	assert.Equal(t, want.ClockOffset, r.Offset())
+	got := FromResponse(r)
+	assert.Equal(t, want.RTT, got.RTT)
+	assert.Equal(t, want.Stratum, got.Stratum)
+	assert.Equal(t, want.Poll, got.Poll)
+	assert.Equal(t, want.Precision, got.Precision)
+	assert.Equal(t, want.RootDelay, got.RootDelay)
+	assert.Equal(t, want.RootDispersion, got.RootDispersion)
+	assert.True(t, want.ReferenceTime.Sub(got.ReferenceTime).Abs() < time.Microsecond)
+}
+
+func TestValidate(t *testing.T) {
+	r := testResponse()
+	r.Packet.Stratum = 0
+	r.Packet.ReferenceID = 0x52415445 // RATE
+	b := FromResponse(r)
+	assert.True(t, b.IsKissOfDeath())
+	assert.Equal(t, "RATE", b.KissCode)
+	assert.EqualError(t, b.Validate(), "kiss of death received: RATE")
+
+	r = testResponse()
+	r.Packet.Settings = 0xe4
+	assert.NotNil(t, FromResponse(r).Validate(), "unsynchronized server")
+
+	r = testResponse()
+	r.Packet.RootDispersion = 2 << 16
+	assert.NotNil(t, FromResponse(r).Validate(), "root distance too large")
+}
+
+func TestQuery(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+	go func() {
+		request, addr, err := ntp.ReadNTPPacket(conn)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		sec, frac := ntp.Time(now)
+		refSec, refFrac := ntp.Time(now.Add(-time.Second))
+		response := &ntp.Packet{
+			Settings:     0x24,
+			Stratum:      1,
+			ReferenceID:  0x47505300, // GPS
+			RefTimeSec:   refSec,
+			RefTimeFrac:  refFrac,
+			OrigTimeSec:  request.TxTimeSec,
+			OrigTimeFrac: request.TxTimeFrac,
+			RxTimeSec:    sec,
+			RxTimeFrac:   frac,
+			TxTimeSec:    sec,
+			TxTimeFrac:   frac,
+		}
+		b, _ := response.Bytes()
+		_, _ = conn.WriteTo(b, addr)
+	}()
+	host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	require.Nil(t, err)
+	r, err := QueryWithOptions(host, QueryOptions{Timeout: time.Second, Port: mustAtoi(t, port)})
+	require.Nil(t, err)
+	assert.Equal(t, "GPS", r.ReferenceString())
+	assert.Nil(t, r.Validate())
+	assert.Less(t, int64(r.ClockOffset.Abs()), int64(time.Second))
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	var n int
+	_, err := fmt.Sscan(s, &n)
+	require.Nil(t, err)
+	return n
+}
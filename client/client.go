@@ -0,0 +1,456 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client implements NTP client querying a server
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/logging"
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/protocol/nts"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	syscall "golang.org/x/sys/unix"
+)
+
+// DefaultTimeout is used when Association has no timeout set
+const DefaultTimeout = 5 * time.Second
+
+// requestSettings is LI 0, VN 4, Mode 3 (client)
+const requestSettings = 0x23
+
+// modeServer is the mode of server responses
+const modeServer = 4
+
+// ErrTimeout is returned when server didn't reply in time
+var ErrTimeout = errors.New("timeout waiting for reply")
+
+// ErrUnreachable is returned when ICMP reports the server unreachable, there is no point waiting for the reply
+var ErrUnreachable = errors.New("server unreachable")
+
+// Response is the server reply along with the four timestamps of the exchange
+type Response struct {
+	Packet             *ntp.Packet
+	ClientTransmitTime time.Time
+	ServerReceiveTime  time.Time
+	ServerTransmitTime time.Time
+	ClientReceiveTime  time.Time
+}
+
+// AvgNetworkDelay returns one way network delay in nanoseconds
+func (r *Response) AvgNetworkDelay() int64 {
+	return ntp.AvgNetworkDelay(r.ClientTransmitTime, r.ServerReceiveTime, r.ServerTransmitTime, r.ClientReceiveTime)
+}
+
+// Offset returns offset of the local clock from the server one
+func (r *Response) Offset() time.Duration {
+	return (r.ServerReceiveTime.Sub(r.ClientTransmitTime) + r.ServerTransmitTime.Sub(r.ClientReceiveTime)) / 2
+}
+
+// Association is the client side of the relationship with a single server
+type Association struct {
+	// Addr is server host:port
+	Addr string
+	// Timeout of a single query, DefaultTimeout if not set
+	Timeout time.Duration
+	// NTS authenticates requests and responses with Network Time Security if set
+	NTS *nts.Client
+	// Key authenticates requests and responses with symmetric key MAC if set
+	Key *auth.Key
+	// UniqueID adds Unique Identifier extension field to requests and only accepts responses echoing it.
+	// NTS always does so
+	UniqueID bool
+	// Interleaved requests interleaved mode, in which the server returns more accurate transmit timestamp
+	// of its previous response. Servers not supporting it reply in basic mode
+	Interleaved bool
+	// Audit receives security events: failed verification, NAKs and responses not matching the request. May be nil
+	Audit audit.Sink
+	// Capture receives requests sent and packets received, the latter with kernel timestamps. May be nil
+	Capture capture.Sink
+	// Tracer records spans of queries: NTS-KE, resolution of the address, sending the request,
+	// receiving, filtering and validating responses. May be nil
+	Tracer trace.Tracer
+	// Logger receives failed queries and responses filtered out, slog.Default() if not set
+	Logger logging.Logger
+	// JitterWindow is how far back PeerStats.WindowJitter looks, it is not computed if not set
+	JitterWindow time.Duration
+	// Connected keeps the socket connected to the server between queries instead of dialing for each of them.
+	// The route is looked up once, and ICMP errors caused by one query fail the next with ErrUnreachable right away.
+	// Close releases the socket
+	Connected bool
+
+	// mu serializes queries, as each of them relies on the state left by the previous one
+	mu   sync.Mutex
+	last *exchange
+	// conn is the socket kept by Connected association, along with what it was set up for
+	conn        *net.UDPConn
+	connAddr    string
+	connTimeout time.Duration
+	// peer keeps statistics of queries
+	peer peerState
+}
+
+// timestamp is NTP timestamp as it is on the wire
+type timestamp struct {
+	sec  uint32
+	frac uint32
+}
+
+func newTimestamp(t time.Time) timestamp {
+	sec, frac := ntp.Time(t)
+	return timestamp{sec: sec, frac: frac}
+}
+
+// exchange is the last accepted request and response of the association
+type exchange struct {
+	clientTransmit time.Time
+	serverReceive  timestamp
+	serverTransmit timestamp
+	clientReceive  timestamp
+	clientRxTime   time.Time
+}
+
+// Query sends single request to the server and waits for the response. The outcome is accounted in Stats
+func (a *Association) Query() (*Response, error) {
+	return a.QueryContext(context.Background())
+}
+
+// QueryContext is Query with spans of the query being children of the span in ctx
+func (a *Association) QueryContext(ctx context.Context) (*Response, error) {
+	ctx, span := a.startSpan(ctx, spanQuery, attribute.String("ntp.server", a.Addr))
+	r, err := a.query(ctx)
+	if r != nil {
+		span.SetAttributes(responseAttributes(r)...)
+	}
+	endSpan(span, err)
+	if err != nil {
+		logging.Or(a.Logger).Debug("Query failed", "server", a.Addr, "error", err)
+	}
+	a.peer.record(time.Now(), r, err)
+	return r, err
+}
+
+func (a *Association) query(ctx context.Context) (*Response, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	addr := a.Addr
+	if a.NTS != nil {
+		// NTS-KE may point at another NTP server, cookies are only good there
+		_, span := a.startSpan(ctx, spanNTSKE)
+		var err error
+		if addr, err = a.NTS.Addr(a.Addr); err != nil {
+			err = fmt.Errorf("failed to establish NTS session: %w", err)
+			endSpan(span, err)
+			return nil, err
+		}
+		span.SetAttributes(attribute.String("ntp.server", addr))
+		endSpan(span, nil)
+	}
+	conn, err := a.socket(ctx, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Connected {
+		defer conn.Close()
+	}
+
+	clientTransmitTime := time.Now()
+	sec, frac := ntp.Time(clientTransmitTime)
+	request := &ntp.Packet{
+		Settings:   requestSettings,
+		TxTimeSec:  sec,
+		TxTimeFrac: frac,
+	}
+	// Interleaved request points at the previous exchange: its server receive and client receive timestamps
+	interleaved := a.Interleaved && a.last != nil
+	if interleaved {
+		request.OrigTimeSec, request.OrigTimeFrac = a.last.serverReceive.sec, a.last.serverReceive.frac
+		request.RxTimeSec, request.RxTimeFrac = a.last.clientReceive.sec, a.last.clientReceive.frac
+	}
+	b, err := request.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var uid []byte
+	if a.NTS != nil {
+		if b, uid, err = a.NTS.NewRequest(b); err != nil {
+			return nil, fmt.Errorf("failed to create NTS request: %w", err)
+		}
+	} else {
+		if a.UniqueID {
+			uidField, err := ntp.NewUniqueIdentifier()
+			if err != nil {
+				return nil, err
+			}
+			uid = uidField.Value
+			b = append(b, uidField.Bytes()...)
+		}
+		if a.Key != nil {
+			b = a.Key.Sign(b)
+		}
+	}
+	_, span := a.startSpan(ctx, spanSend, attribute.Bool("ntp.interleaved", interleaved))
+	if _, err := conn.Write(b); err != nil {
+		a.closeConn()
+		err = fmt.Errorf("failed to send request: %w", unreachable(err))
+		endSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(timeAttribute("ntp.client_transmit", clientTransmitTime), attribute.Int("ntp.request_size", len(b)))
+	endSpan(span, nil)
+	a.capture(clientTransmitTime, conn.LocalAddr(), conn.RemoteAddr(), b)
+
+	deadline := clientTransmitTime.Add(timeout)
+	for time.Now().Before(deadline) {
+		_, span := a.startSpan(ctx, spanReceive)
+		p, err := ntp.ReadPacketWithTimestamps(conn)
+		if err != nil {
+			// SO_RCVTIMEO expired
+			if errors.Is(err, syscall.EAGAIN) {
+				endSpan(span, ErrTimeout)
+				break
+			}
+			a.closeConn()
+			err = unreachable(err)
+			endSpan(span, err)
+			return nil, err
+		}
+		span.SetAttributes(timeAttribute("ntp.client_receive", p.RxTime))
+		endSpan(span, nil)
+		if a.Capture != nil {
+			if header, err := p.Packet.Bytes(); err == nil {
+				a.capture(p.RxTime, conn.RemoteAddr(), conn.LocalAddr(), append(header, p.Extensions...))
+			}
+		}
+		// Response has to echo our transmit timestamp, anything else is stale or spoofed.
+		// Interleaved response echoes receive timestamp of the request instead
+		_, span = a.startSpan(ctx, spanFilter)
+		origin := timestamp{sec: p.Packet.OrigTimeSec, frac: p.Packet.OrigTimeFrac}
+		basicResponse := origin == timestamp{sec: sec, frac: frac}
+		interleavedResponse := interleaved && origin == a.last.clientReceive
+		if !basicResponse && !interleavedResponse {
+			audit.Emit(a.Audit, audit.Event{Kind: audit.Replay, Peer: a.Addr, Reason: "origin timestamp mismatch"})
+			logging.Or(a.Logger).Debug("Ignoring response", "server", a.Addr, "reason", "origin timestamp mismatch")
+			span.SetAttributes(attribute.String("ntp.filtered", "origin timestamp mismatch"))
+			endSpan(span, nil)
+			continue
+		}
+		transmit := timestamp{sec: p.Packet.TxTimeSec, frac: p.Packet.TxTimeFrac}
+		// Basic response repeating transmit timestamp of the previous one is a duplicate
+		if basicResponse && a.last != nil && transmit == a.last.serverTransmit {
+			audit.Emit(a.Audit, audit.Event{Kind: audit.Replay, Peer: a.Addr, Reason: "duplicate response"})
+			logging.Or(a.Logger).Debug("Ignoring response", "server", a.Addr, "reason", "duplicate response")
+			span.SetAttributes(attribute.String("ntp.filtered", "duplicate response"))
+			endSpan(span, nil)
+			continue
+		}
+		span.SetAttributes(attribute.Bool("ntp.interleaved", interleavedResponse))
+		endSpan(span, nil)
+		_, span = a.startSpan(ctx, spanValidate)
+		if err := a.verify(p, uid); err != nil {
+			a.emitFailure(err)
+			endSpan(span, err)
+			return nil, err
+		}
+		endSpan(span, nil)
+		response := &Response{
+			Packet:             p.Packet,
+			ClientTransmitTime: clientTransmitTime,
+			ServerReceiveTime:  ntp.Unix(p.Packet.RxTimeSec, p.Packet.RxTimeFrac),
+			ServerTransmitTime: ntp.Unix(p.Packet.TxTimeSec, p.Packet.TxTimeFrac),
+			ClientReceiveTime:  p.RxTime,
+		}
+		if interleavedResponse {
+			// transmit timestamp belongs to the previous response, so does the rest of the exchange
+			response.ClientTransmitTime = a.last.clientTransmit
+			response.ServerReceiveTime = ntp.Unix(a.last.serverReceive.sec, a.last.serverReceive.frac)
+			response.ClientReceiveTime = a.last.clientRxTime
+		}
+		a.last = &exchange{
+			clientTransmit: clientTransmitTime,
+			serverReceive:  timestamp{sec: p.Packet.RxTimeSec, frac: p.Packet.RxTimeFrac},
+			serverTransmit: transmit,
+			clientReceive:  newTimestamp(p.RxTime),
+			clientRxTime:   p.RxTime,
+		}
+		return response, nil
+	}
+	return nil, fmt.Errorf("%w from %s for %v", ErrTimeout, addr, timeout)
+}
+
+// socket returns socket connected to addr with kernel timestamps and receive timeout set up.
+// Connected association keeps it for the following queries, otherwise the caller closes it
+func (a *Association) socket(ctx context.Context, addr string, timeout time.Duration) (*net.UDPConn, error) {
+	if a.conn != nil {
+		if a.Connected && a.connAddr == addr && a.connTimeout == timeout {
+			return a.conn, nil
+		}
+		a.closeConn()
+	}
+	// dialing UDP sends nothing, it's resolution of the address
+	_, span := a.startSpan(ctx, spanResolve, attribute.String("ntp.server", addr))
+	c, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		err = fmt.Errorf("failed to connect to %s: %w", addr, err)
+		endSpan(span, err)
+		return nil, err
+	}
+	conn := c.(*net.UDPConn)
+	span.SetAttributes(attribute.String("ntp.remote", conn.RemoteAddr().String()))
+	endSpan(span, nil)
+
+	// Allow reading of hardware/kernel timestamps via socket
+	if err := ntp.EnableKernelTimestampsSocket(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Reading kernel timestamps bypasses deadlines, so socket gets its own timeout
+	if err := ntp.SetReceiveTimeout(conn, timeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if a.Connected {
+		a.conn, a.connAddr, a.connTimeout = conn, addr, timeout
+	}
+	return conn, nil
+}
+
+// closeConn closes the socket kept by Connected association, if there is one
+func (a *Association) closeConn() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn = nil
+	return err
+}
+
+// Close releases the socket kept by Connected association, the next query connects again
+func (a *Association) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closeConn()
+}
+
+// unreachable wraps errors ICMP reports to connected sockets with ErrUnreachable
+func unreachable(err error) error {
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	return err
+}
+
+// capture passes the packet to the capture sink, if there is one
+func (a *Association) capture(at time.Time, src, dst net.Addr, b []byte) {
+	if a.Capture == nil {
+		return
+	}
+	s, _ := src.(*net.UDPAddr)
+	d, _ := dst.(*net.UDPAddr)
+	capture.Capture(a.Capture, &capture.Packet{Time: at, Src: s, Dst: d, Payload: b})
+}
+
+// verify authenticates the response with NTS or MAC and checks it echoes Unique Identifier of the request
+func (a *Association) verify(p *ntp.ReceivedPacket, uid []byte) error {
+	if a.NTS != nil {
+		header, err := p.Packet.Bytes()
+		if err != nil {
+			return err
+		}
+		return a.NTS.VerifyResponse(append(header, p.Extensions...), uid)
+	}
+	if a.Key != nil {
+		if err := a.verifyMAC(p); err != nil {
+			return err
+		}
+	}
+	if a.UniqueID {
+		return verifyUID(p, uid)
+	}
+	return nil
+}
+
+// emitFailure logs failed response verification and reports it to the audit sink
+func (a *Association) emitFailure(err error) {
+	logging.Or(a.Logger).Warn("Response failed verification", "server", a.Addr, "error", err)
+	e := audit.Event{Kind: audit.AuthFailure, Peer: a.Addr, Reason: err.Error()}
+	switch {
+	case errors.Is(err, nts.ErrNAK):
+		e.Kind = audit.NTSNAK
+	case errors.Is(err, auth.ErrCryptoNAK):
+		e.Kind = audit.CryptoNAK
+	case errors.Is(err, ntp.ErrUniqueIdentifierMismatch):
+		e.Kind = audit.Replay
+	}
+	if a.Key != nil {
+		e.KeyID = a.Key.ID
+	}
+	audit.Emit(a.Audit, e)
+}
+
+// verifyMAC checks response is signed with the key of the association
+func (a *Association) verifyMAC(p *ntp.ReceivedPacket) error {
+	header, err := p.Packet.Bytes()
+	if err != nil {
+		return err
+	}
+	fields, mac, err := auth.Split(p.Extensions)
+	if err != nil {
+		return err
+	}
+	if mac == nil {
+		return auth.ErrNoMAC
+	}
+	if auth.IsCryptoNAK(mac) {
+		// crypto-NAK itself is not authenticated, only a sane reply to our request is believed
+		if p.Packet.Settings&0x7 != modeServer || len(fields) != 0 {
+			return fmt.Errorf("%w: malformed crypto-NAK", auth.ErrBadMAC)
+		}
+		return auth.ErrCryptoNAK
+	}
+	return a.Key.Verify(append(header, fields...), mac)
+}
+
+// verifyUID checks response echoes Unique Identifier of the request
+func verifyUID(p *ntp.ReceivedPacket, uid []byte) error {
+	fields, _, err := auth.Split(p.Extensions)
+	if err != nil {
+		return err
+	}
+	echoed, err := ntp.UniqueIdentifier(fields)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(echoed, uid) {
+		return ntp.ErrUniqueIdentifierMismatch
+	}
+	return nil
+}
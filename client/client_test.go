@@ -0,0 +1,306 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer replies to each request with the response produced by reply
+func testServer(t *testing.T, reply func(request *ntp.Packet) []*ntp.Packet) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		for {
+			request, addr, err := ntp.ReadNTPPacket(conn)
+			if err != nil {
+				return
+			}
+			for _, response := range reply(request) {
+				b, _ := response.Bytes()
+				_, _ = conn.WriteTo(b, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func response(request *ntp.Packet, rx, tx time.Time) *ntp.Packet {
+	rxSec, rxFrac := ntp.Time(rx)
+	txSec, txFrac := ntp.Time(tx)
+	return &ntp.Packet{
+		Settings:     0x24,
+		Stratum:      1,
+		OrigTimeSec:  request.TxTimeSec,
+		OrigTimeFrac: request.TxTimeFrac,
+		RxTimeSec:    rxSec,
+		RxTimeFrac:   rxFrac,
+		TxTimeSec:    txSec,
+		TxTimeFrac:   txFrac,
+	}
+}
+
+func TestQuery(t *testing.T) {
+	offset := time.Hour
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		now := time.Now().Add(offset)
+		// stale reply from earlier request goes first and has to be ignored
+		stale := response(request, now, now)
+		stale.OrigTimeFrac++
+		stale.Stratum = 2
+		return []*ntp.Packet{stale, response(request, now, now)}
+	})
+	a := &Association{Addr: addr, Timeout: time.Second}
+	r, err := a.Query()
+	require.Nil(t, err)
+	assert.Equal(t, uint8(1), r.Packet.Stratum)
+	assert.InDelta(t, float64(offset), float64(r.Offset()), float64(time.Second))
+	assert.GreaterOrEqual(t, r.AvgNetworkDelay(), int64(0))
+	assert.False(t, r.ClientReceiveTime.Before(r.ClientTransmitTime))
+}
+
+func TestQueryTimeout(t *testing.T) {
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		return nil
+	})
+	a := &Association{Addr: addr, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := a.Query()
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestQueryConnected(t *testing.T) {
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		now := time.Now()
+		return []*ntp.Packet{response(request, now, now)}
+	})
+	a := &Association{Addr: addr, Timeout: time.Second, Connected: true}
+	_, err := a.Query()
+	require.Nil(t, err)
+	require.NotNil(t, a.conn)
+	local := a.conn.LocalAddr().String()
+
+	_, err = a.Query()
+	require.Nil(t, err)
+	assert.Equal(t, local, a.conn.LocalAddr().String(), "socket is kept between queries")
+
+	assert.Nil(t, a.Close())
+	assert.Nil(t, a.conn)
+	_, err = a.Query()
+	require.Nil(t, err, "closed association connects again")
+	assert.Nil(t, a.Close())
+}
+
+func TestQueryUnreachable(t *testing.T) {
+	// nothing listens on the port once the socket is closed, so ICMP reports it unreachable
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	addr := conn.LocalAddr().String()
+	require.Nil(t, conn.Close())
+
+	for _, connected := range []bool{false, true} {
+		a := &Association{Addr: addr, Timeout: 5 * time.Second, Connected: connected}
+		start := time.Now()
+		_, err = a.Query()
+		assert.ErrorIs(t, err, ErrUnreachable)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second), "no waiting for the timeout")
+		assert.Nil(t, a.conn, "failed socket isn't kept")
+	}
+}
+
+func TestQueryMAC(t *testing.T) {
+	key := &auth.Key{ID: 5, Algorithm: auth.SHA1, Secret: []byte("secret")}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, ntp.MaxMessageSizeBytes)
+		for sign := true; ; sign = !sign {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if key.Verify(buf[:ntp.PacketSizeBytes], buf[ntp.PacketSizeBytes:n]) != nil {
+				continue
+			}
+			request, _ := ntp.BytesToPacket(buf[:ntp.PacketSizeBytes])
+			b, _ := response(request, time.Now(), time.Now()).Bytes()
+			// every other response is not signed
+			if sign {
+				b = key.Sign(b)
+			}
+			_, _ = conn.WriteTo(b, addr)
+		}
+	}()
+	a := &Association{Addr: conn.LocalAddr().String(), Timeout: time.Second, Key: key}
+	_, err = a.Query()
+	assert.Nil(t, err)
+	_, err = a.Query()
+	assert.Equal(t, auth.ErrNoMAC, err)
+}
+
+func TestQueryCryptoNAK(t *testing.T) {
+	key := &auth.Key{ID: 5, Algorithm: auth.SHA1, Secret: []byte("secret")}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+	settings := make(chan uint8, 2)
+	go func() {
+		buf := make([]byte, ntp.MaxMessageSizeBytes)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request, _ := ntp.BytesToPacket(buf[:ntp.PacketSizeBytes])
+			p := response(request, time.Now(), time.Now())
+			p.Settings = <-settings
+			b, _ := p.Bytes()
+			_, _ = conn.WriteTo(auth.AppendCryptoNAK(b), addr)
+		}
+	}()
+	events := &testSink{}
+	a := &Association{Addr: conn.LocalAddr().String(), Timeout: time.Second, Key: key, Audit: events}
+	settings <- 0x24
+	_, err = a.Query()
+	assert.Equal(t, auth.ErrCryptoNAK, err)
+	require.Equal(t, 1, len(events.events))
+	assert.Equal(t, audit.CryptoNAK, events.events[0].Kind)
+	assert.Equal(t, uint32(5), events.events[0].KeyID)
+
+	// crypto-NAK in a packet which is not a server reply is bogus
+	settings <- 0x23
+	_, err = a.Query()
+	assert.ErrorIs(t, err, auth.ErrBadMAC)
+}
+
+func TestQueryUniqueID(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, ntp.MaxMessageSizeBytes)
+		for echo := true; ; echo = !echo {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request, _ := ntp.BytesToPacket(buf[:ntp.PacketSizeBytes])
+			b, _ := response(request, time.Now(), time.Now()).Bytes()
+			// every other response echoes somebody else's identifier
+			if !echo {
+				other, _ := ntp.NewUniqueIdentifier()
+				b = append(b, other.Bytes()...)
+			} else {
+				b = append(b, buf[ntp.PacketSizeBytes:n]...)
+			}
+			_, _ = conn.WriteTo(b, addr)
+		}
+	}()
+	a := &Association{Addr: conn.LocalAddr().String(), Timeout: time.Second, UniqueID: true}
+	_, err = a.Query()
+	assert.Nil(t, err)
+	_, err = a.Query()
+	assert.Equal(t, ntp.ErrUniqueIdentifierMismatch, err)
+}
+
+func TestQueryInterleaved(t *testing.T) {
+	// server keeps receive timestamp of the last request and more accurate transmit timestamp of the last response
+	var lastRx, preciseTx time.Time
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		now := time.Now()
+		rxSec, rxFrac := ntp.Time(lastRx)
+		p := response(request, now, now)
+		if !lastRx.IsZero() && request.OrigTimeSec == rxSec && request.OrigTimeFrac == rxFrac {
+			p.OrigTimeSec, p.OrigTimeFrac = request.RxTimeSec, request.RxTimeFrac
+			p.TxTimeSec, p.TxTimeFrac = ntp.Time(preciseTx)
+		}
+		lastRx = now
+		preciseTx = now.Add(time.Millisecond)
+		return []*ntp.Packet{p}
+	})
+	a := &Association{Addr: addr, Timeout: time.Second, Interleaved: true}
+	first, err := a.Query()
+	require.Nil(t, err)
+	second, err := a.Query()
+	require.Nil(t, err)
+	// second response describes the first exchange
+	assert.Equal(t, first.ClientTransmitTime, second.ClientTransmitTime)
+	assert.Equal(t, first.ServerReceiveTime, second.ServerReceiveTime)
+	assert.Equal(t, first.ClientReceiveTime, second.ClientReceiveTime)
+	assert.InDelta(t, float64(time.Millisecond), float64(second.ServerTransmitTime.Sub(first.ServerTransmitTime)), float64(time.Microsecond))
+}
+
+func TestQueryOriginMismatch(t *testing.T) {
+	// response which would be interleaved one is bogus for basic mode association
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		p := response(request, time.Now(), time.Now())
+		p.OrigTimeSec, p.OrigTimeFrac = request.RxTimeSec, request.RxTimeFrac
+		return []*ntp.Packet{p}
+	})
+	events := &testSink{}
+	a := &Association{Addr: addr, Timeout: 100 * time.Millisecond, Audit: events}
+	_, err := a.Query()
+	assert.ErrorIs(t, err, ErrTimeout)
+	require.Equal(t, 1, len(events.events))
+	assert.Equal(t, audit.Replay, events.events[0].Kind)
+	assert.Equal(t, addr, events.events[0].Peer)
+}
+
+// testSink collects audit events
+type testSink struct {
+	events []audit.Event
+}
+
+func (s *testSink) Emit(e audit.Event) {
+	s.events = append(s.events, e)
+}
+
+type testCapture struct {
+	packets []*capture.Packet
+}
+
+func (c *testCapture) Capture(p *capture.Packet) {
+	c.packets = append(c.packets, p)
+}
+
+func TestQueryCapture(t *testing.T) {
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		now := time.Now()
+		return []*ntp.Packet{response(request, now, now)}
+	})
+	sink := &testCapture{}
+	a := &Association{Addr: addr, Timeout: time.Second, Capture: sink}
+	r, err := a.Query()
+	require.Nil(t, err)
+	require.Len(t, sink.packets, 2)
+	assert.Equal(t, addr, sink.packets[0].Dst.String())
+	assert.Equal(t, r.ClientTransmitTime, sink.packets[0].Time)
+	assert.Equal(t, addr, sink.packets[1].Src.String())
+	assert.Equal(t, r.ClientReceiveTime, sink.packets[1].Time, "response is captured with kernel timestamp")
+}
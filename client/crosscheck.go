@@ -0,0 +1,60 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/roughtime"
+)
+
+// CrossCheck is the outcome of checking NTP source against Roughtime
+type CrossCheck struct {
+	Addr string
+	// Difference is NTP offset of the source less Roughtime offset
+	Difference time.Duration
+	// Bound is how large Difference may be while both claims hold: error bounds of the source and Roughtime combined
+	Bound time.Duration
+	// Disagrees is set if Difference exceeds Bound, meaning either the source or Roughtime server is wrong.
+	// Roughtime being signed, the source is the suspect
+	Disagrees bool
+}
+
+// ErrorBound returns how far the source claims true offset may be from Offset:
+// half of round trip delay, dispersion and jitter
+func (p *PeerStats) ErrorBound() time.Duration {
+	return p.Delay/2 + p.Dispersion + p.Jitter
+}
+
+// CrossCheckRoughtime compares offsets of the sources with Roughtime response.
+// Sources without samples are skipped
+func CrossCheckRoughtime(rt *roughtime.Response, peers []*PeerStats) []CrossCheck {
+	var checks []CrossCheck
+	for _, p := range peers {
+		if p.Samples == 0 {
+			continue
+		}
+		c := CrossCheck{
+			Addr:       p.Addr,
+			Difference: p.Offset - rt.Offset(),
+			Bound:      p.ErrorBound() + rt.Uncertainty(),
+		}
+		c.Disagrees = c.Difference > c.Bound || -c.Difference > c.Bound
+		checks = append(checks, c)
+	}
+	return checks
+}
@@ -0,0 +1,51 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/roughtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrossCheckRoughtime(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	rt := &roughtime.Response{
+		Time:     roughtime.Time{Midpoint: now.Add(10 * time.Millisecond), Radius: 100 * time.Millisecond},
+		Sent:     now.Add(-10 * time.Millisecond),
+		Received: now.Add(10 * time.Millisecond),
+	}
+	peers := []*PeerStats{
+		{Addr: "10.0.0.1:123", Samples: 8, Offset: 50 * time.Millisecond, Delay: 2 * time.Millisecond},
+		{Addr: "10.0.0.2:123", Samples: 8, Offset: -time.Second, Delay: 2 * time.Millisecond, Dispersion: time.Millisecond},
+		{Addr: "10.0.0.3:123"},
+	}
+	checks := CrossCheckRoughtime(rt, peers)
+	require.Len(t, checks, 2, "sources without samples are skipped")
+
+	assert.Equal(t, "10.0.0.1:123", checks[0].Addr)
+	assert.Equal(t, 40*time.Millisecond, checks[0].Difference)
+	assert.Equal(t, 111*time.Millisecond, checks[0].Bound)
+	assert.False(t, checks[0].Disagrees)
+
+	assert.Equal(t, -1010*time.Millisecond, checks[1].Difference)
+	assert.Equal(t, 112*time.Millisecond, checks[1].Bound)
+	assert.True(t, checks[1].Disagrees)
+}
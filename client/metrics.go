@@ -0,0 +1,170 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/protocol/nts"
+	"github.com/facebookincubator/ntp/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsNamespace prefixes names of all Prometheus metrics of associations
+const metricsNamespace = "ntp_client"
+
+func sourceDesc(name, help string, t telemetry.Type, labels ...string) *telemetry.Desc {
+	return telemetry.NewDesc(metricsNamespace+"_"+name, help, t, append([]string{"source"}, labels...)...)
+}
+
+var (
+	offsetDesc         = sourceDesc("offset_seconds", "Offset of the local clock from the source", telemetry.Gauge)
+	delayDesc          = sourceDesc("delay_seconds", "Round trip delay to the source", telemetry.Gauge)
+	dispersionDesc     = sourceDesc("dispersion_seconds", "Dispersion of the source samples", telemetry.Gauge)
+	jitterDesc         = sourceDesc("jitter_seconds", "Jitter of the source samples", telemetry.Gauge)
+	windowJitterDesc   = sourceDesc("window_jitter_seconds", "RMS of differences between successive offsets of the source over its jitter window", telemetry.Gauge)
+	offsetQuantileDesc = sourceDesc("offset_quantile_seconds", "Quantiles of absolute offsets of the source responses", telemetry.Gauge, "quantile")
+	delayQuantileDesc  = sourceDesc("delay_quantile_seconds", "Quantiles of round trip delays of the source responses", telemetry.Gauge, "quantile")
+	reachDesc          = sourceDesc("reach", "Reachability register of the source, bit 0 is set if the last query got a response", telemetry.Gauge)
+	stratumDesc        = sourceDesc("stratum", "Stratum of the source, 0 until it responds", telemetry.Gauge)
+	selectableDesc     = sourceDesc("selectable", "1 if the source may be selected to discipline the clock, 0 otherwise", telemetry.Gauge)
+	pollsDesc          = sourceDesc("polls_total", "Queries made to the source", telemetry.Counter)
+	errorsDesc         = sourceDesc("errors_total", "Queries to the source which failed", telemetry.Counter)
+	lastPollDesc       = sourceDesc("last_poll_timestamp_seconds", "When the source was last queried", telemetry.Gauge)
+	lastErrorDesc      = sourceDesc("last_error", "1 if the last query to the source failed, by reason: timeout, nak, auth or other", telemetry.Gauge, "reason")
+)
+
+// ErrorReason classifies the error query failed with for reporting: timeout, nak, auth or other
+func ErrorReason(err error) string {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, nts.ErrNAK), errors.Is(err, auth.ErrCryptoNAK):
+		return "nak"
+	case errors.Is(err, auth.ErrBadMAC), errors.Is(err, auth.ErrNoMAC), errors.Is(err, nts.ErrUnauthenticated),
+		errors.Is(err, ntp.ErrUniqueIdentifierMismatch):
+		return "auth"
+	}
+	return "other"
+}
+
+// Collector is Prometheus collector and telemetry source of statistics of associations, labelled by source address.
+// It is safe for concurrent use
+type Collector struct {
+	// Selectable tells whether the source may be selected to discipline the clock, e.g. PTPGuard.Selectable.
+	// Sources which responded to the last query are if nil
+	Selectable func(addr string) bool
+
+	mu           sync.Mutex
+	associations map[string]*Association
+}
+
+// Add starts reporting statistics of the association, replacing the one with the same address
+func (c *Collector) Add(a *Association) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.associations == nil {
+		c.associations = map[string]*Association{}
+	}
+	c.associations[a.Addr] = a
+}
+
+// Remove stops reporting statistics of the association with the address
+func (c *Collector) Remove(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.associations, addr)
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	telemetry.Describe(c, ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	telemetry.Collect(c, ch)
+}
+
+// Descs implements telemetry.Source
+func (c *Collector) Descs() []*telemetry.Desc {
+	return []*telemetry.Desc{offsetDesc, delayDesc, dispersionDesc, jitterDesc, windowJitterDesc,
+		offsetQuantileDesc, delayQuantileDesc, reachDesc, stratumDesc, selectableDesc, pollsDesc, errorsDesc,
+		lastPollDesc, lastErrorDesc}
+}
+
+// Metrics implements telemetry.Source
+func (c *Collector) Metrics() []telemetry.Metric {
+	c.mu.Lock()
+	associations := make([]*Association, 0, len(c.associations))
+	for _, a := range c.associations {
+		associations = append(associations, a)
+	}
+	c.mu.Unlock()
+	now := time.Now()
+	var metrics []telemetry.Metric
+	for _, a := range associations {
+		s := a.Stats(now)
+		add := func(d *telemetry.Desc, v float64, labels ...string) {
+			metrics = append(metrics, telemetry.Metric{Desc: d, LabelValues: append([]string{s.Addr}, labels...), Value: v})
+		}
+		add(reachDesc, float64(s.Reach))
+		add(stratumDesc, float64(s.Stratum))
+		add(selectableDesc, boolValue(c.selectable(s)))
+		add(pollsDesc, float64(s.Polls))
+		add(errorsDesc, float64(s.Errors))
+		if !s.LastPoll.IsZero() {
+			add(lastPollDesc, float64(s.LastPoll.UnixNano())/1e9)
+		}
+		if s.LastError != nil {
+			add(lastErrorDesc, 1, ErrorReason(s.LastError))
+		}
+		if a.JitterWindow != 0 {
+			add(windowJitterDesc, s.WindowJitter.Seconds())
+		}
+		if s.Samples == 0 {
+			continue
+		}
+		add(offsetDesc, s.Offset.Seconds())
+		add(delayDesc, s.Delay.Seconds())
+		add(dispersionDesc, s.Dispersion.Seconds())
+		add(jitterDesc, s.Jitter.Seconds())
+		add(offsetQuantileDesc, s.OffsetP50.Seconds(), "0.5")
+		add(offsetQuantileDesc, s.OffsetP99.Seconds(), "0.99")
+		add(delayQuantileDesc, s.DelayP50.Seconds(), "0.5")
+		add(delayQuantileDesc, s.DelayP99.Seconds(), "0.99")
+	}
+	return metrics
+}
+
+func (c *Collector) selectable(s *PeerStats) bool {
+	if c.Selectable != nil {
+		return c.Selectable(s.Addr)
+	}
+	return s.Reach&1 == 1
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
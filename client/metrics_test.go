@@ -0,0 +1,84 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/nts"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorReason(t *testing.T) {
+	assert.Equal(t, "timeout", ErrorReason(fmt.Errorf("%w from 10.0.0.1:123 for 1s", ErrTimeout)))
+	assert.Equal(t, "nak", ErrorReason(nts.ErrNAK))
+	assert.Equal(t, "nak", ErrorReason(auth.ErrCryptoNAK))
+	assert.Equal(t, "auth", ErrorReason(fmt.Errorf("%w: malformed crypto-NAK", auth.ErrBadMAC)))
+	assert.Equal(t, "other", ErrorReason(fmt.Errorf("failed to send request")))
+}
+
+func TestCollector(t *testing.T) {
+	now := time.Now()
+	good := &Association{Addr: "10.0.0.1:123", JitterWindow: time.Hour}
+	good.peer.record(now, exchangeAt(now, time.Millisecond, 4*time.Millisecond), nil)
+	bad := &Association{Addr: "10.0.0.2:123"}
+	bad.peer.record(now, nil, ErrTimeout)
+	gone := &Association{Addr: "10.0.0.3:123"}
+
+	c := &Collector{}
+	c.Add(good)
+	c.Add(bad)
+	c.Add(gone)
+	c.Remove(gone.Addr)
+	r := prometheus.NewRegistry()
+	require.Nil(t, r.Register(c))
+
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b, err := ioutil.ReadAll(w.Body)
+	require.Nil(t, err)
+	body := string(b)
+	assert.Contains(t, body, `ntp_client_offset_seconds{source="10.0.0.1:123"} 0.001`)
+	assert.Contains(t, body, `ntp_client_delay_seconds{source="10.0.0.1:123"} 0.004`)
+	assert.Contains(t, body, `ntp_client_offset_quantile_seconds{quantile="0.99",source="10.0.0.1:123"} 0.001`)
+	assert.Contains(t, body, `ntp_client_delay_quantile_seconds{quantile="0.5",source="10.0.0.1:123"} 0.004`)
+	assert.Contains(t, body, `ntp_client_reach{source="10.0.0.1:123"} 1`)
+	assert.Contains(t, body, `ntp_client_stratum{source="10.0.0.1:123"} 2`)
+	assert.Contains(t, body, `ntp_client_selectable{source="10.0.0.1:123"} 1`)
+	assert.Contains(t, body, `ntp_client_selectable{source="10.0.0.2:123"} 0`)
+	assert.Contains(t, body, `ntp_client_polls_total{source="10.0.0.2:123"} 1`)
+	assert.Contains(t, body, `ntp_client_errors_total{source="10.0.0.2:123"} 1`)
+	assert.Contains(t, body, `ntp_client_last_error{reason="timeout",source="10.0.0.2:123"} 1`)
+	assert.Contains(t, body, `ntp_client_window_jitter_seconds{source="10.0.0.1:123"} 0`)
+	assert.NotContains(t, body, `ntp_client_window_jitter_seconds{source="10.0.0.2:123"}`)
+	assert.NotContains(t, body, `ntp_client_offset_seconds{source="10.0.0.2:123"}`)
+	assert.NotContains(t, body, "10.0.0.3")
+
+	c.Selectable = func(addr string) bool { return addr == bad.Addr }
+	w = httptest.NewRecorder()
+	promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `ntp_client_selectable{source="10.0.0.1:123"} 0`)
+	assert.Contains(t, w.Body.String(), `ntp_client_selectable{source="10.0.0.2:123"} 1`)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/events"
+	"github.com/facebookincubator/ntp/logging"
+	"github.com/facebookincubator/ntp/refclock"
+)
+
+// DefaultPTPThreshold is how far NTP sources may be from PTP time when PTPGuard has no threshold set
+const DefaultPTPThreshold = time.Millisecond
+
+// PHCReader reads PTP hardware clock against the system clock, refclock.PHC does
+type PHCReader interface {
+	Read() (*refclock.Sample, error)
+}
+
+// PTPGuard cross-verifies NTP sources against PTP hardware clock disciplined by PTP, for hosts running both protocols.
+// Sources diverging from PTP time by more than Threshold are reported and deselected until they agree again.
+// It is safe for concurrent use
+type PTPGuard struct {
+	// PHC is read for PTP time, its UTC offset must be set for PHC kept in TAI
+	PHC PHCReader
+	// Threshold is DefaultPTPThreshold if not set. Dispersion of PHC reading is added to it
+	Threshold time.Duration
+	// Audit receives SourceDivergence events when sources start diverging. May be nil
+	Audit audit.Sink
+	// Logger receives sources being deselected and selectable again, slog.Default() if not set
+	Logger logging.Logger
+	// Events receives sources being deselected and selectable again. May be nil
+	Events *events.Bus
+
+	mu         sync.Mutex
+	deselected map[string]bool
+}
+
+func (g *PTPGuard) threshold() time.Duration {
+	if g.Threshold == 0 {
+		return DefaultPTPThreshold
+	}
+	return g.Threshold
+}
+
+// Check reads PHC and compares offsets of the sources with PHC offset. Sources without samples are skipped
+func (g *PTPGuard) Check(peers []*PeerStats) ([]CrossCheck, error) {
+	sample, err := g.PHC.Read()
+	if err != nil {
+		return nil, err
+	}
+	ptpOffset := sample.Offset()
+	bound := g.threshold() + sample.Dispersion
+
+	// events are published once the lock is released, subscribers may well ask what is selectable
+	var changes []events.Event
+	defer func() {
+		for _, e := range changes {
+			g.Events.Publish(e)
+		}
+	}()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.deselected == nil {
+		g.deselected = map[string]bool{}
+	}
+	var checks []CrossCheck
+	for _, p := range peers {
+		if p.Samples == 0 {
+			continue
+		}
+		c := CrossCheck{Addr: p.Addr, Difference: p.Offset - ptpOffset, Bound: bound}
+		c.Disagrees = c.Difference > c.Bound || -c.Difference > c.Bound
+		checks = append(checks, c)
+		switch {
+		case c.Disagrees && !g.deselected[p.Addr]:
+			logging.Or(g.Logger).Warn("NTP source diverges from PTP time, deselecting", "source", p.Addr, "difference", c.Difference)
+			audit.Emit(g.Audit, audit.Event{
+				Kind:   audit.SourceDivergence,
+				Peer:   p.Addr,
+				Reason: fmt.Sprintf("%v away from PTP time, over %v", c.Difference, c.Bound),
+			})
+			g.deselected[p.Addr] = true
+			changes = append(changes, events.Event{Kind: events.SourceDeselected, Source: p.Addr, Offset: p.Offset, Reason: "diverges from PTP time"})
+		case !c.Disagrees && g.deselected[p.Addr]:
+			logging.Or(g.Logger).Info("NTP source agrees with PTP time again", "source", p.Addr)
+			delete(g.deselected, p.Addr)
+			changes = append(changes, events.Event{Kind: events.SourceSelected, Source: p.Addr, Offset: p.Offset})
+		}
+	}
+	return checks, nil
+}
+
+// Selectable tells if the source agreed with PTP time when last checked, so it may be used to discipline the clock
+func (g *PTPGuard) Selectable(addr string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.deselected[addr]
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/events"
+	"github.com/facebookincubator/ntp/refclock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPHC struct {
+	offset time.Duration
+}
+
+func (p *testPHC) Read() (*refclock.Sample, error) {
+	now := time.Now()
+	return &refclock.Sample{Received: now, Reference: now.Add(p.offset), Dispersion: 100 * time.Microsecond}, nil
+}
+
+func TestPTPGuard(t *testing.T) {
+	phc := &testPHC{offset: 2 * time.Millisecond}
+	sink := &testSink{}
+	g := &PTPGuard{PHC: phc, Audit: sink, Events: &events.Bus{}}
+	var changes []events.Event
+	g.Events.Subscribe(func(e events.Event) {
+		// subscribers may ask what is selectable
+		g.Selectable(e.Source)
+		changes = append(changes, e)
+	})
+	peers := []*PeerStats{
+		{Addr: "10.0.0.1:123", Samples: 8, Offset: 2500 * time.Microsecond},
+		{Addr: "10.0.0.2:123", Samples: 8, Offset: -time.Millisecond},
+		{Addr: "10.0.0.3:123"},
+	}
+	checks, err := g.Check(peers)
+	require.Nil(t, err)
+	require.Len(t, checks, 2)
+	assert.Equal(t, 500*time.Microsecond, checks[0].Difference)
+	assert.Equal(t, 1100*time.Microsecond, checks[0].Bound)
+	assert.False(t, checks[0].Disagrees)
+	assert.True(t, checks[1].Disagrees)
+
+	assert.True(t, g.Selectable("10.0.0.1:123"))
+	assert.False(t, g.Selectable("10.0.0.2:123"))
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, audit.SourceDivergence, sink.events[0].Kind)
+	assert.Equal(t, "10.0.0.2:123", sink.events[0].Peer)
+
+	_, err = g.Check(peers)
+	require.Nil(t, err)
+	assert.Len(t, sink.events, 1, "divergence is reported once")
+	require.Len(t, changes, 1)
+	assert.Equal(t, events.SourceDeselected, changes[0].Kind)
+	assert.Equal(t, "10.0.0.2:123", changes[0].Source)
+
+	phc.offset = -time.Millisecond
+	_, err = g.Check(peers)
+	require.Nil(t, err)
+	assert.True(t, g.Selectable("10.0.0.2:123"), "source agreeing again is selectable")
+	assert.False(t, g.Selectable("10.0.0.1:123"))
+	require.Len(t, changes, 3)
+	assert.Equal(t, events.SourceDeselected, changes[1].Kind)
+	assert.Equal(t, "10.0.0.1:123", changes[1].Source)
+	assert.Equal(t, events.SourceSelected, changes[2].Kind)
+	assert.Equal(t, "10.0.0.2:123", changes[2].Source)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/internal/histogram"
+	"github.com/facebookincubator/ntp/internal/ring"
+	"github.com/facebookincubator/ntp/internal/window"
+)
+
+// filterSize is how many samples clock filter keeps, as in RFC 5905
+const filterSize = 8
+
+// phi is frequency tolerance sample dispersion grows with, s/s
+const phi = 15e-6
+
+// PeerStats are statistics of the association, as ntpq reports them for a peer
+type PeerStats struct {
+	Addr    string
+	Stratum int
+	RefID   uint32
+	// Reach is reachability register: bit 0 is the last query, set if it got a response
+	Reach uint8
+	// Offset and Delay are those of the sample with the lowest delay among the last 8, Delay is round trip
+	Offset time.Duration
+	Delay  time.Duration
+	// Dispersion is weighted dispersion of the samples, aged to the time of Stats call
+	Dispersion time.Duration
+	// Jitter is RMS of offset differences of the samples from the selected one
+	Jitter time.Duration
+	// WindowJitter is RMS of differences between successive offsets over the association JitterWindow,
+	// 0 if it is not set
+	WindowJitter time.Duration
+	// OffsetP50 and OffsetP99 are quantiles of absolute offsets of all responses, DelayP50 and DelayP99 of their delays
+	OffsetP50 time.Duration
+	OffsetP99 time.Duration
+	DelayP50  time.Duration
+	DelayP99  time.Duration
+	// Samples is how many samples clock filter has
+	Samples int
+	// LastPoll is when the last query was made, LastResponse when the last response was accepted
+	LastPoll     time.Time
+	LastResponse time.Time
+	// LastError is the outcome of the last query, nil if it succeeded
+	LastError error
+	// Polls is how many queries were made, Errors how many of them failed
+	Polls  uint64
+	Errors uint64
+}
+
+// sample is clock filter sample, times in seconds
+type sample struct {
+	offset float64
+	delay  float64
+	disp   float64
+	at     time.Time
+}
+
+// peerState keeps statistics of association queries separately from the query lock, so they can be read while query is in flight
+type peerState struct {
+	mu           sync.Mutex
+	reach        uint8
+	samples      ring.Ring[sample]
+	stratum      int
+	refID        uint32
+	lastPoll     time.Time
+	lastResponse time.Time
+	lastErr      error
+	polls        uint64
+	errors       uint64
+	// offsets of accepted responses, for jitter over JitterWindow
+	offsets window.Series
+	// distributions of absolute offsets and delays of accepted responses, in nanoseconds
+	offsetHist histogram.Histogram
+	delayHist  histogram.Histogram
+}
+
+func (p *peerState) record(now time.Time, r *Response, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reach <<= 1
+	p.lastPoll = now
+	p.lastErr = err
+	p.polls++
+	if err != nil {
+		p.errors++
+		return
+	}
+	p.reach |= 1
+	p.lastResponse = now
+	p.stratum = int(r.Packet.Stratum)
+	p.refID = r.Packet.ReferenceID
+	delay := r.ClientReceiveTime.Sub(r.ClientTransmitTime) - r.ServerTransmitTime.Sub(r.ServerReceiveTime)
+	if delay < 0 {
+		delay = 0
+	}
+	s := sample{
+		offset: r.Offset().Seconds(),
+		delay:  delay.Seconds(),
+		disp:   math.Ldexp(1, int(r.Packet.Precision)),
+		at:     now,
+	}
+	if p.samples.Cap() == 0 {
+		p.samples.Init(filterSize)
+	}
+	p.samples.Push(s)
+	p.offsets.Add(now, s.offset)
+	p.offsetHist.Record(int64(math.Abs(s.offset) * float64(time.Second)))
+	p.delayHist.Record(int64(delay))
+}
+
+// Stats returns statistics of the association as of now
+func (a *Association) Stats(now time.Time) *PeerStats {
+	p := &a.peer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := &PeerStats{
+		Addr:         a.Addr,
+		Stratum:      p.stratum,
+		RefID:        p.refID,
+		Reach:        p.reach,
+		Samples:      p.samples.Len(),
+		LastPoll:     p.lastPoll,
+		LastResponse: p.lastResponse,
+		LastError:    p.lastErr,
+		Polls:        p.polls,
+		Errors:       p.errors,
+		OffsetP50:    time.Duration(p.offsetHist.Quantile(0.5)),
+		OffsetP99:    time.Duration(p.offsetHist.Quantile(0.99)),
+		DelayP50:     time.Duration(p.delayHist.Quantile(0.5)),
+		DelayP99:     time.Duration(p.delayHist.Quantile(0.99)),
+	}
+	if a.JitterWindow != 0 {
+		jitter, _ := p.offsets.RMSDiff(now, a.JitterWindow)
+		stats.WindowJitter = seconds(jitter)
+	}
+	if p.samples.Len() == 0 {
+		return stats
+	}
+	var buf [filterSize]sample
+	samples := buf[:p.samples.Len()]
+	for i := range samples {
+		samples[i] = p.samples.At(i)
+	}
+	// insertion sort is stable like sort.SliceStable, but allocates nothing
+	for i := 1; i < len(samples); i++ {
+		for j := i; j > 0 && samples[j].delay < samples[j-1].delay; j-- {
+			samples[j], samples[j-1] = samples[j-1], samples[j]
+		}
+	}
+	best := samples[0]
+	var disp, jitter float64
+	for i, s := range samples {
+		disp += (s.disp + phi*now.Sub(s.at).Seconds()) / math.Ldexp(1, i+1)
+		jitter += (s.offset - best.offset) * (s.offset - best.offset)
+	}
+	if len(samples) > 1 {
+		jitter = math.Sqrt(jitter / float64(len(samples)-1))
+	}
+	stats.Offset = seconds(best.offset)
+	stats.Delay = seconds(best.delay)
+	stats.Dispersion = seconds(disp)
+	stats.Jitter = seconds(jitter)
+	return stats
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// OffsetQuantile returns the absolute offset q of responses were within, with relative error below 1%
+func (a *Association) OffsetQuantile(q float64) time.Duration {
+	a.peer.mu.Lock()
+	defer a.peer.mu.Unlock()
+	return time.Duration(a.peer.offsetHist.Quantile(q))
+}
+
+// DelayQuantile returns the delay q of responses were within, with relative error below 1%
+func (a *Association) DelayQuantile(q float64) time.Duration {
+	a.peer.mu.Lock()
+	defer a.peer.mu.Unlock()
+	return time.Duration(a.peer.delayHist.Quantile(q))
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exchangeAt returns response of the server offset from the client, with the round trip delay
+func exchangeAt(at time.Time, offset, delay time.Duration) *Response {
+	return &Response{
+		Packet:             &ntp.Packet{Stratum: 2, Precision: -20, ReferenceID: 0x0a000001},
+		ClientTransmitTime: at,
+		ServerReceiveTime:  at.Add(offset + delay/2),
+		ServerTransmitTime: at.Add(offset + delay/2),
+		ClientReceiveTime:  at.Add(delay),
+	}
+}
+
+func TestStats(t *testing.T) {
+	a := &Association{Addr: "10.0.0.1:123"}
+	now := time.Unix(1600000000, 0)
+	stats := a.Stats(now)
+	assert.Equal(t, uint8(0), stats.Reach)
+	assert.Equal(t, 0, stats.Samples)
+
+	a.peer.record(now, exchangeAt(now, time.Millisecond, 4*time.Millisecond), nil)
+	a.peer.record(now.Add(time.Second), exchangeAt(now, 3*time.Millisecond, 2*time.Millisecond), nil)
+	timeout := errors.New("timeout")
+	a.peer.record(now.Add(2*time.Second), nil, timeout)
+
+	stats = a.Stats(now.Add(2 * time.Second))
+	assert.Equal(t, "10.0.0.1:123", stats.Addr)
+	assert.Equal(t, 2, stats.Stratum)
+	assert.Equal(t, uint32(0x0a000001), stats.RefID)
+	assert.Equal(t, uint8(0x6), stats.Reach)
+	assert.Equal(t, 2, stats.Samples)
+	// the sample with the lowest delay is selected
+	assert.Equal(t, 3*time.Millisecond, stats.Offset)
+	assert.Equal(t, 2*time.Millisecond, stats.Delay)
+	assert.InDelta(t, float64(2*time.Millisecond), float64(stats.Jitter), 1)
+	assert.Greater(t, int64(stats.Dispersion), int64(0))
+	assert.Equal(t, now.Add(2*time.Second), stats.LastPoll)
+	assert.Equal(t, now.Add(time.Second), stats.LastResponse)
+	assert.Equal(t, timeout, stats.LastError)
+	assert.Equal(t, uint64(3), stats.Polls)
+	assert.Equal(t, uint64(1), stats.Errors)
+}
+
+func TestStatsWindowJitter(t *testing.T) {
+	a := &Association{}
+	now := time.Unix(1600000000, 0)
+	for i, offset := range []time.Duration{100, 1, 4, 0} {
+		at := now.Add(time.Duration(i) * time.Minute)
+		a.peer.record(at, exchangeAt(at, offset*time.Millisecond, time.Millisecond), nil)
+	}
+	stats := a.Stats(now.Add(3 * time.Minute))
+	assert.Equal(t, time.Duration(0), stats.WindowJitter)
+
+	a.JitterWindow = 2 * time.Minute
+	stats = a.Stats(now.Add(3 * time.Minute))
+	// offsets within the window differ by 3ms and -4ms
+	assert.InDelta(t, float64(3535534*time.Nanosecond), float64(stats.WindowJitter), 1000)
+}
+
+func TestStatsQuantiles(t *testing.T) {
+	a := &Association{}
+	now := time.Unix(1600000000, 0)
+	for i := 1; i <= 100; i++ {
+		offset := time.Duration(i) * time.Millisecond
+		if i%2 == 0 {
+			offset = -offset
+		}
+		a.peer.record(now, exchangeAt(now, offset, time.Duration(i)*100*time.Microsecond), nil)
+	}
+	a.peer.record(now, nil, ErrTimeout)
+	stats := a.Stats(now)
+	assert.InEpsilon(t, float64(50*time.Millisecond), float64(stats.OffsetP50), 0.01)
+	assert.InEpsilon(t, float64(99*time.Millisecond), float64(stats.OffsetP99), 0.01)
+	assert.InEpsilon(t, float64(5*time.Millisecond), float64(stats.DelayP50), 0.01)
+	assert.InEpsilon(t, float64(9900*time.Microsecond), float64(stats.DelayP99), 0.01)
+	assert.InEpsilon(t, float64(100*time.Millisecond), float64(a.OffsetQuantile(1)), 0.01)
+	assert.InEpsilon(t, float64(9*time.Millisecond), float64(a.DelayQuantile(0.9)), 0.01)
+}
+
+func TestStatsFilterSize(t *testing.T) {
+	a := &Association{}
+	now := time.Unix(1600000000, 0)
+	for i := 0; i < 10; i++ {
+		a.peer.record(now, exchangeAt(now, time.Duration(i)*time.Millisecond, time.Duration(10-i)*time.Millisecond), nil)
+	}
+	stats := a.Stats(now)
+	assert.Equal(t, filterSize, stats.Samples)
+	assert.Equal(t, uint8(0xff), stats.Reach)
+	assert.Equal(t, 9*time.Millisecond, stats.Offset)
+}
+
+func TestStatsRecordAllocs(t *testing.T) {
+	a := &Association{JitterWindow: time.Minute}
+	now := time.Unix(1600000000, 0)
+	r := exchangeAt(now, time.Millisecond, time.Millisecond)
+	a.peer.record(now, r, nil)
+	allocs := testing.AllocsPerRun(100, func() {
+		a.peer.record(now, r, nil)
+		a.Stats(now)
+	})
+	// Stats itself is the only allocation, samples are kept in rings
+	assert.Equal(t, 1.0, allocs)
+}
+
+func TestQueryStats(t *testing.T) {
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		now := time.Now()
+		return []*ntp.Packet{response(request, now, now)}
+	})
+	a := &Association{Addr: addr, Timeout: time.Second}
+	_, err := a.Query()
+	require.Nil(t, err)
+	stats := a.Stats(time.Now())
+	assert.Equal(t, uint8(1), stats.Reach)
+	assert.Equal(t, 1, stats.Stratum)
+	assert.Nil(t, stats.LastError)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Names of spans of query phases
+const (
+	spanQuery    = "ntp.query"
+	spanNTSKE    = "ntp.ntske"
+	spanResolve  = "ntp.resolve"
+	spanSend     = "ntp.send"
+	spanReceive  = "ntp.receive"
+	spanFilter   = "ntp.filter"
+	spanValidate = "ntp.validate"
+)
+
+// startSpan starts span of the query phase as a child of the one in ctx. It is no-op one if the association has no tracer
+func (a *Association) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if a.Tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return a.Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, marking it failed with the error if there is one
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// timeAttribute is the time as span attribute, in nanoseconds since Unix epoch
+func timeAttribute(key string, t time.Time) attribute.KeyValue {
+	return attribute.Int64(key, t.UnixNano())
+}
+
+// responseAttributes are the four timestamps of the exchange along with offset and one way delay the response gives
+func responseAttributes(r *Response) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		timeAttribute("ntp.client_transmit", r.ClientTransmitTime),
+		timeAttribute("ntp.server_receive", r.ServerReceiveTime),
+		timeAttribute("ntp.server_transmit", r.ServerTransmitTime),
+		timeAttribute("ntp.client_receive", r.ClientReceiveTime),
+		attribute.Int64("ntp.offset_ns", int64(r.Offset())),
+		attribute.Int64("ntp.one_way_delay_ns", r.AvgNetworkDelay()),
+		attribute.Int("ntp.stratum", int(r.Packet.Stratum)),
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testSpan records what is done to it, methods it doesn't implement panic
+type testSpan struct {
+	trace.Span
+	name   string
+	parent *testSpan
+	attrs  map[attribute.Key]attribute.Value
+	status codes.Code
+	ended  bool
+}
+
+func (s *testSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+func (s *testSpan) RecordError(err error, options ...trace.EventOption) {}
+func (s *testSpan) SetStatus(code codes.Code, description string)       { s.status = code }
+func (s *testSpan) End(options ...trace.SpanEndOption)                  { s.ended = true }
+
+type testSpanKey struct{}
+
+// testTracer keeps spans it started in order
+type testTracer struct {
+	trace.Tracer
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	parent, _ := ctx.Value(testSpanKey{}).(*testSpan)
+	s := &testSpan{name: name, parent: parent, attrs: map[attribute.Key]attribute.Value{}}
+	config := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(config.Attributes()...)
+	tr.spans = append(tr.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+func (tr *testTracer) names() []string {
+	var names []string
+	for _, s := range tr.spans {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+func TestQueryTrace(t *testing.T) {
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet {
+		now := time.Now()
+		stale := response(request, now, now)
+		stale.OrigTimeFrac++
+		return []*ntp.Packet{stale, response(request, now, now)}
+	})
+	tracer := &testTracer{}
+	a := &Association{Addr: addr, Timeout: time.Second, Tracer: tracer}
+	r, err := a.Query()
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{spanQuery, spanResolve, spanSend, spanReceive, spanFilter, spanReceive, spanFilter, spanValidate}, tracer.names())
+	query := tracer.spans[0]
+	assert.Nil(t, query.parent)
+	for _, s := range tracer.spans[1:] {
+		assert.Equal(t, query, s.parent, s.name)
+	}
+	for _, s := range tracer.spans {
+		assert.True(t, s.ended, s.name)
+		assert.Equal(t, codes.Unset, s.status, s.name)
+	}
+	assert.Equal(t, addr, query.attrs["ntp.server"].AsString())
+	assert.Equal(t, r.ClientTransmitTime.UnixNano(), query.attrs["ntp.client_transmit"].AsInt64())
+	assert.Equal(t, r.ClientReceiveTime.UnixNano(), query.attrs["ntp.client_receive"].AsInt64())
+	assert.Equal(t, int64(r.Offset()), query.attrs["ntp.offset_ns"].AsInt64())
+	assert.Equal(t, "origin timestamp mismatch", tracer.spans[4].attrs["ntp.filtered"].AsString())
+	_, filtered := tracer.spans[6].attrs["ntp.filtered"]
+	assert.False(t, filtered)
+}
+
+func TestQueryTraceTimeout(t *testing.T) {
+	addr := testServer(t, func(request *ntp.Packet) []*ntp.Packet { return nil })
+	tracer := &testTracer{}
+	a := &Association{Addr: addr, Timeout: 50 * time.Millisecond, Tracer: tracer}
+	_, err := a.Query()
+	require.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, []string{spanQuery, spanResolve, spanSend, spanReceive}, tracer.names())
+	assert.Equal(t, codes.Error, tracer.spans[0].status)
+	assert.Equal(t, codes.Error, tracer.spans[3].status)
+}
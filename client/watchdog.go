@@ -0,0 +1,184 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/logging"
+	"github.com/facebookincubator/ntp/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWatchdogBound is how large estimated clock error may grow when Watchdog has no bound set
+const DefaultWatchdogBound = 10 * time.Millisecond
+
+// DefaultWatchdogGrace is how long estimated clock error may stay over the bound when Watchdog has no grace period set
+const DefaultWatchdogGrace = 5 * time.Minute
+
+var (
+	watchdogAlarmDesc  = telemetry.NewDesc(metricsNamespace+"_watchdog_alarm", "1 if estimated clock error stayed over the bound for longer than the grace period, 0 otherwise", telemetry.Gauge)
+	watchdogErrorDesc  = telemetry.NewDesc(metricsNamespace+"_watchdog_error_seconds", "Estimated clock error the watchdog last observed", telemetry.Gauge)
+	watchdogAlarmsDesc = telemetry.NewDesc(metricsNamespace+"_watchdog_alarms_total", "Alarms the watchdog raised", telemetry.Counter)
+)
+
+// WatchdogAlarm is passed to Watchdog callback when alarm is raised or cleared
+type WatchdogAlarm struct {
+	Time time.Time
+	// Raised is set when alarm is raised, unset when it is cleared
+	Raised bool
+	// Error is estimated clock error observed at the time
+	Error time.Duration
+	// Since is when the error went over the bound
+	Since time.Time
+}
+
+// Watchdog alarms when estimated clock error stays over the bound for longer than the grace period.
+// It only observes the clock, so it works the same whether discipline is enabled or not.
+// Alarms are logged, passed to the callback and exported as metrics. It is safe for concurrent use
+type Watchdog struct {
+	// Bound is DefaultWatchdogBound if not set
+	Bound time.Duration
+	// Grace is DefaultWatchdogGrace if not set
+	Grace time.Duration
+	// Alarm is called when alarm is raised and cleared, may be nil.
+	// It is called without the watchdog lock held
+	Alarm func(WatchdogAlarm)
+	// Logger receives alarms raised and cleared, slog.Default() if not set
+	Logger logging.Logger
+
+	mu       sync.Mutex
+	observed bool
+	estimate time.Duration
+	over     time.Time
+	alarmed  bool
+	alarms   uint64
+}
+
+func (w *Watchdog) bound() time.Duration {
+	if w.Bound == 0 {
+		return DefaultWatchdogBound
+	}
+	return w.Bound
+}
+
+func (w *Watchdog) grace() time.Duration {
+	if w.Grace == 0 {
+		return DefaultWatchdogGrace
+	}
+	return w.Grace
+}
+
+// EstimateError returns how far the clock may be from true time according to the sources:
+// the smallest absolute offset plus error bound of any of them. It is false if none of the sources has samples
+func EstimateError(peers []*PeerStats) (time.Duration, bool) {
+	var estimate time.Duration
+	ok := false
+	for _, p := range peers {
+		if p.Samples == 0 {
+			continue
+		}
+		offset := p.Offset
+		if offset < 0 {
+			offset = -offset
+		}
+		if e := offset + p.ErrorBound(); !ok || e < estimate {
+			estimate, ok = e, true
+		}
+	}
+	return estimate, ok
+}
+
+// Check observes clock error estimated from the sources at the time. Checks without any samples are ignored.
+// It tells whether alarm is raised
+func (w *Watchdog) Check(peers []*PeerStats, now time.Time) bool {
+	estimate, ok := EstimateError(peers)
+	if !ok {
+		return w.Alarmed()
+	}
+	return w.Observe(estimate, now)
+}
+
+// Observe records estimated clock error at the time, e.g. one read off PTP hardware clock.
+// It tells whether alarm is raised
+func (w *Watchdog) Observe(estimate time.Duration, now time.Time) bool {
+	var alarm *WatchdogAlarm
+	// the callback is called once the lock is released, it may well ask the watchdog
+	defer func() {
+		if alarm != nil && w.Alarm != nil {
+			w.Alarm(*alarm)
+		}
+	}()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.observed, w.estimate = true, estimate
+	if estimate <= w.bound() {
+		if w.alarmed {
+			logging.Or(w.Logger).Info("Clock error is within the bound again", "error", estimate, "bound", w.bound())
+			alarm = &WatchdogAlarm{Time: now, Error: estimate, Since: w.over}
+		}
+		w.over, w.alarmed = time.Time{}, false
+		return false
+	}
+	if w.over.IsZero() {
+		w.over = now
+	}
+	if !w.alarmed && now.Sub(w.over) > w.grace() {
+		logging.Or(w.Logger).Error("Clock error is over the bound", "error", estimate, "bound", w.bound(), "since", w.over)
+		w.alarmed = true
+		w.alarms++
+		alarm = &WatchdogAlarm{Time: now, Raised: true, Error: estimate, Since: w.over}
+	}
+	return w.alarmed
+}
+
+// Alarmed tells whether alarm is raised
+func (w *Watchdog) Alarmed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.alarmed
+}
+
+// Describe implements prometheus.Collector
+func (w *Watchdog) Describe(ch chan<- *prometheus.Desc) {
+	telemetry.Describe(w, ch)
+}
+
+// Collect implements prometheus.Collector
+func (w *Watchdog) Collect(ch chan<- prometheus.Metric) {
+	telemetry.Collect(w, ch)
+}
+
+// Descs implements telemetry.Source
+func (w *Watchdog) Descs() []*telemetry.Desc {
+	return []*telemetry.Desc{watchdogAlarmDesc, watchdogErrorDesc, watchdogAlarmsDesc}
+}
+
+// Metrics implements telemetry.Source
+func (w *Watchdog) Metrics() []telemetry.Metric {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	metrics := []telemetry.Metric{
+		{Desc: watchdogAlarmDesc, Value: boolValue(w.alarmed)},
+		{Desc: watchdogAlarmsDesc, Value: float64(w.alarms)},
+	}
+	if w.observed {
+		metrics = append(metrics, telemetry.Metric{Desc: watchdogErrorDesc, Value: w.estimate.Seconds()})
+	}
+	return metrics
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateError(t *testing.T) {
+	_, ok := EstimateError([]*PeerStats{{Addr: "10.0.0.1:123"}})
+	assert.False(t, ok)
+
+	estimate, ok := EstimateError([]*PeerStats{
+		{Addr: "10.0.0.1:123", Samples: 8, Offset: -3 * time.Millisecond, Delay: 2 * time.Millisecond},
+		{Addr: "10.0.0.2:123", Samples: 8, Offset: time.Millisecond, Delay: 4 * time.Millisecond, Dispersion: time.Millisecond},
+		{Addr: "10.0.0.3:123"},
+	})
+	require.True(t, ok)
+	assert.Equal(t, 4*time.Millisecond, estimate)
+}
+
+func TestWatchdog(t *testing.T) {
+	var alarms []WatchdogAlarm
+	w := &Watchdog{Bound: time.Millisecond, Grace: time.Minute}
+	w.Alarm = func(a WatchdogAlarm) {
+		// callback may ask the watchdog
+		w.Alarmed()
+		alarms = append(alarms, a)
+	}
+	start := time.Unix(1700000000, 0)
+
+	assert.False(t, w.Observe(500*time.Microsecond, start))
+	assert.False(t, w.Observe(2*time.Millisecond, start.Add(time.Second)))
+	assert.False(t, w.Observe(2*time.Millisecond, start.Add(time.Minute)), "within grace period")
+	// dipping under the bound restarts grace period
+	assert.False(t, w.Observe(time.Millisecond, start.Add(90*time.Second)))
+	assert.False(t, w.Observe(3*time.Millisecond, start.Add(2*time.Minute)))
+	assert.Empty(t, alarms)
+
+	assert.True(t, w.Observe(3*time.Millisecond, start.Add(3*time.Minute+time.Second)))
+	assert.True(t, w.Observe(4*time.Millisecond, start.Add(4*time.Minute)))
+	require.Len(t, alarms, 1, "alarm is raised once")
+	assert.Equal(t, WatchdogAlarm{Time: start.Add(3*time.Minute + time.Second), Raised: true, Error: 3 * time.Millisecond, Since: start.Add(2 * time.Minute)}, alarms[0])
+	assert.True(t, w.Alarmed())
+
+	// checks without samples change nothing
+	assert.True(t, w.Check([]*PeerStats{{Addr: "10.0.0.1:123"}}, start.Add(5*time.Minute)))
+	assert.False(t, w.Check([]*PeerStats{{Addr: "10.0.0.1:123", Samples: 1, Offset: 100 * time.Microsecond}}, start.Add(6*time.Minute)))
+	require.Len(t, alarms, 2)
+	assert.False(t, alarms[1].Raised)
+	assert.Equal(t, 100*time.Microsecond, alarms[1].Error)
+	assert.Equal(t, start.Add(2*time.Minute), alarms[1].Since)
+	assert.False(t, w.Alarmed())
+}
+
+func TestWatchdogMetrics(t *testing.T) {
+	w := &Watchdog{Grace: time.Second}
+	r := prometheus.NewRegistry()
+	require.Nil(t, r.Register(w))
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+	body := scrape()
+	assert.Contains(t, body, "ntp_client_watchdog_alarm 0")
+	assert.NotContains(t, body, "ntp_client_watchdog_error_seconds")
+
+	start := time.Unix(1700000000, 0)
+	w.Observe(20*time.Millisecond, start)
+	w.Observe(20*time.Millisecond, start.Add(2*time.Second))
+	body = scrape()
+	assert.Contains(t, body, "ntp_client_watchdog_alarm 1")
+	assert.Contains(t, body, "ntp_client_watchdog_alarms_total 1")
+	assert.Contains(t, body, "ntp_client_watchdog_error_seconds 0.02")
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clock controls system clock the way NTP daemons do: reads and adjusts its frequency,
+// slews and steps it and reports kernel synchronization status, see adjtimex(2)
+package clock
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Realtime is CLOCK_REALTIME, the system clock
+const Realtime int32 = 0
+
+// MaxSlew is the largest offset kernel slews at once (MAXPHASE), larger ones have to be stepped
+const MaxSlew = 500 * time.Millisecond
+
+// MaxFrequencyPPB is the largest frequency adjustment kernel accepts (MAXFREQ)
+const MaxFrequencyPPB = 500000.0
+
+// Clock states, see adjtimex(2)
+const (
+	TimeOK    = 0
+	TimeIns   = 1
+	TimeDel   = 2
+	TimeOOP   = 3
+	TimeWait  = 4
+	TimeError = 5
+)
+
+// Kernel clock status bits, see adjtimex(2)
+const (
+	StatusPLL      int32 = 0x0001
+	StatusPPSFreq  int32 = 0x0002
+	StatusPPSTime  int32 = 0x0004
+	StatusFLL      int32 = 0x0008
+	StatusIns      int32 = 0x0010
+	StatusDel      int32 = 0x0020
+	StatusUnsync   int32 = 0x0040
+	StatusFreqHold int32 = 0x0080
+	StatusNano     int32 = 0x2000
+)
+
+// Leap is the leap second kernel applies at the end of the UTC day. Values match NTP leap indicator
+type Leap int
+
+// Leap seconds
+const (
+	LeapNone Leap = iota
+	LeapInsert
+	LeapDelete
+)
+
+// ErrNotSupported is returned on platforms the clock can't be controlled on
+var ErrNotSupported = errors.New("clock control is not supported on this platform")
+
+// State is kernel view of the clock
+type State struct {
+	// State is one of TimeOK, TimeIns, TimeDel, TimeOOP, TimeWait, TimeError
+	State int
+	// Status has Status* bits
+	Status       int32
+	FrequencyPPB float64
+	// Offset is what kernel PLL has yet to slew
+	Offset time.Duration
+	// MaxError and EstError are maximum and estimated error of the clock as set by synchronization daemon
+	MaxError     time.Duration
+	EstError     time.Duration
+	TimeConstant int64
+	// TAI is TAI-UTC offset in seconds
+	TAI int32
+}
+
+// Synchronized reports whether kernel considers the clock synchronized
+func (s *State) Synchronized() bool {
+	return s.State != TimeError && s.Status&StatusUnsync == 0
+}
+
+// freqToPPB converts kernel frequency, which is ppm with 16 bit fraction, to ppb
+func freqToPPB(freq int64) float64 {
+	return float64(freq) / 65.536
+}
+
+// ppbToFreq converts ppb to kernel frequency
+func ppbToFreq(ppb float64) int64 {
+	return int64(math.Round(ppb * 65.536))
+}
+
+// splitOffset splits offset into seconds and non-negative nanoseconds, the way kernel expects timeval
+func splitOffset(offset time.Duration) (sec, nsec int64) {
+	sec = int64(offset / time.Second)
+	nsec = int64(offset % time.Second)
+	if nsec < 0 {
+		sec--
+		nsec += int64(time.Second)
+	}
+	return sec, nsec
+}
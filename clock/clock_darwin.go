@@ -0,0 +1,146 @@
+// +build darwin
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// darwin kernel doesn't keep frequency adjustment, so it is applied by slewing the clock every second
+var (
+	freqMu  sync.Mutex
+	freqPPB float64
+)
+
+// darwinError explains EPERM, which macOS returns to anyone but root
+func darwinError(call string, err error) error {
+	if errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("%s: %w: adjusting the clock on macOS requires root, e.g. launchd daemon, and is not allowed in App Sandbox", call, err)
+	}
+	return fmt.Errorf("%s: %w", call, err)
+}
+
+// checkClock only lets the system clock through
+func checkClock(clockid int32) error {
+	if clockid != Realtime {
+		return ErrNotSupported
+	}
+	return nil
+}
+
+// adjtime starts slewing the clock by delta, returning what was left of the previous slew. Nil delta only queries it
+func adjtime(delta *time.Duration) (time.Duration, error) {
+	var tv *syscall.Timeval
+	if delta != nil {
+		t := syscall.NsecToTimeval(delta.Nanoseconds())
+		tv = &t
+	}
+	var old syscall.Timeval
+	if err := syscall.Adjtime(tv, &old); err != nil {
+		return 0, darwinError("adjtime", err)
+	}
+	return time.Duration(old.Nano()), nil
+}
+
+// GetState returns frequency adjustment of the clock and the offset adjtime has yet to slew
+func GetState(clockid int32) (*State, error) {
+	if err := checkClock(clockid); err != nil {
+		return nil, err
+	}
+	offset, err := adjtime(nil)
+	if err != nil {
+		return nil, err
+	}
+	freq, _ := FrequencyPPB(clockid)
+	return &State{State: TimeOK, FrequencyPPB: freq, Offset: offset}, nil
+}
+
+// FrequencyPPB returns frequency adjustment of the clock in ppb, the last one set
+func FrequencyPPB(clockid int32) (float64, error) {
+	if err := checkClock(clockid); err != nil {
+		return 0, err
+	}
+	freqMu.Lock()
+	defer freqMu.Unlock()
+	return freqPPB, nil
+}
+
+// SetFrequencyPPB slews the clock by frequency adjustment for the next second. It has to be set every second,
+// which discipline loop does
+func SetFrequencyPPB(clockid int32, ppb float64) error {
+	if err := checkClock(clockid); err != nil {
+		return err
+	}
+	if math.Abs(ppb) > MaxFrequencyPPB {
+		return fmt.Errorf("frequency %.3f ppb exceeds %.0f ppb", ppb, MaxFrequencyPPB)
+	}
+	freqMu.Lock()
+	defer freqMu.Unlock()
+	delta := time.Duration(math.Round(ppb))
+	if _, err := adjtime(&delta); err != nil {
+		return err
+	}
+	freqPPB = ppb
+	return nil
+}
+
+// Slew gradually corrects the clock by the offset with adjtime
+func Slew(clockid int32, offset time.Duration) error {
+	if err := checkClock(clockid); err != nil {
+		return err
+	}
+	if offset > MaxSlew || offset < -MaxSlew {
+		return fmt.Errorf("offset %v exceeds %v which can be slewed", offset, MaxSlew)
+	}
+	_, err := adjtime(&offset)
+	return err
+}
+
+// Step moves the clock by the offset at once, with microsecond resolution
+func Step(clockid int32, offset time.Duration) error {
+	if err := checkClock(clockid); err != nil {
+		return err
+	}
+	tv := syscall.NsecToTimeval(time.Now().Add(offset).UnixNano())
+	if err := syscall.Settimeofday(&tv); err != nil {
+		return darwinError("settimeofday", err)
+	}
+	return nil
+}
+
+// SetLeap is not supported
+func SetLeap(clockid int32, leap Leap) error {
+	return ErrNotSupported
+}
+
+// TAI is not supported
+func TAI(clockid int32) (int, error) {
+	return 0, ErrNotSupported
+}
+
+// SetTAI is not supported
+func SetTAI(clockid int32, tai int) error {
+	return ErrNotSupported
+}
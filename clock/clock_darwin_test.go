@@ -0,0 +1,40 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	syscall "golang.org/x/sys/unix"
+)
+
+func TestAdjustLimits(t *testing.T) {
+	// limits are checked before touching the clock
+	assert.NotNil(t, SetFrequencyPPB(Realtime, MaxFrequencyPPB+1))
+	assert.NotNil(t, Slew(Realtime, MaxSlew+time.Nanosecond))
+	assert.Equal(t, ErrNotSupported, Step(Realtime+1, 0))
+}
+
+func TestDarwinError(t *testing.T) {
+	err := darwinError("adjtime", syscall.EPERM)
+	assert.True(t, errors.Is(err, syscall.EPERM))
+	assert.Contains(t, err.Error(), "requires root")
+	assert.NotContains(t, darwinError("adjtime", syscall.EINVAL).Error(), "root")
+}
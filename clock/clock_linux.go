@@ -0,0 +1,163 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// adjtimex modes, see adjtimex(2)
+const (
+	adjOffset    = 0x0001
+	adjFrequency = 0x0002
+	adjStatus    = 0x0010
+	adjTAI       = 0x0080
+	adjSetOffset = 0x0100
+	adjNano      = 0x2000
+)
+
+// adjtime calls clock_adjtime, returning clock state
+func adjtime(clockid int32, tx *syscall.Timex) (int, error) {
+	state, err := syscall.ClockAdjtime(clockid, tx)
+	if err != nil {
+		return 0, fmt.Errorf("clock_adjtime: %w", err)
+	}
+	return state, nil
+}
+
+// setLong sets long field of Timex, which is int32 or int64 depending on the architecture
+func setLong(field interface{}, v int64) {
+	reflect.ValueOf(field).Elem().SetInt(v)
+}
+
+// GetState returns kernel state of the clock
+func GetState(clockid int32) (*State, error) {
+	tx := &syscall.Timex{}
+	state, err := adjtime(clockid, tx)
+	if err != nil {
+		return nil, err
+	}
+	unit := time.Microsecond
+	if tx.Status&StatusNano != 0 {
+		unit = time.Nanosecond
+	}
+	return &State{
+		State:        state,
+		Status:       tx.Status,
+		FrequencyPPB: freqToPPB(int64(tx.Freq)),
+		Offset:       time.Duration(tx.Offset) * unit,
+		MaxError:     time.Duration(tx.Maxerror) * time.Microsecond,
+		EstError:     time.Duration(tx.Esterror) * time.Microsecond,
+		TimeConstant: int64(tx.Constant),
+		TAI:          tx.Tai,
+	}, nil
+}
+
+// FrequencyPPB returns frequency adjustment of the clock in ppb
+func FrequencyPPB(clockid int32) (float64, error) {
+	tx := &syscall.Timex{}
+	if _, err := adjtime(clockid, tx); err != nil {
+		return 0, err
+	}
+	return freqToPPB(int64(tx.Freq)), nil
+}
+
+// SetFrequencyPPB sets frequency adjustment of the clock in ppb
+func SetFrequencyPPB(clockid int32, ppb float64) error {
+	if math.Abs(ppb) > MaxFrequencyPPB {
+		return fmt.Errorf("frequency %.3f ppb exceeds %.0f ppb", ppb, MaxFrequencyPPB)
+	}
+	tx := &syscall.Timex{Modes: adjFrequency}
+	setLong(&tx.Freq, ppbToFreq(ppb))
+	_, err := adjtime(clockid, tx)
+	return err
+}
+
+// Slew hands the offset over to kernel PLL, which gradually corrects the clock by it
+func Slew(clockid int32, offset time.Duration) error {
+	if offset > MaxSlew || offset < -MaxSlew {
+		return fmt.Errorf("offset %v exceeds %v which can be slewed", offset, MaxSlew)
+	}
+	tx := &syscall.Timex{}
+	if _, err := adjtime(clockid, tx); err != nil {
+		return err
+	}
+	// kernel only uses the offset with PLL enabled
+	status := tx.Status | StatusPLL
+	tx = &syscall.Timex{Modes: adjOffset | adjNano | adjStatus, Status: status}
+	setLong(&tx.Offset, offset.Nanoseconds())
+	_, err := adjtime(clockid, tx)
+	return err
+}
+
+// Step moves the clock by the offset at once
+func Step(clockid int32, offset time.Duration) error {
+	sec, nsec := splitOffset(offset)
+	tx := &syscall.Timex{Modes: adjSetOffset | adjNano}
+	setLong(&tx.Time.Sec, sec)
+	// with ADJ_NANO microseconds field has nanoseconds
+	setLong(&tx.Time.Usec, nsec)
+	_, err := adjtime(clockid, tx)
+	return err
+}
+
+// SetLeap arms kernel to insert or delete a second at the next midnight UTC, LeapNone disarms it
+func SetLeap(clockid int32, leap Leap) error {
+	tx := &syscall.Timex{}
+	if _, err := adjtime(clockid, tx); err != nil {
+		return err
+	}
+	status := tx.Status &^ (StatusIns | StatusDel)
+	switch leap {
+	case LeapNone:
+	case LeapInsert:
+		status |= StatusIns
+	case LeapDelete:
+		status |= StatusDel
+	default:
+		return fmt.Errorf("unknown leap %d", leap)
+	}
+	tx = &syscall.Timex{Modes: adjStatus, Status: status}
+	_, err := adjtime(clockid, tx)
+	return err
+}
+
+// TAI returns TAI-UTC offset of the clock in seconds, which CLOCK_TAI is ahead of CLOCK_REALTIME by
+func TAI(clockid int32) (int, error) {
+	tx := &syscall.Timex{}
+	if _, err := adjtime(clockid, tx); err != nil {
+		return 0, err
+	}
+	return int(tx.Tai), nil
+}
+
+// SetTAI sets TAI-UTC offset of the clock in seconds, making CLOCK_TAI correct
+func SetTAI(clockid int32, tai int) error {
+	if tai < 0 {
+		return fmt.Errorf("negative TAI offset %d", tai)
+	}
+	// kernel takes the offset in time constant field
+	tx := &syscall.Timex{Modes: adjTAI}
+	setLong(&tx.Constant, int64(tai))
+	_, err := adjtime(clockid, tx)
+	return err
+}
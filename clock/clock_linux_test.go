@@ -0,0 +1,46 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetState(t *testing.T) {
+	state, err := GetState(Realtime)
+	require.Nil(t, err)
+	freq, err := FrequencyPPB(Realtime)
+	require.Nil(t, err)
+	assert.Equal(t, state.FrequencyPPB, freq)
+	tai, err := TAI(Realtime)
+	require.Nil(t, err)
+	assert.Equal(t, int(state.TAI), tai)
+	assert.LessOrEqual(t, state.State, TimeError)
+}
+
+func TestAdjustLimits(t *testing.T) {
+	// limits are checked before touching the clock
+	assert.NotNil(t, SetFrequencyPPB(Realtime, MaxFrequencyPPB+1))
+	assert.NotNil(t, Slew(Realtime, MaxSlew+time.Nanosecond))
+	assert.NotNil(t, Slew(Realtime, -MaxSlew-time.Nanosecond))
+	assert.NotNil(t, SetLeap(Realtime, Leap(3)))
+	assert.NotNil(t, SetTAI(Realtime, -1))
+}
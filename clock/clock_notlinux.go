@@ -0,0 +1,63 @@
+// +build !linux,!windows,!darwin
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"time"
+)
+
+// GetState returns kernel state of the clock
+func GetState(clockid int32) (*State, error) {
+	return nil, ErrNotSupported
+}
+
+// FrequencyPPB returns frequency adjustment of the clock in ppb
+func FrequencyPPB(clockid int32) (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// SetFrequencyPPB sets frequency adjustment of the clock in ppb
+func SetFrequencyPPB(clockid int32, ppb float64) error {
+	return ErrNotSupported
+}
+
+// Slew gradually corrects the clock by the offset
+func Slew(clockid int32, offset time.Duration) error {
+	return ErrNotSupported
+}
+
+// Step moves the clock by the offset at once
+func Step(clockid int32, offset time.Duration) error {
+	return ErrNotSupported
+}
+
+// SetLeap arms kernel to insert or delete a second at the next midnight UTC
+func SetLeap(clockid int32, leap Leap) error {
+	return ErrNotSupported
+}
+
+// TAI returns TAI-UTC offset of the clock in seconds
+func TAI(clockid int32) (int, error) {
+	return 0, ErrNotSupported
+}
+
+// SetTAI sets TAI-UTC offset of the clock in seconds
+func SetTAI(clockid int32, tai int) error {
+	return ErrNotSupported
+}
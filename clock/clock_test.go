@@ -0,0 +1,58 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrequencyConversion(t *testing.T) {
+	// 1 ppm is 65536 in kernel units
+	assert.Equal(t, int64(65536), ppbToFreq(1000))
+	assert.Equal(t, int64(-65536), ppbToFreq(-1000))
+	assert.InDelta(t, 1000.0, freqToPPB(65536), 1e-9)
+	assert.InDelta(t, 123.456, freqToPPB(ppbToFreq(123.456)), 0.01)
+}
+
+func TestSplitOffset(t *testing.T) {
+	tests := []struct {
+		offset time.Duration
+		sec    int64
+		nsec   int64
+	}{
+		{0, 0, 0},
+		{1500 * time.Millisecond, 1, 500000000},
+		{-1500 * time.Millisecond, -2, 500000000},
+		{-time.Second, -1, 0},
+		{-time.Nanosecond, -1, 999999999},
+	}
+	for _, tt := range tests {
+		sec, nsec := splitOffset(tt.offset)
+		assert.Equal(t, tt.sec, sec, tt.offset.String())
+		assert.Equal(t, tt.nsec, nsec, tt.offset.String())
+	}
+}
+
+func TestStateSynchronized(t *testing.T) {
+	assert.True(t, (&State{State: TimeOK}).Synchronized())
+	assert.True(t, (&State{State: TimeIns, Status: StatusPLL | StatusIns}).Synchronized())
+	assert.False(t, (&State{State: TimeOK, Status: StatusUnsync}).Synchronized())
+	assert.False(t, (&State{State: TimeError}).Synchronized())
+}
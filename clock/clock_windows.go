@@ -0,0 +1,145 @@
+// +build windows
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                           = windows.NewLazySystemDLL("kernel32.dll")
+	procGetSystemTimeAdjustmentPrecise = kernel32.NewProc("GetSystemTimeAdjustmentPrecise")
+	procSetSystemTimeAdjustmentPrecise = kernel32.NewProc("SetSystemTimeAdjustmentPrecise")
+	procSetSystemTime                  = kernel32.NewProc("SetSystemTime")
+	procFileTimeToSystemTime           = kernel32.NewProc("FileTimeToSystemTime")
+)
+
+// adjustment returns time adjustment and increment, which is how much the clock advances per clock update
+// when adjustment is disabled, both in 100ns units
+func adjustment() (adj, inc uint64, disabled bool, err error) {
+	var d int32
+	r, _, e := procGetSystemTimeAdjustmentPrecise.Call(
+		uintptr(unsafe.Pointer(&adj)), uintptr(unsafe.Pointer(&inc)), uintptr(unsafe.Pointer(&d)))
+	if r == 0 {
+		return 0, 0, false, fmt.Errorf("GetSystemTimeAdjustmentPrecise: %w", e)
+	}
+	return adj, inc, d != 0, nil
+}
+
+// checkClock only lets the system clock through, Windows doesn't have any other
+func checkClock(clockid int32) error {
+	if clockid != Realtime {
+		return ErrNotSupported
+	}
+	return nil
+}
+
+// GetState returns what Windows tells about the clock, which is its frequency adjustment
+func GetState(clockid int32) (*State, error) {
+	freq, err := FrequencyPPB(clockid)
+	if err != nil {
+		return nil, err
+	}
+	return &State{State: TimeOK, FrequencyPPB: freq}, nil
+}
+
+// FrequencyPPB returns frequency adjustment of the clock in ppb
+func FrequencyPPB(clockid int32) (float64, error) {
+	if err := checkClock(clockid); err != nil {
+		return 0, err
+	}
+	adj, inc, disabled, err := adjustment()
+	if err != nil {
+		return 0, err
+	}
+	if disabled || inc == 0 {
+		return 0, nil
+	}
+	return (float64(adj) - float64(inc)) / float64(inc) * 1e9, nil
+}
+
+// SetFrequencyPPB sets frequency adjustment of the clock in ppb by making the clock advance
+// more or less than the increment on every update
+func SetFrequencyPPB(clockid int32, ppb float64) error {
+	if err := checkClock(clockid); err != nil {
+		return err
+	}
+	if math.Abs(ppb) > MaxFrequencyPPB {
+		return fmt.Errorf("frequency %.3f ppb exceeds %.0f ppb", ppb, MaxFrequencyPPB)
+	}
+	_, inc, _, err := adjustment()
+	if err != nil {
+		return err
+	}
+	adj := uint64(math.Round(float64(inc) * (1 + ppb/1e9)))
+	args := []uintptr{uintptr(adj)}
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		// 32-bit Windows passes DWORD64 in two words
+		args = append(args, uintptr(adj>>32))
+	}
+	// adjustment is enabled
+	args = append(args, 0)
+	if r, _, e := procSetSystemTimeAdjustmentPrecise.Call(args...); r == 0 {
+		return fmt.Errorf("SetSystemTimeAdjustmentPrecise: %w", e)
+	}
+	return nil
+}
+
+// Slew is not supported, Windows has no kernel PLL. Offsets are slewed by adjusting frequency instead
+func Slew(clockid int32, offset time.Duration) error {
+	return ErrNotSupported
+}
+
+// Step moves the clock by the offset at once. Windows sets the time with millisecond resolution
+func Step(clockid int32, offset time.Duration) error {
+	if err := checkClock(clockid); err != nil {
+		return err
+	}
+	var ft windows.Filetime
+	windows.GetSystemTimePreciseAsFileTime(&ft)
+	ft = windows.NsecToFiletime(ft.Nanoseconds() + offset.Nanoseconds())
+	var st windows.Systemtime
+	if r, _, e := procFileTimeToSystemTime.Call(uintptr(unsafe.Pointer(&ft)), uintptr(unsafe.Pointer(&st))); r == 0 {
+		return fmt.Errorf("FileTimeToSystemTime: %w", e)
+	}
+	if r, _, e := procSetSystemTime.Call(uintptr(unsafe.Pointer(&st))); r == 0 {
+		return fmt.Errorf("SetSystemTime: %w", e)
+	}
+	return nil
+}
+
+// SetLeap is not supported, Windows applies leap seconds on its own
+func SetLeap(clockid int32, leap Leap) error {
+	return ErrNotSupported
+}
+
+// TAI is not supported
+func TAI(clockid int32) (int, error) {
+	return 0, ErrNotSupported
+}
+
+// SetTAI is not supported
+func SetTAI(clockid int32, tai int) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,40 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetState(t *testing.T) {
+	state, err := GetState(Realtime)
+	require.Nil(t, err)
+	freq, err := FrequencyPPB(Realtime)
+	require.Nil(t, err)
+	assert.Equal(t, state.FrequencyPPB, freq)
+}
+
+func TestOtherClocks(t *testing.T) {
+	_, err := FrequencyPPB(Realtime + 1)
+	assert.Equal(t, ErrNotSupported, err)
+	assert.Equal(t, ErrNotSupported, SetFrequencyPPB(Realtime+1, 0))
+	assert.Equal(t, ErrNotSupported, Step(Realtime+1, 0))
+	assert.NotNil(t, SetFrequencyPPB(Realtime, MaxFrequencyPPB+1))
+}
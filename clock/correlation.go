@@ -0,0 +1,112 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Clock IDs correlation works with, see clock_gettime(2)
+const (
+	// MonotonicRaw is CLOCK_MONOTONIC_RAW, hardware clock no adjustments apply to
+	MonotonicRaw int32 = 4
+	// TAIClock is CLOCK_TAI, the system clock ahead of UTC by TAI offset
+	TAIClock int32 = 11
+)
+
+// correlationTries is how many times the clocks are read to find the tightest snapshot
+const correlationTries = 5
+
+// Correlation is a snapshot of CLOCK_REALTIME, CLOCK_TAI and CLOCK_MONOTONIC_RAW taken at the same instant,
+// which lets measurements taken on one clock be expressed on another without reading the clocks again
+type Correlation struct {
+	Realtime time.Time
+	// TAIOffset is how far CLOCK_TAI is ahead of CLOCK_REALTIME, whole seconds
+	TAIOffset time.Duration
+	// MonotonicRaw is CLOCK_MONOTONIC_RAW reading
+	MonotonicRaw time.Duration
+	// Uncertainty is how long reading the clocks took
+	Uncertainty time.Duration
+	// Rate is how fast CLOCK_REALTIME runs relative to CLOCK_MONOTONIC_RAW, 1 unless measured by Correlator
+	Rate float64
+}
+
+// TAI returns CLOCK_TAI reading at the instant of the snapshot
+func (c *Correlation) TAI() time.Time {
+	return c.Realtime.Add(c.TAIOffset)
+}
+
+// RawToRealtime expresses CLOCK_MONOTONIC_RAW reading as CLOCK_REALTIME
+func (c *Correlation) RawToRealtime(raw time.Duration) time.Time {
+	return c.Realtime.Add(time.Duration(math.Round(float64(raw-c.MonotonicRaw) * c.Rate)))
+}
+
+// RealtimeToRaw expresses CLOCK_REALTIME reading as CLOCK_MONOTONIC_RAW
+func (c *Correlation) RealtimeToRaw(t time.Time) time.Duration {
+	return c.MonotonicRaw + time.Duration(math.Round(float64(t.Sub(c.Realtime))/c.Rate))
+}
+
+// RealtimeToTAI expresses CLOCK_REALTIME reading as CLOCK_TAI
+func (c *Correlation) RealtimeToTAI(t time.Time) time.Time {
+	return t.Add(c.TAIOffset)
+}
+
+// TAIToRealtime expresses CLOCK_TAI reading as CLOCK_REALTIME
+func (c *Correlation) TAIToRealtime(t time.Time) time.Time {
+	return t.Add(-c.TAIOffset)
+}
+
+// Correlator maintains correlation of the clocks, measuring the rate of CLOCK_REALTIME against
+// CLOCK_MONOTONIC_RAW between refreshes. It is safe for concurrent use
+type Correlator struct {
+	mu   sync.Mutex
+	last *Correlation
+}
+
+// Refresh takes a new snapshot of the clocks and returns it
+func (c *Correlator) Refresh() (*Correlation, error) {
+	snapshot, err := Correlate()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update(snapshot)
+	return snapshot, nil
+}
+
+// update sets the rate of the snapshot from the previous one. Stepped clock or TAI offset change leave the rate as is
+func (c *Correlator) update(snapshot *Correlation) {
+	if c.last != nil {
+		snapshot.Rate = c.last.Rate
+		raw := snapshot.MonotonicRaw - c.last.MonotonicRaw
+		rate := float64(snapshot.Realtime.Sub(c.last.Realtime)) / float64(raw)
+		if raw > 0 && math.Abs(rate-1) <= 2*MaxFrequencyPPB/1e9 {
+			snapshot.Rate = rate
+		}
+	}
+	c.last = snapshot
+}
+
+// Correlation returns the last snapshot, nil if there was none
+func (c *Correlator) Correlation() *Correlation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"fmt"
+	"time"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+func gettime(clockid int32) (time.Duration, error) {
+	var ts syscall.Timespec
+	if err := syscall.ClockGettime(clockid, &ts); err != nil {
+		return 0, fmt.Errorf("clock_gettime: %w", err)
+	}
+	return time.Duration(ts.Nano()), nil
+}
+
+// Correlate takes a snapshot of the clocks, reading them several times to find the tightest one.
+// CLOCK_MONOTONIC_RAW is read around the others and taken in the middle
+func Correlate() (*Correlation, error) {
+	var best *Correlation
+	for i := 0; i < correlationTries; i++ {
+		before, err := gettime(MonotonicRaw)
+		if err != nil {
+			return nil, err
+		}
+		realtime, err := gettime(Realtime)
+		if err != nil {
+			return nil, err
+		}
+		tai, err := gettime(TAIClock)
+		if err != nil {
+			return nil, err
+		}
+		after, err := gettime(MonotonicRaw)
+		if err != nil {
+			return nil, err
+		}
+		c := &Correlation{
+			Realtime:     time.Unix(0, int64(realtime)),
+			TAIOffset:    (tai - realtime).Round(time.Second),
+			MonotonicRaw: before + (after-before)/2,
+			Uncertainty:  after - before,
+			Rate:         1,
+		}
+		if best == nil || c.Uncertainty < best.Uncertainty {
+			best = c
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelate(t *testing.T) {
+	c, err := Correlate()
+	require.Nil(t, err)
+	assert.Equal(t, time.Duration(0), c.TAIOffset%time.Second)
+	assert.GreaterOrEqual(t, c.TAIOffset, time.Duration(0))
+	assert.Less(t, c.Uncertainty, time.Millisecond)
+
+	raw, err := gettime(MonotonicRaw)
+	require.Nil(t, err)
+	assert.InDelta(t, float64(time.Now().UnixNano()), float64(c.RawToRealtime(raw).UnixNano()), float64(10*time.Millisecond))
+
+	var correlator Correlator
+	first, err := correlator.Refresh()
+	require.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	second, err := correlator.Refresh()
+	require.Nil(t, err)
+	assert.Equal(t, 1.0, first.Rate)
+	assert.InDelta(t, 1.0, second.Rate, 0.001)
+	assert.Equal(t, second, correlator.Correlation())
+}
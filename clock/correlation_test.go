@@ -0,0 +1,51 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationConversions(t *testing.T) {
+	realtime := time.Unix(1600000000, 0)
+	c := &Correlation{Realtime: realtime, TAIOffset: 37 * time.Second, MonotonicRaw: time.Hour, Rate: 1.0001}
+	assert.Equal(t, realtime.Add(37*time.Second), c.TAI())
+	assert.Equal(t, realtime.Add(10001*time.Microsecond), c.RawToRealtime(time.Hour+10*time.Millisecond))
+	assert.Equal(t, time.Hour+10*time.Millisecond, c.RealtimeToRaw(realtime.Add(10001*time.Microsecond)))
+	assert.Equal(t, realtime.Add(-10001*time.Microsecond), c.RawToRealtime(time.Hour-10*time.Millisecond))
+	tai := c.RealtimeToTAI(realtime.Add(time.Minute))
+	assert.Equal(t, realtime.Add(time.Minute+37*time.Second), tai)
+	assert.Equal(t, realtime.Add(time.Minute), c.TAIToRealtime(tai))
+}
+
+func TestCorrelatorRate(t *testing.T) {
+	realtime := time.Unix(1600000000, 0)
+	c := &Correlator{}
+	c.update(&Correlation{Realtime: realtime, MonotonicRaw: time.Hour, Rate: 1})
+	assert.Equal(t, 1.0, c.Correlation().Rate)
+
+	// realtime runs 20ppm fast
+	c.update(&Correlation{Realtime: realtime.Add(1000020 * time.Microsecond), MonotonicRaw: time.Hour + time.Second, Rate: 1})
+	assert.InDelta(t, 1.00002, c.Correlation().Rate, 1e-12)
+
+	// stepped clock keeps the rate
+	c.update(&Correlation{Realtime: realtime.Add(time.Hour), MonotonicRaw: time.Hour + 2*time.Second, Rate: 1})
+	assert.InDelta(t, 1.00002, c.Correlation().Rate, 1e-12)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// fdToClockID returns dynamic POSIX clock ID of the open character device, see FD_TO_CLOCKID in clock_gettime(2)
+func fdToClockID(fd uintptr) int32 {
+	return int32((^fd << 3) | 3)
+}
+
+// PHC is PTP hardware clock of a NIC, steered with clock_adjtime like the system clock
+type PHC struct {
+	f  *os.File
+	id int32
+}
+
+// OpenPHC opens PTP hardware clock device, e.g. /dev/ptp0
+func OpenPHC(device string) (*PHC, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &PHC{f: f, id: fdToClockID(f.Fd())}, nil
+}
+
+// Close closes the device
+func (p *PHC) Close() error {
+	return p.f.Close()
+}
+
+// Now reads the clock
+func (p *PHC) Now() (time.Time, error) {
+	var ts syscall.Timespec
+	if err := syscall.ClockGettime(p.id, &ts); err != nil {
+		return time.Time{}, fmt.Errorf("clock_gettime on %s: %w", p.f.Name(), err)
+	}
+	return time.Unix(ts.Unix()), nil
+}
+
+// Step moves the clock by the offset at once
+func (p *PHC) Step(offset time.Duration) error {
+	return Step(p.id, offset)
+}
+
+// FrequencyPPB returns frequency adjustment of the clock
+func (p *PHC) FrequencyPPB() (float64, error) {
+	return FrequencyPPB(p.id)
+}
+
+// SetFrequencyPPB sets frequency adjustment of the clock
+func (p *PHC) SetFrequencyPPB(ppb float64) error {
+	return SetFrequencyPPB(p.id, ppb)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFDToClockID(t *testing.T) {
+	assert.Equal(t, int32(-29), fdToClockID(3))
+	assert.Equal(t, int32(-77), fdToClockID(9))
+}
+
+func TestOpenPHCNoDevice(t *testing.T) {
+	_, err := OpenPHC("/dev/nonexistent-ptp")
+	assert.NotNil(t, err)
+}
+
+func TestSystem(t *testing.T) {
+	var c Clock = System{}
+	now, err := c.Now()
+	require.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), now, time.Second)
+	freq, err := c.FrequencyPPB()
+	require.Nil(t, err)
+	expected, err := FrequencyPPB(Realtime)
+	require.Nil(t, err)
+	assert.Equal(t, expected, freq)
+}
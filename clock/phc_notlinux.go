@@ -0,0 +1,56 @@
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"time"
+)
+
+// PHC is PTP hardware clock of a NIC
+type PHC struct{}
+
+// OpenPHC opens PTP hardware clock device
+func OpenPHC(device string) (*PHC, error) {
+	return nil, ErrNotSupported
+}
+
+// Close closes the device
+func (p *PHC) Close() error {
+	return ErrNotSupported
+}
+
+// Now reads the clock
+func (p *PHC) Now() (time.Time, error) {
+	return time.Time{}, ErrNotSupported
+}
+
+// Step moves the clock by the offset at once
+func (p *PHC) Step(offset time.Duration) error {
+	return ErrNotSupported
+}
+
+// FrequencyPPB returns frequency adjustment of the clock
+func (p *PHC) FrequencyPPB() (float64, error) {
+	return 0, ErrNotSupported
+}
+
+// SetFrequencyPPB sets frequency adjustment of the clock
+func (p *PHC) SetFrequencyPPB(ppb float64) error {
+	return ErrNotSupported
+}
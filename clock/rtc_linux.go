@@ -0,0 +1,56 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// rtcTime converts time to struct rtc_time, which is broken down UTC like struct tm
+func rtcTime(t time.Time) *syscall.RTCTime {
+	t = t.UTC()
+	return &syscall.RTCTime{
+		Sec:  int32(t.Second()),
+		Min:  int32(t.Minute()),
+		Hour: int32(t.Hour()),
+		Mday: int32(t.Day()),
+		Mon:  int32(t.Month()) - 1,
+		Year: int32(t.Year()) - 1900,
+		Wday: int32(t.Weekday()),
+		Yday: int32(t.YearDay()) - 1,
+	}
+}
+
+// SetRTC sets hardware clock, e.g. /dev/rtc0, to the system time in UTC. RTC only keeps whole seconds,
+// so it is set at the start of the next second
+func SetRTC(device string) error {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	next := time.Now().Truncate(time.Second).Add(time.Second)
+	time.Sleep(time.Until(next))
+	if err := syscall.IoctlSetRTCTime(int(f.Fd()), rtcTime(next)); err != nil {
+		return fmt.Errorf("RTC_SET_TIME on %s: %w", device, err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	syscall "golang.org/x/sys/unix"
+)
+
+func TestRTCTime(t *testing.T) {
+	tm := time.Date(2020, time.March, 1, 13, 14, 15, 999, time.FixedZone("PST", -8*3600))
+	assert.Equal(t, &syscall.RTCTime{Sec: 15, Min: 14, Hour: 21, Mday: 1, Mon: 2, Year: 120, Wday: 0, Yday: 60}, rtcTime(tm))
+}
+
+func TestSetRTCNoDevice(t *testing.T) {
+	assert.NotNil(t, SetRTC("/dev/nonexistent-rtc"))
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"time"
+)
+
+// Clock is a clock which can be read and steered, like the system clock or PTP hardware clock of a NIC
+type Clock interface {
+	// Now reads the clock
+	Now() (time.Time, error)
+	// Step moves the clock by the offset at once
+	Step(offset time.Duration) error
+	// FrequencyPPB returns frequency adjustment of the clock
+	FrequencyPPB() (float64, error)
+	// SetFrequencyPPB sets frequency adjustment of the clock
+	SetFrequencyPPB(ppb float64) error
+}
+
+// System is the system clock
+type System struct{}
+
+// Now reads the system clock
+func (System) Now() (time.Time, error) {
+	return time.Now(), nil
+}
+
+// Step moves the system clock by the offset at once
+func (System) Step(offset time.Duration) error {
+	return Step(Realtime, offset)
+}
+
+// FrequencyPPB returns frequency adjustment of the system clock
+func (System) FrequencyPPB() (float64, error) {
+	return FrequencyPPB(Realtime)
+}
+
+// SetFrequencyPPB sets frequency adjustment of the system clock
+func (System) SetFrequencyPPB(ppb float64) error {
+	return SetFrequencyPPB(Realtime, ppb)
+}
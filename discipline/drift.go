@@ -0,0 +1,69 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadDriftFile returns frequency correction in PPB kept in the drift file.
+// The file holds it in PPM as text, same as ntpd and chrony do
+func ReadDriftFile(path string) (float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// chrony adds skew after the frequency
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty drift file %s", path)
+	}
+	ppm, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed drift file %s: %w", path, err)
+	}
+	ppb := ppm * 1000
+	if ppb > maxFreq*1e9 || ppb < -maxFreq*1e9 {
+		return 0, fmt.Errorf("drift file %s frequency %.3f PPM is out of range", path, ppm)
+	}
+	return ppb, nil
+}
+
+// WriteDriftFile saves frequency correction in PPB to the drift file, replacing it atomically
+func WriteDriftFile(path string, ppb float64) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".drift")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintf(tmp, "%.3f\n", ppb/1000); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriftFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drift")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ntp.drift")
+
+	_, err = ReadDriftFile(path)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, WriteDriftFile(path, -12345.678))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "-12.346\n", string(data))
+	ppb, err := ReadDriftFile(path)
+	require.NoError(t, err)
+	require.InDelta(t, -12346.0, ppb, 1e-6)
+
+	// chrony format
+	require.NoError(t, ioutil.WriteFile(path, []byte("  1.500000   0.012\n"), 0644))
+	ppb, err = ReadDriftFile(path)
+	require.NoError(t, err)
+	require.InDelta(t, 1500.0, ppb, 1e-6)
+
+	for _, content := range []string{"", "\n", "fast", "600"} {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		_, err = ReadDriftFile(path)
+		require.Error(t, err, content)
+	}
+}
+
+func TestLoopDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drift")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ntp.drift")
+	require.NoError(t, WriteDriftFile(path, 20000))
+
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.DriftFile = path
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, l.Run(ctx), context.Canceled)
+	require.Equal(t, StateFSET, l.State())
+	require.InDelta(t, 20000.0, l.FrequencyPPB(), 1e-6)
+	require.InDelta(t, 20000.0, c.ppb, 1e-6)
+
+	// frequency is known, no need to measure it
+	action, err := l.Update(time.Millisecond, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	require.Equal(t, StateSYNC, l.State())
+
+	require.NoError(t, l.SetFrequencyPPB(-3000))
+	require.NoError(t, l.saveDrift())
+	ppb, err := ReadDriftFile(path)
+	require.NoError(t, err)
+	require.InDelta(t, -3000.0, ppb, 1e-6)
+
+	require.Error(t, l.SetFrequencyPPB(600000))
+}
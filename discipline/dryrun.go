@@ -0,0 +1,127 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+	"github.com/facebookincubator/ntp/logging"
+)
+
+// virtualClock is implemented by clocks the loop doesn't actually steer. Offsets measured against
+// the system clock are corrected by what the loop would have done to it
+type virtualClock interface {
+	Offset(measured time.Duration, at time.Time) time.Duration
+}
+
+// DryRunStats is what the loop would have done to the clock
+type DryRunStats struct {
+	Steps int
+	// Stepped is the sum of the steps
+	Stepped      time.Duration
+	FrequencyPPB float64
+	Leap         clock.Leap
+	TAI          int
+}
+
+// DryRun is the clock the loop runs against without touching the system clock, for evaluation on production hosts.
+// It logs what the loop does and keeps track of the clock the loop would have steered. It is safe for concurrent use
+type DryRun struct {
+	// Logger receives what the loop would have done, slog.Default() if not set
+	Logger logging.Logger
+
+	mu    sync.Mutex
+	stats DryRunStats
+	// phase is how far virtual clock is ahead of the system one as of set, seconds
+	phase float64
+	set   time.Time
+	now   func() time.Time
+}
+
+// NewDryRun returns virtual clock running with the system one
+func NewDryRun() *DryRun {
+	return &DryRun{now: time.Now}
+}
+
+// advance accounts for virtual clock running at its frequency since the last change, as of now
+func (d *DryRun) advance(now time.Time) {
+	if !d.set.IsZero() {
+		d.phase += d.stats.FrequencyPPB / 1e9 * now.Sub(d.set).Seconds()
+	}
+	d.set = now
+}
+
+// Step logs the step and moves virtual clock
+func (d *DryRun) Step(offset time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logging.Or(d.Logger).Info("Dry run: would step the clock", "offset", offset)
+	d.advance(d.now())
+	d.phase += offset.Seconds()
+	d.stats.Steps++
+	d.stats.Stepped += offset
+	return nil
+}
+
+// SetFrequencyPPB sets frequency of virtual clock. Loop does so every second, so it's only logged at debug level
+func (d *DryRun) SetFrequencyPPB(ppb float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logging.Or(d.Logger).Debug("Dry run: would set frequency", "ppb", ppb)
+	d.advance(d.now())
+	d.stats.FrequencyPPB = ppb
+	return nil
+}
+
+// SetLeap logs arming the kernel for the leap second
+func (d *DryRun) SetLeap(leap clock.Leap) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logging.Or(d.Logger).Info("Dry run: would arm kernel for leap second", "leap", leap)
+	d.stats.Leap = leap
+	return nil
+}
+
+// SetTAI logs setting kernel TAI offset
+func (d *DryRun) SetTAI(tai int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logging.Or(d.Logger).Info("Dry run: would set TAI offset", "tai", tai)
+	d.stats.TAI = tai
+	return nil
+}
+
+// Offset returns offset of virtual clock given the one measured against the system clock at the given time
+func (d *DryRun) Offset(measured time.Duration, at time.Time) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	phase := d.phase
+	if !d.set.IsZero() {
+		phase += d.stats.FrequencyPPB / 1e9 * at.Sub(d.set).Seconds()
+	}
+	return measured - time.Duration(math.Round(phase*float64(time.Second)))
+}
+
+// Stats returns what the loop did to virtual clock so far
+func (d *DryRun) Stats() DryRunStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	now := start
+	d := NewDryRun()
+	d.now = func() time.Time { return now }
+
+	require.NoError(t, d.Step(time.Second))
+	require.Equal(t, time.Duration(0), d.Offset(time.Second, now))
+	require.NoError(t, d.SetFrequencyPPB(1000))
+	now = now.Add(1000 * time.Second)
+	require.Equal(t, -time.Millisecond, d.Offset(time.Second, now))
+	require.NoError(t, d.SetFrequencyPPB(0))
+	require.Equal(t, -time.Millisecond, d.Offset(time.Second, now.Add(time.Hour)))
+	require.NoError(t, d.SetLeap(clock.LeapInsert))
+	require.NoError(t, d.SetTAI(37))
+	require.Equal(t, DryRunStats{Steps: 1, Stepped: time.Second, Leap: clock.LeapInsert, TAI: 37}, d.Stats())
+}
+
+func TestLoopDryRun(t *testing.T) {
+	// system clock is 50ms behind and 20ppm slow, and stays so
+	system := &fakeClock{drift: -20e-6, phase: -0.05}
+	now := start
+	d := NewDryRun()
+	d.now = func() time.Time { return now }
+	l := NewLoop(d)
+	next := 0
+	for sec := 0; sec < 2*86400; sec++ {
+		now = start.Add(time.Duration(sec) * time.Second)
+		if sec == next {
+			_, err := l.Update(system.offset(), now)
+			require.NoError(t, err)
+			next += 1 << l.Poll()
+		}
+		require.NoError(t, l.Tick())
+		system.advance()
+	}
+	require.Equal(t, 0.0, system.ppb)
+	require.Equal(t, StateSYNC, l.State())
+	stats := d.Stats()
+	require.Equal(t, 0, stats.Steps)
+	require.InDelta(t, 20000, stats.FrequencyPPB, 200)
+	// the loop would have steered the clock all right
+	require.Less(t, math.Abs(d.Offset(system.offset(), now).Seconds()), 0.001)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"fmt"
+	"math"
+)
+
+// Filter turns offsets the synchronized loop measures into clock corrections
+type Filter interface {
+	// Update takes the offset along with what was left to slew of the previous one, interval since
+	// the previous update, all in seconds, and poll exponent. It returns frequency correction to add, s/s,
+	// and the offset to slew
+	Update(offset, residual, interval float64, poll int) (freq, phase float64)
+}
+
+// Slewer is implemented by filters which decide how fast offsets are slewed
+type Slewer interface {
+	// Slew returns frequency to run the clock at for the next second, s/s, given frequency correction, s/s,
+	// the offset left to slew, s, and poll exponent
+	Slew(freq, offset float64, poll int) float64
+}
+
+// Filter names NewFilter takes
+const (
+	FilterPLL    = "pll"
+	FilterKalman = "kalman"
+	FilterPI     = "pi"
+)
+
+// NewFilter returns filter by name, empty name is PLL
+func NewFilter(name string) (Filter, error) {
+	switch name {
+	case "", FilterPLL:
+		return PLL{}, nil
+	case FilterKalman:
+		return &Kalman{}, nil
+	case FilterPI:
+		return PI{}, nil
+	}
+	return nil, fmt.Errorf("unknown discipline filter %q", name)
+}
+
+// PLL gains are those of ntpd, where time constant is expressed in seconds
+const (
+	pllGain = 16   // PLL loop gain
+	fllGain = 0.25 // FLL loop gain
+)
+
+// PLL is RFC 5905 hybrid phase/frequency-locked loop: PLL at short poll intervals, joined by FLL at long ones
+type PLL struct{}
+
+// Update returns PLL and FLL frequency corrections, the whole offset is slewed
+func (PLL) Update(offset, residual, interval float64, poll int) (freq, phase float64) {
+	tc := math.Ldexp(1, poll)
+	// FLL takes over from PLL when poll interval exceeds Allan intercept
+	if tc > allan/2 {
+		freq += (offset - residual) / math.Max(tc, interval) * fllGain
+	}
+	d := 4 * pllGain * tc
+	freq += offset * math.Min(tc, interval) / (d * d)
+	return freq, offset
+}
+
+// Slew slews the share of the offset inversely proportional to the loop time constant
+func (PLL) Slew(freq, offset float64, poll int) float64 {
+	tc := math.Min(math.Ldexp(1, poll), allan)
+	return freq + offset/(pllGain*tc)
+}
+
+// PI servo defaults are those of linuxptp with software timestamping: constants scale with the interval
+// between updates, but never so much that a single update overshoots
+const (
+	piKPScale    = 0.1
+	piKPExponent = -0.3
+	piKPNormMax  = 0.7
+	piKIScale    = 0.001
+	piKIExponent = 0.4
+	piKINormMax  = 0.3
+)
+
+// PI is proportional-integral servo as linuxptp has it: proportional term slews the offset, integral one
+// accumulates into frequency correction. It is lighter than PLL and easier to tune
+type PI struct {
+	// KP and KI are proportional and integral constants, per second. They are derived from the interval
+	// between updates the way linuxptp does if not set
+	KP float64
+	KI float64
+	// MaxFrequencyPPB clamps frequency the servo runs the clock at, clock maximum if not set
+	MaxFrequencyPPB float64
+}
+
+// gains returns proportional and integral constants for the interval between updates, seconds
+func (p PI) gains(interval float64) (kp, ki float64) {
+	kp, ki = p.KP, p.KI
+	if kp == 0 {
+		kp = piKPScale * math.Pow(interval, piKPExponent)
+	}
+	if ki == 0 {
+		ki = piKIScale * math.Pow(interval, piKIExponent)
+	}
+	return math.Min(kp, piKPNormMax/interval), math.Min(ki, piKINormMax/interval)
+}
+
+// Update returns integral term as frequency correction, the whole offset is left to the proportional term
+func (p PI) Update(offset, residual, interval float64, poll int) (freq, phase float64) {
+	_, ki := p.gains(math.Ldexp(1, poll))
+	return ki * offset, offset
+}
+
+// Slew returns frequency correction along with proportional term, clamped
+func (p PI) Slew(freq, offset float64, poll int) float64 {
+	kp, _ := p.gains(math.Ldexp(1, poll))
+	adj := freq + kp*offset
+	if p.MaxFrequencyPPB != 0 {
+		max := p.MaxFrequencyPPB / 1e9
+		adj = math.Max(math.Min(adj, max), -max)
+	}
+	return adj
+}
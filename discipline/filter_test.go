@@ -0,0 +1,114 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFilter(t *testing.T) {
+	f, err := NewFilter("")
+	require.NoError(t, err)
+	require.Equal(t, PLL{}, f)
+	f, err = NewFilter(FilterKalman)
+	require.NoError(t, err)
+	require.IsType(t, &Kalman{}, f)
+	f, err = NewFilter(FilterPI)
+	require.NoError(t, err)
+	require.Equal(t, PI{}, f)
+	_, err = NewFilter("magic")
+	require.Error(t, err)
+}
+
+// simulate runs the loop for days against 20ppm slow clock, measuring offsets with uniform noise.
+// It returns RMS offset of the clock after the first day and the frequency the loop ended up with
+func simulate(t *testing.T, f Filter, noise float64) (rms, ppb float64) {
+	r := rand.New(rand.NewSource(1))
+	c := &fakeClock{drift: -20e-6}
+	l := NewLoop(c)
+	l.Filter = f
+	next := 0
+	var sum float64
+	var n int
+	for sec := 0; sec < 4*86400; sec++ {
+		if sec == next {
+			measured := -c.phase + (r.Float64()*2-1)*noise
+			_, err := l.Update(time.Duration(measured*float64(time.Second)), start.Add(time.Duration(sec)*time.Second))
+			require.NoError(t, err)
+			next += 1 << l.Poll()
+		}
+		require.NoError(t, l.Tick())
+		c.advance()
+		if sec > 86400 {
+			sum += c.phase * c.phase
+			n++
+		}
+	}
+	require.Empty(t, c.steps)
+	return math.Sqrt(sum / float64(n)), l.FrequencyPPB()
+}
+
+func TestKalmanNoisyNetwork(t *testing.T) {
+	noise := 0.05
+	pllRMS, _ := simulate(t, PLL{}, noise)
+	// standard deviation of the uniform noise
+	k := &Kalman{MeasurementNoise: time.Duration(noise / math.Sqrt(3) * float64(time.Second))}
+	kalmanRMS, ppb := simulate(t, k, noise)
+	require.Less(t, kalmanRMS, pllRMS/2)
+	require.InDelta(t, 20000, ppb, 200)
+}
+
+func TestKalmanQuietNetwork(t *testing.T) {
+	rms, ppb := simulate(t, &Kalman{}, 0.0001)
+	require.Less(t, rms, 0.0001)
+	require.InDelta(t, 20000, ppb, 10)
+}
+
+func TestPIGains(t *testing.T) {
+	kp, ki := PI{}.gains(1)
+	require.InDelta(t, 0.1, kp, 1e-9)
+	require.InDelta(t, 0.001, ki, 1e-9)
+	// single update must not overshoot
+	kp, ki = PI{}.gains(64)
+	require.InDelta(t, 0.7/64, kp, 1e-9)
+	require.InDelta(t, 0.3/64, ki, 1e-9)
+	kp, ki = PI{KP: 0.001, KI: 0.0001}.gains(64)
+	require.Equal(t, 0.001, kp)
+	require.Equal(t, 0.0001, ki)
+}
+
+func TestPISlew(t *testing.T) {
+	p := PI{KP: 0.01, KI: 0.001}
+	freq, phase := p.Update(0.002, 0, 64, 6)
+	require.InDelta(t, 2e-6, freq, 1e-12)
+	require.Equal(t, 0.002, phase)
+	require.InDelta(t, 1e-5+2e-5, p.Slew(1e-5, 0.002, 6), 1e-12)
+	p.MaxFrequencyPPB = 15000
+	require.InDelta(t, 1.5e-5, p.Slew(1e-5, 0.002, 6), 1e-12)
+	require.InDelta(t, -1.5e-5, p.Slew(-1e-5, -0.002, 6), 1e-12)
+}
+
+func TestPIConverges(t *testing.T) {
+	rms, ppb := simulate(t, PI{}, 0.0001)
+	require.Less(t, rms, 0.0001)
+	require.InDelta(t, 20000, ppb, 100)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"time"
+)
+
+// Kalman filter defaults
+const (
+	// DefaultMeasurementNoise is standard deviation of offsets measured over a network
+	DefaultMeasurementNoise = time.Millisecond
+	// DefaultFrequencyNoise is random walk of oscillator frequency, s/s per square root of second
+	DefaultFrequencyNoise = 1e-10
+	// phaseNoise is random walk of oscillator phase, s per square root of second
+	phaseNoise = 1e-7
+)
+
+// Kalman is Kalman filter over offset and frequency error of the clock. It weighs offsets by how much
+// they can be trusted rather than by fixed loop gains, so noisy and asymmetric networks throw it off less than PLL
+type Kalman struct {
+	// MeasurementNoise is DefaultMeasurementNoise if not set
+	MeasurementNoise time.Duration
+	// FrequencyNoise is DefaultFrequencyNoise if not set
+	FrequencyNoise float64
+
+	// p is covariance of offset and frequency error estimates
+	p [2][2]float64
+}
+
+// Update returns the estimates of frequency error and offset. Both are corrected right away,
+// so the filter predicts the residual as the offset and no frequency error
+func (k *Kalman) Update(offset, residual, interval float64, poll int) (freq, phase float64) {
+	r := k.MeasurementNoise.Seconds()
+	if r == 0 {
+		r = DefaultMeasurementNoise.Seconds()
+	}
+	r *= r
+	q := k.FrequencyNoise
+	if q == 0 {
+		q = DefaultFrequencyNoise
+	}
+	q *= q
+	p := &k.p
+	if p[0][0] == 0 {
+		// frequency was measured in FREQ state, over stepout and with the same noise
+		p[0][0] = r
+		p[1][1] = 2 * r / (DefaultStepout.Seconds() * DefaultStepout.Seconds())
+	}
+	// predict: offset grows with frequency error over the interval
+	dt := interval
+	p[0][0] += dt*(p[0][1]+p[1][0]) + dt*dt*p[1][1] + phaseNoise*phaseNoise*dt
+	p[0][1] += dt * p[1][1]
+	p[1][0] += dt * p[1][1]
+	p[1][1] += q * dt
+
+	// update with the measured offset
+	s := p[0][0] + r
+	k0 := p[0][0] / s
+	k1 := p[1][0] / s
+	innovation := offset - residual
+	phase = residual + k0*innovation
+	freq = k1 * innovation
+	p00, p01 := p[0][0], p[0][1]
+	p[0][0] -= k0 * p00
+	p[0][1] -= k0 * p01
+	p[1][0] -= k1 * p00
+	p[1][1] -= k1 * p01
+	return freq, phase
+}
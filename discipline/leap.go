@@ -0,0 +1,233 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+	"github.com/facebookincubator/ntp/events"
+)
+
+// LeapMode is how pending leap second is applied
+type LeapMode int
+
+// Leap modes
+const (
+	// LeapModeKernel arms the kernel, which inserts or deletes the second at midnight UTC
+	LeapModeKernel LeapMode = iota
+	// LeapModeSmear never arms the kernel, the second is smeared into the time around the leap instead,
+	// so the clock never shows 23:59:60 even if upstream servers don't smear
+	LeapModeSmear
+)
+
+// DefaultSmearWindow spreads the leap second from noon to noon UTC around it, the way public smearing servers do
+const DefaultSmearWindow = 24 * time.Hour
+
+// LeapClock is the clock kernel leap second is armed on
+type LeapClock interface {
+	SetLeap(leap clock.Leap) error
+	SetTAI(tai int) error
+}
+
+// SetLeap arms the kernel to insert or delete a second at the next midnight UTC
+func (SystemClock) SetLeap(leap clock.Leap) error {
+	return clock.SetLeap(clock.Realtime, leap)
+}
+
+// SetTAI sets kernel TAI-UTC offset, which CLOCK_TAI relies on
+func (SystemClock) SetTAI(tai int) error {
+	return clock.SetTAI(clock.Realtime, tai)
+}
+
+// EndOfMonth returns the instant leap second announced by NTP leap indicator at t happens at,
+// which is the end of the last day of the month
+func EndOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// LeapArmer arms the kernel for pending leap second on the day of the leap and disarms it afterwards,
+// keeping kernel TAI-UTC offset up to date. Leap is scheduled from leap indicator of upstream servers
+// or from leap seconds file. It is safe for concurrent use
+type LeapArmer struct {
+	// Mode is LeapModeKernel if not set
+	Mode LeapMode
+	// SmearWindow is how long LeapModeSmear spreads the leap second over, centered on it. DefaultSmearWindow if not set
+	SmearWindow time.Duration
+	// Events receives leap seconds armed in the kernel. May be nil
+	Events *events.Bus
+
+	clock LeapClock
+	mu    sync.Mutex
+	leap  clock.Leap
+	at    time.Time
+	armed clock.Leap
+	file  *LeapFile
+	// last leap and its instant are kept until its smear is over
+	last   clock.Leap
+	lastAt time.Time
+	// tai is TAI-UTC offset when there is no file, 0 if unknown. kernelTAI is what kernel was set to
+	tai       int
+	kernelTAI int
+}
+
+// NewLeapArmer returns armer of the clock with no leap pending
+func NewLeapArmer(c LeapClock) *LeapArmer {
+	return &LeapArmer{clock: c}
+}
+
+// Schedule sets leap second pending at the given instant, which is midnight UTC. LeapNone cancels pending leap
+func (a *LeapArmer) Schedule(leap clock.Leap, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.leap = leap
+	a.at = at
+}
+
+// ScheduleFile schedules the next leap second listed in leap seconds file as of now and keeps the file to tell
+// TAI-UTC offset. Expired file can't be trusted to list all upcoming leaps, so it is refused
+func (a *LeapArmer) ScheduleFile(f *LeapFile, now time.Time) error {
+	if f.Expired(now) {
+		return fmt.Errorf("%w on %s", ErrLeapFileExpired, f.Expires.Format("2006-01-02"))
+	}
+	leap, at := f.Next(now)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file = f
+	a.leap = leap
+	a.at = at
+	return nil
+}
+
+// AnnounceTAI sets current TAI-UTC offset learned elsewhere than from leap seconds file. The armer follows
+// leap seconds from there on and sets kernel TAI offset on the next Update
+func (a *LeapArmer) AnnounceTAI(tai int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tai = tai
+}
+
+// TAI returns TAI-UTC offset in seconds at t according to the leap seconds file, or the announced one
+// if there is no file. 0 means it is unknown
+func (a *LeapArmer) TAI(t time.Time) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.taiAt(t)
+}
+
+func (a *LeapArmer) taiAt(t time.Time) int {
+	if a.file == nil {
+		return a.tai
+	}
+	return a.file.TAI(t)
+}
+
+// Pending returns scheduled leap second along with its instant, LeapNone if there is none
+func (a *LeapArmer) Pending() (clock.Leap, time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.leap, a.at
+}
+
+// Update arms or disarms the kernel as of now and sets its TAI offset if it changed. Kernel applies the leap at the end of the day it's armed on,
+// so it is only armed within the last day before the leap
+func (a *LeapArmer) Update(now time.Time) error {
+	armed, err := a.update(now)
+	if armed != clock.LeapNone {
+		reason := "insert"
+		if armed == clock.LeapDelete {
+			reason = "delete"
+		}
+		a.Events.Publish(events.Event{Time: now, Kind: events.LeapArmed, Reason: reason})
+	}
+	return err
+}
+
+// update does what Update does, returning the leap it armed the kernel with, LeapNone if it didn't
+func (a *LeapArmer) update(now time.Time) (clock.Leap, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.leap != clock.LeapNone && !now.Before(a.at) {
+		// the leap is over, the file may well list the next one
+		a.last, a.lastAt = a.leap, a.at
+		switch {
+		case a.tai == 0:
+		case a.leap == clock.LeapInsert:
+			a.tai++
+		case a.leap == clock.LeapDelete:
+			a.tai--
+		}
+		a.leap = clock.LeapNone
+		if a.file != nil {
+			a.leap, a.at = a.file.Next(now)
+		}
+	}
+	if tai := a.taiAt(now); tai != 0 && tai != a.kernelTAI {
+		if err := a.clock.SetTAI(tai); err != nil {
+			return clock.LeapNone, err
+		}
+		a.kernelTAI = tai
+	}
+	want := clock.LeapNone
+	if a.Mode == LeapModeKernel && a.leap != clock.LeapNone && a.at.Sub(now) <= 24*time.Hour {
+		want = a.leap
+	}
+	if want == a.armed {
+		return clock.LeapNone, nil
+	}
+	if err := a.clock.SetLeap(want); err != nil {
+		return clock.LeapNone, err
+	}
+	a.armed = want
+	return want, nil
+}
+
+// Smear returns how far smeared time is ahead of UTC at t in LeapModeSmear, 0 otherwise.
+// Adding it to offsets measured against servers which don't smear steers the clock to smeared time
+func (a *LeapArmer) Smear(t time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Mode != LeapModeSmear {
+		return 0
+	}
+	window := a.SmearWindow
+	if window == 0 {
+		window = DefaultSmearWindow
+	}
+	return SmearOffset(a.leap, a.at, window, t) + SmearOffset(a.last, a.lastAt, window, t)
+}
+
+// SmearOffset returns how far smeared time is ahead of UTC at t for the leap second at the given instant.
+// The second is spread linearly over the window centered on the leap
+func SmearOffset(leap clock.Leap, at time.Time, window time.Duration, t time.Time) time.Duration {
+	begin := at.Add(-window / 2)
+	if leap == clock.LeapNone || t.Before(begin) || !t.Before(begin.Add(window)) {
+		return 0
+	}
+	// inserted second makes smeared clock run slow: it falls behind UTC until UTC repeats the second
+	offset := -time.Duration(float64(time.Second) * float64(t.Sub(begin)) / float64(window))
+	if !t.Before(at) {
+		offset += time.Second
+	}
+	if leap == clock.LeapDelete {
+		return -offset
+	}
+	return offset
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+	"github.com/facebookincubator/ntp/events"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLeapClock struct {
+	leaps []clock.Leap
+	tai   []int
+	err   error
+}
+
+func (c *fakeLeapClock) SetLeap(leap clock.Leap) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.leaps = append(c.leaps, leap)
+	return nil
+}
+
+func (c *fakeLeapClock) SetTAI(tai int) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.tai = append(c.tai, tai)
+	return nil
+}
+
+func TestEndOfMonth(t *testing.T) {
+	require.Equal(t, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), EndOfMonth(time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC)))
+	require.Equal(t, time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC), EndOfMonth(time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestLeapArmer(t *testing.T) {
+	c := &fakeLeapClock{}
+	a := NewLeapArmer(c)
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.Schedule(clock.LeapInsert, leap)
+
+	// too early, kernel would insert the second at the end of today
+	require.NoError(t, a.Update(leap.Add(-25*time.Hour)))
+	require.Empty(t, c.leaps)
+
+	require.NoError(t, a.Update(leap.Add(-24*time.Hour)))
+	require.Equal(t, []clock.Leap{clock.LeapInsert}, c.leaps)
+	// armed once
+	require.NoError(t, a.Update(leap.Add(-time.Second)))
+	require.Len(t, c.leaps, 1)
+
+	require.NoError(t, a.Update(leap))
+	require.Equal(t, []clock.Leap{clock.LeapInsert, clock.LeapNone}, c.leaps)
+	pending, _ := a.Pending()
+	require.Equal(t, clock.LeapNone, pending)
+}
+
+func TestLeapArmerEvents(t *testing.T) {
+	a := NewLeapArmer(&fakeLeapClock{})
+	a.Events = &events.Bus{}
+	var got []events.Event
+	a.Events.Subscribe(func(e events.Event) {
+		a.Pending()
+		got = append(got, e)
+	})
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.Schedule(clock.LeapDelete, leap)
+	require.NoError(t, a.Update(leap.Add(-time.Hour)))
+	require.NoError(t, a.Update(leap.Add(-time.Second)))
+	// disarming is not reported
+	require.NoError(t, a.Update(leap))
+	require.Equal(t, []events.Event{{Time: leap.Add(-time.Hour), Kind: events.LeapArmed, Reason: "delete"}}, got)
+}
+
+func TestLeapArmerCancel(t *testing.T) {
+	c := &fakeLeapClock{}
+	a := NewLeapArmer(c)
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.Schedule(clock.LeapDelete, leap)
+	require.NoError(t, a.Update(leap.Add(-time.Hour)))
+	a.Schedule(clock.LeapNone, time.Time{})
+	require.NoError(t, a.Update(leap.Add(-time.Minute)))
+	require.Equal(t, []clock.Leap{clock.LeapDelete, clock.LeapNone}, c.leaps)
+}
+
+func TestLeapArmerSmear(t *testing.T) {
+	c := &fakeLeapClock{}
+	a := NewLeapArmer(c)
+	a.Mode = LeapModeSmear
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.Schedule(clock.LeapInsert, leap)
+	require.NoError(t, a.Update(leap.Add(-time.Hour)))
+	require.NoError(t, a.Update(leap))
+	require.Empty(t, c.leaps)
+}
+
+func TestLeapArmerError(t *testing.T) {
+	c := &fakeLeapClock{err: errors.New("EPERM")}
+	a := NewLeapArmer(c)
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.Schedule(clock.LeapInsert, leap)
+	require.Error(t, a.Update(leap.Add(-time.Hour)))
+	// retried on the next update
+	c.err = nil
+	require.NoError(t, a.Update(leap.Add(-time.Minute)))
+	require.Equal(t, []clock.Leap{clock.LeapInsert}, c.leaps)
+}
+
+func TestSmearOffset(t *testing.T) {
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		leap   clock.Leap
+		t      time.Time
+		offset time.Duration
+	}{
+		{clock.LeapInsert, leap.Add(-12*time.Hour - time.Second), 0},
+		{clock.LeapInsert, leap.Add(-12 * time.Hour), 0},
+		{clock.LeapInsert, leap.Add(-6 * time.Hour), -250 * time.Millisecond},
+		{clock.LeapInsert, leap.Add(-3 * time.Hour), -375 * time.Millisecond},
+		{clock.LeapInsert, leap, 500 * time.Millisecond},
+		{clock.LeapInsert, leap.Add(6 * time.Hour), 250 * time.Millisecond},
+		{clock.LeapInsert, leap.Add(12 * time.Hour), 0},
+		{clock.LeapDelete, leap.Add(-6 * time.Hour), 250 * time.Millisecond},
+		{clock.LeapDelete, leap.Add(6 * time.Hour), -250 * time.Millisecond},
+		{clock.LeapNone, leap.Add(-6 * time.Hour), 0},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.offset, SmearOffset(tt.leap, leap, DefaultSmearWindow, tt.t), tt.t)
+	}
+}
+
+func TestLeapArmerSmearOffsets(t *testing.T) {
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewLeapArmer(&fakeLeapClock{})
+	a.Schedule(clock.LeapInsert, leap)
+	require.Equal(t, time.Duration(0), a.Smear(leap.Add(-time.Hour)))
+
+	a.Mode = LeapModeSmear
+	a.SmearWindow = 2 * time.Hour
+	require.Equal(t, -250*time.Millisecond, a.Smear(leap.Add(-time.Hour/2)))
+	// smear goes on after the leap is over
+	require.NoError(t, a.Update(leap))
+	require.Equal(t, 250*time.Millisecond, a.Smear(leap.Add(time.Hour/2)))
+	require.Equal(t, time.Duration(0), a.Smear(leap.Add(time.Hour)))
+}
+
+func TestLoopSmearsLeap(t *testing.T) {
+	// the clock is in sync with the server which inserts leap second
+	c := &fakeClock{}
+	l := NewLoop(c)
+	require.NoError(t, l.SetFrequencyPPB(0))
+	l.Leap = NewLeapArmer(&fakeLeapClock{})
+	l.Leap.Mode = LeapModeSmear
+	leap := start.Add(86400 * time.Second)
+	l.Leap.Schedule(clock.LeapInsert, leap)
+
+	var worst float64
+	next := 0
+	for sec := 0; sec < 3*86400; sec++ {
+		now := start.Add(time.Duration(sec) * time.Second)
+		// server repeats the second, local clock is to follow smeared time
+		repeated := 0.0
+		if !now.Before(leap) {
+			repeated = 1
+		}
+		if sec == next {
+			offset := time.Duration((-c.phase - repeated) * float64(time.Second))
+			_, err := l.Update(offset, now)
+			require.NoError(t, err)
+			next += 1 << l.Poll()
+		}
+		require.NoError(t, l.Tick())
+		require.NoError(t, l.Leap.Update(now))
+		c.advance()
+		smeared := SmearOffset(clock.LeapInsert, leap, DefaultSmearWindow, now).Seconds() - repeated
+		worst = math.Max(worst, math.Abs(c.phase-smeared))
+	}
+	require.Empty(t, c.steps)
+	require.Less(t, worst, 0.05)
+}
+
+func TestLeapArmerTAI(t *testing.T) {
+	c := &fakeLeapClock{}
+	a := NewLeapArmer(c)
+	leap := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.Schedule(clock.LeapInsert, leap)
+	// unknown offset is left alone
+	require.NoError(t, a.Update(leap.Add(-time.Hour)))
+	require.Empty(t, c.tai)
+
+	a.AnnounceTAI(36)
+	require.NoError(t, a.Update(leap.Add(-time.Minute)))
+	require.NoError(t, a.Update(leap.Add(-time.Second)))
+	require.Equal(t, []int{36}, c.tai)
+	require.NoError(t, a.Update(leap))
+	require.Equal(t, []int{36, 37}, c.tai)
+	require.Equal(t, 37, a.TAI(leap))
+
+	a.Schedule(clock.LeapDelete, leap.Add(time.Hour))
+	require.NoError(t, a.Update(leap.Add(time.Hour)))
+	require.Equal(t, []int{36, 37, 36}, c.tai)
+}
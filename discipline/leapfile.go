@@ -0,0 +1,164 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+	"github.com/facebookincubator/ntp/leaphash"
+)
+
+// ErrLeapFileHash is returned for leap seconds file which doesn't match its hash
+var ErrLeapFileHash = errors.New("leap seconds file hash mismatch")
+
+// ErrLeapFileExpired is returned for leap seconds file past its expiration date
+var ErrLeapFileExpired = errors.New("leap seconds file expired")
+
+// LeapSecond is an entry of leap seconds file: TAI-UTC offset in seconds effective from the instant
+type LeapSecond struct {
+	At  time.Time
+	TAI int
+}
+
+// LeapFile is IERS/NIST leap-seconds.list
+type LeapFile struct {
+	// Updated is the time the file was last updated at
+	Updated time.Time
+	// Expires is the time leap seconds after which are not known
+	Expires time.Time
+	// Leaps are in chronological order
+	Leaps []LeapSecond
+}
+
+// ntpEpoch is Unix time of 1900-01-01, the epoch of the file. It is not taken from protocol/ntp,
+// which doesn't build on every platform the clock can be disciplined on
+const ntpEpoch = 2208988800
+
+// ntpSeconds converts seconds since 1900 the file uses
+func ntpSeconds(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec-ntpEpoch, 0).UTC(), nil
+}
+
+// ParseLeapFile parses leap seconds file, checking its hash
+func ParseLeapFile(data []byte) (*LeapFile, error) {
+	f := &LeapFile{}
+	var hash string
+	for i, line := range strings.Split(string(data), "\n") {
+		var err error
+		switch {
+		case strings.HasPrefix(line, "#$"):
+			f.Updated, err = ntpSeconds(strings.TrimSpace(line[2:]))
+		case strings.HasPrefix(line, "#@"):
+			f.Expires, err = ntpSeconds(strings.TrimSpace(line[2:]))
+		case strings.HasPrefix(line, "#h"):
+			hash = strings.Join(strings.Fields(line[2:]), " ")
+		case strings.HasPrefix(line, "#"):
+		default:
+			if pos := strings.Index(line, "#"); pos != -1 {
+				line = line[:pos]
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) != 2 {
+				err = fmt.Errorf("expected 2 fields, got %d", len(fields))
+				break
+			}
+			var leap LeapSecond
+			if leap.At, err = ntpSeconds(fields[0]); err != nil {
+				break
+			}
+			if leap.TAI, err = strconv.Atoi(fields[1]); err != nil {
+				break
+			}
+			if n := len(f.Leaps); n > 0 && !f.Leaps[n-1].At.Before(leap.At) {
+				err = fmt.Errorf("entry is out of order")
+				break
+			}
+			f.Leaps = append(f.Leaps, leap)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed leap seconds file line %d: %w", i+1, err)
+		}
+	}
+	if f.Expires.IsZero() {
+		return nil, fmt.Errorf("leap seconds file has no expiration date")
+	}
+	if hash == "" {
+		return nil, fmt.Errorf("%w: no hash", ErrLeapFileHash)
+	}
+	if computed := leaphash.Compute(string(data)); computed != hash {
+		return nil, fmt.Errorf("%w: %s, computed %s", ErrLeapFileHash, hash, computed)
+	}
+	return f, nil
+}
+
+// ReadLeapFile reads and parses leap seconds file
+func ReadLeapFile(path string) (*LeapFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLeapFile(data)
+}
+
+// Expired checks the file is past its expiration date at t
+func (f *LeapFile) Expired(t time.Time) bool {
+	return !t.Before(f.Expires)
+}
+
+// TAI returns TAI-UTC offset in seconds at t, 0 if t precedes the first entry
+func (f *LeapFile) TAI(t time.Time) int {
+	tai := 0
+	for _, leap := range f.Leaps {
+		if t.Before(leap.At) {
+			break
+		}
+		tai = leap.TAI
+	}
+	return tai
+}
+
+// Next returns the first leap second after t along with its instant, LeapNone if there is none known
+func (f *LeapFile) Next(t time.Time) (clock.Leap, time.Time) {
+	tai := f.TAI(t)
+	for _, leap := range f.Leaps {
+		if !leap.At.After(t) {
+			continue
+		}
+		// the first entry sets the offset rather than changes it
+		if tai == 0 {
+			return clock.LeapNone, time.Time{}
+		}
+		if leap.TAI > tai {
+			return clock.LeapInsert, leap.At
+		}
+		return clock.LeapDelete, leap.At
+	}
+	return clock.LeapNone, time.Time{}
+}
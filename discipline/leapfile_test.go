@@ -0,0 +1,101 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLeapFile(t *testing.T) {
+	f, err := ParseLeapFile([]byte(testLeapFile))
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2016, 7, 8, 0, 0, 0, 0, time.UTC), f.Updated)
+	require.Equal(t, time.Date(2018, 12, 28, 0, 0, 0, 0, time.UTC), f.Expires)
+	require.Len(t, f.Leaps, 28)
+	require.Equal(t, LeapSecond{At: time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC), TAI: 10}, f.Leaps[0])
+	require.Equal(t, LeapSecond{At: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), TAI: 37}, f.Leaps[27])
+
+	require.True(t, f.Expired(f.Expires))
+	require.False(t, f.Expired(f.Updated))
+
+	require.Equal(t, 0, f.TAI(time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.Equal(t, 10, f.TAI(time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.Equal(t, 36, f.TAI(time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC)))
+	require.Equal(t, 37, f.TAI(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	leap, at := f.Next(time.Date(2016, 8, 1, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, clock.LeapInsert, leap)
+	require.Equal(t, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), at)
+	leap, _ = f.Next(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, clock.LeapNone, leap)
+	// before the first entry
+	leap, _ = f.Next(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, clock.LeapNone, leap)
+}
+
+func TestParseLeapFileInvalid(t *testing.T) {
+	// tampered entry
+	_, err := ParseLeapFile([]byte(strings.Replace(testLeapFile, "3692217600\t37", "3692217600\t38", 1)))
+	require.ErrorIs(t, err, ErrLeapFileHash)
+
+	noHash := testLeapFile[:strings.Index(testLeapFile, "#h")]
+	_, err = ParseLeapFile([]byte(noHash))
+	require.ErrorIs(t, err, ErrLeapFileHash)
+
+	for _, data := range []string{
+		strings.Replace(testLeapFile, "#@", "# ", 1),
+		strings.Replace(testLeapFile, "2272060800\t10", "2272060800", 1),
+		strings.Replace(testLeapFile, "2272060800\t10", "2272060800\tten", 1),
+		strings.Replace(testLeapFile, "2272060800", "9272060800", 1),
+	} {
+		_, err = ParseLeapFile([]byte(data))
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrLeapFileHash)
+	}
+}
+
+func TestLeapArmerScheduleFile(t *testing.T) {
+	f, err := ParseLeapFile([]byte(testLeapFile))
+	require.NoError(t, err)
+	c := &fakeLeapClock{}
+	a := NewLeapArmer(c)
+	require.ErrorIs(t, a.ScheduleFile(f, f.Expires), ErrLeapFileExpired)
+	require.Equal(t, 0, a.TAI(f.Updated))
+
+	// second half of 2015 leap is still ahead
+	now := time.Date(2015, 6, 30, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, a.ScheduleFile(f, now))
+	require.Equal(t, 35, a.TAI(now))
+	leap, at := a.Pending()
+	require.Equal(t, clock.LeapInsert, leap)
+	require.Equal(t, time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC), at)
+
+	require.NoError(t, a.Update(now))
+	require.NoError(t, a.Update(at))
+	require.Equal(t, []clock.Leap{clock.LeapInsert, clock.LeapNone}, c.leaps)
+	require.Equal(t, []int{35, 36}, c.tai)
+	// the next one is picked from the file
+	leap, at = a.Pending()
+	require.Equal(t, clock.LeapInsert, leap)
+	require.Equal(t, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), at)
+	require.Equal(t, 36, a.TAI(at.Add(-time.Second)))
+}
@@ -0,0 +1,773 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package discipline steers local clock to the offsets measured against NTP servers
+package discipline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+	"github.com/facebookincubator/ntp/events"
+	"github.com/facebookincubator/ntp/internal/window"
+	"github.com/facebookincubator/ntp/logging"
+)
+
+// Defaults of the loop, see RFC 5905 appendix A.1.1
+const (
+	// DefaultStepThreshold is the offset above which the clock is stepped rather than slewed (STEPT)
+	DefaultStepThreshold = 128 * time.Millisecond
+	// DefaultPanicThreshold is the offset the loop refuses to correct (PANICT)
+	DefaultPanicThreshold = 1000 * time.Second
+	// DefaultStepout is how long offsets above the step threshold are ignored before acting on them (WATCH)
+	DefaultStepout = 900 * time.Second
+	// DefaultMinPoll and DefaultMaxPoll bound poll exponent the loop recommends
+	DefaultMinPoll = 6
+	DefaultMaxPoll = 10
+	// DriftInterval is how often Run saves frequency to the drift file
+	DriftInterval = time.Hour
+)
+
+// MaxDispersion is the dispersion of the clock which was never set (MAXDISP)
+const MaxDispersion = 16 * time.Second
+
+// loop constants
+const (
+	allan     = 1500.0 // Allan intercept, seconds
+	avg       = 4      // averaging constant of jitter and wander
+	limit     = 30     // poll-adjust threshold
+	pgate     = 4      // poll-adjust gate
+	maxFreq   = 500e-6 // maximum frequency correction, s/s
+	minJitter = 1e-6   // jitter floor, precision of the clock
+	phi       = 15e-6  // frequency tolerance, s/s
+	// holdoverPolls is how many poll intervals without updates mean sources are lost
+	holdoverPolls = 8
+)
+
+// State is the state of the loop, see RFC 5905 section 11.3
+type State int
+
+// Loop states
+const (
+	// StateNSET means the clock was never set
+	StateNSET State = iota
+	// StateFSET means frequency was set, but the clock wasn't
+	StateFSET
+	// StateSPIK means offset above the step threshold was seen, it is ignored until stepout expires
+	StateSPIK
+	// StateFREQ means frequency is being measured
+	StateFREQ
+	// StateSYNC means the clock is synchronized
+	StateSYNC
+)
+
+var stateNames = map[State]string{
+	StateNSET: "NSET",
+	StateFSET: "FSET",
+	StateSPIK: "SPIK",
+	StateFREQ: "FREQ",
+	StateSYNC: "SYNC",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Action is what the loop did with the offset
+type Action int
+
+// Actions of Update
+const (
+	// ActionIgnore means the offset was ignored, e.g. as a spike
+	ActionIgnore Action = iota
+	// ActionSlew means the offset is being slewed
+	ActionSlew
+	// ActionStep means the clock was stepped
+	ActionStep
+	// ActionPanic means the offset exceeds the panic threshold and was not acted upon
+	ActionPanic
+	// ActionRefuse means the offset or frequency correction it leads to exceeds sanity limits and was not acted upon
+	ActionRefuse
+)
+
+var actionNames = map[Action]string{
+	ActionIgnore: "ignore",
+	ActionSlew:   "slew",
+	ActionStep:   "step",
+	ActionPanic:  "panic",
+	ActionRefuse: "refuse",
+}
+
+func (a Action) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// ErrPanic is returned for offsets exceeding the panic threshold
+var ErrPanic = errors.New("offset exceeds panic threshold")
+
+// ErrRefused is returned once the loop refused more than MaxRefusals offsets in a row
+var ErrRefused = errors.New("clock correction refused")
+
+// Clock is the clock the loop steers. clock.System, clock.PHC and SystemClock implement it
+type Clock interface {
+	// Step moves the clock by offset at once
+	Step(offset time.Duration) error
+	// SetFrequencyPPB sets frequency adjustment of the clock
+	SetFrequencyPPB(ppb float64) error
+}
+
+// rtcClock is implemented by clocks which keep hardware clock in sync
+type rtcClock interface {
+	SetRTC() error
+}
+
+// SystemClock is the system clock along with the hardware one
+type SystemClock struct {
+	clock.System
+	// RTC is hardware clock device, e.g. /dev/rtc0, set to the system time after steps and once synchronized,
+	// so the next boot starts close to correct time. Not touched if not set
+	RTC string
+}
+
+// SetRTC sets hardware clock to the system time, if there is one
+func (c SystemClock) SetRTC() error {
+	if c.RTC == "" {
+		return nil
+	}
+	return clock.SetRTC(c.RTC)
+}
+
+// Loop is RFC 5905 hybrid phase/frequency-locked loop. It takes offsets of the clock from the selected
+// and combined servers with Update and corrects the clock: steps large offsets and slews small ones
+// by adjusting clock frequency every second in Tick. It is safe for concurrent use
+type Loop struct {
+	// StepThreshold is DefaultStepThreshold if not set
+	StepThreshold time.Duration
+	// PanicThreshold is DefaultPanicThreshold if not set
+	PanicThreshold time.Duration
+	// Stepout is DefaultStepout if not set
+	Stepout time.Duration
+	// MinPoll and MaxPoll are DefaultMinPoll and DefaultMaxPoll if not set
+	MinPoll int
+	MaxPoll int
+	// StepLimit allows steps only during the first StepLimit updates that stepped or slewed the clock,
+	// larger offsets are slewed afterwards. Ignored spikes and refused offsets do not count.
+	// Steps are always allowed if not set
+	StepLimit int
+	// OnStep is called after the clock is stepped, may be nil
+	OnStep func(offset time.Duration)
+	// OnPanic is called when offset exceeding PanicThreshold is refused, may be nil
+	OnPanic func(offset time.Duration)
+	// InitialStep lets the clock which was never set be stepped by any offset, ignoring PanicThreshold,
+	// like ntpd -g does. Machines booting with wildly wrong clock need it
+	InitialStep bool
+	// MaxChange refuses offsets above it once the clock was set, no limit if not set
+	MaxChange time.Duration
+	// MaxDriftPPB refuses offsets leading to frequency correction above it, no limit other than the clock one if not set
+	MaxDriftPPB float64
+	// MaxRefusals is how many offsets in a row may be refused by MaxChange and MaxDriftPPB before Update
+	// reports ErrRefused. Every refusal is reported if not set
+	MaxRefusals int
+	// OnAlert is called with ErrRefused error Update reports, may be nil
+	OnAlert func(err error)
+	// DriftFile keeps frequency correction between runs if set: Run starts from the frequency saved there
+	// and saves it every DriftInterval while synchronized
+	DriftFile string
+	// Filter estimates corrections from offsets once the loop is synchronized, PLL if not set
+	Filter Filter
+	// Leap is updated by Run every second if set. Offsets are smeared around leap seconds in LeapModeSmear
+	Leap *LeapArmer
+	// Logger receives state changes, steps and offsets refused or ignored, slog.Default() if not set
+	Logger logging.Logger
+	// WanderWindow is how far back Stats.WindowWanderPPB looks, it is not computed if not set
+	WanderWindow time.Duration
+	// Events receives clock steps, slews and frequency changes, offsets over panic threshold and holdover Run detects.
+	// May be nil
+	Events *events.Bus
+
+	clock Clock
+	mu    sync.Mutex
+	state State
+	// updated is the time of the last update the state was reset at
+	updated time.Time
+	// offset is what is left to slew, last is the offset of the last update, both in seconds
+	offset float64
+	last   float64
+	// freq is frequency correction, s/s
+	freq   float64
+	poll   int
+	count  int
+	jitter float64
+	wander float64
+	// freqs are frequency corrections of updates, for wander over WanderWindow
+	freqs window.Series
+	// updates counts offsets the loop stepped or slewed the clock by, ignored and refused ones are not counted
+	updates int
+	// refusals counts offsets refused in a row
+	refusals int
+	// rtcSynced is set once hardware clock was set after the loop synchronized
+	rtcSynced bool
+	// holdover is set once holdover was reported, until the next update
+	holdover bool
+	// last adjustment of the clock
+	lastAction   Action
+	lastOffset   time.Duration
+	lastAdjusted time.Time
+}
+
+// NewLoop returns loop steering the clock, which has never been set. DryRun clock lets the loop run
+// without touching the system clock
+func NewLoop(c Clock) *Loop {
+	return &Loop{clock: c}
+}
+
+func (l *Loop) stepThreshold() float64 {
+	if l.StepThreshold == 0 {
+		return DefaultStepThreshold.Seconds()
+	}
+	return l.StepThreshold.Seconds()
+}
+
+func (l *Loop) panicThreshold() float64 {
+	if l.PanicThreshold == 0 {
+		return DefaultPanicThreshold.Seconds()
+	}
+	return l.PanicThreshold.Seconds()
+}
+
+func (l *Loop) stepout() float64 {
+	if l.Stepout == 0 {
+		return DefaultStepout.Seconds()
+	}
+	return l.Stepout.Seconds()
+}
+
+func (l *Loop) filter() Filter {
+	if l.Filter == nil {
+		return PLL{}
+	}
+	return l.Filter
+}
+
+func (l *Loop) minPoll() int {
+	if l.MinPoll == 0 {
+		return DefaultMinPoll
+	}
+	return l.MinPoll
+}
+
+func (l *Loop) maxPoll() int {
+	if l.MaxPoll == 0 {
+		return DefaultMaxPoll
+	}
+	return l.MaxPoll
+}
+
+// reset moves the loop into the state, starting measurement interval at t with offset
+func (l *Loop) reset(state State, t time.Time, offset float64) {
+	l.state = state
+	l.updated = t
+	l.offset = offset
+	l.last = offset
+}
+
+// stepAllowed checks the clock may still be stepped
+func (l *Loop) stepAllowed() bool {
+	return l.StepLimit == 0 || l.updates < l.StepLimit
+}
+
+// Update feeds the loop with clock offset measured at the given time: positive offset means the clock is behind.
+// Offsets above StepThreshold step the clock, smaller ones are slewed
+func (l *Loop) Update(offset time.Duration, at time.Time) (Action, error) {
+	return l.UpdateFrom("", offset, at)
+}
+
+// UpdateFrom is Update with offset measured against the source, e.g. address of the selected server,
+// which Events attribute the clock adjustments to
+func (l *Loop) UpdateFrom(source string, offset time.Duration, at time.Time) (Action, error) {
+	if l.Leap != nil {
+		offset += l.Leap.Smear(at)
+	}
+	if v, ok := l.clock.(virtualClock); ok {
+		offset = v.Offset(offset, at)
+	}
+	l.mu.Lock()
+	prev, prevOffset, prevFreq := l.state, l.offset, l.freq
+	action, err := l.update(offset, at)
+	state := l.state
+	var adjustments []events.Event
+	switch action {
+	case ActionStep:
+		adjustments = append(adjustments, events.Event{Time: at, Kind: events.ClockStep, Source: source, Offset: offset,
+			Adjustment: &events.Adjustment{Before: offset.Seconds(), After: l.offset}})
+	case ActionSlew:
+		adjustments = append(adjustments, events.Event{Time: at, Kind: events.ClockSlew, Source: source, Offset: offset,
+			Adjustment: &events.Adjustment{Before: prevOffset, After: l.offset}})
+	}
+	if l.freq != prevFreq {
+		adjustments = append(adjustments, events.Event{Time: at, Kind: events.FrequencyChange, Source: source,
+			Adjustment: &events.Adjustment{Before: prevFreq * 1e9, After: l.freq * 1e9}})
+	}
+	if action == ActionSlew || action == ActionStep {
+		l.updates++
+		l.refusals = 0
+		l.lastAction, l.lastOffset, l.lastAdjusted = action, offset, at
+	}
+	setRTC := action == ActionStep || (l.state == StateSYNC && !l.rtcSynced)
+	if l.state == StateSYNC {
+		l.rtcSynced = true
+	}
+	l.mu.Unlock()
+	l.logUpdate(prev, state, action, offset, err)
+	// callbacks may well look at the loop
+	switch {
+	case action == ActionStep && l.OnStep != nil:
+		l.OnStep(offset)
+	case action == ActionPanic && l.OnPanic != nil:
+		l.OnPanic(offset)
+	case errors.Is(err, ErrRefused) && l.OnAlert != nil:
+		l.OnAlert(err)
+	}
+	for _, e := range adjustments {
+		l.Events.Publish(e)
+	}
+	if action == ActionPanic {
+		l.Events.Publish(events.Event{Time: at, Kind: events.PanicThreshold, Source: source, Offset: offset})
+	}
+	if r, ok := l.clock.(rtcClock); ok && setRTC && err == nil {
+		// it takes up to a second, the loop is not held up meanwhile
+		if rtcErr := r.SetRTC(); rtcErr != nil {
+			err = fmt.Errorf("failed to set RTC: %w", rtcErr)
+		}
+	}
+	return action, err
+}
+
+// logUpdate logs what the update did: state change and steps at info level, refusals at warning,
+// offsets over panic threshold at error. Slews and ignored offsets happen all the time, so they are only logged at debug level
+func (l *Loop) logUpdate(prev, state State, action Action, offset time.Duration, err error) {
+	logger := logging.Or(l.Logger)
+	switch action {
+	case ActionStep:
+		logger.Info("Stepped the clock", "offset", offset)
+	case ActionSlew:
+		logger.Debug("Slewing the clock", "offset", offset)
+	case ActionIgnore:
+		logger.Debug("Ignoring offset", "offset", offset, "state", state, "error", err)
+	case ActionRefuse:
+		logger.Warn("Refused offset", "offset", offset, "error", err)
+	case ActionPanic:
+		logger.Error("Offset exceeds panic threshold", "offset", offset)
+	}
+	if state != prev {
+		logger.Info("Loop state changed", "from", prev, "to", state)
+	}
+}
+
+// refuse counts refused offset, reporting ErrRefused once there were more than MaxRefusals in a row
+func (l *Loop) refuse(format string, a ...interface{}) (Action, error) {
+	l.refusals++
+	if l.refusals > l.MaxRefusals {
+		return ActionRefuse, fmt.Errorf("%w %d times in a row: %s", ErrRefused, l.refusals, fmt.Sprintf(format, a...))
+	}
+	return ActionRefuse, nil
+}
+
+// driftExceeded checks frequency correction changed by freq would exceed MaxDriftPPB
+func (l *Loop) driftExceeded(freq float64) bool {
+	return l.MaxDriftPPB != 0 && math.Abs(l.freq+freq)*1e9 > l.MaxDriftPPB
+}
+
+func (l *Loop) update(offset time.Duration, at time.Time) (Action, error) {
+	if l.poll == 0 {
+		l.poll = l.minPoll()
+	}
+	theta := offset.Seconds()
+	initial := l.state == StateNSET || l.state == StateFSET
+	if math.Abs(theta) > l.panicThreshold() && !(l.InitialStep && initial) {
+		return ActionPanic, fmt.Errorf("%w: %v", ErrPanic, offset)
+	}
+	if l.MaxChange != 0 && math.Abs(theta) > l.MaxChange.Seconds() && !initial {
+		return l.refuse("offset %v exceeds %v", offset, l.MaxChange)
+	}
+	step := math.Abs(theta) > l.stepThreshold() && l.stepAllowed()
+	var freq float64
+	action := ActionSlew
+	mu := at.Sub(l.updated).Seconds()
+	if step {
+		switch l.state {
+		case StateSYNC:
+			// single large offset is a spike until proven otherwise
+			l.state = StateSPIK
+			return ActionIgnore, nil
+		case StateFREQ, StateSPIK:
+			if mu < l.stepout() {
+				return ActionIgnore, nil
+			}
+			if l.state == StateFREQ {
+				freq = (theta - l.offset) / mu
+			}
+		}
+		if l.driftExceeded(freq) {
+			return l.refuse("frequency %.3f PPB exceeds %.3f PPB", (l.freq+freq)*1e9, l.MaxDriftPPB)
+		}
+		if err := l.clock.Step(offset); err != nil {
+			return ActionIgnore, fmt.Errorf("failed to step the clock: %w", err)
+		}
+		action = ActionStep
+		l.count = 0
+		l.poll = l.minPoll()
+		if l.state == StateNSET {
+			l.reset(StateFREQ, at, 0)
+			return action, nil
+		}
+		l.reset(StateSYNC, at, 0)
+	} else {
+		// jitter is RMS of exponentially weighted offset differences
+		d := math.Max(math.Abs(theta-l.last), minJitter)
+		l.jitter = math.Sqrt(l.jitter*l.jitter + (d*d-l.jitter*l.jitter)/avg)
+		phase := theta
+		switch l.state {
+		case StateNSET:
+			// frequency is measured from here
+			l.reset(StateFREQ, at, theta)
+			return action, nil
+		case StateFSET:
+			// frequency is known, so the loop goes straight to SYNC
+			l.reset(StateSYNC, at, theta)
+			return action, nil
+		case StateFREQ:
+			if mu < l.stepout() {
+				return ActionIgnore, nil
+			}
+			freq = (theta - l.offset) / mu
+		default:
+			freq, phase = l.filter().Update(theta, l.offset, mu, l.poll)
+		}
+		if l.driftExceeded(freq) {
+			return l.refuse("frequency %.3f PPB exceeds %.3f PPB", (l.freq+freq)*1e9, l.MaxDriftPPB)
+		}
+		l.reset(StateSYNC, at, theta)
+		l.offset = phase
+	}
+
+	prev := l.freq
+	l.freq = math.Max(math.Min(l.freq+freq, maxFreq), -maxFreq)
+	// wander is RMS of exponentially weighted frequency differences
+	d := l.freq - prev
+	l.wander = math.Sqrt(l.wander*l.wander + (d*d-l.wander*l.wander)/avg)
+	l.freqs.Add(at, l.freq)
+
+	// poll interval grows while offsets stay within the jitter and shrinks otherwise
+	if math.Abs(l.offset) < pgate*l.jitter {
+		l.count += l.poll
+		if l.count > limit {
+			l.count = limit
+			if l.poll < l.maxPoll() {
+				l.count = 0
+				l.poll++
+			}
+		}
+	} else {
+		l.count -= l.poll << 1
+		if l.count < -limit {
+			l.count = -limit
+			if l.poll > l.minPoll() {
+				l.count = 0
+				l.poll--
+			}
+		}
+	}
+	return action, nil
+}
+
+// Tick slews the clock by the share of remaining offset and applies frequency correction. It has to be called every second.
+// Filter decides how fast offsets are slewed if it is a Slewer, PLL does otherwise
+func (l *Loop) Tick() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state == StateNSET {
+		return nil
+	}
+	slewer, ok := l.filter().(Slewer)
+	if !ok {
+		slewer = PLL{}
+	}
+	// large offsets are slewed at the maximum rate the clock takes
+	maxAdj := clock.MaxFrequencyPPB / 1e9
+	adj := math.Max(math.Min(slewer.Slew(l.freq, l.offset, l.poll), maxAdj), -maxAdj)
+	l.offset -= adj - l.freq
+	return l.clock.SetFrequencyPPB(adj * 1e9)
+}
+
+// Run calls Tick every second until ctx is done, loading and saving DriftFile
+func (l *Loop) Run(ctx context.Context) error {
+	if err := l.loadDrift(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	saved := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := l.saveDrift(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case now := <-ticker.C:
+			if err := l.Tick(); err != nil {
+				return err
+			}
+			l.checkHoldover(now)
+			if l.Leap != nil {
+				if err := l.Leap.Update(now); err != nil {
+					return err
+				}
+			}
+			if now.Sub(saved) >= DriftInterval {
+				saved = now
+				if err := l.saveDrift(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// loadDrift starts the loop from the frequency in DriftFile. Missing file means there is no estimate yet
+func (l *Loop) loadDrift() error {
+	if l.DriftFile == "" {
+		return nil
+	}
+	ppb, err := ReadDriftFile(l.DriftFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return l.setFrequencyPPB(l.DriftFile, ppb)
+}
+
+// saveDrift writes frequency to DriftFile, as long as the loop is synchronized and the estimate is any good
+func (l *Loop) saveDrift() error {
+	if l.DriftFile == "" || l.State() != StateSYNC {
+		return nil
+	}
+	return WriteDriftFile(l.DriftFile, l.FrequencyPPB())
+}
+
+// SetFrequencyPPB sets frequency correction known in advance, e.g. from the drift file.
+// Loop which never set the clock moves to FSET and skips frequency measurement
+func (l *Loop) SetFrequencyPPB(ppb float64) error {
+	return l.setFrequencyPPB("", ppb)
+}
+
+// setFrequencyPPB sets frequency correction from the source, e.g. the drift file
+func (l *Loop) setFrequencyPPB(source string, ppb float64) error {
+	var change *events.Event
+	// events are published once the lock is released, subscribers may well look at the loop
+	defer func() {
+		if change != nil {
+			l.Events.Publish(*change)
+		}
+	}()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if math.Abs(ppb) > maxFreq*1e9 {
+		return fmt.Errorf("frequency %.3f PPB is out of range", ppb)
+	}
+	if err := l.clock.SetFrequencyPPB(ppb); err != nil {
+		return err
+	}
+	if prev := l.freq; ppb/1e9 != prev {
+		change = &events.Event{Kind: events.FrequencyChange, Source: source, Adjustment: &events.Adjustment{Before: prev * 1e9, After: ppb}}
+	}
+	l.freq = ppb / 1e9
+	if l.state == StateNSET {
+		l.state = StateFSET
+	}
+	return nil
+}
+
+// State returns the state of the loop
+func (l *Loop) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// Poll returns poll exponent the loop recommends: poll interval is 2^poll seconds
+func (l *Loop) Poll() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.poll == 0 {
+		return l.minPoll()
+	}
+	return l.poll
+}
+
+// FrequencyPPB returns frequency correction of the clock
+func (l *Loop) FrequencyPPB() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.freq * 1e9
+}
+
+// Holdover returns how long the loop has been running on its frequency estimate alone at now,
+// which happens when no update came in for several poll intervals. It is 0 while updates keep coming
+func (l *Loop) Holdover(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.holdoverAt(now)
+}
+
+func (l *Loop) holdoverAt(now time.Time) time.Duration {
+	if l.state == StateNSET || l.state == StateFSET {
+		return 0
+	}
+	since := now.Sub(l.updated)
+	if since < holdoverPolls*time.Duration(math.Ldexp(1, l.poll))*time.Second {
+		return 0
+	}
+	return since
+}
+
+// checkHoldover reports the loop entering holdover at now to Events, once until updates come in again
+func (l *Loop) checkHoldover(now time.Time) {
+	holdover := l.Holdover(now)
+	l.mu.Lock()
+	entered := holdover != 0 && !l.holdover
+	l.holdover = holdover != 0
+	l.mu.Unlock()
+	if entered {
+		logging.Or(l.Logger).Warn("Clock is in holdover", "for", holdover)
+		l.Events.Publish(events.Event{Time: now, Kind: events.HoldoverEntered, Reason: fmt.Sprintf("no updates for %v", holdover)})
+	}
+}
+
+// Dispersion returns the error the clock may have accumulated at now since the last update: jitter of the offsets
+// and the error growing with frequency tolerance and wander. Servers add it to root dispersion they advertise,
+// and may stop serving once it is too large
+func (l *Loop) Dispersion(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dispersionAt(now)
+}
+
+func (l *Loop) dispersionAt(now time.Time) time.Duration {
+	if l.state == StateNSET || l.state == StateFSET {
+		return MaxDispersion
+	}
+	since := math.Max(now.Sub(l.updated).Seconds(), 0)
+	disp := l.jitter + (phi+l.wander)*since
+	if disp > MaxDispersion.Seconds() {
+		return MaxDispersion
+	}
+	return time.Duration(disp * float64(time.Second))
+}
+
+// Stats is the state of the loop, as monitoring and ntpd control queries report it
+type Stats struct {
+	State State
+	// Poll is poll exponent, which is also the time constant of the loop, MinPoll is the lowest it goes
+	Poll         int
+	MinPoll      int
+	FrequencyPPB float64
+	// Offset is the last offset the loop acted on, Residual is what is left of it to slew
+	Offset   time.Duration
+	Residual time.Duration
+	// Jitter is RMS of offset differences, WanderPPB is RMS of frequency differences
+	Jitter    time.Duration
+	WanderPPB float64
+	// WindowWanderPPB is RMS of differences between successive frequency corrections over WanderWindow
+	// before the last update, 0 if it is not set
+	WindowWanderPPB float64
+	// LastAdjustment is when the loop last slewed or stepped the clock, LastAction is which of the two
+	LastAdjustment time.Time
+	LastAction     Action
+	// Holdover is how long the loop has been in holdover, 0 if it is not, Dispersion is the error the clock
+	// may have accumulated since the last update, as Holdover and Dispersion of the loop return them
+	Holdover   time.Duration
+	Dispersion time.Duration
+}
+
+// Stats returns the state of the loop now
+func (l *Loop) Stats() *Stats {
+	return l.StatsAt(time.Now())
+}
+
+// StatsAt returns the state of the loop at now
+func (l *Loop) StatsAt(now time.Time) *Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	poll := l.poll
+	if poll == 0 {
+		poll = l.minPoll()
+	}
+	var windowWander float64
+	if l.WanderWindow != 0 {
+		windowWander, _ = l.freqs.RMSDiff(l.updated, l.WanderWindow)
+	}
+	return &Stats{
+		State:           l.state,
+		Poll:            poll,
+		MinPoll:         l.minPoll(),
+		FrequencyPPB:    l.freq * 1e9,
+		Offset:          l.lastOffset,
+		Residual:        time.Duration(l.offset * float64(time.Second)),
+		Jitter:          time.Duration(l.jitter * float64(time.Second)),
+		WanderPPB:       l.wander * 1e9,
+		WindowWanderPPB: windowWander * 1e9,
+		LastAdjustment:  l.lastAdjusted,
+		LastAction:      l.lastAction,
+		Holdover:        l.holdoverAt(now),
+		Dispersion:      l.dispersionAt(now),
+	}
+}
+
+// Variables returns the stats as ntpd system variables: offset and jitter in milliseconds, frequency and wander in PPM
+func (s *Stats) Variables() map[string]string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 6, 64)
+	}
+	ppm := func(ppb float64) string {
+		return strconv.FormatFloat(ppb/1000, 'f', 3, 64)
+	}
+	return map[string]string{
+		"state":      strconv.Itoa(int(s.State)),
+		"tc":         strconv.Itoa(s.Poll),
+		"mintc":      strconv.Itoa(s.MinPoll),
+		"offset":     ms(s.Offset),
+		"frequency":  ppm(s.FrequencyPPB),
+		"clk_jitter": ms(s.Jitter),
+		"clk_wander": ppm(s.WanderPPB),
+	}
+}
@@ -0,0 +1,521 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock simulates a clock running fast or slow by drift, seconds per second
+type fakeClock struct {
+	drift float64
+	// phase is how far the clock is ahead of true time, seconds
+	phase float64
+	ppb   float64
+	steps []time.Duration
+}
+
+func (c *fakeClock) Step(offset time.Duration) error {
+	c.steps = append(c.steps, offset)
+	c.phase += offset.Seconds()
+	return nil
+}
+
+func (c *fakeClock) SetFrequencyPPB(ppb float64) error {
+	c.ppb = ppb
+	return nil
+}
+
+// advance runs the clock for a second
+func (c *fakeClock) advance() {
+	c.phase += c.drift + c.ppb/1e9
+}
+
+// offset is what NTP would measure
+func (c *fakeClock) offset() time.Duration {
+	return time.Duration(-c.phase * float64(time.Second))
+}
+
+var start = time.Unix(1600000000, 0)
+
+func TestStateString(t *testing.T) {
+	assert.Equal(t, "SYNC", StateSYNC.String())
+	assert.Equal(t, "State(42)", State(42).String())
+	assert.Equal(t, "step", ActionStep.String())
+}
+
+func TestLoopFirstSlew(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	require.Equal(t, StateNSET, l.State())
+	// nothing to slew until the first update
+	require.NoError(t, l.Tick())
+	require.Equal(t, 0.0, c.ppb)
+
+	action, err := l.Update(10*time.Millisecond, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	require.Equal(t, StateFREQ, l.State())
+	require.Empty(t, c.steps)
+
+	require.NoError(t, l.Tick())
+	require.Greater(t, c.ppb, 0.0)
+
+	// frequency is only measured after stepout
+	action, err = l.Update(10*time.Millisecond, start.Add(64*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionIgnore, action)
+	require.Equal(t, StateFREQ, l.State())
+
+	action, err = l.Update(10*time.Millisecond, start.Add(DefaultStepout))
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	require.Equal(t, StateSYNC, l.State())
+}
+
+func TestLoopFirstStep(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	action, err := l.Update(-3*time.Second, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+	require.Equal(t, []time.Duration{-3 * time.Second}, c.steps)
+	require.Equal(t, StateFREQ, l.State())
+}
+
+func TestLoopLogs(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLoop(&fakeClock{})
+	l.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	_, err := l.Update(-3*time.Second, start)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `level=INFO msg="Stepped the clock" offset=-3s`)
+	require.Contains(t, buf.String(), `level=INFO msg="Loop state changed" from=NSET to=FREQ`)
+
+	buf.Reset()
+	_, err = l.Update(time.Millisecond, start.Add(time.Second))
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `level=DEBUG msg="Ignoring offset" offset=1ms state=FREQ`)
+	require.NotContains(t, buf.String(), "Loop state changed")
+}
+
+func TestLoopSpike(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.reset(StateSYNC, start, 0)
+
+	action, err := l.Update(time.Second, start.Add(64*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionIgnore, action)
+	require.Equal(t, StateSPIK, l.State())
+
+	// spike went away
+	action, err = l.Update(time.Millisecond, start.Add(128*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	require.Equal(t, StateSYNC, l.State())
+
+	// offset persisting past stepout is acted upon
+	_, err = l.Update(time.Second, start.Add(192*time.Second))
+	require.NoError(t, err)
+	action, err = l.Update(time.Second, start.Add(256*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionIgnore, action)
+	action, err = l.Update(time.Second, start.Add(128*time.Second+DefaultStepout))
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+	require.Equal(t, StateSYNC, l.State())
+	require.Equal(t, []time.Duration{time.Second}, c.steps)
+	require.Equal(t, DefaultMinPoll, l.Poll())
+}
+
+func TestLoopPanic(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	action, err := l.Update(2*DefaultPanicThreshold, start)
+	require.ErrorIs(t, err, ErrPanic)
+	require.Equal(t, ActionPanic, action)
+	require.Empty(t, c.steps)
+	require.Equal(t, StateNSET, l.State())
+
+	l.PanicThreshold = 3 * DefaultPanicThreshold
+	action, err = l.Update(2*DefaultPanicThreshold, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+}
+
+func TestLoopConverges(t *testing.T) {
+	// clock is 50ms behind and 20ppm slow
+	c := &fakeClock{drift: -20e-6, phase: -0.05}
+	l := NewLoop(c)
+	next := 0
+	for sec := 0; sec < 2*86400; sec++ {
+		if sec == next {
+			_, err := l.Update(c.offset(), start.Add(time.Duration(sec)*time.Second))
+			require.NoError(t, err)
+			next += 1 << l.Poll()
+		}
+		require.NoError(t, l.Tick())
+		c.advance()
+	}
+	require.Empty(t, c.steps)
+	require.Equal(t, StateSYNC, l.State())
+	require.InDelta(t, 20000, l.FrequencyPPB(), 200)
+	require.Less(t, math.Abs(c.phase), 0.001)
+	require.Greater(t, l.Poll(), DefaultMinPoll)
+}
+
+func TestLoopWindowWander(t *testing.T) {
+	c := &fakeClock{drift: -20e-6, phase: -0.05}
+	l := NewLoop(c)
+	l.WanderWindow = time.Hour
+	var early float64
+	next := 0
+	for sec := 0; sec < 86400; sec++ {
+		if sec == next {
+			_, err := l.Update(c.offset(), start.Add(time.Duration(sec)*time.Second))
+			require.NoError(t, err)
+			next += 1 << l.Poll()
+		}
+		if sec == 7200 {
+			early = l.Stats().WindowWanderPPB
+		}
+		require.NoError(t, l.Tick())
+		c.advance()
+	}
+	// frequency corrections settle as the loop converges
+	late := l.Stats().WindowWanderPPB
+	require.Greater(t, late, 0.0)
+	require.Less(t, late, early)
+
+	l.WanderWindow = 0
+	require.Equal(t, 0.0, l.Stats().WindowWanderPPB)
+}
+
+func TestLoopStepLimit(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.StepLimit = 1
+	var stepped []time.Duration
+	l.OnStep = func(offset time.Duration) {
+		// callback may query the loop
+		require.Equal(t, StateFREQ, l.State())
+		stepped = append(stepped, offset)
+	}
+	action, err := l.Update(time.Second, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+	require.Equal(t, []time.Duration{time.Second}, stepped)
+
+	// no more steps, large offset is slewed at the maximum rate
+	action, err = l.Update(2*time.Second, start.Add(DefaultStepout))
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	require.Equal(t, []time.Duration{time.Second}, c.steps)
+	require.Len(t, stepped, 1)
+	require.NoError(t, l.Tick())
+	require.Equal(t, 500000.0, c.ppb)
+}
+
+func TestLoopStepLimitIgnored(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.StepLimit = 2
+	action, err := l.Update(time.Second, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+
+	// offset within stepout is ignored and does not use up the limit
+	action, err = l.Update(time.Second, start.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionIgnore, action)
+	action, err = l.Update(time.Second, start.Add(DefaultStepout))
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+	require.Equal(t, []time.Duration{time.Second, time.Second}, c.steps)
+}
+
+func TestLoopOnPanic(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.PanicThreshold = time.Minute
+	var panicked time.Duration
+	l.OnPanic = func(offset time.Duration) {
+		panicked = offset
+	}
+	_, err := l.Update(-time.Hour, start)
+	require.ErrorIs(t, err, ErrPanic)
+	require.Equal(t, -time.Hour, panicked)
+}
+
+func TestLoopHoldover(t *testing.T) {
+	// clock is 20ppm slow
+	c := &fakeClock{drift: -20e-6}
+	l := NewLoop(c)
+	require.Equal(t, MaxDispersion, l.Dispersion(start))
+	require.NoError(t, l.SetFrequencyPPB(20000))
+	_, err := l.Update(0, start)
+	require.NoError(t, err)
+	require.Equal(t, StateSYNC, l.State())
+
+	// sources are lost
+	for sec := 1; sec < 86400; sec++ {
+		require.NoError(t, l.Tick())
+		c.advance()
+	}
+	end := start.Add(86400 * time.Second)
+	require.Equal(t, time.Duration(0), l.Holdover(start.Add(7*64*time.Second)))
+	require.Equal(t, 86400*time.Second, l.Holdover(end))
+	// last frequency estimate is still applied
+	require.InDelta(t, 20000.0, c.ppb, 1e-6)
+	require.Less(t, math.Abs(c.phase), 1e-6)
+
+	disp := l.Dispersion(end)
+	require.Greater(t, disp, l.Dispersion(start.Add(time.Hour)))
+	require.InDelta(t, phi*86400, disp.Seconds(), 1e-3)
+	require.Equal(t, MaxDispersion, l.Dispersion(end.Add(1e6*time.Second)))
+
+	stats := l.StatsAt(end)
+	require.Equal(t, 86400*time.Second, stats.Holdover)
+	require.Equal(t, disp, stats.Dispersion)
+}
+
+func TestLoopEvents(t *testing.T) {
+	l := NewLoop(&fakeClock{})
+	l.PanicThreshold = time.Minute
+	l.Events = &events.Bus{}
+	var got []events.Event
+	l.Events.Subscribe(func(e events.Event) {
+		// subscribers may look at the loop
+		l.Stats()
+		got = append(got, e)
+	})
+
+	_, err := l.Update(time.Second, start)
+	require.NoError(t, err)
+	_, err = l.Update(-time.Hour, start)
+	require.ErrorIs(t, err, ErrPanic)
+	require.Equal(t, []events.Event{
+		{Time: start, Kind: events.ClockStep, Offset: time.Second, Adjustment: &events.Adjustment{Before: 1}},
+		{Time: start, Kind: events.PanicThreshold, Offset: -time.Hour},
+	}, got)
+
+	got = nil
+	l.checkHoldover(start.Add(7 * 64 * time.Second))
+	require.Empty(t, got)
+	l.checkHoldover(start.Add(8 * 64 * time.Second))
+	l.checkHoldover(start.Add(9 * 64 * time.Second))
+	require.Len(t, got, 1, "holdover is reported once")
+	require.Equal(t, events.HoldoverEntered, got[0].Kind)
+	require.Equal(t, "no updates for 8m32s", got[0].Reason)
+
+	// updates end holdover, so it is reported again next time
+	at := start.Add(DefaultStepout)
+	_, err = l.Update(0, at)
+	require.NoError(t, err)
+	l.checkHoldover(at.Add(64 * time.Second))
+	l.checkHoldover(at.Add(9 * 64 * time.Second))
+	require.Len(t, got, 3)
+	require.Equal(t, events.ClockSlew, got[1].Kind)
+	require.Equal(t, events.HoldoverEntered, got[2].Kind)
+}
+
+func TestLoopAdjustmentEvents(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.Events = &events.Bus{}
+	var got []events.Event
+	l.Events.Subscribe(func(e events.Event) {
+		l.Stats()
+		got = append(got, e)
+	})
+
+	require.NoError(t, l.SetFrequencyPPB(1000))
+	require.NoError(t, l.SetFrequencyPPB(1000))
+	require.Len(t, got, 1, "unchanged frequency is not reported")
+	assert.Equal(t, events.Event{Time: got[0].Time, Kind: events.FrequencyChange, Adjustment: &events.Adjustment{After: 1000}}, got[0])
+
+	got = nil
+	_, err := l.UpdateFrom("10.0.0.1:123", time.Millisecond, start)
+	require.NoError(t, err)
+	action, err := l.UpdateFrom("10.0.0.1:123", 2*time.Millisecond, start.Add(64*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	require.Len(t, got, 3)
+	assert.Equal(t, events.Event{Time: start, Kind: events.ClockSlew, Source: "10.0.0.1:123", Offset: time.Millisecond,
+		Adjustment: &events.Adjustment{After: 0.001}}, got[0])
+	assert.Equal(t, events.ClockSlew, got[1].Kind)
+	assert.Equal(t, 0.001, got[1].Adjustment.Before)
+	assert.Equal(t, events.FrequencyChange, got[2].Kind)
+	assert.Equal(t, "10.0.0.1:123", got[2].Source)
+	assert.Equal(t, 1000.0, got[2].Adjustment.Before)
+	assert.Equal(t, l.FrequencyPPB(), got[2].Adjustment.After)
+}
+
+func TestLoopMaxChange(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.MaxChange = 500 * time.Millisecond
+	l.MaxRefusals = 1
+	var alerts []error
+	l.OnAlert = func(err error) {
+		alerts = append(alerts, err)
+	}
+	// the clock is set regardless
+	action, err := l.Update(time.Second, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+
+	action, err = l.Update(time.Second, start.Add(DefaultStepout))
+	require.NoError(t, err)
+	require.Equal(t, ActionRefuse, action)
+	action, err = l.Update(-time.Second, start.Add(DefaultStepout+64*time.Second))
+	require.ErrorIs(t, err, ErrRefused)
+	require.Equal(t, ActionRefuse, action)
+	require.Len(t, alerts, 1)
+	require.Equal(t, []time.Duration{time.Second}, c.steps)
+
+	// accepted offset starts over
+	action, err = l.Update(time.Millisecond, start.Add(DefaultStepout+128*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	action, err = l.Update(time.Second, start.Add(DefaultStepout+192*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, ActionRefuse, action)
+	require.Len(t, alerts, 1)
+}
+
+func TestLoopMaxDrift(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.MaxDriftPPB = 10000
+	_, err := l.Update(0, start)
+	require.NoError(t, err)
+	// 100ms over stepout is over 100ppm
+	action, err := l.Update(100*time.Millisecond, start.Add(DefaultStepout))
+	require.ErrorIs(t, err, ErrRefused)
+	require.Equal(t, ActionRefuse, action)
+	require.Equal(t, StateFREQ, l.State())
+	require.Equal(t, 0.0, l.FrequencyPPB())
+
+	action, err = l.Update(time.Millisecond, start.Add(DefaultStepout))
+	require.NoError(t, err)
+	require.Equal(t, ActionSlew, action)
+	require.Equal(t, StateSYNC, l.State())
+}
+
+func TestLoopStats(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	stats := l.Stats()
+	require.Equal(t, StateNSET, stats.State)
+	require.Equal(t, DefaultMinPoll, stats.Poll)
+	require.True(t, stats.LastAdjustment.IsZero())
+
+	require.NoError(t, l.SetFrequencyPPB(-1500))
+	_, err := l.Update(2*time.Millisecond, start)
+	require.NoError(t, err)
+	// spike is not an adjustment
+	_, err = l.Update(time.Second, start.Add(64*time.Second))
+	require.NoError(t, err)
+	stats = l.Stats()
+	require.Equal(t, StateSPIK, stats.State)
+	require.Equal(t, 2*time.Millisecond, stats.Offset)
+	require.Equal(t, 2*time.Millisecond, stats.Residual)
+	require.Equal(t, start, stats.LastAdjustment)
+	require.Equal(t, ActionSlew, stats.LastAction)
+	require.Equal(t, -1500.0, stats.FrequencyPPB)
+
+	require.Equal(t, map[string]string{
+		"state":      "2",
+		"tc":         "6",
+		"mintc":      "6",
+		"offset":     "2.000000",
+		"frequency":  "-1.500",
+		"clk_jitter": "1.000000",
+		"clk_wander": "0.000",
+	}, stats.Variables())
+}
+
+// rtcFakeClock is fake clock with hardware clock
+type rtcFakeClock struct {
+	fakeClock
+	rtcSets int
+	rtcErr  error
+}
+
+func (c *rtcFakeClock) SetRTC() error {
+	c.rtcSets++
+	return c.rtcErr
+}
+
+func TestLoopRTC(t *testing.T) {
+	c := &rtcFakeClock{}
+	l := NewLoop(c)
+	_, err := l.Update(time.Second, start)
+	require.NoError(t, err)
+	require.Equal(t, 1, c.rtcSets)
+
+	// once synchronized
+	_, err = l.Update(time.Millisecond, start.Add(DefaultStepout))
+	require.NoError(t, err)
+	require.Equal(t, StateSYNC, l.State())
+	require.Equal(t, 2, c.rtcSets)
+	_, err = l.Update(time.Millisecond, start.Add(DefaultStepout+64*time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 2, c.rtcSets)
+
+	c.rtcErr = errors.New("EBUSY")
+	l.reset(StateNSET, start, 0)
+	action, err := l.Update(time.Second, start.Add(2*DefaultStepout))
+	require.Error(t, err)
+	require.Equal(t, ActionStep, action)
+	require.Len(t, c.steps, 2)
+}
+
+func TestSystemClockRTC(t *testing.T) {
+	// no device, nothing to do
+	require.NoError(t, SystemClock{}.SetRTC())
+	require.Error(t, SystemClock{RTC: "/dev/nonexistent-rtc"}.SetRTC())
+}
+
+func TestLoopInitialStep(t *testing.T) {
+	c := &fakeClock{}
+	l := NewLoop(c)
+	l.InitialStep = true
+	// booted in 1970
+	offset := 50 * 365 * 24 * time.Hour
+	action, err := l.Update(offset, start)
+	require.NoError(t, err)
+	require.Equal(t, ActionStep, action)
+	require.Equal(t, []time.Duration{offset}, c.steps)
+
+	// only once
+	l.reset(StateSYNC, start, 0)
+	action, err = l.Update(-offset, start.Add(DefaultStepout))
+	require.ErrorIs(t, err, ErrPanic)
+	require.Equal(t, ActionPanic, action)
+}
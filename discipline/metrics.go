@@ -0,0 +1,84 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"github.com/facebookincubator/ntp/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsNamespace prefixes names of all Prometheus metrics of the loop
+const metricsNamespace = "ntp_loop"
+
+func loopDesc(name, help string) *telemetry.Desc {
+	return telemetry.NewDesc(metricsNamespace+"_"+name, help, telemetry.Gauge)
+}
+
+var (
+	stateDesc        = loopDesc("state", "State of the loop: 0 NSET, 1 FSET, 2 SPIK, 3 FREQ, 4 SYNC")
+	pollDesc         = loopDesc("poll", "Poll exponent, the time constant of the loop")
+	offsetDesc       = loopDesc("offset_seconds", "The last offset the loop acted on")
+	residualDesc     = loopDesc("residual_seconds", "What is left of the last offset to slew")
+	frequencyDesc    = loopDesc("frequency_ppb", "Frequency correction of the clock")
+	jitterDesc       = loopDesc("jitter_seconds", "RMS of exponentially weighted offset differences")
+	wanderDesc       = loopDesc("wander_ppb", "RMS of exponentially weighted frequency differences")
+	windowWanderDesc = loopDesc("window_wander_ppb", "RMS of differences between successive frequency corrections over the wander window")
+)
+
+// Collector is Prometheus collector and telemetry source of the loop state
+type Collector struct {
+	loop *Loop
+}
+
+// NewCollector returns collector of the loop state
+func NewCollector(l *Loop) *Collector {
+	return &Collector{loop: l}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	telemetry.Describe(c, ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	telemetry.Collect(c, ch)
+}
+
+// Descs implements telemetry.Source
+func (c *Collector) Descs() []*telemetry.Desc {
+	return []*telemetry.Desc{stateDesc, pollDesc, offsetDesc, residualDesc, frequencyDesc, jitterDesc, wanderDesc,
+		windowWanderDesc}
+}
+
+// Metrics implements telemetry.Source
+func (c *Collector) Metrics() []telemetry.Metric {
+	s := c.loop.Stats()
+	metrics := []telemetry.Metric{
+		{Desc: stateDesc, Value: float64(s.State)},
+		{Desc: pollDesc, Value: float64(s.Poll)},
+		{Desc: offsetDesc, Value: s.Offset.Seconds()},
+		{Desc: residualDesc, Value: s.Residual.Seconds()},
+		{Desc: frequencyDesc, Value: s.FrequencyPPB},
+		{Desc: jitterDesc, Value: s.Jitter.Seconds()},
+		{Desc: wanderDesc, Value: s.WanderPPB},
+	}
+	if c.loop.WanderWindow != 0 {
+		metrics = append(metrics, telemetry.Metric{Desc: windowWanderDesc, Value: s.WindowWanderPPB})
+	}
+	return metrics
+}
@@ -0,0 +1,50 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	l := NewLoop(&fakeClock{})
+	_, err := l.Update(time.Millisecond, start)
+	require.NoError(t, err)
+	r := prometheus.NewRegistry()
+	require.Nil(t, r.Register(NewCollector(l)))
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+	body := scrape()
+	assert.Contains(t, body, "ntp_loop_state 3")
+	assert.Contains(t, body, "ntp_loop_poll 6")
+	assert.Contains(t, body, "ntp_loop_wander_ppb 0")
+	assert.NotContains(t, body, "ntp_loop_window_wander_ppb")
+
+	l.WanderWindow = time.Hour
+	assert.Contains(t, scrape(), "ntp_loop_window_wander_ppb 0")
+}
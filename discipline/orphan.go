@@ -0,0 +1,123 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultOrphanStratum is what ntpd deployments commonly configure with tos orphan
+const DefaultOrphanStratum = 10
+
+// DefaultOrphanTimeout is how long a member of the group is believed to be orphan parent candidate after it was heard from
+const DefaultOrphanTimeout = 5 * time.Minute
+
+// OrphanID returns ID of the server with the address, same as its reference ID: IPv4 address itself
+// or the first four bytes of MD5 of IPv6 one
+func OrphanID(ip net.IP) uint32 {
+	if ip4 := ip.To4(); ip4 != nil {
+		return binary.BigEndian.Uint32(ip4)
+	}
+	sum := md5.Sum(ip.To16())
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Election is the outcome of orphan mode election
+type Election struct {
+	// Orphan is set when there are no upstreams left
+	Orphan bool
+	// Parent is the member of the group to synchronize to, empty when this server is the parent itself and runs free
+	Parent string
+	// Stratum to serve at, 0 if not orphan
+	Stratum int
+}
+
+type orphanCandidate struct {
+	id   uint32
+	seen time.Time
+}
+
+// Orphan implements orphan mode of RFC 5905: once all upstreams are lost, the group of servers peering
+// with each other elects the one with the lowest ID. It runs free at Stratum while the rest synchronize to it
+// at Stratum+1, so the island stays consistent instead of drifting apart. It is safe for concurrent use
+type Orphan struct {
+	// ID of this server, usually OrphanID of its address
+	ID uint32
+	// Stratum is DefaultOrphanStratum if not set
+	Stratum int
+	// Timeout is DefaultOrphanTimeout if not set
+	Timeout time.Duration
+
+	mu         sync.Mutex
+	candidates map[string]orphanCandidate
+}
+
+func (o *Orphan) stratum() int {
+	if o.Stratum == 0 {
+		return DefaultOrphanStratum
+	}
+	return o.Stratum
+}
+
+// Observe records response of a member of the group. Members serving at orphan stratum are parent candidates,
+// others are withdrawn from the election
+func (o *Orphan) Observe(peer string, id uint32, stratum int, at time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.candidates == nil {
+		o.candidates = map[string]orphanCandidate{}
+	}
+	if stratum != o.stratum() {
+		delete(o.candidates, peer)
+		return
+	}
+	o.candidates[peer] = orphanCandidate{id: id, seen: at}
+}
+
+// Elect returns the role of this server as of now. upstreams tells if any upstream server is still usable
+func (o *Orphan) Elect(now time.Time, upstreams bool) Election {
+	if upstreams {
+		return Election{}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = DefaultOrphanTimeout
+	}
+	e := Election{Orphan: true, Stratum: o.stratum()}
+	best := o.ID
+	for peer, c := range o.candidates {
+		if now.Sub(c.seen) > timeout {
+			delete(o.candidates, peer)
+			continue
+		}
+		// ties are broken by peer name so every member picks the same one
+		if c.id < best || (c.id == best && e.Parent != "" && peer < e.Parent) {
+			best = c.id
+			e.Parent = peer
+		}
+	}
+	if e.Parent != "" {
+		e.Stratum++
+	}
+	return e
+}
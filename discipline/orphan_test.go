@@ -0,0 +1,52 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discipline
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphanID(t *testing.T) {
+	assert.Equal(t, uint32(0x0a000001), OrphanID(net.ParseIP("10.0.0.1")))
+	assert.NotEqual(t, OrphanID(net.ParseIP("2001:db8::1")), OrphanID(net.ParseIP("2001:db8::2")))
+}
+
+func TestOrphanElect(t *testing.T) {
+	o := &Orphan{ID: OrphanID(net.ParseIP("10.0.0.2"))}
+	assert.Equal(t, Election{}, o.Elect(start, true))
+	// alone, this server is the parent
+	assert.Equal(t, Election{Orphan: true, Stratum: 10}, o.Elect(start, false))
+
+	o.Observe("10.0.0.3", OrphanID(net.ParseIP("10.0.0.3")), 10, start)
+	assert.Equal(t, Election{Orphan: true, Stratum: 10}, o.Elect(start, false))
+
+	o.Observe("10.0.0.1", OrphanID(net.ParseIP("10.0.0.1")), 10, start)
+	assert.Equal(t, Election{Orphan: true, Parent: "10.0.0.1", Stratum: 11}, o.Elect(start, false))
+
+	// 10.0.0.1 regained upstream and serves at stratum 3
+	o.Observe("10.0.0.1", OrphanID(net.ParseIP("10.0.0.1")), 3, start.Add(time.Minute))
+	assert.Equal(t, Election{Orphan: true, Stratum: 10}, o.Elect(start.Add(time.Minute), false))
+
+	// 10.0.0.1 is orphan again, then goes silent
+	o.Observe("10.0.0.1", OrphanID(net.ParseIP("10.0.0.1")), 10, start.Add(2*time.Minute))
+	assert.Equal(t, "10.0.0.1", o.Elect(start.Add(6*time.Minute), false).Parent)
+	assert.Equal(t, "", o.Elect(start.Add(8*time.Minute), false).Parent)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package events lets applications react to significant events of time synchronization,
+// e.g. pause trading on clock steps or fence a database once the clock is in holdover
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the type of event
+type Kind string
+
+// Events
+const (
+	// ClockStep is the clock stepped by Offset measured against Source
+	ClockStep Kind = "clock_step"
+	// ClockSlew is Offset measured against Source being slewed
+	ClockSlew Kind = "clock_slew"
+	// FrequencyChange is frequency correction of the clock changed, by the loop or from Source, e.g. drift file
+	FrequencyChange Kind = "frequency_change"
+	// PanicThreshold is Offset exceeding panic threshold refused
+	PanicThreshold Kind = "panic_threshold"
+	// LeapArmed is leap second armed in the kernel, Reason tells whether it is inserted or deleted
+	LeapArmed Kind = "leap_armed"
+	// SourceSelected is Source which may be selected again to discipline the clock
+	SourceSelected Kind = "source_selected"
+	// SourceDeselected is Source no longer selected to discipline the clock, Reason tells why
+	SourceDeselected Kind = "source_deselected"
+	// HoldoverEntered is the clock running on its frequency estimate alone, no updates came in for Reason
+	HoldoverEntered Kind = "holdover_entered"
+)
+
+// Event is a single event
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind Kind      `json:"kind"`
+	// Source is the address or reference ID of time source the event is about
+	Source string        `json:"source,omitempty"`
+	Offset time.Duration `json:"offset_ns,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+	// Adjustment is set for clock steps, slews and frequency changes
+	Adjustment *Adjustment `json:"adjustment,omitempty"`
+}
+
+// Adjustment is what the clock went through: for steps, offset of the clock in seconds before and after the step;
+// for slews, offset left to slew in seconds before and after the update; for frequency changes, frequency correction in PPB
+type Adjustment struct {
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+}
+
+// IsAdjustment tells whether the event is the clock being adjusted
+func (e Event) IsAdjustment() bool {
+	return e.Kind == ClockStep || e.Kind == ClockSlew || e.Kind == FrequencyChange
+}
+
+type subscriber struct {
+	id int
+	f  func(Event)
+}
+
+// Bus delivers published events to subscribers. It is safe for concurrent use, nil one delivers nothing
+type Bus struct {
+	mu          sync.Mutex
+	next        int
+	subscribers []subscriber
+	dropped     int64
+}
+
+// Subscribe calls f with every event published from now on, until unsubscribe is called. f is called from
+// the goroutine publishing the event, which steers the clock, so it must not block
+func (b *Bus) Subscribe(f func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers = append(b.subscribers, subscriber{id: id, f: f})
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subscribers {
+			if s.id == id {
+				b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Channel returns channel receiving events published from now on, until cancel is called, which closes it.
+// Events which don't fit into the channel of size are dropped
+func (b *Bus) Channel(size int) (events <-chan Event, cancel func()) {
+	ch := make(chan Event, size)
+	var mu sync.Mutex
+	closed := false
+	unsubscribe := b.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+			atomic.AddInt64(&b.dropped, 1)
+		}
+	})
+	return ch, func() {
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// Publish delivers the event to subscribers, filling in the time if it's not set
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	subscribers := make([]subscriber, len(b.subscribers))
+	copy(subscribers, b.subscribers)
+	b.mu.Unlock()
+	for _, s := range subscribers {
+		s.f(e)
+	}
+}
+
+// Dropped returns how many events didn't fit into channels
+func (b *Bus) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
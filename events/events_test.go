@@ -0,0 +1,61 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusSubscribe(t *testing.T) {
+	b := &Bus{}
+	var got []Event
+	unsubscribe := b.Subscribe(func(e Event) { got = append(got, e) })
+	b.Publish(Event{Kind: ClockStep, Offset: time.Second})
+	unsubscribe()
+	b.Publish(Event{Kind: ClockStep})
+
+	require.Len(t, got, 1)
+	assert.Equal(t, ClockStep, got[0].Kind)
+	assert.Equal(t, time.Second, got[0].Offset)
+	assert.False(t, got[0].Time.IsZero())
+}
+
+func TestBusChannel(t *testing.T) {
+	b := &Bus{}
+	events, cancel := b.Channel(1)
+	at := time.Unix(1600000000, 0)
+	b.Publish(Event{Time: at, Kind: SourceDeselected, Source: "10.0.0.1:123"})
+	b.Publish(Event{Kind: SourceSelected, Source: "10.0.0.1:123"})
+	assert.Equal(t, int64(1), b.Dropped())
+
+	e := <-events
+	assert.Equal(t, Event{Time: at, Kind: SourceDeselected, Source: "10.0.0.1:123"}, e)
+	cancel()
+	cancel()
+	b.Publish(Event{Kind: HoldoverEntered})
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestBusNil(t *testing.T) {
+	var b *Bus
+	b.Publish(Event{Kind: ClockStep})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Trail is audit trail of clock adjustments: steps, slews and frequency changes with values before and after them
+// and the source responsible, appended to a file as JSON lines, for compliance regimes requiring clock change audit logs.
+// Every record is synced to disk before Record returns. It is safe for concurrent use
+type Trail struct {
+	mu     sync.Mutex
+	f      *os.File
+	enc    *json.Encoder
+	failed int64
+}
+
+// OpenTrail opens the file for appending, creating it if it doesn't exist. Records already in it are kept
+func OpenTrail(path string) (*Trail, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Trail{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record appends the event to the file and syncs it
+func (t *Trail) Record(e Event) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.enc.Encode(e); err != nil {
+		return err
+	}
+	return t.f.Sync()
+}
+
+// Subscribe records clock adjustments published on the bus from now on, until unsubscribe is called.
+// Records are written from the goroutine publishing the event, adjustments being rare enough for that.
+// Failed records are counted by Failed
+func (t *Trail) Subscribe(b *Bus) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if !e.IsAdjustment() {
+			return
+		}
+		if err := t.Record(e); err != nil {
+			atomic.AddInt64(&t.failed, 1)
+		}
+	})
+}
+
+// Failed returns how many adjustments published on the bus failed to be recorded
+func (t *Trail) Failed() int64 {
+	return atomic.LoadInt64(&t.failed)
+}
+
+// Close closes the file
+func (t *Trail) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.f.Close()
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTrail(t *testing.T, path string) []Event {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		records = append(records, e)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestTrail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "adjustments.log")
+	at := time.Unix(1700000000, 0).UTC()
+	step := Event{Time: at, Kind: ClockStep, Source: "10.0.0.1:123", Offset: time.Second, Adjustment: &Adjustment{Before: 1}}
+	freq := Event{Time: at, Kind: FrequencyChange, Source: "10.0.0.1:123", Adjustment: &Adjustment{Before: 10, After: 12.5}}
+
+	trail, err := OpenTrail(path)
+	require.NoError(t, err)
+	b := &Bus{}
+	trail.Subscribe(b)
+	b.Publish(step)
+	b.Publish(Event{Time: at, Kind: HoldoverEntered})
+	require.NoError(t, trail.Close())
+
+	// reopening appends
+	trail, err = OpenTrail(path)
+	require.NoError(t, err)
+	require.NoError(t, trail.Record(freq))
+	require.NoError(t, trail.Close())
+
+	assert.Equal(t, []Event{step, freq}, readTrail(t, path))
+}
+
+func TestTrailFailed(t *testing.T) {
+	trail, err := OpenTrail(filepath.Join(t.TempDir(), "adjustments.log"))
+	require.NoError(t, err)
+	require.NoError(t, trail.Close())
+	b := &Bus{}
+	trail.Subscribe(b)
+	b.Publish(Event{Kind: ClockSlew})
+	assert.Equal(t, int64(1), trail.Failed())
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cmac implements AES-CMAC message authentication code, see RFC 4493
+package cmac
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// Size is the size of AES-CMAC in bytes
+const Size = aes.BlockSize
+
+// CMAC computes AES-CMAC with a given key
+type CMAC struct {
+	block cipher.Block
+	k1    [Size]byte
+	k2    [Size]byte
+}
+
+// New returns CMAC for AES-128, AES-192 or AES-256 key
+func New(key []byte) (*CMAC, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	c := &CMAC{block: block}
+	// Subkeys are derived from encrypted zero block
+	var l [Size]byte
+	block.Encrypt(l[:], l[:])
+	c.k1 = Dbl(l)
+	c.k2 = Dbl(c.k1)
+	return c, nil
+}
+
+// Sum returns AES-CMAC of msg
+func (c *CMAC) Sum(msg []byte) [Size]byte {
+	var x [Size]byte
+	// all blocks but the last one are simply chained
+	for len(msg) > Size {
+		xorBlock(&x, msg[:Size])
+		c.block.Encrypt(x[:], x[:])
+		msg = msg[Size:]
+	}
+	// last block is mixed with one of the subkeys, incomplete one is padded with 10*
+	var last [Size]byte
+	copy(last[:], msg)
+	if len(msg) == Size {
+		xorBlock(&last, c.k1[:])
+	} else {
+		last[len(msg)] = 0x80
+		xorBlock(&last, c.k2[:])
+	}
+	xorBlock(&x, last[:])
+	c.block.Encrypt(x[:], x[:])
+	return x
+}
+
+// Dbl multiplies block by x in GF(2^128), which is the doubling used by CMAC and S2V
+func Dbl(b [Size]byte) [Size]byte {
+	var r [Size]byte
+	carry := b[0] >> 7
+	for i := 0; i < Size-1; i++ {
+		r[i] = b[i]<<1 | b[i+1]>>7
+	}
+	r[Size-1] = b[Size-1] << 1
+	// constant-time reduction by x^128 + x^7 + x^2 + x + 1
+	r[Size-1] ^= 0x87 & (0 - carry)
+	return r
+}
+
+func xorBlock(dst *[Size]byte, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmac
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test vectors from RFC 4493 section 4
+func TestSum(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	c, err := New(key)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		length   int
+		expected string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tt := range tests {
+		sum := c.Sum(msg[:tt.length])
+		assert.Equal(t, tt.expected, hex.EncodeToString(sum[:]), "message of %d bytes", tt.length)
+	}
+}
+
+func TestNewInvalidKey(t *testing.T) {
+	_, err := New([]byte("short"))
+	assert.NotNil(t, err)
+}
+
+func TestDbl(t *testing.T) {
+	// subkeys from RFC 4493 section 4
+	l, _ := hex.DecodeString("7df76b0c1ab899b33e42f047b91b546f")
+	var b [Size]byte
+	copy(b[:], l)
+	k1 := Dbl(b)
+	assert.Equal(t, "fbeed618357133667c85e08f7236a8de", hex.EncodeToString(k1[:]))
+	k2 := Dbl(k1)
+	assert.Equal(t, "f7ddac306ae266ccf90bc11ee46d513b", hex.EncodeToString(k2[:]))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package counter implements counters sharded over CPUs, so goroutines counting packets on different CPUs
+// don't contend for the same cache line. Reads sum up the shards, which makes them slower, but they are rare
+package counter
+
+import (
+	"math/rand"
+	"runtime"
+	"sync/atomic"
+)
+
+// cacheLine is large enough to cover cache lines of common CPUs along with adjacent line prefetch
+const cacheLine = 128
+
+// maxShards caps memory sets of many counters take on hosts with many CPUs
+const maxShards = 64
+
+// Set is a set of counters. Every shard keeps all of them next to each other, a cache line away from other shards.
+// Counting picks a shard at random, which spreads goroutines over shards about as well as per-CPU ones would,
+// without knowing which CPU they run on. It is safe for concurrent use
+type Set struct {
+	n      int
+	stride int
+	mask   uint32
+	values []uint64
+}
+
+// NewSet returns set of n counters with a shard per CPU
+func NewSet(n int) *Set {
+	shards := 1
+	for shards < runtime.GOMAXPROCS(0) && shards < maxShards {
+		shards <<= 1
+	}
+	// padding shards with a cache line keeps them apart wherever the slice starts
+	stride := n + cacheLine/8
+	return &Set{n: n, stride: stride, mask: uint32(shards - 1), values: make([]uint64, shards*stride)}
+}
+
+// Len returns how many counters there are
+func (s *Set) Len() int {
+	return s.n
+}
+
+// Add adds delta to the counter i
+func (s *Set) Add(i int, delta uint64) {
+	shard := int(rand.Uint32() & s.mask)
+	atomic.AddUint64(&s.values[shard*s.stride+i], delta)
+}
+
+// Inc adds 1 to the counter i
+func (s *Set) Inc(i int) {
+	s.Add(i, 1)
+}
+
+// Load returns value of the counter i: sum of its shards. Counts racing with it may or may not be included
+func (s *Set) Load(i int) uint64 {
+	var sum uint64
+	for offset := i; offset < len(s.values); offset += s.stride {
+		sum += atomic.LoadUint64(&s.values[offset])
+	}
+	return sum
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counter
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet(t *testing.T) {
+	s := NewSet(3)
+	require.Equal(t, 3, s.Len())
+	s.Inc(0)
+	s.Add(2, 5)
+	assert.Equal(t, uint64(1), s.Load(0))
+	assert.Equal(t, uint64(0), s.Load(1))
+	assert.Equal(t, uint64(5), s.Load(2))
+}
+
+func TestSetConcurrent(t *testing.T) {
+	s := NewSet(2)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Inc(0)
+				s.Add(1, 2)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(8000), s.Load(0))
+	assert.Equal(t, uint64(16000), s.Load(1))
+}
+
+func BenchmarkSetInc(b *testing.B) {
+	s := NewSet(1)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Inc(0)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fips controls FIPS-constrained crypto mode, in which algorithms not approved by FIPS 140 are refused.
+// The mode is switched on at runtime with Set or at build time with fips build tag
+package fips
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrNotApproved is returned when FIPS mode forbids the algorithm
+var ErrNotApproved = errors.New("algorithm is not allowed in FIPS mode")
+
+var enabled int32
+
+// Enabled reports whether FIPS mode is on
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Set switches FIPS mode on or off
+func Set(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&enabled, v)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package histogram counts values in log-linear buckets, like HdrHistogram does, so quantiles are reported
+// with bounded relative error in constant memory
+package histogram
+
+import (
+	"math"
+	"math/bits"
+)
+
+// subBits is how many leading bits of values tell buckets apart, relative error of quantiles is below 2^-(subBits-1)
+const subBits = 8
+
+const (
+	subCount = 1 << subBits
+	subHalf  = subCount >> 1
+)
+
+// Histogram of non-negative values, negative ones are counted as 0. It is not safe for concurrent use
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	max    int64
+}
+
+// index returns bucket of the value: values below subCount have buckets of their own,
+// larger ones share buckets with values having the same subBits leading bits
+func index(v int64) int {
+	n := bits.Len64(uint64(v))
+	if n <= subBits {
+		return int(v)
+	}
+	shift := n - subBits
+	return subCount + (shift-1)*subHalf + int(v>>shift) - subHalf
+}
+
+// highest returns the highest value counted in the bucket
+func highest(i int) int64 {
+	if i < subCount {
+		return int64(i)
+	}
+	k := i - subCount
+	shift := k/subHalf + 1
+	low := int64(k%subHalf+subHalf) << shift
+	return low + 1<<shift - 1
+}
+
+// Record counts the value
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	i := index(v)
+	if i >= len(h.counts) {
+		counts := make([]uint64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	h.total++
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Count returns how many values were recorded
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+// Max returns the largest value recorded
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Quantile returns the value q of recorded values are at or below, within relative error of bucketing.
+// It is 0 if nothing was recorded
+func (h *Histogram) Quantile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if v := highest(i); v < h.max {
+				return v
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package histogram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	for _, v := range []int64{0, 1, 255, 256, 257, 511, 512, 1000, 123456789, 1 << 62} {
+		i := index(v)
+		require.LessOrEqual(t, v, highest(i), v)
+		if i > 0 {
+			require.Greater(t, v, highest(i-1), v)
+		}
+	}
+	assert.Equal(t, index(256), index(257))
+	assert.NotEqual(t, index(255), index(256))
+}
+
+func TestQuantile(t *testing.T) {
+	var h Histogram
+	assert.Equal(t, int64(0), h.Quantile(0.99))
+	for v := int64(1); v <= 100000; v++ {
+		h.Record(v)
+	}
+	h.Record(-5)
+	assert.Equal(t, uint64(100001), h.Count())
+	assert.Equal(t, int64(100000), h.Max())
+	for _, tt := range []struct {
+		q    float64
+		want float64
+	}{{0.5, 50000}, {0.9, 90000}, {0.99, 99000}, {0.999, 99900}, {1, 100000}} {
+		assert.InEpsilon(t, tt.want, float64(h.Quantile(tt.q)), 1.0/subHalf, tt.q)
+	}
+	assert.Equal(t, int64(0), h.Quantile(0))
+}
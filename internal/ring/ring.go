@@ -0,0 +1,81 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ring implements fixed-size ring buffers of the latest values. Their memory is allocated once,
+// so keeping recent samples for long doesn't grow the heap or leave garbage behind
+package ring
+
+// Ring keeps up to its size of the latest values pushed, overwriting the oldest ones.
+// Zero Ring keeps nothing until Init is called. It is not safe for concurrent use
+type Ring[T any] struct {
+	buf   []T
+	start int
+	n     int
+}
+
+// Init allocates room for size values, dropping the ones kept so far
+func (r *Ring[T]) Init(size int) {
+	r.buf = make([]T, size)
+	r.start, r.n = 0, 0
+}
+
+// Cap returns how many values the ring keeps at most
+func (r *Ring[T]) Cap() int {
+	return len(r.buf)
+}
+
+// Len returns how many values the ring keeps
+func (r *Ring[T]) Len() int {
+	return r.n
+}
+
+// Push adds the value, overwriting the oldest one if the ring is full
+func (r *Ring[T]) Push(v T) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = v
+		r.n++
+		return
+	}
+	r.buf[r.start] = v
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// At returns i-th oldest value, At(0) is the oldest and At(Len()-1) the latest one
+func (r *Ring[T]) At(i int) T {
+	if i < 0 || i >= r.n {
+		panic("ring: index out of range")
+	}
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// Drop removes n oldest values
+func (r *Ring[T]) Drop(n int) {
+	if n > r.n {
+		n = r.n
+	}
+	if n <= 0 {
+		return
+	}
+	var zero T
+	for i := 0; i < n; i++ {
+		r.buf[(r.start+i)%len(r.buf)] = zero
+	}
+	r.start = (r.start + n) % len(r.buf)
+	r.n -= n
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func values(r *Ring[int]) []int {
+	var result []int
+	for i := 0; i < r.Len(); i++ {
+		result = append(result, r.At(i))
+	}
+	return result
+}
+
+func TestRing(t *testing.T) {
+	var r Ring[int]
+	r.Push(1)
+	assert.Equal(t, 0, r.Len(), "zero ring keeps nothing")
+
+	r.Init(3)
+	assert.Equal(t, 3, r.Cap())
+	r.Push(1)
+	r.Push(2)
+	assert.Equal(t, []int{1, 2}, values(&r))
+	r.Push(3)
+	r.Push(4)
+	r.Push(5)
+	assert.Equal(t, []int{3, 4, 5}, values(&r), "the oldest values are overwritten")
+	assert.Panics(t, func() { r.At(3) })
+
+	r.Drop(2)
+	assert.Equal(t, []int{5}, values(&r))
+	r.Push(6)
+	r.Push(7)
+	r.Push(8)
+	assert.Equal(t, []int{6, 7, 8}, values(&r))
+	r.Drop(10)
+	assert.Equal(t, 0, r.Len())
+	r.Push(9)
+	assert.Equal(t, []int{9}, values(&r))
+}
+
+func TestRingAllocs(t *testing.T) {
+	var r Ring[float64]
+	r.Init(8)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Push(1)
+		r.Drop(1)
+		r.Push(2)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package window keeps recent values of a time series for statistics over sliding time windows
+package window
+
+import (
+	"math"
+	"time"
+
+	"github.com/facebookincubator/ntp/internal/ring"
+)
+
+// MaxPoints is how many latest values Series keeps, windows spanning more of them are cut short
+const MaxPoints = 1024
+
+// point is a value with the time it was added at in Unix nanoseconds, so points have no pointers for GC to scan
+type point struct {
+	at int64
+	v  float64
+}
+
+// Series is a time series of values added in time order. Room for MaxPoints of them is allocated
+// with the first one, adding more allocates nothing. It is not safe for concurrent use
+type Series struct {
+	points ring.Ring[point]
+}
+
+// Add appends the value at the time, dropping the oldest one once there are MaxPoints
+func (s *Series) Add(at time.Time, v float64) {
+	if s.points.Cap() == 0 {
+		s.points.Init(MaxPoints)
+	}
+	s.points.Push(point{at: at.UnixNano(), v: v})
+}
+
+// RMSDiff returns RMS of differences between successive values added within the window before now,
+// and how many values it was computed from. It is 0 unless there are at least two
+func (s *Series) RMSDiff(now time.Time, window time.Duration) (float64, int) {
+	from := now.Add(-window).UnixNano()
+	i := s.points.Len()
+	for i > 0 && s.points.At(i-1).at >= from {
+		i--
+	}
+	n := s.points.Len() - i
+	if n < 2 {
+		return 0, n
+	}
+	var sum float64
+	prev := s.points.At(i)
+	for j := i + 1; j < s.points.Len(); j++ {
+		p := s.points.At(j)
+		d := p.v - prev.v
+		sum += d * d
+		prev = p
+	}
+	return math.Sqrt(sum / float64(n-1)), n
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesRMSDiff(t *testing.T) {
+	var s Series
+	now := time.Unix(1600000000, 0)
+	rms, n := s.RMSDiff(now, time.Minute)
+	assert.Equal(t, 0.0, rms)
+	assert.Equal(t, 0, n)
+
+	s.Add(now.Add(-2*time.Minute), 100)
+	s.Add(now.Add(-50*time.Second), 1)
+	s.Add(now.Add(-30*time.Second), 4)
+	s.Add(now.Add(-10*time.Second), 0)
+	rms, n = s.RMSDiff(now, time.Minute)
+	assert.Equal(t, 3, n)
+	// differences are 3 and -4
+	assert.InDelta(t, 3.5355, rms, 1e-4)
+
+	rms, n = s.RMSDiff(now, 20*time.Second)
+	assert.Equal(t, 0.0, rms)
+	assert.Equal(t, 1, n)
+}
+
+func TestSeriesMaxPoints(t *testing.T) {
+	var s Series
+	now := time.Unix(1600000000, 0)
+	for i := 0; i < MaxPoints+10; i++ {
+		s.Add(now.Add(time.Duration(i)*time.Second), float64(i%2))
+	}
+	rms, n := s.RMSDiff(now.Add(time.Hour), 24*time.Hour)
+	assert.Equal(t, MaxPoints, n)
+	assert.Equal(t, 1.0, rms)
+}
+
+func TestSeriesAllocs(t *testing.T) {
+	var s Series
+	now := time.Unix(1600000000, 0)
+	s.Add(now, 0)
+	allocs := testing.AllocsPerRun(2*MaxPoints, func() {
+		now = now.Add(time.Second)
+		s.Add(now, 1)
+	})
+	assert.Equal(t, 0.0, allocs, "room for all the points is allocated with the first one")
+}
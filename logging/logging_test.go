@@ -0,0 +1,38 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOr(t *testing.T) {
+	assert.Equal(t, slog.Default(), Or(nil))
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	assert.Equal(t, l, Or(l))
+	Or(l).Info("Stepped the clock", "offset", "1s")
+	assert.Contains(t, buf.String(), "level=INFO msg=\"Stepped the clock\" offset=1s")
+}
+
+func TestDiscard(t *testing.T) {
+	Discard.Error("dropped", "key", "value")
+}
@@ -0,0 +1,162 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout is used when Client has no timeout set
+const DefaultTimeout = 5 * time.Second
+
+// Client talks to management API of the daemon
+type Client struct {
+	http *http.Client
+	// base is URL the paths are relative to
+	base string
+}
+
+// DialUnix returns client of the API on Unix socket
+func DialUnix(path string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return NewClient(&http.Client{Transport: transport, Timeout: DefaultTimeout}, "http://unix")
+}
+
+// NewClient returns client of the API at base URL, like http://localhost:8080
+func NewClient(c *http.Client, base string) *Client {
+	return &Client{http: c, base: base}
+}
+
+func (c *Client) do(method, path string, body, result interface{}) error {
+	var r bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&r).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.base+path, &r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e errorBody
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		if resp.StatusCode == http.StatusNotImplemented {
+			return ErrNotSupported
+		}
+		return fmt.Errorf("%s %s failed with %s: %s", method, path, resp.Status, e.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// SyncState returns synchronization state of the daemon
+func (c *Client) SyncState() (*SyncState, error) {
+	var s SyncState
+	if err := c.do(http.MethodGet, PathSync, nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Peers returns sources of time the daemon polls
+func (c *Client) Peers() ([]Peer, error) {
+	var p []Peer
+	if err := c.do(http.MethodGet, PathPeers, nil, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// MRU returns the list of clients the daemon recently saw
+func (c *Client) MRU() ([]MRUEntry, error) {
+	var m []MRUEntry
+	if err := c.do(http.MethodGet, PathMRU, nil, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TopTalkers returns n most active clients among the most recently seen ones, by request rate and by KoD count
+func (c *Client) TopTalkers(n int) (*TopTalkers, error) {
+	var t TopTalkers
+	if err := c.do(http.MethodGet, fmt.Sprintf("%s?n=%d", PathTopTalkers, n), nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RateLimit returns the status of rate limiter of the daemon
+func (c *Client) RateLimit() (*RateLimit, error) {
+	var r RateLimit
+	if err := c.do(http.MethodGet, PathRateLimit, nil, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Probes returns sources of probes the daemon never answers
+func (c *Client) Probes() ([]Probe, error) {
+	var p []Probe
+	if err := c.do(http.MethodGet, PathProbes, nil, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AddSource adds source of time at host:port
+func (c *Client) AddSource(addr string) error {
+	return c.do(http.MethodPost, PathAddSource, &Source{Addr: addr}, nil)
+}
+
+// RemoveSource removes source of time at host:port
+func (c *Client) RemoveSource(addr string) error {
+	return c.do(http.MethodPost, PathRemoveSource, &Source{Addr: addr}, nil)
+}
+
+// Health returns health of the daemon evaluated with default policy
+func (c *Client) Health() (*HealthStatus, error) {
+	var h HealthStatus
+	if err := c.do(http.MethodGet, PathHealth, nil, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Poll makes the daemon poll the source right away
+func (c *Client) Poll(addr string) error {
+	return c.do(http.MethodPost, PathPoll, &Source{Addr: addr}, nil)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// chronySeconds formats duration the way chronyc -c does. Note chrony reports offsets the other way around:
+// positive means the local clock is ahead
+func chronySeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 9, 64)
+}
+
+// refIDHex returns reference ID the way chronyc prints it: IPv4 address or ASCII code as 8 hex digits
+func refIDHex(refID string) string {
+	if ip := net.ParseIP(refID).To4(); ip != nil {
+		return fmt.Sprintf("%08X", []byte(ip))
+	}
+	b := make([]byte, 4)
+	copy(b, refID)
+	return fmt.Sprintf("%08X", b)
+}
+
+// chronyLeap returns leap status as chronyc prints it
+func chronyLeap(s *SyncState) string {
+	if s.State != "SYNC" {
+		return "Not synchronised"
+	}
+	switch s.Leap {
+	case 1:
+		return "Insert second"
+	case 2:
+		return "Delete second"
+	case 3:
+		return "Not synchronised"
+	}
+	return "Normal"
+}
+
+// WriteTrackingCSV writes synchronization state in the format of chronyc -c tracking: reference ID, name, stratum,
+// reference time, system time, last offset, RMS offset, frequency, residual frequency, skew, root delay,
+// root dispersion, update interval and leap status. Residual frequency, skew, root delay and root dispersion
+// are not tracked and written as zeros
+func WriteTrackingCSV(w io.Writer, s *SyncState) error {
+	var refTime string
+	if s.LastAdjustment.IsZero() {
+		refTime = "0.000000000"
+	} else {
+		refTime = fmt.Sprintf("%d.%09d", s.LastAdjustment.Unix(), s.LastAdjustment.Nanosecond())
+	}
+	// correction speeds the clock up, so the clock itself is that much slow
+	freq := -s.FrequencyPPB / 1e3
+	if freq == 0 {
+		freq = 0 // no negative zero
+	}
+	c := csv.NewWriter(w)
+	err := c.Write([]string{
+		refIDHex(s.RefID),
+		s.RefID,
+		strconv.Itoa(s.Stratum),
+		refTime,
+		chronySeconds(-s.Offset),
+		chronySeconds(-s.Offset),
+		chronySeconds(s.Jitter),
+		strconv.FormatFloat(freq, 'f', 3, 64),
+		"0.000",
+		"0.000",
+		chronySeconds(0),
+		chronySeconds(0),
+		strconv.FormatFloat(math.Ldexp(1, s.Poll), 'f', 1, 64),
+		chronyLeap(s),
+	})
+	if err != nil {
+		return err
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// WriteSourcesCSV writes peers in the format of chronyc -c sources: mode, state, name, stratum, poll, reach,
+// seconds since last poll, adjusted offset, measured offset and error. The peer the daemon is synchronized to
+// is marked with *, unreachable ones with ?
+func WriteSourcesCSV(w io.Writer, s *SyncState, peers []Peer, now time.Time) error {
+	c := csv.NewWriter(w)
+	for _, p := range peers {
+		name := p.Addr
+		if host, _, err := net.SplitHostPort(p.Addr); err == nil {
+			name = host
+		}
+		state := "+"
+		if p.Reach == 0 {
+			state = "?"
+		} else if name == s.RefID {
+			state = "*"
+		}
+		lastRx := "-"
+		if !p.LastPoll.IsZero() {
+			lastRx = strconv.FormatInt(int64(now.Sub(p.LastPoll).Seconds()), 10)
+		}
+		err := c.Write([]string{
+			"^",
+			state,
+			name,
+			strconv.Itoa(p.Stratum),
+			strconv.Itoa(s.Poll),
+			strconv.FormatUint(uint64(p.Reach), 8),
+			lastRx,
+			chronySeconds(-p.Offset),
+			chronySeconds(-p.Offset),
+			chronySeconds(p.Delay/2 + p.Dispersion + p.Jitter),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTrackingCSV(t *testing.T) {
+	s := &SyncState{
+		State:          "SYNC",
+		Stratum:        2,
+		RefID:          "10.0.0.1",
+		Offset:         12345 * time.Nanosecond,
+		Jitter:         time.Microsecond,
+		FrequencyPPB:   1500,
+		Poll:           6,
+		LastAdjustment: time.Unix(1600000000, 5),
+	}
+	var b bytes.Buffer
+	require.Nil(t, WriteTrackingCSV(&b, s))
+	assert.Equal(t, "0A000001,10.0.0.1,2,1600000000.000000005,-0.000012345,-0.000012345,0.000001000,-1.500,0.000,0.000,0.000000000,0.000000000,64.0,Normal\n", b.String())
+
+	b.Reset()
+	require.Nil(t, WriteTrackingCSV(&b, &SyncState{State: "UNSET", RefID: "GPS", Poll: -1}))
+	assert.Equal(t, "47505300,GPS,0,0.000000000,0.000000000,0.000000000,0.000000000,0.000,0.000,0.000,0.000000000,0.000000000,0.5,Not synchronised\n", b.String())
+}
+
+func TestWriteSourcesCSV(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	s := &SyncState{State: "SYNC", RefID: "10.0.0.1", Poll: 6}
+	peers := []Peer{
+		{Addr: "10.0.0.1:123", Stratum: 1, Reach: 0377, Offset: -time.Millisecond, Delay: 2 * time.Millisecond, LastPoll: now.Add(-10 * time.Second)},
+		{Addr: "10.0.0.2:123", Stratum: 1, Reach: 0376, Offset: time.Millisecond, Dispersion: time.Millisecond, LastPoll: now.Add(-20 * time.Second)},
+		{Addr: "10.0.0.3:123"},
+	}
+	var b bytes.Buffer
+	require.Nil(t, WriteSourcesCSV(&b, s, peers, now))
+	assert.Equal(t, "^,*,10.0.0.1,1,6,377,10,0.001000000,0.001000000,0.001000000\n"+
+		"^,+,10.0.0.2,1,6,376,20,-0.001000000,-0.001000000,0.001000000\n"+
+		"^,?,10.0.0.3,0,6,0,-,0.000000000,0.000000000,0.000000000\n", b.String())
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Health is overall health of time synchronization of the daemon
+type Health string
+
+// Health of the daemon
+const (
+	// HealthSynchronized means the clock is synchronized to enough sources within the offset bound
+	HealthSynchronized Health = "synchronized"
+	// HealthDegraded means the clock is synchronized, but is off by more than the bound, lacks sources or is in holdover
+	HealthDegraded Health = "degraded"
+	// HealthUnsynchronized means the clock is not synchronized, or has been in holdover for too long
+	HealthUnsynchronized Health = "unsynchronized"
+)
+
+// Defaults of HealthPolicy
+const (
+	DefaultMaxOffset   = 10 * time.Millisecond
+	DefaultMaxHoldover = time.Hour
+	DefaultMinSources  = 1
+)
+
+// HealthPolicy decides health of the daemon from its synchronization state and peers
+type HealthPolicy struct {
+	// MaxOffset is DefaultMaxOffset if not set. The clock off by more is degraded
+	MaxOffset time.Duration
+	// MaxHoldover is DefaultMaxHoldover if not set. The clock in holdover for longer is unsynchronized
+	MaxHoldover time.Duration
+	// MinSources is DefaultMinSources if not set. The clock with fewer sources which answered the last poll is degraded,
+	// without any it is unsynchronized
+	MinSources int
+}
+
+// HealthStatus is health of the daemon along with what it was decided on
+type HealthStatus struct {
+	Status Health `json:"status"`
+	// Reasons tell why the daemon isn't synchronized, empty if it is
+	Reasons   []string      `json:"reasons,omitempty"`
+	State     string        `json:"state"`
+	Offset    time.Duration `json:"offset_ns"`
+	Holdover  time.Duration `json:"holdover_ns"`
+	Reachable int           `json:"reachable"`
+}
+
+func (p HealthPolicy) maxOffset() time.Duration {
+	if p.MaxOffset == 0 {
+		return DefaultMaxOffset
+	}
+	return p.MaxOffset
+}
+
+func (p HealthPolicy) maxHoldover() time.Duration {
+	if p.MaxHoldover == 0 {
+		return DefaultMaxHoldover
+	}
+	return p.MaxHoldover
+}
+
+func (p HealthPolicy) minSources() int {
+	if p.MinSources == 0 {
+		return DefaultMinSources
+	}
+	return p.MinSources
+}
+
+// Evaluate returns health of the daemon in the synchronization state with the peers.
+// Holdover is the one of the loop the state was taken from
+func (p HealthPolicy) Evaluate(state *SyncState, peers []Peer) *HealthStatus {
+	h := &HealthStatus{Status: HealthSynchronized, State: state.State, Offset: state.Offset, Holdover: state.Holdover}
+	for _, peer := range peers {
+		if peer.Reach&1 == 1 {
+			h.Reachable++
+		}
+	}
+	degrade := func(status Health, format string, a ...interface{}) {
+		if status == HealthUnsynchronized || h.Status == HealthSynchronized {
+			h.Status = status
+		}
+		h.Reasons = append(h.Reasons, fmt.Sprintf(format, a...))
+	}
+	switch state.State {
+	case "SYNC":
+	case "SPIK":
+		degrade(HealthDegraded, "loop is in %s state", state.State)
+	default:
+		degrade(HealthUnsynchronized, "loop is in %s state", state.State)
+	}
+	if h.Reachable == 0 {
+		degrade(HealthUnsynchronized, "no reachable sources")
+	} else if h.Reachable < p.minSources() {
+		degrade(HealthDegraded, "%d reachable sources, fewer than %d", h.Reachable, p.minSources())
+	}
+	if h.Offset > p.maxOffset() || -h.Offset > p.maxOffset() {
+		degrade(HealthDegraded, "offset %v exceeds %v", h.Offset, p.maxOffset())
+	}
+	if h.Holdover > p.maxHoldover() {
+		degrade(HealthUnsynchronized, "holdover for %v exceeds %v", h.Holdover, p.maxHoldover())
+	} else if h.Holdover > 0 {
+		degrade(HealthDegraded, "holdover for %v", h.Holdover)
+	}
+	return h
+}
+
+// HealthHandler returns handler of health endpoints only, for Kubernetes probes and load balancer checks
+// which can't reach Unix socket of the API
+func HealthHandler(b Backend, policy HealthPolicy) http.Handler {
+	return (&API{Backend: b, Health: policy}).HealthHandler()
+}
+
+// HealthHandler returns handler of health endpoints of the API only
+func (a *API) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	a.handleHealth(mux)
+	return mux
+}
+
+// handleHealth registers health endpoints with the mux. PathHealth always responds with 200 and health in the body,
+// PathReady responds with 503 when the daemon is unsynchronized
+func (a *API) handleHealth(mux *http.ServeMux) {
+	evaluate := func() *HealthStatus { return a.Health.Evaluate(a.Backend.SyncState(), a.Backend.Peers()) }
+	mux.HandleFunc(PathHealth, a.get(func() (interface{}, error) { return evaluate(), nil }))
+	mux.HandleFunc(PathReady, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			a.reply(w, http.StatusMethodNotAllowed, errorBody{Error: "only GET is allowed"})
+			return
+		}
+		h := evaluate()
+		status := http.StatusOK
+		if h.Status == HealthUnsynchronized {
+			status = http.StatusServiceUnavailable
+		}
+		a.reply(w, status, h)
+	})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/discipline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthPolicyEvaluate(t *testing.T) {
+	reachable := []Peer{{Addr: "10.0.0.1:123", Reach: 0xff}, {Addr: "10.0.0.2:123", Reach: 0x01}}
+	unreachable := []Peer{{Addr: "10.0.0.1:123", Reach: 0xfe}}
+	synced := SyncState{State: "SYNC", Poll: 6, Offset: time.Millisecond, LastAdjustment: time.Unix(1600000000, 0)}
+
+	tests := []struct {
+		name   string
+		policy HealthPolicy
+		state  func(*SyncState)
+		peers  []Peer
+		want   Health
+	}{
+		{name: "synchronized", peers: reachable, want: HealthSynchronized},
+		{name: "unreachable", peers: unreachable, want: HealthUnsynchronized},
+		{name: "no peers", want: HealthUnsynchronized},
+		{name: "unset", state: func(s *SyncState) { s.State = "NSET" }, peers: reachable, want: HealthUnsynchronized},
+		{name: "spike", state: func(s *SyncState) { s.State = "SPIK" }, peers: reachable, want: HealthDegraded},
+		{name: "offset", state: func(s *SyncState) { s.Offset = -20 * time.Millisecond }, peers: reachable, want: HealthDegraded},
+		{name: "offset within bound", policy: HealthPolicy{MaxOffset: 50 * time.Millisecond}, state: func(s *SyncState) { s.Offset = 20 * time.Millisecond }, peers: reachable, want: HealthSynchronized},
+		{name: "few sources", policy: HealthPolicy{MinSources: 3}, peers: reachable, want: HealthDegraded},
+		{name: "holdover", state: func(s *SyncState) { s.Holdover = 10 * time.Minute }, peers: reachable, want: HealthDegraded},
+		{name: "long holdover", state: func(s *SyncState) { s.Holdover = 2 * time.Hour }, peers: reachable, want: HealthUnsynchronized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := synced
+			if tt.state != nil {
+				tt.state(&state)
+			}
+			h := tt.policy.Evaluate(&state, tt.peers)
+			assert.Equal(t, tt.want, h.Status)
+			if tt.want == HealthSynchronized {
+				assert.Empty(t, h.Reasons)
+			} else {
+				assert.NotEmpty(t, h.Reasons)
+			}
+		})
+	}
+}
+
+func TestHealthPolicyEvaluateLoop(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	l := discipline.NewLoop(discipline.NewDryRun())
+	require.NoError(t, l.SetFrequencyPPB(0))
+	_, err := l.Update(0, start)
+	require.NoError(t, err)
+	peers := []Peer{{Addr: "10.0.0.1:123", Reach: 0xff}}
+
+	// holdover is the loop's, from its last update
+	for _, tt := range []struct {
+		after time.Duration
+		want  Health
+	}{{time.Minute, HealthSynchronized}, {10 * time.Minute, HealthDegraded}, {2 * time.Hour, HealthUnsynchronized}} {
+		now := start.Add(tt.after)
+		h := HealthPolicy{}.Evaluate(NewSyncState(1, "GPS", 0, l.StatsAt(now)), peers)
+		assert.Equal(t, tt.want, h.Status, tt.after)
+		assert.Equal(t, l.Holdover(now), h.Holdover)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	h := HealthHandler(&testBackend{}, HealthPolicy{})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, PathReady, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"synchronized"`)
+
+	h = HealthHandler(readOnlyBackend{}, HealthPolicy{})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, PathReady, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, PathHealth, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"unsynchronized"`)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, PathReady, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestClientHealth(t *testing.T) {
+	s := httptest.NewServer(Handler(&testBackend{}))
+	defer s.Close()
+	h, err := NewClient(s.Client(), s.URL).Health()
+	require.Nil(t, err)
+	assert.Equal(t, HealthSynchronized, h.Status)
+	assert.Equal(t, 1, h.Reachable)
+}
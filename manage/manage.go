@@ -0,0 +1,345 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manage implements local management API over HTTP with JSON bodies, usually on Unix socket:
+// synchronization state, peers, MRU list and rate limiter status, along with runtime controls of sources.
+// It is a modern alternative to NTP control messages (mode 6)
+package manage
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/discipline"
+	"github.com/facebookincubator/ntp/logging"
+)
+
+// API paths
+const (
+	PathSync         = "/v1/sync"
+	PathPeers        = "/v1/peers"
+	PathMRU          = "/v1/mru"
+	PathRateLimit    = "/v1/ratelimit"
+	PathAddSource    = "/v1/sources/add"
+	PathRemoveSource = "/v1/sources/remove"
+	PathPoll         = "/v1/poll"
+	PathProbes       = "/v1/probes"
+	PathHealth       = "/v1/health"
+	PathReady        = "/v1/ready"
+	PathTopTalkers   = "/v1/mru/top"
+)
+
+// ErrNotSupported is returned by the client when the daemon doesn't implement what's asked
+var ErrNotSupported = errors.New("not supported by the daemon")
+
+// SyncState is synchronization state of the daemon
+type SyncState struct {
+	State   string `json:"state"`
+	Stratum int    `json:"stratum"`
+	RefID   string `json:"refid"`
+	Leap    int    `json:"leap"`
+	// Offset is the last offset the clock was corrected by
+	Offset       time.Duration `json:"offset_ns"`
+	Jitter       time.Duration `json:"jitter_ns"`
+	FrequencyPPB float64       `json:"frequency_ppb"`
+	WanderPPB    float64       `json:"wander_ppb"`
+	// WindowWanderPPB is wander over the loop wander window, 0 if it is not set
+	WindowWanderPPB float64 `json:"window_wander_ppb,omitempty"`
+	Poll            int     `json:"poll"`
+	// LastAdjustment is when the clock was last slewed or stepped
+	LastAdjustment time.Time `json:"last_adjustment,omitempty"`
+	// Holdover is how long the loop has been in holdover, 0 if it is not
+	Holdover time.Duration `json:"holdover_ns,omitempty"`
+}
+
+// NewSyncState returns synchronization state of the daemon steering the clock with the loop
+func NewSyncState(stratum int, refID string, leap int, l *discipline.Stats) *SyncState {
+	return &SyncState{
+		State:           l.State.String(),
+		Stratum:         stratum,
+		RefID:           refID,
+		Leap:            leap,
+		Offset:          l.Offset,
+		Jitter:          l.Jitter,
+		FrequencyPPB:    l.FrequencyPPB,
+		WanderPPB:       l.WanderPPB,
+		WindowWanderPPB: l.WindowWanderPPB,
+		Poll:            l.Poll,
+		LastAdjustment:  l.LastAdjustment,
+		Holdover:        l.Holdover,
+	}
+}
+
+// Peer is a source of time the daemon polls
+type Peer struct {
+	Addr       string        `json:"addr"`
+	Stratum    int           `json:"stratum"`
+	RefID      uint32        `json:"refid"`
+	Reach      uint8         `json:"reach"`
+	Offset     time.Duration `json:"offset_ns"`
+	Delay      time.Duration `json:"delay_ns"`
+	Dispersion time.Duration `json:"dispersion_ns"`
+	Jitter     time.Duration `json:"jitter_ns"`
+	// WindowJitter is jitter over the association jitter window, 0 if it is not set
+	WindowJitter time.Duration `json:"window_jitter_ns,omitempty"`
+	// OffsetP50 and OffsetP99 are quantiles of absolute offsets of all responses, DelayP50 and DelayP99 of their delays
+	OffsetP50 time.Duration `json:"offset_p50_ns"`
+	OffsetP99 time.Duration `json:"offset_p99_ns"`
+	DelayP50  time.Duration `json:"delay_p50_ns"`
+	DelayP99  time.Duration `json:"delay_p99_ns"`
+	LastPoll  time.Time     `json:"last_poll,omitempty"`
+	// LastError is what the last poll failed with, empty if it succeeded
+	LastError string `json:"last_error,omitempty"`
+}
+
+// NewPeer returns peer with statistics of client association
+func NewPeer(s *client.PeerStats) Peer {
+	p := Peer{
+		Addr:         s.Addr,
+		Stratum:      s.Stratum,
+		RefID:        s.RefID,
+		Reach:        s.Reach,
+		Offset:       s.Offset,
+		Delay:        s.Delay,
+		Dispersion:   s.Dispersion,
+		Jitter:       s.Jitter,
+		WindowJitter: s.WindowJitter,
+		OffsetP50:    s.OffsetP50,
+		OffsetP99:    s.OffsetP99,
+		DelayP50:     s.DelayP50,
+		DelayP99:     s.DelayP99,
+		LastPoll:     s.LastPoll,
+	}
+	if s.LastError != nil {
+		p.LastError = s.LastError.Error()
+	}
+	return p
+}
+
+// MRUEntry is a client in the list of most recently seen ones
+type MRUEntry struct {
+	Addr    string `json:"addr"`
+	Mode    int    `json:"mode"`
+	Version int    `json:"version"`
+	Count   uint64 `json:"count"`
+	// KoD counts kiss-o'-death and NAK responses the client got
+	KoD   uint64    `json:"kod"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// RateLimit is the status of rate limiter
+type RateLimit struct {
+	// Rate is how many requests per second are let through, 0 means no limit
+	Rate int `json:"rate"`
+	// Concurrency is how many requests may be processed at once, 0 means no limit
+	Concurrency int   `json:"concurrency"`
+	InFlight    int64 `json:"in_flight"`
+	Rejected    int64 `json:"rejected"`
+}
+
+// Probe is a source of requests the daemon never answers, like ntpdc monlist used in reflection attacks
+type Probe struct {
+	Addr  string `json:"addr"`
+	Count uint64 `json:"count"`
+	// Classes counts probes by what they asked for, e.g. monlist
+	Classes map[string]uint64 `json:"classes"`
+	First   time.Time         `json:"first"`
+	Last    time.Time         `json:"last"`
+}
+
+// Source is the body of requests adding, removing and polling sources
+type Source struct {
+	Addr string `json:"addr"`
+}
+
+// Backend is the daemon the API manages
+type Backend interface {
+	SyncState() *SyncState
+	Peers() []Peer
+}
+
+// MRULister is Backend keeping the list of most recently seen clients
+type MRULister interface {
+	MRU() []MRUEntry
+}
+
+// RateLimiter is Backend limiting request rate
+type RateLimiter interface {
+	RateLimit() *RateLimit
+}
+
+// ProbeReporter is Backend tracking sources of probes
+type ProbeReporter interface {
+	Probes() []Probe
+}
+
+// SourceManager is Backend letting its sources be changed at runtime
+type SourceManager interface {
+	AddSource(addr string) error
+	RemoveSource(addr string) error
+	// Poll polls the source right away instead of waiting for its poll interval
+	Poll(addr string) error
+}
+
+// errorBody is the body of failed responses
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// API serves management API of the backend
+type API struct {
+	Backend Backend
+	// Health decides what health endpoints report, default policy if not set
+	Health HealthPolicy
+	// Logger receives failures to reply, slog.Default() if nil
+	Logger logging.Logger
+}
+
+// Handler returns HTTP handler serving the API of the backend, health evaluated with default policy
+func Handler(b Backend) http.Handler {
+	return (&API{Backend: b}).Handler()
+}
+
+// Handler returns HTTP handler serving the API, health evaluated with Health policy
+func (a *API) Handler() http.Handler {
+	b := a.Backend
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathSync, a.get(func() (interface{}, error) { return b.SyncState(), nil }))
+	mux.HandleFunc(PathPeers, a.get(func() (interface{}, error) { return b.Peers(), nil }))
+	mux.HandleFunc(PathMRU, a.get(func() (interface{}, error) {
+		if m, ok := b.(MRULister); ok {
+			return m.MRU(), nil
+		}
+		return nil, ErrNotSupported
+	}))
+	mux.HandleFunc(PathTopTalkers, func(w http.ResponseWriter, r *http.Request) {
+		n := DefaultTopTalkers
+		if q := r.URL.Query().Get("n"); q != "" {
+			var err error
+			if n, err = strconv.Atoi(q); err != nil || n <= 0 {
+				a.reply(w, http.StatusBadRequest, errorBody{Error: "n must be positive integer"})
+				return
+			}
+		}
+		a.get(func() (interface{}, error) {
+			if m, ok := b.(MRULister); ok {
+				return NewTopTalkers(m.MRU(), n), nil
+			}
+			return nil, ErrNotSupported
+		})(w, r)
+	})
+	mux.HandleFunc(PathRateLimit, a.get(func() (interface{}, error) {
+		if r, ok := b.(RateLimiter); ok {
+			return r.RateLimit(), nil
+		}
+		return nil, ErrNotSupported
+	}))
+	mux.HandleFunc(PathProbes, a.get(func() (interface{}, error) {
+		if p, ok := b.(ProbeReporter); ok {
+			return p.Probes(), nil
+		}
+		return nil, ErrNotSupported
+	}))
+	sources, _ := b.(SourceManager)
+	control := func(f func(SourceManager, string) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				a.reply(w, http.StatusMethodNotAllowed, errorBody{Error: "only POST is allowed"})
+				return
+			}
+			if sources == nil {
+				a.reply(w, http.StatusNotImplemented, errorBody{Error: ErrNotSupported.Error()})
+				return
+			}
+			var s Source
+			if err := json.NewDecoder(r.Body).Decode(&s); err != nil || s.Addr == "" {
+				a.reply(w, http.StatusBadRequest, errorBody{Error: "body must be JSON object with addr"})
+				return
+			}
+			if err := f(sources, s.Addr); err != nil {
+				a.reply(w, http.StatusUnprocessableEntity, errorBody{Error: err.Error()})
+				return
+			}
+			a.reply(w, http.StatusOK, s)
+		}
+	}
+	mux.HandleFunc(PathAddSource, control(SourceManager.AddSource))
+	mux.HandleFunc(PathRemoveSource, control(SourceManager.RemoveSource))
+	mux.HandleFunc(PathPoll, control(SourceManager.Poll))
+	a.handleHealth(mux)
+	return mux
+}
+
+// get serves GET requests with JSON of what f returns
+func (a *API) get(f func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			a.reply(w, http.StatusMethodNotAllowed, errorBody{Error: "only GET is allowed"})
+			return
+		}
+		v, err := f()
+		if errors.Is(err, ErrNotSupported) {
+			a.reply(w, http.StatusNotImplemented, errorBody{Error: err.Error()})
+			return
+		}
+		a.reply(w, http.StatusOK, v)
+	}
+}
+
+func (a *API) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Or(a.Logger).Error("Failed to reply", "error", err)
+	}
+}
+
+// ListenUnix listens on Unix socket only the owner can connect to, replacing stale socket file if there is one
+func ListenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve serves the API of the backend on the Unix socket until it fails
+func Serve(path string, b Backend) error {
+	return (&API{Backend: b}).Serve(path)
+}
+
+// Serve serves the API on the Unix socket until it fails
+func (a *API) Serve(path string) error {
+	l, err := ListenUnix(path)
+	if err != nil {
+		return err
+	}
+	return http.Serve(l, a.Handler())
+}
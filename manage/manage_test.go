@@ -0,0 +1,173 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/discipline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBackend struct {
+	sources map[string]bool
+	polled  string
+}
+
+func (b *testBackend) SyncState() *SyncState {
+	return NewSyncState(2, "10.0.0.1", 0, &discipline.Stats{State: discipline.StateSYNC, Poll: 6, Offset: time.Microsecond, WanderPPB: 2, WindowWanderPPB: 3})
+}
+
+func (b *testBackend) Peers() []Peer {
+	return []Peer{NewPeer(&client.PeerStats{Addr: "10.0.0.1:123", Stratum: 1, Reach: 0xff, WindowJitter: time.Millisecond, OffsetP99: 2 * time.Millisecond, LastError: errors.New("timeout")})}
+}
+
+func (b *testBackend) RateLimit() *RateLimit {
+	return &RateLimit{Rate: 100, Rejected: 3}
+}
+
+func (b *testBackend) Probes() []Probe {
+	return []Probe{{Addr: "192.0.2.1", Count: 2, Classes: map[string]uint64{"monlist": 2}}}
+}
+
+func (b *testBackend) AddSource(addr string) error {
+	b.sources[addr] = true
+	return nil
+}
+
+func (b *testBackend) RemoveSource(addr string) error {
+	if !b.sources[addr] {
+		return errors.New("no such source")
+	}
+	delete(b.sources, addr)
+	return nil
+}
+
+func (b *testBackend) Poll(addr string) error {
+	b.polled = addr
+	return nil
+}
+
+func TestClientServer(t *testing.T) {
+	b := &testBackend{sources: map[string]bool{}}
+	s := httptest.NewServer(Handler(b))
+	defer s.Close()
+	c := NewClient(s.Client(), s.URL)
+
+	state, err := c.SyncState()
+	require.Nil(t, err)
+	assert.Equal(t, "SYNC", state.State)
+	assert.Equal(t, 2, state.Stratum)
+	assert.Equal(t, time.Microsecond, state.Offset)
+	assert.Equal(t, 2.0, state.WanderPPB)
+	assert.Equal(t, 3.0, state.WindowWanderPPB)
+
+	peers, err := c.Peers()
+	require.Nil(t, err)
+	require.Len(t, peers, 1)
+	assert.Equal(t, uint8(0xff), peers[0].Reach)
+	assert.Equal(t, "timeout", peers[0].LastError)
+	assert.Equal(t, time.Millisecond, peers[0].WindowJitter)
+	assert.Equal(t, 2*time.Millisecond, peers[0].OffsetP99)
+
+	limit, err := c.RateLimit()
+	require.Nil(t, err)
+	assert.Equal(t, &RateLimit{Rate: 100, Rejected: 3}, limit)
+
+	probes, err := c.Probes()
+	require.Nil(t, err)
+	require.Len(t, probes, 1)
+	assert.Equal(t, uint64(2), probes[0].Classes["monlist"])
+
+	_, err = c.MRU()
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	require.Nil(t, c.AddSource("10.0.0.2:123"))
+	assert.True(t, b.sources["10.0.0.2:123"])
+	require.Nil(t, c.Poll("10.0.0.2:123"))
+	assert.Equal(t, "10.0.0.2:123", b.polled)
+	require.Nil(t, c.RemoveSource("10.0.0.2:123"))
+	err = c.RemoveSource("10.0.0.2:123")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no such source")
+}
+
+type readOnlyBackend struct{}
+
+func (readOnlyBackend) SyncState() *SyncState { return &SyncState{} }
+func (readOnlyBackend) Peers() []Peer         { return nil }
+
+func TestHandlerNotSupported(t *testing.T) {
+	h := Handler(readOnlyBackend{})
+	for _, path := range []string{PathMRU, PathRateLimit, PathProbes} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotImplemented, w.Code, path)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, PathAddSource, nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, PathSync, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+// failingWriter is ResponseWriter of a client which went away
+type failingWriter struct {
+	httptest.ResponseRecorder
+}
+
+func (w *failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestAPILogger(t *testing.T) {
+	var buf bytes.Buffer
+	api := &API{Backend: &testBackend{}, Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	w := &failingWriter{ResponseRecorder: *httptest.NewRecorder()}
+	api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, PathSync, nil))
+	assert.Contains(t, buf.String(), `level=ERROR msg="Failed to reply" error="broken pipe"`)
+}
+
+func TestServeUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manage")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "manage.sock")
+	l, err := ListenUnix(path)
+	require.Nil(t, err)
+	defer l.Close()
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	go func() { _ = http.Serve(l, Handler(readOnlyBackend{})) }()
+
+	peers, err := DialUnix(path).Peers()
+	require.Nil(t, err)
+	assert.Empty(t, peers)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"sort"
+)
+
+// DefaultTopTalkers is how many clients top talkers report lists unless asked for another number
+const DefaultTopTalkers = 10
+
+// TopTalker is a client among the most recently seen ones along with its request rate
+type TopTalker struct {
+	MRUEntry
+	// Rate is requests per second between the first and the last one seen, 0 if there was only one
+	Rate float64 `json:"rate"`
+}
+
+// TopTalkers are the most active clients among the most recently seen ones, for abuse investigations
+type TopTalkers struct {
+	// ByRate are the clients sending requests at the highest rate, the fastest first
+	ByRate []TopTalker `json:"by_rate"`
+	// ByKoD are the clients which got the most kiss-o'-death responses, clients which got none are left out
+	ByKoD []TopTalker `json:"by_kod"`
+}
+
+// Rate returns requests per second between the first and the last one seen, 0 if there was only one
+func (e *MRUEntry) Rate() float64 {
+	span := e.Last.Sub(e.First).Seconds()
+	if e.Count < 2 || span <= 0 {
+		return 0
+	}
+	return float64(e.Count-1) / span
+}
+
+// NewTopTalkers returns n top talkers among the entries by request rate and by KoD count
+func NewTopTalkers(entries []MRUEntry, n int) *TopTalkers {
+	talkers := make([]TopTalker, 0, len(entries))
+	for i := range entries {
+		talkers = append(talkers, TopTalker{MRUEntry: entries[i], Rate: entries[i].Rate()})
+	}
+	top := &TopTalkers{ByRate: []TopTalker{}, ByKoD: []TopTalker{}}
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].Rate != talkers[j].Rate {
+			return talkers[i].Rate > talkers[j].Rate
+		}
+		return talkers[i].Addr < talkers[j].Addr
+	})
+	for _, t := range talkers {
+		if len(top.ByRate) == n {
+			break
+		}
+		top.ByRate = append(top.ByRate, t)
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].KoD != talkers[j].KoD {
+			return talkers[i].KoD > talkers[j].KoD
+		}
+		return talkers[i].Addr < talkers[j].Addr
+	})
+	for _, t := range talkers {
+		if len(top.ByKoD) == n || t.KoD == 0 {
+			break
+		}
+		top.ByKoD = append(top.ByKoD, t)
+	}
+	return top
+}
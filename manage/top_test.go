@@ -0,0 +1,89 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Unix(1600000000, 0)
+
+func TestMRUEntryRate(t *testing.T) {
+	e := MRUEntry{Count: 11, First: start, Last: start.Add(5 * time.Second)}
+	assert.Equal(t, 2.0, e.Rate())
+	e = MRUEntry{Count: 1, First: start, Last: start}
+	assert.Equal(t, 0.0, e.Rate())
+}
+
+func TestNewTopTalkers(t *testing.T) {
+	entries := []MRUEntry{
+		{Addr: "192.0.2.1", Count: 11, First: start, Last: start.Add(10 * time.Second)},
+		{Addr: "192.0.2.2", Count: 101, KoD: 5, First: start, Last: start.Add(10 * time.Second)},
+		{Addr: "192.0.2.3", Count: 2, KoD: 1, First: start, Last: start.Add(10 * time.Second)},
+	}
+	top := NewTopTalkers(entries, 2)
+	require.Len(t, top.ByRate, 2)
+	assert.Equal(t, "192.0.2.2", top.ByRate[0].Addr)
+	assert.Equal(t, 10.0, top.ByRate[0].Rate)
+	assert.Equal(t, "192.0.2.1", top.ByRate[1].Addr)
+	require.Len(t, top.ByKoD, 2)
+	assert.Equal(t, "192.0.2.2", top.ByKoD[0].Addr)
+	assert.Equal(t, "192.0.2.3", top.ByKoD[1].Addr)
+
+	top = NewTopTalkers(entries[:1], 10)
+	assert.Len(t, top.ByRate, 1)
+	assert.Empty(t, top.ByKoD)
+}
+
+type mruBackend struct {
+	readOnlyBackend
+}
+
+func (mruBackend) MRU() []MRUEntry {
+	return []MRUEntry{
+		{Addr: "192.0.2.1", Count: 3, KoD: 2, First: start, Last: start.Add(time.Second)},
+		{Addr: "192.0.2.2", Count: 5, First: start, Last: start.Add(time.Second)},
+	}
+}
+
+func TestClientTopTalkers(t *testing.T) {
+	s := httptest.NewServer(Handler(mruBackend{}))
+	defer s.Close()
+	c := NewClient(s.Client(), s.URL)
+
+	top, err := c.TopTalkers(1)
+	require.Nil(t, err)
+	require.Len(t, top.ByRate, 1)
+	assert.Equal(t, "192.0.2.2", top.ByRate[0].Addr)
+	assert.Equal(t, 4.0, top.ByRate[0].Rate)
+	require.Len(t, top.ByKoD, 1)
+	assert.Equal(t, uint64(2), top.ByKoD[0].KoD)
+
+	_, err = NewClient(s.Client(), s.URL).TopTalkers(-1)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "n must be positive")
+
+	w := httptest.NewRecorder()
+	Handler(readOnlyBackend{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, PathTopTalkers, nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
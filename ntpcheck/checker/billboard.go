@@ -0,0 +1,178 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checker
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// tallyCodes are ntpq -p tally codes indexed by peer selection
+var tallyCodes = [8]string{" ", "x", ".", "-", "+", "#", "*", "o"}
+
+// BillboardHeader is the header of ntpq -p billboard
+const BillboardHeader = "     remote           refid      st t when poll reach   delay   offset  jitter\n" +
+	"==============================================================================\n"
+
+// BillboardRow is a line of ntpq -p billboard. Delay, offset and jitter are in ms
+type BillboardRow struct {
+	Tally   string        `json:"tally"`
+	Remote  string        `json:"remote"`
+	RefID   string        `json:"refid"`
+	Stratum int           `json:"st"`
+	Type    string        `json:"t"`
+	When    time.Duration `json:"when"`
+	Poll    time.Duration `json:"poll"`
+	Reach   uint8         `json:"reach"`
+	Delay   float64       `json:"delay"`
+	Offset  float64       `json:"offset"`
+	Jitter  float64       `json:"jitter"`
+}
+
+// NewBillboardRow returns billboard line of the peer as of now. When is 0 if the peer never replied or its last reply time is unknown
+func NewBillboardRow(p *Peer, now time.Time) BillboardRow {
+	row := BillboardRow{
+		Tally:   " ",
+		Remote:  p.SRCAdr,
+		RefID:   p.RefID,
+		Stratum: p.Stratum,
+		Type:    peerType(p),
+		Reach:   p.Reach,
+		Delay:   p.Delay,
+		Offset:  p.Offset,
+		Jitter:  p.Jitter,
+	}
+	if int(p.Selection) < len(tallyCodes) {
+		row.Tally = tallyCodes[p.Selection]
+	}
+	// kiss codes and reference clock names are shown the way ntpq does, between dots
+	if row.RefID != "" && len(row.RefID) <= 4 && net.ParseIP(row.RefID) == nil {
+		row.RefID = "." + row.RefID + "."
+	}
+	poll := p.PPoll
+	if p.HPoll != 0 && (poll == 0 || p.HPoll < poll) {
+		poll = p.HPoll
+	}
+	row.Poll = time.Duration(1<<uint(poll)) * time.Second
+	if rec, err := parseNTPTimestamp(p.Rec); err == nil && rec.Before(now) {
+		row.When = now.Sub(rec)
+	}
+	return row
+}
+
+// peerType returns ntpq t column: l for reference clocks, b for broadcast, s for symmetric and u for unicast client
+func peerType(p *Peer) string {
+	if strings.HasPrefix(p.SRCAdr, "127.127.") {
+		return "l"
+	}
+	switch p.HMode {
+	case 1, 2:
+		return "s"
+	case 5, 6:
+		return "b"
+	}
+	return "u"
+}
+
+// parseNTPTimestamp parses NTP timestamp in 0xseconds.fraction form control protocol uses
+func parseNTPTimestamp(s string) (time.Time, error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, "0x"), ".", 2)
+	if len(parts) != 2 {
+		return time.Time{}, fmt.Errorf("malformed NTP timestamp %q", s)
+	}
+	sec, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed NTP timestamp %q: %w", s, err)
+	}
+	frac, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed NTP timestamp %q: %w", s, err)
+	}
+	if sec == 0 {
+		return time.Time{}, fmt.Errorf("zero NTP timestamp %q", s)
+	}
+	return ntp.Unix(uint32(sec), uint32(frac)), nil
+}
+
+// Billboard returns billboard lines of all the peers as of now, ordered by association ID
+func Billboard(r *NTPCheckResult, now time.Time) []BillboardRow {
+	ids := make([]int, 0, len(r.Peers))
+	for id := range r.Peers {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	rows := make([]BillboardRow, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, NewBillboardRow(r.Peers[uint16(id)], now))
+	}
+	return rows
+}
+
+// String formats the line the way ntpq -p does, without trailing newline
+func (r BillboardRow) String() string {
+	remote := r.Remote
+	if len(remote) > 15 {
+		remote = remote[:15]
+	}
+	refid := r.RefID
+	if len(refid) > 15 {
+		refid = refid[:15]
+	}
+	return fmt.Sprintf("%s%-15s %-15s %2d %s %4s %4s  %3o  %7.3f %8.3f %7.3f",
+		r.Tally, remote, refid, r.Stratum, r.Type, prettyInterval(r.When), prettyInterval(r.Poll), r.Reach, r.Delay, r.Offset, r.Jitter)
+}
+
+// prettyInterval formats interval the way ntpq does: seconds, then minutes, hours and days as it grows
+func prettyInterval(d time.Duration) string {
+	diff := int64(d / time.Second)
+	if diff <= 0 {
+		return "-"
+	}
+	if diff <= 2048 {
+		return strconv.FormatInt(diff, 10)
+	}
+	diff = (diff + 29) / 60
+	if diff <= 300 {
+		return fmt.Sprintf("%dm", diff)
+	}
+	diff = (diff + 29) / 60
+	if diff <= 96 {
+		return fmt.Sprintf("%dh", diff)
+	}
+	diff = (diff + 11) / 24
+	return fmt.Sprintf("%dd", diff)
+}
+
+// WriteBillboard writes header and lines of ntpq -p billboard to w
+func WriteBillboard(w io.Writer, rows []BillboardRow) error {
+	if _, err := io.WriteString(w, BillboardHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := fmt.Fprintln(w, row.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checker
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+func TestNewBillboardRow(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	sec, frac := ntp.Time(now.Add(-35 * time.Second))
+	p := &Peer{
+		Selection: SelSYSPeer,
+		SRCAdr:    "2401:db00:3020:70e5:face:0:8b:0",
+		RefID:     "GPS",
+		Stratum:   1,
+		HMode:     3,
+		PPoll:     6,
+		HPoll:     7,
+		Reach:     255,
+		Delay:     0.123,
+		Offset:    -0.045,
+		Jitter:    0.012,
+		Rec:       "0x" + formatHex(sec) + "." + formatHex(frac),
+	}
+	row := NewBillboardRow(p, now)
+	assert.Equal(t, "*", row.Tally)
+	assert.Equal(t, ".GPS.", row.RefID)
+	assert.Equal(t, "u", row.Type)
+	assert.Equal(t, 64*time.Second, row.Poll)
+	assert.InDelta(t, float64(35*time.Second), float64(row.When), float64(time.Millisecond))
+	assert.Equal(t, "*2401:db00:3020: .GPS.            1 u   35   64  377    0.123   -0.045   0.012", row.String())
+}
+
+func TestNewBillboardRowUnknown(t *testing.T) {
+	p := &Peer{Selection: SelFalseTick, SRCAdr: "127.127.22.0", RefID: "10.0.0.1", Stratum: 16, PPoll: 4, HPoll: 4}
+	row := NewBillboardRow(p, time.Now())
+	assert.Equal(t, "x", row.Tally)
+	assert.Equal(t, "10.0.0.1", row.RefID)
+	assert.Equal(t, "l", row.Type)
+	assert.Equal(t, time.Duration(0), row.When)
+	assert.Equal(t, "x127.127.22.0    10.0.0.1        16 l    -   16    0    0.000    0.000   0.000", row.String())
+}
+
+func TestPrettyInterval(t *testing.T) {
+	assert.Equal(t, "-", prettyInterval(0))
+	assert.Equal(t, "2048", prettyInterval(2048*time.Second))
+	assert.Equal(t, "35m", prettyInterval(35*time.Minute))
+	assert.Equal(t, "12h", prettyInterval(12*time.Hour))
+	assert.Equal(t, "5d", prettyInterval(5*24*time.Hour))
+}
+
+func TestBillboard(t *testing.T) {
+	r := NewNTPCheckResult()
+	r.Peers[2] = &Peer{Selection: SelCandidate, SRCAdr: "10.0.0.2", Stratum: 2, PPoll: 6, HPoll: 6}
+	r.Peers[1] = &Peer{Selection: SelSYSPeer, SRCAdr: "10.0.0.1", Stratum: 2, PPoll: 6, HPoll: 6}
+	rows := Billboard(r, time.Now())
+	require.Len(t, rows, 2)
+	assert.Equal(t, "10.0.0.1", rows[0].Remote)
+	assert.Equal(t, "+", rows[1].Tally)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteBillboard(&buf, rows))
+	assert.Equal(t, BillboardHeader+rows[0].String()+"\n"+rows[1].String()+"\n", buf.String())
+}
+
+func formatHex(v uint32) string {
+	return strconv.FormatUint(uint64(v), 16)
+}
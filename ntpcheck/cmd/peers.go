@@ -0,0 +1,67 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/facebookincubator/ntp/ntpcheck/checker"
+)
+
+var peersJSON = false
+
+func printPeers(r *checker.NTPCheckResult, asJSON bool) error {
+	rows := checker.Billboard(r, time.Now())
+	if !asJSON {
+		return checker.WriteBillboard(os.Stdout, rows)
+	}
+	toPrint, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(toPrint))
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(peersCmd)
+	peersCmd.Flags().StringVarP(&server, "server", "S", "", "server to connect to")
+	peersCmd.Flags().BoolVarP(&peersJSON, "json", "j", false, "print peers as JSON records")
+}
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "Print NTP peers billboard the way ntpq -p does",
+	Run: func(cmd *cobra.Command, args []string) {
+		ConfigureVerbosity()
+
+		result, err := checker.RunCheck(server)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = printPeers(result, peersJSON)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
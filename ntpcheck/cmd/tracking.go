@@ -0,0 +1,102 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/facebookincubator/ntp/manage"
+)
+
+var (
+	manageSocket string
+	csvOutput    bool
+)
+
+func printJSON(v interface{}) error {
+	toPrint, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(toPrint))
+	return nil
+}
+
+func printTracking(c *manage.Client, asCSV bool) error {
+	state, err := c.SyncState()
+	if err != nil {
+		return err
+	}
+	if asCSV {
+		return manage.WriteTrackingCSV(os.Stdout, state)
+	}
+	return printJSON(state)
+}
+
+func printSources(c *manage.Client, asCSV bool) error {
+	peers, err := c.Peers()
+	if err != nil {
+		return err
+	}
+	if !asCSV {
+		return printJSON(peers)
+	}
+	state, err := c.SyncState()
+	if err != nil {
+		return err
+	}
+	return manage.WriteSourcesCSV(os.Stdout, state, peers, time.Now())
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{trackingCmd, sourcesCmd} {
+		RootCmd.AddCommand(cmd)
+		cmd.Flags().StringVarP(&manageSocket, "managesocket", "m", "", "management API socket of the daemon")
+		cmd.Flags().BoolVarP(&csvOutput, "csv", "c", false, "print CSV the way chronyc -c does")
+		_ = cmd.MarkFlagRequired("managesocket")
+	}
+}
+
+var trackingCmd = &cobra.Command{
+	Use:   "tracking",
+	Short: "Print synchronization state taken from management API, as JSON or chronyc CSV",
+	Run: func(cmd *cobra.Command, args []string) {
+		ConfigureVerbosity()
+
+		if err := printTracking(manage.DialUnix(manageSocket), csvOutput); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var sourcesCmd = &cobra.Command{
+	Use:   "sources",
+	Short: "Print sources taken from management API, as JSON or chronyc CSV",
+	Run: func(cmd *cobra.Command, args []string) {
+		ConfigureVerbosity()
+
+		if err := printSources(manage.DialUnix(manageSocket), csvOutput); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
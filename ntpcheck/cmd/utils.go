@@ -18,7 +18,7 @@ package cmd
 
 import (
 	"crypto/md5"
-	"encoding/binary"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -26,8 +26,12 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/internal/fips"
 	"github.com/facebookincubator/ntp/leaphash"
+	"github.com/facebookincubator/ntp/protocol/auth"
 	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/protocol/nts"
 	"github.com/spf13/cobra"
 )
 
@@ -101,19 +105,69 @@ func signFile(fileName string) {
 	fmt.Printf("#h %s\n", leaphash.Compute(string(data)))
 }
 
+// ntsTLSConfig builds TLS configuration of NTS-KE client, nil means defaults
+func ntsTLSConfig(caFile string, pins []string, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && len(pins) == 0 && certFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if caFile != "" {
+		pool, err := nts.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if len(pins) > 0 {
+		var hashes [][]byte
+		for _, p := range pins {
+			pin, err := nts.ParsePin(p)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, pin)
+		}
+		// pinned key is what identifies the server, self-signed certificates are fine
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = nts.PinSPKI(hashes...)
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
 // ntpDate prints data similar to 'ntptime' command output
-func ntpDate(remoteServerAddr string, remoteServerPort string, requests int) error {
+func ntpDate(remoteServerAddr string, remoteServerPort string, requests int, useNTS bool, ntsTLS *tls.Config, ntsAEADs []uint16, ntsStore string, keyFile string, keyID uint32, uniqueID bool) error {
 	timeout := 5 * time.Second
 	addr := net.JoinHostPort(remoteServerAddr, remoteServerPort)
-	conn, err := net.DialTimeout("udp", addr, timeout)
-	if err != nil {
-		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	// requests go one after another, so they share the socket
+	association := &client.Association{Addr: addr, Timeout: timeout, UniqueID: uniqueID, Connected: true}
+	defer association.Close()
+	if useNTS {
+		association.NTS = &nts.Client{
+			KEServer:  net.JoinHostPort(remoteServerAddr, strconv.Itoa(ntsKEPort)),
+			TLSConfig: ntsTLS,
+			Timeout:   timeout,
+			AEADs:     ntsAEADs,
+		}
+		if ntsStore != "" {
+			association.NTS.Store = &nts.FileStore{Dir: ntsStore}
+		}
 	}
-	defer conn.Close()
-
-	// Allow reading of hardware/kernel timestamps via socket
-	if err := ntp.EnableKernelTimestampsSocket(conn.(*net.UDPConn)); err != nil {
-		return err
+	if keyFile != "" {
+		keys, err := auth.ReadKeysFile(keyFile)
+		if err != nil {
+			return err
+		}
+		keys.TrustAll()
+		if association.Key, err = keys.Trusted(keyID); err != nil {
+			return err
+		}
 	}
 
 	fmt.Printf("Server: %s, Requests: %d\n", addr, requests)
@@ -121,43 +175,13 @@ func ntpDate(remoteServerAddr string, remoteServerPort string, requests int) err
 	var sumOffset int64
 
 	for i := 0; i < requests; i++ {
-		clientTransmitTime := time.Now()
-		sec, frac := ntp.Time(clientTransmitTime)
-
-		request := &ntp.Packet{
-			Settings:   0x1B,
-			TxTimeSec:  sec,
-			TxTimeFrac: frac,
-		}
-
-		if err := binary.Write(conn, binary.BigEndian, request); err != nil {
-			return fmt.Errorf("failed to send request, %w", err)
-		}
-
-		var response *ntp.Packet
-		var clientReceiveTime time.Time
-
-		blockingRead := make(chan bool, 1)
-		go func() {
-			// This calls syscall.Recvmsg which has no timeout
-			response, clientReceiveTime, _, err = ntp.ReadPacketWithKernelTimestamp(conn.(*net.UDPConn))
-			blockingRead <- true
-		}()
-
-		select {
-		case <-blockingRead:
-			if err != nil {
-				return err
-			}
-		case <-time.After(timeout):
-			return fmt.Errorf("timeout waiting for reply from server for %v", timeout)
+		response, err := association.Query()
+		if err != nil {
+			return err
 		}
 
-		serverReceiveTime := ntp.Unix(response.RxTimeSec, response.RxTimeFrac)
-		serverTransmitTime := ntp.Unix(response.TxTimeSec, response.TxTimeFrac)
-
-		avgNetworkDelay := ntp.AvgNetworkDelay(clientTransmitTime, serverReceiveTime, serverTransmitTime, clientReceiveTime)
-		currentRealTime := ntp.CurrentRealTime(serverTransmitTime, avgNetworkDelay)
+		avgNetworkDelay := response.AvgNetworkDelay()
+		currentRealTime := ntp.CurrentRealTime(response.ServerTransmitTime, avgNetworkDelay)
 		offset := ntp.CalculateOffset(currentRealTime, time.Now())
 
 		sumAvgNetworkDelay += avgNetworkDelay
@@ -166,7 +190,7 @@ func ntpDate(remoteServerAddr string, remoteServerPort string, requests int) err
 		// On last request calculate everything
 		if i == requests-1 {
 			fmt.Printf("Last:\n")
-			fmt.Printf("Stratum: %d, Current time: %s\n", response.Stratum, currentRealTime)
+			fmt.Printf("Stratum: %d, Current time: %s\n", response.Packet.Stratum, currentRealTime)
 			fmt.Printf("Offset: %fs (%fms), Network delay: %fs (%fms)\n", float64(offset)/float64(time.Second.Nanoseconds()), float64(offset)/float64(time.Millisecond.Nanoseconds()), float64(avgNetworkDelay)/float64(time.Second.Nanoseconds()), float64(avgNetworkDelay)/float64(time.Millisecond.Nanoseconds()))
 		}
 	}
@@ -186,6 +210,18 @@ var signFileName string
 var remoteServerAddr string
 var remoteServerPort int
 var ntpdateRequests int
+var ntpdateNTS bool
+var ntsKEPort int
+var ntpdateNTSStore string
+var ntpdateNTSCA string
+var ntpdateNTSPins []string
+var ntpdateNTSCert string
+var ntpdateNTSKey string
+var ntpdateNTSAEADs string
+var ntpdateKeyFile string
+var ntpdateKeyID uint32
+var ntpdateUniqueID bool
+var ntpdateFIPS bool
 
 func init() {
 	RootCmd.AddCommand(utilsCmd)
@@ -203,6 +239,18 @@ func init() {
 	ntpdateCmd.Flags().StringVarP(&remoteServerAddr, "server", "s", "", "Server to query")
 	ntpdateCmd.Flags().IntVarP(&remoteServerPort, "port", "p", 123, "Port of the remote server")
 	ntpdateCmd.Flags().IntVarP(&ntpdateRequests, "requests", "r", 3, "How many requests to send")
+	ntpdateCmd.Flags().BoolVar(&ntpdateNTS, "nts", false, "Authenticate with Network Time Security")
+	ntpdateCmd.Flags().IntVar(&ntsKEPort, "ntskeport", nts.DefaultKEPort, "Port of the NTS-KE server")
+	ntpdateCmd.Flags().StringVar(&ntpdateNTSStore, "ntsstore", "", "Directory to keep NTS cookies in between runs")
+	ntpdateCmd.Flags().StringVar(&ntpdateNTSCA, "ntsca", "", "PEM file with CA certificates to verify NTS-KE server with instead of system ones")
+	ntpdateCmd.Flags().StringSliceVar(&ntpdateNTSPins, "ntspin", nil, "Hex SHA-256 of NTS-KE server public key (SPKI). Server has to match one of the pins, CA verification is skipped")
+	ntpdateCmd.Flags().StringVar(&ntpdateNTSCert, "ntscert", "", "Client certificate for NTS-KE servers requiring one")
+	ntpdateCmd.Flags().StringVar(&ntpdateNTSKey, "ntskey", "", "Private key of the client certificate")
+	ntpdateCmd.Flags().StringVar(&ntpdateNTSAEADs, "ntsaeads", "", "Comma-separated AEAD algorithms to offer in order of preference, e.g. AES-128-GCM-SIV,AES-SIV-CMAC-256")
+	ntpdateCmd.Flags().StringVar(&ntpdateKeyFile, "keyfile", "", "ntpd-style key file for symmetric key authentication")
+	ntpdateCmd.Flags().Uint32Var(&ntpdateKeyID, "keyid", 1, "ID of the key from the key file to authenticate with")
+	ntpdateCmd.Flags().BoolVar(&ntpdateUniqueID, "uid", false, "Match responses by Unique Identifier extension field")
+	ntpdateCmd.Flags().BoolVar(&ntpdateFIPS, "fips", fips.Enabled(), "Only allow FIPS approved crypto: AES128CMAC keys and AES-SIV-CMAC NTS AEADs")
 }
 
 var utilsCmd = &cobra.Command{
@@ -254,7 +302,20 @@ var ntpdateCmd = &cobra.Command{
 			fmt.Println("server must be specified")
 			os.Exit(1)
 		}
-		if err := ntpDate(remoteServerAddr, strconv.Itoa(remoteServerPort), ntpdateRequests); err != nil {
+		if ntpdateFIPS {
+			fips.Set(true)
+		}
+		ntsTLS, err := ntsTLSConfig(ntpdateNTSCA, ntpdateNTSPins, ntpdateNTSCert, ntpdateNTSKey)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ntsAEADs, err := nts.ParseAEADs(ntpdateNTSAEADs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := ntpDate(remoteServerAddr, strconv.Itoa(remoteServerPort), ntpdateRequests, ntpdateNTS, ntsTLS, ntsAEADs, ntpdateNTSStore, ntpdateKeyFile, ntpdateKeyID, ntpdateUniqueID); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
@@ -0,0 +1,289 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package protobuf encodes NTP packets and query results compactly, for shipping measurements into data pipelines.
+// Encoding is protocol buffers wire format of messages defined in ntp.proto, so consumers decode them with code
+// generated from the schema. Encoding is implemented here directly to keep the library free of protobuf runtime
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// Wire types of protocol buffers
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Field numbers of Packet, see ntp.proto
+const (
+	fieldSettings           = 1
+	fieldStratum            = 2
+	fieldPoll               = 3
+	fieldPrecision          = 4
+	fieldRootDelay          = 5
+	fieldRootDispersion     = 6
+	fieldReferenceID        = 7
+	fieldReferenceTimestamp = 8
+	fieldOriginTimestamp    = 9
+	fieldReceiveTimestamp   = 10
+	fieldTransmitTimestamp  = 11
+)
+
+// Field numbers of Response, see ntp.proto
+const (
+	fieldPacket             = 1
+	fieldClientTransmitTime = 2
+	fieldServerReceiveTime  = 3
+	fieldServerTransmitTime = 4
+	fieldClientReceiveTime  = 5
+	fieldOffset             = 6
+	fieldDelay              = 7
+	fieldServer             = 8
+)
+
+// ErrTruncated is returned for messages ending mid-field
+var ErrTruncated = errors.New("truncated protobuf message")
+
+// encoder appends fields, skipping zero values as proto3 does
+type encoder []byte
+
+func (e *encoder) tag(field, wire int) {
+	*e = binary.AppendUvarint(*e, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) uvarint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	*e = binary.AppendUvarint(*e, v)
+}
+
+// svarint encodes signed value zigzag, as sint32 and sint64 are
+func (e *encoder) svarint(field int, v int64) {
+	e.uvarint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+func (e *encoder) fixed32(field int, v uint32) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed32)
+	*e = binary.LittleEndian.AppendUint32(*e, v)
+}
+
+func (e *encoder) fixed64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed64)
+	*e = binary.LittleEndian.AppendUint64(*e, v)
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	*e = binary.AppendUvarint(*e, uint64(len(b)))
+	*e = append(*e, b...)
+}
+
+// field is a decoded field: number, wire type and value, which is in v for numeric types and in b for bytes
+type field struct {
+	number int
+	wire   int
+	v      uint64
+	b      []byte
+}
+
+// decode calls fn for every field of the message
+func decode(b []byte, fn func(f *field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrTruncated
+		}
+		b = b[n:]
+		f := &field{number: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.v, n = binary.Uvarint(b); n <= 0 {
+				return ErrTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return ErrTruncated
+			}
+			f.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return ErrTruncated
+			}
+			f.v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return ErrTruncated
+			}
+			f.b, b = b[n:n+int(length)], b[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", f.wire, f.number)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signed decodes zigzag encoded value
+func signed(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// timestamp joins NTP timestamp halves
+func timestamp(sec, frac uint32) uint64 {
+	return uint64(sec)<<32 | uint64(frac)
+}
+
+// MarshalPacket encodes the packet as Packet message
+func MarshalPacket(p *ntp.Packet) []byte {
+	var e encoder
+	e.uvarint(fieldSettings, uint64(p.Settings))
+	e.uvarint(fieldStratum, uint64(p.Stratum))
+	e.svarint(fieldPoll, int64(p.Poll))
+	e.svarint(fieldPrecision, int64(p.Precision))
+	e.uvarint(fieldRootDelay, uint64(p.RootDelay))
+	e.uvarint(fieldRootDispersion, uint64(p.RootDispersion))
+	e.fixed32(fieldReferenceID, p.ReferenceID)
+	e.fixed64(fieldReferenceTimestamp, timestamp(p.RefTimeSec, p.RefTimeFrac))
+	e.fixed64(fieldOriginTimestamp, timestamp(p.OrigTimeSec, p.OrigTimeFrac))
+	e.fixed64(fieldReceiveTimestamp, timestamp(p.RxTimeSec, p.RxTimeFrac))
+	e.fixed64(fieldTransmitTimestamp, timestamp(p.TxTimeSec, p.TxTimeFrac))
+	return e
+}
+
+// UnmarshalPacket decodes Packet message. Unknown fields are skipped
+func UnmarshalPacket(b []byte) (*ntp.Packet, error) {
+	p := &ntp.Packet{}
+	err := decode(b, func(f *field) error {
+		switch f.number {
+		case fieldSettings:
+			p.Settings = uint8(f.v)
+		case fieldStratum:
+			p.Stratum = uint8(f.v)
+		case fieldPoll:
+			p.Poll = int8(signed(f.v))
+		case fieldPrecision:
+			p.Precision = int8(signed(f.v))
+		case fieldRootDelay:
+			p.RootDelay = uint32(f.v)
+		case fieldRootDispersion:
+			p.RootDispersion = uint32(f.v)
+		case fieldReferenceID:
+			p.ReferenceID = uint32(f.v)
+		case fieldReferenceTimestamp:
+			p.RefTimeSec, p.RefTimeFrac = uint32(f.v>>32), uint32(f.v)
+		case fieldOriginTimestamp:
+			p.OrigTimeSec, p.OrigTimeFrac = uint32(f.v>>32), uint32(f.v)
+		case fieldReceiveTimestamp:
+			p.RxTimeSec, p.RxTimeFrac = uint32(f.v>>32), uint32(f.v)
+		case fieldTransmitTimestamp:
+			p.TxTimeSec, p.TxTimeFrac = uint32(f.v>>32), uint32(f.v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// unixNano returns Unix time in nanoseconds, 0 for zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the reverse of unixNano
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// MarshalResponse encodes query result of the server as Response message
+func MarshalResponse(server string, r *client.Response) []byte {
+	var e encoder
+	if r.Packet != nil {
+		e.bytes(fieldPacket, MarshalPacket(r.Packet))
+	}
+	// int64 fields are plain varints, negative values take 10 bytes
+	e.uvarint(fieldClientTransmitTime, uint64(unixNano(r.ClientTransmitTime)))
+	e.uvarint(fieldServerReceiveTime, uint64(unixNano(r.ServerReceiveTime)))
+	e.uvarint(fieldServerTransmitTime, uint64(unixNano(r.ServerTransmitTime)))
+	e.uvarint(fieldClientReceiveTime, uint64(unixNano(r.ClientReceiveTime)))
+	e.svarint(fieldOffset, int64(r.Offset()))
+	e.svarint(fieldDelay, int64(r.ClientReceiveTime.Sub(r.ClientTransmitTime)-r.ServerTransmitTime.Sub(r.ServerReceiveTime)))
+	e.bytes(fieldServer, []byte(server))
+	return e
+}
+
+// UnmarshalResponse decodes Response message into the server address and query result.
+// Derived offset and delay are not decoded, Response computes them from timestamps
+func UnmarshalResponse(b []byte) (server string, r *client.Response, err error) {
+	r = &client.Response{}
+	err = decode(b, func(f *field) error {
+		switch f.number {
+		case fieldPacket:
+			p, err := UnmarshalPacket(f.b)
+			if err != nil {
+				return fmt.Errorf("packet: %w", err)
+			}
+			r.Packet = p
+		case fieldClientTransmitTime:
+			r.ClientTransmitTime = fromUnixNano(int64(f.v))
+		case fieldServerReceiveTime:
+			r.ServerReceiveTime = fromUnixNano(int64(f.v))
+		case fieldServerTransmitTime:
+			r.ServerTransmitTime = fromUnixNano(int64(f.v))
+		case fieldClientReceiveTime:
+			r.ClientReceiveTime = fromUnixNano(int64(f.v))
+		case fieldServer:
+			server = string(f.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return server, r, nil
+}
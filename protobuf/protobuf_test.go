@@ -0,0 +1,101 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protobuf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPacket() *ntp.Packet {
+	return &ntp.Packet{
+		Settings:       0x24,
+		Stratum:        1,
+		Poll:           6,
+		Precision:      -20,
+		RootDelay:      10,
+		RootDispersion: 20,
+		ReferenceID:    0x47505300,
+		RefTimeSec:     3800000000,
+		RefTimeFrac:    1,
+		OrigTimeSec:    3800000001,
+		OrigTimeFrac:   2,
+		RxTimeSec:      3800000002,
+		RxTimeFrac:     3,
+		TxTimeSec:      3800000003,
+		TxTimeFrac:     4,
+	}
+}
+
+func TestMarshalPacketWireFormat(t *testing.T) {
+	b := MarshalPacket(&ntp.Packet{Settings: 0x24, Poll: -1, ReferenceID: 0x01020304})
+	// settings varint, poll zigzag varint, reference ID fixed32 little endian
+	assert.Equal(t, []byte{0x08, 0x24, 0x18, 0x01, 0x3d, 0x04, 0x03, 0x02, 0x01}, b)
+	assert.Empty(t, MarshalPacket(&ntp.Packet{}), "zero values are skipped")
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	p := testPacket()
+	got, err := UnmarshalPacket(MarshalPacket(p))
+	require.Nil(t, err)
+	assert.Equal(t, p, got)
+}
+
+func TestUnmarshalPacketErrors(t *testing.T) {
+	b := MarshalPacket(testPacket())
+	_, err := UnmarshalPacket(b[:len(b)-1])
+	assert.ErrorIs(t, err, ErrTruncated)
+
+	// unknown field 15 of bytes type is skipped
+	unknown := append([]byte{0x7a, 0x02, 0xff, 0xff}, b...)
+	got, err := UnmarshalPacket(unknown)
+	require.Nil(t, err)
+	assert.Equal(t, testPacket(), got)
+
+	_, err = UnmarshalPacket([]byte{0x0b})
+	assert.NotNil(t, err, "groups are not supported")
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	t1 := time.Unix(1600000000, 123)
+	r := &client.Response{
+		Packet:             testPacket(),
+		ClientTransmitTime: t1,
+		ServerReceiveTime:  t1.Add(time.Second + 10*time.Millisecond),
+		ServerTransmitTime: t1.Add(time.Second + 11*time.Millisecond),
+		ClientReceiveTime:  t1.Add(21 * time.Millisecond),
+	}
+	b := MarshalResponse("10.0.0.1:123", r)
+	server, got, err := UnmarshalResponse(b)
+	require.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:123", server)
+	assert.Equal(t, r.Packet, got.Packet)
+	assert.True(t, r.ClientTransmitTime.Equal(got.ClientTransmitTime))
+	assert.True(t, r.ClientReceiveTime.Equal(got.ClientReceiveTime))
+	assert.Equal(t, time.Second, got.Offset())
+
+	server, got, err = UnmarshalResponse(MarshalResponse("", &client.Response{}))
+	require.Nil(t, err)
+	assert.Empty(t, server)
+	assert.True(t, got.ClientTransmitTime.IsZero())
+	assert.Nil(t, got.Packet)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// KeyFile keeps keys read from a key file up to date. After a reload keys of the previous version
+// keep validating requests for the overlap window, so peers can switch to the new keys at their own pace.
+// It is safe for concurrent use
+type KeyFile struct {
+	path    string
+	trusted []uint32
+	overlap time.Duration
+
+	mu            sync.RWMutex
+	keys          *Keys
+	previous      *Keys
+	previousUntil time.Time
+}
+
+// OpenKeyFile reads key file trusting given keys, all of them if trusted is empty
+func OpenKeyFile(path string, trusted []uint32, overlap time.Duration) (*KeyFile, error) {
+	f := &KeyFile{path: path, trusted: trusted, overlap: overlap}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the path of the key file
+func (f *KeyFile) Path() string {
+	return f.path
+}
+
+// Reload reads the key file again and atomically replaces the keys.
+// Current keys are kept if the file can't be read
+func (f *KeyFile) Reload() error {
+	keys, err := ReadKeysFile(f.path)
+	if err != nil {
+		return err
+	}
+	if len(f.trusted) == 0 {
+		keys.TrustAll()
+	} else if err := keys.Trust(f.trusted...); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.keys != nil && f.overlap > 0 {
+		f.previous = f.keys
+		f.previousUntil = time.Now().Add(f.overlap)
+	}
+	f.keys = keys
+	return nil
+}
+
+// Keys returns current keys
+func (f *KeyFile) Keys() *Keys {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.keys
+}
+
+// Verify authenticates message with current keys or, during the overlap window, with the previous ones
+func (f *KeyFile) Verify(header, extensions []byte) (*Key, error) {
+	f.mu.RLock()
+	keys, previous, until := f.keys, f.previous, f.previousUntil
+	f.mu.RUnlock()
+
+	key, err := keys.Verify(header, extensions)
+	if err == nil || errors.Is(err, ErrNoMAC) || previous == nil || time.Now().After(until) {
+		return key, err
+	}
+	if key, perr := previous.Verify(header, extensions); perr == nil {
+		return key, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeys(t *testing.T, path, content string) {
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+}
+
+func TestKeyFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ntp.keys")
+	writeKeys(t, path, "1 MD5 old\n2 MD5 untrusted\n")
+
+	f, err := OpenKeyFile(path, []uint32{1}, 50*time.Millisecond)
+	require.Nil(t, err)
+	assert.Equal(t, path, f.Path())
+	oldKey := &Key{ID: 1, Algorithm: MD5, Secret: []byte("old")}
+	newKey := &Key{ID: 1, Algorithm: MD5, Secret: []byte("new")}
+	old := oldKey.Sign(testHeader())
+	signedNew := newKey.Sign(testHeader())
+
+	_, err = f.Verify(old[:48], old[48:])
+	assert.Nil(t, err)
+	_, err = f.Verify(signedNew[:48], signedNew[48:])
+	assert.ErrorIs(t, err, ErrBadMAC)
+	untrusted := (&Key{ID: 2, Algorithm: MD5, Secret: []byte("untrusted")}).Sign(testHeader())
+	_, err = f.Verify(untrusted[:48], untrusted[48:])
+	assert.ErrorIs(t, err, ErrUntrustedKey)
+
+	// broken file doesn't replace the keys
+	writeKeys(t, path, "1 MD5\n")
+	assert.NotNil(t, f.Reload())
+	_, err = f.Verify(old[:48], old[48:])
+	assert.Nil(t, err)
+
+	// both versions validate during the overlap
+	writeKeys(t, path, "1 MD5 new\n2 MD5 untrusted\n")
+	require.Nil(t, f.Reload())
+	key, err := f.Verify(signedNew[:48], signedNew[48:])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), key.Secret)
+	key, err = f.Verify(old[:48], old[48:])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), key.Secret, "response has to be signed with the key request was")
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = f.Verify(old[:48], old[48:])
+	assert.ErrorIs(t, err, ErrBadMAC)
+	_, err = f.Verify(signedNew[:48], signedNew[48:])
+	assert.Nil(t, err)
+}
+
+func TestOpenKeyFileUnknownTrustedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ntp.keys")
+	writeKeys(t, path, "1 MD5 secret\n")
+
+	_, err = OpenKeyFile(path, []uint32{2}, 0)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = OpenKeyFile(filepath.Join(dir, "missing"), nil, 0)
+	assert.NotNil(t, err)
+}
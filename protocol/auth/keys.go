@@ -0,0 +1,173 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth implements NTP symmetric key authentication: ntp.keys files and message authentication codes
+package auth
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxKeyID is the largest key ID ntp.keys may contain
+const MaxKeyID = 65534
+
+// maxASCIIKeyLength is the longest key ntpd reads as ASCII, longer ones are hex
+const maxASCIIKeyLength = 20
+
+// Key is a symmetric key shared with a peer
+type Key struct {
+	ID        uint32
+	Algorithm Algorithm
+	Secret    []byte
+}
+
+// Keys is a set of symmetric keys. Only trusted ones are used to authenticate messages
+type Keys struct {
+	keys    map[uint32]*Key
+	trusted map[uint32]bool
+}
+
+// NewKeys returns a set of given keys, none of them trusted
+func NewKeys(keys ...*Key) *Keys {
+	k := &Keys{keys: map[uint32]*Key{}, trusted: map[uint32]bool{}}
+	for _, key := range keys {
+		k.keys[key.ID] = key
+	}
+	return k
+}
+
+// ReadKeysFile reads ntpd-style key file
+func ReadKeysFile(path string) (*Keys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKeys(f)
+}
+
+// ParseKeys parses ntpd-style key file: one "keyid type key" per line, # starts a comment.
+// Key longer than 20 characters is hex encoded, chrony-style HEX: and ASCII: prefixes are supported as well
+func ParseKeys(r io.Reader) (*Keys, error) {
+	k := NewKeys()
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected key ID, type and key, got %d fields", line, len(fields))
+		}
+		key, err := parseKey(fields[0], fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %d", line, key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func parseKey(id, algorithm, secret string) (*Key, error) {
+	keyID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || keyID < 1 || keyID > MaxKeyID {
+		return nil, fmt.Errorf("invalid key ID %q", id)
+	}
+	alg, err := ParseAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := alg.Allowed(); err != nil {
+		return nil, fmt.Errorf("key %d: %w", keyID, err)
+	}
+	key := &Key{ID: uint32(keyID), Algorithm: alg}
+	switch {
+	case strings.HasPrefix(secret, "HEX:"):
+		key.Secret, err = hex.DecodeString(secret[len("HEX:"):])
+	case strings.HasPrefix(secret, "ASCII:"):
+		key.Secret = []byte(secret[len("ASCII:"):])
+	case len(secret) > maxASCIIKeyLength:
+		key.Secret, err = hex.DecodeString(secret)
+	default:
+		key.Secret = []byte(secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %d is not valid hex: %w", keyID, err)
+	}
+	if err := alg.validKey(key.Secret); err != nil {
+		return nil, fmt.Errorf("key %d: %w", keyID, err)
+	}
+	return key, nil
+}
+
+// Trust marks keys as trusted
+func (k *Keys) Trust(ids ...uint32) error {
+	for _, id := range ids {
+		if _, ok := k.keys[id]; !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownKey, id)
+		}
+		k.trusted[id] = true
+	}
+	return nil
+}
+
+// TrustAll marks all the keys as trusted
+func (k *Keys) TrustAll() {
+	for id := range k.keys {
+		k.trusted[id] = true
+	}
+}
+
+// IDs returns sorted IDs of all the keys
+func (k *Keys) IDs() []uint32 {
+	ids := make([]uint32, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Trusted returns trusted key with a given ID
+func (k *Keys) Trusted(id uint32) (*Key, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+	if !k.trusted[id] {
+		return nil, fmt.Errorf("%w: %d", ErrUntrustedKey, id)
+	}
+	return key, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKeys = `
+# ntpd style
+1 M secret
+2 MD5 verysecret # trailing comment
+3 SHA1 00112233445566778899aabbccddeeff00112233
+# chrony style
+4 SHA1 HEX:0011
+5 MD5 ASCII:this-key-is-long-but-still-ascii
+6 AES128CMAC 2b7e151628aed2a6abf7158809cf4f3c
+7 AES128 HEX:2b7e151628aed2a6abf7158809cf4f3c
+`
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader(testKeys))
+	require.Nil(t, err)
+	assert.Equal(t, []uint32{1, 2, 3, 4, 5, 6, 7}, keys.IDs())
+	assert.Equal(t, &Key{ID: 1, Algorithm: MD5, Secret: []byte("secret")}, keys.keys[1])
+	assert.Equal(t, &Key{ID: 3, Algorithm: SHA1, Secret: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x00, 0x11, 0x22, 0x33}}, keys.keys[3])
+	assert.Equal(t, []byte{0x00, 0x11}, keys.keys[4].Secret)
+	assert.Equal(t, []byte("this"), keys.keys[5].Secret[:4])
+	assert.Equal(t, AES128CMAC, keys.keys[6].Algorithm)
+	assert.Equal(t, keys.keys[6].Secret, keys.keys[7].Secret)
+}
+
+func TestParseKeysInvalid(t *testing.T) {
+	for _, keys := range []string{
+		"1 MD5",
+		"0 MD5 secret",
+		"65535 MD5 secret",
+		"x MD5 secret",
+		"1 SHA512 secret",
+		"1 MD5 this-is-longer-than-20-but-not-hex",
+		"1 MD5 secret\n1 SHA1 secret",
+		"1 AES128CMAC secret",
+		"1 AES128CMAC 2b7e151628aed2a6abf7158809cf4f",
+	} {
+		_, err := ParseKeys(strings.NewReader(keys))
+		assert.NotNil(t, err, keys)
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader(testKeys))
+	require.Nil(t, err)
+
+	_, err = keys.Trusted(1)
+	assert.ErrorIs(t, err, ErrUntrustedKey)
+	_, err = keys.Trusted(42)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.ErrorIs(t, keys.Trust(42), ErrUnknownKey)
+
+	require.Nil(t, keys.Trust(1, 3))
+	key, err := keys.Trusted(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), key.ID)
+	_, err = keys.Trusted(2)
+	assert.ErrorIs(t, err, ErrUntrustedKey)
+
+	keys.TrustAll()
+	_, err = keys.Trusted(2)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,237 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/facebookincubator/ntp/internal/cmac"
+	"github.com/facebookincubator/ntp/internal/fips"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// Algorithm is MAC algorithm of a key
+type Algorithm string
+
+// Supported algorithms. MD5 and SHA1 are legacy keyed digests, AES128CMAC is the one RFC 8573 recommends
+const (
+	MD5        Algorithm = "MD5"
+	SHA1       Algorithm = "SHA1"
+	AES128CMAC Algorithm = "AES128CMAC"
+)
+
+// KeyIDSizeBytes is the size of key ID which precedes the digest in MAC
+const KeyIDSizeBytes = 4
+
+// CryptoNAKSizeBytes is the size of crypto-NAK: MAC consisting of zero key ID only
+const CryptoNAKSizeBytes = KeyIDSizeBytes
+
+// MaxMACSizeBytes is the longest MAC NTPv4 allows, anything longer following the header is an extension field
+const MaxMACSizeBytes = 24
+
+var (
+	// ErrNoMAC is returned when the message has no MAC
+	ErrNoMAC = errors.New("message has no MAC")
+	// ErrUnknownKey is returned for key IDs missing from the key file
+	ErrUnknownKey = errors.New("unknown key")
+	// ErrUntrustedKey is returned for keys which are not trusted
+	ErrUntrustedKey = errors.New("untrusted key")
+	// ErrBadMAC is returned when digest doesn't match the message
+	ErrBadMAC = errors.New("MAC verification failed")
+	// ErrCryptoNAK is returned when server replied with crypto-NAK, which means it couldn't authenticate the request
+	ErrCryptoNAK = errors.New("crypto-NAK received")
+	// ErrMalformed is returned when extension fields and MAC following the header can't be told apart
+	ErrMalformed = errors.New("malformed extension fields or MAC")
+	// ErrNotApproved is returned for MD5 and SHA1 keys in FIPS mode
+	ErrNotApproved = fips.ErrNotApproved
+)
+
+// digestSize is the size of the digest algorithm produces
+var digestSize = map[Algorithm]int{
+	MD5:        md5.Size,
+	SHA1:       sha1.Size,
+	AES128CMAC: cmac.Size,
+}
+
+// ParseAlgorithm parses algorithm name as written in key files
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToUpper(name) {
+	case "M", "MD5":
+		return MD5, nil
+	case "SHA1", "SHA-1":
+		return SHA1, nil
+	case "AES128CMAC", "AES-128-CMAC", "AES128":
+		return AES128CMAC, nil
+	}
+	return "", fmt.Errorf("unsupported key type %q", name)
+}
+
+// Allowed checks the algorithm may be used: FIPS mode only allows AES128CMAC
+func (a Algorithm) Allowed() error {
+	if fips.Enabled() && a != AES128CMAC {
+		return fmt.Errorf("%w: %s MAC", ErrNotApproved, a)
+	}
+	return nil
+}
+
+func (a Algorithm) validKey(secret []byte) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("empty key")
+	}
+	if a == AES128CMAC && len(secret) != aes.BlockSize {
+		return fmt.Errorf("%s key must be %d bytes, got %d", a, aes.BlockSize, len(secret))
+	}
+	return nil
+}
+
+// Size returns the size of MAC produced with the key, including key ID
+func (k *Key) Size() int {
+	return KeyIDSizeBytes + digestSize[k.Algorithm]
+}
+
+// digest computes the digest of the message: CMAC or legacy hash of the key followed by the message
+func (k *Key) digest(msg []byte) []byte {
+	switch k.Algorithm {
+	case MD5:
+		h := md5.New()
+		h.Write(k.Secret)
+		h.Write(msg)
+		return h.Sum(nil)
+	case SHA1:
+		h := sha1.New()
+		h.Write(k.Secret)
+		h.Write(msg)
+		return h.Sum(nil)
+	case AES128CMAC:
+		c, err := cmac.New(k.Secret)
+		if err != nil {
+			return nil
+		}
+		sum := c.Sum(msg)
+		return sum[:]
+	}
+	return nil
+}
+
+// Sign appends MAC of msg to it
+func (k *Key) Sign(msg []byte) []byte {
+	mac := make([]byte, KeyIDSizeBytes, k.Size())
+	binary.BigEndian.PutUint32(mac, k.ID)
+	mac = append(mac, k.digest(msg)...)
+	return append(msg, mac...)
+}
+
+// Verify checks MAC against msg it authenticates
+func (k *Key) Verify(msg, mac []byte) error {
+	if err := k.Algorithm.Allowed(); err != nil {
+		return err
+	}
+	if len(mac) != k.Size() || binary.BigEndian.Uint32(mac) != k.ID {
+		return ErrBadMAC
+	}
+	if subtle.ConstantTimeCompare(k.digest(msg), mac[KeyIDSizeBytes:]) != 1 {
+		return ErrBadMAC
+	}
+	return nil
+}
+
+// SplitMAC splits what follows NTP header into extension fields and MAC, see RFC 7822 section 7.5:
+// anything shorter than the minimal extension field which may end the message is a MAC.
+// Whatever doesn't parse as extension field is returned as MAC too, use Split to reject such messages
+func SplitMAC(extensions []byte) (fields, mac []byte) {
+	offset := 0
+	for len(extensions)-offset > MaxMACSizeBytes {
+		length := int(binary.BigEndian.Uint16(extensions[offset+2 : offset+4]))
+		if length < ntp.MinExtensionFieldSizeBytes || length%4 != 0 || length > len(extensions)-offset {
+			// not an extension field, let MAC verification reject it
+			break
+		}
+		offset += length
+	}
+	if offset == len(extensions) {
+		return extensions, nil
+	}
+	return extensions[:offset], extensions[offset:]
+}
+
+// Split is strict SplitMAC: it fails with ErrMalformed unless what follows NTP header is a sequence of
+// extension fields optionally followed by MAC of the size NTPv4 allows.
+// As fields are only taken while more than MaxMACSizeBytes remain, the last one without MAC is at least
+// ntp.MinLastExtensionFieldSizeBytes long, and a shorter one makes the message malformed
+func Split(extensions []byte) (fields, mac []byte, err error) {
+	fields, mac = SplitMAC(extensions)
+	if mac != nil && !validMACSize(len(mac)) {
+		return nil, nil, fmt.Errorf("%w: %d bytes following extension fields are not a MAC", ErrMalformed, len(mac))
+	}
+	return fields, mac, nil
+}
+
+// validMACSize checks n is the size of crypto-NAK or MAC: key ID followed by 128 or 160 bit digest
+func validMACSize(n int) bool {
+	return n == CryptoNAKSizeBytes || n == KeyIDSizeBytes+md5.Size || n == KeyIDSizeBytes+sha1.Size
+}
+
+// IsCryptoNAK checks if MAC is crypto-NAK
+func IsCryptoNAK(mac []byte) bool {
+	return len(mac) == CryptoNAKSizeBytes && binary.BigEndian.Uint32(mac) == 0
+}
+
+// AppendCryptoNAK appends crypto-NAK to the message, telling the peer its request failed authentication
+func AppendCryptoNAK(msg []byte) []byte {
+	return append(msg, make([]byte, CryptoNAKSizeBytes)...)
+}
+
+// KeyID returns key ID MAC was produced with
+func KeyID(mac []byte) (uint32, error) {
+	if len(mac) < KeyIDSizeBytes {
+		return 0, fmt.Errorf("MAC of %d bytes is too short", len(mac))
+	}
+	return binary.BigEndian.Uint32(mac), nil
+}
+
+// Verify authenticates message consisting of NTP header and extensions with a trusted key MAC was produced with.
+// It returns the key, so the response can be signed with it
+func (k *Keys) Verify(header, extensions []byte) (*Key, error) {
+	fields, mac, err := Split(extensions)
+	if err != nil {
+		return nil, err
+	}
+	if mac == nil {
+		return nil, ErrNoMAC
+	}
+	id, err := KeyID(mac)
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.Trusted(id)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(header)+len(fields))
+	msg = append(append(msg, header...), fields...)
+	if err := key.Verify(msg, mac); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
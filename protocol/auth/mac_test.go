@@ -0,0 +1,218 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/facebookincubator/ntp/internal/fips"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHeader() []byte {
+	header := make([]byte, 48)
+	header[0] = 0x23
+	return header
+}
+
+func TestSign(t *testing.T) {
+	secret, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233")
+	tests := []struct {
+		key    *Key
+		digest string
+	}{
+		{&Key{ID: 1, Algorithm: MD5, Secret: []byte("secret")}, "e090dd0f2a35e38783a54445cb1e6933"},
+		{&Key{ID: 3, Algorithm: SHA1, Secret: secret}, "e8aa14cd36bdbe248c54d57f200fc9f43d47d71c"},
+	}
+	for _, tt := range tests {
+		signed := tt.key.Sign(testHeader())
+		require.Equal(t, 48+tt.key.Size(), len(signed))
+		mac := signed[48:]
+		id, err := KeyID(mac)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.key.ID, id)
+		assert.Equal(t, tt.digest, hex.EncodeToString(mac[KeyIDSizeBytes:]))
+		assert.Nil(t, tt.key.Verify(signed[:48], mac))
+
+		mac[len(mac)-1] ^= 1
+		assert.Equal(t, ErrBadMAC, tt.key.Verify(signed[:48], mac))
+	}
+}
+
+// RFC 8573 MAC is AES-CMAC of the message, checked against RFC 4493 example 4
+func TestSignAES128CMAC(t *testing.T) {
+	secret, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	key := &Key{ID: 10, Algorithm: AES128CMAC, Secret: secret}
+	assert.Equal(t, 20, key.Size())
+
+	signed := key.Sign(append([]byte{}, msg...))
+	mac := signed[len(msg):]
+	assert.Equal(t, "0000000a51f0bebf7e3b9d92fc49741779363cfe", hex.EncodeToString(mac))
+	assert.Nil(t, key.Verify(msg, mac))
+}
+
+func TestSplitMAC(t *testing.T) {
+	field := []byte{0x01, 0x04, 0x00, 0x10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	mac := make([]byte, 20)
+
+	fields, m := SplitMAC(mac)
+	assert.Equal(t, 0, len(fields))
+	assert.Equal(t, mac, m)
+
+	fields, m = SplitMAC(append(append([]byte{}, field...), mac...))
+	assert.Equal(t, field, fields)
+	assert.Equal(t, mac, m)
+
+	longField := append([]byte{0x01, 0x04, 0x00, 0x20}, make([]byte, 28)...)
+	fields, m = SplitMAC(longField)
+	assert.Equal(t, longField, fields)
+	assert.Nil(t, m)
+
+	fields, m = SplitMAC(nil)
+	assert.Nil(t, fields)
+	assert.Nil(t, m)
+}
+
+func TestSplit(t *testing.T) {
+	field := []byte{0x01, 0x04, 0x00, 0x10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	lastField := append([]byte{0x01, 0x04, 0x00, 0x1c}, make([]byte, 24)...)
+	join := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	tests := []struct {
+		name       string
+		extensions []byte
+		fields     []byte
+		mac        []byte
+		err        bool
+	}{
+		{name: "empty"},
+		{name: "MD5 MAC", extensions: make([]byte, 20), mac: make([]byte, 20)},
+		{name: "SHA1 MAC", extensions: make([]byte, 24), mac: make([]byte, 24)},
+		{name: "crypto-NAK after field", extensions: join(lastField, make([]byte, 4)), fields: lastField, mac: make([]byte, 4)},
+		// RFC 7822 heuristic: no more than 24 bytes left can't be an extension field
+		{name: "short field and crypto-NAK", extensions: join(field, make([]byte, 4)), mac: join(field, make([]byte, 4))},
+		{name: "field and MAC", extensions: join(field, field, make([]byte, 20)), fields: join(field, field), mac: make([]byte, 20)},
+		{name: "last field of 28 bytes", extensions: join(field, lastField), fields: join(field, lastField)},
+		{name: "last field too short", extensions: join(lastField, field), err: true},
+		{name: "MAC of odd size", extensions: make([]byte, 13), err: true},
+		{name: "MAC of 8 bytes", extensions: join(lastField, make([]byte, 8)), err: true},
+		{name: "field shorter than 16 bytes", extensions: join([]byte{0x01, 0x04, 0x00, 0x0c}, make([]byte, 8), make([]byte, 20)), err: true},
+		{name: "unaligned field", extensions: join([]byte{0x01, 0x04, 0x00, 0x11}, make([]byte, 13), make([]byte, 20)), err: true},
+		{name: "field exceeding message", extensions: join([]byte{0x01, 0x04, 0xff, 0xfc}, make([]byte, 40)), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, mac, err := Split(tt.extensions)
+			if tt.err {
+				assert.ErrorIs(t, err, ErrMalformed)
+				return
+			}
+			require.Nil(t, err)
+			assert.True(t, bytes.Equal(tt.fields, fields))
+			assert.Equal(t, tt.mac, mac)
+		})
+	}
+}
+
+func TestSplitRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		b := make([]byte, r.Intn(128))
+		r.Read(b)
+		// lengths which are small multiples of 4 make it past the first field more often
+		if len(b) >= 4 && r.Intn(2) == 0 {
+			b[2], b[3] = 0, byte(r.Intn(32)*4)
+		}
+		fields, mac, err := Split(b)
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, b, append(append([]byte{}, fields...), mac...))
+		assert.True(t, mac == nil || validMACSize(len(mac)))
+	}
+}
+
+func TestKeysVerify(t *testing.T) {
+	key := &Key{ID: 7, Algorithm: SHA1, Secret: []byte("secret")}
+	keys := NewKeys(key)
+	field := []byte{0x01, 0x04, 0x00, 0x10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	signed := key.Sign(append(testHeader(), field...))
+
+	_, err := keys.Verify(signed[:48], signed[48:])
+	assert.ErrorIs(t, err, ErrUntrustedKey)
+
+	require.Nil(t, keys.Trust(7))
+	verifiedBy, err := keys.Verify(signed[:48], signed[48:])
+	assert.Nil(t, err)
+	assert.Equal(t, key, verifiedBy)
+
+	_, err = keys.Verify(testHeader(), nil)
+	assert.Equal(t, ErrNoMAC, err)
+
+	signed[10] ^= 1
+	_, err = keys.Verify(signed[:48], signed[48:])
+	assert.Equal(t, ErrBadMAC, err)
+}
+
+func TestCryptoNAK(t *testing.T) {
+	msg := AppendCryptoNAK(testHeader())
+	assert.Equal(t, 48+CryptoNAKSizeBytes, len(msg))
+	fields, mac := SplitMAC(msg[48:])
+	assert.Equal(t, 0, len(fields))
+	assert.True(t, IsCryptoNAK(mac))
+
+	assert.False(t, IsCryptoNAK([]byte{0, 0, 0, 1}))
+	assert.False(t, IsCryptoNAK(make([]byte, 20)))
+}
+
+func TestFIPS(t *testing.T) {
+	prev := fips.Enabled()
+	defer fips.Set(prev)
+	fips.Set(true)
+
+	_, err := ParseKeys(strings.NewReader("1 MD5 secret"))
+	assert.ErrorIs(t, err, ErrNotApproved)
+	_, err = ParseKeys(strings.NewReader("1 SHA1 secret"))
+	assert.ErrorIs(t, err, ErrNotApproved)
+	keys, err := ParseKeys(strings.NewReader("1 AES128CMAC 2b7e151628aed2a6abf7158809cf4f3c"))
+	require.Nil(t, err)
+	keys.TrustAll()
+	key, err := keys.Trusted(1)
+	require.Nil(t, err)
+	signed := key.Sign(testHeader())
+	_, err = keys.Verify(signed[:48], signed[48:])
+	assert.Nil(t, err)
+
+	// keys loaded before FIPS mode was switched on are refused as well
+	md5 := &Key{ID: 2, Algorithm: MD5, Secret: []byte("secret")}
+	keys = NewKeys(md5)
+	keys.TrustAll()
+	signed = md5.Sign(testHeader())
+	_, err = keys.Verify(signed[:48], signed[48:])
+	assert.ErrorIs(t, err, ErrNotApproved)
+}
@@ -89,15 +89,72 @@ func (n *Client) Communicate(packet RequestPacket) (ResponsePacket, error) {
 			replyHead: *head,
 			tracking:  *newTracking(data),
 		}, nil
+	case rpySourceStats:
+		data := new(replySourceStatsContent)
+		if err = binary.Read(r, binary.BigEndian, data); err != nil {
+			return nil, err
+		}
+		log.Debugf("response data: %+v", data)
+		return &ReplySourceStats{
+			replyHead:   *head,
+			sourceStats: *newSourceStats(data),
+		}, nil
 	case rpyServerStats:
-		data := new(serverStats)
+		data := new(replyServerStatsContent)
 		if err = binary.Read(r, binary.BigEndian, data); err != nil {
 			return nil, err
 		}
 		log.Debugf("response data: %+v", data)
 		return &ReplyServerStats{
-			replyHead:   *head,
-			serverStats: *data,
+			replyHead: *head,
+			serverStats: serverStats{
+				NTPHits:  data.NTPHits,
+				CMDHits:  data.CMDHits,
+				NTPDrops: data.NTPDrops,
+				CMDDrops: data.CMDDrops,
+				LogDrops: data.LogDrops,
+			},
+		}, nil
+	case rpyServerStats2:
+		data := new(replyServerStats2Content)
+		if err = binary.Read(r, binary.BigEndian, data); err != nil {
+			return nil, err
+		}
+		log.Debugf("response data: %+v", data)
+		return &ReplyServerStats{
+			replyHead: *head,
+			serverStats: serverStats{
+				NTPHits:     data.NTPHits,
+				NKEHits:     data.NKEHits,
+				CMDHits:     data.CMDHits,
+				NTPDrops:    data.NTPDrops,
+				NKEDrops:    data.NKEDrops,
+				CMDDrops:    data.CMDDrops,
+				LogDrops:    data.LogDrops,
+				NTPAuthHits: data.NTPAuthHits,
+			},
+		}, nil
+	case rpyServerStats3:
+		data := new(replyServerStats3Content)
+		if err = binary.Read(r, binary.BigEndian, data); err != nil {
+			return nil, err
+		}
+		log.Debugf("response data: %+v", data)
+		return &ReplyServerStats{
+			replyHead: *head,
+			serverStats: serverStats{
+				NTPHits:            data.NTPHits,
+				NKEHits:            data.NKEHits,
+				CMDHits:            data.CMDHits,
+				NTPDrops:           data.NTPDrops,
+				NKEDrops:           data.NKEDrops,
+				CMDDrops:           data.CMDDrops,
+				LogDrops:           data.LogDrops,
+				NTPAuthHits:        data.NTPAuthHits,
+				NTPInterleavedHits: data.NTPInterleavedHits,
+				NTPTimestamps:      data.NTPTimestamps,
+				NTPSpanSeconds:     data.NTPSpanSeconds,
+			},
 		}, nil
 	case rpyNTPData:
 		data := new(replyNTPDataContent)
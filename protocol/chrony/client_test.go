@@ -198,3 +198,104 @@ func TestCommunicateOK(t *testing.T) {
 	}
 	assert.Equal(expected, p)
 }
+
+func TestCommunicateSourceStats(t *testing.T) {
+	var err error
+	assert := assert.New(t)
+	require := require.New(t)
+	buf := &bytes.Buffer{}
+	packetHead := replyHead{
+		Version:  protoVersionNumber,
+		PKTType:  pktTypeCmdReply,
+		Command:  reqSourceStats,
+		Reply:    rpySourceStats,
+		Status:   sttSuccess,
+		Sequence: 2,
+	}
+	packetBody := replySourceStatsContent{
+		RefID:       0xc0a8000a,
+		IPAddr:      *newIPAddr(net.IP([]byte{192, 168, 0, 10})),
+		NSamples:    12,
+		NRuns:       7,
+		SpanSeconds: 720,
+		StandardDev: 12345,
+	}
+	err = binary.Write(buf, binary.BigEndian, packetHead)
+	require.Nil(err)
+	err = binary.Write(buf, binary.BigEndian, packetBody)
+	require.Nil(err)
+	conn := newConn([]*bytes.Buffer{
+		buf,
+	})
+	client := Client{Sequence: 1, Connection: conn}
+	p, err := client.Communicate(NewSourceStatsPacket(0))
+	require.Nil(err)
+	expected := &ReplySourceStats{
+		replyHead: packetHead,
+		sourceStats: sourceStats{
+			RefID:          packetBody.RefID,
+			IPAddr:         net.IP([]byte{192, 168, 0, 10}),
+			NSamples:       12,
+			NRuns:          7,
+			SpanSeconds:    720,
+			StandardDev:    packetBody.StandardDev.ToFloat(),
+			ResidFreqPPM:   packetBody.ResidFreqPPM.ToFloat(),
+			SkewPPM:        packetBody.SkewPPM.ToFloat(),
+			EstOffset:      packetBody.EstOffset.ToFloat(),
+			EstOffsetError: packetBody.EstOffsetError.ToFloat(),
+		},
+	}
+	assert.Equal(expected, p)
+}
+
+// chrony 4.1 replies to serverstats with its third version
+func TestCommunicateServerStats3(t *testing.T) {
+	var err error
+	assert := assert.New(t)
+	require := require.New(t)
+	buf := &bytes.Buffer{}
+	packetHead := replyHead{
+		Version:  protoVersionNumber,
+		PKTType:  pktTypeCmdReply,
+		Command:  reqServerStats,
+		Reply:    rpyServerStats3,
+		Status:   sttSuccess,
+		Sequence: 2,
+	}
+	packetBody := replyServerStats3Content{
+		NTPHits:            100,
+		NKEHits:            2,
+		CMDHits:            3,
+		NTPDrops:           4,
+		LogDrops:           5,
+		NTPAuthHits:        6,
+		NTPInterleavedHits: 7,
+		NTPTimestamps:      8,
+		NTPSpanSeconds:     9,
+	}
+	err = binary.Write(buf, binary.BigEndian, packetHead)
+	require.Nil(err)
+	err = binary.Write(buf, binary.BigEndian, packetBody)
+	require.Nil(err)
+	conn := newConn([]*bytes.Buffer{
+		buf,
+	})
+	client := Client{Sequence: 1, Connection: conn}
+	p, err := client.Communicate(NewServerStatsPacket())
+	require.Nil(err)
+	expected := &ReplyServerStats{
+		replyHead: packetHead,
+		serverStats: serverStats{
+			NTPHits:            100,
+			NKEHits:            2,
+			CMDHits:            3,
+			NTPDrops:           4,
+			LogDrops:           5,
+			NTPAuthHits:        6,
+			NTPInterleavedHits: 7,
+			NTPTimestamps:      8,
+			NTPSpanSeconds:     9,
+		},
+	}
+	assert.Equal(expected, p)
+}
@@ -57,17 +57,21 @@ const (
 	reqNSources    CommandType = 14
 	reqSourceData  CommandType = 15
 	reqTracking    CommandType = 33
+	reqSourceStats CommandType = 34
 	reqServerStats CommandType = 54
 	reqNtpData     CommandType = 57
 )
 
 // reply types
 const (
-	rpyNSources    ReplyType = 2
-	rpySourceData  ReplyType = 3
-	rpyTracking    ReplyType = 5
-	rpyServerStats ReplyType = 14
-	rpyNTPData     ReplyType = 16
+	rpyNSources     ReplyType = 2
+	rpySourceData   ReplyType = 3
+	rpyTracking     ReplyType = 5
+	rpySourceStats  ReplyType = 6
+	rpyServerStats  ReplyType = 14
+	rpyNTPData      ReplyType = 16
+	rpyServerStats2 ReplyType = 22
+	rpyServerStats3 ReplyType = 24
 )
 
 // source modes
@@ -214,6 +218,15 @@ type RequestSourceData struct {
 	data [maxDataLen - 4]uint8 //nolint:unused,structcheck
 }
 
+// RequestSourceStats - packet to request source stats for source id
+type RequestSourceStats struct {
+	requestHead
+	Index int32
+	EOR   int32
+	// we pass i32 - 4 bytes
+	data [maxDataLen - 4]uint8 //nolint:unused,structcheck
+}
+
 // RequestNTPData - packet to request NTP data for peer IP
 type RequestNTPData struct {
 	requestHead
@@ -484,7 +497,56 @@ type ReplyNTPData struct {
 	ntpData
 }
 
-type serverStats struct {
+type replySourceStatsContent struct {
+	RefID          uint32
+	IPAddr         ipAddr
+	NSamples       uint32
+	NRuns          uint32
+	SpanSeconds    uint32
+	StandardDev    chronyFloat
+	ResidFreqPPM   chronyFloat
+	SkewPPM        chronyFloat
+	EstOffset      chronyFloat
+	EstOffsetError chronyFloat
+	EOR            int32
+}
+
+// sourceStats contains parsed version of 'sourcestats' reply
+type sourceStats struct {
+	RefID          uint32
+	IPAddr         net.IP
+	NSamples       uint32
+	NRuns          uint32
+	SpanSeconds    uint32
+	StandardDev    float64
+	ResidFreqPPM   float64
+	SkewPPM        float64
+	EstOffset      float64
+	EstOffsetError float64
+}
+
+func newSourceStats(r *replySourceStatsContent) *sourceStats {
+	return &sourceStats{
+		RefID:          r.RefID,
+		IPAddr:         r.IPAddr.ToNetIP(),
+		NSamples:       r.NSamples,
+		NRuns:          r.NRuns,
+		SpanSeconds:    r.SpanSeconds,
+		StandardDev:    r.StandardDev.ToFloat(),
+		ResidFreqPPM:   r.ResidFreqPPM.ToFloat(),
+		SkewPPM:        r.SkewPPM.ToFloat(),
+		EstOffset:      r.EstOffset.ToFloat(),
+		EstOffsetError: r.EstOffsetError.ToFloat(),
+	}
+}
+
+// ReplySourceStats is a usable version of 'sourcestats' reply for given source id
+type ReplySourceStats struct {
+	replyHead
+	sourceStats
+}
+
+type replyServerStatsContent struct {
 	NTPHits  uint32
 	CMDHits  uint32
 	NTPDrops uint32
@@ -492,6 +554,50 @@ type serverStats struct {
 	LogDrops uint32
 }
 
+// chrony 4.0 added NTS-KE and authentication counters
+type replyServerStats2Content struct {
+	NTPHits     uint32
+	NKEHits     uint32
+	CMDHits     uint32
+	NTPDrops    uint32
+	NKEDrops    uint32
+	CMDDrops    uint32
+	LogDrops    uint32
+	NTPAuthHits uint32
+	EOR         int32
+}
+
+// chrony 4.1 added interleaved mode and timestamp counters
+type replyServerStats3Content struct {
+	NTPHits            uint32
+	NKEHits            uint32
+	CMDHits            uint32
+	NTPDrops           uint32
+	NKEDrops           uint32
+	CMDDrops           uint32
+	LogDrops           uint32
+	NTPAuthHits        uint32
+	NTPInterleavedHits uint32
+	NTPTimestamps      uint32
+	NTPSpanSeconds     uint32
+	EOR                int32
+}
+
+// serverStats has counters of all serverstats reply versions, those older chronyd doesn't report are 0
+type serverStats struct {
+	NTPHits            uint32
+	NKEHits            uint32
+	CMDHits            uint32
+	NTPDrops           uint32
+	NKEDrops           uint32
+	CMDDrops           uint32
+	LogDrops           uint32
+	NTPAuthHits        uint32
+	NTPInterleavedHits uint32
+	NTPTimestamps      uint32
+	NTPSpanSeconds     uint32
+}
+
 // ReplyServerStats is a usable version of 'serverstats' response
 type ReplyServerStats struct {
 	replyHead
@@ -534,6 +640,18 @@ func NewSourceDataPacket(sourceID int32) *RequestSourceData {
 	}
 }
 
+// NewSourceStatsPacket creates new packet to request 'sourcestats' information about source with given ID
+func NewSourceStatsPacket(sourceID int32) *RequestSourceStats {
+	return &RequestSourceStats{
+		requestHead: requestHead{
+			Version: protoVersionNumber,
+			PKTType: pktTypeCmdRequest,
+			Command: reqSourceStats,
+		},
+		Index: sourceID,
+	}
+}
+
 // NewNTPDataPacket creates new packet to request 'ntp data' information for given peer IP
 func NewNTPDataPacket(ip net.IP) *RequestNTPData {
 	return &RequestNTPData{
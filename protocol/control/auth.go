@@ -0,0 +1,120 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/auth"
+)
+
+// DefaultNonceLifetime is how long nonce stays valid when Nonces have no lifetime set, same as ntpd
+const DefaultNonceLifetime = 16 * time.Second
+
+// nonceSize is the size of nonce in hex: issue time in seconds followed by 64 bit MAC
+const nonceSize = 8 + 16
+
+// ErrBadNonce is returned for nonces not issued to the address or expired
+var ErrBadNonce = errors.New("invalid or expired nonce")
+
+// SplitMAC splits control message into the part MAC covers, which is the message padded to 64 bit boundary,
+// and MAC: key ID followed by digest. MAC is nil if the message isn't authenticated
+func SplitMAC(b []byte) (msg, mac []byte, err error) {
+	m, err := ParseRequest(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	end := (headSize + int(m.Count) + 7) &^ 7
+	if len(b) <= end {
+		return b, nil, nil
+	}
+	return b[:end], b[end:], nil
+}
+
+// Sign pads the message to 64 bit boundary and appends its MAC, the way ntpq and ntpd authenticate control messages
+func Sign(key *auth.Key, b []byte) []byte {
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	return key.Sign(b)
+}
+
+// Nonces issues nonces proving the client receives responses at the address it claims, and checks them.
+// Nonce is its issue time and MAC of it along with client address, so nothing is kept per client. It is safe for concurrent use
+type Nonces struct {
+	// Lifetime is DefaultNonceLifetime if not set
+	Lifetime time.Duration
+
+	secret []byte
+}
+
+// NewNonces returns nonces keyed with random secret
+func NewNonces() (*Nonces, error) {
+	secret := make([]byte, sha256.Size)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &Nonces{secret: secret}, nil
+}
+
+func (n *Nonces) mac(addr net.IP, issued uint32) []byte {
+	h := hmac.New(sha256.New, n.secret)
+	var ts [4]byte
+	binary.BigEndian.PutUint32(ts[:], issued)
+	h.Write(ts[:])
+	h.Write(addr.To16())
+	return h.Sum(nil)[:8]
+}
+
+// Issue returns nonce for the address
+func (n *Nonces) Issue(addr net.IP, now time.Time) string {
+	issued := uint32(now.Unix())
+	return fmt.Sprintf("%08x%x", issued, n.mac(addr, issued))
+}
+
+// Verify checks nonce was issued to the address within its lifetime
+func (n *Nonces) Verify(nonce string, addr net.IP, now time.Time) error {
+	if len(nonce) != nonceSize {
+		return ErrBadNonce
+	}
+	issued, err := strconv.ParseUint(nonce[:8], 16, 32)
+	if err != nil {
+		return ErrBadNonce
+	}
+	mac, err := hex.DecodeString(nonce[8:])
+	if err != nil || !hmac.Equal(mac, n.mac(addr, uint32(issued))) {
+		return ErrBadNonce
+	}
+	lifetime := n.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultNonceLifetime
+	}
+	age := now.Sub(time.Unix(int64(issued), 0))
+	if age < 0 || age > lifetime {
+		return ErrBadNonce
+	}
+	return nil
+}
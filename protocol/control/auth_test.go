@@ -0,0 +1,69 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = &auth.Key{ID: 5, Algorithm: auth.SHA1, Secret: []byte("secret")}
+
+func TestSignSplitMAC(t *testing.T) {
+	h := NTPControlMsgHead{VnMode: vnModeRequest, REMOp: OpReadVariables, Sequence: 1, Count: 7}
+	plain := encode(&h, []byte("stratum"))
+	msg, mac, err := SplitMAC(plain)
+	require.Nil(t, err)
+	assert.Nil(t, mac)
+	assert.Equal(t, plain, msg)
+
+	signed := Sign(testKey, plain)
+	// 12 bytes of header and 7 of data are padded to 24
+	require.Equal(t, 24+testKey.Size(), len(signed))
+	msg, mac, err = SplitMAC(signed)
+	require.Nil(t, err)
+	assert.Equal(t, 24, len(msg))
+	assert.Nil(t, testKey.Verify(msg, mac))
+
+	signed[13] ^= 1
+	msg, mac, err = SplitMAC(signed)
+	require.Nil(t, err)
+	assert.ErrorIs(t, testKey.Verify(msg, mac), auth.ErrBadMAC)
+}
+
+func TestNonces(t *testing.T) {
+	n, err := NewNonces()
+	require.Nil(t, err)
+	addr := net.ParseIP("10.0.0.1")
+	now := time.Unix(1600000000, 0)
+	nonce := n.Issue(addr, now)
+	assert.Len(t, nonce, nonceSize)
+	assert.Nil(t, n.Verify(nonce, addr, now.Add(time.Second)))
+	assert.ErrorIs(t, n.Verify(nonce, net.ParseIP("10.0.0.2"), now), ErrBadNonce)
+	assert.ErrorIs(t, n.Verify(nonce, addr, now.Add(DefaultNonceLifetime+time.Second)), ErrBadNonce)
+	assert.ErrorIs(t, n.Verify(nonce[:8]+"0000000000000000", addr, now), ErrBadNonce)
+	assert.ErrorIs(t, n.Verify("", addr, now), ErrBadNonce)
+
+	other, err := NewNonces()
+	require.Nil(t, err)
+	assert.ErrorIs(t, other.Verify(nonce, addr, now), ErrBadNonce)
+}
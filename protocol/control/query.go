@@ -0,0 +1,281 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/auth"
+)
+
+// DefaultTimeout is used when Client has no timeout set
+const DefaultTimeout = 5 * time.Second
+
+// vnModeRequest is version 2 mode 6, what ntpq sends
+const vnModeRequest = 0x16
+
+// headSize is the size of NTPControlMsgHead on the wire
+const headSize = 12
+
+// ErrorDesc stores human-readable descriptions of error codes of responses with Error bit set
+var ErrorDesc = [8]string{
+	"unspecified",
+	"authentication failure",
+	"invalid message length or format",
+	"invalid opcode",
+	"unknown association ID",
+	"unknown variable name",
+	"invalid variable value",
+	"administratively prohibited",
+}
+
+// ErrResponse is returned when server replied with Error bit set
+var ErrResponse = errors.New("control request failed")
+
+// Client queries ntpd servers with NTP control messages (mode 6), like ntpq does. Unlike NTPClient,
+// it skips responses to other requests and reassembles fragments by their offset, as they may arrive out of order
+type Client struct {
+	// Timeout of a single request, DefaultTimeout if not set
+	Timeout time.Duration
+	// Key signs requests if set, responses are then required to be signed with it too
+	Key *auth.Key
+
+	conn     io.ReadWriter
+	sequence uint16
+}
+
+// Dial connects to ntpd at host:port
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns client talking over conn
+func NewClient(conn io.ReadWriter) *Client {
+	return &Client{conn: conn}
+}
+
+// Close closes connection of the client if it can be closed
+func (c *Client) Close() error {
+	if closer, ok := c.conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Request sends the request and returns reassembled response
+func (c *Client) Request(op uint8, associationID uint16, data []byte) (*NTPControlMsg, error) {
+	c.sequence++
+	head := NTPControlMsgHead{
+		VnMode:        vnModeRequest,
+		REMOp:         op,
+		Sequence:      c.sequence,
+		AssociationID: associationID,
+		Count:         uint16(len(data)),
+	}
+	if conn, ok := c.conn.(net.Conn); ok {
+		timeout := c.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+	request := encode(&head, data)
+	if c.Key != nil {
+		request = Sign(c.Key, request)
+	}
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to send control request: %w", err)
+	}
+
+	fragments := map[uint16][]byte{}
+	var last *NTPControlMsgHead
+	response := make([]byte, 2048)
+	for {
+		n, err := c.conn.Read(response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read control response: %w", err)
+		}
+		if n < headSize {
+			continue
+		}
+		var h NTPControlMsgHead
+		if err := binary.Read(bytes.NewReader(response[:headSize]), binary.BigEndian, &h); err != nil {
+			return nil, err
+		}
+		if !h.IsResponse() || h.GetOperation() != op || h.Sequence != c.sequence || h.AssociationID != associationID {
+			continue
+		}
+		if h.HasError() {
+			code := h.Status >> 8
+			desc := "unknown"
+			if int(code) < len(ErrorDesc) {
+				desc = ErrorDesc[code]
+			}
+			return nil, fmt.Errorf("%w: %s", ErrResponse, desc)
+		}
+		if c.Key != nil {
+			if err := c.verify(response[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if headSize+int(h.Count) > n {
+			return nil, fmt.Errorf("control response fragment at offset %d is truncated", h.Offset)
+		}
+		fragments[h.Offset] = append([]byte(nil), response[headSize:headSize+int(h.Count)]...)
+		if !h.HasMore() {
+			last = &h
+		}
+		if last == nil {
+			continue
+		}
+		// response is complete once fragments cover it all without gaps
+		if data, ok := reassemble(fragments, last); ok {
+			last.Offset = 0
+			last.Count = uint16(len(data))
+			last.REMOp &^= 0x20
+			return &NTPControlMsg{NTPControlMsgHead: *last, Data: data}, nil
+		}
+	}
+}
+
+// verify checks response is signed with the key of the client
+func (c *Client) verify(b []byte) error {
+	msg, mac, err := SplitMAC(b)
+	if err != nil {
+		return err
+	}
+	if mac == nil {
+		return auth.ErrNoMAC
+	}
+	return c.Key.Verify(msg, mac)
+}
+
+// reassemble returns data of fragments in order of their offsets, if there are no gaps up to the end of the last one
+func reassemble(fragments map[uint16][]byte, last *NTPControlMsgHead) ([]byte, bool) {
+	end := int(last.Offset) + int(last.Count)
+	data := []byte{}
+	for len(data) < end {
+		fragment, ok := fragments[uint16(len(data))]
+		if !ok || len(fragment) == 0 {
+			return nil, false
+		}
+		data = append(data, fragment...)
+	}
+	return data, len(data) == end
+}
+
+// ReadStatus returns system status word and status words of all associations
+func (c *Client) ReadStatus() (*SystemStatusWord, map[uint16]*PeerStatusWord, error) {
+	msg, err := c.Request(readStatus, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	peers, err := msg.GetAssociations()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ReadSystemStatusWord(msg.Status), peers, nil
+}
+
+// ReadVariables returns variables of the association, system ones for association 0.
+// Only the named variables are requested if any are given
+func (c *Client) ReadVariables(associationID uint16, names ...string) (map[string]string, error) {
+	msg, err := c.Request(readVariables, associationID, []byte(strings.Join(names, ",")))
+	if err != nil {
+		return nil, err
+	}
+	return ParseVariables(msg.Data)
+}
+
+// RequestNonce returns nonce the server issued to the client
+func (c *Client) RequestNonce() (string, error) {
+	msg, err := c.Request(OpRequestNonce, 0, nil)
+	if err != nil {
+		return "", err
+	}
+	vars, err := ParseVariables(msg.Data)
+	if err != nil {
+		return "", err
+	}
+	nonce, ok := vars["nonce"]
+	if !ok {
+		return "", fmt.Errorf("no nonce in %q", msg.Data)
+	}
+	return nonce, nil
+}
+
+// WriteVariables sets variables of the association, system ones for association 0. The request is
+// accompanied by a fresh nonce, and servers only accept it if the client has Key they trust
+func (c *Client) WriteVariables(associationID uint16, vars map[string]string) error {
+	nonce, err := c.RequestNonce()
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+	data := "nonce=" + nonce + ", " + strings.TrimRight(string(FormatVariables(vars, nil)), "\r\n")
+	_, err = c.Request(OpWriteVariables, associationID, []byte(data))
+	return err
+}
+
+// ParseVariables decodes name=value list of control responses. Unlike NormalizeData it keeps commas
+// inside quoted values and variables without value, like flags ntpd reports
+func ParseVariables(data []byte) (map[string]string, error) {
+	result := map[string]string{}
+	s := string(bytes.TrimRight(data, "\x00"))
+	for len(s) > 0 {
+		// next comma outside of quotes ends the pair
+		end, quoted := len(s), false
+		for i := 0; i < len(s); i++ {
+			if s[i] == '"' {
+				quoted = !quoted
+			}
+			if s[i] == ',' && !quoted {
+				end = i
+				break
+			}
+		}
+		pair := strings.TrimSpace(s[:end])
+		if end < len(s) {
+			end++
+		}
+		s = s[end:]
+		if pair == "" {
+			continue
+		}
+		name, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name, value = strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		}
+		result[name] = strings.Trim(value, "\"")
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no variables in %q", data)
+	}
+	return result, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queueConn replies with queued packets and records requests
+type queueConn struct {
+	requests [][]byte
+	replies  [][]byte
+}
+
+func (c *queueConn) Read(p []byte) (int, error) {
+	if len(c.replies) == 0 {
+		return 0, errors.New("timeout")
+	}
+	n := copy(p, c.replies[0])
+	c.replies = c.replies[1:]
+	return n, nil
+}
+
+func (c *queueConn) Write(p []byte) (int, error) {
+	c.requests = append(c.requests, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func reply(h NTPControlMsgHead, data string) []byte {
+	h.VnMode = 0x16
+	h.Count = uint16(len(data))
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.BigEndian, h)
+	buf.WriteString(data)
+	return buf.Bytes()
+}
+
+func TestClientReadVariablesFragmented(t *testing.T) {
+	conn := &queueConn{replies: [][]byte{
+		// reply to some earlier request
+		reply(NTPControlMsgHead{REMOp: 0x82, Sequence: 7}, "stale=1"),
+		// last fragment arrives first, reading stops once the rest is in
+		reply(NTPControlMsgHead{REMOp: 0x82, Sequence: 1, AssociationID: 5, Offset: 19}, "refid=GPS"),
+		reply(NTPControlMsgHead{REMOp: 0xa2, Sequence: 1, AssociationID: 5, Offset: 0}, "leap=0, st"),
+		reply(NTPControlMsgHead{REMOp: 0xa2, Sequence: 1, AssociationID: 5, Offset: 10}, "ratum=2, "),
+		reply(NTPControlMsgHead{REMOp: 0x82, Sequence: 2, AssociationID: 5}, "next=1"),
+	}}
+	c := NewClient(conn)
+	vars, err := c.ReadVariables(5, "leap", "stratum", "refid")
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"leap": "0", "stratum": "2", "refid": "GPS"}, vars)
+	assert.Len(t, conn.replies, 1)
+
+	require.Len(t, conn.requests, 1)
+	request := conn.requests[0]
+	assert.Equal(t, []byte{0x16, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x12}, request[:12])
+	assert.Equal(t, "leap,stratum,refid\x00\x00", string(request[12:]))
+}
+
+func TestClientReadStatus(t *testing.T) {
+	conn := &queueConn{replies: [][]byte{
+		reply(NTPControlMsgHead{REMOp: 0x81, Sequence: 1, Status: 0x0615}, "\x9a\x1b\x96\x14\x9a\x1c\x90\x11"),
+	}}
+	c := NewClient(conn)
+	sys, peers, err := c.ReadStatus()
+	require.Nil(t, err)
+	assert.Equal(t, &SystemStatusWord{LI: 0, ClockSource: 6, SystemEventCounter: 1, SystemEventCode: 5}, sys)
+	require.Len(t, peers, 2)
+	assert.Equal(t, "sys.peer", PeerSelect[peers[0x9a1b].PeerSelection])
+	assert.True(t, peers[0x9a1c].PeerStatus.Reachable)
+}
+
+func TestClientErrorResponse(t *testing.T) {
+	conn := &queueConn{replies: [][]byte{
+		reply(NTPControlMsgHead{REMOp: 0xc2, Sequence: 1, AssociationID: 9, Status: 0x0400}, ""),
+	}}
+	c := NewClient(conn)
+	_, err := c.ReadVariables(9)
+	assert.ErrorIs(t, err, ErrResponse)
+	assert.Contains(t, err.Error(), "unknown association ID")
+}
+
+func TestClientTimeout(t *testing.T) {
+	conn := &queueConn{replies: [][]byte{
+		// the rest of the response never comes
+		reply(NTPControlMsgHead{REMOp: 0x82, Sequence: 1, Offset: 4}, "=0"),
+	}}
+	c := NewClient(conn)
+	_, err := c.ReadVariables(0)
+	assert.NotNil(t, err)
+}
+
+func TestParseVariables(t *testing.T) {
+	vars, err := ParseVariables([]byte("version=\"ntpd 4.2.8p15, built Mon Jun 1\",\r\nleap=0, authentic, refid=GPS\x00\x00"))
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"version":   "ntpd 4.2.8p15, built Mon Jun 1",
+		"leap":      "0",
+		"authentic": "",
+		"refid":     "GPS",
+	}, vars)
+	_, err = ParseVariables([]byte(" , "))
+	assert.NotNil(t, err)
+}
+
+func TestClientAuthenticated(t *testing.T) {
+	conn := &queueConn{replies: [][]byte{
+		Sign(testKey, reply(NTPControlMsgHead{REMOp: 0x8c, Sequence: 1}, "nonce=5f5e1000aabbccddeeff0011")),
+		Sign(testKey, reply(NTPControlMsgHead{REMOp: 0x83, Sequence: 2}, "")),
+	}}
+	c := NewClient(conn)
+	c.Key = testKey
+	require.Nil(t, c.WriteVariables(0, map[string]string{"leap": "1"}))
+	require.Len(t, conn.requests, 2)
+	msg, mac, err := SplitMAC(conn.requests[1])
+	require.Nil(t, err)
+	assert.Nil(t, testKey.Verify(msg, mac))
+	request, err := ParseRequest(msg)
+	require.Nil(t, err)
+	assert.Equal(t, OpWriteVariables, request.GetOperation())
+	assert.Equal(t, "nonce=5f5e1000aabbccddeeff0011, leap=1", string(request.Data))
+}
+
+func TestClientUnsignedResponse(t *testing.T) {
+	conn := &queueConn{replies: [][]byte{
+		reply(NTPControlMsgHead{REMOp: 0x82, Sequence: 1}, "stratum=1"),
+	}}
+	c := NewClient(conn)
+	c.Key = testKey
+	_, err := c.ReadVariables(0)
+	assert.ErrorIs(t, err, auth.ErrNoMAC)
+}
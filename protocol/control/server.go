@@ -0,0 +1,173 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Operations served
+const (
+	OpReadStatus    uint8 = readStatus
+	OpReadVariables uint8 = readVariables
+	// OpWriteVariables changes variables, so it is only served to authenticated clients
+	OpWriteVariables uint8 = 3
+	// OpReadOrderedList returns ordered list the request names, ntpq ifstats asks for list of interfaces
+	OpReadOrderedList uint8 = 11
+	// OpRequestNonce returns nonce the client passes back along with the next requests
+	OpRequestNonce uint8 = 12
+)
+
+// Error codes of responses, see ErrorDesc
+const (
+	ErrorCodePermission         uint16 = 1
+	ErrorCodeFormat             uint16 = 2
+	ErrorCodeOpcode             uint16 = 3
+	ErrorCodeUnknownAssociation uint16 = 4
+	ErrorCodeUnknownVariable    uint16 = 5
+	ErrorCodeBadValue           uint16 = 6
+	ErrorCodeRestricted         uint16 = 7
+)
+
+// MaxDataSize is the most data a single control message carries, longer responses are fragmented
+const MaxDataSize = 468
+
+// lineSize is how long lines of variables get before wrapping, same as ntpd
+const lineSize = 72
+
+// ParseRequest decodes control message the way it arrived
+func ParseRequest(b []byte) (*NTPControlMsg, error) {
+	if len(b) < headSize {
+		return nil, fmt.Errorf("control message of %d bytes is too short", len(b))
+	}
+	var h NTPControlMsgHead
+	if err := binary.Read(bytes.NewReader(b[:headSize]), binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+	if int(h.Count) > len(b)-headSize || h.Count > MaxDataSize {
+		return nil, fmt.Errorf("control message data of %d bytes doesn't fit", h.Count)
+	}
+	return &NTPControlMsg{NTPControlMsgHead: h, Data: b[headSize : headSize+int(h.Count)]}, nil
+}
+
+// FormatVariables encodes variables in ntpd format: name=value pairs separated with commas and wrapped into lines.
+// Only the named variables are encoded, in the given order, if names are given; all of them in name order otherwise
+func FormatVariables(vars map[string]string, names []string) []byte {
+	if len(names) == 0 {
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	var buf bytes.Buffer
+	line := 0
+	for i, name := range names {
+		pair := name
+		if value := vars[name]; value != "" {
+			if strings.ContainsAny(value, " ,=") {
+				value = "\"" + value + "\""
+			}
+			pair += "=" + value
+		}
+		if i > 0 {
+			if line+len(pair)+2 > lineSize {
+				buf.WriteString(",\r\n")
+				line = 0
+			} else {
+				buf.WriteString(", ")
+				line += 2
+			}
+		}
+		buf.WriteString(pair)
+		line += len(pair)
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// Responses returns the response to the request, fragmented if data doesn't fit into a single message
+func Responses(request *NTPControlMsgHead, status uint16, data []byte) [][]byte {
+	var responses [][]byte
+	for offset := 0; offset == 0 || offset < len(data); offset += MaxDataSize {
+		end := offset + MaxDataSize
+		if end > len(data) {
+			end = len(data)
+		}
+		h := NTPControlMsgHead{
+			VnMode:        request.VnMode,
+			REMOp:         0x80 | request.GetOperation(),
+			Sequence:      request.Sequence,
+			Status:        status,
+			AssociationID: request.AssociationID,
+			Offset:        uint16(offset),
+			Count:         uint16(end - offset),
+		}
+		if end < len(data) {
+			h.REMOp |= 0x20
+		}
+		responses = append(responses, encode(&h, data[offset:end]))
+	}
+	return responses
+}
+
+// ErrorResponse returns response telling the request failed with the code
+func ErrorResponse(request *NTPControlMsgHead, code uint16) []byte {
+	h := NTPControlMsgHead{
+		VnMode:        request.VnMode,
+		REMOp:         0xc0 | request.GetOperation(),
+		Sequence:      request.Sequence,
+		Status:        code << 8,
+		AssociationID: request.AssociationID,
+	}
+	return encode(&h, nil)
+}
+
+// encode returns message with data padded to 32 bit boundary
+func encode(h *NTPControlMsgHead, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.BigEndian, h)
+	buf.Write(data)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// Word returns system status word, the reverse of ReadSystemStatusWord
+func (s *SystemStatusWord) Word() uint16 {
+	return uint16(s.LI&0x3)<<14 | uint16(s.ClockSource&0x3f)<<8 | uint16(s.SystemEventCounter&0xf)<<4 | uint16(s.SystemEventCode&0xf)
+}
+
+// Byte returns peer status bits, the reverse of ReadPeerStatus
+func (p PeerStatus) Byte() uint8 {
+	var b uint8
+	for i, set := range []bool{p.Broadcast, p.Reachable, p.AuthEnabled, p.AuthOK, p.Configured} {
+		if set {
+			b |= 1 << i
+		}
+	}
+	return b
+}
+
+// Word returns peer status word, the reverse of ReadPeerStatusWord
+func (s *PeerStatusWord) Word() uint16 {
+	return uint16(s.PeerStatus.Byte()&0x1f)<<11 | uint16(s.PeerSelection&0x7)<<8 | uint16(s.PeerEventCounter&0xf)<<4 | uint16(s.PeerEventCode&0xf)
+}
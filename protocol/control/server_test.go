@@ -0,0 +1,104 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatVariables(t *testing.T) {
+	vars := map[string]string{"version": "ntpd 4.2.8p15, built", "leap": "0", "stratum": "2", "authentic": ""}
+	assert.Equal(t, "authentic, leap=0, stratum=2, version=\"ntpd 4.2.8p15, built\"\r\n", string(FormatVariables(vars, nil)))
+	assert.Equal(t, "stratum=2, leap=0\r\n", string(FormatVariables(vars, []string{"stratum", "leap"})))
+
+	long := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		long[name] = strings.Repeat(name, 10)
+	}
+	lines := strings.Split(string(FormatVariables(long, nil)), "\r\n")
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		assert.LessOrEqual(t, len(line), lineSize)
+	}
+	parsed, err := ParseVariables(FormatVariables(long, nil))
+	require.Nil(t, err)
+	assert.Equal(t, long, parsed)
+}
+
+func TestResponsesRoundTrip(t *testing.T) {
+	vars := map[string]string{}
+	for i := 0; i < 100; i++ {
+		vars[strings.Repeat("x", i%10+1)+string(rune('a'+i%26))+string(rune('a'+i/26))] = strings.Repeat("v", i%20)
+	}
+	data := FormatVariables(vars, nil)
+	request := &NTPControlMsgHead{VnMode: vnModeRequest, REMOp: readVariables, Sequence: 1, AssociationID: 7}
+	responses := Responses(request, 0x9614, data)
+	require.Equal(t, (len(data)+MaxDataSize-1)/MaxDataSize, len(responses))
+
+	// fragments arrive in reverse order
+	conn := &queueConn{}
+	for i := len(responses) - 1; i >= 0; i-- {
+		conn.replies = append(conn.replies, responses[i])
+	}
+	got, err := NewClient(conn).ReadVariables(7)
+	require.Nil(t, err)
+	assert.Equal(t, len(vars), len(got))
+	for name, value := range vars {
+		assert.Equal(t, value, got[name])
+	}
+}
+
+func TestResponsesEmpty(t *testing.T) {
+	responses := Responses(&NTPControlMsgHead{REMOp: readStatus}, 0x0615, nil)
+	require.Len(t, responses, 1)
+	msg, err := ParseRequest(responses[0])
+	require.Nil(t, err)
+	assert.True(t, msg.IsResponse())
+	assert.False(t, msg.HasMore())
+	assert.Equal(t, uint16(0x0615), msg.Status)
+}
+
+func TestErrorResponse(t *testing.T) {
+	msg, err := ParseRequest(ErrorResponse(&NTPControlMsgHead{REMOp: readVariables, Sequence: 9}, ErrorCodeUnknownVariable))
+	require.Nil(t, err)
+	assert.True(t, msg.HasError())
+	assert.Equal(t, uint16(9), msg.Sequence)
+	assert.Equal(t, "unknown variable name", ErrorDesc[msg.Status>>8])
+}
+
+func TestParseRequestTruncated(t *testing.T) {
+	_, err := ParseRequest([]byte{0x16, 0x02})
+	assert.NotNil(t, err)
+	_, err = ParseRequest([]byte{0x16, 0x02, 0, 1, 0, 0, 0, 0, 0, 0, 0, 8, 'a'})
+	assert.NotNil(t, err)
+}
+
+func TestSystemStatusWord(t *testing.T) {
+	for _, w := range []uint16{0x0615, 0xc000, 0x4fff} {
+		assert.Equal(t, w, ReadSystemStatusWord(w).Word())
+	}
+}
+
+func TestPeerStatusWord(t *testing.T) {
+	for _, w := range []uint16{0x961a, 0x9414, 0xffff, 0} {
+		assert.Equal(t, w, ReadPeerStatusWord(w).Word())
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"net"
+	"time"
+)
+
+// ReceivedPacket is an NTP packet along with its receive metadata
+type ReceivedPacket struct {
+	Packet  *Packet
+	RxTime  time.Time     // HW/kernel timestamp
+	RxMono  time.Duration // raw monotonic clock reading right after the packet was received
+	RemAddr net.Addr
+	Local   *PktInfo // destination, only set if EnablePktInfoSocket was called
+	// Extensions is everything following the header: extension fields and MAC
+	Extensions []byte
+}
+
+// readSinglePacket reads one packet as a batch of one
+func readSinglePacket(conn *net.UDPConn) ([]ReceivedPacket, error) {
+	p, err := ReadPacketWithTimestamps(conn)
+	if err != nil {
+		return nil, err
+	}
+	return []ReceivedPacket{*p}, nil
+}
+
+// extensions returns a copy of what follows the header in the message of n bytes buf starts with
+func extensions(buf []byte, n int) []byte {
+	if n <= PacketSizeBytes {
+		return nil
+	}
+	return append([]byte(nil), buf[PacketSizeBytes:n]...)
+}
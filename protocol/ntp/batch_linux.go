@@ -0,0 +1,192 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"unsafe"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// mmsghdr is struct mmsghdr from recvmmsg(2), x/sys/unix doesn't provide it
+type mmsghdr struct {
+	Hdr syscall.Msghdr
+	Len uint32
+}
+
+// recvmmsg is a thin wrapper around recvmmsg(2) syscall
+func recvmmsg(fd int, msgs []mmsghdr, flags int) (int, error) {
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return 0, errno
+		}
+		return int(n), nil
+	}
+}
+
+// sendmmsg is a thin wrapper around sendmmsg(2) syscall
+func sendmmsg(fd int, msgs []mmsghdr, flags int) (int, error) {
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return 0, errno
+		}
+		return int(n), nil
+	}
+}
+
+// fillSockaddr converts addr to raw socket address of IPv4 or IPv6 socket family
+func fillSockaddr(rsa *syscall.RawSockaddrAny, addr net.Addr, ipv4 bool) (uint32, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unsupported address type %T", addr)
+	}
+	if ipv4 {
+		ip4 := udpAddr.IP.To4()
+		if ip4 == nil {
+			return 0, fmt.Errorf("can't send to %v from IPv4 socket", udpAddr)
+		}
+		sa := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		*sa = syscall.RawSockaddrInet4{Family: syscall.AF_INET, Port: htons(udpAddr.Port)}
+		copy(sa.Addr[:], ip4)
+		return syscall.SizeofSockaddrInet4, nil
+	}
+	sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+	*sa = syscall.RawSockaddrInet6{Family: syscall.AF_INET6, Port: htons(udpAddr.Port)}
+	copy(sa.Addr[:], udpAddr.IP.To16())
+	return syscall.SizeofSockaddrInet6, nil
+}
+
+// batchBuffers are buffers of recvmmsg batch: every message gets its own packet buffer,
+// control buffer and remote address. Headers point into them, so they are set up once
+type batchBuffers struct {
+	oobSize int
+	bufs    []byte
+	oobs    []byte
+	names   []syscall.RawSockaddrAny
+	iovs    []syscall.Iovec
+	msgs    []mmsghdr
+}
+
+// batchPool reuses batch buffers, so servers don't allocate them for every batch
+var batchPool sync.Pool
+
+// getBatchBuffers returns buffers for batch of at least size messages, they have to be put back into batchPool
+func getBatchBuffers(size int) *batchBuffers {
+	if b, ok := batchPool.Get().(*batchBuffers); ok && len(b.msgs) >= size {
+		return b
+	}
+	return newBatchBuffers(size, ControlBufferSize(ControlTimestamp|ControlPktInfo))
+}
+
+// newBatchBuffers allocates buffers for size messages with oobSize bytes of control messages each
+func newBatchBuffers(size, oobSize int) *batchBuffers {
+	b := &batchBuffers{
+		oobSize: oobSize,
+		bufs:    make([]byte, size*MaxMessageSizeBytes),
+		oobs:    make([]byte, size*oobSize),
+		names:   make([]syscall.RawSockaddrAny, size),
+		iovs:    make([]syscall.Iovec, size),
+		msgs:    make([]mmsghdr, size),
+	}
+	for i := range b.msgs {
+		b.iovs[i].Base = &b.bufs[i*MaxMessageSizeBytes]
+		b.iovs[i].SetLen(MaxMessageSizeBytes)
+		b.msgs[i].Hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.msgs[i].Hdr.Iov = &b.iovs[i]
+		b.msgs[i].Hdr.SetIovlen(1)
+		b.msgs[i].Hdr.Control = &b.oobs[i*oobSize]
+	}
+	return b
+}
+
+// ReadPacketsWithKernelTimestamp reads a batch of up to batchSize packets, each with its own HW/kernel timestamp.
+// All available packets are received with a single recvmmsg call, it blocks only until the first one arrives.
+// Packets which control messages were truncated are skipped and reported with ErrControlTruncated
+// alongside the rest of the batch
+func ReadPacketsWithKernelTimestamp(conn *net.UDPConn, batchSize int) ([]ReceivedPacket, error) {
+	if batchSize <= 1 {
+		return readSinglePacket(conn)
+	}
+	// Get socket fd
+	connfd, err := connFd(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	b := getBatchBuffers(batchSize)
+	defer batchPool.Put(b)
+	oobSize, bufs, oobs, names := b.oobSize, b.bufs, b.oobs, b.names
+	msgs := b.msgs[:batchSize]
+	for i := range msgs {
+		// kernel overwrites lengths with what it received
+		msgs[i].Hdr.Namelen = syscall.SizeofSockaddrAny
+		msgs[i].Hdr.SetControllen(oobSize)
+		msgs[i].Hdr.Flags = 0
+		msgs[i].Len = 0
+	}
+
+	// MSG_WAITFORONE makes recvmmsg return as soon as there is at least one packet
+	n, err := recvmmsg(connfd, msgs, syscall.MSG_WAITFORONE)
+	if err != nil {
+		return nil, err
+	}
+	// In case there is no kernel timestamp, this is as close to the receive time as we can get in userspace
+	mono := MonotonicRaw()
+
+	packets := make([]ReceivedPacket, 0, n)
+	truncated := 0
+	for i := 0; i < n; i++ {
+		// Kernel silently drops control messages which don't fit, so timestamp may be missing
+		if msgs[i].Hdr.Flags&syscall.MSG_CTRUNC != 0 {
+			truncated++
+			continue
+		}
+		oob := oobs[i*oobSize : i*oobSize+int(msgs[i].Hdr.Controllen)]
+		rxTime, local, err := parseControlMessages(oob)
+		if err != nil {
+			return nil, err
+		}
+		buf := bufs[i*MaxMessageSizeBytes : (i+1)*MaxMessageSizeBytes]
+		packet, err := BytesToPacket(header(buf, int(msgs[i].Len)))
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, ReceivedPacket{
+			Packet:     packet,
+			RxTime:     fallbackRxTime(rxTime, mono),
+			RxMono:     mono,
+			RemAddr:    rawSockaddrToUDP(&names[i]),
+			Local:      local,
+			Extensions: extensions(buf, int(msgs[i].Len)),
+		})
+	}
+	if truncated > 0 {
+		return packets, fmt.Errorf("%d of %d packets dropped: %w", truncated, n, ErrControlTruncated)
+	}
+	return packets, nil
+}
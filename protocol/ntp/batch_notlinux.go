@@ -0,0 +1,29 @@
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"net"
+)
+
+// ReadPacketsWithKernelTimestamp reads a batch of up to batchSize packets, each with its own HW/kernel timestamp.
+// recvmmsg is Linux-specific, here we always read a single packet
+func ReadPacketsWithKernelTimestamp(conn *net.UDPConn, batchSize int) ([]ReceivedPacket, error) {
+	return readSinglePacket(conn)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"sync"
+)
+
+// buffers are packet and control buffers of a single read. Nothing read into them outlives the read:
+// packets are decoded, extensions and addresses copied
+type buffers struct {
+	buf []byte
+	oob []byte
+}
+
+// bufferPool reuses read buffers, so servers don't allocate them for every packet
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &buffers{
+			buf: make([]byte, MaxMessageSizeBytes),
+			oob: make([]byte, ControlBufferSize(ControlTimestamp|ControlPktInfo)),
+		}
+	},
+}
+
+// getBuffers returns read buffers, they have to be put back with putBuffers once read is done
+func getBuffers() *buffers {
+	return bufferPool.Get().(*buffers)
+}
+
+func putBuffers(b *buffers) {
+	bufferPool.Put(b)
+}
+
+// header returns the header of the message of n bytes buf starts with. Buffers being reused,
+// messages shorter than the header are padded with zeros rather than what previous ones left there
+func header(buf []byte, n int) []byte {
+	for i := n; i < PacketSizeBytes; i++ {
+		buf[i] = 0
+	}
+	return buf[:PacketSizeBytes]
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// ErrControlTruncated is returned when control messages didn't fit into the buffer (MSG_CTRUNC)
+var ErrControlTruncated = errors.New("control messages truncated")
+
+// ControlOption is a socket option which delivers control messages alongside received packets
+type ControlOption uint8
+
+// Socket options which deliver control messages
+const (
+	// ControlTimestamp is SO_TIMESTAMP/SO_TIMESTAMPNS, see EnableKernelTimestampsSocket
+	ControlTimestamp ControlOption = 1 << iota
+	// ControlPktInfo is IP_PKTINFO/IPV6_PKTINFO, see EnablePktInfoSocket
+	ControlPktInfo
+)
+
+// ControlBufferSize returns size of the buffer able to hold control messages of all enabled options
+func ControlBufferSize(opts ControlOption) int {
+	size := 0
+	if opts&ControlTimestamp != 0 {
+		// depending on the option and platform we get either timespec or timeval
+		tsSize := unsafe.Sizeof(syscall.Timespec{})
+		if tvSize := unsafe.Sizeof(syscall.Timeval{}); tvSize > tsSize {
+			tsSize = tvSize
+		}
+		size += syscall.CmsgSpace(int(tsSize))
+	}
+	if opts&ControlPktInfo != 0 {
+		// only one of them is delivered, IPv6 one is the largest on all platforms
+		size += syscall.CmsgSpace(syscall.SizeofInet6Pktinfo)
+	}
+	return size
+}
+
+// parseControlMessages extracts kernel timestamp and destination address from control messages
+func parseControlMessages(oob []byte) (rxTime time.Time, local *PktInfo, err error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("failed to parse control messages: %w", err)
+	}
+	for _, m := range msgs {
+		if ts, ok := timestampFromControlMessage(m); ok {
+			rxTime = ts
+			continue
+		}
+		if info, ok := pktInfoFromControlMessage(m); ok {
+			local = info
+		}
+	}
+	return rxTime, local, nil
+}
+
+// timevalFromControlMessage decodes SCM_TIMESTAMP control message
+func timevalFromControlMessage(m syscall.SocketControlMessage) (time.Time, bool) {
+	if m.Header.Level != syscall.SOL_SOCKET || m.Header.Type != syscall.SCM_TIMESTAMP {
+		return time.Time{}, false
+	}
+	if len(m.Data) < int(unsafe.Sizeof(syscall.Timeval{})) {
+		return time.Time{}, false
+	}
+	tv := (*syscall.Timeval)(unsafe.Pointer(&m.Data[0]))
+	return time.Unix(tv.Unix()), true
+}
+
+// newControlMessage builds a single control message of a given level and type
+func newControlMessage(level, typ int, data []byte) []byte {
+	b := make([]byte, syscall.CmsgSpace(len(data)))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(syscall.CmsgLen(len(data)))
+	copy(b[syscall.CmsgLen(0):], data)
+	return b
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MaxMessageSizeBytes is the largest NTP message we read: header followed by extension fields and MAC
+const MaxMessageSizeBytes = 2048
+
+// ExtensionHeaderSizeBytes is the size of extension field type and length
+const ExtensionHeaderSizeBytes = 4
+
+// Extension field types, see https://www.iana.org/assignments/ntp-parameters
+const (
+	ExtensionUniqueIdentifier     uint16 = 0x0104
+	ExtensionNTSCookie            uint16 = 0x0204
+	ExtensionNTSCookiePlaceholder uint16 = 0x0304
+	ExtensionNTSAuthenticator     uint16 = 0x0404
+)
+
+// RFC 7822 limits on extension field size: any field is at least 16 bytes,
+// the last one in a message without MAC at least 28, so it can't be mistaken for a MAC
+const (
+	MinExtensionFieldSizeBytes     = 16
+	MinLastExtensionFieldSizeBytes = 28
+)
+
+// UniqueIdentifierSizeBytes is the size of the Unique Identifier we generate, RFC 8915 requires at least 32
+const UniqueIdentifierSizeBytes = 32
+
+var (
+	// ErrExtensionTruncated is returned when extension field doesn't fit into the message
+	ErrExtensionTruncated = errors.New("extension field is truncated")
+	// ErrUniqueIdentifierMismatch is returned when the response doesn't echo request's Unique Identifier
+	ErrUniqueIdentifierMismatch = errors.New("unique identifier mismatch")
+)
+
+// ExtensionField is NTPv4 extension field, see RFC 7822.
+// Value of a parsed field includes padding
+type ExtensionField struct {
+	Type  uint16
+	Value []byte
+}
+
+// Bytes returns the field with its value padded to 4 bytes boundary and MinExtensionFieldSizeBytes
+func (e *ExtensionField) Bytes() []byte {
+	length := max(ExtensionHeaderSizeBytes+(len(e.Value)+3)&^3, MinExtensionFieldSizeBytes)
+	b := make([]byte, length)
+	binary.BigEndian.PutUint16(b[0:2], e.Type)
+	binary.BigEndian.PutUint16(b[2:4], uint16(length))
+	copy(b[ExtensionHeaderSizeBytes:], e.Value)
+	return b
+}
+
+// NextExtensionField parses the extension field b starts with and returns it along with its length.
+// Fields shorter than MinExtensionFieldSizeBytes are invalid, the same as auth.SplitMAC takes them for MAC
+func NextExtensionField(b []byte) (ExtensionField, int, error) {
+	if len(b) < ExtensionHeaderSizeBytes {
+		return ExtensionField{}, 0, ErrExtensionTruncated
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < MinExtensionFieldSizeBytes || length%4 != 0 {
+		return ExtensionField{}, 0, fmt.Errorf("invalid extension field length %d", length)
+	}
+	if length > len(b) {
+		return ExtensionField{}, 0, ErrExtensionTruncated
+	}
+	return ExtensionField{Type: binary.BigEndian.Uint16(b[0:2]), Value: b[ExtensionHeaderSizeBytes:length]}, length, nil
+}
+
+// ParseExtensionFields parses a sequence of extension fields which follows the header
+func ParseExtensionFields(b []byte) ([]ExtensionField, error) {
+	var fields []ExtensionField
+	for len(b) > 0 {
+		field, length, err := NextExtensionField(b)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+		b = b[length:]
+	}
+	return fields, nil
+}
+
+// NewUniqueIdentifier returns Unique Identifier extension field with a random value.
+// Server echoes it in the response, which matches the response to the request better than origin timestamp alone
+func NewUniqueIdentifier() (ExtensionField, error) {
+	uid := make([]byte, UniqueIdentifierSizeBytes)
+	if _, err := rand.Read(uid); err != nil {
+		return ExtensionField{}, err
+	}
+	return ExtensionField{Type: ExtensionUniqueIdentifier, Value: uid}, nil
+}
+
+// UniqueIdentifier returns the value of Unique Identifier extension field, nil if there is none
+func UniqueIdentifier(extensions []byte) ([]byte, error) {
+	fields, err := ParseExtensionFields(extensions)
+	if err != nil {
+		return nil, err
+	}
+	var uid []byte
+	for _, field := range fields {
+		if field.Type != ExtensionUniqueIdentifier {
+			continue
+		}
+		if uid != nil {
+			return nil, fmt.Errorf("duplicate unique identifier")
+		}
+		uid = field.Value
+	}
+	return uid, nil
+}
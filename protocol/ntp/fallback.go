@@ -0,0 +1,68 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"sync"
+	"time"
+)
+
+// correlationMaxAge is how long wall clock / raw monotonic clock correlation is used before it's refreshed.
+// Raw monotonic clock is not disciplined, so correlation can't be kept forever
+const correlationMaxAge = time.Second
+
+// clockCorrelation maps raw monotonic clock readings to wall clock.
+// It's used for userspace receive timestamps when kernel didn't provide one:
+// raw monotonic clock is read right after the syscall and converted to wall clock later,
+// so clock steps happening in between don't affect the timestamp
+type clockCorrelation struct {
+	sync.Mutex
+	wall time.Time
+	mono time.Duration
+}
+
+// fallbackCorrelation is shared by all the sockets
+var fallbackCorrelation clockCorrelation
+
+// refresh takes new snapshot of both clocks.
+// Wall clock is read between two monotonic readings, midpoint is used to reduce the error
+func (c *clockCorrelation) refresh() {
+	before := MonotonicRaw()
+	wall := time.Now()
+	after := MonotonicRaw()
+	c.wall = wall
+	c.mono = before + (after-before)/2
+}
+
+// wallTime converts raw monotonic reading to wall clock
+func (c *clockCorrelation) wallTime(mono time.Duration) time.Time {
+	c.Lock()
+	defer c.Unlock()
+	if c.wall.IsZero() || mono-c.mono > correlationMaxAge {
+		c.refresh()
+	}
+	// strip monotonic reading of time.Now, it's meaningless for the converted value
+	return c.wall.Round(0).Add(mono - c.mono)
+}
+
+// fallbackRxTime returns kernel timestamp if it's present, or userspace timestamp otherwise
+func fallbackRxTime(kernelTime time.Time, mono time.Duration) time.Time {
+	if !kernelTime.IsZero() {
+		return kernelTime
+	}
+	return fallbackCorrelation.wallTime(mono)
+}
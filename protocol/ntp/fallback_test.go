@@ -0,0 +1,77 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_clockCorrelationWallTime(t *testing.T) {
+	c := &clockCorrelation{}
+	now := time.Now()
+	wall := c.wallTime(MonotonicRaw())
+	assert.InDelta(t, now.UnixNano(), wall.UnixNano(), float64(time.Second), "converted time should be close to wall clock")
+}
+
+func Test_clockCorrelationNotAffectedBySteps(t *testing.T) {
+	// pretend correlation was taken just now, before the wall clock was stepped
+	mono := MonotonicRaw()
+	before := time.Unix(1585147599, 0)
+	c := &clockCorrelation{wall: before, mono: mono}
+
+	// converted value only depends on monotonic clock and the correlation
+	wall := c.wallTime(mono + 5*time.Millisecond)
+	assert.Equal(t, before.Add(5*time.Millisecond), wall)
+}
+
+func Test_clockCorrelationRefresh(t *testing.T) {
+	mono := MonotonicRaw()
+	c := &clockCorrelation{wall: time.Unix(1585147599, 0), mono: mono - 2*correlationMaxAge}
+
+	wall := c.wallTime(mono)
+	assert.InDelta(t, time.Now().UnixNano(), wall.UnixNano(), float64(time.Second), "stale correlation must be refreshed")
+	assert.Greater(t, int64(c.mono), int64(mono-correlationMaxAge))
+}
+
+func Test_fallbackRxTime(t *testing.T) {
+	kernelTime := time.Unix(1585147599, 631495778)
+	assert.Equal(t, kernelTime, fallbackRxTime(kernelTime, MonotonicRaw()))
+
+	wall := fallbackRxTime(time.Time{}, MonotonicRaw())
+	assert.InDelta(t, time.Now().UnixNano(), wall.UnixNano(), float64(time.Second))
+}
+
+func Test_ReadPacketWithKernelTimestampFallback(t *testing.T) {
+	// kernel timestamps are not enabled, but we still expect reasonable receive time
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+	_, err = cconn.Write(ntpRequestBytes)
+	assert.Nil(t, err)
+
+	_, rxTime, _, err := ReadPacketWithKernelTimestamp(conn)
+	assert.Nil(t, err)
+	assert.InDelta(t, time.Now().UnixNano(), rxTime.UnixNano(), float64(time.Second))
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// UnmarshalBinary decodes NTP header b starts with into the packet without allocating.
+// Extension fields and MAC following the header are left alone
+func (p *Packet) UnmarshalBinary(b []byte) error {
+	if len(b) < PacketSizeBytes {
+		return io.ErrUnexpectedEOF
+	}
+	p.Settings = b[0]
+	p.Stratum = b[1]
+	p.Poll = int8(b[2])
+	p.Precision = int8(b[3])
+	p.RootDelay = binary.BigEndian.Uint32(b[4:])
+	p.RootDispersion = binary.BigEndian.Uint32(b[8:])
+	p.ReferenceID = binary.BigEndian.Uint32(b[12:])
+	p.RefTimeSec = binary.BigEndian.Uint32(b[16:])
+	p.RefTimeFrac = binary.BigEndian.Uint32(b[20:])
+	p.OrigTimeSec = binary.BigEndian.Uint32(b[24:])
+	p.OrigTimeFrac = binary.BigEndian.Uint32(b[28:])
+	p.RxTimeSec = binary.BigEndian.Uint32(b[32:])
+	p.RxTimeFrac = binary.BigEndian.Uint32(b[36:])
+	p.TxTimeSec = binary.BigEndian.Uint32(b[40:])
+	p.TxTimeFrac = binary.BigEndian.Uint32(b[44:])
+	return nil
+}
+
+// ReadInto reads incoming NTP message into buf and decodes its header into p. Neither allocates, so receivers
+// reusing buf and p produce no garbage per packet. buf has to fit the header, and MaxMessageSizeBytes to keep
+// extension fields and MAC, which are buf[PacketSizeBytes:n] then. Messages shorter than the header are padded with zeros
+func ReadInto(conn *net.UDPConn, buf []byte, p *Packet) (n int, remAddr netip.AddrPort, err error) {
+	if len(buf) < PacketSizeBytes {
+		return 0, netip.AddrPort{}, io.ErrShortBuffer
+	}
+	n, remAddr, err = conn.ReadFromUDPAddrPort(buf)
+	if err != nil {
+		return 0, netip.AddrPort{}, err
+	}
+	return n, remAddr, p.UnmarshalBinary(header(buf, n))
+}
+
+// ReadIntoWithKernelTimestamp is ReadInto which reads control messages into oob as well, to report HW/kernel receive
+// timestamp. oob has to fit ControlBufferSize(ControlTimestamp). If kernel didn't provide a timestamp,
+// userspace one derived from raw monotonic clock is returned
+func ReadIntoWithKernelTimestamp(conn *net.UDPConn, buf, oob []byte, p *Packet) (n int, rxTime time.Time, remAddr netip.AddrPort, err error) {
+	if len(buf) < PacketSizeBytes {
+		return 0, time.Time{}, netip.AddrPort{}, io.ErrShortBuffer
+	}
+	n, oobn, flags, remAddr, err := conn.ReadMsgUDPAddrPort(buf, oob)
+	if err != nil {
+		return 0, time.Time{}, netip.AddrPort{}, err
+	}
+	// In case there is no kernel timestamp, this is as close to the receive time as we can get in userspace
+	mono := MonotonicRaw()
+	// Kernel silently drops control messages which don't fit, so timestamp may be missing
+	if flags&syscall.MSG_CTRUNC != 0 {
+		return 0, time.Time{}, netip.AddrPort{}, ErrControlTruncated
+	}
+	hwRxTime, err := rxTimestamp(oob[:oobn])
+	if err != nil {
+		return 0, time.Time{}, netip.AddrPort{}, err
+	}
+	return n, fallbackRxTime(hwRxTime, mono), remAddr, p.UnmarshalBinary(header(buf, n))
+}
+
+// rxTimestamp finds receive timestamp among control messages, walking them in place rather than parsing into a slice
+func rxTimestamp(oob []byte) (time.Time, error) {
+	for len(oob) > 0 {
+		h, data, rest, err := syscall.ParseOneSocketControlMessage(oob)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if ts, ok := timestampFromControlMessage(syscall.SocketControlMessage{Header: h, Data: data}); ok {
+			return ts, nil
+		}
+		oob = rest
+	}
+	return time.Time{}, nil
+}
@@ -19,6 +19,7 @@ package ntp
 import (
 	"net"
 	"time"
+	"unsafe"
 
 	syscall "golang.org/x/sys/unix"
 )
@@ -66,6 +67,13 @@ func CalculateOffset(currentRealTime, curentLocaTime time.Time) int64 {
 	return currentRealTime.UnixNano() - curentLocaTime.UnixNano()
 }
 
+// MonotonicReceiveTime returns client receive time derived from client transmit time and
+// raw monotonic clock readings taken at transmit and receive (see MonotonicRaw and ReceivedPacket.RxMono).
+// Unlike wall clock receive timestamp it's not affected by local clock steps or slewing during the exchange
+func MonotonicReceiveTime(clientTransmitTime time.Time, txMono, rxMono time.Duration) time.Time {
+	return clientTransmitTime.Add(rxMono - txMono)
+}
+
 // sockaddrToUDP converts syscall.Sockaddr to net.Addr
 func sockaddrToUDP(sa syscall.Sockaddr) net.Addr {
 	switch sa := sa.(type) {
@@ -77,6 +85,59 @@ func sockaddrToUDP(sa syscall.Sockaddr) net.Addr {
 	return nil
 }
 
+// rawSockaddrToUDP converts raw socket address filled by the kernel to net.Addr
+func rawSockaddrToUDP(rsa *syscall.RawSockaddrAny) net.Addr {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, pp.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: ntohs(pp.Port)}
+	case syscall.AF_INET6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, pp.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: ntohs(pp.Port)}
+	}
+	return nil
+}
+
+// ntohs converts port stored in network byte order
+func ntohs(port uint16) int {
+	p := (*[2]byte)(unsafe.Pointer(&port))
+	return int(p[0])<<8 | int(p[1])
+}
+
+// htons converts port to network byte order
+func htons(port int) uint16 {
+	var n uint16
+	p := (*[2]byte)(unsafe.Pointer(&n))
+	p[0], p[1] = byte(port>>8), byte(port)
+	return n
+}
+
+// isIPv4Socket returns true if socket is of AF_INET family.
+// Wildcard listeners are usually dual-stack AF_INET6 sockets
+func isIPv4Socket(connfd int) bool {
+	sa, err := syscall.Getsockname(connfd)
+	if err != nil {
+		return false
+	}
+	_, ok := sa.(*syscall.SockaddrInet4)
+	return ok
+}
+
+// SetReceiveTimeout limits how long reads of the connection block (SO_RCVTIMEO).
+// Read deadlines don't work once the socket is switched to blocking mode by connFd
+func SetReceiveTimeout(conn *net.UDPConn, timeout time.Duration) error {
+	connfd, err := connFd(conn)
+	if err != nil {
+		return err
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	return syscall.SetsockoptTimeval(connfd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+}
+
 // connFd returns file descriptor of a connection
 func connFd(conn *net.UDPConn) (int, error) {
 	connfd, err := conn.File()
@@ -17,6 +17,7 @@ limitations under the License.
 package ntp
 
 import (
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -276,6 +277,65 @@ func Test_ReadPacketWithKernelTimestamp(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func Test_ReadPacketWithKernelTimestampPktInfo(t *testing.T) {
+	// listen to incoming udp packets on all addresses
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// Allow reading of destination address via socket
+	err = EnablePktInfoSocket(conn)
+	assert.Nil(t, err)
+
+	// Send a client request to the specific address
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	cconn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	assert.Nil(t, err)
+	defer cconn.Close()
+	_, err = cconn.Write(ntpRequestBytes)
+	assert.Nil(t, err)
+
+	request, _, returnaddr, local, err := ReadPacketWithKernelTimestampPktInfo(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, ntpRequest, request, "We should have the same request arriving on the server")
+	assert.Equal(t, returnaddr, cconn.LocalAddr())
+	assert.NotNil(t, local)
+	assert.True(t, net.ParseIP("127.0.0.1").Equal(local.Addr), "destination must be the address client sent request to")
+}
+
+func Test_WritePacketWithPktInfo(t *testing.T) {
+	// listen to incoming udp packets on all addresses
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	err = EnablePktInfoSocket(conn)
+	assert.Nil(t, err)
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	cconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer cconn.Close()
+	_, err = cconn.WriteTo(ntpRequestBytes, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	assert.Nil(t, err)
+
+	_, _, returnaddr, local, err := ReadPacketWithKernelTimestampPktInfo(conn)
+	assert.Nil(t, err)
+
+	// Reply must come from the address request was sent to, not from the wildcard
+	_, err = WritePacketWithPktInfo(conn, ntpResponseBytes, returnaddr, local)
+	assert.Nil(t, err)
+
+	buf := make([]byte, PacketSizeBytes)
+	err = cconn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	_, from, err := cconn.ReadFromUDP(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, ntpResponseBytes, buf)
+	assert.True(t, net.ParseIP("127.0.0.1").Equal(from.IP))
+	assert.Equal(t, port, from.Port)
+}
+
 func Benchmark_PacketToBytesConversion(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_, _ = ntpResponse.Bytes()
@@ -352,3 +412,401 @@ func Benchmark_ServerWithHWTimestampsRead(b *testing.B) {
 		_, _, _, _ = ReadPacketWithKernelTimestamp(conn)
 	}
 }
+
+func Test_ControlBufferSize(t *testing.T) {
+	assert.Equal(t, 0, ControlBufferSize(0))
+	timestamp := ControlBufferSize(ControlTimestamp)
+	pktinfo := ControlBufferSize(ControlPktInfo)
+	assert.GreaterOrEqual(t, timestamp, ControlHeaderSizeBytes)
+	assert.Greater(t, pktinfo, 0)
+	assert.Equal(t, timestamp+pktinfo, ControlBufferSize(ControlTimestamp|ControlPktInfo))
+}
+
+func Test_ReadPacketWithKernelTimestampPktInfoIPv6(t *testing.T) {
+	// listen to incoming udp packets, both timestamps and pktinfo are enabled
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback, Port: 0})
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer conn.Close()
+
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+	err = EnablePktInfoSocket(conn)
+	assert.Nil(t, err)
+
+	cconn, err := net.DialUDP("udp6", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+	_, err = cconn.Write(ntpRequestBytes)
+	assert.Nil(t, err)
+
+	// buffer must fit all control messages, otherwise we'd get ErrControlTruncated
+	request, nowHWtimestamp, _, local, err := ReadPacketWithKernelTimestampPktInfo(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, ntpRequest, request)
+	assert.Equal(t, time.Now().Unix()/10, nowHWtimestamp.Unix()/10, "hwtimestamps should be within 10s")
+	assert.NotNil(t, local)
+	assert.True(t, net.IPv6loopback.Equal(local.Addr))
+}
+
+func Test_ReadPacketsWithKernelTimestamp(t *testing.T) {
+	// listen to incoming udp packets
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+	err = EnablePktInfoSocket(conn)
+	assert.Nil(t, err)
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+
+	// Send several client requests before reading
+	requests := 3
+	for i := 0; i < requests; i++ {
+		_, err = cconn.Write(ntpRequestBytes)
+		assert.Nil(t, err)
+	}
+
+	received := 0
+	for received < requests {
+		packets, err := ReadPacketsWithKernelTimestamp(conn, 8)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, packets)
+		for _, p := range packets {
+			assert.Equal(t, ntpRequest, p.Packet, "We should have the same request arriving on the server")
+			assert.Equal(t, time.Now().Unix()/10, p.RxTime.Unix()/10, "hwtimestamps should be within 10s")
+			assert.Equal(t, cconn.LocalAddr(), p.RemAddr)
+			assert.True(t, net.ParseIP("127.0.0.1").Equal(p.Local.Addr))
+		}
+		received += len(packets)
+	}
+	assert.Equal(t, requests, received)
+}
+
+func Test_MonotonicReceiveTime(t *testing.T) {
+	clientTransmitTime := time.Unix(usec, unsec)
+	txMono := 10 * time.Second
+	rxMono := txMono + forwardDelay + returnDelay
+
+	assert.Equal(t, clientTransmitTime.Add(forwardDelay+returnDelay), MonotonicReceiveTime(clientTransmitTime, txMono, rxMono))
+}
+
+func Test_ReadPacketWithTimestamps(t *testing.T) {
+	// listen to incoming udp packets
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+
+	txMono := MonotonicRaw()
+	_, err = cconn.Write(ntpRequestBytes)
+	assert.Nil(t, err)
+
+	received, err := ReadPacketWithTimestamps(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, ntpRequest, received.Packet, "We should have the same request arriving on the server")
+	assert.Equal(t, cconn.LocalAddr(), received.RemAddr)
+	assert.Equal(t, time.Now().Unix()/10, received.RxTime.Unix()/10, "hwtimestamps should be within 10s")
+	assert.GreaterOrEqual(t, int64(received.RxMono), int64(txMono), "monotonic receive time must be after transmit")
+	assert.LessOrEqual(t, int64(received.RxMono), int64(MonotonicRaw()))
+}
+
+func Test_ReadPacketWithTimestampsExtensions(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+
+	field := ExtensionField{Type: ExtensionUniqueIdentifier, Value: []byte("unique")}
+	_, err = cconn.Write(append(ntpRequestBytes, field.Bytes()...))
+	assert.Nil(t, err)
+
+	received, err := ReadPacketWithTimestamps(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, ntpRequest, received.Packet)
+	assert.Equal(t, field.Bytes(), received.Extensions)
+}
+
+func Test_ExtensionField(t *testing.T) {
+	field := ExtensionField{Type: ExtensionNTSCookie, Value: []byte{1, 2, 3, 4, 5}}
+	b := field.Bytes()
+	// short value is padded up to the minimal field size
+	assert.Equal(t, []byte{0x02, 0x04, 0x00, 0x10, 1, 2, 3, 4, 5, 0, 0, 0, 0, 0, 0, 0}, b)
+
+	other := ExtensionField{Type: ExtensionUniqueIdentifier, Value: []byte{6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21}}
+	fields, err := ParseExtensionFields(append(b, other.Bytes()...))
+	assert.Nil(t, err)
+	assert.Equal(t, []ExtensionField{
+		{Type: ExtensionNTSCookie, Value: []byte{1, 2, 3, 4, 5, 0, 0, 0, 0, 0, 0, 0}},
+		other,
+	}, fields)
+
+	_, err = ParseExtensionFields(b[:8])
+	assert.Equal(t, ErrExtensionTruncated, err)
+	_, err = ParseExtensionFields(b[:2])
+	assert.Equal(t, ErrExtensionTruncated, err)
+	// length has to be multiple of 4 and cover the header
+	_, err = ParseExtensionFields([]byte{0x01, 0x04, 0x00, 0x05, 0, 0, 0, 0})
+	assert.NotNil(t, err)
+	_, err = ParseExtensionFields([]byte{0x01, 0x04, 0x00, 0x00})
+	assert.NotNil(t, err)
+	// RFC 7822 fields are at least 16 bytes, as auth.SplitMAC has them
+	_, err = ParseExtensionFields([]byte{0x01, 0x04, 0x00, 0x0c, 0, 0, 0, 0, 0, 0, 0, 0})
+	assert.NotNil(t, err)
+}
+
+func Test_UniqueIdentifier(t *testing.T) {
+	uid, err := NewUniqueIdentifier()
+	assert.Nil(t, err)
+	assert.Equal(t, UniqueIdentifierSizeBytes, len(uid.Value))
+	other, err := NewUniqueIdentifier()
+	assert.Nil(t, err)
+	assert.NotEqual(t, uid.Value, other.Value)
+
+	cookie := ExtensionField{Type: ExtensionNTSCookie, Value: []byte{1, 2, 3, 4}}
+	value, err := UniqueIdentifier(append(cookie.Bytes(), uid.Bytes()...))
+	assert.Nil(t, err)
+	assert.Equal(t, uid.Value, value)
+
+	value, err = UniqueIdentifier(cookie.Bytes())
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	_, err = UniqueIdentifier(append(uid.Bytes(), other.Bytes()...))
+	assert.NotNil(t, err)
+}
+
+func Test_URing(t *testing.T) {
+	// listen to incoming udp packets
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+	err = EnablePktInfoSocket(conn)
+	assert.Nil(t, err)
+
+	ring, err := NewURing(conn, 8)
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	defer ring.Close()
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+	err = cconn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(t, err)
+
+	// More requests than receive buffers, so they have to be recycled
+	requests := 20
+	for i := 0; i < requests; i++ {
+		_, err = cconn.Write(ntpRequestBytes)
+		assert.Nil(t, err)
+
+		packets, err := ring.ReadPackets()
+		if err != nil {
+			t.Skipf("multishot recvmsg is not supported: %v", err)
+		}
+		assert.Len(t, packets, 1)
+		p := packets[0]
+		assert.Equal(t, ntpRequest, p.Packet, "We should have the same request arriving on the server")
+		assert.Equal(t, time.Now().Unix()/10, p.RxTime.Unix()/10, "hwtimestamps should be within 10s")
+		assert.Equal(t, cconn.LocalAddr(), p.RemAddr)
+		assert.True(t, net.ParseIP("127.0.0.1").Equal(p.Local.Addr))
+
+		err = ring.QueueWrite(ntpResponseBytes, p.RemAddr, p.Local)
+		assert.Nil(t, err)
+	}
+
+	// Without local address the response is sent from whatever address the kernel picks
+	err = ring.QueueWrite(ntpResponseBytes, cconn.LocalAddr(), &PktInfo{})
+	assert.Nil(t, err)
+
+	// Last responses are only queued so far
+	err = ring.Flush()
+	assert.Nil(t, err)
+	buf := make([]byte, PacketSizeBytes)
+	for i := 0; i <= requests; i++ {
+		n, err := cconn.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, ntpResponseBytes, buf[:n])
+	}
+	assert.Equal(t, 0, ring.WriteErrors())
+}
+
+func Test_Batch(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+	err = EnablePktInfoSocket(conn)
+	assert.Nil(t, err)
+
+	batch, err := NewBatch(conn, 4)
+	if err != nil {
+		t.Skipf("sendmmsg is not available: %v", err)
+	}
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+	err = cconn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(t, err)
+
+	requests := 3
+	for i := 0; i < requests; i++ {
+		_, err = cconn.Write(ntpRequestBytes)
+		assert.Nil(t, err)
+	}
+	var packets []ReceivedPacket
+	for len(packets) < requests {
+		batchPackets, err := batch.ReadPackets()
+		assert.Nil(t, err)
+		packets = append(packets, batchPackets...)
+	}
+	for _, p := range packets {
+		assert.Equal(t, ntpRequest, p.Packet, "We should have the same request arriving on the server")
+		assert.Equal(t, cconn.LocalAddr(), p.RemAddr)
+		assert.True(t, net.ParseIP("127.0.0.1").Equal(p.Local.Addr))
+		err = batch.QueueWrite(ntpResponseBytes, p.RemAddr, p.Local)
+		assert.Nil(t, err)
+	}
+	// More responses than the batch holds, the queue is sent once it's full
+	responses := requests + 4
+	for i := requests; i < responses; i++ {
+		err = batch.QueueWrite(ntpResponseBytes, cconn.LocalAddr(), nil)
+		assert.Nil(t, err)
+	}
+	err = batch.QueueWrite(ntpResponseBytes, &net.IPAddr{IP: net.ParseIP("127.0.0.1")}, nil)
+	assert.NotNil(t, err, "only UDP addresses are supported")
+
+	err = batch.Flush()
+	assert.Nil(t, err)
+	buf := make([]byte, PacketSizeBytes)
+	for i := 0; i < responses; i++ {
+		n, err := cconn.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, ntpResponseBytes, buf[:n])
+	}
+	assert.Equal(t, 0, batch.WriteErrors())
+}
+
+func Test_ReadPacketBuffersReused(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+
+	// request with an extension field, then a short one
+	ext := []byte{0x01, 0x04, 0x00, 0x08, 0xde, 0xad, 0xbe, 0xef}
+	_, err = cconn.Write(append(append([]byte(nil), ntpRequestBytes...), ext...))
+	assert.Nil(t, err)
+	_, err = cconn.Write(ntpRequestBytes[:8])
+	assert.Nil(t, err)
+
+	first, err := ReadPacketWithTimestamps(conn)
+	assert.Nil(t, err)
+	second, err := ReadPacketWithTimestamps(conn)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ext, first.Extensions, "extensions don't change once buffers are reused")
+	assert.Equal(t, ntpRequest, first.Packet)
+	assert.Equal(t, &Packet{Settings: 227, Poll: 3, Precision: -6, RootDelay: 65536}, second.Packet,
+		"short message is padded with zeros, not leftovers of the previous one")
+	assert.Nil(t, second.Extensions)
+}
+
+func Test_PacketUnmarshalBinary(t *testing.T) {
+	p := &Packet{}
+	assert.Nil(t, p.UnmarshalBinary(ntpResponseBytes))
+	assert.Equal(t, ntpResponse, p)
+	assert.Equal(t, io.ErrUnexpectedEOF, p.UnmarshalBinary(ntpResponseBytes[:47]))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = p.UnmarshalBinary(ntpRequestBytes)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func Test_ReadInto(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(t, err)
+	defer conn.Close()
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(t, err)
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer cconn.Close()
+	ext := []byte{0x01, 0x04, 0x00, 0x08, 0xde, 0xad, 0xbe, 0xef}
+	_, err = cconn.Write(append(append([]byte(nil), ntpRequestBytes...), ext...))
+	assert.Nil(t, err)
+	_, err = cconn.Write(ntpRequestBytes)
+	assert.Nil(t, err)
+
+	buf := make([]byte, MaxMessageSizeBytes)
+	p := &Packet{}
+	n, remAddr, err := ReadInto(conn, buf, p)
+	assert.Nil(t, err)
+	assert.Equal(t, ntpRequest, p)
+	assert.Equal(t, ext, buf[PacketSizeBytes:n])
+	assert.Equal(t, cconn.LocalAddr().String(), remAddr.String())
+
+	oob := make([]byte, ControlBufferSize(ControlTimestamp))
+	p = &Packet{}
+	n, rxTime, remAddr, err := ReadIntoWithKernelTimestamp(conn, buf, oob, p)
+	assert.Nil(t, err)
+	assert.Equal(t, PacketSizeBytes, n)
+	assert.Equal(t, ntpRequest, p)
+	assert.Equal(t, time.Now().Unix()/10, rxTime.Unix()/10, "hwtimestamps should be within 10s")
+	assert.Equal(t, cconn.LocalAddr().String(), remAddr.String())
+
+	_, _, err = ReadInto(conn, buf[:PacketSizeBytes-1], p)
+	assert.Equal(t, io.ErrShortBuffer, err)
+}
+
+func Benchmark_ServerReadInto(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	assert.Nil(b, err)
+	defer conn.Close()
+	err = EnableKernelTimestampsSocket(conn)
+	assert.Nil(b, err)
+
+	cconn, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	assert.Nil(b, err)
+	defer cconn.Close()
+
+	buf := make([]byte, MaxMessageSizeBytes)
+	oob := make([]byte, ControlBufferSize(ControlTimestamp))
+	p := &Packet{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = cconn.Write(ntpRequestBytes)
+		_, _, _, _ = ReadIntoWithKernelTimestamp(conn, buf, oob, p)
+	}
+}
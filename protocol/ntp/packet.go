@@ -19,9 +19,9 @@ package ntp
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"net"
 	"time"
-	"unsafe"
 
 	syscall "golang.org/x/sys/unix"
 )
@@ -30,8 +30,17 @@ import (
 const PacketSizeBytes = 48
 
 // ControlHeaderSizeBytes is a buffer to read packet header with Kernel/HW timestamps
+//
+// Deprecated: it's not enough once more socket options are enabled, use ControlBufferSize instead
 const ControlHeaderSizeBytes = 32
 
+// PktInfo describes the local end of the received packet:
+// destination address the packet was sent to and interface it arrived on
+type PktInfo struct {
+	Addr    net.IP
+	Ifindex int
+}
+
 // Packet is an NTPv4 packet
 /*
 http://seriot.ch/ntp.php
@@ -132,46 +141,99 @@ func (p *Packet) Bytes() ([]byte, error) {
 // BytesToPacket converts []bytes to Packet
 func BytesToPacket(ntpPacketBytes []byte) (*Packet, error) {
 	packet := &Packet{}
-	reader := bytes.NewReader(ntpPacketBytes)
-	err := binary.Read(reader, binary.BigEndian, packet)
+	err := packet.UnmarshalBinary(ntpPacketBytes)
 	return packet, err
 }
 
 // ReadNTPPacket reads incoming NTP packet
 func ReadNTPPacket(conn *net.UDPConn) (ntp *Packet, remAddr net.Addr, err error) {
-	buf := make([]byte, PacketSizeBytes)
-	_, remAddr, err = conn.ReadFromUDP(buf)
+	b := getBuffers()
+	defer putBuffers(b)
+	n, remAddr, err := conn.ReadFromUDP(b.buf[:PacketSizeBytes])
 	if err != nil {
 		return nil, nil, err
 	}
-	ntp, err = BytesToPacket(buf)
+	ntp, err = BytesToPacket(header(b.buf, n))
 
 	return ntp, remAddr, err
 }
 
 // ReadPacketWithKernelTimestamp reads HW/kernel timestamp from incoming packet
 func ReadPacketWithKernelTimestamp(conn *net.UDPConn) (ntp *Packet, hwRxTime time.Time, remAddr net.Addr, err error) {
+	ntp, hwRxTime, remAddr, _, err = ReadPacketWithKernelTimestampPktInfo(conn)
+	return ntp, hwRxTime, remAddr, err
+}
+
+// ReadPacketWithKernelTimestampPktInfo reads HW/kernel timestamp and destination address from incoming packet.
+// Destination is only reported if EnablePktInfoSocket was called on the connection, otherwise it's nil.
+// If kernel didn't provide a timestamp, userspace one derived from raw monotonic clock is returned
+func ReadPacketWithKernelTimestampPktInfo(conn *net.UDPConn) (ntp *Packet, hwRxTime time.Time, remAddr net.Addr, local *PktInfo, err error) {
+	p, err := ReadPacketWithTimestamps(conn)
+	if err != nil {
+		return nil, time.Time{}, nil, nil, err
+	}
+	return p.Packet, p.RxTime, p.RemAddr, p.Local, nil
+}
+
+// ReadPacketWithTimestamps reads incoming packet with both wall clock (HW/kernel) and raw monotonic receive timestamps.
+// Monotonic one allows to measure intervals which are not affected by local clock adjustments, see MonotonicReceiveTime.
+// Extension fields and MAC following the header are returned as well
+func ReadPacketWithTimestamps(conn *net.UDPConn) (*ReceivedPacket, error) {
 	// Get socket fd
 	connfd, err := connFd(conn)
 	if err != nil {
-		return nil, time.Time{}, nil, err
+		return nil, err
 	}
-	buf := make([]byte, PacketSizeBytes)
-	oob := make([]byte, ControlHeaderSizeBytes)
+	b := getBuffers()
+	defer putBuffers(b)
+	buf, oob := b.buf, b.oob
 
 	// Receive message + control struct from the socket
 	// https://linux.die.net/man/2/recvmsg
 	// This is a low-level way of getting the message (NTP packet content)
 	// Additionally we receive control headers, one of which is hwtimestamp
-	_, _, _, sa, err := syscall.Recvmsg(connfd, buf, oob, 0)
+	n, oobn, flags, sa, err := syscall.Recvmsg(connfd, buf, oob, 0)
 	if err != nil {
-		return nil, time.Time{}, nil, err
+		return nil, err
+	}
+	// In case there is no kernel timestamp, this is as close to the receive time as we can get in userspace
+	mono := MonotonicRaw()
+	// Kernel silently drops control messages which don't fit, so timestamp may be missing
+	if flags&syscall.MSG_CTRUNC != 0 {
+		return nil, ErrControlTruncated
 	}
-	// Extract hardware timestamp from control fields
-	ts := (*syscall.Timespec)(unsafe.Pointer(&oob[syscall.CmsgSpace(0)]))
-	hwRxTime = time.Unix(ts.Unix())
+	// Extract hardware timestamp and destination from control fields
+	hwRxTime, local, err := parseControlMessages(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+
+	packet, err := BytesToPacket(header(buf, n))
+	if err != nil {
+		return nil, err
+	}
+	return &ReceivedPacket{
+		Packet:     packet,
+		RxTime:     fallbackRxTime(hwRxTime, mono),
+		RxMono:     mono,
+		RemAddr:    sockaddrToUDP(sa),
+		Local:      local,
+		Extensions: extensions(buf, n),
+	}, nil
+}
 
-	packet, err := BytesToPacket(buf)
-	remAddr = sockaddrToUDP(sa)
-	return packet, hwRxTime, remAddr, err
+// WritePacketWithPktInfo sends the packet to addr using local address from PktInfo as a source.
+// This way multi-homed servers reply from the same address request arrived to.
+// If local is nil the kernel picks the source address
+func WritePacketWithPktInfo(conn *net.UDPConn, b []byte, addr net.Addr, local *PktInfo) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unsupported address type %T", addr)
+	}
+	var oob []byte
+	if local != nil && local.Addr != nil {
+		oob = pktInfoControlMessage(local)
+	}
+	n, _, err := conn.WriteMsgUDP(b, oob, udpAddr)
+	return n, err
 }
@@ -19,6 +19,8 @@ package ntp
 import (
 	"fmt"
 	"net"
+	"time"
+	"unsafe"
 
 	syscall "golang.org/x/sys/unix"
 )
@@ -37,3 +39,71 @@ func EnableKernelTimestampsSocket(conn *net.UDPConn) error {
 	}
 	return nil
 }
+
+// EnablePktInfoSocket enables socket options to read destination address and interface of incoming packets
+func EnablePktInfoSocket(conn *net.UDPConn) error {
+	// Get socket fd
+	connfd, err := connFd(conn)
+	if err != nil {
+		return err
+	}
+
+	if isIPv4Socket(connfd) {
+		if err := syscall.SetsockoptInt(connfd, syscall.IPPROTO_IP, syscall.IP_RECVDSTADDR, 1); err != nil {
+			return fmt.Errorf("failed to enable IP_RECVDSTADDR: %w", err)
+		}
+		return nil
+	}
+	if err := syscall.SetsockoptInt(connfd, syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1); err != nil {
+		return fmt.Errorf("failed to enable IPV6_RECVPKTINFO: %w", err)
+	}
+	return nil
+}
+
+// MonotonicRaw returns CLOCK_MONOTONIC reading. FreeBSD has no raw monotonic clock,
+// monotonic one is not affected by clock steps which is what we need
+func MonotonicRaw() time.Duration {
+	var ts syscall.Timespec
+	// clock_gettime never fails for valid clock id
+	_ = syscall.ClockGettime(syscall.CLOCK_MONOTONIC, &ts)
+	return time.Duration(ts.Nano())
+}
+
+// timestampFromControlMessage decodes SCM_TIMESTAMP control message
+func timestampFromControlMessage(m syscall.SocketControlMessage) (time.Time, bool) {
+	return timevalFromControlMessage(m)
+}
+
+// pktInfoFromControlMessage decodes IP_RECVDSTADDR or IPV6_PKTINFO control message
+func pktInfoFromControlMessage(m syscall.SocketControlMessage) (*PktInfo, bool) {
+	switch {
+	case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVDSTADDR:
+		if len(m.Data) < net.IPv4len {
+			return nil, false
+		}
+		// FreeBSD doesn't report interface for IPv4, only the address
+		addr := make(net.IP, net.IPv4len)
+		copy(addr, m.Data[:net.IPv4len])
+		return &PktInfo{Addr: addr}, true
+	case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_PKTINFO:
+		if len(m.Data) < syscall.SizeofInet6Pktinfo {
+			return nil, false
+		}
+		info := (*syscall.Inet6Pktinfo)(unsafe.Pointer(&m.Data[0]))
+		addr := make(net.IP, net.IPv6len)
+		copy(addr, info.Addr[:])
+		return &PktInfo{Addr: addr, Ifindex: int(info.Ifindex)}, true
+	}
+	return nil, false
+}
+
+// pktInfoControlMessage builds control message to set source address of outgoing packet
+func pktInfoControlMessage(local *PktInfo) []byte {
+	if ip4 := local.Addr.To4(); ip4 != nil && len(local.Addr) == net.IPv4len {
+		return newControlMessage(syscall.IPPROTO_IP, syscall.IP_SENDSRCADDR, ip4)
+	}
+	info := syscall.Inet6Pktinfo{Ifindex: uint32(local.Ifindex)}
+	copy(info.Addr[:], local.Addr.To16())
+	data := (*[syscall.SizeofInet6Pktinfo]byte)(unsafe.Pointer(&info))
+	return newControlMessage(syscall.IPPROTO_IPV6, syscall.IPV6_PKTINFO, data[:])
+}
@@ -19,6 +19,8 @@ package ntp
 import (
 	"fmt"
 	"net"
+	"time"
+	"unsafe"
 
 	syscall "golang.org/x/sys/unix"
 )
@@ -40,3 +42,81 @@ func EnableKernelTimestampsSocket(conn *net.UDPConn) error {
 	}
 	return nil
 }
+
+// EnablePktInfoSocket enables socket options to read destination address and interface of incoming packets
+func EnablePktInfoSocket(conn *net.UDPConn) error {
+	// Get socket fd
+	connfd, err := connFd(conn)
+	if err != nil {
+		return err
+	}
+
+	if isIPv4Socket(connfd) {
+		if err := syscall.SetsockoptInt(connfd, syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1); err != nil {
+			return fmt.Errorf("failed to enable IP_PKTINFO: %w", err)
+		}
+		return nil
+	}
+	// IPv4-mapped addresses on dual-stack sockets are reported via IPV6_PKTINFO as well
+	if err := syscall.SetsockoptInt(connfd, syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1); err != nil {
+		return fmt.Errorf("failed to enable IPV6_RECVPKTINFO: %w", err)
+	}
+	return nil
+}
+
+// MonotonicRaw returns CLOCK_MONOTONIC_RAW reading, which is not affected by clock steps or slewing
+func MonotonicRaw() time.Duration {
+	var ts syscall.Timespec
+	// clock_gettime never fails for valid clock id
+	_ = syscall.ClockGettime(syscall.CLOCK_MONOTONIC_RAW, &ts)
+	return time.Duration(ts.Nano())
+}
+
+// timestampFromControlMessage decodes SCM_TIMESTAMPNS or SCM_TIMESTAMP control message
+func timestampFromControlMessage(m syscall.SocketControlMessage) (time.Time, bool) {
+	if m.Header.Level != syscall.SOL_SOCKET || m.Header.Type != syscall.SCM_TIMESTAMPNS {
+		return timevalFromControlMessage(m)
+	}
+	if len(m.Data) < int(unsafe.Sizeof(syscall.Timespec{})) {
+		return time.Time{}, false
+	}
+	ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+	return time.Unix(ts.Unix()), true
+}
+
+// pktInfoFromControlMessage decodes IP_PKTINFO or IPV6_PKTINFO control message
+func pktInfoFromControlMessage(m syscall.SocketControlMessage) (*PktInfo, bool) {
+	switch {
+	case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_PKTINFO:
+		if len(m.Data) < syscall.SizeofInet4Pktinfo {
+			return nil, false
+		}
+		info := (*syscall.Inet4Pktinfo)(unsafe.Pointer(&m.Data[0]))
+		addr := make(net.IP, net.IPv4len)
+		copy(addr, info.Addr[:])
+		return &PktInfo{Addr: addr, Ifindex: int(info.Ifindex)}, true
+	case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_PKTINFO:
+		if len(m.Data) < syscall.SizeofInet6Pktinfo {
+			return nil, false
+		}
+		info := (*syscall.Inet6Pktinfo)(unsafe.Pointer(&m.Data[0]))
+		addr := make(net.IP, net.IPv6len)
+		copy(addr, info.Addr[:])
+		return &PktInfo{Addr: addr, Ifindex: int(info.Ifindex)}, true
+	}
+	return nil, false
+}
+
+// pktInfoControlMessage builds IP_PKTINFO or IPV6_PKTINFO control message to set source address of outgoing packet
+func pktInfoControlMessage(local *PktInfo) []byte {
+	if ip4 := local.Addr.To4(); ip4 != nil && len(local.Addr) == net.IPv4len {
+		info := syscall.Inet4Pktinfo{Ifindex: int32(local.Ifindex)}
+		copy(info.Spec_dst[:], ip4)
+		data := (*[syscall.SizeofInet4Pktinfo]byte)(unsafe.Pointer(&info))
+		return newControlMessage(syscall.IPPROTO_IP, syscall.IP_PKTINFO, data[:])
+	}
+	info := syscall.Inet6Pktinfo{Ifindex: uint32(local.Ifindex)}
+	copy(info.Addr[:], local.Addr.To16())
+	data := (*[syscall.SizeofInet6Pktinfo]byte)(unsafe.Pointer(&info))
+	return newControlMessage(syscall.IPPROTO_IPV6, syscall.IPV6_PKTINFO, data[:])
+}
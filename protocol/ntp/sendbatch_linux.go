@@ -0,0 +1,133 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// Batch is a recvmmsg/sendmmsg based datapath for a single UDP socket.
+// Requests are received in batches like with ReadPacketsWithKernelTimestamp, and responses are queued
+// and sent before the next batch is read, so every receive batch is answered with a single sendmmsg call
+// instead of a syscall per packet.
+// Batch is not safe for concurrent use.
+type Batch struct {
+	conn *net.UDPConn
+	fd   int
+	ipv4 bool
+	size int
+
+	// send buffers are set up once, queued messages are the first queued of them
+	send        *batchBuffers
+	queued      int
+	writeErrors int
+}
+
+// NewBatch sets up a datapath for conn, reading and sending up to size packets per syscall
+func NewBatch(conn *net.UDPConn, size int) (*Batch, error) {
+	if size < 1 {
+		size = 1
+	}
+	b := &Batch{conn: conn, fd: -1, size: size}
+	// Use fd owned by conn, it stays valid as long as conn is open
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := rawConn.Control(func(fd uintptr) { b.fd = int(fd) }); err != nil {
+		return nil, err
+	}
+	b.ipv4 = isIPv4Socket(b.fd)
+	b.send = newBatchBuffers(size, ControlBufferSize(ControlPktInfo))
+	return b, nil
+}
+
+// ReadPackets sends queued responses and reads a batch of requests,
+// each comes with its own HW/kernel timestamp and destination address like with ReadPacketsWithKernelTimestamp
+func (b *Batch) ReadPackets() ([]ReceivedPacket, error) {
+	if err := b.Flush(); err != nil {
+		return nil, err
+	}
+	return ReadPacketsWithKernelTimestamp(b.conn, b.size)
+}
+
+// QueueWrite queues response p to addr sent from local address if it's not nil.
+// p is copied, so the caller can reuse it right away. Queued responses are sent with the next
+// ReadPackets or Flush call, or once the queue is full. Failures are counted by WriteErrors
+func (b *Batch) QueueWrite(p []byte, addr net.Addr, local *PktInfo) error {
+	if len(p) > MaxMessageSizeBytes {
+		return fmt.Errorf("response of %d bytes exceeds %d bytes limit", len(p), MaxMessageSizeBytes)
+	}
+	if b.queued == len(b.send.msgs) {
+		if err := b.Flush(); err != nil {
+			return err
+		}
+	}
+	i := b.queued
+	msg := &b.send.msgs[i]
+	namelen, err := fillSockaddr(&b.send.names[i], addr, b.ipv4)
+	if err != nil {
+		return err
+	}
+	msg.Hdr.Namelen = namelen
+	copy(b.send.bufs[i*MaxMessageSizeBytes:], p)
+	b.send.iovs[i].SetLen(len(p))
+	msg.Hdr.Flags = 0
+	msg.Len = 0
+	if local != nil && local.Addr != nil {
+		oob := b.send.oobs[i*b.send.oobSize : (i+1)*b.send.oobSize]
+		msg.Hdr.Control = &oob[0]
+		msg.Hdr.SetControllen(copy(oob, pktInfoControlMessage(local)))
+	} else {
+		msg.Hdr.Control = nil
+		msg.Hdr.SetControllen(0)
+	}
+	b.queued++
+	return nil
+}
+
+// Flush sends queued responses with as few sendmmsg calls as possible
+func (b *Batch) Flush() error {
+	sent := 0
+	for sent < b.queued {
+		n, err := sendmmsg(b.fd, b.send.msgs[sent:b.queued], 0)
+		if errors.Is(err, syscall.EBADF) {
+			b.writeErrors += b.queued - sent
+			b.queued = 0
+			return err
+		}
+		if err != nil {
+			// sendmmsg only fails if the very first message can't be sent, skip it and carry on with the rest
+			b.writeErrors++
+			n = 1
+		}
+		sent += n
+	}
+	b.queued = 0
+	return nil
+}
+
+// WriteErrors returns the number of responses which failed to send since the previous call
+func (b *Batch) WriteErrors() int {
+	n := b.writeErrors
+	b.writeErrors = 0
+	return n
+}
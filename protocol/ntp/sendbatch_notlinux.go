@@ -0,0 +1,55 @@
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"errors"
+	"net"
+)
+
+var errBatchNotSupported = errors.New("sendmmsg is only supported on Linux")
+
+// Batch is a recvmmsg/sendmmsg based datapath for a single UDP socket.
+// Both are Linux-specific, here it's never available
+type Batch struct{}
+
+// NewBatch always fails, caller is expected to fall back to ReadPacketsWithKernelTimestamp
+func NewBatch(conn *net.UDPConn, size int) (*Batch, error) {
+	return nil, errBatchNotSupported
+}
+
+// ReadPackets always fails
+func (b *Batch) ReadPackets() ([]ReceivedPacket, error) {
+	return nil, errBatchNotSupported
+}
+
+// QueueWrite always fails
+func (b *Batch) QueueWrite(p []byte, addr net.Addr, local *PktInfo) error {
+	return errBatchNotSupported
+}
+
+// Flush always fails
+func (b *Batch) Flush() error {
+	return errBatchNotSupported
+}
+
+// WriteErrors always returns 0
+func (b *Batch) WriteErrors() int {
+	return 0
+}
@@ -0,0 +1,590 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// io_uring ABI bits from linux/io_uring.h, x/sys/unix doesn't provide them
+const (
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringOpSendmsg         = 9
+	uringOpRecvmsg         = 10
+	uringOpProvideBuffers  = 31
+	uringSQEBufferSelect   = 1 << 5
+	uringRecvMultishot     = 1 << 1
+	uringEnterGetEvents    = 1 << 0
+	uringCQEFBuffer        = 1 << 0
+	uringCQEFMore          = 1 << 1
+	uringCQEBufferShift    = 16
+	uringBufferGroup       = 0
+	uringDefaultEntries    = 256
+	uringMaxResponseLength = MaxMessageSizeBytes
+)
+
+// user_data of every submission carries the kind of operation in upper half and send slot in lower one
+const (
+	uringTagRecv uint64 = (iota + 1) << 32
+	uringTagProvide
+	uringTagSend
+	uringTagMask uint64 = 0xffffffff << 32
+)
+
+// uringSQOffsets is struct io_sqring_offsets
+type uringSQOffsets struct {
+	Head        uint32
+	Tail        uint32
+	RingMask    uint32
+	RingEntries uint32
+	Flags       uint32
+	Dropped     uint32
+	Array       uint32
+	Resv1       uint32
+	UserAddr    uint64
+}
+
+// uringCQOffsets is struct io_cqring_offsets
+type uringCQOffsets struct {
+	Head        uint32
+	Tail        uint32
+	RingMask    uint32
+	RingEntries uint32
+	Overflow    uint32
+	CQEs        uint32
+	Flags       uint32
+	Resv1       uint32
+	UserAddr    uint64
+}
+
+// uringParams is struct io_uring_params
+type uringParams struct {
+	SQEntries    uint32
+	CQEntries    uint32
+	Flags        uint32
+	SQThreadCPU  uint32
+	SQThreadIdle uint32
+	Features     uint32
+	WQFd         uint32
+	Resv         [3]uint32
+	SQOff        uringSQOffsets
+	CQOff        uringCQOffsets
+}
+
+// uringSQE is struct io_uring_sqe
+type uringSQE struct {
+	Opcode      uint8
+	Flags       uint8
+	Ioprio      uint16
+	Fd          int32
+	Off         uint64
+	Addr        uint64
+	Len         uint32
+	OpFlags     uint32
+	UserData    uint64
+	BufGroup    uint16
+	Personality uint16
+	SpliceFdIn  int32
+	Addr3       uint64
+	_           uint64
+}
+
+// uringCQE is struct io_uring_cqe
+type uringCQE struct {
+	UserData uint64
+	Res      int32
+	Flags    uint32
+}
+
+// uringRecvmsgOut is struct io_uring_recvmsg_out which precedes every packet received by multishot recvmsg
+type uringRecvmsgOut struct {
+	Namelen    uint32
+	Controllen uint32
+	Payloadlen uint32
+	Flags      uint32
+}
+
+// uringSendSlot holds everything sendmsg needs while the send is in flight.
+// Control message and response bytes follow it in the same slot
+type uringSendSlot struct {
+	Hdr  syscall.Msghdr
+	Iov  syscall.Iovec
+	Name syscall.RawSockaddrAny
+}
+
+// URing is an io_uring based datapath for a single UDP socket.
+// Requests are received by one multishot recvmsg into kernel-selected buffers
+// and responses are queued as sendmsg submissions, so a busy server makes
+// a single io_uring_enter call per batch instead of a syscall per packet.
+// Sends are not linked with IOSQE_IO_LINK: a failed send would cancel the rest of the chain,
+// so one unreachable client would cost responses to everyone else in the batch,
+// and responses to different clients need no ordering anyway.
+// URing is not safe for concurrent use.
+type URing struct {
+	conn *net.UDPConn
+	fd   int
+	ipv4 bool
+
+	ringFd int
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+
+	sqHead    *uint32
+	sqTail    *uint32
+	sqMask    uint32
+	sqEntries uint32
+	cqHead    *uint32
+	cqTail    *uint32
+	cqMask    uint32
+	cqes      uintptr
+
+	// local copy of the SQ tail and how much of it kernel has consumed
+	tail      uint32
+	submitted uint32
+
+	// mem holds recvmsg template, provided buffers and send slots.
+	// It's mmapped so GC never has to care about memory kernel writes to
+	mem        []byte
+	oobSize    int
+	bufSize    int
+	bufs       int
+	bufsOffset int
+	slotSize   int
+	slotsOff   int
+	freeSlots  []uint32
+	// unprovided are receive buffers which couldn't be handed back to the kernel yet
+	unprovided []int
+
+	recvArmed   bool
+	received    bool
+	backlog     []ReceivedPacket
+	truncated   int
+	writeErrors int
+}
+
+// NewURing sets up io_uring for conn with a given number of submission entries,
+// which is also the number of receive buffers and in-flight responses.
+// It returns an error if io_uring is unavailable, caller is expected to fall back to ReadPacketsWithKernelTimestamp
+func NewURing(conn *net.UDPConn, entries int) (*URing, error) {
+	if entries <= 0 {
+		entries = uringDefaultEntries
+	}
+	r := &URing{conn: conn, fd: -1, ringFd: -1}
+	// Use fd owned by conn, it stays valid as long as conn is open
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := rawConn.Control(func(fd uintptr) { r.fd = int(fd) }); err != nil {
+		return nil, err
+	}
+	r.ipv4 = isIPv4Socket(r.fd)
+
+	if err := r.setup(uint32(entries)); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if err := r.allocate(int(r.sqEntries)); err != nil {
+		r.Close()
+		return nil, err
+	}
+	// Hand all the buffers to the kernel and start receiving
+	r.prepProvideBuffers(0, r.bufs)
+	r.prepRecv()
+	if err := r.enter(0); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// setup creates the ring and maps its queues
+func (r *URing) setup(entries uint32) error {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(syscall.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r.ringFd = int(fd)
+
+	var err error
+	sqSize := int(p.SQOff.Array + p.SQEntries*4)
+	if r.sqRing, err = syscall.Mmap(r.ringFd, uringOffSQRing, sqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		return fmt.Errorf("failed to map submission queue: %w", err)
+	}
+	cqSize := int(p.CQOff.CQEs) + int(p.CQEntries)*int(unsafe.Sizeof(uringCQE{}))
+	if r.cqRing, err = syscall.Mmap(r.ringFd, uringOffCQRing, cqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		return fmt.Errorf("failed to map completion queue: %w", err)
+	}
+	sqesSize := int(p.SQEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if r.sqes, err = syscall.Mmap(r.ringFd, uringOffSQEs, sqesSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		return fmt.Errorf("failed to map submission entries: %w", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.SQOff.Head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.SQOff.Tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.SQOff.RingMask]))
+	r.sqEntries = p.SQEntries
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.CQOff.Head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.CQOff.Tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.CQOff.RingMask]))
+	r.cqes = uintptr(p.CQOff.CQEs)
+	r.tail = atomic.LoadUint32(r.sqTail)
+	r.submitted = r.tail
+
+	// Submission queue is indirect, map every slot to the entry with the same index once and for all
+	for i := uint32(0); i < p.SQEntries; i++ {
+		*(*uint32)(unsafe.Pointer(&r.sqRing[p.SQOff.Array+i*4])) = i
+	}
+	return nil
+}
+
+// allocate maps memory for recvmsg template, receive buffers and send slots
+func (r *URing) allocate(n int) error {
+	msghdrSize := int(unsafe.Sizeof(syscall.Msghdr{}))
+	r.oobSize = ControlBufferSize(ControlTimestamp | ControlPktInfo)
+	r.bufSize = align8(int(unsafe.Sizeof(uringRecvmsgOut{})) + syscall.SizeofSockaddrAny + r.oobSize + MaxMessageSizeBytes)
+	r.bufs = n
+	r.bufsOffset = align8(msghdrSize)
+	r.slotSize = align8(int(unsafe.Sizeof(uringSendSlot{})) + ControlBufferSize(ControlPktInfo) + uringMaxResponseLength)
+	r.slotsOff = r.bufsOffset + r.bufs*r.bufSize
+
+	var err error
+	size := r.slotsOff + n*r.slotSize
+	if r.mem, err = syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS); err != nil {
+		return fmt.Errorf("failed to allocate buffers: %w", err)
+	}
+
+	// multishot recvmsg uses msghdr only as a template: name and control get reserved at the start of every buffer
+	hdr := (*syscall.Msghdr)(unsafe.Pointer(&r.mem[0]))
+	hdr.Namelen = syscall.SizeofSockaddrAny
+	hdr.SetControllen(r.oobSize)
+
+	r.freeSlots = make([]uint32, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		r.freeSlots = append(r.freeSlots, uint32(i))
+	}
+	return nil
+}
+
+// Close tears down the ring. Connection itself is left open
+func (r *URing) Close() error {
+	var err error
+	if r.ringFd >= 0 {
+		err = syscall.Close(r.ringFd)
+		r.ringFd = -1
+	}
+	for _, m := range [][]byte{r.sqes, r.cqRing, r.sqRing, r.mem} {
+		if m != nil {
+			_ = syscall.Munmap(m)
+		}
+	}
+	r.sqes, r.cqRing, r.sqRing, r.mem = nil, nil, nil, nil
+	return err
+}
+
+// ReadPackets submits queued responses and waits for at least one packet,
+// each comes with its own HW/kernel timestamp and destination address like with ReadPacketsWithKernelTimestamp.
+// Packets which control messages were truncated are skipped and reported with ErrControlTruncated
+// alongside the rest of the batch
+func (r *URing) ReadPackets() ([]ReceivedPacket, error) {
+	for len(r.backlog) == 0 {
+		if err := r.enter(1); err != nil {
+			return nil, err
+		}
+		if err := r.reap(); err != nil {
+			return nil, err
+		}
+	}
+	packets := r.backlog
+	r.backlog = nil
+	if r.truncated > 0 {
+		truncated := r.truncated
+		r.truncated = 0
+		return packets, fmt.Errorf("%d of %d packets dropped: %w", truncated, truncated+len(packets), ErrControlTruncated)
+	}
+	return packets, nil
+}
+
+// QueueWrite queues response b to addr sent from local address if it's not nil.
+// It's submitted with the next ReadPackets call, failures are counted by WriteErrors
+func (r *URing) QueueWrite(b []byte, addr net.Addr, local *PktInfo) error {
+	if len(b) > uringMaxResponseLength {
+		return fmt.Errorf("response of %d bytes exceeds %d bytes limit", len(b), uringMaxResponseLength)
+	}
+	// Wait for some sends to complete if all the slots are busy
+	for len(r.freeSlots) == 0 {
+		if err := r.enter(1); err != nil {
+			return err
+		}
+		if err := r.reap(); err != nil {
+			return err
+		}
+	}
+	slotIdx := r.freeSlots[len(r.freeSlots)-1]
+	off := r.slotsOff + int(slotIdx)*r.slotSize
+	slot := (*uringSendSlot)(unsafe.Pointer(&r.mem[off]))
+	namelen, err := fillSockaddr(&slot.Name, addr, r.ipv4)
+	if err != nil {
+		return err
+	}
+	r.freeSlots = r.freeSlots[:len(r.freeSlots)-1]
+
+	oobOff := off + int(unsafe.Sizeof(uringSendSlot{}))
+	payloadOff := oobOff + ControlBufferSize(ControlPktInfo)
+	copy(r.mem[payloadOff:], b)
+
+	slot.Hdr = syscall.Msghdr{}
+	slot.Hdr.Name = (*byte)(unsafe.Pointer(&slot.Name))
+	slot.Hdr.Namelen = namelen
+	slot.Iov.Base = &r.mem[payloadOff]
+	slot.Iov.SetLen(len(b))
+	slot.Hdr.Iov = &slot.Iov
+	slot.Hdr.SetIovlen(1)
+	if local != nil && local.Addr != nil {
+		oob := pktInfoControlMessage(local)
+		copy(r.mem[oobOff:payloadOff], oob)
+		slot.Hdr.Control = &r.mem[oobOff]
+		slot.Hdr.SetControllen(len(oob))
+	}
+
+	sqe, err := r.getSQE()
+	if err != nil {
+		r.freeSlots = append(r.freeSlots, slotIdx)
+		return err
+	}
+	sqe.Opcode = uringOpSendmsg
+	sqe.Fd = int32(r.fd)
+	sqe.Addr = uint64(uintptr(unsafe.Pointer(&slot.Hdr)))
+	sqe.Len = 1
+	sqe.UserData = uringTagSend | uint64(slotIdx)
+	return nil
+}
+
+// Flush submits queued responses without waiting for anything
+func (r *URing) Flush() error {
+	return r.enter(0)
+}
+
+// WriteErrors returns the number of responses which failed to send since the previous call
+func (r *URing) WriteErrors() int {
+	n := r.writeErrors
+	r.writeErrors = 0
+	return n
+}
+
+// getSQE returns next free submission entry, flushing the queue to the kernel if it's full
+func (r *URing) getSQE() (*uringSQE, error) {
+	if r.tail-atomic.LoadUint32(r.sqHead) >= r.sqEntries {
+		if err := r.enter(0); err != nil {
+			return nil, err
+		}
+		if r.tail-atomic.LoadUint32(r.sqHead) >= r.sqEntries {
+			return nil, fmt.Errorf("io_uring submission queue is full")
+		}
+	}
+	idx := r.tail & r.sqMask
+	sqe := (*uringSQE)(unsafe.Pointer(&r.sqes[uintptr(idx)*unsafe.Sizeof(uringSQE{})]))
+	*sqe = uringSQE{}
+	r.tail++
+	return sqe, nil
+}
+
+// prepRecv queues multishot recvmsg which keeps receiving until it runs out of buffers
+func (r *URing) prepRecv() {
+	sqe, err := r.getSQE()
+	if err != nil {
+		// stays disarmed, we'll retry after the next completion
+		return
+	}
+	sqe.Opcode = uringOpRecvmsg
+	sqe.Flags = uringSQEBufferSelect
+	sqe.Ioprio = uringRecvMultishot
+	sqe.Fd = int32(r.fd)
+	sqe.Addr = uint64(uintptr(unsafe.Pointer(&r.mem[0])))
+	sqe.Len = 1
+	sqe.OpFlags = syscall.MSG_TRUNC
+	sqe.BufGroup = uringBufferGroup
+	sqe.UserData = uringTagRecv
+	r.recvArmed = true
+}
+
+// prepProvideBuffers queues handing count buffers starting with bid back to the kernel.
+// If submission queue is full they are kept aside and retried by the next reap
+func (r *URing) prepProvideBuffers(bid, count int) {
+	sqe, err := r.getSQE()
+	if err != nil {
+		for i := bid; i < bid+count; i++ {
+			r.unprovided = append(r.unprovided, i)
+		}
+		return
+	}
+	r.provideSQE(sqe, bid, count)
+}
+
+// retryProvideBuffers queues buffers prepProvideBuffers failed to hand back to the kernel, as many as fit
+func (r *URing) retryProvideBuffers() {
+	for len(r.unprovided) > 0 {
+		sqe, err := r.getSQE()
+		if err != nil {
+			return
+		}
+		r.provideSQE(sqe, r.unprovided[len(r.unprovided)-1], 1)
+		r.unprovided = r.unprovided[:len(r.unprovided)-1]
+	}
+}
+
+// provideSQE fills the entry to hand count buffers starting with bid to the kernel
+func (r *URing) provideSQE(sqe *uringSQE, bid, count int) {
+	sqe.Opcode = uringOpProvideBuffers
+	sqe.Fd = int32(count)
+	sqe.Addr = uint64(uintptr(unsafe.Pointer(&r.mem[r.bufsOffset+bid*r.bufSize])))
+	sqe.Len = uint32(r.bufSize)
+	sqe.Off = uint64(bid)
+	sqe.BufGroup = uringBufferGroup
+	sqe.UserData = uringTagProvide
+}
+
+// enter submits queued entries and waits for at least minComplete completions
+func (r *URing) enter(minComplete int) error {
+	atomic.StoreUint32(r.sqTail, r.tail)
+	flags := 0
+	if minComplete > 0 {
+		flags = uringEnterGetEvents
+	}
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_IO_URING_ENTER, uintptr(r.ringFd), uintptr(r.tail-r.submitted), uintptr(minComplete), uintptr(flags), 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		r.submitted += uint32(n)
+		return nil
+	}
+}
+
+// reap processes all available completions
+func (r *URing) reap() error {
+	// In case there is no kernel timestamp, this is as close to the receive time as we can get in userspace
+	mono := MonotonicRaw()
+	head := *r.cqHead
+	tail := atomic.LoadUint32(r.cqTail)
+	var err error
+	for ; head != tail && err == nil; head++ {
+		off := r.cqes + uintptr(head&r.cqMask)*unsafe.Sizeof(uringCQE{})
+		cqe := *(*uringCQE)(unsafe.Pointer(&r.cqRing[off]))
+		switch cqe.UserData & uringTagMask {
+		case uringTagRecv:
+			err = r.handleRecv(cqe, mono)
+		case uringTagProvide:
+			if cqe.Res < 0 {
+				err = fmt.Errorf("failed to provide buffers: %w", syscall.Errno(-cqe.Res))
+			}
+		case uringTagSend:
+			r.freeSlots = append(r.freeSlots, uint32(cqe.UserData&^uringTagMask))
+			if cqe.Res < 0 {
+				r.writeErrors++
+			}
+		}
+	}
+	atomic.StoreUint32(r.cqHead, head)
+	if err == nil && !r.recvArmed {
+		r.prepRecv()
+	}
+	r.retryProvideBuffers()
+	return err
+}
+
+// handleRecv parses a packet received by multishot recvmsg and returns its buffer to the kernel
+func (r *URing) handleRecv(cqe uringCQE, mono time.Duration) error {
+	if cqe.Flags&uringCQEFMore == 0 {
+		// multishot request is over, it has to be resubmitted
+		r.recvArmed = false
+	}
+	if cqe.Res < 0 {
+		errno := syscall.Errno(-cqe.Res)
+		// Running out of buffers is fine, they come back as we process packets
+		if errno == syscall.ENOBUFS && r.received {
+			return nil
+		}
+		return fmt.Errorf("io_uring recvmsg: %w", errno)
+	}
+	if cqe.Flags&uringCQEFBuffer == 0 {
+		return nil
+	}
+	r.received = true
+	bid := int(cqe.Flags >> uringCQEBufferShift)
+	defer r.prepProvideBuffers(bid, 1)
+
+	buf := r.mem[r.bufsOffset+bid*r.bufSize : r.bufsOffset+(bid+1)*r.bufSize]
+	out := (*uringRecvmsgOut)(unsafe.Pointer(&buf[0]))
+	nameOff := int(unsafe.Sizeof(uringRecvmsgOut{}))
+	oobOff := nameOff + syscall.SizeofSockaddrAny
+	payloadOff := oobOff + r.oobSize
+
+	// Kernel silently drops control messages which don't fit, so timestamp may be missing
+	if out.Flags&syscall.MSG_CTRUNC != 0 {
+		r.truncated++
+		return nil
+	}
+	rxTime, local, err := parseControlMessages(buf[oobOff : oobOff+int(out.Controllen)])
+	if err != nil {
+		return err
+	}
+	payload := buf[payloadOff : payloadOff+MaxMessageSizeBytes]
+	// Buffers are reused, don't let short packets pick up leftovers of previous ones
+	// With MSG_TRUNC payload length is the real one, which may exceed the buffer
+	n := int(out.Payloadlen)
+	if n > MaxMessageSizeBytes {
+		n = MaxMessageSizeBytes
+	}
+	if n < PacketSizeBytes {
+		for i := n; i < PacketSizeBytes; i++ {
+			payload[i] = 0
+		}
+	}
+	packet, err := BytesToPacket(payload[:PacketSizeBytes])
+	if err != nil {
+		return err
+	}
+	r.backlog = append(r.backlog, ReceivedPacket{
+		Packet:     packet,
+		RxTime:     fallbackRxTime(rxTime, mono),
+		RxMono:     mono,
+		RemAddr:    rawSockaddrToUDP((*syscall.RawSockaddrAny)(unsafe.Pointer(&buf[nameOff]))),
+		Local:      local,
+		Extensions: extensions(payload, n),
+	})
+	return nil
+}
+
+// align8 rounds n up to the multiple of 8
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
@@ -0,0 +1,60 @@
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ntp
+
+import (
+	"errors"
+	"net"
+)
+
+var errURingNotSupported = errors.New("io_uring is only supported on Linux")
+
+// URing is an io_uring based datapath for a single UDP socket.
+// io_uring is Linux-specific, here it's never available
+type URing struct{}
+
+// NewURing always fails, caller is expected to fall back to ReadPacketsWithKernelTimestamp
+func NewURing(conn *net.UDPConn, entries int) (*URing, error) {
+	return nil, errURingNotSupported
+}
+
+// Close does nothing
+func (r *URing) Close() error {
+	return nil
+}
+
+// ReadPackets always fails
+func (r *URing) ReadPackets() ([]ReceivedPacket, error) {
+	return nil, errURingNotSupported
+}
+
+// QueueWrite always fails
+func (r *URing) QueueWrite(b []byte, addr net.Addr, local *PktInfo) error {
+	return errURingNotSupported
+}
+
+// Flush always fails
+func (r *URing) Flush() error {
+	return errURingNotSupported
+}
+
+// WriteErrors always returns 0
+func (r *URing) WriteErrors() int {
+	return 0
+}
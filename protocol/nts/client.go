@@ -0,0 +1,286 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// Session holds keys and cookies established by NTS-KE. It is not safe for concurrent use
+type Session struct {
+	AEAD uint16
+	// Server and Port of NTP server NTS-KE pointed the client at, cookies are only good there.
+	// Empty and zero if it didn't, which means NTP server of the NTS-KE host on the default port
+	Server string
+	Port   uint16
+
+	c2sKey  []byte
+	s2cKey  []byte
+	c2s     cipher.AEAD
+	s2c     cipher.AEAD
+	cookies [][]byte
+}
+
+func newSession(aead uint16, c2sKey, s2cKey []byte, cookies [][]byte) (*Session, error) {
+	if err := aeadAllowed(aead); err != nil {
+		return nil, err
+	}
+	alg := aeadAlgorithms[aead]
+	c2s, err := alg.new(c2sKey)
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := alg.new(s2cKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{AEAD: aead, c2sKey: c2sKey, s2cKey: s2cKey, c2s: c2s, s2c: s2c, cookies: cookies}, nil
+}
+
+// Cookies returns the number of unused cookies
+func (s *Session) Cookies() int {
+	return len(s.cookies)
+}
+
+// Addr returns host:port of NTP server the session is bound to. Host and port NTS-KE didn't negotiate
+// are taken from addr, which is where the client would send requests otherwise
+func (s *Session) Addr(addr string) (string, error) {
+	if s.Server == "" && s.Port == 0 {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if s.Server != "" {
+		host = s.Server
+	}
+	if s.Port != 0 {
+		port = strconv.Itoa(int(s.Port))
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// NewRequest appends NTS extension fields to NTP request header, consuming one cookie.
+// It returns the request along with its Unique Identifier the response is matched with
+func (s *Session) NewRequest(header []byte) (request []byte, uid []byte, err error) {
+	if len(s.cookies) == 0 {
+		return nil, nil, ErrNoCookies
+	}
+	cookie := s.cookies[0]
+	s.cookies = s.cookies[1:]
+
+	uidField, err := ntp.NewUniqueIdentifier()
+	if err != nil {
+		return nil, nil, err
+	}
+	request = append([]byte{}, header...)
+	request = append(request, uidField.Bytes()...)
+	cookieField := ntp.ExtensionField{Type: ntp.ExtensionNTSCookie, Value: cookie}
+	request = append(request, cookieField.Bytes()...)
+	// ask for enough fresh cookies to refill the jar
+	for i := len(s.cookies) + 1; i < MaxCookies; i++ {
+		placeholder := ntp.ExtensionField{Type: ntp.ExtensionNTSCookiePlaceholder, Value: make([]byte, len(cookie))}
+		request = append(request, placeholder.Bytes()...)
+	}
+	auth, err := sealAuthenticator(s.c2s, request, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	request = append(request, auth.Bytes()...)
+	return request, uidField.Value, nil
+}
+
+// VerifyResponse authenticates the response to the request with the given Unique Identifier
+// and stores the cookies it carries
+func (s *Session) VerifyResponse(response []byte, uid []byte) error {
+	if len(response) < ntp.PacketSizeBytes {
+		return fmt.Errorf("response of %d bytes is too short", len(response))
+	}
+	var uidOK, authenticated bool
+	var plaintext []byte
+	offset := ntp.PacketSizeBytes
+	for offset < len(response) && !authenticated {
+		field, length, err := ntp.NextExtensionField(response[offset:])
+		if err != nil {
+			return err
+		}
+		switch field.Type {
+		case ntp.ExtensionUniqueIdentifier:
+			uidOK = bytes.Equal(field.Value, uid)
+		case ntp.ExtensionNTSAuthenticator:
+			if plaintext, err = openAuthenticator(s.s2c, response[:offset], field.Value); err != nil {
+				return fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+			}
+			// anything following the authenticator is not authenticated and is ignored
+			authenticated = true
+		}
+		offset += length
+	}
+	if !uidOK {
+		return ErrUniqueIdentifierMismatch
+	}
+	if !authenticated {
+		if isNAK(response) {
+			return ErrNAK
+		}
+		return ErrUnauthenticated
+	}
+	fields, err := ntp.ParseExtensionFields(plaintext)
+	if err != nil {
+		return fmt.Errorf("malformed encrypted extension fields: %w", err)
+	}
+	for _, field := range fields {
+		if field.Type == ntp.ExtensionNTSCookie && len(s.cookies) < MaxCookies {
+			s.cookies = append(s.cookies, append([]byte{}, field.Value...))
+		}
+	}
+	return nil
+}
+
+// isNAK checks if the response is NTS NAK kiss-o'-death
+func isNAK(response []byte) bool {
+	// stratum is the second byte, reference ID starts at 12th
+	return response[1] == 0 && binary.BigEndian.Uint32(response[12:16]) == kissNTSNAK
+}
+
+// Client maintains NTS session with a single server, repeating key establishment when needed.
+// It is safe for concurrent use
+type Client struct {
+	// KEServer is NTS-KE server host:port
+	KEServer string
+	// TLSConfig is used for NTS-KE connections, may be nil. RootCAs trusts internal CAs,
+	// VerifyConnection can pin server keys (see PinSPKI) and Certificates authenticate the client
+	TLSConfig *tls.Config
+	// Timeout of NTS-KE
+	Timeout time.Duration
+	// AEADs are offered in NTS-KE in order of preference, DefaultAEADs if not set
+	AEADs []uint16
+	// Audit receives cookie exhaustion events, may be nil
+	Audit audit.Sink
+	// Store keeps the session between runs, may be nil
+	Store CookieStore
+
+	mu      sync.Mutex
+	session *Session
+	loaded  bool
+}
+
+// Addr returns host:port of NTP server requests have to be sent to, performing key establishment
+// if there are no cookies left. NTS-KE may point at another server than addr, see Session.Addr
+func (c *Client) Addr(addr string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.establish(); err != nil {
+		return "", err
+	}
+	return c.session.Addr(addr)
+}
+
+// NewRequest returns authenticated request, performing key establishment if there are no cookies left
+func (c *Client) NewRequest(header []byte) (request []byte, uid []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.establish(); err != nil {
+		return nil, nil, err
+	}
+	if request, uid, err = c.session.NewRequest(header); err != nil {
+		return nil, nil, err
+	}
+	// spent cookie must not be reused by the next run, it would make requests linkable
+	return request, uid, c.save()
+}
+
+// VerifyResponse authenticates the response. NTS NAK drops the session so next request starts over
+func (c *Client) VerifyResponse(response []byte, uid []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
+		return ErrUnauthenticated
+	}
+	err := c.session.VerifyResponse(response, uid)
+	if errors.Is(err, ErrNAK) {
+		c.session = nil
+	}
+	if saveErr := c.save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// establish makes sure there is a session with cookies left: stored one or the new one from NTS-KE
+func (c *Client) establish() (err error) {
+	if c.session == nil && c.Store != nil && !c.loaded {
+		// stored session is only tried once, KE is repeated if it doesn't work out
+		c.loaded = true
+		if c.session, err = c.load(); err != nil {
+			return err
+		}
+	}
+	if c.session != nil && c.session.Cookies() > 0 {
+		return nil
+	}
+	if c.session != nil {
+		// cookies are replenished by responses, running out of them means responses are lost or dropped
+		audit.Emit(c.Audit, audit.Event{Kind: audit.CookieExhaustion, Peer: c.KEServer})
+	}
+	session, err := Dial(c.KEServer, c.TLSConfig, c.Timeout, c.AEADs...)
+	if err != nil {
+		return err
+	}
+	c.session = session
+	return nil
+}
+
+// load returns the session kept in the store, nil if there is none
+func (c *Client) load() (*Session, error) {
+	state, err := c.Store.Load(c.KEServer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load NTS session: %w", err)
+	}
+	if state == nil {
+		return nil, nil
+	}
+	return state.Session()
+}
+
+// save puts current session into the store, if there is one
+func (c *Client) save() error {
+	if c.Store == nil {
+		return nil
+	}
+	var state *SessionState
+	if c.session != nil {
+		state = c.session.State()
+	}
+	if err := c.Store.Save(c.KEServer, state); err != nil {
+		return fmt.Errorf("failed to save NTS session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate returns self-signed certificate for localhost along with the pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// testKEServer accepts single NTS-KE connection, responds with given records and sends derived session
+// from server point of view into returned channel
+func testKEServer(t *testing.T, response []record) (string, *tls.Config, chan *Session) {
+	cert, pool := testCertificate(t)
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{ALPN},
+		MinVersion:   tls.VersionTLS13,
+	})
+	require.Nil(t, err)
+	sessions := make(chan *Session, 1)
+	go func() {
+		defer ln.Close()
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn := c.(*tls.Conn)
+		defer conn.Close()
+		if _, err := readMessage(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		if err := writeMessage(conn, response); err != nil {
+			return
+		}
+		c2s, s2c, err := newSessionKeys(conn.ConnectionState(), AEADAESSIVCMAC256)
+		if err != nil {
+			return
+		}
+		// server seals with S2C key and opens with C2S one, which is the mirror of the client
+		session, _ := newSession(AEADAESSIVCMAC256, s2c, c2s, nil)
+		sessions <- session
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return net.JoinHostPort("localhost", port), &tls.Config{RootCAs: pool}, sessions
+}
+
+func testKEResponse(cookies ...[]byte) []record {
+	response := []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Type: recordAEAD, Body: uint16Body(AEADAESSIVCMAC256)},
+	}
+	for _, c := range cookies {
+		response = append(response, record{Type: recordNewCookie, Body: c})
+	}
+	return response
+}
+
+// testResponse builds response from server side session the way the server would
+func testResponse(t *testing.T, server *Session, uid []byte, cookies ...[]byte) []byte {
+	response := make([]byte, ntp.PacketSizeBytes)
+	response[0] = 0x24
+	response[1] = 1
+	uidField := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: uid}
+	response = append(response, uidField.Bytes()...)
+	var plaintext []byte
+	for _, c := range cookies {
+		f := ntp.ExtensionField{Type: ntp.ExtensionNTSCookie, Value: c}
+		plaintext = append(plaintext, f.Bytes()...)
+	}
+	auth, err := sealAuthenticator(server.c2s, response, plaintext)
+	require.Nil(t, err)
+	return append(response, auth.Bytes()...)
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	r := record{Critical: true, Type: recordAEAD, Body: uint16Body(AEADAESSIVCMAC256, 16)}
+	b := r.Bytes()
+	assert.Equal(t, []byte{0x80, 0x04, 0x00, 0x04, 0x00, 0x0f, 0x00, 0x10}, b)
+
+	eom := record{Critical: true, Type: recordEndOfMessage}
+	records, err := readMessage(bufio.NewReader(bytes.NewReader(append(b, eom.Bytes()...))))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(records))
+	assert.Equal(t, r, records[0])
+	values, err := records[0].uint16s()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{AEADAESSIVCMAC256, 16}, values)
+
+	// message without End of Message
+	_, err = readMessage(bufio.NewReader(bytes.NewReader(b)))
+	assert.NotNil(t, err)
+}
+
+func TestKEError(t *testing.T) {
+	addr, config, _ := testKEServer(t, []record{{Critical: true, Type: recordError, Body: uint16Body(ErrorBadRequest)}})
+	_, err := Dial(addr, config, time.Second)
+	assert.Equal(t, &KEError{Code: ErrorBadRequest}, err)
+}
+
+func TestKENoCookies(t *testing.T) {
+	addr, config, _ := testKEServer(t, testKEResponse())
+	_, err := Dial(addr, config, time.Second)
+	assert.Equal(t, ErrNoCookies, err)
+}
+
+func TestKEUnknownCriticalRecord(t *testing.T) {
+	addr, config, _ := testKEServer(t, append(testKEResponse([]byte("cookie")), record{Critical: true, Type: 0x1234}))
+	_, err := Dial(addr, config, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestKERedirect(t *testing.T) {
+	response := append(testKEResponse([]byte("cookie")),
+		record{Critical: true, Type: recordServer, Body: []byte("ntp.example.com")},
+		record{Critical: true, Type: recordPort, Body: uint16Body(1123)},
+	)
+	addr, config, _ := testKEServer(t, response)
+	session, err := Dial(addr, config, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, "ntp.example.com", session.Server)
+	assert.Equal(t, uint16(1123), session.Port)
+	ntpAddr, err := session.Addr("localhost:123")
+	require.Nil(t, err)
+	assert.Equal(t, "ntp.example.com:1123", ntpAddr)
+
+	// only what was negotiated is replaced
+	session.Server = ""
+	ntpAddr, err = session.Addr("localhost:123")
+	require.Nil(t, err)
+	assert.Equal(t, "localhost:1123", ntpAddr)
+	session.Server, session.Port = "::1", 0
+	ntpAddr, err = session.Addr("localhost:123")
+	require.Nil(t, err)
+	assert.Equal(t, "[::1]:123", ntpAddr)
+}
+
+func TestKERedirectMalformed(t *testing.T) {
+	for _, r := range []record{
+		{Critical: true, Type: recordServer, Body: []byte("ntp example com")},
+		{Critical: true, Type: recordServer},
+		{Critical: true, Type: recordPort, Body: uint16Body(123, 1123)},
+		{Critical: true, Type: recordPort, Body: uint16Body(0)},
+	} {
+		addr, config, _ := testKEServer(t, append(testKEResponse([]byte("cookie")), r))
+		_, err := Dial(addr, config, time.Second)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	response := append(testKEResponse([]byte("cookie")), record{Critical: true, Type: recordPort, Body: uint16Body(1123)})
+	addr, config, _ := testKEServer(t, response)
+	c := &Client{KEServer: addr, TLSConfig: config, Timeout: time.Second}
+	ntpAddr, err := c.Addr("localhost:123")
+	require.Nil(t, err)
+	assert.Equal(t, "localhost:1123", ntpAddr)
+	// request uses the same session, KE server doesn't accept another connection
+	_, _, err = c.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	assert.Nil(t, err)
+}
+
+func TestKEUntrustedServer(t *testing.T) {
+	addr, _, _ := testKEServer(t, testKEResponse([]byte("cookie")))
+	_, err := Dial(addr, &tls.Config{RootCAs: x509.NewCertPool()}, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestSession(t *testing.T) {
+	cookie := []byte("first cookie....")
+	addr, config, sessions := testKEServer(t, testKEResponse(cookie))
+	client, err := Dial(addr, config, time.Second)
+	require.Nil(t, err)
+	server := <-sessions
+	assert.Equal(t, 1, client.Cookies())
+
+	header := make([]byte, ntp.PacketSizeBytes)
+	header[0] = 0x23
+	request, uid, err := client.NewRequest(header)
+	require.Nil(t, err)
+	assert.Equal(t, 0, client.Cookies())
+	assert.Equal(t, header, request[:ntp.PacketSizeBytes])
+
+	// server side: authenticate the request and check what client sent
+	fields, err := ntp.ParseExtensionFields(request[ntp.PacketSizeBytes:])
+	require.Nil(t, err)
+	require.Equal(t, 2+MaxCookies-1+1, len(fields))
+	assert.Equal(t, ntp.ExtensionUniqueIdentifier, fields[0].Type)
+	assert.Equal(t, uid, fields[0].Value)
+	assert.Equal(t, ntp.ExtensionNTSCookie, fields[1].Type)
+	assert.Equal(t, cookie, fields[1].Value)
+	for _, f := range fields[2 : len(fields)-1] {
+		assert.Equal(t, ntp.ExtensionNTSCookiePlaceholder, f.Type)
+		assert.Equal(t, len(cookie), len(f.Value))
+	}
+	auth := fields[len(fields)-1]
+	assert.Equal(t, ntp.ExtensionNTSAuthenticator, auth.Type)
+	ad := request[:len(request)-ntp.ExtensionHeaderSizeBytes-len(auth.Value)]
+	_, err = openAuthenticator(server.s2c, ad, auth.Value)
+	assert.Nil(t, err)
+
+	// valid response replenishes cookies
+	newCookies := [][]byte{[]byte("second cookie..."), []byte("third cookie....")}
+	response := testResponse(t, server, uid, newCookies...)
+	require.Nil(t, client.VerifyResponse(response, uid))
+	assert.Equal(t, 2, client.Cookies())
+	assert.Equal(t, newCookies, client.cookies)
+
+	// response to another request
+	otherUID := make([]byte, ntp.UniqueIdentifierSizeBytes)
+	assert.Equal(t, ErrUniqueIdentifierMismatch, client.VerifyResponse(response, otherUID))
+
+	// tampered response
+	response[ntp.PacketSizeBytes-1] ^= 1
+	assert.ErrorIs(t, client.VerifyResponse(response, uid), ErrUnauthenticated)
+
+	// unauthenticated response
+	assert.Equal(t, ErrUnauthenticated, client.VerifyResponse(response[:ntp.PacketSizeBytes+ntp.ExtensionHeaderSizeBytes+ntp.UniqueIdentifierSizeBytes], uid))
+}
+
+func TestSessionNAK(t *testing.T) {
+	client, err := newSession(AEADAESSIVCMAC256, make([]byte, 32), make([]byte, 32), nil)
+	require.Nil(t, err)
+	uid := make([]byte, ntp.UniqueIdentifierSizeBytes)
+	response := make([]byte, ntp.PacketSizeBytes)
+	binary.BigEndian.PutUint32(response[12:16], kissNTSNAK)
+	uidField := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: uid}
+	response = append(response, uidField.Bytes()...)
+	assert.Equal(t, ErrNAK, client.VerifyResponse(response, uid))
+
+	_, _, err = client.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	assert.Equal(t, ErrNoCookies, err)
+}
+
+func TestClient(t *testing.T) {
+	addr, config, sessions := testKEServer(t, testKEResponse([]byte("cookie")))
+	c := &Client{KEServer: addr, TLSConfig: config, Timeout: time.Second}
+	_, uid, err := c.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	require.Nil(t, err)
+	server := <-sessions
+	require.Nil(t, c.VerifyResponse(testResponse(t, server, uid, []byte("another")), uid))
+
+	// NAK drops the session
+	nak := make([]byte, ntp.PacketSizeBytes)
+	binary.BigEndian.PutUint32(nak[12:16], kissNTSNAK)
+	uidField := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: uid}
+	nak = append(nak, uidField.Bytes()...)
+	assert.Equal(t, ErrNAK, c.VerifyResponse(nak, uid))
+	assert.Nil(t, c.session)
+}
+
+func TestClientCookieExhaustion(t *testing.T) {
+	addr, config, _ := testKEServer(t, testKEResponse([]byte("cookie")))
+	events := &testSink{}
+	c := &Client{KEServer: addr, TLSConfig: config, Timeout: time.Second, Audit: events}
+	_, _, err := c.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	require.Nil(t, err)
+	assert.Empty(t, events.events)
+	// response never came back, so the only cookie is gone. KE server doesn't accept another connection
+	_, _, err = c.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	assert.NotNil(t, err)
+	require.Equal(t, 1, len(events.events))
+	assert.Equal(t, audit.CookieExhaustion, events.events[0].Kind)
+	assert.Equal(t, addr, events.events[0].Peer)
+}
+
+// testSink collects audit events
+type testSink struct {
+	events []audit.Event
+}
+
+func (s *testSink) Emit(e audit.Event) {
+	s.events = append(s.events, e)
+}
@@ -0,0 +1,251 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCookieKeys is how many master keys are kept by default: the current one and two previous
+const DefaultCookieKeys = 3
+
+// masterKeySize is the size of AES-SIV-CMAC-256 key cookies are encrypted with
+const masterKeySize = 32
+
+// cookieHeaderSize is key ID and nonce which precede encrypted cookie contents
+const cookieHeaderSize = 4 + sivNonceSize
+
+// ErrInvalidCookie is returned for cookies the server can't decrypt, e.g. issued with a key which is rotated out
+var ErrInvalidCookie = errors.New("invalid NTS cookie")
+
+// cookie is the state server offloads to the client: negotiated AEAD and session keys
+type cookie struct {
+	aead   uint16
+	c2sKey []byte
+	s2cKey []byte
+}
+
+type masterKey struct {
+	id   uint32
+	aead cipher.AEAD
+	// expires is set for keys which were replaced and only validate cookies during the overlap window
+	expires time.Time
+}
+
+// MasterKey is cookie master key shared by servers behind the same NTS-KE
+type MasterKey struct {
+	ID  uint32
+	Key []byte
+}
+
+// CookieKeys are master keys server encrypts cookies with. Every rotation generates a new key
+// and a few previous ones are kept, so cookies issued before the rotation remain valid.
+// It is safe for concurrent use
+type CookieKeys struct {
+	mu   sync.RWMutex
+	keys []masterKey // newest first
+	keep int
+}
+
+// NewCookieKeys returns CookieKeys keeping up to keep keys, DefaultCookieKeys if keep is not positive
+func NewCookieKeys(keep int) (*CookieKeys, error) {
+	if keep <= 0 {
+		keep = DefaultCookieKeys
+	}
+	k := &CookieKeys{keep: keep}
+	if err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Rotate generates a new master key for new cookies and drops the oldest one if there are too many
+func (k *CookieKeys) Rotate() error {
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	aead, err := newSIVCMAC(key)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = append([]masterKey{{id: binary.BigEndian.Uint32(id[:]), aead: aead}}, k.keys...)
+	if len(k.keys) > k.keep {
+		k.keys = k.keys[:k.keep]
+	}
+	return nil
+}
+
+// seal encrypts cookie with the current master key
+func (k *CookieKeys) seal(c *cookie) ([]byte, error) {
+	k.mu.RLock()
+	key := k.keys[0]
+	k.mu.RUnlock()
+
+	// cookies travel in extension fields padded to 4 bytes, so padding is made part of the cookie
+	plaintext := make([]byte, pad4(2+len(c.c2sKey)+len(c.s2cKey)))
+	binary.BigEndian.PutUint16(plaintext, c.aead)
+	copy(plaintext[2:], c.c2sKey)
+	copy(plaintext[2+len(c.c2sKey):], c.s2cKey)
+
+	b := make([]byte, cookieHeaderSize, cookieHeaderSize+len(plaintext)+key.aead.Overhead())
+	binary.BigEndian.PutUint32(b[0:4], key.id)
+	nonce := b[4:cookieHeaderSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return key.aead.Seal(b, nonce, plaintext, b[0:4]), nil
+}
+
+// open decrypts cookie with the master key it was sealed with
+func (k *CookieKeys) open(b []byte) (*cookie, error) {
+	if len(b) < cookieHeaderSize {
+		return nil, ErrInvalidCookie
+	}
+	id := binary.BigEndian.Uint32(b[0:4])
+	var key *masterKey
+	now := time.Now()
+	k.mu.RLock()
+	for i := range k.keys {
+		if k.keys[i].id == id && (k.keys[i].expires.IsZero() || now.Before(k.keys[i].expires)) {
+			key = &k.keys[i]
+			break
+		}
+	}
+	k.mu.RUnlock()
+	if key == nil {
+		return nil, ErrInvalidCookie
+	}
+	plaintext, err := key.aead.Open(nil, b[4:cookieHeaderSize], b[cookieHeaderSize:], b[0:4])
+	if err != nil || len(plaintext) < 2 {
+		return nil, ErrInvalidCookie
+	}
+	c := &cookie{aead: binary.BigEndian.Uint16(plaintext[0:2])}
+	alg, ok := aeadAlgorithms[c.aead]
+	if !ok || len(plaintext) != pad4(2+2*alg.keySize) {
+		return nil, fmt.Errorf("%w: unexpected contents", ErrInvalidCookie)
+	}
+	if err := aeadAllowed(c.aead); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCookie, err)
+	}
+	c.c2sKey = plaintext[2 : 2+alg.keySize]
+	c.s2cKey = plaintext[2+alg.keySize : 2+2*alg.keySize]
+	return c, nil
+}
+
+// Set replaces master keys, the first one is used for new cookies.
+// Keys which are not in the new set keep validating cookies for the overlap window
+func (k *CookieKeys) Set(keys []MasterKey, overlap time.Duration) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no master keys")
+	}
+	newKeys := make([]masterKey, 0, len(keys))
+	ids := map[uint32]bool{}
+	for _, key := range keys {
+		if ids[key.ID] {
+			return fmt.Errorf("duplicate master key %d", key.ID)
+		}
+		ids[key.ID] = true
+		aead, err := newSIVCMAC(key.Key)
+		if err != nil {
+			return fmt.Errorf("master key %d: %w", key.ID, err)
+		}
+		newKeys = append(newKeys, masterKey{id: key.ID, aead: aead})
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	for _, old := range k.keys {
+		if ids[old.id] || overlap <= 0 {
+			continue
+		}
+		// keys replaced earlier keep their own deadline
+		if old.expires.IsZero() {
+			old.expires = now.Add(overlap)
+		} else if now.After(old.expires) {
+			continue
+		}
+		newKeys = append(newKeys, old)
+	}
+	k.keys = newKeys
+	return nil
+}
+
+// ReadMasterKeysFile reads master keys file
+func ReadMasterKeysFile(path string) ([]MasterKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMasterKeys(f)
+}
+
+// ParseMasterKeys parses master keys: one "id hexkey" per line, # starts a comment.
+// The first key is the current one
+func ParseMasterKeys(r io.Reader) ([]MasterKey, error) {
+	var keys []MasterKey
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected key ID and key, got %d fields", line, len(fields))
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid key ID %q", line, fields[0])
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != masterKeySize {
+			return nil, fmt.Errorf("line %d: key must be %d hex encoded bytes", line, masterKeySize)
+		}
+		keys = append(keys, MasterKey{ID: uint32(id), Key: key})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// gcmSIVNonceSize is the nonce size of AES-GCM-SIV
+const gcmSIVNonceSize = 12
+
+// gcmSIVTagSize is the size of AES-GCM-SIV authentication tag
+const gcmSIVTagSize = 16
+
+// gcmSIV implements AEAD_AES_128_GCM_SIV and AEAD_AES_256_GCM_SIV, see RFC 8452
+type gcmSIV struct {
+	// block is keyed with key-generating key, per-message keys are derived from it and the nonce
+	block   cipher.Block
+	keySize int
+}
+
+// newGCMSIV returns AES-GCM-SIV AEAD with 16 or 32 byte key
+func newGCMSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("invalid AES-GCM-SIV key size %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmSIV{block: block, keySize: len(key)}, nil
+}
+
+func (g *gcmSIV) NonceSize() int { return gcmSIVNonceSize }
+
+func (g *gcmSIV) Overhead() int { return gcmSIVTagSize }
+
+// deriveKeys derives message authentication and encryption keys, see RFC 8452 section 4
+func (g *gcmSIV) deriveKeys(nonce []byte) (authKey [16]byte, enc cipher.Block, err error) {
+	var in, out [16]byte
+	copy(in[4:], nonce)
+	encKey := make([]byte, g.keySize)
+	for i := 0; i < 2+g.keySize/8; i++ {
+		binary.LittleEndian.PutUint32(in[0:4], uint32(i))
+		g.block.Encrypt(out[:], in[:])
+		// only the first half of each block is used
+		if i < 2 {
+			copy(authKey[8*i:], out[:8])
+		} else {
+			copy(encKey[8*(i-2):], out[:8])
+		}
+	}
+	enc, err = aes.NewCipher(encKey)
+	return authKey, enc, err
+}
+
+// tag computes authentication tag over additional data and plaintext
+func (g *gcmSIV) tag(authKey [16]byte, enc cipher.Block, nonce, plaintext, additionalData []byte) [16]byte {
+	p := newPolyval(authKey)
+	p.update(additionalData)
+	p.update(plaintext)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:16], uint64(len(plaintext))*8)
+	p.update(lengths[:])
+	s := p.sum()
+	for i := range nonce {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	var tag [16]byte
+	enc.Encrypt(tag[:], s[:])
+	return tag
+}
+
+// ctr encrypts or decrypts src with the counter starting at the tag with the top bit set
+func (g *gcmSIV) ctr(enc cipher.Block, dst, src []byte, tag [16]byte) {
+	counter := tag
+	counter[15] |= 0x80
+	var keystream [16]byte
+	for len(src) > 0 {
+		enc.Encrypt(keystream[:], counter[:])
+		// counter is the first 32 bits, little-endian, wrapping around
+		binary.LittleEndian.PutUint32(counter[0:4], binary.LittleEndian.Uint32(counter[0:4])+1)
+		n := len(src)
+		if n > len(keystream) {
+			n = len(keystream)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ keystream[i]
+		}
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != gcmSIVNonceSize {
+		panic("nts: incorrect nonce length given to AES-GCM-SIV")
+	}
+	authKey, enc, err := g.deriveKeys(nonce)
+	if err != nil {
+		panic(err)
+	}
+	tag := g.tag(authKey, enc, nonce, plaintext, additionalData)
+	ret, out := sliceForAppend(dst, len(plaintext)+gcmSIVTagSize)
+	g.ctr(enc, out[:len(plaintext)], plaintext, tag)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != gcmSIVNonceSize || len(ciphertext) < gcmSIVTagSize {
+		return nil, errOpen
+	}
+	authKey, enc, err := g.deriveKeys(nonce)
+	if err != nil {
+		return nil, err
+	}
+	var tag [16]byte
+	copy(tag[:], ciphertext[len(ciphertext)-gcmSIVTagSize:])
+	plaintext := make([]byte, len(ciphertext)-gcmSIVTagSize)
+	g.ctr(enc, plaintext, ciphertext[:len(plaintext)], tag)
+	expected := g.tag(authKey, enc, nonce, plaintext, additionalData)
+	if subtle.ConstantTimeCompare(expected[:], tag[:]) != 1 {
+		return nil, errOpen
+	}
+	return append(dst, plaintext...), nil
+}
+
+// polyval is POLYVAL universal hash, see RFC 8452 section 3.
+// Field elements are little-endian: bit i of lo|hi<<64 is the coefficient of x^i
+type polyval struct {
+	hLo, hHi uint64
+	sLo, sHi uint64
+}
+
+func newPolyval(h [16]byte) *polyval {
+	return &polyval{hLo: binary.LittleEndian.Uint64(h[0:8]), hHi: binary.LittleEndian.Uint64(h[8:16])}
+}
+
+// update absorbs data zero-padded to the block size
+func (p *polyval) update(data []byte) {
+	for len(data) > 0 {
+		var block [16]byte
+		n := copy(block[:], data)
+		data = data[n:]
+		p.sLo ^= binary.LittleEndian.Uint64(block[0:8])
+		p.sHi ^= binary.LittleEndian.Uint64(block[8:16])
+		p.sLo, p.sHi = dot(p.sLo, p.sHi, p.hLo, p.hHi)
+	}
+}
+
+func (p *polyval) sum() [16]byte {
+	var s [16]byte
+	binary.LittleEndian.PutUint64(s[0:8], p.sLo)
+	binary.LittleEndian.PutUint64(s[8:16], p.sHi)
+	return s
+}
+
+// dot returns a*b*x^-128 modulo x^128 + x^127 + x^126 + x^121 + 1.
+// Bits of a are consumed from the lowest one, multiplying the accumulator by x^-1 after each of them
+func dot(aLo, aHi, bLo, bHi uint64) (lo, hi uint64) {
+	for i := 0; i < 128; i++ {
+		var bit uint64
+		if i < 64 {
+			bit = (aLo >> uint(i)) & 1
+		} else {
+			bit = (aHi >> uint(i-64)) & 1
+		}
+		// constant time: mask is all ones if the bit is set
+		mask := -bit
+		lo ^= bLo & mask
+		hi ^= bHi & mask
+		// multiply by x^-1: add the polynomial if the accumulator is odd, then divide by x
+		carry := lo & 1
+		mask = -carry
+		lo ^= 1 & mask
+		hi ^= (1<<57 | 1<<62 | 1<<63) & mask
+		lo = lo>>1 | hi<<63
+		hi = hi>>1 | carry<<63
+	}
+	return lo, hi
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.Nil(t, err)
+	return b
+}
+
+// RFC 8452 appendix A
+func TestPolyval(t *testing.T) {
+	var h [16]byte
+	copy(h[:], unhex(t, "25629347589242761d31f826ba4b757b"))
+	p := newPolyval(h)
+	p.update(unhex(t, "4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362"))
+	sum := p.sum()
+	assert.Equal(t, unhex(t, "f7a3b47b846119fae5b7866cf5e5b77e"), sum[:])
+}
+
+// RFC 8452 appendix C.1
+func TestGCMSIV(t *testing.T) {
+	key := unhex(t, "01000000000000000000000000000000")
+	nonce := unhex(t, "030000000000000000000000")
+	aead, err := newGCMSIV(key)
+	require.Nil(t, err)
+
+	for _, v := range []struct {
+		plaintext string
+		result    string
+	}{
+		{"", "dc20e2d83f25705bb49e439eca56de25"},
+		{"0100000000000000", "b5d839330ac7b786578782fff6013b815b287c22493a364c"},
+	} {
+		result := aead.Seal(nil, nonce, unhex(t, v.plaintext), nil)
+		assert.Equal(t, v.result, hex.EncodeToString(result))
+		plaintext, err := aead.Open(nil, nonce, result, nil)
+		require.Nil(t, err)
+		assert.Equal(t, v.plaintext, hex.EncodeToString(plaintext))
+	}
+}
+
+func TestGCMSIVRoundTrip(t *testing.T) {
+	for _, keySize := range []int{16, 32} {
+		aead, err := newGCMSIV(make([]byte, keySize))
+		require.Nil(t, err)
+		nonce := make([]byte, aead.NonceSize())
+		plaintext := []byte("plaintext spanning more than a single AES block")
+		ciphertext := aead.Seal(nil, nonce, plaintext, []byte("ad"))
+		assert.Equal(t, len(plaintext)+aead.Overhead(), len(ciphertext))
+		opened, err := aead.Open(nil, nonce, ciphertext, []byte("ad"))
+		require.Nil(t, err)
+		assert.Equal(t, plaintext, opened)
+
+		_, err = aead.Open(nil, nonce, ciphertext, []byte("other ad"))
+		assert.NotNil(t, err)
+		ciphertext[0] ^= 1
+		_, err = aead.Open(nil, nonce, ciphertext, []byte("ad"))
+		assert.NotNil(t, err)
+	}
+	_, err := newGCMSIV(make([]byte, 24))
+	assert.NotNil(t, err)
+}
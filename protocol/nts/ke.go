@@ -0,0 +1,235 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/ntp/internal/fips"
+)
+
+// exportKey derives C2S (s2c=false) or S2C (s2c=true) key from TLS session, see RFC 8915 section 5.1
+func exportKey(state tls.ConnectionState, aead uint16, s2c bool) ([]byte, error) {
+	alg, ok := aeadAlgorithms[aead]
+	if !ok {
+		return nil, fmt.Errorf("unsupported AEAD algorithm %d", aead)
+	}
+	context := []byte{0, 0, byte(aead >> 8), byte(aead), 0}
+	if s2c {
+		context[4] = 1
+	}
+	return state.ExportKeyingMaterial(exporterLabel, context, alg.keySize)
+}
+
+// newSessionKeys derives both AEADs from TLS session
+func newSessionKeys(state tls.ConnectionState, aead uint16) (c2s, s2c []byte, err error) {
+	if c2s, err = exportKey(state, aead, false); err != nil {
+		return nil, nil, err
+	}
+	if s2c, err = exportKey(state, aead, true); err != nil {
+		return nil, nil, err
+	}
+	return c2s, s2c, nil
+}
+
+// KE performs NTS key establishment over established TLS connection,
+// offering AEAD algorithms in order of preference, DefaultAEADs allowed in the current mode if none are given
+func KE(conn *tls.Conn, aeads ...uint16) (*Session, error) {
+	aeads, err := allowedAEADs(aeads)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	state := conn.ConnectionState()
+	if state.Version != tls.VersionTLS13 {
+		return nil, fmt.Errorf("NTS-KE requires TLS 1.3, negotiated %x", state.Version)
+	}
+	if state.NegotiatedProtocol != ALPN {
+		return nil, fmt.Errorf("server didn't negotiate %q ALPN", ALPN)
+	}
+
+	request := []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Type: recordAEAD, Body: uint16Body(aeads...)},
+	}
+	if err := writeMessage(conn, request); err != nil {
+		return nil, fmt.Errorf("failed to send NTS-KE request: %w", err)
+	}
+	response, err := readMessage(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+
+	var protocolOK, aeadOK bool
+	var aead, port uint16
+	var server string
+	var cookies [][]byte
+	for _, r := range response {
+		switch r.Type {
+		case recordError:
+			codes, err := r.uint16s()
+			if err != nil || len(codes) != 1 {
+				return nil, fmt.Errorf("malformed NTS-KE error record")
+			}
+			return nil, &KEError{Code: codes[0]}
+		case recordNextProtocol:
+			protocols, err := r.uint16s()
+			if err != nil {
+				return nil, err
+			}
+			if len(protocols) != 1 || protocols[0] != ProtocolNTPv4 {
+				return nil, fmt.Errorf("server didn't agree to NTPv4, offered %v", protocols)
+			}
+			protocolOK = true
+		case recordAEAD:
+			algorithms, err := r.uint16s()
+			if err != nil {
+				return nil, err
+			}
+			if len(algorithms) == 0 {
+				if fips.Enabled() {
+					return nil, fmt.Errorf("server supports none of offered AEAD algorithms, FIPS mode only allows %s", aeadNames(aeads))
+				}
+				return nil, fmt.Errorf("server supports none of offered AEAD algorithms")
+			}
+			if len(algorithms) != 1 {
+				return nil, fmt.Errorf("server offered %d AEAD algorithms instead of one", len(algorithms))
+			}
+			if !offered(aeads, algorithms[0]) {
+				return nil, fmt.Errorf("server chose AEAD algorithm %s which wasn't offered", AEADName(algorithms[0]))
+			}
+			aead = algorithms[0]
+			aeadOK = true
+		case recordNewCookie:
+			cookies = append(cookies, r.Body)
+		case recordServer:
+			if server, err = parseServer(r.Body); err != nil {
+				return nil, err
+			}
+		case recordPort:
+			ports, err := r.uint16s()
+			if err != nil || len(ports) != 1 || ports[0] == 0 {
+				return nil, fmt.Errorf("malformed NTS-KE port record")
+			}
+			port = ports[0]
+		case recordWarning:
+			// warnings carry no information we can act upon
+		default:
+			if r.Critical {
+				return nil, fmt.Errorf("unrecognized critical NTS-KE record %d", r.Type)
+			}
+		}
+	}
+	if !protocolOK {
+		return nil, fmt.Errorf("NTS-KE response has no next protocol record")
+	}
+	if !aeadOK {
+		return nil, fmt.Errorf("NTS-KE response has no AEAD algorithm record")
+	}
+	if len(cookies) == 0 {
+		return nil, ErrNoCookies
+	}
+	c2sKey, s2cKey, err := newSessionKeys(state, aead)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export keys: %w", err)
+	}
+	session, err := newSession(aead, c2sKey, s2cKey, cookies)
+	if err != nil {
+		return nil, err
+	}
+	session.Server, session.Port = server, port
+	return session, nil
+}
+
+// parseServer parses NTPv4 Server Negotiation record body: IP address or host name in ASCII
+func parseServer(body []byte) (string, error) {
+	server := string(body)
+	if net.ParseIP(server) != nil {
+		return server, nil
+	}
+	if !validHostname(server) {
+		return "", fmt.Errorf("malformed NTS-KE server record %q", server)
+	}
+	return server, nil
+}
+
+// validHostname checks name consists of letters, digits, hyphens and dots, see RFC 1123
+func validHostname(name string) bool {
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Dial connects to NTS-KE server at addr and performs key establishment offering given AEAD algorithms.
+// config may be nil, in which case system roots are used to verify the server
+func Dial(addr string, config *tls.Config, timeout time.Duration, aeads ...uint16) (*Session, error) {
+	var cfg *tls.Config
+	if config != nil {
+		cfg = config.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	cfg.NextProtos = []string{ALPN}
+	cfg.MinVersion = tls.VersionTLS13
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NTS-KE server %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+	return KE(conn, aeads...)
+}
+
+// offered checks if aead is in the list
+func offered(aeads []uint16, aead uint16) bool {
+	for _, a := range aeads {
+		if a == aead {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultKETimeout is how long NTS-KE session may take by default
+const DefaultKETimeout = 10 * time.Second
+
+// Backoff of retrying temporary accept errors, same as net/http uses
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+)
+
+// KEServer is NTS-KE server issuing cookies for the NTP server which shares its CookieKeys
+type KEServer struct {
+	Keys *CookieKeys
+	// TLSConfig has to provide server certificate. ALPN and TLS version are set by the server
+	TLSConfig *tls.Config
+	// Timeout of a single session, DefaultKETimeout if not set
+	Timeout time.Duration
+	// AEADs restricts AEAD algorithms server agrees to, all supported ones if not set.
+	// Server picks the first algorithm from the client's list it agrees to
+	AEADs []uint16
+	// NTPServer and NTPPort point clients at NTP server other than the one on this host and DefaultNTPPort,
+	// e.g. the one behind load balancer. It has to share Keys for the cookies to be valid there
+	NTPServer string
+	NTPPort   uint16
+}
+
+// ListenAndServe listens on TCP address and serves NTS-KE sessions
+func (s *KEServer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	return s.Serve(ln)
+}
+
+// Serve serves NTS-KE sessions on TCP listener until it fails. Temporary accept errors, e.g. running out of
+// file descriptors, are retried with backoff like net/http does, so they don't stop the service
+func (s *KEServer) Serve(ln net.Listener) error {
+	config := s.TLSConfig.Clone()
+	config.NextProtos = []string{ALPN}
+	config.MinVersion = tls.VersionTLS13
+	tlsListener := tls.NewListener(ln, config)
+	var delay time.Duration
+	for {
+		conn, err := tlsListener.Accept()
+		if err != nil {
+			// Temporary is deprecated, yet it is still what tells EMFILE and the like from closed listener
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Temporary() { //nolint:staticcheck
+				return err
+			}
+			delay = min(max(2*delay, acceptMinDelay), acceptMaxDelay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go func() {
+			// failed session just gets closed, client will retry
+			_ = s.handle(conn.(*tls.Conn))
+		}()
+	}
+}
+
+// handle serves single NTS-KE session
+func (s *KEServer) handle(conn *tls.Conn) error {
+	defer conn.Close()
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultKETimeout
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	if conn.ConnectionState().NegotiatedProtocol != ALPN {
+		return fmt.Errorf("client didn't negotiate %q ALPN", ALPN)
+	}
+	request, err := readMessage(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	response, err := s.respond(conn.ConnectionState(), request)
+	if err != nil {
+		return err
+	}
+	return writeMessage(conn, response)
+}
+
+// respond negotiates protocol and AEAD algorithm and issues cookies
+func (s *KEServer) respond(state tls.ConnectionState, request []record) ([]record, error) {
+	errorRecord := func(code uint16) []record {
+		return []record{{Critical: true, Type: recordError, Body: uint16Body(code)}}
+	}
+	var protocols, algorithms []uint16
+	var protocolsSeen bool
+	for _, r := range request {
+		var err error
+		switch r.Type {
+		case recordNextProtocol:
+			if protocolsSeen {
+				return errorRecord(ErrorBadRequest), nil
+			}
+			protocolsSeen = true
+			protocols, err = r.uint16s()
+		case recordAEAD:
+			algorithms, err = r.uint16s()
+		case recordNewCookie, recordServer, recordPort, recordWarning:
+			// nothing we need from the client
+		default:
+			if r.Critical {
+				return errorRecord(ErrorUnrecognizedCriticalRecord), nil
+			}
+		}
+		if err != nil {
+			return errorRecord(ErrorBadRequest), nil
+		}
+	}
+	if !protocolsSeen {
+		return errorRecord(ErrorBadRequest), nil
+	}
+
+	response := []record{}
+	ntpv4 := false
+	for _, p := range protocols {
+		ntpv4 = ntpv4 || p == ProtocolNTPv4
+	}
+	if !ntpv4 {
+		// empty next protocol record means none of the offered protocols is supported
+		return append(response, record{Critical: true, Type: recordNextProtocol}), nil
+	}
+	response = append(response, record{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)})
+
+	aead, ok := uint16(0), false
+	for _, a := range algorithms {
+		if ok = s.accepts(a); ok {
+			aead = a
+			break
+		}
+	}
+	if !ok {
+		// empty AEAD record means none of the offered algorithms is supported
+		return append(response, record{Critical: true, Type: recordAEAD}), nil
+	}
+	response = append(response, record{Critical: true, Type: recordAEAD, Body: uint16Body(aead)})
+	// critical, as client ignoring them would spend the cookies where they are not valid
+	if s.NTPServer != "" {
+		response = append(response, record{Critical: true, Type: recordServer, Body: []byte(s.NTPServer)})
+	}
+	if s.NTPPort != 0 {
+		response = append(response, record{Critical: true, Type: recordPort, Body: uint16Body(s.NTPPort)})
+	}
+
+	c2sKey, s2cKey, err := newSessionKeys(state, aead)
+	if err != nil {
+		return errorRecord(ErrorInternalServerError), nil
+	}
+	c := &cookie{aead: aead, c2sKey: c2sKey, s2cKey: s2cKey}
+	for i := 0; i < MaxCookies; i++ {
+		b, err := s.Keys.seal(c)
+		if err != nil {
+			return nil, err
+		}
+		response = append(response, record{Type: recordNewCookie, Body: b})
+	}
+	return response, nil
+}
+
+// accepts checks if server agrees to AEAD algorithm
+func (s *KEServer) accepts(aead uint16) bool {
+	if aeadAllowed(aead) != nil {
+		return false
+	}
+	return len(s.AEADs) == 0 || offered(s.AEADs, aead)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nts implements Network Time Security for NTPv4, see RFC 8915
+package nts
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/facebookincubator/ntp/internal/fips"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// ALPN is the protocol NTS-KE TLS connections negotiate
+const ALPN = "ntske/1"
+
+// DefaultKEPort is the default NTS-KE port
+const DefaultKEPort = 4460
+
+// DefaultNTPPort is the port NTS-KE server assumes NTP server listens on, unless it negotiates another one
+const DefaultNTPPort = 123
+
+// ProtocolNTPv4 is NTS Next Protocol ID of NTPv4
+const ProtocolNTPv4 uint16 = 0
+
+// AEAD algorithm identifiers, see https://www.iana.org/assignments/aead-parameters
+const (
+	AEADAESSIVCMAC256 uint16 = 15
+	AEADAESSIVCMAC384 uint16 = 16
+	AEADAESSIVCMAC512 uint16 = 17
+	AEADAES128GCMSIV  uint16 = 30
+	AEADAES256GCMSIV  uint16 = 31
+)
+
+// DefaultAEADs are AEAD algorithms client offers by default, in order of preference.
+// AES-SIV-CMAC-256 goes first as the one every NTS implementation supports
+var DefaultAEADs = []uint16{AEADAESSIVCMAC256, AEADAES128GCMSIV, AEADAESSIVCMAC512, AEADAES256GCMSIV, AEADAESSIVCMAC384}
+
+// MaxCookies is how many cookies the client tries to keep
+const MaxCookies = 8
+
+// exporterLabel is the label of TLS exporter keys are derived with
+const exporterLabel = "EXPORTER-network-time-security"
+
+// kissNTSNAK is Reference ID of NTS NAK kiss-o'-death packet
+const kissNTSNAK = 0x4e54534e // NTSN
+
+var (
+	// ErrNoCookies is returned when all the cookies are used up and key establishment has to be repeated
+	ErrNoCookies = errors.New("no NTS cookies left")
+	// ErrNAK is returned when server responds with NTS NAK, which means it couldn't use the cookie
+	ErrNAK = errors.New("NTS NAK received")
+	// ErrUnauthenticated is returned when the response has no valid NTS Authenticator
+	ErrUnauthenticated = errors.New("response is not authenticated")
+	// ErrUniqueIdentifierMismatch is returned when the response doesn't echo request's Unique Identifier
+	ErrUniqueIdentifierMismatch = ntp.ErrUniqueIdentifierMismatch
+	// ErrNotApproved is returned for AEAD algorithms FIPS mode forbids
+	ErrNotApproved = fips.ErrNotApproved
+)
+
+// aeadAlgorithm describes AEAD algorithm we can negotiate.
+// approved ones are built from FIPS approved primitives only: AES-SIV is CMAC and CTR, while AES-GCM-SIV relies on POLYVAL
+type aeadAlgorithm struct {
+	name     string
+	keySize  int
+	approved bool
+	new      func(key []byte) (cipher.AEAD, error)
+}
+
+var aeadAlgorithms = map[uint16]aeadAlgorithm{
+	AEADAESSIVCMAC256: {name: "AES-SIV-CMAC-256", keySize: 32, approved: true, new: newSIVCMAC},
+	AEADAESSIVCMAC384: {name: "AES-SIV-CMAC-384", keySize: 48, approved: true, new: newSIVCMAC},
+	AEADAESSIVCMAC512: {name: "AES-SIV-CMAC-512", keySize: 64, approved: true, new: newSIVCMAC},
+	AEADAES128GCMSIV:  {name: "AES-128-GCM-SIV", keySize: 16, new: newGCMSIV},
+	AEADAES256GCMSIV:  {name: "AES-256-GCM-SIV", keySize: 32, new: newGCMSIV},
+}
+
+// aeadAllowed checks AEAD algorithm is supported and allowed in the current mode
+func aeadAllowed(aead uint16) error {
+	alg, ok := aeadAlgorithms[aead]
+	if !ok {
+		return fmt.Errorf("unsupported AEAD algorithm %d", aead)
+	}
+	if fips.Enabled() && !alg.approved {
+		return fmt.Errorf("%w: %s", ErrNotApproved, alg.name)
+	}
+	return nil
+}
+
+// allowedAEADs returns AEAD algorithms to offer: the given ones, all of which must be allowed,
+// or DefaultAEADs which are allowed in the current mode
+func allowedAEADs(aeads []uint16) ([]uint16, error) {
+	if len(aeads) != 0 {
+		for _, aead := range aeads {
+			if err := aeadAllowed(aead); err != nil {
+				return nil, err
+			}
+		}
+		return aeads, nil
+	}
+	for _, aead := range DefaultAEADs {
+		if aeadAllowed(aead) == nil {
+			aeads = append(aeads, aead)
+		}
+	}
+	return aeads, nil
+}
+
+// AEADName returns the name of AEAD algorithm, e.g. AES-SIV-CMAC-256
+func AEADName(aead uint16) string {
+	if alg, ok := aeadAlgorithms[aead]; ok {
+		return alg.name
+	}
+	return fmt.Sprintf("AEAD(%d)", aead)
+}
+
+// aeadNames returns comma-separated names of AEAD algorithms
+func aeadNames(aeads []uint16) string {
+	names := make([]string, 0, len(aeads))
+	for _, aead := range aeads {
+		names = append(names, AEADName(aead))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseAEAD returns AEAD algorithm identifier by its name. Algorithms FIPS mode forbids are refused when it's on
+func ParseAEAD(name string) (uint16, error) {
+	for id, alg := range aeadAlgorithms {
+		if strings.EqualFold(alg.name, name) {
+			return id, aeadAllowed(id)
+		}
+	}
+	return 0, fmt.Errorf("unsupported AEAD algorithm %q", name)
+}
+
+// ParseAEADs parses comma-separated list of AEAD algorithm names
+func ParseAEADs(list string) ([]uint16, error) {
+	var aeads []uint16
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		aead, err := ParseAEAD(name)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+	return aeads, nil
+}
+
+// pad4 rounds n up to the multiple of 4
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// sealAuthenticator builds NTS Authenticator and Encrypted Extension Fields field.
+// ad is everything in the packet preceding the field
+func sealAuthenticator(aead cipher.AEAD, ad, plaintext []byte) (ntp.ExtensionField, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return ntp.ExtensionField{}, err
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, ad)
+	value := make([]byte, 4+pad4(len(nonce))+pad4(len(ciphertext)))
+	binary.BigEndian.PutUint16(value[0:2], uint16(len(nonce)))
+	binary.BigEndian.PutUint16(value[2:4], uint16(len(ciphertext)))
+	copy(value[4:], nonce)
+	copy(value[4+pad4(len(nonce)):], ciphertext)
+	return ntp.ExtensionField{Type: ntp.ExtensionNTSAuthenticator, Value: value}, nil
+}
+
+// openAuthenticator verifies NTS Authenticator and Encrypted Extension Fields field value and returns the plaintext
+func openAuthenticator(aead cipher.AEAD, ad, value []byte) ([]byte, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("authenticator of %d bytes is too short", len(value))
+	}
+	nonceLen := int(binary.BigEndian.Uint16(value[0:2]))
+	ciphertextLen := int(binary.BigEndian.Uint16(value[2:4]))
+	if 4+pad4(nonceLen)+pad4(ciphertextLen) > len(value) {
+		return nil, fmt.Errorf("authenticator lengths %d/%d exceed the field", nonceLen, ciphertextLen)
+	}
+	nonce := value[4 : 4+nonceLen]
+	ciphertext := value[4+pad4(nonceLen) : 4+pad4(nonceLen)+ciphertextLen]
+	return aead.Open(nil, nonce, ciphertext, ad)
+}
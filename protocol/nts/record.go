@@ -0,0 +1,141 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// NTS-KE record types, see RFC 8915 section 4
+const (
+	recordEndOfMessage    uint16 = 0
+	recordNextProtocol    uint16 = 1
+	recordError           uint16 = 2
+	recordWarning         uint16 = 3
+	recordAEAD            uint16 = 4
+	recordNewCookie       uint16 = 5
+	recordServer          uint16 = 6
+	recordPort            uint16 = 7
+	recordCriticalBit     uint16 = 0x8000
+	recordHeaderSizeBytes        = 4
+	maxRecordsPerMessage         = 1024
+)
+
+// NTS-KE error codes
+const (
+	ErrorUnrecognizedCriticalRecord uint16 = 0
+	ErrorBadRequest                 uint16 = 1
+	ErrorInternalServerError        uint16 = 2
+)
+
+// KEError is an error reported by NTS-KE server
+type KEError struct {
+	Code uint16
+}
+
+func (e *KEError) Error() string {
+	switch e.Code {
+	case ErrorUnrecognizedCriticalRecord:
+		return "NTS-KE error: unrecognized critical record"
+	case ErrorBadRequest:
+		return "NTS-KE error: bad request"
+	case ErrorInternalServerError:
+		return "NTS-KE error: internal server error"
+	}
+	return fmt.Sprintf("NTS-KE error %d", e.Code)
+}
+
+// record is a single NTS-KE record
+type record struct {
+	Critical bool
+	Type     uint16
+	Body     []byte
+}
+
+// Bytes returns wire representation of the record
+func (r *record) Bytes() []byte {
+	b := make([]byte, recordHeaderSizeBytes+len(r.Body))
+	t := r.Type
+	if r.Critical {
+		t |= recordCriticalBit
+	}
+	binary.BigEndian.PutUint16(b[0:2], t)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(r.Body)))
+	copy(b[recordHeaderSizeBytes:], r.Body)
+	return b
+}
+
+// uint16Body returns record body consisting of 16-bit values
+func uint16Body(values ...uint16) []byte {
+	b := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(b[2*i:], v)
+	}
+	return b
+}
+
+// uint16s parses record body consisting of 16-bit values
+func (r *record) uint16s() ([]uint16, error) {
+	if len(r.Body)%2 != 0 {
+		return nil, fmt.Errorf("record %d body of %d bytes is not a list of 16-bit values", r.Type, len(r.Body))
+	}
+	values := make([]uint16, len(r.Body)/2)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(r.Body[2*i:])
+	}
+	return values, nil
+}
+
+// writeMessage writes records followed by End of Message
+func writeMessage(w io.Writer, records []record) error {
+	buf := []byte{}
+	for _, r := range records {
+		buf = append(buf, r.Bytes()...)
+	}
+	eom := record{Critical: true, Type: recordEndOfMessage}
+	buf = append(buf, eom.Bytes()...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readMessage reads records until End of Message
+func readMessage(r *bufio.Reader) ([]record, error) {
+	var records []record
+	for len(records) < maxRecordsPerMessage {
+		var header [recordHeaderSizeBytes]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("failed to read NTS-KE record: %w", err)
+		}
+		t := binary.BigEndian.Uint16(header[0:2])
+		rec := record{
+			Critical: t&recordCriticalBit != 0,
+			Type:     t &^ recordCriticalBit,
+			Body:     make([]byte, binary.BigEndian.Uint16(header[2:4])),
+		}
+		if _, err := io.ReadFull(r, rec.Body); err != nil {
+			return nil, fmt.Errorf("failed to read NTS-KE record body: %w", err)
+		}
+		if rec.Type == recordEndOfMessage {
+			return records, nil
+		}
+		records = append(records, rec)
+	}
+	return nil, fmt.Errorf("NTS-KE message has more than %d records", maxRecordsPerMessage)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// ErrNotNTS is returned for requests without NTS extension fields.
+// Unique Identifier alone doesn't make the request NTS one, it can be used standalone
+var ErrNotNTS = errors.New("request has no NTS extension fields")
+
+// ServerRequest is NTS request decoded by the server
+type ServerRequest struct {
+	// UID is request's Unique Identifier the response has to echo
+	UID []byte
+	// cookies is how many fresh cookies the client asked for: one for the cookie it spent and one per placeholder
+	cookies int
+	cookie  *cookie
+	s2c     cipher.AEAD
+}
+
+// ParseRequest authenticates NTS request (header followed by extension fields) with the cookie it carries.
+// ErrInvalidCookie and ErrUnauthenticated come with the decoded request, so caller can reply with NTS NAK
+func (k *CookieKeys) ParseRequest(request []byte) (*ServerRequest, error) {
+	if len(request) < ntp.PacketSizeBytes {
+		return nil, fmt.Errorf("request of %d bytes is too short", len(request))
+	}
+	r := &ServerRequest{}
+	var cookieValue, auth []byte
+	var placeholders []int
+	var authOffset int
+	offset := ntp.PacketSizeBytes
+	for offset < len(request) && auth == nil {
+		field, length, err := ntp.NextExtensionField(request[offset:])
+		if err != nil {
+			return nil, err
+		}
+		switch field.Type {
+		case ntp.ExtensionUniqueIdentifier:
+			if r.UID != nil {
+				return nil, fmt.Errorf("duplicate unique identifier")
+			}
+			r.UID = field.Value
+		case ntp.ExtensionNTSCookie:
+			if cookieValue != nil {
+				return nil, fmt.Errorf("more than one NTS cookie")
+			}
+			cookieValue = field.Value
+		case ntp.ExtensionNTSCookiePlaceholder:
+			placeholders = append(placeholders, len(field.Value))
+		case ntp.ExtensionNTSAuthenticator:
+			// anything following the authenticator is not authenticated and is ignored
+			auth = field.Value
+			authOffset = offset
+		}
+		offset += length
+	}
+	if cookieValue == nil && auth == nil {
+		return nil, ErrNotNTS
+	}
+	if len(r.UID) < ntp.UniqueIdentifierSizeBytes {
+		return nil, fmt.Errorf("unique identifier of %d bytes is too short", len(r.UID))
+	}
+	if cookieValue == nil {
+		return r, ErrUnauthenticated
+	}
+	c, err := k.open(cookieValue)
+	if err != nil {
+		return r, err
+	}
+	alg := aeadAlgorithms[c.aead]
+	c2s, err := alg.new(c.c2sKey)
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil {
+		return r, ErrUnauthenticated
+	}
+	if _, err := openAuthenticator(c2s, request[:authOffset], auth); err != nil {
+		return r, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if r.s2c, err = alg.new(c.s2cKey); err != nil {
+		return nil, err
+	}
+	r.cookie = c
+	// placeholders of the wrong size would let responses be larger than requests
+	r.cookies = 1
+	for _, size := range placeholders {
+		if size == len(cookieValue) && r.cookies < MaxCookies {
+			r.cookies++
+		}
+	}
+	return r, nil
+}
+
+// Response appends to response header Unique Identifier and fresh cookies encrypted with session key
+func (r *ServerRequest) Response(keys *CookieKeys, header []byte) ([]byte, error) {
+	response := append([]byte{}, header...)
+	uidField := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: r.UID}
+	response = append(response, uidField.Bytes()...)
+	var plaintext []byte
+	for i := 0; i < r.cookies; i++ {
+		c, err := keys.seal(r.cookie)
+		if err != nil {
+			return nil, err
+		}
+		field := ntp.ExtensionField{Type: ntp.ExtensionNTSCookie, Value: c}
+		plaintext = append(plaintext, field.Bytes()...)
+	}
+	auth, err := sealAuthenticator(r.s2c, response, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(response, auth.Bytes()...), nil
+}
+
+// NAK turns response header into NTS NAK kiss-o'-death telling client to repeat key establishment
+func (r *ServerRequest) NAK(header []byte) []byte {
+	response := append([]byte{}, header...)
+	response[1] = 0
+	binary.BigEndian.PutUint32(response[12:16], kissNTSNAK)
+	uidField := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: r.UID}
+	return append(response, uidField.Bytes()...)
+}
@@ -0,0 +1,388 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/internal/fips"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer starts NTS-KE server and returns client TLS config trusting it
+func testServer(t *testing.T, keys *CookieKeys) (string, *tls.Config) {
+	return testServerWith(t, &KEServer{Keys: keys})
+}
+
+// testServerWith starts given NTS-KE server with test certificate
+func testServerWith(t *testing.T, s *KEServer) (string, *tls.Config) {
+	cert, pool := testCertificate(t)
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	go func() { _ = s.Serve(ln) }()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return net.JoinHostPort("localhost", port), &tls.Config{RootCAs: pool}
+}
+
+func TestCookieKeys(t *testing.T) {
+	keys, err := NewCookieKeys(2)
+	require.Nil(t, err)
+	c := &cookie{aead: AEADAESSIVCMAC256, c2sKey: make([]byte, 32), s2cKey: make([]byte, 32)}
+	c.s2cKey[0] = 1
+
+	b, err := keys.seal(c)
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(b)%4, "cookie must not need padding in extension field")
+	opened, err := keys.open(b)
+	require.Nil(t, err)
+	assert.Equal(t, c, opened)
+
+	// cookie stays valid after one rotation, but not after two
+	require.Nil(t, keys.Rotate())
+	_, err = keys.open(b)
+	assert.Nil(t, err)
+	require.Nil(t, keys.Rotate())
+	_, err = keys.open(b)
+	assert.Equal(t, ErrInvalidCookie, err)
+
+	b, err = keys.seal(c)
+	require.Nil(t, err)
+	b[len(b)-1] ^= 1
+	_, err = keys.open(b)
+	assert.Equal(t, ErrInvalidCookie, err)
+	_, err = keys.open(b[:3])
+	assert.Equal(t, ErrInvalidCookie, err)
+}
+
+func TestCookieKeysSet(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	c := &cookie{aead: AEADAESSIVCMAC256, c2sKey: make([]byte, 32), s2cKey: make([]byte, 32)}
+	random, err := keys.seal(c)
+	require.Nil(t, err)
+
+	first := MasterKey{ID: 1, Key: make([]byte, masterKeySize)}
+	require.Nil(t, keys.Set([]MasterKey{first}, 0))
+	_, err = keys.open(random)
+	assert.Equal(t, ErrInvalidCookie, err, "no overlap drops replaced keys right away")
+	b, err := keys.seal(c)
+	require.Nil(t, err)
+
+	second := MasterKey{ID: 2, Key: make([]byte, masterKeySize)}
+	second.Key[0] = 1
+	require.Nil(t, keys.Set([]MasterKey{second}, 50*time.Millisecond))
+	_, err = keys.open(b)
+	assert.Nil(t, err, "replaced key validates cookies during the overlap")
+	// another change doesn't extend the overlap of the key replaced before
+	require.Nil(t, keys.Set([]MasterKey{second, {ID: 3, Key: make([]byte, masterKeySize)}}, time.Hour))
+	time.Sleep(100 * time.Millisecond)
+	_, err = keys.open(b)
+	assert.Equal(t, ErrInvalidCookie, err)
+
+	assert.NotNil(t, keys.Set(nil, 0))
+	assert.NotNil(t, keys.Set([]MasterKey{second, second}, 0))
+	assert.NotNil(t, keys.Set([]MasterKey{{ID: 4, Key: []byte("short")}}, 0))
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	keys, err := ParseMasterKeys(strings.NewReader(`
+# current key goes first
+7 000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f
+6 ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff # previous
+`))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(keys))
+	assert.Equal(t, uint32(7), keys[0].ID)
+	assert.Equal(t, byte(0x1f), keys[0].Key[31])
+	assert.Equal(t, uint32(6), keys[1].ID)
+
+	for _, invalid := range []string{"7", "x 00", "7 0011", "7 zz"} {
+		_, err := ParseMasterKeys(strings.NewReader(invalid))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestKEServerRespond(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	s := &KEServer{Keys: keys}
+	errorRecord := func(code uint16) []record {
+		return []record{{Critical: true, Type: recordError, Body: uint16Body(code)}}
+	}
+
+	response, err := s.respond(tls.ConnectionState{}, []record{{Type: recordAEAD, Body: uint16Body(AEADAESSIVCMAC256)}})
+	assert.Nil(t, err)
+	assert.Equal(t, errorRecord(ErrorBadRequest), response)
+
+	response, err = s.respond(tls.ConnectionState{}, []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Critical: true, Type: 0x4242},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, errorRecord(ErrorUnrecognizedCriticalRecord), response)
+
+	response, err = s.respond(tls.ConnectionState{}, []record{{Critical: true, Type: recordNextProtocol, Body: uint16Body(0x8001)}})
+	assert.Nil(t, err)
+	assert.Equal(t, []record{{Critical: true, Type: recordNextProtocol}}, response)
+
+	// AEAD_AES_128_GCM is not suitable for NTS
+	response, err = s.respond(tls.ConnectionState{}, []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Type: recordAEAD, Body: uint16Body(1)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Critical: true, Type: recordAEAD},
+	}, response)
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	for _, aead := range DefaultAEADs {
+		t.Run(AEADName(aead), func(t *testing.T) {
+			testServerRoundTrip(t, aead)
+		})
+	}
+}
+
+func testServerRoundTrip(t *testing.T, aead uint16) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	addr, config := testServer(t, keys)
+	client := &Client{KEServer: addr, TLSConfig: config, Timeout: time.Second, AEADs: []uint16{aead}}
+
+	header := make([]byte, ntp.PacketSizeBytes)
+	header[0] = 0x23
+	for i := 0; i < 2*MaxCookies; i++ {
+		request, uid, err := client.NewRequest(header)
+		require.Nil(t, err)
+		assert.Equal(t, aead, client.session.AEAD)
+
+		req, err := keys.ParseRequest(request)
+		require.Nil(t, err)
+		assert.Equal(t, uid, req.UID)
+		// client asks for as many cookies as it needs to have MaxCookies again
+		assert.Equal(t, MaxCookies-client.session.Cookies(), req.cookies)
+
+		response, err := req.Response(keys, header)
+		require.Nil(t, err)
+		require.Nil(t, client.VerifyResponse(response, uid))
+		assert.Equal(t, MaxCookies, client.session.Cookies())
+		// rotation doesn't break sessions in progress
+		if i%MaxCookies == 0 {
+			require.Nil(t, keys.Rotate())
+		}
+	}
+}
+
+// temporaryError is what accept returns running out of file descriptors
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails to accept failures times before it starts accepting
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestKEServerTemporaryAcceptError(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	cert, pool := testCertificate(t)
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.Nil(t, err)
+	s := &KEServer{Keys: keys, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(&flakyListener{Listener: ln, failures: 3}) }()
+
+	// temporary errors don't stop the service
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	_, err = Dial(net.JoinHostPort("localhost", port), &tls.Config{RootCAs: pool}, time.Second)
+	require.Nil(t, err)
+
+	// closing the listener does
+	require.Nil(t, ln.Close())
+	select {
+	case err := <-served:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop with the listener closed")
+	}
+}
+
+func TestKEServerAEADs(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	addr, config := testServer(t, keys)
+
+	// server honors client's preference
+	session, err := Dial(addr, config, time.Second, AEADAES128GCMSIV, AEADAESSIVCMAC256)
+	require.Nil(t, err)
+	assert.Equal(t, AEADAES128GCMSIV, session.AEAD)
+	session, err = Dial(addr, config, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, DefaultAEADs[0], session.AEAD)
+
+	s := &KEServer{Keys: keys, AEADs: []uint16{AEADAESSIVCMAC256}}
+	response, err := s.respond(tls.ConnectionState{}, []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Type: recordAEAD, Body: uint16Body(AEADAES128GCMSIV)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Critical: true, Type: recordAEAD},
+	}, response)
+}
+
+func TestKEServerRedirect(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	addr, config := testServerWith(t, &KEServer{Keys: keys, NTPServer: "ntp.example.com", NTPPort: 1123})
+	session, err := Dial(addr, config, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, "ntp.example.com", session.Server)
+	assert.Equal(t, uint16(1123), session.Port)
+}
+
+func TestKEFIPS(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	addr, config := testServer(t, keys)
+	prev := fips.Enabled()
+	defer fips.Set(prev)
+	fips.Set(true)
+
+	// defaults are narrowed down to approved algorithms
+	session, err := Dial(addr, config, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, AEADAESSIVCMAC256, session.AEAD)
+	_, err = Dial(addr, config, time.Second, AEADAES128GCMSIV)
+	assert.ErrorIs(t, err, ErrNotApproved)
+	_, err = ParseAEAD("AES-128-GCM-SIV")
+	assert.ErrorIs(t, err, ErrNotApproved)
+
+	// server refuses to agree to unapproved algorithm
+	s := &KEServer{Keys: keys}
+	response, err := s.respond(tls.ConnectionState{}, []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Type: recordAEAD, Body: uint16Body(AEADAES128GCMSIV)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []record{
+		{Critical: true, Type: recordNextProtocol, Body: uint16Body(ProtocolNTPv4)},
+		{Critical: true, Type: recordAEAD},
+	}, response)
+
+	// cookies issued before FIPS mode was switched on are rejected
+	fips.Set(false)
+	b, err := keys.seal(&cookie{aead: AEADAES128GCMSIV, c2sKey: make([]byte, 16), s2cKey: make([]byte, 16)})
+	require.Nil(t, err)
+	fips.Set(true)
+	_, err = keys.open(b)
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+}
+
+func TestParseAEAD(t *testing.T) {
+	for aead := range aeadAlgorithms {
+		parsed, err := ParseAEAD(AEADName(aead))
+		require.Nil(t, err)
+		assert.Equal(t, aead, parsed)
+	}
+	aead, err := ParseAEAD("aes-128-gcm-siv")
+	require.Nil(t, err)
+	assert.Equal(t, AEADAES128GCMSIV, aead)
+	_, err = ParseAEAD("AES-128-GCM")
+	assert.NotNil(t, err)
+	assert.Equal(t, "AEAD(1)", AEADName(1))
+
+	aeads, err := ParseAEADs("AES-128-GCM-SIV, AES-SIV-CMAC-256")
+	require.Nil(t, err)
+	assert.Equal(t, []uint16{AEADAES128GCMSIV, AEADAESSIVCMAC256}, aeads)
+	aeads, err = ParseAEADs("")
+	require.Nil(t, err)
+	assert.Empty(t, aeads)
+	_, err = ParseAEADs("AES-SIV-CMAC-256,ChaCha20")
+	assert.NotNil(t, err)
+}
+
+func TestServerNAK(t *testing.T) {
+	keys, err := NewCookieKeys(1)
+	require.Nil(t, err)
+	addr, config := testServer(t, keys)
+	client := &Client{KEServer: addr, TLSConfig: config, Timeout: time.Second}
+	header := make([]byte, ntp.PacketSizeBytes)
+
+	request, uid, err := client.NewRequest(header)
+	require.Nil(t, err)
+	// cookie can't be decrypted with a new key
+	require.Nil(t, keys.Rotate())
+	req, err := keys.ParseRequest(request)
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+	assert.Equal(t, ErrNAK, client.VerifyResponse(req.NAK(header), uid))
+	assert.Nil(t, client.session)
+
+	// tampered request
+	request, _, err = client.NewRequest(header)
+	require.Nil(t, err)
+	request[0] ^= 1
+	req, err = keys.ParseRequest(request)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.NotNil(t, req)
+}
+
+func TestParseRequestNotNTS(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	header := make([]byte, ntp.PacketSizeBytes)
+	_, err = keys.ParseRequest(header)
+	assert.Equal(t, ErrNotNTS, err)
+
+	other := ntp.ExtensionField{Type: 0x2005, Value: make([]byte, 16)}
+	_, err = keys.ParseRequest(append(header, other.Bytes()...))
+	assert.Equal(t, ErrNotNTS, err)
+
+	uid, err := ntp.NewUniqueIdentifier()
+	require.Nil(t, err)
+	_, err = keys.ParseRequest(append(header, uid.Bytes()...))
+	assert.Equal(t, ErrNotNTS, err, "standalone unique identifier")
+
+	short := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: make([]byte, 8)}
+	cookie := ntp.ExtensionField{Type: ntp.ExtensionNTSCookie, Value: make([]byte, 64)}
+	_, err = keys.ParseRequest(append(append(header, short.Bytes()...), cookie.Bytes()...))
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrNotNTS, err)
+}
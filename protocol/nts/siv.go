@@ -0,0 +1,155 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/facebookincubator/ntp/internal/cmac"
+)
+
+// sivNonceSize is the nonce size we use with AES-SIV, RFC 8915 recommends 16 bytes
+const sivNonceSize = 16
+
+var errOpen = errors.New("message authentication failed")
+
+// siv implements AES-SIV, see RFC 5297
+type siv struct {
+	mac *cmac.CMAC
+	ctr cipher.Block
+}
+
+// newSIVCMAC returns AEAD_AES_SIV_CMAC_256 (or 384/512 for longer keys) AEAD
+func newSIVCMAC(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 && len(key) != 48 && len(key) != 64 {
+		return nil, fmt.Errorf("invalid AES-SIV key size %d", len(key))
+	}
+	s, err := newSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	return &sivAEAD{siv: s}, nil
+}
+
+func newSIV(key []byte) (*siv, error) {
+	// first half of the key is used for S2V, second one for CTR
+	mac, err := cmac.New(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &siv{mac: mac, ctr: ctr}, nil
+}
+
+// s2v computes synthetic IV over associated data components followed by plaintext
+func (s *siv) s2v(plaintext []byte, components [][]byte) [cmac.Size]byte {
+	var zero [cmac.Size]byte
+	d := s.mac.Sum(zero[:])
+	for _, c := range components {
+		sum := s.mac.Sum(c)
+		d = cmac.Dbl(d)
+		for i := range d {
+			d[i] ^= sum[i]
+		}
+	}
+	var t []byte
+	if len(plaintext) >= cmac.Size {
+		t = make([]byte, len(plaintext))
+		copy(t, plaintext)
+		for i := range d {
+			t[len(t)-cmac.Size+i] ^= d[i]
+		}
+	} else {
+		// short plaintext is padded with 10* and mixed with doubled D
+		d = cmac.Dbl(d)
+		for i := range plaintext {
+			d[i] ^= plaintext[i]
+		}
+		d[len(plaintext)] ^= 0x80
+		t = d[:]
+	}
+	return s.mac.Sum(t)
+}
+
+// ctrXOR encrypts or decrypts src with the counter derived from synthetic IV
+func (s *siv) ctrXOR(dst, src []byte, v [cmac.Size]byte) {
+	// bits 31 and 63 are cleared so implementations can use 32 or 64 bit counters
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+// seal appends V || C to dst
+func (s *siv) seal(dst, plaintext []byte, components ...[]byte) []byte {
+	v := s.s2v(plaintext, components)
+	ret, out := sliceForAppend(dst, cmac.Size+len(plaintext))
+	copy(out, v[:])
+	s.ctrXOR(out[cmac.Size:], plaintext, v)
+	return ret
+}
+
+// open verifies V || C and appends decrypted plaintext to dst
+func (s *siv) open(dst, ciphertext []byte, components ...[]byte) ([]byte, error) {
+	if len(ciphertext) < cmac.Size {
+		return nil, errOpen
+	}
+	var v [cmac.Size]byte
+	copy(v[:], ciphertext[:cmac.Size])
+	plaintext := make([]byte, len(ciphertext)-cmac.Size)
+	s.ctrXOR(plaintext, ciphertext[cmac.Size:], v)
+	expected := s.s2v(plaintext, components)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		return nil, errOpen
+	}
+	return append(dst, plaintext...), nil
+}
+
+// sivAEAD is cipher.AEAD interface to AES-SIV with associated data and nonce as S2V components
+type sivAEAD struct {
+	siv *siv
+}
+
+func (a *sivAEAD) NonceSize() int { return sivNonceSize }
+
+func (a *sivAEAD) Overhead() int { return cmac.Size }
+
+func (a *sivAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return a.siv.seal(dst, plaintext, additionalData, nonce)
+}
+
+func (a *sivAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return a.siv.open(dst, ciphertext, additionalData, nonce)
+}
+
+// sliceForAppend extends in by n bytes and returns the result along with the extension
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
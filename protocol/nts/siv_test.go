@@ -0,0 +1,69 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Deterministic authenticated encryption example from RFC 5297 appendix A.1
+func TestSIVVector(t *testing.T) {
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	ad, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext, _ := hex.DecodeString("112233445566778899aabbccddee")
+	s, err := newSIV(key)
+	assert.Nil(t, err)
+
+	ciphertext := s.seal(nil, plaintext, ad)
+	assert.Equal(t, "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c", hex.EncodeToString(ciphertext))
+
+	decrypted, err := s.open(nil, ciphertext, ad)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, decrypted)
+}
+
+func TestSIVAEAD(t *testing.T) {
+	key := make([]byte, 32)
+	aead, err := newSIVCMAC(key)
+	assert.Nil(t, err)
+	nonce := make([]byte, aead.NonceSize())
+	ad := []byte("associated data")
+
+	for _, plaintext := range [][]byte{{}, []byte("short"), []byte("exactly 16 bytes"), []byte("longer than a single AES block")} {
+		ciphertext := aead.Seal(nil, nonce, plaintext, ad)
+		assert.Equal(t, len(plaintext)+aead.Overhead(), len(ciphertext))
+		decrypted, err := aead.Open(nil, nonce, ciphertext, ad)
+		assert.Nil(t, err)
+		assert.Equal(t, string(plaintext), string(decrypted))
+
+		// any modification is detected
+		ciphertext[0] ^= 1
+		_, err = aead.Open(nil, nonce, ciphertext, ad)
+		assert.NotNil(t, err)
+		ciphertext[0] ^= 1
+		_, err = aead.Open(nil, nonce, ciphertext, []byte("other data"))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSIVInvalidKey(t *testing.T) {
+	_, err := newSIVCMAC(make([]byte, 16))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SessionState is what it takes to resume NTS session: keys established by NTS-KE, unused cookies
+// and NTP server they are bound to
+type SessionState struct {
+	AEAD    uint16
+	C2SKey  []byte
+	S2CKey  []byte
+	Cookies [][]byte
+	Server  string `json:",omitempty"`
+	Port    uint16 `json:",omitempty"`
+}
+
+// State returns the copy of session state which can be stored and resumed later
+func (s *Session) State() *SessionState {
+	cookies := make([][]byte, len(s.cookies))
+	copy(cookies, s.cookies)
+	return &SessionState{AEAD: s.AEAD, C2SKey: s.c2sKey, S2CKey: s.s2cKey, Cookies: cookies, Server: s.Server, Port: s.Port}
+}
+
+// Session resumes the session from its state
+func (st *SessionState) Session() (*Session, error) {
+	cookies := make([][]byte, len(st.Cookies))
+	copy(cookies, st.Cookies)
+	session, err := newSession(st.AEAD, st.C2SKey, st.S2CKey, cookies)
+	if err != nil {
+		return nil, err
+	}
+	session.Server, session.Port = st.Server, st.Port
+	return session, nil
+}
+
+// CookieStore keeps NTS sessions per NTS-KE server, so they outlive the Client
+type CookieStore interface {
+	// Load returns stored session state, nil if there is none
+	Load(server string) (*SessionState, error)
+	// Save replaces stored session state, nil state removes it
+	Save(server string, state *SessionState) error
+}
+
+// MemoryStore keeps sessions in memory. It is safe for concurrent use
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*SessionState
+}
+
+// NewMemoryStore returns empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]*SessionState{}}
+}
+
+// Load returns stored session state
+func (m *MemoryStore) Load(server string) (*SessionState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[server], nil
+}
+
+// Save stores session state
+func (m *MemoryStore) Save(server string, state *SessionState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state == nil {
+		delete(m.sessions, server)
+	} else {
+		m.sessions[server] = state
+	}
+	return nil
+}
+
+// FileStore keeps sessions on disk, one file per server in Dir.
+// Files hold session keys, so they are only readable by the owner
+type FileStore struct {
+	Dir string
+}
+
+// path returns the file session with the server is stored in
+func (f *FileStore) path(server string) string {
+	return filepath.Join(f.Dir, url.QueryEscape(server)+".json")
+}
+
+// Load reads stored session state
+func (f *FileStore) Load(server string) (*SessionState, error) {
+	data, err := ioutil.ReadFile(f.path(server))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &SessionState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Save writes session state, replacing the file atomically
+func (f *FileStore) Save(server string, state *SessionState) error {
+	path := f.path(server)
+	if state == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.Dir, ".session")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
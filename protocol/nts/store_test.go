@@ -0,0 +1,111 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionState(t *testing.T) {
+	session, err := newSession(AEADAESSIVCMAC256, make([]byte, 32), make([]byte, 32), [][]byte{[]byte("cookie")})
+	require.Nil(t, err)
+	session.Server, session.Port = "ntp.example.com", 1123
+	state := session.State()
+	resumed, err := state.Session()
+	require.Nil(t, err)
+	assert.Equal(t, 1, resumed.Cookies())
+	assert.Equal(t, "ntp.example.com", resumed.Server)
+	assert.Equal(t, uint16(1123), resumed.Port)
+
+	// spending cookie of the resumed session doesn't touch the state
+	_, _, err = resumed.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(state.Cookies))
+
+	state.AEAD = 42
+	_, err = state.Session()
+	assert.NotNil(t, err)
+}
+
+func testStore(t *testing.T, store CookieStore) {
+	state, err := store.Load("localhost:4460")
+	require.Nil(t, err)
+	assert.Nil(t, state)
+
+	saved := &SessionState{AEAD: AEADAESSIVCMAC256, C2SKey: []byte{1}, S2CKey: []byte{2}, Cookies: [][]byte{{3}, {4}}, Server: "ntp.example.com", Port: 1123}
+	require.Nil(t, store.Save("localhost:4460", saved))
+	state, err = store.Load("localhost:4460")
+	require.Nil(t, err)
+	assert.Equal(t, saved, state)
+	state, err = store.Load("[::1]:4460")
+	require.Nil(t, err)
+	assert.Nil(t, state)
+
+	require.Nil(t, store.Save("localhost:4460", nil))
+	require.Nil(t, store.Save("localhost:4460", nil))
+	state, err = store.Load("localhost:4460")
+	require.Nil(t, err)
+	assert.Nil(t, state)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nts")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := &FileStore{Dir: dir + "/sessions"}
+	testStore(t, store)
+
+	require.Nil(t, store.Save("localhost:4460", &SessionState{}))
+	info, err := os.Stat(store.path("localhost:4460"))
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestClientStore(t *testing.T) {
+	// KE server accepts single connection, so the second client has to resume the session
+	addr, config, sessions := testKEServer(t, testKEResponse([]byte("cookie1"), []byte("cookie2")))
+	store := NewMemoryStore()
+	c := &Client{KEServer: addr, TLSConfig: config, Timeout: time.Second, Store: store}
+	_, _, err := c.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	require.Nil(t, err)
+	<-sessions
+	state, err := store.Load(addr)
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("cookie2")}, state.Cookies, "spent cookie is not stored")
+
+	c = &Client{KEServer: addr, TLSConfig: config, Timeout: time.Second, Store: store}
+	request, _, err := c.NewRequest(make([]byte, ntp.PacketSizeBytes))
+	require.Nil(t, err)
+	fields, err := ntp.ParseExtensionFields(request[ntp.PacketSizeBytes:])
+	require.Nil(t, err)
+	// short test cookie is padded up to the minimal field size
+	assert.Equal(t, []byte("cookie2\x00\x00\x00\x00\x00"), fields[1].Value)
+	state, err = store.Load(addr)
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(state.Cookies))
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ErrPinMismatch is returned when NTS-KE server certificate matches none of the pins
+var ErrPinMismatch = errors.New("NTS-KE server certificate doesn't match any pin")
+
+// LoadCertPool reads PEM encoded CA certificates, e.g. of internal CA NTS-KE servers are issued by
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// SPKIHash returns SHA-256 of certificate's SubjectPublicKeyInfo, which is what PinSPKI pins.
+// Unlike certificate hash it survives certificate renewal with the same key
+func SPKIHash(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// ParsePin parses hex encoded SPKI hash, colons are allowed between bytes
+func ParsePin(s string) ([]byte, error) {
+	pin, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pin %q: %w", s, err)
+	}
+	if len(pin) != sha256.Size {
+		return nil, fmt.Errorf("pin %q is %d bytes instead of %d", s, len(pin), sha256.Size)
+	}
+	return pin, nil
+}
+
+// PinSPKI returns tls.Config VerifyConnection callback accepting servers whose certificate key matches one of the pins.
+// It runs on top of regular verification, set InsecureSkipVerify to rely on pins alone
+func PinSPKI(pins ...[]byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return ErrPinMismatch
+		}
+		hash := SPKIHash(state.PeerCertificates[0])
+		for _, pin := range pins {
+			if subtle.ConstantTimeCompare(hash, pin) == 1 {
+				return nil
+			}
+		}
+		return ErrPinMismatch
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nts
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePin(t *testing.T) {
+	hash := make([]byte, 32)
+	hash[31] = 0xab
+	pin, err := ParsePin(hex.EncodeToString(hash))
+	require.Nil(t, err)
+	assert.Equal(t, hash, pin)
+	pin, err = ParsePin("00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:AB")
+	require.Nil(t, err)
+	assert.Equal(t, hash, pin)
+
+	_, err = ParsePin("abcd")
+	assert.NotNil(t, err)
+	_, err = ParsePin("xyz")
+	assert.NotNil(t, err)
+}
+
+func TestPinSPKI(t *testing.T) {
+	keys, err := NewCookieKeys(0)
+	require.Nil(t, err)
+	cert, _ := testCertificate(t)
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	s := &KEServer{Keys: keys, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	go func() { _ = s.Serve(ln) }()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.Nil(t, err)
+
+	// server certificate is self-signed, pin alone identifies it
+	config := &tls.Config{ServerName: "localhost", InsecureSkipVerify: true, VerifyConnection: PinSPKI(make([]byte, 32), SPKIHash(parsed))}
+	session, err := Dial(ln.Addr().String(), config, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, MaxCookies, session.Cookies())
+
+	config.VerifyConnection = PinSPKI(make([]byte, 32))
+	_, err = Dial(ln.Addr().String(), config, time.Second)
+	assert.ErrorIs(t, err, ErrPinMismatch)
+}
+
+func TestLoadCertPool(t *testing.T) {
+	cert, _ := testCertificate(t)
+	f, err := ioutil.TempFile("", "ca")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	require.Nil(t, pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}))
+	f.Close()
+
+	pool, err := LoadCertPool(f.Name())
+	require.Nil(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.Nil(t, err)
+	_, err = parsed.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"})
+	assert.Nil(t, err)
+
+	require.Nil(t, ioutil.WriteFile(f.Name(), []byte("garbage"), 0600))
+	_, err = LoadCertPool(f.Name())
+	assert.NotNil(t, err)
+}
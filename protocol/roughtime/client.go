@@ -0,0 +1,102 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roughtime
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultPort is the port Roughtime servers commonly listen on
+const DefaultPort = 2002
+
+// maxResponseSize is the size of the largest response accepted
+const maxResponseSize = 4096
+
+// Server is Roughtime server along with its long term public key
+type Server struct {
+	// Addr is server host:port
+	Addr      string
+	PublicKey ed25519.PublicKey
+}
+
+// Response is verified server time along with the local times the request was sent and the response received
+type Response struct {
+	Time
+	Sent     time.Time
+	Received time.Time
+}
+
+// Offset returns offset of the local clock from the server one, assuming symmetric network delay
+func (r *Response) Offset() time.Duration {
+	return r.Midpoint.Sub(r.Sent.Add(r.Received.Sub(r.Sent) / 2))
+}
+
+// Uncertainty returns how far true offset may be from Offset: server radius widened by half of the round trip
+func (r *Response) Uncertainty() time.Duration {
+	return r.Radius + r.Received.Sub(r.Sent)/2
+}
+
+// Query sends request with random nonce to the server and verifies its response
+func (s *Server) Query(timeout time.Duration) (*Response, error) {
+	if len(s.PublicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key of %d bytes", len(s.PublicKey))
+	}
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	request, err := NewRequest(nonce)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("udp", s.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	sent := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxResponseSize)
+	var verifyErr error
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if verifyErr != nil {
+				return nil, verifyErr
+			}
+			return nil, err
+		}
+		received := time.Now()
+		t, err := VerifyResponse(buf[:n], nonce, s.PublicKey)
+		if err != nil {
+			// anybody can send us garbage, keep waiting for the real response until deadline
+			verifyErr = err
+			continue
+		}
+		return &Response{Time: *t, Sent: sent, Received: received}, nil
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package roughtime implements Roughtime client, coarse time with cryptographic proof of the server having said it.
+// The protocol is that of Google Roughtime, as served by roughtime.cloudflare.com and others
+package roughtime
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RequestSize is the size requests are padded to, so the server doesn't amplify traffic
+const RequestSize = 1024
+
+// NonceSize is the size of request nonce
+const NonceSize = 64
+
+// hashSize is the size of Merkle tree hashes, truncated SHA-512
+const hashSize = 64
+
+// Signature contexts, prepended to signed data
+const (
+	certContext     = "RoughTime v1 delegation signature--\x00"
+	responseContext = "RoughTime v1 response signature\x00"
+)
+
+// Tag identifies message value, it is four ASCII bytes read as little endian integer
+type Tag uint32
+
+func newTag(s string) Tag {
+	return Tag(binary.LittleEndian.Uint32([]byte(s)))
+}
+
+func (t Tag) String() string {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(t))
+	return string(bytes.TrimRight(b, "\x00\xff"))
+}
+
+// Message tags
+var (
+	TagSIG  = newTag("SIG\x00")
+	TagNONC = newTag("NONC")
+	TagDELE = newTag("DELE")
+	TagPATH = newTag("PATH")
+	TagRADI = newTag("RADI")
+	TagPUBK = newTag("PUBK")
+	TagMIDP = newTag("MIDP")
+	TagSREP = newTag("SREP")
+	TagMINT = newTag("MINT")
+	TagROOT = newTag("ROOT")
+	TagCERT = newTag("CERT")
+	TagMAXT = newTag("MAXT")
+	TagINDX = newTag("INDX")
+	TagPAD  = newTag("PAD\xff")
+)
+
+// ErrVerification is returned when response fails signature or Merkle proof verification
+var ErrVerification = errors.New("roughtime response verification failed")
+
+// Message is a Roughtime message: values identified by tags
+type Message map[Tag][]byte
+
+// Bytes encodes the message: number of tags, value offsets, tags in ascending order and values
+func (m Message) Bytes() []byte {
+	tags := make([]Tag, 0, len(m))
+	for tag := range m {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	// count, offsets of all values but the first one and tags
+	headerSize := 4
+	if len(tags) > 0 {
+		headerSize = 8 * len(tags)
+	}
+	header := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(header, uint32(len(tags)))
+	var values []byte
+	for i, tag := range tags {
+		if i > 0 {
+			binary.LittleEndian.PutUint32(header[4*i:], uint32(len(values)))
+		}
+		binary.LittleEndian.PutUint32(header[4*len(tags)+4*i:], uint32(tag))
+		values = append(values, m[tag]...)
+	}
+	return append(header, values...)
+}
+
+// ParseMessage decodes the message, checking tags are ascending and values are in bounds and 4 byte aligned
+func ParseMessage(b []byte) (Message, error) {
+	if len(b) < 4 || len(b)%4 != 0 {
+		return nil, fmt.Errorf("invalid message length %d", len(b))
+	}
+	n := int(binary.LittleEndian.Uint32(b))
+	if n == 0 {
+		return Message{}, nil
+	}
+	if n > len(b)/8 {
+		return nil, fmt.Errorf("message of %d bytes can't have %d tags", len(b), n)
+	}
+	values := b[8*n:]
+	offsets := make([]int, n+1)
+	for i := 1; i < n; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	offsets[n] = len(values)
+	m := Message{}
+	var last Tag
+	for i := 0; i < n; i++ {
+		tag := Tag(binary.LittleEndian.Uint32(b[4*n+4*i:]))
+		if i > 0 && tag <= last {
+			return nil, fmt.Errorf("tag %v out of order", tag)
+		}
+		last = tag
+		start, end := offsets[i], offsets[i+1]
+		if start%4 != 0 || start > end || end > len(values) {
+			return nil, fmt.Errorf("invalid offset of tag %v", tag)
+		}
+		m[tag] = values[start:end]
+	}
+	return m, nil
+}
+
+// get returns the value, checking its size if size is not 0
+func (m Message) get(tag Tag, size int) ([]byte, error) {
+	v, ok := m[tag]
+	if !ok {
+		return nil, fmt.Errorf("missing %v", tag)
+	}
+	if size != 0 && len(v) != size {
+		return nil, fmt.Errorf("%v of %d bytes, want %d", tag, len(v), size)
+	}
+	return v, nil
+}
+
+// message returns nested message
+func (m Message) message(tag Tag) (Message, []byte, error) {
+	v, err := m.get(tag, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	nested, err := ParseMessage(v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %w", tag, err)
+	}
+	return nested, v, nil
+}
+
+// NewRequest returns request with the nonce, padded to RequestSize
+func NewRequest(nonce []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		return nil, fmt.Errorf("nonce of %d bytes, want %d", len(nonce), NonceSize)
+	}
+	// header of two tags is 16 bytes
+	return Message{TagNONC: nonce, TagPAD: make([]byte, RequestSize-16-NonceSize)}.Bytes(), nil
+}
+
+// Time is the time attested by the server: true time is within Radius of Midpoint
+type Time struct {
+	Midpoint time.Time
+	Radius   time.Duration
+}
+
+// microseconds converts microseconds since Unix epoch
+func microseconds(b []byte) time.Time {
+	us := binary.LittleEndian.Uint64(b)
+	return time.Unix(int64(us/1e6), int64(us%1e6)*1e3)
+}
+
+// VerifyResponse checks the response is signed by the server with the long term key and answers the request with the nonce
+func VerifyResponse(response, nonce []byte, rootKey ed25519.PublicKey) (*Time, error) {
+	m, err := ParseMessage(response)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, _, err := m.message(TagCERT)
+	if err != nil {
+		return nil, err
+	}
+	dele, deleBytes, err := cert.message(TagDELE)
+	if err != nil {
+		return nil, err
+	}
+	certSig, err := cert.get(TagSIG, ed25519.SignatureSize)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(rootKey, append([]byte(certContext), deleBytes...), certSig) {
+		return nil, fmt.Errorf("%w: bad delegation signature", ErrVerification)
+	}
+	pubKey, err := dele.get(TagPUBK, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	minT, err := dele.get(TagMINT, 8)
+	if err != nil {
+		return nil, err
+	}
+	maxT, err := dele.get(TagMAXT, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	srep, srepBytes, err := m.message(TagSREP)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := m.get(TagSIG, ed25519.SignatureSize)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pubKey, append([]byte(responseContext), srepBytes...), sig) {
+		return nil, fmt.Errorf("%w: bad response signature", ErrVerification)
+	}
+
+	root, err := srep.get(TagROOT, hashSize)
+	if err != nil {
+		return nil, err
+	}
+	index, err := m.get(TagINDX, 4)
+	if err != nil {
+		return nil, err
+	}
+	path, err := m.get(TagPATH, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(merkleRoot(nonce, binary.LittleEndian.Uint32(index), path), root) {
+		return nil, fmt.Errorf("%w: nonce is not in Merkle tree", ErrVerification)
+	}
+
+	midp, err := srep.get(TagMIDP, 8)
+	if err != nil {
+		return nil, err
+	}
+	radi, err := srep.get(TagRADI, 4)
+	if err != nil {
+		return nil, err
+	}
+	t := &Time{
+		Midpoint: microseconds(midp),
+		Radius:   time.Duration(binary.LittleEndian.Uint32(radi)) * time.Microsecond,
+	}
+	if t.Midpoint.Before(microseconds(minT)) || t.Midpoint.After(microseconds(maxT)) {
+		return nil, fmt.Errorf("%w: midpoint %v outside of delegation validity", ErrVerification, t.Midpoint)
+	}
+	return t, nil
+}
+
+// merkleRoot returns root of the Merkle tree the path proves the nonce at the index is in
+func merkleRoot(nonce []byte, index uint32, path []byte) []byte {
+	h := hashLeaf(nonce)
+	for len(path) >= hashSize {
+		if index&1 == 0 {
+			h = hashNode(h, path[:hashSize])
+		} else {
+			h = hashNode(path[:hashSize], h)
+		}
+		index >>= 1
+		path = path[hashSize:]
+	}
+	return h
+}
+
+func hashLeaf(leaf []byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{0})
+	h.Write(leaf)
+	return h.Sum(nil)[:hashSize]
+}
+
+func hashNode(left, right []byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)[:hashSize]
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package roughtime
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// testResponse answers requests with the nonces, batched into one Merkle tree, as server with the root key would
+func testResponse(t *testing.T, rootKey ed25519.PrivateKey, nonces [][]byte, index int, midpoint time.Time) []byte {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	dele := Message{
+		TagPUBK: pub,
+		TagMINT: uint64Bytes(0),
+		TagMAXT: uint64Bytes(^uint64(0)),
+	}.Bytes()
+	cert := Message{
+		TagDELE: dele,
+		TagSIG:  ed25519.Sign(rootKey, append([]byte(certContext), dele...)),
+	}.Bytes()
+
+	var root, path []byte
+	switch len(nonces) {
+	case 1:
+		root = hashLeaf(nonces[0])
+	case 2:
+		root = hashNode(hashLeaf(nonces[0]), hashLeaf(nonces[1]))
+		path = hashLeaf(nonces[1-index])
+	}
+	srep := Message{
+		TagROOT: root,
+		TagMIDP: uint64Bytes(uint64(midpoint.UnixNano() / 1e3)),
+		TagRADI: uint32Bytes(1000000),
+	}.Bytes()
+	return Message{
+		TagSIG:  ed25519.Sign(priv, append([]byte(responseContext), srep...)),
+		TagPATH: path,
+		TagSREP: srep,
+		TagCERT: cert,
+		TagINDX: uint32Bytes(uint32(index)),
+	}.Bytes()
+}
+
+func nonce(b byte) []byte {
+	n := make([]byte, NonceSize)
+	n[0] = b
+	return n
+}
+
+func TestMessage(t *testing.T) {
+	m := Message{TagNONC: []byte("abcd"), TagPAD: []byte("efghijkl"), TagMIDP: nil}
+	b := m.Bytes()
+	assert.Equal(t, uint32(3), binary.LittleEndian.Uint32(b))
+	parsed, err := ParseMessage(b)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("abcd"), parsed[TagNONC])
+	assert.Equal(t, []byte("efghijkl"), parsed[TagPAD])
+	assert.Empty(t, parsed[TagMIDP])
+
+	empty, err := ParseMessage(Message{}.Bytes())
+	require.Nil(t, err)
+	assert.Empty(t, empty)
+
+	_, err = ParseMessage(b[:12])
+	assert.NotNil(t, err)
+	_, err = ParseMessage([]byte{2, 0, 0, 0})
+	assert.NotNil(t, err)
+	assert.Equal(t, "NONC", TagNONC.String())
+	assert.Equal(t, "PAD", TagPAD.String())
+}
+
+func TestNewRequest(t *testing.T) {
+	request, err := NewRequest(nonce(1))
+	require.Nil(t, err)
+	assert.Len(t, request, RequestSize)
+	m, err := ParseMessage(request)
+	require.Nil(t, err)
+	assert.Equal(t, nonce(1), m[TagNONC])
+
+	_, err = NewRequest([]byte("short"))
+	assert.NotNil(t, err)
+}
+
+func TestVerifyResponse(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	midpoint := time.Unix(1600000000, 123000)
+
+	got, err := VerifyResponse(testResponse(t, priv, [][]byte{nonce(1)}, 0, midpoint), nonce(1), pub)
+	require.Nil(t, err)
+	assert.True(t, midpoint.Equal(got.Midpoint))
+	assert.Equal(t, time.Second, got.Radius)
+
+	batched := [][]byte{nonce(1), nonce(2)}
+	for index := range batched {
+		got, err = VerifyResponse(testResponse(t, priv, batched, index, midpoint), batched[index], pub)
+		require.Nil(t, err)
+		assert.True(t, midpoint.Equal(got.Midpoint))
+	}
+
+	_, err = VerifyResponse(testResponse(t, priv, [][]byte{nonce(1)}, 0, midpoint), nonce(2), pub)
+	assert.ErrorIs(t, err, ErrVerification, "response to another request")
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	_, err = VerifyResponse(testResponse(t, priv, [][]byte{nonce(1)}, 0, midpoint), nonce(1), otherPub)
+	assert.ErrorIs(t, err, ErrVerification, "response signed by another server")
+}
+
+func TestQuery(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, RequestSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := ParseMessage(buf[:n])
+		if err != nil {
+			return
+		}
+		// garbage first, it must not stop the client from taking the response
+		_, _ = conn.WriteTo([]byte("garbage"), addr)
+		response := testResponse(t, priv, [][]byte{request[TagNONC]}, 0, time.Now().Add(time.Minute))
+		_, _ = conn.WriteTo(response, addr)
+	}()
+
+	s := &Server{Addr: conn.LocalAddr().String(), PublicKey: pub}
+	r, err := s.Query(time.Second)
+	require.Nil(t, err)
+	assert.InDelta(t, float64(time.Minute), float64(r.Offset()), float64(time.Second))
+	assert.Equal(t, time.Second+r.Received.Sub(r.Sent)/2, r.Uncertainty())
+}
@@ -0,0 +1,291 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+)
+
+// Built-in driver names
+const (
+	DriverNMEA = "nmea"
+	DriverUBX  = "ubx"
+	DriverPPS  = "pps"
+	DriverGPSD = "gpsd"
+	DriverSHM  = "shm"
+	DriverPHC  = "phc"
+)
+
+// Defaults of built-in driver options
+const (
+	DefaultNMEABaud   = 4800
+	DefaultUBXBaud    = 9600
+	DefaultPPSTimeout = 2 * time.Second
+)
+
+func init() {
+	Register(DriverNMEA, newNMEADriver)
+	Register(DriverUBX, newUBXDriver)
+	Register(DriverPPS, newPPSDriver)
+	Register(DriverGPSD, newGPSDDriver)
+	Register(DriverSHM, newSHMDriver)
+	Register(DriverPHC, newPHCDriver)
+}
+
+var errNotStarted = errors.New("refclock is not started")
+
+// driver adapts built-in drivers, which read a sample at a time, to RefClock
+type driver struct {
+	open  func() (io.Closer, error)
+	read  func() (*Sample, error)
+	close io.Closer
+
+	mu      sync.Mutex
+	samples []*Sample
+}
+
+// Start opens the device
+func (d *driver) Start() error {
+	closer, err := d.open()
+	if err != nil {
+		return err
+	}
+	d.close = closer
+	return nil
+}
+
+// Poll waits for the next sample and keeps it
+func (d *driver) Poll() error {
+	if d.read == nil {
+		return errNotStarted
+	}
+	s, err := d.read()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.samples = append(d.samples, s)
+	return nil
+}
+
+// Samples returns samples kept since the last call
+func (d *driver) Samples() []*Sample {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	samples := d.samples
+	d.samples = nil
+	return samples
+}
+
+// Stop closes the device
+func (d *driver) Stop() error {
+	if d.close == nil {
+		return nil
+	}
+	return d.close.Close()
+}
+
+// intOption returns integer option, def if it's not set
+func intOption(c *Config, name string, def int) (int, error) {
+	v, ok := c.Options[name]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("malformed option %s=%q: %w", name, v, err)
+	}
+	return i, nil
+}
+
+// boolOption returns boolean option, false if it's not set
+func boolOption(c *Config, name string) (bool, error) {
+	v, ok := c.Options[name]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("malformed option %s=%q: %w", name, v, err)
+	}
+	return b, nil
+}
+
+// newNMEADriver reads serial device at "baud" rate
+func newNMEADriver(c *Config) (RefClock, error) {
+	baud, err := intOption(c, "baud", DefaultNMEABaud)
+	if err != nil {
+		return nil, err
+	}
+	d := &driver{}
+	d.open = func() (io.Closer, error) {
+		f, err := OpenSerial(c.Device, baud)
+		if err != nil {
+			return nil, err
+		}
+		n := NewNMEA(f)
+		n.Fudge = c.Fudge
+		d.read = n.Read
+		return f, nil
+	}
+	return d, nil
+}
+
+// newUBXDriver reads serial device at "baud" rate, enabling UBX messages it needs
+func newUBXDriver(c *Config) (RefClock, error) {
+	baud, err := intOption(c, "baud", DefaultUBXBaud)
+	if err != nil {
+		return nil, err
+	}
+	d := &driver{}
+	d.open = func() (io.Closer, error) {
+		f, err := OpenSerial(c.Device, baud)
+		if err != nil {
+			return nil, err
+		}
+		if err := EnableUBX(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		u := NewUBX(f)
+		u.Fudge = c.Fudge
+		d.read = u.Read
+		return f, nil
+	}
+	return d, nil
+}
+
+// newPPSDriver captures falling edges if "clear" is set
+func newPPSDriver(c *Config) (RefClock, error) {
+	clear, err := boolOption(c, "clear")
+	if err != nil {
+		return nil, err
+	}
+	d := &driver{}
+	d.open = func() (io.Closer, error) {
+		p, err := OpenPPS(c.Device)
+		if err != nil {
+			return nil, err
+		}
+		p.Fudge = c.Fudge
+		p.Clear = clear
+		d.read = func() (*Sample, error) {
+			return p.Read(DefaultPPSTimeout)
+		}
+		return p, nil
+	}
+	return d, nil
+}
+
+// newGPSDDriver connects to gpsd at Device, DefaultGPSDAddr if not set, and keeps PPS samples if "pps" is set,
+// TPV ones otherwise
+func newGPSDDriver(c *Config) (RefClock, error) {
+	pps, err := boolOption(c, "pps")
+	if err != nil {
+		return nil, err
+	}
+	addr := c.Device
+	if addr == "" {
+		addr = DefaultGPSDAddr
+	}
+	d := &driver{}
+	d.open = func() (io.Closer, error) {
+		g, err := DialGPSD(addr)
+		if err != nil {
+			return nil, err
+		}
+		if pps {
+			g.PPSFudge = c.Fudge
+		} else {
+			g.Fudge = c.Fudge
+		}
+		d.read = func() (*Sample, error) {
+			for {
+				s, isPPS, err := g.Read()
+				if err != nil || isPPS == pps {
+					return s, err
+				}
+			}
+		}
+		return g, nil
+	}
+	return d, nil
+}
+
+// shmPollInterval is how often SHM unit is checked for a new sample
+const shmPollInterval = 100 * time.Millisecond
+
+// newSHMDriver reads SHM unit numbered Device
+func newSHMDriver(c *Config) (RefClock, error) {
+	unit, err := strconv.Atoi(c.Device)
+	if err != nil {
+		return nil, fmt.Errorf("malformed SHM unit %q: %w", c.Device, err)
+	}
+	d := &driver{}
+	d.open = func() (io.Closer, error) {
+		s, err := OpenSHM(unit)
+		if err != nil {
+			return nil, err
+		}
+		s.Fudge = c.Fudge
+		d.read = func() (*Sample, error) {
+			for {
+				sample, err := s.Read()
+				if err != ErrNoSample {
+					return sample, err
+				}
+				time.Sleep(shmPollInterval)
+			}
+		}
+		return s, nil
+	}
+	return d, nil
+}
+
+// newPHCDriver reads PHC device "samples" times per sample, subtracting "utcoffset" seconds
+func newPHCDriver(c *Config) (RefClock, error) {
+	samples, err := intOption(c, "samples", DefaultPHCSamples)
+	if err != nil {
+		return nil, err
+	}
+	utcOffset, err := intOption(c, "utcoffset", 0)
+	if err != nil {
+		return nil, err
+	}
+	d := &driver{}
+	d.open = func() (io.Closer, error) {
+		phc, err := clock.OpenPHC(c.Device)
+		if err != nil {
+			return nil, err
+		}
+		p := NewPHC(phc)
+		p.Samples = samples
+		p.UTCOffset = utcOffset
+		p.Fudge = c.Fudge
+		d.read = p.Read
+		return phc, nil
+	}
+	return d, nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"time"
+)
+
+// Fudge calibrates samples of a driver, like fudge command of ntpd does. Zero value leaves samples as they are
+type Fudge struct {
+	// Offset is added to reference time to correct constant error of the reference, e.g. antenna cable delay
+	Offset time.Duration
+	// Delay is how late local timestamp is taken after the reference event: serial sentences are sent well
+	// after the second they describe begins, pulses are timestamped after interrupt latency
+	Delay time.Duration
+	// MinDispersion is the least dispersion of samples, for drivers more confident than they should be
+	MinDispersion time.Duration
+}
+
+// Apply returns calibrated copy of the sample
+func (f *Fudge) Apply(s *Sample) *Sample {
+	calibrated := &Sample{
+		Received:   s.Received.Add(-f.Delay),
+		Reference:  s.Reference.Add(f.Offset),
+		Dispersion: s.Dispersion,
+	}
+	if calibrated.Dispersion < f.MinDispersion {
+		calibrated.Dispersion = f.MinDispersion
+	}
+	return calibrated
+}
@@ -0,0 +1,42 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFudgeApply(t *testing.T) {
+	s := &Sample{Received: received, Reference: received, Dispersion: time.Millisecond}
+	f := Fudge{}
+	assert.Equal(t, s, f.Apply(s))
+
+	f = Fudge{Offset: time.Microsecond, Delay: 200 * time.Millisecond, MinDispersion: 5 * time.Millisecond}
+	calibrated := f.Apply(s)
+	assert.Equal(t, received.Add(-200*time.Millisecond), calibrated.Received)
+	assert.Equal(t, received.Add(time.Microsecond), calibrated.Reference)
+	assert.Equal(t, 200*time.Millisecond+time.Microsecond, calibrated.Offset())
+	assert.Equal(t, 5*time.Millisecond, calibrated.Dispersion)
+	// sample itself is left alone
+	assert.Equal(t, received, s.Received)
+
+	f.MinDispersion = 0
+	assert.Equal(t, time.Millisecond, f.Apply(s).Dispersion)
+}
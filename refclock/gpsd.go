@@ -0,0 +1,116 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DefaultGPSDAddr is where gpsd listens by default
+const DefaultGPSDAddr = "localhost:2947"
+
+// gpsdWatch asks gpsd to stream JSON reports including PPS ones
+const gpsdWatch = "?WATCH={\"enable\":true,\"json\":true,\"pps\":true};\n"
+
+// gpsdReport holds the fields of TPV and PPS reports we use
+type gpsdReport struct {
+	Class string `json:"class"`
+	// TPV
+	Mode int    `json:"mode"`
+	Time string `json:"time"`
+	// PPS
+	RealSec   int64 `json:"real_sec"`
+	RealNsec  int64 `json:"real_nsec"`
+	ClockSec  int64 `json:"clock_sec"`
+	ClockNsec int64 `json:"clock_nsec"`
+}
+
+// GPSD reads time from receivers managed by gpsd over its JSON protocol: TPV reports carry time of the fix,
+// PPS reports carry pulse edges gpsd captured along with the local time of capture
+type GPSD struct {
+	// Fudge calibrates TPV samples, which arrive some time after the second they describe, like NMEA does
+	Fudge Fudge
+	// PPSFudge calibrates PPS samples
+	PPSFudge Fudge
+
+	conn io.Closer
+	r    *bufio.Reader
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// DialGPSD connects to gpsd at host:port and starts watching its devices
+func DialGPSD(addr string) (*GPSD, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gpsd at %s: %w", addr, err)
+	}
+	if _, err := io.WriteString(conn, gpsdWatch); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to watch gpsd: %w", err)
+	}
+	g := newGPSD(conn)
+	g.conn = conn
+	return g, nil
+}
+
+func newGPSD(r io.Reader) *GPSD {
+	return &GPSD{r: bufio.NewReader(r), now: time.Now}
+}
+
+// Close closes connection to gpsd
+func (g *GPSD) Close() error {
+	return g.conn.Close()
+}
+
+// Read returns sample from the next TPV report with a fix or the next PPS report, skipping the rest.
+// pps tells which one it is: PPS sample is precise, but only good once the clock is within half a second
+func (g *GPSD) Read() (sample *Sample, pps bool, err error) {
+	for {
+		line, err := g.r.ReadBytes('\n')
+		if err != nil {
+			return nil, false, err
+		}
+		received := g.now()
+		var report gpsdReport
+		if err := json.Unmarshal(line, &report); err != nil {
+			return nil, false, fmt.Errorf("malformed gpsd report: %w", err)
+		}
+		switch report.Class {
+		case "TPV":
+			// mode 2 and 3 are 2D and 3D fix
+			if report.Mode < 2 || report.Time == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339Nano, report.Time)
+			if err != nil {
+				return nil, false, fmt.Errorf("malformed TPV time %q: %w", report.Time, err)
+			}
+			return g.Fudge.Apply(&Sample{Received: received, Reference: t}), false, nil
+		case "PPS":
+			return g.PPSFudge.Apply(&Sample{
+				Received:  time.Unix(report.ClockSec, report.ClockNsec),
+				Reference: time.Unix(report.RealSec, report.RealNsec),
+			}), true, nil
+		}
+	}
+}
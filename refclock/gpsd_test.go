@@ -0,0 +1,88 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGPSDRead(t *testing.T) {
+	input := strings.Join([]string{
+		`{"class":"VERSION","release":"3.22","rev":"3.22","proto_major":3,"proto_minor":14}`,
+		`{"class":"TPV","device":"/dev/ttyACM0","mode":1}`,
+		`{"class":"TPV","device":"/dev/ttyACM0","mode":3,"time":"2021-03-04T11:59:59.000Z","lat":48.1,"lon":11.5}`,
+		`{"class":"SKY","device":"/dev/ttyACM0","satellites":[]}`,
+		`{"class":"PPS","device":"/dev/pps0","real_sec":1614859200,"real_nsec":0,"clock_sec":1614859199,"clock_nsec":999999700,"precision":-20}`,
+	}, "\n") + "\n"
+	g := newGPSD(strings.NewReader(input))
+	g.Fudge.Offset = 100 * time.Millisecond
+	g.now = func() time.Time { return received }
+
+	s, pps, err := g.Read()
+	require.Nil(t, err)
+	assert.False(t, pps)
+	assert.Equal(t, received, s.Received)
+	assert.Equal(t, time.Date(2021, 3, 4, 11, 59, 59, 100000000, time.UTC), s.Reference.UTC())
+
+	s, pps, err = g.Read()
+	require.Nil(t, err)
+	assert.True(t, pps)
+	assert.Equal(t, received, s.Reference.UTC())
+	assert.Equal(t, 300*time.Nanosecond, s.Offset())
+
+	_, _, err = g.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestGPSDMalformed(t *testing.T) {
+	g := newGPSD(strings.NewReader("{\"class\":\n"))
+	_, _, err := g.Read()
+	assert.NotNil(t, err)
+}
+
+func TestDialGPSD(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	watch := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 128)
+		n, _ := conn.Read(buf)
+		watch <- string(buf[:n])
+		_, _ = io.WriteString(conn, `{"class":"TPV","mode":2,"time":"2021-03-04T12:00:00.000Z"}`+"\n")
+	}()
+
+	g, err := DialGPSD(ln.Addr().String())
+	require.Nil(t, err)
+	defer g.Close()
+	assert.Equal(t, gpsdWatch, <-watch)
+	s, _, err := g.Read()
+	require.Nil(t, err)
+	assert.Equal(t, received, s.Reference.UTC())
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/events"
+	"github.com/facebookincubator/ntp/internal/ring"
+	"github.com/facebookincubator/ntp/logging"
+)
+
+// Health thresholds used when Monitor has none set
+const (
+	// DefaultHealthWindow is how far back samples are judged
+	DefaultHealthWindow = 64 * time.Second
+	// DefaultMinRate is half the rate of drivers sampling once a second
+	DefaultMinRate = 0.5
+	// DefaultMaxAge is how old the last sample may be
+	DefaultMaxAge = 8 * time.Second
+	// DefaultMaxSpread is how far apart offsets of samples may be, generous enough for serial sentences
+	DefaultMaxSpread = 100 * time.Millisecond
+)
+
+// MaxHealthSamples is how many latest samples Monitor keeps. Drivers sampling faster than that over the window
+// are judged by the latest ones, their rate is estimated from the time those span
+const MaxHealthSamples = 1024
+
+// Health of a driver as of some instant
+type Health struct {
+	// RefID of the driver
+	RefID string
+	// Reachable is false for unhealthy drivers, Reason tells why
+	Reachable bool
+	Reason    string
+	// Rate is samples per second over the window
+	Rate float64
+	// Spread is the difference between the largest and the smallest offset over the window
+	Spread time.Duration
+	// Age is time since the last sample
+	Age time.Duration
+	// Offset of the last sample
+	Offset time.Duration
+}
+
+// Variables returns health in the form of ntpd peer variables
+func (h *Health) Variables() map[string]string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 6, 64)
+	}
+	reach := "0"
+	if h.Reachable {
+		reach = "1"
+	}
+	return map[string]string{
+		"refid":  h.RefID,
+		"reach":  reach,
+		"rate":   strconv.FormatFloat(h.Rate, 'f', 3, 64),
+		"jitter": ms(h.Spread),
+		"age":    strconv.FormatFloat(h.Age.Seconds(), 'f', 3, 64),
+		"offset": ms(h.Offset),
+	}
+}
+
+type monitoredSample struct {
+	received time.Time
+	offset   time.Duration
+}
+
+// Monitor judges health of a driver by its samples: too old, too rare or too spread out samples mark it unreachable.
+// It is safe for concurrent use
+type Monitor struct {
+	// RefID of the driver, e.g. NMEARefID
+	RefID string
+	// Window is DefaultHealthWindow if not set
+	Window time.Duration
+	// MinRate in samples per second is DefaultMinRate if not set
+	MinRate float64
+	// MaxAge is DefaultMaxAge if not set
+	MaxAge time.Duration
+	// MaxSpread is DefaultMaxSpread if not set
+	MaxSpread time.Duration
+	// Events receives the driver becoming unreachable, which deselects it, and reachable again. May be nil
+	Events *events.Bus
+	// Logger receives changes of reachability, slog.Default() if nil
+	Logger logging.Logger
+
+	mu        sync.Mutex
+	started   time.Time
+	samples   ring.Ring[monitoredSample]
+	reachable bool
+}
+
+// NewMonitor returns monitor of the driver with the given reference ID and default thresholds
+func NewMonitor(refID string) *Monitor {
+	return &Monitor{RefID: refID}
+}
+
+func (m *Monitor) window() time.Duration {
+	if m.Window == 0 {
+		return DefaultHealthWindow
+	}
+	return m.Window
+}
+
+// Add records the sample
+func (m *Monitor) Add(s *Sample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started.IsZero() {
+		m.started = s.Received
+	}
+	if m.samples.Cap() == 0 {
+		m.samples.Init(MaxHealthSamples)
+	}
+	m.samples.Push(monitoredSample{received: s.Received, offset: s.Offset()})
+	m.expire(s.Received)
+}
+
+// expire drops samples which left the window
+func (m *Monitor) expire(now time.Time) {
+	begin := now.Add(-m.window())
+	i := 0
+	for i < m.samples.Len() && m.samples.At(i).received.Before(begin) {
+		i++
+	}
+	m.samples.Drop(i)
+}
+
+// Health returns health of the driver as of now. Changes of reachability are logged and published
+func (m *Monitor) Health(now time.Time) *Health {
+	// the event is published once the lock is released
+	var change *events.Event
+	defer func() {
+		if change != nil {
+			m.Events.Publish(*change)
+		}
+	}()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+	h := &Health{RefID: m.RefID}
+	h.Reason = m.judge(now, h)
+	h.Reachable = h.Reason == ""
+	if h.Reachable != m.reachable {
+		if h.Reachable {
+			logging.Or(m.Logger).Info("Refclock is reachable", "refid", m.RefID)
+			change = &events.Event{Time: now, Kind: events.SourceSelected, Source: m.RefID}
+		} else {
+			logging.Or(m.Logger).Warn("Refclock is unreachable", "refid", m.RefID, "reason", h.Reason)
+			change = &events.Event{Time: now, Kind: events.SourceDeselected, Source: m.RefID, Reason: h.Reason}
+		}
+		m.reachable = h.Reachable
+	}
+	return h
+}
+
+// judge fills in health stats and returns why the driver is unhealthy, empty if it's not
+func (m *Monitor) judge(now time.Time, h *Health) string {
+	n := m.samples.Len()
+	if n == 0 {
+		return "no samples"
+	}
+	last := m.samples.At(n - 1)
+	h.Age = now.Sub(last.received)
+	h.Offset = last.offset
+	h.Rate = float64(n) / m.window().Seconds()
+	if span := now.Sub(m.samples.At(0).received); n == m.samples.Cap() && span > 0 {
+		// the window has more samples than are kept
+		h.Rate = float64(n) / span.Seconds()
+	}
+	minOffset, maxOffset := last.offset, last.offset
+	for i := 0; i < n; i++ {
+		s := m.samples.At(i)
+		if s.offset < minOffset {
+			minOffset = s.offset
+		}
+		if s.offset > maxOffset {
+			maxOffset = s.offset
+		}
+	}
+	h.Spread = maxOffset - minOffset
+
+	maxAge := m.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+	minRate := m.MinRate
+	if minRate == 0 {
+		minRate = DefaultMinRate
+	}
+	maxSpread := m.MaxSpread
+	if maxSpread == 0 {
+		maxSpread = DefaultMaxSpread
+	}
+	switch {
+	case h.Age > maxAge:
+		return fmt.Sprintf("last sample is %v old", h.Age)
+	// rate is only known once the driver has run for the whole window
+	case now.Sub(m.started) >= m.window() && h.Rate < minRate:
+		return fmt.Sprintf("%.3f samples per second", h.Rate)
+	case h.Spread > maxSpread:
+		return fmt.Sprintf("offsets spread over %v", h.Spread)
+	}
+	return ""
+}
+
+// Failover picks the first healthy driver in the order of preference, so the server fails over
+// to network sources once none of them is
+type Failover struct {
+	Monitors []*Monitor
+}
+
+// Select returns the monitor of the driver to use as of now, nil if network sources should be used instead
+func (f *Failover) Select(now time.Time) *Monitor {
+	for _, m := range f.Monitors {
+		if m.Health(now).Reachable {
+			return m
+		}
+	}
+	return nil
+}
+
+// Health returns health of all drivers as of now
+func (f *Failover) Health(now time.Time) []*Health {
+	health := make([]*Health, 0, len(f.Monitors))
+	for _, m := range f.Monitors {
+		health = append(health, m.Health(now))
+	}
+	return health
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func feed(m *Monitor, from time.Time, n int, every time.Duration, offset func(i int) time.Duration) time.Time {
+	t := from
+	for i := 0; i < n; i++ {
+		m.Add(&Sample{Received: t, Reference: t.Add(offset(i))})
+		t = t.Add(every)
+	}
+	return t.Add(-every)
+}
+
+func TestMonitorHealthy(t *testing.T) {
+	m := NewMonitor(NMEARefID)
+	h := m.Health(received)
+	assert.False(t, h.Reachable)
+	assert.Equal(t, "no samples", h.Reason)
+
+	last := feed(m, received, 100, time.Second, func(i int) time.Duration { return time.Duration(i%3) * time.Millisecond })
+	h = m.Health(last.Add(time.Second))
+	require.True(t, h.Reachable, h.Reason)
+	assert.Equal(t, time.Second, h.Age)
+	assert.Equal(t, 2*time.Millisecond, h.Spread)
+	// 64s window holds 64 samples
+	assert.InDelta(t, 1, h.Rate, 0.02)
+	assert.Equal(t, map[string]string{
+		"refid":  "GPS",
+		"reach":  "1",
+		"rate":   "1.000",
+		"jitter": "2.000000",
+		"age":    "1.000",
+		"offset": "0.000000",
+	}, h.Variables())
+}
+
+func TestMonitorStale(t *testing.T) {
+	m := NewMonitor(PPSRefID)
+	last := feed(m, received, 10, time.Second, func(int) time.Duration { return 0 })
+	assert.True(t, m.Health(last.Add(8*time.Second)).Reachable)
+	h := m.Health(last.Add(9 * time.Second))
+	assert.False(t, h.Reachable)
+	assert.Equal(t, "last sample is 9s old", h.Reason)
+}
+
+func TestMonitorEvents(t *testing.T) {
+	m := NewMonitor(PPSRefID)
+	m.Events = &events.Bus{}
+	var got []events.Event
+	m.Events.Subscribe(func(e events.Event) {
+		m.Health(e.Time)
+		got = append(got, e)
+	})
+	last := feed(m, received, 10, time.Second, func(int) time.Duration { return 0 })
+	m.Health(last)
+	m.Health(last.Add(9 * time.Second))
+	require.Len(t, got, 2)
+	assert.Equal(t, events.Event{Time: last, Kind: events.SourceSelected, Source: "PPS"}, got[0])
+	assert.Equal(t, events.SourceDeselected, got[1].Kind)
+	assert.Equal(t, "last sample is 9s old", got[1].Reason)
+}
+
+func TestMonitorLogger(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewMonitor(PPSRefID)
+	m.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	last := feed(m, received, 10, time.Second, func(int) time.Duration { return 0 })
+	m.Health(last)
+	m.Health(last.Add(9 * time.Second))
+	assert.Contains(t, buf.String(), `level=INFO msg="Refclock is reachable" refid=PPS`)
+	assert.Contains(t, buf.String(), `level=WARN msg="Refclock is unreachable" refid=PPS reason="last sample is 9s old"`)
+}
+
+func TestMonitorRate(t *testing.T) {
+	m := NewMonitor(NMEARefID)
+	m.MaxAge = time.Minute
+	last := feed(m, received, 10, 5*time.Second, func(int) time.Duration { return 0 })
+	// not judged until the driver ran for the whole window
+	assert.True(t, m.Health(last).Reachable)
+	last = feed(m, last.Add(5*time.Second), 10, 5*time.Second, func(int) time.Duration { return 0 })
+	h := m.Health(last)
+	assert.False(t, h.Reachable)
+	assert.Equal(t, "0.203 samples per second", h.Reason)
+}
+
+func TestMonitorManySamples(t *testing.T) {
+	m := NewMonitor(PPSRefID)
+	last := feed(m, received, 3*MaxHealthSamples, 10*time.Millisecond, func(int) time.Duration { return 0 })
+	h := m.Health(last)
+	require.True(t, h.Reachable, h.Reason)
+	assert.InDelta(t, 100, h.Rate, 1, "rate is estimated from the samples kept")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		last = last.Add(10 * time.Millisecond)
+		m.Add(&Sample{Received: last, Reference: last})
+	})
+	assert.Equal(t, 0.0, allocs, "samples are kept in a ring")
+}
+
+func TestMonitorSpread(t *testing.T) {
+	m := NewMonitor(NMEARefID)
+	last := feed(m, received, 10, time.Second, func(i int) time.Duration { return time.Duration(i) * 20 * time.Millisecond })
+	h := m.Health(last)
+	assert.False(t, h.Reachable)
+	assert.Equal(t, "offsets spread over 180ms", h.Reason)
+}
+
+func TestFailover(t *testing.T) {
+	pps := NewMonitor(PPSRefID)
+	gps := NewMonitor(NMEARefID)
+	f := &Failover{Monitors: []*Monitor{pps, gps}}
+	assert.Nil(t, f.Select(received))
+
+	last := feed(gps, received, 10, time.Second, func(int) time.Duration { return 0 })
+	assert.Equal(t, gps, f.Select(last))
+	feed(pps, received, 10, time.Second, func(int) time.Duration { return 0 })
+	assert.Equal(t, pps, f.Select(last))
+	// both go stale, network sources take over
+	assert.Nil(t, f.Select(last.Add(time.Minute)))
+
+	health := f.Health(last)
+	require.Len(t, health, 2)
+	assert.Equal(t, PPSRefID, health[0].RefID)
+	assert.True(t, health[1].Reachable)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NMEARefID is reference ID of servers synchronized to NMEA GPS receiver
+const NMEARefID = "GPS"
+
+// ErrNMEAChecksum is returned for sentences with checksum not matching their content
+var ErrNMEAChecksum = errors.New("NMEA checksum mismatch")
+
+// NMEA reads time from RMC, GGA and ZDA sentences GPS receivers emit on serial line, usually once a second.
+// GGA carries no date, so it is only used once RMC or ZDA told the date
+type NMEA struct {
+	// Fudge calibrates samples. Sentences are sent after the second they describe begins,
+	// so Fudge.Delay is the delay of the receiver and serial line, typically a few hundred milliseconds
+	Fudge Fudge
+
+	r    *bufio.Reader
+	date time.Time
+	last time.Time
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewNMEA returns driver reading sentences from r, usually serial device opened with OpenSerial
+func NewNMEA(r io.Reader) *NMEA {
+	return &NMEA{r: bufio.NewReader(r), now: time.Now}
+}
+
+// Read returns sample from the next sentence carrying valid time, skipping the rest.
+// Corrupted sentences return error, reading may go on after it
+func (n *NMEA) Read() (*Sample, error) {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		received := n.now()
+		t, ok, err := n.parse(strings.TrimSpace(line))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return n.Fudge.Apply(&Sample{Received: received, Reference: t}), nil
+		}
+	}
+}
+
+// parse returns time the sentence carries, if it's a valid fix
+func (n *NMEA) parse(sentence string) (time.Time, bool, error) {
+	if !strings.HasPrefix(sentence, "$") {
+		return time.Time{}, false, nil
+	}
+	body := sentence[1:]
+	if i := strings.LastIndexByte(body, '*'); i >= 0 {
+		checksum, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: %q", ErrNMEAChecksum, sentence)
+		}
+		body = body[:i]
+		var sum byte
+		for j := 0; j < len(body); j++ {
+			sum ^= body[j]
+		}
+		if sum != byte(checksum) {
+			return time.Time{}, false, fmt.Errorf("%w: %q", ErrNMEAChecksum, sentence)
+		}
+	}
+	fields := strings.Split(body, ",")
+	// talker ID is GP, GN, GL and so on, depending on constellation
+	if len(fields[0]) != 5 {
+		return time.Time{}, false, nil
+	}
+	switch fields[0][2:] {
+	case "RMC":
+		// time, status, lat, N/S, lon, E/W, speed, course, date
+		if len(fields) < 10 || fields[2] != "A" {
+			return time.Time{}, false, nil
+		}
+		date, err := time.Parse("020106", fields[9])
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("malformed RMC date %q: %w", fields[9], err)
+		}
+		n.date = date
+		return n.timeOfDay(fields[1])
+	case "ZDA":
+		// time, day, month, year
+		if len(fields) < 5 || fields[1] == "" {
+			return time.Time{}, false, nil
+		}
+		date, err := time.Parse("02 01 2006", strings.Join(fields[2:5], " "))
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("malformed ZDA date %q: %w", strings.Join(fields[2:5], ","), err)
+		}
+		n.date = date
+		return n.timeOfDay(fields[1])
+	case "GGA":
+		// time, lat, N/S, lon, E/W, fix quality
+		if len(fields) < 7 || fields[6] == "" || fields[6] == "0" || n.date.IsZero() {
+			return time.Time{}, false, nil
+		}
+		last := n.last
+		t, ok, err := n.timeOfDay(fields[1])
+		if ok && t.Before(last) {
+			// midnight passed since RMC or ZDA told the date
+			n.date = n.date.AddDate(0, 0, 1)
+			t = t.AddDate(0, 0, 1)
+			n.last = t
+		}
+		return t, ok, err
+	}
+	return time.Time{}, false, nil
+}
+
+// timeOfDay returns hhmmss.ss time on the last known date
+func (n *NMEA) timeOfDay(field string) (time.Time, bool, error) {
+	if len(field) < 6 {
+		return time.Time{}, false, fmt.Errorf("malformed NMEA time %q", field)
+	}
+	hour, errH := strconv.Atoi(field[0:2])
+	min, errM := strconv.Atoi(field[2:4])
+	sec, errS := strconv.ParseFloat(field[4:], 64)
+	if errH != nil || errM != nil || errS != nil {
+		return time.Time{}, false, fmt.Errorf("malformed NMEA time %q", field)
+	}
+	t := n.date.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(math.Round(sec*1e6))*time.Microsecond)
+	n.last = t
+	return t, true, nil
+}
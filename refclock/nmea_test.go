@@ -0,0 +1,93 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var received = time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+
+func newTestNMEA(input string) *NMEA {
+	n := NewNMEA(strings.NewReader(input))
+	n.now = func() time.Time { return received }
+	return n
+}
+
+func TestNMEARMC(t *testing.T) {
+	n := newTestNMEA("$GPRMC,115959.50,A,4807.038,N,01131.000,E,022.4,084.4,040321,003.1,W*47\r\n")
+	n.Fudge.Delay = 200 * time.Millisecond
+	s, err := n.Read()
+	require.Nil(t, err)
+	assert.Equal(t, received.Add(-200*time.Millisecond), s.Received)
+	assert.Equal(t, time.Date(2021, 3, 4, 11, 59, 59, 500000000, time.UTC), s.Reference)
+	assert.Equal(t, -300*time.Millisecond, s.Offset())
+}
+
+func TestNMEAZDA(t *testing.T) {
+	n := newTestNMEA("$GNZDA,120001.00,04,03,2021,00,00*7C\r\n")
+	s, err := n.Read()
+	require.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 3, 4, 12, 0, 1, 0, time.UTC), s.Reference)
+}
+
+func TestNMEASkipsInvalid(t *testing.T) {
+	input := strings.Join([]string{
+		"garbage",
+		// GGA before the date is known
+		"$GPGGA,115958.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*65",
+		// no fix
+		"$GPRMC,115958.00,V,,,,,,,040321,,,N*78",
+		"$GPGSV,3,1,11,03,03,111,00,04,15,270,00,06,01,010,00,13,06,292,00*74",
+		"$GPRMC,115959.00,A,4807.038,N,01131.000,E,022.4,084.4,040321,003.1,W*42",
+		// no fix
+		"$GPGGA,120000.00,4807.038,N,01131.000,E,0,08,0.9,545.4,M,46.9,M,,*66",
+		"$GPGGA,120001.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*66",
+	}, "\r\n") + "\r\n"
+	n := newTestNMEA(input)
+	s, err := n.Read()
+	require.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 3, 4, 11, 59, 59, 0, time.UTC), s.Reference)
+	s, err = n.Read()
+	require.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 3, 4, 12, 0, 1, 0, time.UTC), s.Reference)
+	_, err = n.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestNMEAGGAAfterMidnight(t *testing.T) {
+	n := newTestNMEA("")
+	_, ok, err := n.parse("$GPZDA,235959.00,03,03,2021,00,00")
+	require.Nil(t, err)
+	require.True(t, ok)
+	ts, ok, err := n.parse("$GPGGA,000000.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+	require.Nil(t, err)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), ts)
+}
+
+func TestNMEAChecksum(t *testing.T) {
+	n := newTestNMEA("$GPZDA,120001.00,04,03,2021,00,00*00\r\n")
+	_, err := n.Read()
+	assert.ErrorIs(t, err, ErrNMEAChecksum)
+}
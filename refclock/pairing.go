@@ -0,0 +1,89 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Pairing defaults
+const (
+	// DefaultPairWindow is how far coarse source may place the edge from the start of a second
+	DefaultPairWindow = 200 * time.Millisecond
+	// DefaultCoarseMaxAge is how old coarse sample pulses are paired with may be
+	DefaultCoarseMaxAge = 16 * time.Second
+)
+
+// ErrPulseRejected is returned for pulses coarse source disagrees with
+var ErrPulseRejected = errors.New("pulse rejected")
+
+// Pairing combines coarse source telling which second it is, like NMEA or gpsd, with PPS source telling
+// precisely when the second starts. Pulse is numbered by coarse time of its edge, unless coarse source puts the edge
+// too far from the start of any second: then one of them is broken and the pulse is rejected.
+// It is safe for concurrent use
+type Pairing struct {
+	// Window is DefaultPairWindow if not set
+	Window time.Duration
+	// MaxAge is DefaultCoarseMaxAge if not set
+	MaxAge time.Duration
+
+	mu     sync.Mutex
+	coarse *Sample
+}
+
+// Coarse records sample of the coarse source
+func (p *Pairing) Coarse(s *Sample) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.coarse = s
+}
+
+// Pulse returns sample of the pulse with its second numbered by the coarse source
+func (p *Pairing) Pulse(s *Sample) (*Sample, error) {
+	p.mu.Lock()
+	coarse := p.coarse
+	p.mu.Unlock()
+	window := p.Window
+	if window == 0 {
+		window = DefaultPairWindow
+	}
+	maxAge := p.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultCoarseMaxAge
+	}
+	if coarse == nil {
+		return nil, fmt.Errorf("%w: no coarse sample", ErrPulseRejected)
+	}
+	if age := s.Received.Sub(coarse.Received); age > maxAge || age < -maxAge {
+		return nil, fmt.Errorf("%w: coarse sample is %v old", ErrPulseRejected, age)
+	}
+	edge := s.Received.Add(coarse.Offset())
+	second := edge.Round(time.Second)
+	if diff := edge.Sub(second); diff > window || diff < -window {
+		return nil, fmt.Errorf("%w: coarse source puts the edge %v off the second", ErrPulseRejected, diff)
+	}
+	// keep calibration of the pulse
+	calibration := s.Reference.Sub(s.Reference.Round(time.Second))
+	return &Sample{
+		Received:   s.Received,
+		Reference:  second.Add(calibration),
+		Dispersion: s.Dispersion,
+	}, nil
+}
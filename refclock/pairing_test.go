@@ -0,0 +1,54 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPairing(t *testing.T) {
+	p := &Pairing{}
+	second := time.Date(2021, 3, 4, 12, 0, 1, 0, time.UTC)
+	// local clock is 3.7s behind, pulse arrives when it shows 11:59:57.3
+	edge := second.Add(-3700 * time.Millisecond)
+	pulse := ppsSample(edge, Fudge{Offset: 2 * time.Microsecond})
+
+	_, err := p.Pulse(pulse)
+	assert.ErrorIs(t, err, ErrPulseRejected)
+
+	// NMEA sentence of 12:00:00 arrived late by 150ms of jitter
+	p.Coarse(&Sample{Received: edge.Add(-850 * time.Millisecond), Reference: second.Add(-time.Second)})
+	s, err := p.Pulse(pulse)
+	require.Nil(t, err)
+	assert.Equal(t, edge, s.Received)
+	assert.Equal(t, second.Add(2*time.Microsecond), s.Reference)
+	assert.Equal(t, 3700*time.Millisecond+2*time.Microsecond, s.Offset())
+
+	// coarse source disagrees by 300ms
+	p.Coarse(&Sample{Received: edge.Add(-700 * time.Millisecond), Reference: second.Add(-time.Second)})
+	_, err = p.Pulse(pulse)
+	assert.ErrorIs(t, err, ErrPulseRejected)
+
+	// coarse sample is too old
+	p.Coarse(&Sample{Received: edge.Add(-20 * time.Second), Reference: second.Add(-20 * time.Second)})
+	_, err = p.Pulse(pulse)
+	assert.ErrorIs(t, err, ErrPulseRejected)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/ntp/clock"
+)
+
+// PHCRefID is reference ID of servers synchronized to PTP hardware clock
+const PHCRefID = "PHC"
+
+// DefaultPHCSamples is how many times PHC is read for a single sample
+const DefaultPHCSamples = 10
+
+// PHC turns PTP hardware clock of a NIC, disciplined by PTP, e.g. by ptp4l, into reference clock.
+// The clock is read in between two readings of the system clock a few times and the tightest reading wins
+type PHC struct {
+	// Samples is how many times the clock is read, DefaultPHCSamples if not set
+	Samples int
+	// UTCOffset is TAI-UTC offset in seconds subtracted from PHC time, 37 as of 2017 for PHC kept in TAI the way ptp4l does
+	UTCOffset int
+	// Fudge calibrates samples
+	Fudge Fudge
+
+	clock clock.Clock
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewPHC returns driver reading the clock, usually opened with clock.OpenPHC
+func NewPHC(c clock.Clock) *PHC {
+	return &PHC{clock: c, now: time.Now}
+}
+
+// Read returns sample of the reading which took the least time. Dispersion is half that time,
+// as the clock was read somewhere within it, plus half the spread of offsets of all readings
+func (p *PHC) Read() (*Sample, error) {
+	n := p.Samples
+	if n == 0 {
+		n = DefaultPHCSamples
+	}
+	var best *Sample
+	var bestDelay, minOffset, maxOffset time.Duration
+	for i := 0; i < n; i++ {
+		before := p.now()
+		t, err := p.clock.Now()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PHC: %w", err)
+		}
+		after := p.now()
+		delay := after.Sub(before)
+		s := &Sample{
+			Received:  before.Add(delay / 2),
+			Reference: t.Add(-time.Duration(p.UTCOffset) * time.Second),
+		}
+		offset := s.Offset()
+		if best == nil || delay < bestDelay {
+			best, bestDelay = s, delay
+		}
+		if i == 0 || offset < minOffset {
+			minOffset = offset
+		}
+		if i == 0 || offset > maxOffset {
+			maxOffset = offset
+		}
+	}
+	best.Dispersion = bestDelay/2 + (maxOffset-minOffset)/2
+	return p.Fudge.Apply(best), nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePHC is 37s ahead of the system clock, as TAI is, plus 10µs, and takes delays[i] to read the i-th time
+type fakePHC struct {
+	system time.Time
+	delays []time.Duration
+	reads  int
+}
+
+func (c *fakePHC) Now() (time.Time, error) {
+	delay := c.delays[c.reads]
+	c.reads++
+	c.system = c.system.Add(delay / 2)
+	t := c.system.Add(37*time.Second + 10*time.Microsecond)
+	c.system = c.system.Add(delay / 2)
+	return t, nil
+}
+
+func (c *fakePHC) Step(offset time.Duration) error {
+	return nil
+}
+
+func (c *fakePHC) FrequencyPPB() (float64, error) {
+	return 0, nil
+}
+
+func (c *fakePHC) SetFrequencyPPB(ppb float64) error {
+	return nil
+}
+
+func TestPHCRead(t *testing.T) {
+	c := &fakePHC{system: received, delays: []time.Duration{4 * time.Microsecond, 2 * time.Microsecond, 6 * time.Microsecond}}
+	p := NewPHC(c)
+	p.Samples = 3
+	p.UTCOffset = 37
+	p.now = func() time.Time { return c.system }
+
+	s, err := p.Read()
+	require.Nil(t, err)
+	assert.Equal(t, 3, c.reads)
+	assert.Equal(t, received.Add(5*time.Microsecond), s.Received)
+	assert.Equal(t, 10*time.Microsecond, s.Offset())
+	assert.Equal(t, time.Microsecond, s.Dispersion)
+}
@@ -0,0 +1,34 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"errors"
+	"time"
+)
+
+// PPSRefID is reference ID of servers synchronized to pulse per second signal
+const PPSRefID = "PPS"
+
+// ErrNoPulse is returned when no pulse arrived in time
+var ErrNoPulse = errors.New("no PPS pulse")
+
+// ppsSample returns sample of the pulse captured at the edge. Pulse marks the start of a second but doesn't tell which one,
+// so the local clock has to be within half a second already, kept there by a coarse source like NMEA
+func ppsSample(edge time.Time, f Fudge) *Sample {
+	return f.Apply(&Sample{Received: edge, Reference: edge.Add(-f.Delay).Round(time.Second)})
+}
@@ -0,0 +1,115 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// RFC 2783 constants from linux/pps.h
+const (
+	ppsAPIVersion     = 1
+	ppsCaptureAssert  = 0x01
+	ppsCaptureClear   = 0x02
+	ppsCanWait        = 0x100
+	ppsTimeFormatSpec = 0x1000
+	ppsTimeInvalid    = 0x01
+)
+
+// PPS captures pulse per second edges with kernel PPS API (RFC 2783), e.g. from /dev/pps0,
+// timestamped by the kernel with nanosecond precision
+type PPS struct {
+	// Fudge calibrates samples, Fudge.Delay being how late the edge is timestamped after the second starts
+	Fudge Fudge
+	// Clear captures falling edges instead of rising ones, for receivers with inverted pulse
+	Clear bool
+
+	f   *os.File
+	seq uint32
+}
+
+// OpenPPS opens PPS device and enables capture of both edges, which takes root
+func OpenPPS(device string) (*PPS, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	p := &PPS{f: f}
+	var caps int32
+	if err := p.ioctl(syscall.PPS_GETCAP, unsafe.Pointer(&caps)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a PPS device: %w", device, err)
+	}
+	if caps&ppsCanWait == 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s can't wait for pulses", device)
+	}
+	var params syscall.PPSKParams
+	if err := p.ioctl(syscall.PPS_GETPARAMS, unsafe.Pointer(&params)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read %s parameters: %w", device, err)
+	}
+	params.Api_version = ppsAPIVersion
+	params.Mode |= int32(caps & (ppsCaptureAssert | ppsCaptureClear))
+	params.Mode |= ppsTimeFormatSpec
+	if err := p.ioctl(syscall.PPS_SETPARAMS, unsafe.Pointer(&params)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to enable capture on %s: %w", device, err)
+	}
+	return p, nil
+}
+
+func (p *PPS) ioctl(req uint, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.f.Fd(), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Close closes the device
+func (p *PPS) Close() error {
+	return p.f.Close()
+}
+
+// Read waits up to timeout for the next pulse and returns its sample
+func (p *PPS) Read(timeout time.Duration) (*Sample, error) {
+	var data syscall.PPSFData
+	data.Timeout.Sec = int64(timeout / time.Second)
+	data.Timeout.Nsec = int32(timeout % time.Second)
+	if err := p.ioctl(syscall.PPS_FETCH, unsafe.Pointer(&data)); err != nil {
+		if errors.Is(err, syscall.ETIMEDOUT) || errors.Is(err, syscall.EINTR) {
+			return nil, fmt.Errorf("%w in %v", ErrNoPulse, timeout)
+		}
+		return nil, fmt.Errorf("failed to fetch pulse: %w", err)
+	}
+	seq, ts := data.Info.Assert_sequence, data.Info.Assert_tu
+	if p.Clear {
+		seq, ts = data.Info.Clear_sequence, data.Info.Clear_tu
+	}
+	if seq == p.seq || ts.Flags&ppsTimeInvalid != 0 {
+		return nil, fmt.Errorf("%w in %v", ErrNoPulse, timeout)
+	}
+	p.seq = seq
+	return ppsSample(time.Unix(ts.Sec, int64(ts.Nsec)), p.Fudge), nil
+}
@@ -0,0 +1,46 @@
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"time"
+)
+
+// PPS captures pulse per second edges with kernel PPS API (RFC 2783)
+type PPS struct {
+	// Fudge calibrates samples, Fudge.Delay being how late the edge is timestamped after the second starts
+	Fudge Fudge
+	// Clear captures falling edges instead of rising ones
+	Clear bool
+}
+
+// OpenPPS opens PPS device
+func OpenPPS(device string) (*PPS, error) {
+	return nil, ErrNotSupported
+}
+
+// Close closes the device
+func (p *PPS) Close() error {
+	return ErrNotSupported
+}
+
+// Read waits up to timeout for the next pulse and returns its sample
+func (p *PPS) Read(timeout time.Duration) (*Sample, error) {
+	return nil, ErrNotSupported
+}
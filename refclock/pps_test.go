@@ -0,0 +1,39 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPPSSample(t *testing.T) {
+	second := time.Date(2021, 3, 4, 12, 0, 1, 0, time.UTC)
+	s := ppsSample(second.Add(-300*time.Microsecond), Fudge{})
+	assert.Equal(t, second, s.Reference)
+	assert.Equal(t, 300*time.Microsecond, s.Offset())
+
+	s = ppsSample(second.Add(400*time.Millisecond), Fudge{})
+	assert.Equal(t, second, s.Reference)
+	assert.Equal(t, -400*time.Millisecond, s.Offset())
+
+	// edge timestamped 2µs late
+	s = ppsSample(second.Add(2*time.Microsecond), Fudge{Delay: 2 * time.Microsecond})
+	assert.Equal(t, time.Duration(0), s.Offset())
+}
@@ -0,0 +1,46 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package refclock implements reference clock drivers: local sources of time like GPS receivers,
+// which make the server built on them stratum 1
+package refclock
+
+import (
+	"errors"
+	"time"
+)
+
+// Stratum of reference clocks
+const Stratum = 0
+
+// ErrNotSupported is returned on platforms the driver doesn't work on
+var ErrNotSupported = errors.New("reference clock is not supported on this platform")
+
+// Sample is a single reading of reference clock
+type Sample struct {
+	// Received is local time the reading was taken at
+	Received time.Time
+	// Reference is time of the reference clock at Received
+	Reference time.Time
+	// Dispersion is error bound of the reading, 0 if the driver can't tell
+	Dispersion time.Duration
+}
+
+// Offset returns offset of the local clock from the reference one: positive offset means the local clock is behind,
+// same as discipline.Loop expects
+func (s *Sample) Offset() time.Duration {
+	return s.Reference.Sub(s.Received)
+}
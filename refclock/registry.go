@@ -0,0 +1,104 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RefClock is a reference clock driver. Drivers shipped elsewhere, e.g. for IRIG or DCF77 receivers,
+// implement it and Register themselves to be configured the same way built-in ones are
+type RefClock interface {
+	// Start opens the device
+	Start() error
+	// Poll waits for the next reading of the device and keeps its samples
+	Poll() error
+	// Samples returns samples kept since the last call
+	Samples() []*Sample
+	// Stop closes the device
+	Stop() error
+}
+
+// Config of a driver
+type Config struct {
+	// Device is what the driver reads: serial device, address, SHM unit and so on
+	Device string
+	// Fudge calibrates samples
+	Fudge Fudge
+	// Options are specific to the driver, e.g. baud rate
+	Options map[string]string
+}
+
+// Factory returns driver configured with c
+type Factory func(c *Config) (RefClock, error)
+
+// ErrUnknownDriver is returned for drivers nobody registered
+var ErrUnknownDriver = errors.New("unknown refclock driver")
+
+var (
+	driversMu sync.Mutex
+	drivers   = map[string]Factory{}
+)
+
+// Register makes driver available under the name. It panics if the name is taken, like database/sql does
+func Register(name string, f Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("refclock driver %q registered twice", name))
+	}
+	drivers[name] = f
+}
+
+// Drivers returns sorted names of registered drivers
+func Drivers() []string {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns driver registered under the name, configured with c
+func New(name string, c *Config) (RefClock, error) {
+	driversMu.Lock()
+	f, ok := drivers[name]
+	driversMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownDriver, name)
+	}
+	return f(c)
+}
+
+// Collect polls the driver once and records its samples with the monitor, which selects
+// the driver to steer the clock to
+func Collect(rc RefClock, m *Monitor) ([]*Sample, error) {
+	if err := rc.Poll(); err != nil {
+		return nil, err
+	}
+	samples := rc.Samples()
+	for _, s := range samples {
+		m.Add(s)
+	}
+	return samples, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dcf77 is out-of-tree driver
+type dcf77 struct {
+	config  *Config
+	started bool
+	polls   int
+}
+
+func (d *dcf77) Start() error {
+	d.started = true
+	return nil
+}
+
+func (d *dcf77) Poll() error {
+	d.polls++
+	return nil
+}
+
+func (d *dcf77) Samples() []*Sample {
+	t := received.Add(time.Duration(d.polls) * time.Second)
+	return []*Sample{d.config.Fudge.Apply(&Sample{Received: t, Reference: t})}
+}
+
+func (d *dcf77) Stop() error {
+	d.started = false
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("dcf77", func(c *Config) (RefClock, error) {
+		return &dcf77{config: c}, nil
+	})
+	defer func() {
+		driversMu.Lock()
+		delete(drivers, "dcf77")
+		driversMu.Unlock()
+	}()
+	assert.Panics(t, func() {
+		Register("dcf77", nil)
+	})
+	assert.Equal(t, []string{"dcf77", "gpsd", "nmea", "phc", "pps", "shm", "ubx"}, Drivers())
+
+	rc, err := New("dcf77", &Config{Device: "/dev/ttyUSB0", Fudge: Fudge{Offset: time.Millisecond}})
+	require.Nil(t, err)
+	require.Nil(t, rc.Start())
+	m := NewMonitor("DCF")
+	samples, err := Collect(rc, m)
+	require.Nil(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, time.Millisecond, samples[0].Offset())
+	h := m.Health(received.Add(time.Second))
+	assert.True(t, h.Reachable)
+	assert.Equal(t, time.Millisecond, h.Offset)
+	require.Nil(t, rc.Stop())
+}
+
+func TestNewUnknown(t *testing.T) {
+	_, err := New("irig", &Config{})
+	assert.ErrorIs(t, err, ErrUnknownDriver)
+}
+
+func TestBuiltinDriverOptions(t *testing.T) {
+	_, err := New(DriverNMEA, &Config{Device: "/dev/ttyS0", Options: map[string]string{"baud": "fast"}})
+	assert.NotNil(t, err)
+	_, err = New(DriverPPS, &Config{Device: "/dev/pps0", Options: map[string]string{"clear": "maybe"}})
+	assert.NotNil(t, err)
+	_, err = New(DriverSHM, &Config{Device: "zero"})
+	assert.NotNil(t, err)
+
+	rc, err := New(DriverPHC, &Config{Device: "/dev/ptp0", Options: map[string]string{"utcoffset": "37"}})
+	require.Nil(t, err)
+	assert.Equal(t, errNotStarted, rc.Poll())
+	assert.Nil(t, rc.Stop())
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// shmKeyBase is SysV IPC key of unit 0 of ntpd SHM driver, "NTP0"
+const shmKeyBase = 0x4e545030
+
+// ErrNoSample is returned when there is no new sample to read
+var ErrNoSample = errors.New("no new sample")
+
+// shmTime is struct shmTime shared with ntpd, chrony and gpsd. time_t is long, which is int in Go on all unix platforms
+type shmTime struct {
+	Mode        int32
+	Count       int32
+	ClockSec    int
+	ClockUSec   int32
+	ReceiveSec  int
+	ReceiveUSec int32
+	Leap        int32
+	Precision   int32
+	NSamples    int32
+	Valid       int32
+	ClockNSec   uint32
+	ReceiveNSec uint32
+	Dummy       [8]int32
+}
+
+// read returns the sample published by writer if there is a new one. In mode 1 count is bumped by writer before and after
+// the update, so a torn read shows as count changing under us
+func (t *shmTime) read() (*Sample, error) {
+	if atomic.LoadInt32(&t.Valid) == 0 {
+		return nil, ErrNoSample
+	}
+	count := atomic.LoadInt32(&t.Count)
+	mode := atomic.LoadInt32(&t.Mode)
+	clockSec, clockNSec := t.ClockSec, t.ClockNSec
+	receiveSec, receiveNSec := t.ReceiveSec, t.ReceiveNSec
+	if mode == 1 && atomic.LoadInt32(&t.Count) != count {
+		return nil, ErrNoSample
+	}
+	atomic.StoreInt32(&t.Valid, 0)
+	return &Sample{
+		Received:  time.Unix(int64(receiveSec), int64(receiveNSec)),
+		Reference: time.Unix(int64(clockSec), int64(clockNSec)),
+	}, nil
+}
+
+// write publishes the sample in mode 1
+func (t *shmTime) write(s *Sample, precision int) {
+	atomic.StoreInt32(&t.Valid, 0)
+	atomic.StoreInt32(&t.Mode, 1)
+	atomic.AddInt32(&t.Count, 1)
+	t.ClockSec = int(s.Reference.Unix())
+	t.ClockUSec = int32(s.Reference.Nanosecond() / 1000)
+	t.ClockNSec = uint32(s.Reference.Nanosecond())
+	t.ReceiveSec = int(s.Received.Unix())
+	t.ReceiveUSec = int32(s.Received.Nanosecond() / 1000)
+	t.ReceiveNSec = uint32(s.Received.Nanosecond())
+	t.Leap = 0
+	t.Precision = int32(precision)
+	t.NSamples = 3
+	atomic.AddInt32(&t.Count, 1)
+	atomic.StoreInt32(&t.Valid, 1)
+}
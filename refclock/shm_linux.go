@@ -0,0 +1,74 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"fmt"
+	"unsafe"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// SHM is a unit of ntpd shared memory refclock driver. Units 0 and 1 are only accessible by root,
+// the rest by anyone. It is either read, e.g. to get samples gpsd publishes, or written to feed ntpd or chrony
+type SHM struct {
+	// Precision is log2 of the precision of written samples in seconds, e.g. -1 for NMEA and -20 for PPS
+	Precision int
+	// Fudge calibrates read samples
+	Fudge Fudge
+
+	id  int
+	seg []byte
+	t   *shmTime
+}
+
+// OpenSHM attaches the unit of SHM driver, creating it if needed
+func OpenSHM(unit int) (*SHM, error) {
+	perm := 0666
+	if unit < 2 {
+		perm = 0600
+	}
+	id, err := syscall.SysvShmGet(shmKeyBase+unit, int(unsafe.Sizeof(shmTime{})), syscall.IPC_CREAT|perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SHM unit %d: %w", unit, err)
+	}
+	seg, err := syscall.SysvShmAttach(id, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach SHM unit %d: %w", unit, err)
+	}
+	return &SHM{id: id, seg: seg, t: (*shmTime)(unsafe.Pointer(&seg[0]))}, nil
+}
+
+// Close detaches the unit, leaving it to other users
+func (s *SHM) Close() error {
+	return syscall.SysvShmDetach(s.seg)
+}
+
+// Read returns the sample published since the last read, ErrNoSample if there is none
+func (s *SHM) Read() (*Sample, error) {
+	sample, err := s.t.read()
+	if err != nil {
+		return nil, err
+	}
+	return s.Fudge.Apply(sample), nil
+}
+
+// Write publishes the sample
+func (s *SHM) Write(sample *Sample) error {
+	s.t.write(sample, s.Precision)
+	return nil
+}
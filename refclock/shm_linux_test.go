@@ -0,0 +1,70 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	syscall "golang.org/x/sys/unix"
+)
+
+func TestSHM(t *testing.T) {
+	writer, err := OpenSHM(77)
+	if err != nil {
+		t.Skipf("no SysV shared memory: %v", err)
+	}
+	defer func() {
+		_, _ = syscall.SysvShmCtl(writer.id, syscall.IPC_RMID, nil)
+	}()
+	defer writer.Close()
+	reader, err := OpenSHM(77)
+	require.Nil(t, err)
+	defer reader.Close()
+
+	sample := &Sample{Received: time.Unix(1614859200, 1000), Reference: time.Unix(1614859200, 0)}
+	require.Nil(t, writer.Write(sample))
+	s, err := reader.Read()
+	require.Nil(t, err)
+	assert.Equal(t, -time.Microsecond, s.Offset())
+	_, err = reader.Read()
+	assert.Equal(t, ErrNoSample, err)
+}
+
+func TestSHMDriver(t *testing.T) {
+	writer, err := OpenSHM(78)
+	if err != nil {
+		t.Skipf("no SysV shared memory: %v", err)
+	}
+	defer func() {
+		_, _ = syscall.SysvShmCtl(writer.id, syscall.IPC_RMID, nil)
+	}()
+	defer writer.Close()
+
+	rc, err := New(DriverSHM, &Config{Device: "78", Fudge: Fudge{MinDispersion: time.Millisecond}})
+	require.Nil(t, err)
+	require.Nil(t, rc.Start())
+	defer rc.Stop()
+	require.Nil(t, writer.Write(&Sample{Received: time.Unix(1614859200, 0), Reference: time.Unix(1614859200, 0)}))
+	require.Nil(t, rc.Poll())
+	samples := rc.Samples()
+	require.Len(t, samples, 1)
+	assert.Equal(t, time.Millisecond, samples[0].Dispersion)
+	assert.Empty(t, rc.Samples())
+}
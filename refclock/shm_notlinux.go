@@ -0,0 +1,47 @@
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+// SHM is a unit of ntpd shared memory refclock driver
+type SHM struct {
+	// Precision is log2 of the precision of written samples in seconds
+	Precision int
+	// Fudge calibrates read samples
+	Fudge Fudge
+}
+
+// OpenSHM attaches the unit of SHM driver, creating it if needed
+func OpenSHM(unit int) (*SHM, error) {
+	return nil, ErrNotSupported
+}
+
+// Close detaches the unit
+func (s *SHM) Close() error {
+	return ErrNotSupported
+}
+
+// Read returns the sample published since the last read
+func (s *SHM) Read() (*Sample, error) {
+	return nil, ErrNotSupported
+}
+
+// Write publishes the sample
+func (s *SHM) Write(sample *Sample) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSHMTimeLayout(t *testing.T) {
+	// struct shmTime is 96 bytes on 64 bit platforms and 80 on 32 bit ones
+	assert.Equal(t, 64+4*unsafe.Sizeof(int(0)), unsafe.Sizeof(shmTime{}))
+}
+
+func TestSHMTimeReadWrite(t *testing.T) {
+	var shm shmTime
+	_, err := shm.read()
+	require.Equal(t, ErrNoSample, err)
+
+	sample := &Sample{
+		Received:  time.Date(2021, 3, 4, 12, 0, 0, 123456789, time.UTC),
+		Reference: time.Date(2021, 3, 4, 12, 0, 0, 100000000, time.UTC),
+	}
+	shm.write(sample, -20)
+	assert.Equal(t, int32(1), shm.Mode)
+	assert.Equal(t, int32(2), shm.Count)
+	assert.Equal(t, int32(123456), shm.ReceiveUSec)
+	assert.Equal(t, int32(-20), shm.Precision)
+
+	s, err := shm.read()
+	require.Nil(t, err)
+	assert.True(t, sample.Received.Equal(s.Received))
+	assert.True(t, sample.Reference.Equal(s.Reference))
+	_, err = shm.read()
+	assert.Equal(t, ErrNoSample, err)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"fmt"
+	"net"
+	"unsafe"
+)
+
+// sockMagic marks samples of chrony SOCK refclock driver, "SOCK"
+const sockMagic = 0x534f434b
+
+// sockSample is struct sock_sample of chrony SOCK driver, in host byte order and layout. timeval fields are long,
+// which is int in Go on all unix platforms
+type sockSample struct {
+	Sec    int
+	USec   int
+	Offset float64
+	Pulse  int32
+	Leap   int32
+	Pad    int32
+	Magic  int32
+}
+
+// ChronySock feeds samples to chronyd running SOCK refclock driver, configured like
+// refclock SOCK /var/run/chrony.ttyS0.sock
+type ChronySock struct {
+	conn net.Conn
+}
+
+// DialChronySock connects to the socket chronyd created for the driver
+func DialChronySock(path string) (*ChronySock, error) {
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to chronyd at %s: %w", path, err)
+	}
+	return &ChronySock{conn: conn}, nil
+}
+
+// Close closes the socket
+func (c *ChronySock) Close() error {
+	return c.conn.Close()
+}
+
+// Write sends the sample. pulse tells chronyd it's a PPS edge, which only carries offset within the second
+func (c *ChronySock) Write(s *Sample, pulse bool) error {
+	sample := sockSample{
+		Sec:    int(s.Received.Unix()),
+		USec:   s.Received.Nanosecond() / 1000,
+		Offset: s.Offset().Seconds(),
+		Magic:  sockMagic,
+	}
+	if pulse {
+		sample.Pulse = 1
+	}
+	data := (*[unsafe.Sizeof(sockSample{})]byte)(unsafe.Pointer(&sample))
+	if _, err := c.conn.Write(data[:]); err != nil {
+		return fmt.Errorf("failed to send sample to chronyd: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSockSampleLayout(t *testing.T) {
+	// struct sock_sample is 40 bytes on 64 bit platforms and 32 on 32 bit ones
+	assert.Equal(t, 24+2*unsafe.Sizeof(int(0)), unsafe.Sizeof(sockSample{}))
+}
+
+func TestChronySock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sock")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chrony.sock")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.Nil(t, err)
+	defer ln.Close()
+
+	c, err := DialChronySock(path)
+	require.Nil(t, err)
+	defer c.Close()
+	s := &Sample{
+		Received:  time.Unix(1614859200, 1500000),
+		Reference: time.Unix(1614859200, 0),
+	}
+	require.Nil(t, c.Write(s, true))
+
+	buf := make([]byte, 64)
+	n, err := ln.Read(buf)
+	require.Nil(t, err)
+	require.Equal(t, int(unsafe.Sizeof(sockSample{})), n)
+	got := *(*sockSample)(unsafe.Pointer(&buf[0]))
+	assert.Equal(t, 1614859200, got.Sec)
+	assert.Equal(t, 1500, got.USec)
+	assert.InDelta(t, -0.0015, got.Offset, 1e-12)
+	assert.Equal(t, int32(1), got.Pulse)
+	assert.Equal(t, int32(sockMagic), got.Magic)
+}
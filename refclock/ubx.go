@@ -0,0 +1,174 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// UBX message classes and IDs we use
+const (
+	ubxSync1       = 0xb5
+	ubxSync2       = 0x62
+	ubxClassNAV    = 0x01
+	ubxNAVTimeUTC  = 0x21
+	ubxClassTIM    = 0x0d
+	ubxTIMTP       = 0x01
+	ubxClassCFG    = 0x06
+	ubxCFGMsg      = 0x01
+	ubxMaxLength   = 4096
+	ubxValidUTC    = 0x04
+	navTimeUTCSize = 20
+	timTPSize      = 16
+)
+
+// ErrUBXChecksum is returned for messages with checksum not matching their content
+var ErrUBXChecksum = errors.New("UBX checksum mismatch")
+
+// UBX reads time from u-blox receivers speaking UBX binary protocol: NAV-TIMEUTC carries time of navigation epochs
+// with nanosecond resolution and accuracy estimate, TIM-TP carries quantization error of the next time pulse.
+// Receiver only generates the pulse on an edge of its own oscillator, so the pulse is off by up to tens of nanoseconds,
+// which is known beforehand and is removed from PPS samples with CorrectPulse
+type UBX struct {
+	// Fudge calibrates NAV-TIMEUTC samples, Fudge.Delay being how late messages arrive after their epoch
+	Fudge Fudge
+
+	r *bufio.Reader
+	// qErr is quantization error of the next pulse in picoseconds
+	qErr int32
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewUBX returns driver reading messages from r, usually serial device opened with OpenSerial
+func NewUBX(r io.Reader) *UBX {
+	return &UBX{r: bufio.NewReader(r), now: time.Now}
+}
+
+// ubxFrame returns the message framed with sync chars, length and checksum
+func ubxFrame(class, id byte, payload []byte) []byte {
+	frame := []byte{ubxSync1, ubxSync2, class, id, 0, 0}
+	binary.LittleEndian.PutUint16(frame[4:], uint16(len(payload)))
+	frame = append(frame, payload...)
+	a, b := ubxChecksum(frame[2:])
+	return append(frame, a, b)
+}
+
+// ubxChecksum is 8-bit Fletcher checksum over class, ID, length and payload
+func ubxChecksum(data []byte) (byte, byte) {
+	var a, b byte
+	for _, c := range data {
+		a += c
+		b += a
+	}
+	return a, b
+}
+
+// EnableUBX configures receiver to send NAV-TIMEUTC and TIM-TP every epoch on the port it is written to
+func EnableUBX(w io.Writer) error {
+	for _, msg := range [][2]byte{{ubxClassNAV, ubxNAVTimeUTC}, {ubxClassTIM, ubxTIMTP}} {
+		if _, err := w.Write(ubxFrame(ubxClassCFG, ubxCFGMsg, []byte{msg[0], msg[1], 1})); err != nil {
+			return fmt.Errorf("failed to enable UBX message 0x%02x 0x%02x: %w", msg[0], msg[1], err)
+		}
+	}
+	return nil
+}
+
+// readMessage returns class, ID and payload of the next message, skipping anything in between
+func (u *UBX) readMessage() (byte, byte, []byte, error) {
+	for {
+		c, err := u.r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if c != ubxSync1 {
+			continue
+		}
+		if c, err = u.r.ReadByte(); err != nil {
+			return 0, 0, nil, err
+		}
+		if c != ubxSync2 {
+			_ = u.r.UnreadByte()
+			continue
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(u.r, header); err != nil {
+			return 0, 0, nil, err
+		}
+		length := int(binary.LittleEndian.Uint16(header[2:]))
+		if length > ubxMaxLength {
+			continue
+		}
+		rest := make([]byte, length+2)
+		if _, err := io.ReadFull(u.r, rest); err != nil {
+			return 0, 0, nil, err
+		}
+		payload := rest[:length]
+		a, b := ubxChecksum(append(header, payload...))
+		if a != rest[length] || b != rest[length+1] {
+			return 0, 0, nil, fmt.Errorf("%w in message 0x%02x 0x%02x", ErrUBXChecksum, header[0], header[1])
+		}
+		return header[0], header[1], payload, nil
+	}
+}
+
+// Read returns sample from the next NAV-TIMEUTC message with valid UTC time, taking note of TIM-TP on the way.
+// Dispersion of the sample is the time accuracy estimate of the receiver. Corrupted messages return error,
+// reading may go on after it
+func (u *UBX) Read() (*Sample, error) {
+	for {
+		class, id, payload, err := u.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		received := u.now()
+		switch {
+		case class == ubxClassTIM && id == ubxTIMTP && len(payload) >= timTPSize:
+			u.qErr = int32(binary.LittleEndian.Uint32(payload[8:]))
+		case class == ubxClassNAV && id == ubxNAVTimeUTC && len(payload) >= navTimeUTCSize:
+			if payload[19]&ubxValidUTC == 0 {
+				continue
+			}
+			accuracy := time.Duration(binary.LittleEndian.Uint32(payload[4:]))
+			nano := time.Duration(int32(binary.LittleEndian.Uint32(payload[8:])))
+			t := time.Date(
+				int(binary.LittleEndian.Uint16(payload[12:])), time.Month(payload[14]), int(payload[15]),
+				int(payload[16]), int(payload[17]), int(payload[18]), 0, time.UTC,
+			).Add(nano)
+			return u.Fudge.Apply(&Sample{Received: received, Reference: t, Dispersion: accuracy}), nil
+		}
+	}
+}
+
+// QuantizationError returns how late the next pulse comes after the start of the second, as told by the last TIM-TP
+func (u *UBX) QuantizationError() time.Duration {
+	return time.Duration(u.qErr) * time.Nanosecond / 1000
+}
+
+// CorrectPulse returns sample of the pulse TIM-TP told about, with its quantization error removed
+func (u *UBX) CorrectPulse(s *Sample) *Sample {
+	return &Sample{
+		Received:   s.Received.Add(-u.QuantizationError()),
+		Reference:  s.Reference,
+		Dispersion: s.Dispersion,
+	}
+}
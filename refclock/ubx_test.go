@@ -0,0 +1,102 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package refclock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func navTimeUTC(t time.Time, nano int32, accuracy uint32, valid byte) []byte {
+	payload := make([]byte, navTimeUTCSize)
+	binary.LittleEndian.PutUint32(payload[4:], accuracy)
+	binary.LittleEndian.PutUint32(payload[8:], uint32(nano))
+	binary.LittleEndian.PutUint16(payload[12:], uint16(t.Year()))
+	payload[14] = byte(t.Month())
+	payload[15] = byte(t.Day())
+	payload[16] = byte(t.Hour())
+	payload[17] = byte(t.Minute())
+	payload[18] = byte(t.Second())
+	payload[19] = valid
+	return ubxFrame(ubxClassNAV, ubxNAVTimeUTC, payload)
+}
+
+func timTP(qErr int32) []byte {
+	payload := make([]byte, timTPSize)
+	binary.LittleEndian.PutUint32(payload[8:], uint32(qErr))
+	return ubxFrame(ubxClassTIM, ubxTIMTP, payload)
+}
+
+func TestUBXFrame(t *testing.T) {
+	// UBX-CFG-MSG enabling NAV-TIMEUTC, as u-center sends it
+	assert.Equal(t, []byte{0xb5, 0x62, 0x06, 0x01, 0x03, 0x00, 0x01, 0x21, 0x01, 0x2d, 0x85}, ubxFrame(ubxClassCFG, ubxCFGMsg, []byte{0x01, 0x21, 0x01}))
+	var buf bytes.Buffer
+	require.Nil(t, EnableUBX(&buf))
+	assert.Equal(t, 22, buf.Len())
+}
+
+func TestUBXRead(t *testing.T) {
+	second := time.Date(2021, 3, 4, 12, 0, 1, 0, time.UTC)
+	var input []byte
+	input = append(input, 'x', ubxSync1, 'y')
+	// invalid UTC
+	input = append(input, navTimeUTC(second.Add(-time.Second), 0, 20, 0x03)...)
+	input = append(input, timTP(-7500)...)
+	input = append(input, navTimeUTC(second.Add(-time.Second), 1000, 25, 0x07)...)
+	input = append(input, navTimeUTC(second, -1000, 25, 0x07)...)
+	u := NewUBX(bytes.NewReader(input))
+	u.now = func() time.Time { return received }
+
+	s, err := u.Read()
+	require.Nil(t, err)
+	assert.Equal(t, second.Add(-time.Second+time.Microsecond), s.Reference)
+	assert.Equal(t, received, s.Received)
+	assert.Equal(t, 25*time.Nanosecond, s.Dispersion)
+	assert.Equal(t, -7*time.Nanosecond, u.QuantizationError())
+
+	s, err = u.Read()
+	require.Nil(t, err)
+	assert.Equal(t, second.Add(-time.Microsecond), s.Reference)
+
+	_, err = u.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestUBXChecksum(t *testing.T) {
+	frame := timTP(100)
+	frame[len(frame)-1]++
+	u := NewUBX(bytes.NewReader(frame))
+	_, err := u.Read()
+	assert.ErrorIs(t, err, ErrUBXChecksum)
+}
+
+func TestUBXCorrectPulse(t *testing.T) {
+	u := NewUBX(bytes.NewReader(timTP(-12000)))
+	_, err := u.Read()
+	require.Equal(t, io.EOF, err)
+	second := time.Date(2021, 3, 4, 12, 0, 1, 0, time.UTC)
+	// pulse came 12ns early
+	s := u.CorrectPulse(ppsSample(second.Add(-12*time.Nanosecond), Fudge{}))
+	assert.Equal(t, second, s.Received)
+	assert.Equal(t, time.Duration(0), s.Offset())
+}
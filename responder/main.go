@@ -20,17 +20,28 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 	syscall "golang.org/x/sys/unix"
 
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/internal/fips"
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/nts"
 	"github.com/facebookincubator/ntp/responder/announce"
 	"github.com/facebookincubator/ntp/responder/checker"
 	"github.com/facebookincubator/ntp/responder/server"
 	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/facebookincubator/ntp/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -44,6 +55,21 @@ func main() {
 		logLevel       string
 		monitoringport int
 		prefix         string
+		keysFile       string
+		trustedKeys    string
+		keysOverlap    time.Duration
+		ntsAEADs       string
+		auditLog       string
+		fipsMode       bool
+		capturePath    string
+		captureSize    int64
+		captureFiles   int
+		replayFile     string
+		replaySpeed    float64
+		replayParse    bool
+		prometheusAddr string
+		statsdAddr     string
+		statsdPlain    bool
 	)
 
 	flag.StringVar(&logLevel, "loglevel", "warning", "Set a log level. Can be: debug, info, warning, error")
@@ -52,37 +78,143 @@ func main() {
 	flag.StringVar(&prefix, "metricsprefix", "", "Prefix to prepend to the metric name")
 	flag.IntVar(&s.ListenConfig.Port, "port", 123, "Port to run service on")
 	flag.IntVar(&monitoringport, "monitoringport", 0, "Port to run monitoring server on")
+	flag.StringVar(&prometheusAddr, "prometheus", "", "Address to serve Prometheus metrics on at /metrics, e.g. :9123. Disabled if empty")
+	flag.StringVar(&statsdAddr, "statsd", "", "Address of StatsD daemon or Datadog agent to push metrics to, e.g. 127.0.0.1:8125. Disabled if empty")
+	flag.BoolVar(&statsdPlain, "statsdplain", false, "Append label values to StatsD metric names instead of sending DogStatsD tags")
+	flag.DurationVar(&s.MetricsInterval, "statsdinterval", server.DefaultMetricsInterval, "How often to push metrics to StatsD")
 	flag.IntVar(&s.Stratum, "stratum", 1, "Stratum of the server")
 	flag.IntVar(&s.Workers, "workers", runtime.NumCPU()*100, "How many workers (routines) to run")
+	flag.IntVar(&s.BatchSize, "batchsize", 1, "How many packets to read in one syscall (recvmmsg). Linux only")
+	flag.BoolVar(&s.AutoTune, "autotune", false, "Size receive batches up to max(-batchsize, 64) and keep between -minworkers and -workers workers active after the load. With -workersockets only batches are sized")
+	flag.IntVar(&s.MinWorkers, "minworkers", 0, "How many workers -autotune keeps active at least. The number of CPUs if 0, ignored with -workersockets")
+	flag.BoolVar(&s.WorkerSockets, "workersockets", false, "Give every worker its own socket (SO_REUSEPORT) for each IP and serve it right in the worker")
+	flag.Var(&s.WorkerCPUs, "workercpus", "Pin workers to CPUs round-robin, like 0-3,8. Linux only")
+	flag.BoolVar(&s.BatchResponses, "batchresponses", false, "Answer each batch of -batchsize requests with a single syscall (sendmmsg), served by listeners instead of workers. Linux only")
+	flag.BoolVar(&s.IOURing, "iouring", false, "Use io_uring datapath if available, falls back to regular one otherwise. Linux only")
+	flag.StringVar(&s.XDPIface, "xdpiface", "", "Serve requests arriving on this interface via AF_XDP, bypassing the kernel UDP stack. Linux only")
+	flag.IntVar(&s.XDPQueues, "xdpqueues", 1, "How many receive queues of the interface to serve via AF_XDP")
+	flag.StringVar(&s.NTS.CertFile, "ntscert", "", "TLS certificate of NTS-KE server. NTS is enabled if both certificate and key are set")
+	flag.StringVar(&s.NTS.KeyFile, "ntskey", "", "TLS private key of NTS-KE server")
+	flag.StringVar(&ntsAEADs, "ntsaeads", "", "Comma-separated AEAD algorithms NTS-KE agrees to, e.g. AES-SIV-CMAC-256,AES-128-GCM-SIV. Default: all supported")
+	flag.StringVar(&s.NTS.ClientCAFile, "ntsclientca", "", "PEM file with CAs of client certificates. NTS-KE clients are required to present one if set")
+	flag.IntVar(&s.NTS.Port, "ntskeport", nts.DefaultKEPort, "Port to run NTS-KE service on")
+	flag.StringVar(&s.NTS.NTPServer, "ntsntpserver", "", "NTP server NTS-KE points clients at, e.g. load balancer in front of servers sharing NTS master keys. Default: this one")
+	flag.IntVar(&s.NTS.NTPPort, "ntsntpport", 0, "NTP port NTS-KE points clients at. Default: the port NTP server listens on")
+	flag.DurationVar(&s.NTS.Rotation, "ntsrotate", 24*time.Hour, "How often to rotate NTS cookie master key")
+	flag.StringVar(&keysFile, "keys", "", "ntpd-style key file for symmetric key authentication")
+	flag.StringVar(&trustedKeys, "trustedkeys", "", "Comma-separated IDs of trusted keys. Default: all keys from the key file")
+	flag.DurationVar(&keysOverlap, "keysoverlap", time.Hour, "How long keys replaced in key files keep validating requests")
+	flag.DurationVar(&s.ReloadInterval, "keysreload", 10*time.Second, "How often to check key files for changes. 0 disables reloading")
+	flag.StringVar(&s.NTS.KeysFile, "ntsmasterkeys", "", "File with NTS cookie master keys shared with other servers, replaces rotation")
+	flag.IntVar(&s.CryptoRate, "cryptorate", 0, "Max MAC/NTS verifications per second, requests over it are dropped unverified. 0 means no limit")
+	flag.Float64Var(&s.ClientRate, "clientrate", 0, "Max requests per second from a single client. 0 means no limit")
+	flag.IntVar(&s.ClientBurst, "clientburst", 0, "Max requests a client may send at once. A second worth of -clientrate if 0")
+	flag.IntVar(&s.RateClients, "rateclients", server.DefaultRateClients, "How many clients -clientrate is tracked for, others are forgotten at random")
+	flag.BoolVar(&s.RateKoD, "ratekod", false, "Answer the first request over -clientrate with RATE kiss-o'-death")
+	flag.IntVar(&s.CryptoConcurrency, "cryptoconcurrency", 0, "Max MAC/NTS verifications running at once. 0 means no limit")
+	flag.StringVar(&auditLog, "auditlog", "", "File to append security events to as JSON lines, - for stdout")
+	flag.StringVar(&capturePath, "capture", "", "Write requests and responses to ring of pcap files with this prefix. Disabled if empty")
+	flag.Int64Var(&captureSize, "capturesize", 100<<20, "Max size of a single pcap file in bytes")
+	flag.IntVar(&captureFiles, "capturefiles", 10, "How many pcap files to keep")
+	flag.StringVar(&replayFile, "replay", "", "Serve requests from pcap file instead of network, print outcomes and exit")
+	flag.Float64Var(&replaySpeed, "replayspeed", 0, "Replay speed: 1 is original timing, 0 is as fast as possible")
+	flag.BoolVar(&replayParse, "replayparseonly", false, "Only parse and validate replayed requests, don't serve them")
+	flag.BoolVar(&fipsMode, "fips", fips.Enabled(), "Only allow FIPS approved crypto: AES128CMAC keys and AES-SIV-CMAC NTS AEADs")
+	flag.BoolVar(&s.CryptoNAK, "cryptonak", false, "Reply with crypto-NAK to requests failing MAC verification instead of dropping them")
+	flag.BoolVar(&s.W32TimeQuirks, "w32time", false, "Answer symmetric active requests and out of range polls of Windows time service clients")
 	flag.Var(&s.ListenConfig.IPs, "ip", fmt.Sprintf("IP to listen to. Repeat for multiple. Default: %s", server.DefaultServerIPs))
-	flag.BoolVar(&debugger, "pprof", false, "Enable pprof")
+	flag.Var(&s.RequireAuth, "requireauth", "Only serve authenticated (MAC or NTS) requests from this prefix. Repeat for multiple")
+	flag.Var(&s.ControlAllow, "controlallow", "Answer ntpq control queries (mode 6) from this prefix. Repeat for multiple")
+	flag.StringVar(&s.ManageSocket, "managesocket", "", "Unix socket to serve management API (HTTP+JSON) on. Disabled if empty")
+	flag.IntVar(&s.MRUSize, "mrusize", 0, "How many most recently seen clients to keep for management API top talkers. Disabled if 0")
+	flag.Int64Var(&s.MRUMemory, "mrumemory", 0, "How much memory in bytes MRU list may take, fewer clients than -mrusize are kept if needed. Not limited if 0")
+	flag.DurationVar(&s.MRUMaxAge, "mrumaxage", 0, "Forget clients not seen for this long from MRU list. Kept until pushed out by others if 0")
+	flag.BoolVar(&debugger, "pprof", false, fmt.Sprintf("Serve expvar and pprof debug endpoints on %s", pprofHTTP))
+	flag.StringVar(&s.Expvar, "expvar", "ntp_server", "Name to publish internal counters under with expvar. Disabled if empty")
 	flag.BoolVar(&s.ListenConfig.ShouldAnnounce, "announce", false, "Advertize IPs")
 	flag.DurationVar(&s.ExtraOffset, "extraoffset", 0, "Extra offset to return to clients")
 
 	flag.Parse()
 	s.ListenConfig.IPs.SetDefault()
 
+	var level slog.Level
 	switch logLevel {
 	case "debug":
 		log.SetLevel(log.DebugLevel)
+		level = slog.LevelDebug
 	case "info":
 		log.SetLevel(log.InfoLevel)
+		level = slog.LevelInfo
 	case "warning":
 		log.SetLevel(log.WarnLevel)
+		level = slog.LevelWarn
 	case "error":
 		log.SetLevel(log.ErrorLevel)
+		level = slog.LevelError
 	default:
 		log.Fatalf("Unrecognized log level: %v", logLevel)
 	}
+	s.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 
 	if s.Workers < 1 {
 		log.Fatalf("Will not start without workers")
 	}
 
+	if fipsMode {
+		fips.Set(true)
+		log.Infof("FIPS mode is on")
+	}
+	s.NTS.Overlap = keysOverlap
+	if s.NTS.NTPPort < 0 || s.NTS.NTPPort > 65535 {
+		log.Fatalf("Invalid NTS NTP port %d", s.NTS.NTPPort)
+	}
+	aeads, err := nts.ParseAEADs(ntsAEADs)
+	if err != nil {
+		log.Fatalf("Failed to parse NTS AEAD algorithms: %v", err)
+	}
+	s.NTS.AEADs = aeads
+	if keysFile != "" {
+		trusted, err := parseKeyIDs(trustedKeys)
+		if err != nil {
+			log.Fatalf("Failed to parse trusted keys: %v", err)
+		}
+		keys, err := auth.OpenKeyFile(keysFile, trusted, keysOverlap)
+		if err != nil {
+			log.Fatalf("Failed to read keys: %v", err)
+		}
+		s.Keys = keys
+	}
+
+	if auditLog != "" {
+		w := os.Stdout
+		if auditLog != "-" {
+			if w, err = os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+				log.Fatalf("Failed to open audit log: %v", err)
+			}
+		}
+		s.Audit = audit.NewAsyncSink(audit.NewJSONSink(w), 1024)
+	}
+
+	if capturePath != "" {
+		ring, err := capture.NewRing(capturePath, captureSize, captureFiles)
+		if err != nil {
+			log.Fatalf("Failed to open capture file: %v", err)
+		}
+		s.Capture = capture.NewAsyncSink(ring, 4096)
+	}
+
+	if replayFile != "" {
+		s.Stats = &stats.JSONStats{}
+		if err := replay(&s, replayFile, replaySpeed, replayParse); err != nil {
+			log.Fatalf("Failed to replay %s: %v", replayFile, err)
+		}
+		return
+	}
+
 	if debugger {
 		log.Warningf("Staring profiler on %s", pprofHTTP)
 		go func() {
-			log.Println(http.ListenAndServe(pprofHTTP, nil))
+			log.Println(http.ListenAndServe(pprofHTTP, server.DebugHandler()))
 		}()
 	}
 
@@ -96,11 +228,35 @@ func main() {
 	st.SetPrefix(prefix)
 	go st.Start(monitoringport)
 
+	if prometheusAddr != "" {
+		s.Prometheus = prometheus.DefaultRegisterer
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			log.Errorf("Failed to serve Prometheus metrics: %v", http.ListenAndServe(prometheusAddr, mux))
+		}()
+	}
+
+	if statsdAddr != "" {
+		sink, err := telemetry.NewStatsD(statsdAddr)
+		if err != nil {
+			log.Fatalf("Failed to connect to StatsD: %v", err)
+		}
+		sink.Prefix = prefix
+		sink.PlainLabels = statsdPlain
+		s.MetricsSink = sink
+	}
+
 	// Replace with your implementation of Announce
 	s.Announce = &announce.NoopAnnounce{}
 
+	// Workers with sockets of their own listen on every IP
+	expectedListeners := len(s.ListenConfig.IPs)
+	if s.WorkerSockets {
+		expectedListeners *= s.Workers
+	}
 	ch := &checker.SimpleChecker{
-		ExpectedListeners: int64(len(s.ListenConfig.IPs)),
+		ExpectedListeners: int64(expectedListeners),
 		ExpectedWorkers:   int64(s.Workers),
 	}
 
@@ -137,3 +293,43 @@ func main() {
 	go s.Start(ctx, cancelFunc)
 	<-shutdownFinish
 }
+
+// replay serves requests from pcap file, printing outcome of each one
+func replay(s *server.Server, path string, speed float64, parseOnly bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return s.Replay(r, speed, parseOnly, func(result *server.ReplayResult) {
+		p := result.Request
+		switch {
+		case result.Err != nil:
+			fmt.Printf("%s %v -> %v: %v\n", p.Time.Format(time.RFC3339Nano), p.Src, p.Dst, result.Err)
+		case parseOnly:
+			fmt.Printf("%s %v -> %v: valid=%v %+v\n", p.Time.Format(time.RFC3339Nano), p.Src, p.Dst, result.Valid, result.Packet)
+		default:
+			fmt.Printf("%s %v -> %v: valid=%v responses=%d\n", p.Time.Format(time.RFC3339Nano), p.Src, p.Dst, result.Valid, len(result.Responses))
+		}
+	})
+}
+
+// parseKeyIDs parses comma-separated list of key IDs
+func parseKeyIDs(list string) ([]uint32, error) {
+	var ids []uint32
+	if list == "" {
+		return ids, nil
+	}
+	for _, id := range strings.Split(list, ",") {
+		keyID, err := strconv.ParseUint(strings.TrimSpace(id), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		ids = append(ids, uint32(keyID))
+	}
+	return ids, nil
+}
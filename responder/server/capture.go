@@ -0,0 +1,78 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/responder/xdp"
+)
+
+// remoteAddr returns address of the client
+func (t *task) remoteAddr() *net.UDPAddr {
+	switch a := t.addr.(type) {
+	case *net.UDPAddr:
+		return a
+	case *xdp.Addr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port}
+	}
+	return &net.UDPAddr{}
+}
+
+// localAddr returns address the request arrived to
+func (t *task) localAddr() *net.UDPAddr {
+	a := &net.UDPAddr{Port: t.port}
+	if t.conn != nil {
+		if l, ok := t.conn.LocalAddr().(*net.UDPAddr); ok {
+			a.IP = l.IP
+		}
+	}
+	if x, ok := t.addr.(*xdp.Addr); ok {
+		a.IP = x.Local
+	}
+	if t.local != nil && t.local.Addr != nil {
+		a.IP = t.local.Addr
+	}
+	return a
+}
+
+// captureRequest passes the request to the capture sink along with its kernel receive timestamp
+func (t *task) captureRequest() {
+	if t.capture == nil {
+		return
+	}
+	request, err := t.request.Bytes()
+	if err != nil {
+		return
+	}
+	capture.Capture(t.capture, &capture.Packet{
+		Time:    t.received,
+		Src:     t.remoteAddr(),
+		Dst:     t.localAddr(),
+		Payload: append(request, t.extensions...),
+	})
+}
+
+// captureResponse passes the response to the capture sink. Transmit timestamps are not read back, so it's taken now
+func (t *task) captureResponse(b []byte) {
+	if t.capture == nil {
+		return
+	}
+	capture.Capture(t.capture, &capture.Packet{Time: time.Now(), Src: t.localAddr(), Dst: t.remoteAddr(), Payload: b})
+}
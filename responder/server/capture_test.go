@@ -0,0 +1,66 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCapture struct {
+	packets []*capture.Packet
+}
+
+func (c *testCapture) Capture(p *capture.Packet) {
+	c.packets = append(c.packets, p)
+}
+
+func Test_serveCapture(t *testing.T) {
+	received := time.Unix(1600000000, 0)
+	sink := &testCapture{}
+	task := &task{
+		batch:    &testBatch{},
+		conn:     &net.UDPConn{},
+		port:     123,
+		addr:     &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 40000},
+		local:    &ntp.PktInfo{Addr: net.ParseIP("10.0.0.1")},
+		received: received,
+		request:  &ntp.Packet{Settings: 0x23},
+		stats:    &stats.JSONStats{},
+		capture:  sink,
+	}
+	task.serve(&responseTemplate{}, 0)
+	require.Len(t, sink.packets, 2)
+
+	request := sink.packets[0]
+	assert.Equal(t, received, request.Time, "request is captured with receive timestamp")
+	assert.Equal(t, "192.168.0.1:40000", request.Src.String())
+	assert.Equal(t, "10.0.0.1:123", request.Dst.String())
+	assert.Len(t, request.Payload, ntp.PacketSizeBytes)
+
+	response := sink.packets[1]
+	assert.Equal(t, "10.0.0.1:123", response.Src.String())
+	assert.Equal(t, "192.168.0.1:40000", response.Dst.String())
+	assert.Equal(t, task.batch.(*testBatch).written[0], response.Payload)
+}
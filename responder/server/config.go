@@ -19,7 +19,9 @@ package server
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // DefaultServerIPs is a default list of IPs server will bind to if nothing else is specified
@@ -33,6 +35,106 @@ type ListenConfig struct {
 	Iface          string
 }
 
+// NTSConfig enables Network Time Security. NTS-KE server listens on the same IPs as NTP one
+type NTSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile makes NTS-KE server require client certificates issued by one of CAs from the file
+	ClientCAFile string
+	Port         int
+	// Rotation is how often the master key cookies are encrypted with is replaced
+	Rotation time.Duration
+	// KeysFile has master keys shared with other servers. It replaces rotation and is reloaded on change
+	KeysFile string
+	// Overlap is how long master keys removed from KeysFile keep validating cookies
+	Overlap time.Duration
+	// AEADs restricts AEAD algorithms NTS-KE agrees to, all supported ones if empty
+	AEADs []uint16
+	// NTPServer is the host NTS-KE points clients at instead of itself, e.g. NTP load balancer
+	NTPServer string
+	// NTPPort is the port NTS-KE points clients at, the one NTP server listens on if 0
+	NTPPort int
+}
+
+// Enabled returns true if NTS is configured
+func (c *NTSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// ntpPort returns the port NTS-KE points clients at, listenPort of NTP server unless overridden
+func (c *NTSConfig) ntpPort(listenPort int) int {
+	if c.NTPPort != 0 {
+		return c.NTPPort
+	}
+	return listenPort
+}
+
+// MultiPrefixes is a wrapper allowing to set multiple network prefixes
+type MultiPrefixes []*net.IPNet
+
+// Set adds prefix in CIDR notation to the list
+func (m *MultiPrefixes) Set(cidr string) error {
+	_, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid prefix %s: %w", cidr, err)
+	}
+	*m = append(*m, prefix)
+	return nil
+}
+
+// String returns joined list of prefixes
+func (m *MultiPrefixes) String() string {
+	var prefixes []string
+	for _, prefix := range *m {
+		prefixes = append(prefixes, prefix.String())
+	}
+	return strings.Join(prefixes, ", ")
+}
+
+// Contains returns true if ip belongs to any of the prefixes
+func (m MultiPrefixes) Contains(ip net.IP) bool {
+	for _, prefix := range m {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CPUList is a list of CPUs set as comma separated CPU numbers and ranges, like 0-3,8
+type CPUList []int
+
+// Set adds CPUs to the list
+func (c *CPUList) Set(list string) error {
+	for _, part := range strings.Split(list, ",") {
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 0 {
+			return fmt.Errorf("invalid CPU %q", part)
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			return fmt.Errorf("invalid CPU range %q", part)
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			*c = append(*c, cpu)
+		}
+	}
+	return nil
+}
+
+// String returns comma separated list of CPUs
+func (c *CPUList) String() string {
+	var cpus []string
+	for _, cpu := range *c {
+		cpus = append(cpus, strconv.Itoa(cpu))
+	}
+	return strings.Join(cpus, ",")
+}
+
 // MultiIPs is a wrapper allowing to set multiple IPs
 type MultiIPs []net.IP
 
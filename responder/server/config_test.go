@@ -61,3 +61,36 @@ func Test_ConfigSetDefault(t *testing.T) {
 
 	assert.Equal(t, DefaultServerIPs, m)
 }
+
+func Test_PrefixesSet(t *testing.T) {
+	m := MultiPrefixes{}
+	assert.Nil(t, m.Set("10.0.0.0/8"))
+	assert.Nil(t, m.Set("2001:db8::/32"))
+	assert.NotNil(t, m.Set("10.0.0.1"))
+	assert.Equal(t, "10.0.0.0/8, 2001:db8::/32", m.String())
+
+	assert.True(t, m.Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, m.Contains(net.ParseIP("2001:db8::1")))
+	assert.False(t, m.Contains(net.ParseIP("192.168.0.1")))
+	assert.False(t, MultiPrefixes{}.Contains(net.ParseIP("10.1.2.3")))
+}
+
+func Test_CPUListSet(t *testing.T) {
+	c := CPUList{}
+	assert.Nil(t, c.Set("0-3,8"))
+	assert.Nil(t, c.Set("10"))
+	assert.Equal(t, CPUList{0, 1, 2, 3, 8, 10}, c)
+	assert.Equal(t, "0,1,2,3,8,10", c.String())
+
+	assert.NotNil(t, c.Set("a"))
+	assert.NotNil(t, c.Set("3-1"))
+	assert.NotNil(t, c.Set("-1"))
+	assert.NotNil(t, c.Set("1,"))
+}
+
+func Test_NTSConfigNTPPort(t *testing.T) {
+	c := &NTSConfig{}
+	assert.Equal(t, 1123, c.ntpPort(1123))
+	c.NTPPort = 123
+	assert.Equal(t, 123, c.ntpPort(1123))
+}
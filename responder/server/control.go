@@ -0,0 +1,329 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/control"
+)
+
+// modeControl is the mode of NTP control messages ntpq sends
+const modeControl = 6
+
+// clockSourceNTP is clock source of system status word of servers synchronized over NTP
+const clockSourceNTP = 6
+
+// errCryptoBudget is returned when control query can't be verified as verification budget is exhausted
+var errCryptoBudget = errors.New("crypto budget exhausted")
+
+// ControlAssociation is an association reported over control protocol
+type ControlAssociation struct {
+	ID uint16
+	// Status is peer status word, see control.ReadPeerStatusWord
+	Status    uint16
+	Variables map[string]string
+}
+
+// NewControlAssociation returns association reporting statistics of client association the way ntpd does,
+// reach register included. Selection is one of control.PeerSelect
+func NewControlAssociation(id uint16, selection uint8, s *client.PeerStats) ControlAssociation {
+	status := control.PeerStatusWord{
+		PeerStatus:    control.PeerStatus{Configured: true, Reachable: s.Reach != 0},
+		PeerSelection: selection,
+	}
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		host, port = s.Addr, "123"
+	}
+	return ControlAssociation{
+		ID:     id,
+		Status: status.Word(),
+		Variables: map[string]string{
+			"srcadr":     host,
+			"srcport":    port,
+			"stratum":    strconv.Itoa(s.Stratum),
+			"refid":      formatRefID(s.Stratum, s.RefID),
+			"reach":      fmt.Sprintf("0x%02x", s.Reach),
+			"offset":     milliseconds(s.Offset),
+			"delay":      milliseconds(s.Delay),
+			"dispersion": milliseconds(s.Dispersion),
+			"jitter":     milliseconds(s.Jitter),
+		},
+	}
+}
+
+// formatRefID returns reference ID as ntpd reports it: kiss code or reference clock name for stratum 0 and 1, IPv4 address otherwise
+func formatRefID(stratum int, refID uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, refID)
+	if stratum <= 1 {
+		return strings.TrimRight(string(b), "\x00")
+	}
+	return net.IP(b).String()
+}
+
+// milliseconds formats duration in milliseconds, the unit ntpd reports offsets and delays in
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// ControlSource provides what control queries report: system variables and associations
+type ControlSource interface {
+	SystemVariables() map[string]string
+	Associations() []ControlAssociation
+}
+
+// ControlWriter is ControlSource which lets control queries change variables, system ones for association 0.
+// Writes are only served to clients authenticated with a trusted key
+type ControlWriter interface {
+	WriteVariables(associationID uint16, vars map[string]string) error
+}
+
+// SystemVariables returns variables describing the server as it answers clients
+func (s *Server) SystemVariables() map[string]string {
+	return map[string]string{
+		"version":   "facebook ntp responder",
+		"processor": runtime.GOARCH,
+		"system":    runtime.GOOS,
+		"leap":      "0",
+		"stratum":   strconv.Itoa(s.Stratum),
+		"precision": "-32",
+		"rootdelay": "0.000",
+		// root dispersion of responses, 10/65536s
+		"rootdisp": "0.153",
+		"refid":    s.RefID,
+	}
+}
+
+// Associations returns nothing, the server doesn't talk to other servers
+func (s *Server) Associations() []ControlAssociation {
+	return nil
+}
+
+// serveControl answers control query from the allowed prefixes
+func (t *task) serveControl() {
+	if t.control == nil || !t.controlAllow.Contains(addrIP(t.addr)) {
+		t.logger().Debug("Control query not allowed, discarding", "from", t.addr)
+		t.stats.IncInvalidFormat()
+		t.sys.inc(ssRestricted)
+		return
+	}
+	raw, err := t.request.Bytes()
+	if err != nil {
+		t.logger().Error("Failed to convert request to bytes", "request", t.request, "error", err)
+		return
+	}
+	raw = append(raw, t.extensions...)
+	request, err := control.ParseRequest(raw)
+	if err != nil {
+		t.logger().Info("Invalid control query, discarding", "from", t.addr, "error", err)
+		t.stats.IncInvalidFormat()
+		t.sys.inc(ssBadFormat)
+		return
+	}
+	head := &request.NTPControlMsgHead
+	// answering responses would let two servers bounce error replies off each other forever, ntpd drops them too
+	if head.IsResponse() {
+		t.logger().Debug("Control response instead of query, discarding", "from", t.addr)
+		return
+	}
+	var responses [][]byte
+	key, err := t.verifyControl(raw)
+	if errors.Is(err, errCryptoBudget) {
+		t.logger().Debug("Crypto budget exhausted, discarding control query", "from", t.addr)
+		t.stats.IncCryptoRejects()
+		t.sys.inc(ssLimited)
+		t.emit(audit.CryptoBudget, "")
+		return
+	}
+	switch {
+	case err != nil:
+		t.logger().Info("Unauthenticated control query", "from", t.addr, "error", err)
+		t.sys.inc(ssBadAuth)
+		t.emit(audit.AuthFailure, err.Error())
+		responses = [][]byte{control.ErrorResponse(head, control.ErrorCodePermission)}
+	case request.GetOperation() == control.OpRequestNonce:
+		nonce := t.controlNonces.Issue(addrIP(t.addr), time.Now())
+		responses = control.Responses(head, systemStatus(t.control), control.FormatVariables(map[string]string{"nonce": nonce}, nil))
+	case request.GetOperation() == control.OpWriteVariables:
+		responses = [][]byte{t.controlWrite(request, key)}
+	default:
+		responses = controlResponses(t.control, request)
+	}
+	for _, b := range responses {
+		// responses to authenticated requests are signed with the same key
+		if key != nil {
+			b = control.Sign(key, b)
+		}
+		if err := t.send(b); err != nil {
+			t.logger().Info("Failed to respond to the control query", "to", t.addr, "error", err)
+			return
+		}
+	}
+	t.stats.IncResponses()
+	t.sys.inc(ssProcessed)
+}
+
+// verifyControl authenticates control query signed with a trusted key. Key is nil if the query isn't signed
+func (t *task) verifyControl(raw []byte) (*auth.Key, error) {
+	msg, mac, err := control.SplitMAC(raw)
+	if err != nil || allZero(mac) {
+		return nil, err
+	}
+	if t.keys == nil {
+		return nil, auth.ErrUnknownKey
+	}
+	if !t.limiter.acquire(time.Now()) {
+		return nil, errCryptoBudget
+	}
+	defer t.limiter.release()
+	// datagrams shorter than NTP header arrive zero padded, so MAC may be followed by zeros
+	err = auth.ErrBadMAC
+	for _, size := range []int{auth.KeyIDSizeBytes + md5.Size, auth.KeyIDSizeBytes + sha1.Size} {
+		if len(mac) < size || !allZero(mac[size:]) {
+			continue
+		}
+		var key *auth.Key
+		if key, err = t.keys.Verify(msg, mac[:size]); err == nil {
+			return key, nil
+		}
+	}
+	return nil, err
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// controlWrite changes variables if the query is authenticated and carries valid nonce
+func (t *task) controlWrite(request *control.NTPControlMsg, key *auth.Key) []byte {
+	head := &request.NTPControlMsgHead
+	if key == nil {
+		t.logger().Info("Unauthenticated control write, refusing", "from", t.addr)
+		t.emit(audit.UnauthenticatedDrop, "control write")
+		return control.ErrorResponse(head, control.ErrorCodePermission)
+	}
+	writer, ok := t.control.(ControlWriter)
+	if !ok {
+		return control.ErrorResponse(head, control.ErrorCodeRestricted)
+	}
+	vars, err := control.ParseVariables(request.Data)
+	if err != nil {
+		return control.ErrorResponse(head, control.ErrorCodeFormat)
+	}
+	// nonce proves the client gets responses at its address, so writes can't be replayed from elsewhere or later
+	if err := t.controlNonces.Verify(vars["nonce"], addrIP(t.addr), time.Now()); err != nil {
+		t.logger().Info("Control write with invalid nonce, refusing", "from", t.addr, "error", err)
+		t.emit(audit.Replay, err.Error())
+		return control.ErrorResponse(head, control.ErrorCodePermission)
+	}
+	delete(vars, "nonce")
+	if err := writer.WriteVariables(request.AssociationID, vars); err != nil {
+		t.logger().Info("Control write failed", "from", t.addr, "error", err)
+		return control.ErrorResponse(head, control.ErrorCodeBadValue)
+	}
+	return control.Responses(head, systemStatus(t.control), nil)[0]
+}
+
+// systemStatus returns system status word of the source
+func systemStatus(source ControlSource) uint16 {
+	li, _ := strconv.Atoi(source.SystemVariables()["leap"])
+	return (&control.SystemStatusWord{LI: uint8(li), ClockSource: clockSourceNTP}).Word()
+}
+
+// orderedListInterfaces is the ordered list of interfaces ntpq ifstats asks for
+const orderedListInterfaces = "ifstats"
+
+// controlResponses returns responses to readstat, readvar and ifstats queries
+func controlResponses(source ControlSource, request *control.NTPControlMsg) [][]byte {
+	status := systemStatus(source)
+	vars := source.SystemVariables()
+	stats, hasStats := source.(ControlStats)
+	if request.AssociationID != 0 {
+		found := false
+		for _, a := range source.Associations() {
+			if a.ID == request.AssociationID {
+				status, vars, found = a.Status, a.Variables, true
+				break
+			}
+		}
+		if !found {
+			return [][]byte{control.ErrorResponse(&request.NTPControlMsgHead, control.ErrorCodeUnknownAssociation)}
+		}
+	}
+	switch request.GetOperation() {
+	case control.OpReadStatus:
+		var data []byte
+		if request.AssociationID == 0 {
+			for _, a := range source.Associations() {
+				data = append(data, byte(a.ID>>8), byte(a.ID), byte(a.Status>>8), byte(a.Status))
+			}
+		}
+		return control.Responses(&request.NTPControlMsgHead, status, data)
+	case control.OpReadVariables:
+		if request.AssociationID == 0 && hasStats && len(request.Data) > 0 {
+			// system statistics are only reported by name
+			all := stats.SystemStats()
+			for name, value := range vars {
+				all[name] = value
+			}
+			vars = all
+		}
+		var names []string
+		for _, name := range strings.Split(string(request.Data), ",") {
+			// values of names are ignored, as ntpd does
+			name = strings.TrimSpace(strings.SplitN(name, "=", 2)[0])
+			if name == "" {
+				continue
+			}
+			if _, ok := vars[name]; !ok {
+				return [][]byte{control.ErrorResponse(&request.NTPControlMsgHead, control.ErrorCodeUnknownVariable)}
+			}
+			names = append(names, name)
+		}
+		return control.Responses(&request.NTPControlMsgHead, status, control.FormatVariables(vars, names))
+	case control.OpReadOrderedList:
+		if !hasStats || request.AssociationID != 0 || strings.TrimSpace(string(request.Data)) != orderedListInterfaces {
+			return [][]byte{control.ErrorResponse(&request.NTPControlMsgHead, control.ErrorCodeUnknownVariable)}
+		}
+		list := map[string]string{}
+		var names []string
+		for i, iface := range stats.Interfaces() {
+			names = append(names, iface.variables(i, list)...)
+		}
+		return control.Responses(&request.NTPControlMsgHead, status, control.FormatVariables(list, names))
+	}
+	return [][]byte{control.ErrorResponse(&request.NTPControlMsgHead, control.ErrorCodeOpcode)}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/client"
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/control"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testControlSource struct{}
+
+func (testControlSource) SystemVariables() map[string]string {
+	return map[string]string{"leap": "1", "stratum": "2", "refid": "10.0.0.1"}
+}
+
+func (testControlSource) Associations() []ControlAssociation {
+	return []ControlAssociation{
+		{ID: 1, Status: 0x961a, Variables: map[string]string{"srcadr": "10.0.0.1", "stratum": "1"}},
+		{ID: 2, Status: 0x9414, Variables: map[string]string{"srcadr": "10.0.0.2", "stratum": "1"}},
+	}
+}
+
+func controlRequest(op uint8, assoc uint16, data string) *control.NTPControlMsg {
+	return &control.NTPControlMsg{
+		NTPControlMsgHead: control.NTPControlMsgHead{VnMode: 0x16, REMOp: op, Sequence: 3, AssociationID: assoc, Count: uint16(len(data))},
+		Data:              []byte(data),
+	}
+}
+
+func parseResponse(t *testing.T, b []byte) *control.NTPControlMsg {
+	msg, err := control.ParseRequest(b)
+	require.Nil(t, err)
+	return msg
+}
+
+func Test_controlResponsesReadStatus(t *testing.T) {
+	responses := controlResponses(testControlSource{}, controlRequest(control.OpReadStatus, 0, ""))
+	require.Len(t, responses, 1)
+	msg := parseResponse(t, responses[0])
+	assert.True(t, msg.IsResponse())
+	assert.Equal(t, uint16(3), msg.Sequence)
+	sys, err := msg.GetSystemStatus()
+	require.Nil(t, err)
+	assert.Equal(t, uint8(1), sys.LI)
+	assocs, err := msg.GetAssociations()
+	require.Nil(t, err)
+	assert.Equal(t, "sys.peer", control.PeerSelect[assocs[1].PeerSelection])
+	assert.Equal(t, "candidate", control.PeerSelect[assocs[2].PeerSelection])
+}
+
+func Test_controlResponsesReadVariables(t *testing.T) {
+	responses := controlResponses(testControlSource{}, controlRequest(control.OpReadVariables, 0, ""))
+	require.Len(t, responses, 1)
+	vars, err := parseResponse(t, responses[0]).GetAssociationInfo()
+	require.Nil(t, err)
+	assert.Equal(t, testControlSource{}.SystemVariables(), vars)
+
+	responses = controlResponses(testControlSource{}, controlRequest(control.OpReadVariables, 2, "srcadr"))
+	msg := parseResponse(t, responses[0])
+	assert.Equal(t, uint16(2), msg.AssociationID)
+	assert.Equal(t, uint16(0x9414), msg.Status)
+	assert.Equal(t, "srcadr=10.0.0.2\r\n", string(msg.Data))
+}
+
+func TestNewControlAssociation(t *testing.T) {
+	a := NewControlAssociation(3, 6, &client.PeerStats{
+		Addr:    "10.0.0.1:123",
+		Stratum: 2,
+		RefID:   0x0a000002,
+		Reach:   0xfd,
+		Offset:  1500 * time.Microsecond,
+		Delay:   -250 * time.Microsecond,
+	})
+	assert.Equal(t, uint16(3), a.ID)
+	status := control.ReadPeerStatusWord(a.Status)
+	assert.True(t, status.PeerStatus.Reachable)
+	assert.True(t, status.PeerStatus.Configured)
+	assert.Equal(t, "sys.peer", control.PeerSelect[status.PeerSelection])
+	assert.Equal(t, "10.0.0.1", a.Variables["srcadr"])
+	assert.Equal(t, "123", a.Variables["srcport"])
+	assert.Equal(t, "10.0.0.2", a.Variables["refid"])
+	assert.Equal(t, "0xfd", a.Variables["reach"])
+	assert.Equal(t, "1.500", a.Variables["offset"])
+	assert.Equal(t, "-0.250", a.Variables["delay"])
+
+	a = NewControlAssociation(4, 0, &client.PeerStats{Addr: "10.0.0.3:123", Stratum: 1, RefID: 0x47505300})
+	assert.False(t, control.ReadPeerStatusWord(a.Status).PeerStatus.Reachable)
+	assert.Equal(t, "GPS", a.Variables["refid"])
+	assert.Equal(t, "0x00", a.Variables["reach"])
+}
+
+func Test_controlResponsesErrors(t *testing.T) {
+	for _, request := range []*control.NTPControlMsg{
+		controlRequest(control.OpReadVariables, 3, ""),
+		controlRequest(control.OpReadVariables, 0, "nonsense"),
+		controlRequest(7, 0, ""),
+	} {
+		responses := controlResponses(testControlSource{}, request)
+		require.Len(t, responses, 1)
+		assert.True(t, parseResponse(t, responses[0]).HasError())
+	}
+}
+
+func Test_serveControl(t *testing.T) {
+	allow := MultiPrefixes{}
+	require.Nil(t, allow.Set("10.0.0.0/8"))
+	request := &ntp.Packet{Settings: 0x16, Stratum: control.OpReadVariables, Precision: 0x01}
+	s := &Server{Stratum: 1, RefID: "GPS"}
+
+	batch := &testBatch{}
+	serve := func(ip string) {
+		task := &task{
+			batch:        batch,
+			conn:         &net.UDPConn{},
+			addr:         &net.UDPAddr{IP: net.ParseIP(ip), Port: 123},
+			request:      request,
+			stats:        &stats.JSONStats{},
+			control:      s.controlSource(),
+			controlAllow: allow,
+		}
+		task.serve(&responseTemplate{}, 0)
+	}
+	serve("192.168.0.1")
+	assert.Equal(t, 0, len(batch.written), "other prefixes are not answered")
+	serve("10.0.0.1")
+	require.Equal(t, 1, len(batch.written))
+	msg := parseResponse(t, batch.written[0])
+	assert.Equal(t, uint16(1), msg.Sequence)
+	vars, err := control.ParseVariables(msg.Data)
+	require.Nil(t, err)
+	assert.Equal(t, "1", vars["stratum"])
+	assert.Equal(t, "GPS", vars["refid"])
+
+	// responses are never answered
+	batch.written = nil
+	request.Stratum |= 0x80
+	serve("10.0.0.1")
+	assert.Equal(t, 0, len(batch.written), "responses are not answered")
+}
+
+type testControlWriter struct {
+	testControlSource
+	written map[string]string
+}
+
+func (w *testControlWriter) WriteVariables(associationID uint16, vars map[string]string) error {
+	w.written = vars
+	return nil
+}
+
+func Test_serveControlWrite(t *testing.T) {
+	allow := MultiPrefixes{}
+	require.Nil(t, allow.Set("10.0.0.0/8"))
+	key := &auth.Key{ID: 5, Algorithm: auth.SHA1, Secret: []byte("secret")}
+	keys := auth.NewKeys(key)
+	require.Nil(t, keys.Trust(key.ID))
+	nonces, err := control.NewNonces()
+	require.Nil(t, err)
+	writer := &testControlWriter{}
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 123}
+
+	batch := &testBatch{}
+	serve := func(b []byte) *control.NTPControlMsg {
+		request, err := ntp.BytesToPacket(b[:48])
+		require.Nil(t, err)
+		task := &task{
+			batch:         batch,
+			conn:          &net.UDPConn{},
+			addr:          addr,
+			request:       request,
+			extensions:    b[48:],
+			keys:          keys,
+			stats:         &stats.JSONStats{},
+			control:       writer,
+			controlAllow:  allow,
+			controlNonces: nonces,
+		}
+		batch.written = nil
+		task.serve(&responseTemplate{}, 0)
+		require.Equal(t, 1, len(batch.written))
+		msg, mac, err := control.SplitMAC(batch.written[0])
+		require.Nil(t, err)
+		if mac != nil {
+			assert.Nil(t, key.Verify(msg, mac))
+		}
+		return parseResponse(t, msg)
+	}
+	// datagrams shorter than NTP header arrive zero padded
+	request := func(op uint8, data string, signed bool) []byte {
+		var buf bytes.Buffer
+		h := control.NTPControlMsgHead{VnMode: 0x16, REMOp: op, Sequence: 2, Count: uint16(len(data))}
+		require.Nil(t, binary.Write(&buf, binary.BigEndian, h))
+		buf.WriteString(data)
+		b := buf.Bytes()
+		if signed {
+			b = control.Sign(key, b)
+		}
+		for len(b) < 48 {
+			b = append(b, 0)
+		}
+		return b
+	}
+
+	msg := serve(request(control.OpRequestNonce, "", false))
+	require.False(t, msg.HasError())
+	vars, err := control.ParseVariables(msg.Data)
+	require.Nil(t, err)
+	nonce := vars["nonce"]
+	require.Nil(t, nonces.Verify(nonce, addr.IP, time.Now()))
+
+	data := "nonce=" + nonce + ", leap=1"
+	msg = serve(request(control.OpWriteVariables, data, false))
+	assert.True(t, msg.HasError(), "unauthenticated write is refused")
+	assert.Nil(t, writer.written)
+
+	msg = serve(request(control.OpWriteVariables, "nonce=00000000aabbccddeeff0011, leap=1", true))
+	assert.True(t, msg.HasError(), "write with bad nonce is refused")
+	assert.Nil(t, writer.written)
+
+	msg = serve(request(control.OpWriteVariables, data, true))
+	assert.False(t, msg.HasError())
+	assert.Equal(t, map[string]string{"leap": "1"}, writer.written)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// DebugHandler returns handler of the standard debug endpoints: expvar variables at /debug/vars
+// and pprof profiles under /debug/pprof/. It is meant for listeners only operators can reach
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// publishExpvar publishes internal counters of the server as expvar variable with the name
+func (s *Server) publishExpvar(name string) error {
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q is already published", name)
+	}
+	expvar.Publish(name, expvar.Func(s.expvars))
+	return nil
+}
+
+// expvars returns internal counters of the server: ntpd-like system and interface counters,
+// rate limiter status and how many clients it tracks, how many sources of mode 7 probes are tracked,
+// how many clients MRU list keeps and how many workers are active if they are tuned
+func (s *Server) expvars() interface{} {
+	sys := map[string]uint64{}
+	for c, name := range sysCounterNames {
+		sys[name] = s.sys.get(sysCounter(c))
+	}
+	var uptime time.Duration
+	if s.sys != nil {
+		uptime = time.Since(s.sys.started)
+	}
+	return map[string]interface{}{
+		"uptime_seconds": int64(uptime.Seconds()),
+		"sysstats":       sys,
+		"interfaces":     s.Interfaces(),
+		"ratelimit":      s.RateLimit(),
+		"rate_clients":   s.rates.size(),
+		"probe_sources":  s.probes.size(),
+		"mru_clients":    s.mru.size(),
+		"active_workers": s.tuner.activeWorkers(),
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DebugHandler(t *testing.T) {
+	s := &Server{Expvar: "test_debug_handler", CryptoRate: 10}
+	s.ListenConfig.IPs = []net.IP{net.ParseIP("192.0.2.1")}
+	require.Nil(t, s.setup())
+	s.sys.inc(ssProcessed)
+	h := DebugHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var vars struct {
+		Server struct {
+			Sysstats   map[string]uint64  `json:"sysstats"`
+			Interfaces []ControlInterface `json:"interfaces"`
+			Ratelimit  struct {
+				Rate int `json:"rate"`
+			} `json:"ratelimit"`
+		} `json:"test_debug_handler"`
+	}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &vars))
+	assert.Equal(t, uint64(1), vars.Server.Sysstats["ss_processed"])
+	require.Len(t, vars.Server.Interfaces, 1)
+	assert.Equal(t, "192.0.2.1", vars.Server.Interfaces[0].Addr)
+	assert.Equal(t, 10, vars.Server.Ratelimit.Rate)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine")
+
+	assert.NotNil(t, s.setup(), "expvar is published once")
+}
@@ -18,6 +18,8 @@ package server
 
 import (
 	"net"
+
+	"github.com/facebookincubator/ntp/protocol/auth"
 )
 
 // Stats is a metric collection interface
@@ -44,6 +46,24 @@ type Stats interface {
 	IncListeners()
 	// IncWorkers atomically add 1 to the counter
 	IncWorkers()
+	// IncNTSRequests atomically add 1 to the counter
+	IncNTSRequests()
+	// IncNTSNAKs atomically add 1 to the counter
+	IncNTSNAKs()
+	// IncCryptoNAKs atomically add 1 to the counter
+	IncCryptoNAKs()
+	// IncAuthenticated atomically add 1 to the counter
+	IncAuthenticated()
+	// IncUnauthenticatedDrops atomically add 1 to the counter
+	IncUnauthenticatedDrops()
+	// IncCryptoRejects atomically add 1 to the counter
+	IncCryptoRejects()
+	// IncMode7Probes atomically add 1 to the counter
+	IncMode7Probes()
+	// IncW32TimeSymmetric atomically add 1 to the counter
+	IncW32TimeSymmetric()
+	// IncW32TimePoll atomically add 1 to the counter
+	IncW32TimePoll()
 
 	// DecListeners atomically removes 1 from the counter
 	DecListeners()
@@ -82,3 +102,9 @@ type Checker interface {
 	// DecWorkers atomically removes 1 from the counter
 	DecWorkers()
 }
+
+// KeyVerifier authenticates requests signed with symmetric keys, e.g. *auth.Keys or *auth.KeyFile
+type KeyVerifier interface {
+	// Verify authenticates the request and returns the key the response is signed with
+	Verify(header, extensions []byte) (*auth.Key, error)
+}
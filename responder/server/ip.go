@@ -19,8 +19,6 @@ package server
 import (
 	"fmt"
 	"net"
-
-	log "github.com/sirupsen/logrus"
 )
 
 const bitsInBytes = 8
@@ -39,7 +37,7 @@ const ipv6Len = net.IPv6len * bitsInBytes
 
 // AddIPOnInterface adds ip to interface
 func (s *Server) addIPToInterface(vip net.IP) error {
-	log.Debugf("Adding %s to %s", vip, s.ListenConfig.Iface)
+	s.logger().Debug("Adding IP to the interface", "ip", vip, "interface", s.ListenConfig.Iface)
 	// Add IPs to the interface
 	iface, err := net.InterfaceByName(s.ListenConfig.Iface)
 	if err != nil {
@@ -51,7 +49,7 @@ func (s *Server) addIPToInterface(vip net.IP) error {
 
 // deleteIPFromInterface deletes ip from interface
 func (s *Server) deleteIPFromInterface(vip net.IP) error {
-	log.Debugf("Deleting %s to %s", vip, s.ListenConfig.Iface)
+	s.logger().Debug("Deleting IP from the interface", "ip", vip, "interface", s.ListenConfig.Iface)
 	// Delete IPs to the interface
 	iface, err := net.InterfaceByName(s.ListenConfig.Iface)
 	if err != nil {
@@ -66,7 +64,7 @@ func (s *Server) DeleteAllIPs() {
 	for _, vip := range s.ListenConfig.IPs {
 		if err := s.deleteIPFromInterface(vip); err != nil {
 			// Don't return error. Continue deleting
-			log.Errorf("[server]: %v", err)
+			s.logger().Error("Failed to delete IP from the interface", "ip", vip, "error", err)
 		}
 	}
 }
@@ -45,7 +45,6 @@ func addIfaceIP(iface *net.Interface, addr *net.IP) error {
 	}
 
 	cmd := exec.Command("ifconfig", iface.Name, proto, "alias", fmt.Sprintf("%s/%d", addr.String(), mask))
-	fmt.Println(cmd.Args)
 	if err := cmd.Run(); err != nil {
 		return errors.Wrap(err, "can't add address")
 	}
@@ -0,0 +1,105 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookincubator/ntp/manage"
+)
+
+// cryptoLimiter bounds MAC and NTS verification work, so floods of authenticated requests can't take all the CPU.
+// Rate is enforced with token bucket, concurrency with a counter. Zero values mean no limit
+type cryptoLimiter struct {
+	rate        float64
+	burst       float64
+	concurrency int64
+
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	inflight int64
+	rejected int64
+}
+
+// newCryptoLimiter returns limiter allowing rate verifications per second with bursts of up to a second worth of them,
+// no more than concurrency at once. It returns nil if there are no limits
+func newCryptoLimiter(rate, concurrency int) *cryptoLimiter {
+	if rate <= 0 && concurrency <= 0 {
+		return nil
+	}
+	return &cryptoLimiter{rate: float64(rate), burst: float64(rate), tokens: float64(rate), concurrency: int64(concurrency)}
+}
+
+// acquire reserves budget for one verification. It has to be released if acquired
+func (l *cryptoLimiter) acquire(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	if l.concurrency > 0 && atomic.AddInt64(&l.inflight, 1) > l.concurrency {
+		atomic.AddInt64(&l.inflight, -1)
+		atomic.AddInt64(&l.rejected, 1)
+		return false
+	}
+	if l.rate > 0 && !l.take(now) {
+		l.release()
+		atomic.AddInt64(&l.rejected, 1)
+		return false
+	}
+	return true
+}
+
+// status returns limits along with verifications in flight and rejected so far
+func (l *cryptoLimiter) status() *manage.RateLimit {
+	if l == nil {
+		return &manage.RateLimit{}
+	}
+	return &manage.RateLimit{
+		Rate:        int(l.rate),
+		Concurrency: int(l.concurrency),
+		InFlight:    atomic.LoadInt64(&l.inflight),
+		Rejected:    atomic.LoadInt64(&l.rejected),
+	}
+}
+
+// take takes a token from the bucket refilled since the last call
+func (l *cryptoLimiter) take(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// release returns concurrency budget taken by acquire
+func (l *cryptoLimiter) release() {
+	if l == nil || l.concurrency <= 0 {
+		return
+	}
+	atomic.AddInt64(&l.inflight, -1)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/manage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cryptoLimiterNoLimits(t *testing.T) {
+	var l *cryptoLimiter
+	assert.Nil(t, newCryptoLimiter(0, 0))
+	assert.True(t, l.acquire(time.Now()))
+	l.release()
+}
+
+func Test_cryptoLimiterRate(t *testing.T) {
+	l := newCryptoLimiter(10, 0)
+	now := time.Now()
+	// bucket starts full
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.acquire(now))
+		l.release()
+	}
+	assert.False(t, l.acquire(now))
+	assert.False(t, l.acquire(now.Add(50*time.Millisecond)))
+	assert.True(t, l.acquire(now.Add(100*time.Millisecond)))
+	// bucket doesn't fill beyond a second worth of tokens
+	later := now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.acquire(later))
+	}
+	assert.False(t, l.acquire(later))
+}
+
+func Test_cryptoLimiterConcurrency(t *testing.T) {
+	l := newCryptoLimiter(0, 2)
+	now := time.Now()
+	assert.True(t, l.acquire(now))
+	assert.True(t, l.acquire(now))
+	assert.False(t, l.acquire(now))
+	l.release()
+	assert.True(t, l.acquire(now))
+	assert.Equal(t, int64(2), l.inflight)
+}
+
+func Test_cryptoLimiterRateAndConcurrency(t *testing.T) {
+	l := newCryptoLimiter(1, 5)
+	now := time.Now()
+	assert.True(t, l.acquire(now))
+	// rejected by rate, concurrency budget is given back
+	assert.False(t, l.acquire(now))
+	assert.Equal(t, int64(1), l.inflight)
+}
+
+func Test_cryptoLimiterStatus(t *testing.T) {
+	var none *cryptoLimiter
+	assert.Equal(t, &manage.RateLimit{}, none.status())
+	l := newCryptoLimiter(0, 1)
+	now := time.Now()
+	assert.True(t, l.acquire(now))
+	assert.False(t, l.acquire(now))
+	assert.Equal(t, &manage.RateLimit{Concurrency: 1, InFlight: 1, Rejected: 1}, l.status())
+}
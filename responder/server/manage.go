@@ -0,0 +1,45 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/facebookincubator/ntp/manage"
+)
+
+// SyncState returns what the server tells clients about its synchronization. It doesn't steer the clock itself
+func (s *Server) SyncState() *manage.SyncState {
+	return &manage.SyncState{Stratum: s.Stratum, RefID: s.RefID}
+}
+
+// Peers returns nothing, the server doesn't talk to other servers
+func (s *Server) Peers() []manage.Peer {
+	return nil
+}
+
+// RateLimit returns the status of the limiter of MAC and NTS verifications
+func (s *Server) RateLimit() *manage.RateLimit {
+	return s.limiter.status()
+}
+
+// serveManage serves management API on ManageSocket
+func (s *Server) serveManage() {
+	s.logger().Info("Serving management API", "socket", s.ManageSocket)
+	api := &manage.API{Backend: s, Logger: s.logger()}
+	if err := api.Serve(s.ManageSocket); err != nil {
+		s.logger().Error("Management API failed", "error", err)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/ntp/internal/counter"
+	"github.com/facebookincubator/ntp/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsNamespace prefixes names of all Prometheus metrics of the server
+const metricsNamespace = "ntp_server"
+
+func serverDesc(name, help string, t telemetry.Type, labels ...string) *telemetry.Desc {
+	return telemetry.NewDesc(metricsNamespace+"_"+name, help, t, labels...)
+}
+
+var (
+	requestsDesc     = serverDesc("requests_total", "Requests received, by mode and version", telemetry.Counter, "mode", "version")
+	responsesDesc    = serverDesc("responses_total", "Requests answered, by reason: processed or kod", telemetry.Counter, "reason")
+	dropsDesc        = serverDesc("drops_total", "Requests dropped, by reason: badformat, badauth, declined, restricted or limited", telemetry.Counter, "reason")
+	rateLimitDesc    = serverDesc("ratelimit_hits_total", "Requests rejected by MAC and NTS verification rate limiter", telemetry.Counter)
+	probeSourcesDesc = serverDesc("probe_sources", "Sources of mode 7 probes being tracked", telemetry.Gauge)
+	mruClientsDesc   = serverDesc("mru_clients", "Clients in the list of most recently seen ones", telemetry.Gauge)
+)
+
+// dropReasons are sysStats counters of requests dropped, by reason they are reported with
+var dropReasons = map[sysCounter]string{
+	ssBadFormat:  "badformat",
+	ssBadAuth:    "badauth",
+	ssDeclined:   "declined",
+	ssRestricted: "restricted",
+	ssLimited:    "limited",
+}
+
+// responseReasons are sysStats counters of requests answered, by reason they are reported with
+var responseReasons = map[sysCounter]string{
+	ssProcessed: "processed",
+	ssKoDSent:   "kod",
+}
+
+// metrics is Prometheus collector and telemetry source of the server. Requests are counted by mode and version
+// with sharded counters
+// and their processing latency, from kernel receive timestamp to send, is observed by mode.
+// Responses, drops and rate limit hits are reported from counters the server keeps anyway.
+// Latency is reported to Prometheus only. Nil one counts nothing
+type metrics struct {
+	// requests are indexed by mode<<3 | version
+	requests *counter.Set
+	latency  *prometheus.HistogramVec
+
+	sys     *sysStats
+	limiter *cryptoLimiter
+	probes  *probeTracker
+	mru     *mruList
+}
+
+// newMetrics returns collector of the server. It has to be called after counters of the server are set up
+func newMetrics(s *Server) *metrics {
+	return &metrics{
+		requests: counter.NewSet(64),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "processing_seconds",
+			Help:      "Time from receiving request to sending response, by request mode",
+			// 1us to 32ms
+			Buckets: prometheus.ExponentialBuckets(1e-6, 2, 16),
+		}, []string{"mode"}),
+		sys:     s.sys,
+		limiter: s.limiter,
+		probes:  s.probes,
+		mru:     s.mru,
+	}
+}
+
+// request counts request with the first byte of the header
+func (m *metrics) request(settings uint8) {
+	if m == nil {
+		return
+	}
+	m.requests.Inc(int(settings&0x7)<<3 | int(settings>>3&0x7))
+}
+
+// observe records processing latency of request of the mode received at the time
+func (m *metrics) observe(mode uint8, received time.Time) {
+	if m == nil || received.IsZero() {
+		return
+	}
+	m.latency.WithLabelValues(strconv.Itoa(int(mode))).Observe(time.Since(received).Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
+	telemetry.Describe(m, ch)
+	m.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *metrics) Collect(ch chan<- prometheus.Metric) {
+	telemetry.Collect(m, ch)
+	m.latency.Collect(ch)
+}
+
+// Descs implements telemetry.Source
+func (m *metrics) Descs() []*telemetry.Desc {
+	return []*telemetry.Desc{requestsDesc, responsesDesc, dropsDesc, rateLimitDesc, probeSourcesDesc, mruClientsDesc}
+}
+
+// Metrics implements telemetry.Source
+func (m *metrics) Metrics() []telemetry.Metric {
+	var metrics []telemetry.Metric
+	add := func(d *telemetry.Desc, v float64, labels ...string) {
+		metrics = append(metrics, telemetry.Metric{Desc: d, LabelValues: labels, Value: v})
+	}
+	for mode := 0; mode < 8; mode++ {
+		for version := 0; version < 8; version++ {
+			n := m.requests.Load(mode<<3 | version)
+			if n == 0 {
+				continue
+			}
+			add(requestsDesc, float64(n), strconv.Itoa(mode), strconv.Itoa(version))
+		}
+	}
+	for c, reason := range responseReasons {
+		add(responsesDesc, float64(m.sys.get(c)), reason)
+	}
+	for c, reason := range dropReasons {
+		add(dropsDesc, float64(m.sys.get(c)), reason)
+	}
+	add(rateLimitDesc, float64(m.limiter.status().Rejected))
+	add(probeSourcesDesc, float64(m.probes.size()))
+	add(mruClientsDesc, float64(m.mru.size()))
+	return metrics
+}
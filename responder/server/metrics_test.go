@@ -0,0 +1,118 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/facebookincubator/ntp/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, r *prometheus.Registry) string {
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(w.Body)
+	require.Nil(t, err)
+	return string(body)
+}
+
+func Test_metrics(t *testing.T) {
+	r := prometheus.NewRegistry()
+	s := &Server{Prometheus: r, CryptoRate: 1, MRUSize: 10}
+	require.Nil(t, s.setup())
+	require.NotNil(t, s.metrics)
+
+	batch := &testBatch{}
+	serve := func(request *ntp.Packet) {
+		task := &task{
+			batch:    batch,
+			conn:     &net.UDPConn{},
+			addr:     &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 123},
+			received: time.Now(),
+			request:  request,
+			stats:    &stats.JSONStats{},
+			limiter:  s.limiter,
+			probes:   s.probes,
+			mru:      s.mru,
+			sys:      s.sys,
+			metrics:  s.metrics,
+		}
+		task.serve(&responseTemplate{}, 0)
+	}
+	// version 4 and version 3 client requests, version 2 mode 7 probe, version 4 symmetric active
+	serve(&ntp.Packet{Settings: 0x23})
+	serve(&ntp.Packet{Settings: 0x23})
+	serve(&ntp.Packet{Settings: 0x1b})
+	serve(&ntp.Packet{Settings: 0x17, Precision: 42})
+	serve(&ntp.Packet{Settings: 0x21})
+	require.Equal(t, 3, len(batch.written))
+
+	body := scrape(t, r)
+	assert.Contains(t, body, `ntp_server_requests_total{mode="3",version="4"} 2`)
+	assert.Contains(t, body, `ntp_server_requests_total{mode="3",version="3"} 1`)
+	assert.Contains(t, body, `ntp_server_requests_total{mode="7",version="2"} 1`)
+	assert.Contains(t, body, `ntp_server_requests_total{mode="1",version="4"} 1`)
+	assert.Contains(t, body, `ntp_server_responses_total{reason="processed"} 3`)
+	assert.Contains(t, body, `ntp_server_responses_total{reason="kod"} 0`)
+	assert.Contains(t, body, `ntp_server_drops_total{reason="restricted"} 1`)
+	assert.Contains(t, body, `ntp_server_drops_total{reason="badformat"} 1`)
+	assert.Contains(t, body, `ntp_server_ratelimit_hits_total 0`)
+	assert.Contains(t, body, `ntp_server_probe_sources 1`)
+	assert.Contains(t, body, `ntp_server_mru_clients 1`)
+	assert.Contains(t, body, `ntp_server_processing_seconds_count{mode="3"} 3`)
+
+	assert.NotNil(t, s.setup(), "collector is registered once")
+}
+
+func Test_metricsNil(t *testing.T) {
+	var m *metrics
+	m.request(0x23)
+	m.observe(3, time.Now())
+}
+
+// sinkRecorder keeps metrics written to it
+type sinkRecorder struct {
+	metrics []telemetry.Metric
+}
+
+func (r *sinkRecorder) Write(metrics []telemetry.Metric) error {
+	r.metrics = append(r.metrics, metrics...)
+	return nil
+}
+
+func Test_metricsSink(t *testing.T) {
+	sink := &sinkRecorder{}
+	s := &Server{MetricsSink: sink}
+	require.Nil(t, s.setup())
+	require.NotNil(t, s.metrics)
+	s.metrics.request(0x23)
+
+	require.Nil(t, telemetry.Report(sink, s.metrics))
+	assert.Contains(t, sink.metrics, telemetry.Metric{Desc: requestsDesc, LabelValues: []string{"3", "4"}, Value: 1})
+	assert.Contains(t, sink.metrics, telemetry.Metric{Desc: mruClientsDesc, Value: 0})
+	assert.Equal(t, DefaultMetricsInterval, s.metricsInterval())
+}
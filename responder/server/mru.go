@@ -0,0 +1,274 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"hash/maphash"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/manage"
+)
+
+const (
+	// mruEntryBytes is how much memory a client takes: its slot in the table and in the index, with some slack
+	mruEntryBytes = 128
+	// mruShardSize is how many clients a shard keeps at least, small lists aren't sharded at all
+	mruShardSize = 4096
+	// mruMaxShards caps the number of shards, each has its own lock
+	mruMaxShards = 64
+	// mruEvictBatch is how many idle clients are forgotten at most with every request
+	mruEvictBatch = 2
+)
+
+// mruNil marks the end of the order and of the free list
+const mruNil = -1
+
+// mruEntry is a client kept in the list. It has no pointers, so the garbage collector doesn't scan
+// millions of them, and entries are linked into the order by their indexes in the table
+type mruEntry struct {
+	addr       [16]byte
+	first      int64
+	last       int64
+	count      uint64
+	kod        uint64
+	settings   uint8
+	prev, next int32
+}
+
+// mruShard is a part of the list with its own lock, order and table of clients
+type mruShard struct {
+	mu  sync.Mutex
+	max int
+	// table grows up to max entries, slots of forgotten clients are reused
+	table []mruEntry
+	index map[[16]byte]int32
+	// head is the most recently seen client, tail the least recently seen one
+	head, tail int32
+	free       int32
+}
+
+// mruList keeps the most recently seen clients like ntpd MRU list does, forgetting the least recently seen
+// ones first. Clients are spread over shards, so with many of them the least recently seen client
+// of a shard goes rather than of the whole list. Every request costs O(1), including eviction, and clients
+// not seen for maxAge are forgotten a few per request instead of all at once.
+// It is safe for concurrent use, nil one keeps nothing
+type mruList struct {
+	seed   maphash.Seed
+	maxAge time.Duration
+	shards []mruShard
+}
+
+// newMRUList returns list of max clients forgetting those not seen for maxAge, if it's set.
+// It is nil if max is not positive
+func newMRUList(max int, maxAge time.Duration) *mruList {
+	if max <= 0 {
+		return nil
+	}
+	n := 1
+	for n < mruMaxShards && n*2*mruShardSize <= max {
+		n *= 2
+	}
+	m := &mruList{seed: maphash.MakeSeed(), maxAge: maxAge, shards: make([]mruShard, n)}
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.max = max / n
+		if i < max%n {
+			sh.max++
+		}
+		sh.index = make(map[[16]byte]int32, min(sh.max, mruShardSize))
+		sh.head, sh.tail, sh.free = mruNil, mruNil, mruNil
+	}
+	return m
+}
+
+// mruCapacity returns how many clients fit into size and memory limits, memory isn't limited if it's not positive
+func mruCapacity(size int, memory int64) int {
+	if memory > 0 && int64(size) > memory/mruEntryBytes {
+		return int(memory / mruEntryBytes)
+	}
+	return size
+}
+
+// mruKey returns the address as the list keeps it
+func mruKey(ip net.IP) [16]byte {
+	var key [16]byte
+	copy(key[:], ip.To16())
+	return key
+}
+
+// shard returns the shard the client belongs to
+func (m *mruList) shard(key *[16]byte) *mruShard {
+	if len(m.shards) == 1 {
+		return &m.shards[0]
+	}
+	return &m.shards[maphash.Bytes(m.seed, key[:])&uint64(len(m.shards)-1)]
+}
+
+// record counts request with the first byte of the header from the address
+func (m *mruList) record(ip net.IP, settings uint8, now time.Time) {
+	if m == nil {
+		return
+	}
+	key := mruKey(ip)
+	sh := m.shard(&key)
+	ts := now.UnixNano()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if m.maxAge > 0 {
+		sh.evictIdle(ts - int64(m.maxAge))
+	}
+	i, ok := sh.index[key]
+	if ok {
+		sh.unlink(i)
+	} else {
+		i = sh.alloc()
+		sh.table[i] = mruEntry{addr: key, first: ts}
+		sh.index[key] = i
+	}
+	sh.pushFront(i)
+	e := &sh.table[i]
+	e.settings = settings
+	e.count++
+	e.last = ts
+}
+
+// kod counts kiss-o'-death or NAK response to the address
+func (m *mruList) kod(ip net.IP) {
+	if m == nil {
+		return
+	}
+	key := mruKey(ip)
+	sh := m.shard(&key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if i, ok := sh.index[key]; ok {
+		sh.table[i].kod++
+	}
+}
+
+// size returns how many clients are kept
+func (m *mruList) size() int {
+	if m == nil {
+		return 0
+	}
+	n := 0
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		n += len(sh.index)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// entries returns copies of the kept clients, the most recently seen first
+func (m *mruList) entries() []manage.MRUEntry {
+	if m == nil {
+		return nil
+	}
+	var result []manage.MRUEntry
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		for j := sh.head; j != mruNil; j = sh.table[j].next {
+			result = append(result, sh.table[j].export())
+		}
+		sh.mu.Unlock()
+	}
+	if len(m.shards) > 1 {
+		sort.SliceStable(result, func(i, j int) bool { return result[i].Last.After(result[j].Last) })
+	}
+	return result
+}
+
+// export converts the entry to the management API one
+func (e *mruEntry) export() manage.MRUEntry {
+	return manage.MRUEntry{
+		Addr:    netip.AddrFrom16(e.addr).Unmap().String(),
+		Mode:    int(e.settings & 0x7),
+		Version: int(e.settings >> 3 & 0x7),
+		Count:   e.count,
+		KoD:     e.kod,
+		First:   time.Unix(0, e.first),
+		Last:    time.Unix(0, e.last),
+	}
+}
+
+// alloc returns a free slot of the table, forgetting the least recently seen client if the shard is full
+func (sh *mruShard) alloc() int32 {
+	if sh.free != mruNil {
+		i := sh.free
+		sh.free = sh.table[i].next
+		return i
+	}
+	if len(sh.table) < sh.max {
+		sh.table = append(sh.table, mruEntry{})
+		return int32(len(sh.table) - 1)
+	}
+	i := sh.tail
+	sh.unlink(i)
+	delete(sh.index, sh.table[i].addr)
+	return i
+}
+
+// evictIdle forgets a few least recently seen clients if they weren't seen since before
+func (sh *mruShard) evictIdle(before int64) {
+	for n := 0; n < mruEvictBatch && sh.tail != mruNil && sh.table[sh.tail].last < before; n++ {
+		i := sh.tail
+		sh.unlink(i)
+		delete(sh.index, sh.table[i].addr)
+		sh.table[i].next = sh.free
+		sh.free = i
+	}
+}
+
+// unlink takes the entry out of the order
+func (sh *mruShard) unlink(i int32) {
+	e := &sh.table[i]
+	if e.prev != mruNil {
+		sh.table[e.prev].next = e.next
+	} else {
+		sh.head = e.next
+	}
+	if e.next != mruNil {
+		sh.table[e.next].prev = e.prev
+	} else {
+		sh.tail = e.prev
+	}
+	e.prev, e.next = mruNil, mruNil
+}
+
+// pushFront puts the entry in front of the order
+func (sh *mruShard) pushFront(i int32) {
+	e := &sh.table[i]
+	e.prev, e.next = mruNil, sh.head
+	if sh.head != mruNil {
+		sh.table[sh.head].prev = i
+	} else {
+		sh.tail = i
+	}
+	sh.head = i
+}
+
+// MRU returns the most recently seen clients, the most recent first. It is empty unless MRUSize is set
+func (s *Server) MRU() []manage.MRUEntry {
+	return s.mru.entries()
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mruList(t *testing.T) {
+	m := newMRUList(2, 0)
+	now := time.Unix(1600000000, 0)
+	m.record(net.ParseIP("10.0.0.1"), 0x23, now)
+	m.record(net.ParseIP("10.0.0.2"), 0x1b, now.Add(time.Second))
+	m.record(net.ParseIP("10.0.0.1"), 0x23, now.Add(2*time.Second))
+	m.kod(net.ParseIP("10.0.0.1"))
+	m.kod(net.ParseIP("10.0.0.9"))
+
+	entries := m.entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "10.0.0.1", entries[0].Addr)
+	assert.Equal(t, 3, entries[0].Mode)
+	assert.Equal(t, 4, entries[0].Version)
+	assert.Equal(t, uint64(2), entries[0].Count)
+	assert.Equal(t, uint64(1), entries[0].KoD)
+	assert.Equal(t, now, entries[0].First)
+	assert.Equal(t, now.Add(2*time.Second), entries[0].Last)
+	assert.Equal(t, 3, entries[1].Version)
+
+	m.record(net.ParseIP("10.0.0.3"), 0x23, now.Add(3*time.Second))
+	entries = m.entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "10.0.0.3", entries[0].Addr)
+	assert.Equal(t, "10.0.0.1", entries[1].Addr, "least recently seen client is forgotten")
+	assert.Equal(t, 2, m.size())
+}
+
+func Test_mruListDisabled(t *testing.T) {
+	m := newMRUList(0, 0)
+	assert.Nil(t, m)
+	m.record(net.ParseIP("10.0.0.1"), 0x23, time.Now())
+	m.kod(net.ParseIP("10.0.0.1"))
+	assert.Equal(t, 0, m.size())
+	assert.Empty(t, (&Server{}).MRU())
+}
+
+func Test_mruListIPv6(t *testing.T) {
+	m := newMRUList(2, 0)
+	m.record(net.ParseIP("2001:db8::1"), 0x23, time.Now())
+	m.record(net.ParseIP("10.0.0.1").To4(), 0x23, time.Now())
+	entries := m.entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "10.0.0.1", entries[0].Addr)
+	assert.Equal(t, "2001:db8::1", entries[1].Addr)
+}
+
+func Test_mruListMaxAge(t *testing.T) {
+	m := newMRUList(10, time.Minute)
+	now := time.Unix(1600000000, 0)
+	m.record(net.ParseIP("10.0.0.1"), 0x23, now)
+	m.record(net.ParseIP("10.0.0.2"), 0x23, now.Add(time.Second))
+	m.record(net.ParseIP("10.0.0.3"), 0x23, now.Add(30*time.Second))
+	assert.Equal(t, 3, m.size())
+
+	// idle clients are forgotten a few at a time
+	m.record(net.ParseIP("10.0.0.4"), 0x23, now.Add(2*time.Minute))
+	assert.Equal(t, 2, m.size())
+	m.record(net.ParseIP("10.0.0.5"), 0x23, now.Add(2*time.Minute))
+	entries := m.entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "10.0.0.5", entries[0].Addr)
+	assert.Equal(t, "10.0.0.4", entries[1].Addr)
+	assert.Equal(t, uint64(1), entries[0].Count, "slots of forgotten clients are reused from scratch")
+}
+
+func Test_mruListSharded(t *testing.T) {
+	max := 100000
+	m := newMRUList(max, 0)
+	require.Greater(t, len(m.shards), 1)
+	now := time.Unix(1600000000, 0)
+	ip := net.IPv4(10, 0, 0, 0).To4()
+	for i := 0; i < 3*max; i++ {
+		ip[1], ip[2], ip[3] = byte(i>>16), byte(i>>8), byte(i)
+		m.record(ip, 0x23, now.Add(time.Duration(i)))
+	}
+	assert.Equal(t, max, m.size(), "every shard is full")
+	for i := range m.shards {
+		assert.LessOrEqual(t, len(m.shards[i].table), m.shards[i].max)
+	}
+	entries := m.entries()
+	require.Len(t, entries, max)
+	assert.Equal(t, ip.String(), entries[0].Addr)
+	for i := 1; i < len(entries); i++ {
+		require.False(t, entries[i].Last.After(entries[i-1].Last), "the most recently seen first")
+	}
+}
+
+func Test_mruCapacity(t *testing.T) {
+	assert.Equal(t, 1000, mruCapacity(1000, 0))
+	assert.Equal(t, 1000, mruCapacity(1000, 1<<30))
+	assert.Equal(t, 8, mruCapacity(1000, 8*mruEntryBytes))
+}
+
+func Benchmark_mruListRecord(b *testing.B) {
+	m := newMRUList(1<<16, time.Minute)
+	now := time.Now()
+	ip := net.IPv4(10, 0, 0, 0).To4()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ip[1], ip[2], ip[3] = byte(i>>16), byte(i>>8), byte(i)
+		m.record(ip, 0x23, now)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/manage"
+)
+
+// modePrivate is the mode of ntpdc requests (mode 7), monlist among them. They are never answered,
+// as ntpd answers to them were widely abused for reflection attacks
+const modePrivate = 7
+
+// DefaultProbeSources is how many sources of mode 7 probes are tracked, the least recently seen ones are forgotten first
+const DefaultProbeSources = 4096
+
+// mode7Classes classifies mode 7 request codes by what they ask for
+var mode7Classes = map[uint8]string{
+	0:  "peers",   // REQ_PEER_LIST
+	1:  "peers",   // REQ_PEER_LIST_SUM
+	2:  "peers",   // REQ_PEER_INFO
+	3:  "peers",   // REQ_PEER_STATS
+	4:  "sysinfo", // REQ_SYS_INFO
+	5:  "sysinfo", // REQ_SYS_STATS
+	6:  "sysinfo", // REQ_IO_STATS
+	7:  "sysinfo", // REQ_MEM_STATS
+	8:  "sysinfo", // REQ_LOOP_INFO
+	9:  "sysinfo", // REQ_TIMER_STATS
+	10: "config",  // REQ_CONFIG
+	11: "config",  // REQ_UNCONFIG
+	20: "monlist", // REQ_MON_GETLIST
+	42: "monlist", // REQ_MON_GETLIST_1
+	44: "sysinfo", // REQ_IF_STATS
+}
+
+// mode7Class returns what mode 7 request with the code asks for
+func mode7Class(code uint8) string {
+	if class, ok := mode7Classes[code]; ok {
+		return class
+	}
+	return "other"
+}
+
+// probeEntry is a tracked source, linked into the order by indexes in the table
+type probeEntry struct {
+	key        [16]byte
+	probe      manage.Probe
+	prev, next int32
+}
+
+// probeTracker counts probes per source, forgetting the least recently seen one in O(1) once max are tracked,
+// so floods of spoofed probes don't slow workers down. It is safe for concurrent use
+type probeTracker struct {
+	max   int
+	mu    sync.Mutex
+	table []probeEntry
+	index map[[16]byte]int32
+	// head is the most recently seen source, tail the least recently seen one
+	head, tail int32
+}
+
+func newProbeTracker(max int) *probeTracker {
+	return &probeTracker{max: max, index: map[[16]byte]int32{}, head: mruNil, tail: mruNil}
+}
+
+// record counts probe of the class from the address
+func (p *probeTracker) record(ip net.IP, class string, now time.Time) {
+	if p == nil {
+		return
+	}
+	key := mruKey(ip)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, ok := p.index[key]
+	switch {
+	case ok:
+		p.unlink(i)
+	case len(p.table) < p.max:
+		i = int32(len(p.table))
+		p.table = append(p.table, probeEntry{})
+	default:
+		// the least recently seen source gives its slot away
+		i = p.tail
+		p.unlink(i)
+		delete(p.index, p.table[i].key)
+	}
+	if !ok {
+		p.table[i].key = key
+		p.table[i].probe = manage.Probe{Addr: ip.String(), Classes: map[string]uint64{}, First: now}
+		p.index[key] = i
+	}
+	p.pushFront(i)
+	probe := &p.table[i].probe
+	probe.Count++
+	probe.Classes[class]++
+	probe.Last = now
+}
+
+// unlink takes the entry out of the order
+func (p *probeTracker) unlink(i int32) {
+	e := &p.table[i]
+	if e.prev != mruNil {
+		p.table[e.prev].next = e.next
+	} else {
+		p.head = e.next
+	}
+	if e.next != mruNil {
+		p.table[e.next].prev = e.prev
+	} else {
+		p.tail = e.prev
+	}
+}
+
+// pushFront makes the entry the most recently seen one
+func (p *probeTracker) pushFront(i int32) {
+	e := &p.table[i]
+	e.prev, e.next = mruNil, p.head
+	if p.head != mruNil {
+		p.table[p.head].prev = i
+	} else {
+		p.tail = i
+	}
+	p.head = i
+}
+
+// size returns how many sources are tracked
+func (p *probeTracker) size() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.index)
+}
+
+// probes returns copies of tracked sources, the most active first
+func (p *probeTracker) probes() []manage.Probe {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]manage.Probe, 0, len(p.table))
+	for i := range p.table {
+		probe := &p.table[i].probe
+		c := *probe
+		c.Classes = make(map[string]uint64, len(probe.Classes))
+		for class, n := range probe.Classes {
+			c.Classes[class] = n
+		}
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Addr < result[j].Addr
+	})
+	return result
+}
+
+// Probes returns sources of mode 7 probes, the most active first
+func (s *Server) Probes() []manage.Probe {
+	return s.probes.probes()
+}
+
+// recordProbe counts mode 7 probe, which is never answered
+func (t *task) recordProbe() {
+	// request code takes the place of precision in NTP header
+	class := mode7Class(uint8(t.request.Precision))
+	t.logger().Debug("Mode 7 probe, discarding", "class", class, "from", t.addr)
+	t.stats.IncMode7Probes()
+	t.sys.inc(ssRestricted)
+	t.probes.record(addrIP(t.addr), class, time.Now())
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mode7Class(t *testing.T) {
+	assert.Equal(t, "monlist", mode7Class(42))
+	assert.Equal(t, "monlist", mode7Class(20))
+	assert.Equal(t, "peers", mode7Class(0))
+	assert.Equal(t, "sysinfo", mode7Class(4))
+	assert.Equal(t, "config", mode7Class(10))
+	assert.Equal(t, "other", mode7Class(100))
+}
+
+func Test_probeTracker(t *testing.T) {
+	p := newProbeTracker(2)
+	now := time.Unix(1600000000, 0)
+	p.record(net.ParseIP("10.0.0.1"), "monlist", now)
+	p.record(net.ParseIP("10.0.0.2"), "monlist", now.Add(time.Second))
+	p.record(net.ParseIP("10.0.0.2"), "peers", now.Add(2*time.Second))
+
+	probes := p.probes()
+	require.Len(t, probes, 2)
+	assert.Equal(t, "10.0.0.2", probes[0].Addr)
+	assert.Equal(t, uint64(2), probes[0].Count)
+	assert.Equal(t, map[string]uint64{"monlist": 1, "peers": 1}, probes[0].Classes)
+	assert.Equal(t, now.Add(time.Second), probes[0].First)
+	assert.Equal(t, now.Add(2*time.Second), probes[0].Last)
+
+	p.record(net.ParseIP("10.0.0.3"), "other", now.Add(3*time.Second))
+	probes = p.probes()
+	require.Len(t, probes, 2)
+	for _, probe := range probes {
+		assert.NotEqual(t, "10.0.0.1", probe.Addr, "least recently seen source is forgotten")
+	}
+
+	// sources seen again are not forgotten before the others
+	p.record(net.ParseIP("10.0.0.2"), "peers", now.Add(4*time.Second))
+	p.record(net.ParseIP("10.0.0.4"), "other", now.Add(5*time.Second))
+	addrs := map[string]bool{}
+	for _, probe := range p.probes() {
+		addrs[probe.Addr] = true
+	}
+	assert.Equal(t, map[string]bool{"10.0.0.2": true, "10.0.0.4": true}, addrs)
+	assert.Equal(t, 2, p.size())
+
+	var nilTracker *probeTracker
+	nilTracker.record(net.ParseIP("10.0.0.1"), "monlist", now)
+	assert.Nil(t, nilTracker.probes())
+}
+
+func Test_serveMode7(t *testing.T) {
+	// ntpdc monlist request: response bit clear, version 2, mode 7, request code 42
+	request := &ntp.Packet{Settings: 0x17, Precision: 42}
+	probes := newProbeTracker(DefaultProbeSources)
+	batch := &testBatch{}
+	task := &task{
+		batch:   batch,
+		conn:    &net.UDPConn{},
+		addr:    &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 123},
+		request: request,
+		stats:   &stats.JSONStats{},
+		probes:  probes,
+	}
+	task.serve(&responseTemplate{}, 0)
+	assert.Equal(t, 0, len(batch.written), "mode 7 is never answered")
+	got := probes.probes()
+	require.Len(t, got, 1)
+	assert.Equal(t, map[string]uint64{"monlist": 1}, got[0].Classes)
+}
+
+func Benchmark_probeTrackerRecord(b *testing.B) {
+	p := newProbeTracker(DefaultProbeSources)
+	now := time.Unix(1600000000, 0)
+	ips := make([]net.IP, 2*DefaultProbeSources)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, byte(i>>8), byte(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// every source is new by the time it comes around again, so each record evicts
+		p.record(ips[i%len(ips)], "monlist", now)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"hash/maphash"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultRateClients is how many clients rate limiter tracks unless told otherwise
+const DefaultRateClients = 1 << 20
+
+// rateShards is how many shards clients are spread over, each has its own lock, so workers
+// serving different clients rarely wait for each other
+const rateShards = 64
+
+// rateBucket is the token bucket of a single client. It has no pointers, so the garbage collector
+// doesn't scan millions of them
+type rateBucket struct {
+	tokens float64
+	last   int64
+	// kod is set once the client was told to slow down, until it gets a token again
+	kod bool
+}
+
+// rateShard is a part of the clients with its own lock, padded to a cache line of its own
+type rateShard struct {
+	mu      sync.Mutex
+	buckets map[[16]byte]rateBucket
+	_       [48]byte
+}
+
+// clientLimiter limits requests per client with token buckets, clients are forgotten at random
+// once there are too many of them. It is safe for concurrent use, nil one limits nothing
+type clientLimiter struct {
+	rate  float64
+	burst float64
+	// max is how many clients a shard keeps
+	max    int
+	seed   maphash.Seed
+	shards [rateShards]rateShard
+}
+
+// newClientLimiter returns limiter allowing rate requests per second with bursts of up to burst of them,
+// a second worth of requests if burst is not positive, from each of up to clients clients.
+// It returns nil if rate is not positive
+func newClientLimiter(rate float64, burst, clients int) *clientLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if clients <= 0 {
+		clients = DefaultRateClients
+	}
+	l := &clientLimiter{rate: rate, burst: float64(burst), max: (clients + rateShards - 1) / rateShards, seed: maphash.MakeSeed()}
+	if burst <= 0 {
+		l.burst = max(rate, 1)
+	}
+	for i := range l.shards {
+		l.shards[i].buckets = map[[16]byte]rateBucket{}
+	}
+	return l
+}
+
+// allow takes a token from the bucket of the client. kod is true for the first request over the limit
+// since the client had a token, the one worth telling the client to slow down
+func (l *clientLimiter) allow(ip net.IP, now time.Time) (ok, kod bool) {
+	if l == nil {
+		return true, false
+	}
+	key := mruKey(ip)
+	sh := &l.shards[maphash.Bytes(l.seed, key[:])%rateShards]
+	ts := now.UnixNano()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	b, found := sh.buckets[key]
+	if !found {
+		if len(sh.buckets) >= l.max {
+			// map iteration starts at random, so does eviction
+			for k := range sh.buckets {
+				delete(sh.buckets, k)
+				break
+			}
+		}
+		b = rateBucket{tokens: l.burst, last: ts}
+	} else if ts > b.last {
+		b.tokens = min(b.tokens+float64(ts-b.last)/float64(time.Second)*l.rate, l.burst)
+		b.last = ts
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		b.kod = false
+		ok = true
+	} else {
+		kod = !b.kod
+		b.kod = true
+	}
+	sh.buckets[key] = b
+	return ok, kod
+}
+
+// size returns how many clients are tracked
+func (l *clientLimiter) size() int {
+	if l == nil {
+		return 0
+	}
+	n := 0
+	for i := range l.shards {
+		sh := &l.shards[i]
+		sh.mu.Lock()
+		n += len(sh.buckets)
+		sh.mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_clientLimiter(t *testing.T) {
+	l := newClientLimiter(1, 2, 0)
+	client := net.ParseIP("10.0.0.1")
+	now := time.Unix(1600000000, 0)
+
+	for _, want := range []struct{ ok, kod bool }{{true, false}, {true, false}, {false, true}, {false, false}} {
+		ok, kod := l.allow(client, now)
+		assert.Equal(t, want.ok, ok)
+		assert.Equal(t, want.kod, kod)
+	}
+	ok, _ := l.allow(net.ParseIP("10.0.0.2"), now)
+	assert.True(t, ok, "other clients have buckets of their own")
+
+	ok, _ = l.allow(client, now.Add(time.Second))
+	assert.True(t, ok, "bucket is refilled at the rate")
+	ok, kod := l.allow(client, now.Add(time.Second))
+	assert.False(t, ok)
+	assert.True(t, kod, "client is told to slow down again once it got a token")
+	assert.Equal(t, 2, l.size())
+}
+
+func Test_clientLimiterBurst(t *testing.T) {
+	l := newClientLimiter(0.5, 0, 0)
+	assert.Equal(t, 1.0, l.burst, "burst lets at least one request through")
+	l = newClientLimiter(100, 0, 0)
+	assert.Equal(t, 100.0, l.burst)
+}
+
+func Test_clientLimiterClients(t *testing.T) {
+	l := newClientLimiter(1, 1, rateShards)
+	now := time.Unix(1600000000, 0)
+	ip := net.IPv4(10, 0, 0, 0).To4()
+	for i := 0; i < 10*rateShards; i++ {
+		ip[2], ip[3] = byte(i>>8), byte(i)
+		ok, _ := l.allow(ip, now)
+		assert.True(t, ok)
+	}
+	assert.LessOrEqual(t, l.size(), rateShards)
+}
+
+func Test_clientLimiterDisabled(t *testing.T) {
+	l := newClientLimiter(0, 10, 0)
+	assert.Nil(t, l)
+	ok, kod := l.allow(net.ParseIP("10.0.0.1"), time.Now())
+	assert.True(t, ok)
+	assert.False(t, kod)
+	assert.Equal(t, 0, l.size())
+}
+
+func Benchmark_clientLimiterParallel(b *testing.B) {
+	l := newClientLimiter(1000, 0, 0)
+	now := time.Now()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		ip := net.IPv4(10, 0, 0, 0).To4()
+		i := 0
+		for pb.Next() {
+			ip[1], ip[2], ip[3] = byte(i>>16), byte(i>>8), byte(i)
+			l.allow(ip, now)
+			i++
+		}
+	})
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/protocol/nts"
+)
+
+// ReplayResult is the outcome of a replayed request
+type ReplayResult struct {
+	Request *capture.Packet
+	// Packet is the request parsed, nil if it failed to parse with Err
+	Packet *ntp.Packet
+	Err    error
+	// Valid tells if the request has settings of a client request
+	Valid bool
+	// Responses are what the server sent back, none if the request was dropped
+	Responses [][]byte
+}
+
+// replayBatch collects responses instead of sending them
+type replayBatch struct {
+	written [][]byte
+}
+
+func (b *replayBatch) ReadPackets() ([]ntp.ReceivedPacket, error) { return nil, nil }
+
+func (b *replayBatch) QueueWrite(p []byte, addr net.Addr, local *ntp.PktInfo) error {
+	b.written = append(b.written, append([]byte{}, p...))
+	return nil
+}
+
+func (b *replayBatch) WriteErrors() int { return 0 }
+
+// Replay serves requests read from the capture, to reproduce field issues without network: each one is parsed
+// and, unless parseOnly is set, served the way it would have been, with receive timestamp taken from the capture.
+// Packets not sent to ListenConfig.Port, responses among them, are skipped. Speed is that of capture.Replay.
+// Stats has to be set. NTS requests are only verified if NTS.KeysFile has master keys they were made with
+func (s *Server) Replay(r *capture.Reader, speed float64, parseOnly bool, fn func(*ReplayResult)) error {
+	if err := s.setup(); err != nil {
+		return err
+	}
+	if s.NTS.KeysFile != "" {
+		keys, err := nts.ReadMasterKeysFile(s.NTS.KeysFile)
+		if err != nil {
+			return fmt.Errorf("failed to read NTS master keys: %w", err)
+		}
+		if s.ntsKeys, err = nts.NewCookieKeys(0); err != nil {
+			return err
+		}
+		if err := s.ntsKeys.Set(keys, s.NTS.Overlap); err != nil {
+			return err
+		}
+	}
+	response := s.newResponseTemplate()
+	return capture.Replay(r, speed, func(p *capture.Packet) error {
+		if p.Dst.Port != s.ListenConfig.Port {
+			return nil
+		}
+		result := &ReplayResult{Request: p}
+		defer fn(result)
+		if len(p.Payload) < ntp.PacketSizeBytes {
+			result.Err = fmt.Errorf("request of %d bytes is too short", len(p.Payload))
+			return nil
+		}
+		if result.Packet, result.Err = ntp.BytesToPacket(p.Payload[:ntp.PacketSizeBytes]); result.Err != nil {
+			return nil
+		}
+		result.Valid = result.Packet.ValidSettingsFormat()
+		if parseOnly {
+			return nil
+		}
+		batch := &replayBatch{}
+		t := s.newTask(nil, ntp.ReceivedPacket{
+			Packet:     result.Packet,
+			RxTime:     p.Time,
+			RemAddr:    p.Src,
+			Local:      &ntp.PktInfo{Addr: p.Dst.IP},
+			Extensions: p.Payload[ntp.PacketSizeBytes:],
+		})
+		t.batch = batch
+		t.serve(response, s.ExtraOffset)
+		result.Responses = batch.written
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	var b bytes.Buffer
+	w, err := capture.NewWriter(&b)
+	require.Nil(t, err)
+	client := &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 123}
+	request, err := (&ntp.Packet{Settings: 0x23, TxTimeSec: 1, TxTimeFrac: 2}).Bytes()
+	require.Nil(t, err)
+	received := time.Unix(1600000000, 0)
+	for _, p := range []*capture.Packet{
+		{Time: received, Src: client, Dst: server, Payload: request},
+		// response captured along with the request is skipped
+		{Time: received, Src: server, Dst: client, Payload: request},
+		{Time: received, Src: client, Dst: server, Payload: []byte("short")},
+	} {
+		require.Nil(t, w.WritePacket(p))
+	}
+
+	replay := func(parseOnly bool) []*ReplayResult {
+		s := &Server{Stratum: 1, RefID: "GPS", Stats: &stats.JSONStats{}, ListenConfig: ListenConfig{Port: 123}}
+		r, err := capture.NewReader(bytes.NewReader(b.Bytes()))
+		require.Nil(t, err)
+		var results []*ReplayResult
+		require.Nil(t, s.Replay(r, 0, parseOnly, func(r *ReplayResult) {
+			results = append(results, r)
+		}))
+		return results
+	}
+
+	results := replay(false)
+	require.Len(t, results, 2)
+	assert.Nil(t, results[0].Err)
+	assert.True(t, results[0].Valid)
+	require.Len(t, results[0].Responses, 1)
+	response, err := ntp.BytesToPacket(results[0].Responses[0])
+	require.Nil(t, err)
+	assert.Equal(t, uint32(1), response.OrigTimeSec)
+	assert.Equal(t, received, ntp.Unix(response.RxTimeSec, response.RxTimeFrac).Round(time.Second), "receive timestamp comes from the capture")
+	assert.NotNil(t, results[1].Err)
+
+	results = replay(true)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Valid)
+	assert.Empty(t, results[0].Responses)
+}
@@ -18,60 +18,215 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/capture"
+	"github.com/facebookincubator/ntp/discipline"
+	"github.com/facebookincubator/ntp/logging"
+	"github.com/facebookincubator/ntp/protocol/auth"
+	"github.com/facebookincubator/ntp/protocol/control"
 	"github.com/facebookincubator/ntp/protocol/ntp"
-	log "github.com/sirupsen/logrus"
+	"github.com/facebookincubator/ntp/protocol/nts"
+	"github.com/facebookincubator/ntp/responder/xdp"
+	"github.com/facebookincubator/ntp/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// DefaultMetricsInterval is how often metrics are pushed to the sink unless configured otherwise
+const DefaultMetricsInterval = 10 * time.Second
+
+// batchDatapath receives requests and queues responses in batches.
+// It can't be shared with workers, so requests are served right in the listener goroutine
+type batchDatapath interface {
+	ReadPackets() ([]ntp.ReceivedPacket, error)
+	QueueWrite(b []byte, addr net.Addr, local *ntp.PktInfo) error
+	WriteErrors() int
+}
+
 type task struct {
-	conn     net.PacketConn
-	addr     net.Addr
-	received time.Time
-	request  *ntp.Packet
-	stats    Stats
+	conn        *net.UDPConn
+	port        int
+	addr        net.Addr
+	local       *ntp.PktInfo
+	batch       batchDatapath
+	received    time.Time
+	request     *ntp.Packet
+	extensions  []byte
+	nts         *nts.CookieKeys
+	keys        KeyVerifier
+	cryptoNAK   bool
+	w32time     bool
+	requireAuth MultiPrefixes
+	limiter     *cryptoLimiter
+	rates       *clientLimiter
+	rateKoD     bool
+	audit       audit.Sink
+	stats       Stats
+	// control answers mode 6 queries from controlAllow
+	control       ControlSource
+	controlAllow  MultiPrefixes
+	controlNonces *control.Nonces
+	probes        *probeTracker
+	mru           *mruList
+	capture       capture.Sink
+	sys           *sysStats
+	iface         *ifStats
+	metrics       *metrics
+	log           logging.Logger
 }
 
 // Server is a type for UDP server which handles connections
 type Server struct {
 	ListenConfig ListenConfig
 	Workers      int
-	Announce     Announce
-	Stats        Stats
-	Checker      Checker
-	tasks        chan task
-	ExtraOffset  time.Duration
-	RefID        string
-	Stratum      int
+	BatchSize    int
+	IOURing      bool
+	XDPIface     string
+	XDPQueues    int
+	NTS          NTSConfig
+	Keys         KeyVerifier
+	CryptoNAK    bool
+	// WorkerSockets gives every worker its own socket for each listen IP, served right in the worker
+	// instead of listeners handing requests to the shared pool
+	WorkerSockets bool
+	// AutoTune sizes receive batches up to BatchSize or DefaultMaxBatchSize, whichever is larger, after the load,
+	// and keeps between MinWorkers and Workers workers active depending on how deep their queue gets.
+	// With WorkerSockets only batches are sized: every worker serves sockets of its own, which can't be parked
+	AutoTune bool
+	// MinWorkers is how many workers AutoTune keeps active at least, the number of CPUs if not set.
+	// It is ignored with WorkerSockets
+	MinWorkers int
+	// WorkerCPUs pins worker i to CPU WorkerCPUs[i%len(WorkerCPUs)], workers aren't pinned if empty. Linux only
+	WorkerCPUs CPUList
+	// BatchResponses serves every receive batch right in the listener and sends its responses with a single sendmmsg call
+	BatchResponses bool
+	// W32TimeQuirks answers w32time clients despite their quirks: symmetric active requests and poll out of range
+	W32TimeQuirks bool
+	// RequireAuth lists prefixes requests from which are only served if authenticated
+	RequireAuth MultiPrefixes
+	// CryptoRate limits MAC and NTS verifications per second, 0 means no limit
+	CryptoRate int
+	// CryptoConcurrency limits how many verifications run at once, 0 means no limit
+	CryptoConcurrency int
+	// ClientRate limits requests per second from a single client, 0 means no limit
+	ClientRate float64
+	// ClientBurst is how many requests a client may send at once, a second worth of ClientRate if not set
+	ClientBurst int
+	// RateClients is how many clients ClientRate is tracked for, DefaultRateClients if not set
+	RateClients int
+	// RateKoD answers the first request over ClientRate with RATE kiss-o'-death, the rest are dropped silently
+	RateKoD bool
+	// Audit receives security events, may be nil
+	Audit audit.Sink
+	// Capture receives requests and responses, may be nil
+	Capture capture.Sink
+	// Prometheus is where collector of the server is registered, metrics are not collected if nil
+	Prometheus prometheus.Registerer
+	// MetricsSink receives metrics of the server every MetricsInterval, e.g. *telemetry.StatsD, they are not pushed if nil
+	MetricsSink telemetry.Sink
+	// MetricsInterval is how often metrics are pushed to MetricsSink, DefaultMetricsInterval if not set
+	MetricsInterval time.Duration
+	// Logger receives events of the server, slog.Default() if nil
+	Logger logging.Logger
+	// Expvar is the name internal counters are published under with expvar, they are not published if empty
+	Expvar string
+	// ControlAllow lists prefixes control queries of ntpq are answered for, nobody's are if empty
+	ControlAllow MultiPrefixes
+	// Control provides variables and associations control queries report, the server itself if nil
+	Control ControlSource
+	// ManageSocket is Unix socket management API is served on, it is not served if empty
+	ManageSocket string
+	// MRUSize is how many most recently seen clients are kept for management API, none are if not set
+	MRUSize int
+	// MRUMemory caps memory MRU list takes in bytes, it keeps fewer clients than MRUSize if needed
+	MRUMemory int64
+	// MRUMaxAge is how long clients not seen are kept in MRU list, until they are pushed out by others if not set
+	MRUMaxAge time.Duration
+	// Loop steers the clock responses are timestamped with, may be nil. Root dispersion of responses grows
+	// by its dispersion, so clients see the server in holdover getting worse. It is fixed if not set
+	Loop *discipline.Loop
+	// ReloadInterval is how often key files are checked for changes
+	ReloadInterval time.Duration
+	Announce       Announce
+	Stats          Stats
+	Checker        Checker
+	tasks          chan task
+	ExtraOffset    time.Duration
+	RefID          string
+	Stratum        int
+	ntsKeys        *nts.CookieKeys
+	limiter        *cryptoLimiter
+	rates          *clientLimiter
+	tuner          *workerTuner
+	controlNonces  *control.Nonces
+	probes         *probeTracker
+	mru            *mruList
+	sys            *sysStats
+	interfaces     map[string]*ifStats
+	metrics        *metrics
 }
 
 // Start UDP server
 func (s *Server) Start(ctx context.Context, cancelFunc context.CancelFunc) {
-	log.Warningf("Creating %d goroutine workers", s.Workers)
+	s.logger().Warn("Creating goroutine workers", "workers", s.Workers)
 	s.tasks = make(chan task, s.Workers)
-	// Pre-create workers
-	for i := 0; i < s.Workers; i++ {
-		go s.startWorker()
+	if err := s.setup(); err != nil {
+		s.fatal("Failed to set up the server", "error", err)
+	}
+	if s.ManageSocket != "" {
+		go s.serveManage()
+	}
+	if s.MetricsSink != nil {
+		go telemetry.Run(ctx, s.logger(), s.MetricsSink, s.metricsInterval(), s.metrics)
+	}
+	// Pre-create workers, unless they serve sockets of their own
+	if !s.WorkerSockets {
+		if s.AutoTune {
+			s.tuner = newWorkerTuner(s.MinWorkers, s.Workers)
+			go s.tuner.run(ctx, s.logger(), DefaultTuneInterval)
+		}
+		for i := 0; i < s.Workers; i++ {
+			go s.startWorker(i)
+		}
+	} else if s.AutoTune && s.MinWorkers != 0 {
+		s.logger().Warn("Workers serving sockets of their own are all kept active, ignoring minimum", "minworkers", s.MinWorkers)
 	}
 
-	log.Warningf("Starting %d listener(s)", len(s.ListenConfig.IPs))
+	if s.NTS.Enabled() {
+		s.startNTS()
+	}
 
-	for _, ip := range s.ListenConfig.IPs {
-		log.Infof("Starting listener on %s:%d", ip.String(), s.ListenConfig.Port)
+	if f, ok := s.Keys.(*auth.KeyFile); ok && s.ReloadInterval > 0 {
+		go watchFile(s.logger(), f.Path(), s.ReloadInterval, f.Reload)
+	}
 
-		go func(ip net.IP) {
-			s.Stats.IncListeners()
-			// Need to be sure IP is on interface:
+	if s.XDPIface != "" {
+		s.logger().Warn("Starting AF_XDP datapath", "interface", s.XDPIface)
+		go s.startXDP()
+	}
+
+	if s.WorkerSockets {
+		// Need to be sure IPs are on interface before workers listen on them
+		for _, ip := range s.ListenConfig.IPs {
 			if err := s.addIPToInterface(ip); err != nil {
-				log.Errorf("[server]: %v", err)
+				s.logger().Error("Failed to add IP to the interface", "ip", ip, "error", err)
 			}
-
-			s.startListener(ip, s.ListenConfig.Port)
-			s.Stats.DecListeners()
-		}(ip)
+		}
+		s.logger().Warn("Starting workers with sockets of their own", "workers", s.Workers, "ips", len(s.ListenConfig.IPs))
+		for i := 0; i < s.Workers; i++ {
+			go s.startSocketWorker(i)
+		}
+	} else {
+		s.startListeners()
 	}
 
 	// Run active metric reporting
@@ -80,7 +235,7 @@ func (s *Server) Start(ctx context.Context, cancelFunc context.CancelFunc) {
 			<-time.After(1 * time.Minute)
 			err := s.Stats.Report()
 			if err != nil {
-				log.Errorf("[stats] %v", err)
+				s.logger().Error("Failed to report stats", "error", err)
 			}
 		}
 	}()
@@ -89,10 +244,10 @@ func (s *Server) Start(ctx context.Context, cancelFunc context.CancelFunc) {
 	go func() {
 		for {
 			time.Sleep(time.Minute)
-			log.Debug("[Checker] running internal health checks")
+			s.logger().Debug("Running internal health checks")
 			err := s.Checker.Check()
 			if err != nil {
-				log.Errorf("[Checker] internal error: %v", err)
+				s.logger().Error("Internal health check failed", "error", err)
 				cancelFunc()
 				return
 			}
@@ -106,10 +261,10 @@ func (s *Server) Start(ctx context.Context, cancelFunc context.CancelFunc) {
 		case <-time.After(30 * time.Second):
 			if s.ListenConfig.ShouldAnnounce {
 				// First run will be 30 seconds delayed
-				log.Debug("Requesting VIPs announce")
+				s.logger().Debug("Requesting VIPs announce")
 				err := s.Announce.Advertise(s.ListenConfig.IPs)
 				if err != nil {
-					log.Errorf("Error during announcement: %v", err)
+					s.logger().Error("Failed to announce VIPs", "error", err)
 					s.Stats.ResetAnnounce()
 				} else {
 					s.Stats.SetAnnounce()
@@ -121,11 +276,78 @@ func (s *Server) Start(ctx context.Context, cancelFunc context.CancelFunc) {
 	}
 }
 
+// metricsInterval returns how often metrics are pushed to the sink
+func (s *Server) metricsInterval() time.Duration {
+	if s.MetricsInterval > 0 {
+		return s.MetricsInterval
+	}
+	return DefaultMetricsInterval
+}
+
+// logger returns the logger of the server
+func (s *Server) logger() logging.Logger {
+	return logging.Or(s.Logger)
+}
+
+// fatal logs the error and exits, there is no serving without what failed
+func (s *Server) fatal(msg string, args ...interface{}) {
+	s.logger().Error(msg, args...)
+	os.Exit(1)
+}
+
+// setup creates state requests are served with
+func (s *Server) setup() error {
+	s.limiter = newCryptoLimiter(s.CryptoRate, s.CryptoConcurrency)
+	s.rates = newClientLimiter(s.ClientRate, s.ClientBurst, s.RateClients)
+	s.probes = newProbeTracker(DefaultProbeSources)
+	s.mru = newMRUList(mruCapacity(s.MRUSize, s.MRUMemory), s.MRUMaxAge)
+	s.sys = newSysStats(time.Now())
+	s.interfaces = newInterfaceStats(&s.ListenConfig, time.Now())
+	var err error
+	if s.controlNonces, err = control.NewNonces(); err != nil {
+		return fmt.Errorf("failed to create control nonce secret: %w", err)
+	}
+	if s.Prometheus != nil || s.MetricsSink != nil {
+		s.metrics = newMetrics(s)
+	}
+	if s.Prometheus != nil {
+		if err := s.Prometheus.Register(s.metrics); err != nil {
+			return fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	if s.Expvar != "" {
+		if err := s.publishExpvar(s.Expvar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Stop will stop announcement, delete IPs from interfaces
 func (s *Server) Stop() {
 	s.DeleteAllIPs()
 	if err := s.Announce.Withdraw(); err != nil {
-		log.Errorf("[server] failed to withdraw announce: %v", err)
+		s.logger().Error("Failed to withdraw announce", "error", err)
+	}
+}
+
+// startListeners starts a listener for every IP, handing requests to workers
+func (s *Server) startListeners() {
+	s.logger().Warn("Starting listeners", "listeners", len(s.ListenConfig.IPs))
+
+	for _, ip := range s.ListenConfig.IPs {
+		s.logger().Info("Starting listener", "ip", ip, "port", s.ListenConfig.Port)
+
+		go func(ip net.IP) {
+			s.Stats.IncListeners()
+			// Need to be sure IP is on interface:
+			if err := s.addIPToInterface(ip); err != nil {
+				s.logger().Error("Failed to add IP to the interface", "ip", ip, "error", err)
+			}
+
+			s.startListener(ip, s.ListenConfig.Port)
+			s.Stats.DecListeners()
+		}(ip)
 	}
 }
 
@@ -133,68 +355,509 @@ func (s *Server) startListener(ip net.IP, port int) {
 	s.Checker.IncListeners()
 	defer s.Checker.DecListeners()
 
+	conn := s.listen(ip, port, false)
+	defer conn.Close()
+	s.serveBatchDatapaths(conn)
+
+	batcher := s.newReadBatcher()
+	for {
+		// read HW/kernel timestamps and destination addresses of incoming packets
+		packets, err := ntp.ReadPacketsWithKernelTimestamp(conn, s.readBatchSize(batcher))
+		if errors.Is(err, ntp.ErrControlTruncated) {
+			// we can't serve without receive timestamp, but it's not a reason to stop the listener
+			s.logger().Error("Dropping requests", "error", err)
+		} else if err != nil {
+			s.fatal("Failed to read requests", "ip", ip, "error", err)
+			continue
+		}
+		batcher.observe(len(packets))
+		for _, p := range packets {
+			s.Stats.IncRequests()
+			s.enqueue(s.newTask(conn, p))
+		}
+	}
+}
+
+// listen opens a socket for requests on ip:port with HW/kernel timestamps and destination addresses enabled.
+// reusePort lets sockets of several workers share the address
+func (s *Server) listen(ip net.IP, port int, reusePort bool) *net.UDPConn {
 	// listen to incoming udp ntp.
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+	conn, err := listenUDP(ip, port, reusePort)
 	if err != nil {
-		log.Fatal(err)
+		s.fatal("Failed to listen", "ip", ip, "port", port, "error", err)
 	}
-	defer conn.Close()
 
 	// Allow reading of hardware/kernel timestamps via socket
 	if err := ntp.EnableKernelTimestampsSocket(conn); err != nil {
-		log.Fatalln(err)
+		s.fatal("Failed to enable kernel timestamps", "ip", ip, "error", err)
+	}
+
+	// Allow reading of destination address, so we can reply from it
+	if err := ntp.EnablePktInfoSocket(conn); err != nil {
+		s.fatal("Failed to enable destination addresses", "ip", ip, "error", err)
+	}
+	return conn
+}
+
+// serveBatchDatapaths serves conn with io_uring or sendmmsg datapaths if they are enabled, until they fail
+func (s *Server) serveBatchDatapaths(conn *net.UDPConn) {
+	if s.IOURing {
+		// io_uring datapath serves until it fails, regular one takes over after that
+		if err := s.serveURing(conn); err != nil {
+			s.logger().Warn("io_uring datapath is not available, falling back", "error", err)
+		}
+	}
+
+	if s.BatchResponses {
+		// sendmmsg datapath serves until it fails, regular one takes over after that
+		if err := s.serveSendBatches(conn); err != nil {
+			s.logger().Warn("sendmmsg datapath is not available, falling back", "error", err)
+		}
+	}
+}
+
+// serveURing receives requests and sends responses via io_uring
+func (s *Server) serveURing(conn *net.UDPConn) error {
+	ring, err := ntp.NewURing(conn, 0)
+	if err != nil {
+		return err
+	}
+	defer ring.Close()
+	return s.serveBatches(conn, ring)
+}
+
+// serveSendBatches receives requests with recvmmsg and sends responses to each batch with sendmmsg
+func (s *Server) serveSendBatches(conn *net.UDPConn) error {
+	batch, err := ntp.NewBatch(conn, s.BatchSize)
+	if err != nil {
+		return err
+	}
+	return s.serveBatches(conn, batch)
+}
+
+// startXDP serves requests arriving on XDPIface right from AF_XDP sockets, one per receive queue.
+// Requests which aren't redirected, or all of them if XDP is unavailable, are served by regular listeners
+func (s *Server) startXDP() {
+	prog, err := xdp.Attach(s.XDPIface, s.ListenConfig.Port, s.XDPQueues)
+	if err != nil {
+		s.logger().Error("AF_XDP datapath is not available", "error", err)
+		return
+	}
+	defer prog.Close()
+
+	var wg sync.WaitGroup
+	for queue := 0; queue < s.XDPQueues; queue++ {
+		sock, err := xdp.NewSocket(prog, queue)
+		if err != nil {
+			s.logger().Error("No AF_XDP socket for queue", "queue", queue, "error", err)
+			continue
+		}
+		wg.Add(1)
+		go func(queue int) {
+			defer wg.Done()
+			defer sock.Close()
+			if err := s.serveBatches(nil, sock); err != nil {
+				s.logger().Error("AF_XDP datapath failed", "queue", queue, "error", err)
+			}
+		}(queue)
+	}
+	wg.Wait()
+}
+
+// startNTS creates cookie master keys, rotates them periodically and starts NTS-KE listeners
+func (s *Server) startNTS() {
+	cert, err := tls.LoadX509KeyPair(s.NTS.CertFile, s.NTS.KeyFile)
+	if err != nil {
+		s.fatal("Failed to load NTS-KE certificate", "error", err)
+	}
+	s.ntsKeys, err = nts.NewCookieKeys(0)
+	if err != nil {
+		s.fatal("Failed to create NTS cookie keys", "error", err)
+	}
+	if s.NTS.KeysFile != "" {
+		reload := func() error {
+			keys, err := nts.ReadMasterKeysFile(s.NTS.KeysFile)
+			if err != nil {
+				return err
+			}
+			return s.ntsKeys.Set(keys, s.NTS.Overlap)
+		}
+		if err := reload(); err != nil {
+			s.fatal("Failed to read NTS master keys", "error", err)
+		}
+		if s.ReloadInterval > 0 {
+			go watchFile(s.logger(), s.NTS.KeysFile, s.ReloadInterval, reload)
+		}
+	} else if s.NTS.Rotation > 0 {
+		go func() {
+			for {
+				time.Sleep(s.NTS.Rotation)
+				s.logger().Info("Rotating NTS cookie master key")
+				if err := s.ntsKeys.Rotate(); err != nil {
+					s.logger().Error("Failed to rotate NTS cookie master key", "error", err)
+				}
+			}
+		}()
 	}
 
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if s.NTS.ClientCAFile != "" {
+		if config.ClientCAs, err = nts.LoadCertPool(s.NTS.ClientCAFile); err != nil {
+			s.fatal("Failed to load NTS-KE client CAs", "error", err)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	ke := &nts.KEServer{Keys: s.ntsKeys, TLSConfig: config, AEADs: s.NTS.AEADs, NTPServer: s.NTS.NTPServer}
+	// clients assume the default port unless told otherwise
+	if port := s.NTS.ntpPort(s.ListenConfig.Port); port != nts.DefaultNTPPort {
+		ke.NTPPort = uint16(port)
+	}
+	for _, ip := range s.ListenConfig.IPs {
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(s.NTS.Port))
+		s.logger().Info("Starting NTS-KE listener", "addr", addr)
+		go func(addr string) {
+			s.logger().Error("NTS-KE listener failed", "addr", addr, "error", ke.ListenAndServe(addr))
+		}(addr)
+	}
+}
+
+// watchFile calls reload every time file modification time or size changes
+func watchFile(logger logging.Logger, path string, interval time.Duration, reload func() error) {
+	var lastMod time.Time
+	var lastSize int64
+	if st, err := os.Stat(path); err == nil {
+		lastMod, lastSize = st.ModTime(), st.Size()
+	}
 	for {
-		// read HW/kernel timestamp from incoming packet
-		request, nowHWtimestamp, returnaddr, err := ntp.ReadPacketWithKernelTimestamp(conn)
+		time.Sleep(interval)
+		st, err := os.Stat(path)
 		if err != nil {
-			log.Fatalln(err)
+			logger.Error("Failed to check key file", "path", path, "error", err)
+			continue
+		}
+		if st.ModTime().Equal(lastMod) && st.Size() == lastSize {
 			continue
 		}
-		s.Stats.IncRequests()
-		s.tasks <- task{conn: conn, addr: returnaddr, received: nowHWtimestamp, request: request, stats: s.Stats}
+		lastMod, lastSize = st.ModTime(), st.Size()
+		if err := reload(); err != nil {
+			logger.Error("Failed to reload key file, keeping current keys", "path", path, "error", err)
+			continue
+		}
+		logger.Warn("Reloaded keys", "path", path)
+	}
+}
+
+// serveBatches serves requests of a batch datapath until it fails
+func (s *Server) serveBatches(conn *net.UDPConn, batch batchDatapath) error {
+	response := s.newResponseTemplate()
+	for {
+		// responses queued in the previous iteration are submitted here as well
+		packets, err := batch.ReadPackets()
+		if errors.Is(err, ntp.ErrControlTruncated) {
+			s.logger().Error("Dropping requests", "error", err)
+		} else if err != nil {
+			return err
+		}
+		for _, p := range packets {
+			s.Stats.IncRequests()
+			t := s.newTask(conn, p)
+			t.batch = batch
+			t.serve(response, s.ExtraOffset)
+		}
+		if n := batch.WriteErrors(); n > 0 {
+			s.logger().Info("Failed to respond to requests", "requests", n)
+		}
 	}
 }
 
-func (s *Server) startWorker() {
+func (s *Server) startWorker(id int) {
+	s.pinWorker(id)
 	s.Checker.IncWorkers()
 	defer s.Checker.DecWorkers()
-        defer s.Stats.DecWorkers()
+	defer s.Stats.DecWorkers()
 
-	// Pre-allocating response buffer
-	response := &ntp.Packet{}
-	s.fillStaticHeaders(response)
+	// Pre-allocating response template
+	response := s.newResponseTemplate()
 	s.Stats.IncWorkers()
 	for {
+		s.tuner.wait(id)
 		task := <-s.tasks
 		task.serve(response, s.ExtraOffset)
 	}
 }
 
+// newTask wraps received packet into a task
+func (s *Server) newTask(conn *net.UDPConn, p ntp.ReceivedPacket) task {
+	return task{
+		conn:          conn,
+		port:          s.ListenConfig.Port,
+		addr:          p.RemAddr,
+		local:         p.Local,
+		received:      p.RxTime,
+		request:       p.Packet,
+		extensions:    p.Extensions,
+		nts:           s.ntsKeys,
+		keys:          s.Keys,
+		cryptoNAK:     s.CryptoNAK,
+		w32time:       s.W32TimeQuirks,
+		requireAuth:   s.RequireAuth,
+		limiter:       s.limiter,
+		rates:         s.rates,
+		rateKoD:       s.RateKoD,
+		audit:         s.Audit,
+		stats:         s.Stats,
+		control:       s.controlSource(),
+		controlAllow:  s.ControlAllow,
+		controlNonces: s.controlNonces,
+		probes:        s.probes,
+		mru:           s.mru,
+		capture:       s.Capture,
+		sys:           s.sys,
+		iface:         s.interfaceOf(conn, p.Local),
+		metrics:       s.metrics,
+		log:           s.logger(),
+	}
+}
+
+// interfaceOf returns counters of the address the packet arrived to, nil if it's not one of listeners
+func (s *Server) interfaceOf(conn *net.UDPConn, local *ntp.PktInfo) *ifStats {
+	if local != nil && local.Addr != nil {
+		return s.interfaces[local.Addr.String()]
+	}
+	if conn != nil {
+		if a, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			return s.interfaces[a.IP.String()]
+		}
+	}
+	return nil
+}
+
+// controlSource returns what control queries report
+func (s *Server) controlSource() ControlSource {
+	if s.Control != nil {
+		return s.Control
+	}
+	return s
+}
+
+// logger returns the logger of the task
+func (t *task) logger() logging.Logger {
+	return logging.Or(t.log)
+}
+
 // serve checks the request format.
 // gets time from local and respond.
-func (t *task) serve(response *ntp.Packet, extraoffset time.Duration) {
-	log.Debugf("Received request: %+v", t.request)
-	if t.request.ValidSettingsFormat() {
-		generateResponse(time.Now().Add(extraoffset), t.received.Add(extraoffset), t.request, response)
-		responseBytes, err := response.Bytes()
-		if err != nil {
-			log.Errorf("Failed to convert ntp.%v to bytes %v: %v", response, responseBytes, err)
+func (t *task) serve(response *responseTemplate, extraoffset time.Duration) {
+	t.logger().Debug("Received request", "from", t.addr, "request", t.request)
+	t.captureRequest()
+	t.sys.receive(t.request.Settings >> 3 & 0x7)
+	t.metrics.request(t.request.Settings)
+	t.mru.record(addrIP(t.addr), t.request.Settings, t.received)
+	t.iface.receive()
+	if t.request.Settings&0x7 == modeControl {
+		t.serveControl()
+		return
+	}
+	if t.request.Settings&0x7 == modePrivate {
+		t.recordProbe()
+		return
+	}
+	if ok, kod := t.rates.allow(addrIP(t.addr), t.received); !ok {
+		t.limitRate(response, kod)
+		return
+	}
+	if t.request.ValidSettingsFormat() || (t.w32time && symmetricActive(t.request)) {
+		responseBytes := response.fill(time.Now().Add(extraoffset), t.received.Add(extraoffset), t.request)
+		if t.w32time {
+			t.answerW32Time(responseBytes)
+		}
+		protected := false
+		var err error
+		if len(t.extensions) > 0 {
+			// over budget requests are rejected before any crypto is done
+			if !t.limiter.acquire(time.Now()) {
+				t.logger().Debug("Crypto budget exhausted, discarding request", "from", t.addr)
+				t.stats.IncCryptoRejects()
+				t.sys.inc(ssLimited)
+				t.emit(audit.CryptoBudget, "")
+				return
+			}
+			responseBytes, protected, err = t.protect(responseBytes)
+			t.limiter.release()
+			if err != nil {
+				t.logger().Info("Unauthenticated request, discarding", "from", t.addr, "error", err)
+				t.stats.IncInvalidFormat()
+				t.sys.inc(ssBadAuth)
+				t.emit(audit.AuthFailure, err.Error())
+				return
+			}
+		}
+		if !protected && t.requireAuth.Contains(addrIP(t.addr)) {
+			t.logger().Debug("Unauthenticated request, discarding", "from", t.addr)
+			t.stats.IncUnauthenticatedDrops()
+			t.sys.inc(ssDeclined)
+			t.emit(audit.UnauthenticatedDrop, "")
 			return
 		}
 
-		log.Debugf("Writing from: %v", t.conn.LocalAddr())
-		log.Debugf("Writing response: %+v", response)
-		_, err = t.conn.WriteTo(responseBytes, t.addr)
-		if err != nil {
-			log.Infof("Failed to respond to the request: %v", err)
+		if err := t.send(responseBytes); err != nil {
+			t.logger().Info("Failed to respond to the request", "to", t.addr, "error", err)
 		}
 		t.stats.IncResponses()
+		t.sys.inc(ssProcessed)
 		return
 	}
-	log.Infof("Invalid query, discarding: %v", t.request)
+	t.logger().Info("Invalid query, discarding", "from", t.addr, "request", t.request)
 	t.stats.IncInvalidFormat()
+	t.sys.inc(ssBadFormat)
+}
+
+// limitRate drops request over the rate limit of the client. The first one of them
+// is answered with RATE kiss-o'-death if the client is to be told to slow down
+func (t *task) limitRate(response *responseTemplate, kod bool) {
+	t.logger().Debug("Client rate exceeded, discarding request", "from", t.addr)
+	t.sys.inc(ssLimited)
+	t.emit(audit.RateLimited, "")
+	if !kod || !t.rateKoD || !t.request.ValidSettingsFormat() {
+		return
+	}
+	// template is shared by requests, kiss-o'-death is a copy of it
+	b := append([]byte(nil), response.fill(time.Now(), t.received, t.request)...)
+	kissOfDeath(b, "RATE")
+	if err := t.send(b); err != nil {
+		t.logger().Info("Failed to respond to the request", "to", t.addr, "error", err)
+	}
+	t.sys.inc(ssKoDSent)
+	t.mru.kod(addrIP(t.addr))
+}
+
+// kissOfDeath turns response into kiss-o'-death with the code: unsynchronized, stratum 0 and the code as reference ID
+func kissOfDeath(b []byte, code string) {
+	b[0] = 0xc0 | b[0]&0x3f
+	b[1] = 0
+	copy(b[12:16], code)
+}
+
+// send writes the response from the same address request arrived to. Many clients drop responses from other addresses
+func (t *task) send(b []byte) error {
+	t.logger().Debug("Writing response", "to", t.addr, "from", t.localAddr())
+	t.captureResponse(b)
+	var err error
+	if t.batch != nil {
+		err = t.batch.QueueWrite(b, t.addr, t.local)
+	} else {
+		_, err = ntp.WritePacketWithPktInfo(t.conn, b, t.addr, t.local)
+	}
+	t.iface.send(err)
+	t.metrics.observe(t.request.Settings&0x7, t.received)
+	return err
+}
+
+// protect authenticates the request and the response with NTS or symmetric key MAC,
+// whichever the request uses. Requests without either are served as usual.
+// Standalone Unique Identifier is echoed back with or without MAC.
+// protected is false if the response goes out plain, NAKs telling the client to authenticate again count as protected
+func (t *task) protect(response []byte) (b []byte, protected bool, err error) {
+	request, err := t.request.Bytes()
+	if err != nil {
+		return nil, false, err
+	}
+	// NTS requests end with the authenticator, requests ending with MAC are left to the keys
+	_, mac, err := auth.Split(t.extensions)
+	if err != nil {
+		return nil, false, err
+	}
+	if t.nts != nil && (mac == nil || t.keys == nil) {
+		b, err := t.protectNTS(request, response)
+		if !errors.Is(err, nts.ErrNotNTS) {
+			return b, err == nil, err
+		}
+	}
+	if t.keys != nil {
+		key, err := t.keys.Verify(request, t.extensions)
+		if errors.Is(err, auth.ErrNoMAC) {
+			b, err := t.echoUID(response)
+			return b, false, err
+		}
+		if err != nil && t.cryptoNAK {
+			t.logger().Debug("Sending crypto-NAK", "to", t.addr, "error", err)
+			t.stats.IncCryptoNAKs()
+			t.sys.inc(ssKoDSent)
+			t.mru.kod(addrIP(t.addr))
+			t.emit(audit.CryptoNAK, err.Error())
+			return auth.AppendCryptoNAK(response), true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if response, err = t.echoUID(response); err != nil {
+			return nil, false, err
+		}
+		t.stats.IncAuthenticated()
+		// response is signed with the key request was
+		return key.Sign(response), true, nil
+	}
+	b, err = t.echoUID(response)
+	return b, false, err
+}
+
+// emit sends security event about the request to the audit sink
+func (t *task) emit(kind audit.Kind, reason string) {
+	if t.audit == nil {
+		return
+	}
+	e := audit.Event{Kind: kind, Peer: t.addr.String(), Reason: reason}
+	if _, mac := auth.SplitMAC(t.extensions); mac != nil {
+		e.KeyID, _ = auth.KeyID(mac)
+	}
+	audit.Emit(t.audit, e)
+}
+
+// addrIP returns IP of UDP or XDP client address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *xdp.Addr:
+		return a.IP
+	}
+	return nil
+}
+
+// echoUID appends Unique Identifier of the request to the response, if the request has one
+func (t *task) echoUID(response []byte) ([]byte, error) {
+	fields, _, err := auth.Split(t.extensions)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := ntp.UniqueIdentifier(fields)
+	if err != nil || uid == nil {
+		return response, err
+	}
+	uidField := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: uid}
+	return append(response, uidField.Bytes()...), nil
+}
+
+// protectNTS authenticates NTS request and response. Client gets NTS NAK if its cookie is not accepted
+func (t *task) protectNTS(request, response []byte) ([]byte, error) {
+	req, err := t.nts.ParseRequest(append(request, t.extensions...))
+	if errors.Is(err, nts.ErrNotNTS) {
+		return nil, err
+	}
+	t.stats.IncNTSRequests()
+	if errors.Is(err, nts.ErrInvalidCookie) || errors.Is(err, nts.ErrUnauthenticated) {
+		t.logger().Debug("Sending NTS NAK", "to", t.addr, "error", err)
+		t.stats.IncNTSNAKs()
+		t.sys.inc(ssKoDSent)
+		t.mru.kod(addrIP(t.addr))
+		t.emit(audit.NTSNAK, err.Error())
+		return req.NAK(response), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.stats.IncAuthenticated()
+	return req.Response(t.nts, response)
 }
 
 // fillStaticHeaders pre-sets all the headers per worker which will never change
@@ -209,40 +872,3 @@ func (s *Server) fillStaticHeaders(response *ntp.Packet) {
 	// Reference ID ATOM. Only for stratum 1
 	response.ReferenceID = binary.BigEndian.Uint32([]byte(fmt.Sprintf("%-4s", s.RefID)))
 }
-
-// generateResponse generates response NTP packet
-// See more in protocol/ntp/packet.go
-func generateResponse(now time.Time, received time.Time, request, response *ntp.Packet) {
-	var vn = request.Settings & 0x38
-	response.Settings = vn + 4
-
-	// Poll
-	response.Poll = request.Poll
-
-	// Reference Timestamp
-	// RFC: "Local time at which the local clock was last set or corrected."
-	// Because we don't have this info (no access to chronyd/ntpd) we need to
-	// come up with something. Just returning "now" will not fly and chronyd/ntpd
-	// will exclude "inconsistent host". So once per 1000s sounds "consistent" enough
-	lastSync := time.Unix(now.Unix()/1000*1000, 0)
-	lastSyncSec, lastSyncFrac := ntp.Time(lastSync)
-	response.RefTimeSec = lastSyncSec
-	response.RefTimeFrac = lastSyncFrac
-
-	// Originate Timestamp
-	// RFC: "Local time at which the request departed the client host for the service host."
-	response.OrigTimeSec = request.TxTimeSec
-	response.OrigTimeFrac = request.TxTimeFrac
-
-	// Receive Timestamp
-	// RFC: "Local time at which the request arrived at the service host."
-	receivedSec, receivedFrac := ntp.Time(received)
-	response.RxTimeSec = receivedSec
-	response.RxTimeFrac = receivedFrac
-
-	// Transmit Timestamp
-	// RFC: "Local time at which the reply departed the service host for the client host."
-	nowSec, nowFrac := ntp.Time(now)
-	response.TxTimeSec = nowSec
-	response.TxTimeFrac = nowFrac
-}
@@ -18,10 +18,18 @@ package server
 
 import (
 	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/facebookincubator/ntp/audit"
+	"github.com/facebookincubator/ntp/logging"
+	"github.com/facebookincubator/ntp/protocol/auth"
 	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/protocol/nts"
+	"github.com/facebookincubator/ntp/responder/stats"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,19 +67,19 @@ func Test_fillStaticHeadersRootDispersion(t *testing.T) {
 	assert.Equal(t, uint32(10), response.RootDispersion, "Root dispersion should be 0.000152")
 }
 
-func Test_generateResponsePoll(t *testing.T) {
+func Test_responseTemplatePoll(t *testing.T) {
 	request := &ntp.Packet{Poll: 8}
-	response := &ntp.Packet{}
-	generateResponse(timestamp, timestamp, request, response)
+	response, err := ntp.BytesToPacket((&responseTemplate{}).fill(timestamp, timestamp, request))
+	assert.Nil(t, err)
 	assert.Equal(t, request.Poll, response.Poll)
 }
 
-func Test_generateResponseTimestamps(t *testing.T) {
+func Test_responseTemplateTimestamps(t *testing.T) {
 	request := &ntp.Packet{TxTimeSec: 3794210679, TxTimeFrac: 2718216404}
-	response := &ntp.Packet{}
 	nowSec, nowFrac := ntp.Time(timestamp)
 
-	generateResponse(timestamp, timestamp, request, response)
+	response, err := ntp.BytesToPacket((&responseTemplate{}).fill(timestamp, timestamp, request))
+	assert.Nil(t, err)
 
 	// Reference Timestamp must to the closest /1000s
 	lastSync := time.Unix(timestamp.Unix()/1000*1000, 0)
@@ -92,11 +100,292 @@ func Test_generateResponseTimestamps(t *testing.T) {
 	assert.Equal(t, nowFrac, response.TxTimeFrac)
 }
 
-func Benchmark_generateResponse(b *testing.B) {
+func Test_protectNotNTS(t *testing.T) {
+	keys, err := nts.NewCookieKeys(0)
+	assert.Nil(t, err)
+	field := ntp.ExtensionField{Type: 0x2005, Value: make([]byte, 16)}
+	task := &task{request: &ntp.Packet{}, extensions: field.Bytes(), nts: keys, stats: &stats.JSONStats{}}
+	response := make([]byte, ntp.PacketSizeBytes)
+
+	protected, ok, err := task.protect(response)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, response, protected, "request without NTS fields gets plain response")
+}
+
+func Test_protectNAK(t *testing.T) {
+	keys, err := nts.NewCookieKeys(0)
+	assert.Nil(t, err)
+	uid := ntp.ExtensionField{Type: ntp.ExtensionUniqueIdentifier, Value: make([]byte, 32)}
+	cookie := ntp.ExtensionField{Type: ntp.ExtensionNTSCookie, Value: make([]byte, 100)}
+	task := &task{request: &ntp.Packet{}, extensions: append(uid.Bytes(), cookie.Bytes()...), nts: keys, stats: &stats.JSONStats{}}
+	response := make([]byte, ntp.PacketSizeBytes)
+	response[1] = 1
+
+	protected, ok, err := task.protect(response)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(0), protected[1], "NTS NAK is stratum 0")
+	assert.Equal(t, []byte("NTSN"), protected[12:16])
+	assert.Equal(t, uid.Bytes(), protected[ntp.PacketSizeBytes:])
+}
+
+func Test_protectMalformed(t *testing.T) {
+	keys, err := nts.NewCookieKeys(0)
+	assert.Nil(t, err)
+	task := &task{request: &ntp.Packet{}, extensions: []byte{1, 4, 0, 42}, nts: keys, stats: &stats.JSONStats{}}
+	_, _, err = task.protect(make([]byte, ntp.PacketSizeBytes))
+	assert.NotNil(t, err)
+
+	// neither extension fields nor MAC of a valid size
+	task.extensions = make([]byte, 13)
+	task.keys = auth.NewKeys()
+	_, _, err = task.protect(make([]byte, ntp.PacketSizeBytes))
+	assert.ErrorIs(t, err, auth.ErrMalformed)
+}
+
+func Test_protectMAC(t *testing.T) {
+	key := &auth.Key{ID: 1, Algorithm: auth.MD5, Secret: []byte("secret")}
+	keys := auth.NewKeys(key)
+	assert.Nil(t, keys.Trust(1))
+	request := &ntp.Packet{Settings: 0x23}
+	requestBytes, _ := request.Bytes()
+	signed := key.Sign(requestBytes)
+	task := &task{request: request, extensions: signed[ntp.PacketSizeBytes:], keys: keys, stats: &stats.JSONStats{}}
+	response := make([]byte, ntp.PacketSizeBytes)
+
+	protected, ok, err := task.protect(response)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ntp.PacketSizeBytes+key.Size(), len(protected))
+	assert.Nil(t, key.Verify(protected[:ntp.PacketSizeBytes], protected[ntp.PacketSizeBytes:]))
+
+	// request is tampered with
+	task.request = &ntp.Packet{Settings: 0x24}
+	_, _, err = task.protect(response)
+	assert.ErrorIs(t, err, auth.ErrBadMAC)
+}
+
+func Test_protectCryptoNAK(t *testing.T) {
+	key := &auth.Key{ID: 1, Algorithm: auth.MD5, Secret: []byte("secret")}
+	keys := auth.NewKeys(key)
+	assert.Nil(t, keys.Trust(1))
+	request := &ntp.Packet{Settings: 0x23}
+	requestBytes, _ := request.Bytes()
+	// signed with the key server doesn't know
+	unknown := &auth.Key{ID: 2, Algorithm: auth.MD5, Secret: []byte("secret")}
+	signed := unknown.Sign(requestBytes)
+	st := &stats.JSONStats{}
+	task := &task{request: request, extensions: signed[ntp.PacketSizeBytes:], keys: keys, cryptoNAK: true, stats: st}
+	response := make([]byte, ntp.PacketSizeBytes)
+
+	protected, ok, err := task.protect(response)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, auth.AppendCryptoNAK(make([]byte, ntp.PacketSizeBytes)), protected)
+
+	// without crypto-NAK the request is dropped
+	task.cryptoNAK = false
+	_, _, err = task.protect(response)
+	assert.ErrorIs(t, err, auth.ErrUnknownKey)
+}
+
+func Test_protectUID(t *testing.T) {
+	keys, err := nts.NewCookieKeys(0)
+	assert.Nil(t, err)
+	uid, err := ntp.NewUniqueIdentifier()
+	assert.Nil(t, err)
+	task := &task{request: &ntp.Packet{}, extensions: uid.Bytes(), nts: keys, stats: &stats.JSONStats{}}
+	response := make([]byte, ntp.PacketSizeBytes)
+
+	protected, ok, err := task.protect(response)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, append(response, uid.Bytes()...), protected)
+
+	// signed response covers the echoed identifier
+	key := &auth.Key{ID: 1, Algorithm: auth.SHA1, Secret: []byte("secret")}
+	task.keys = auth.NewKeys(key)
+	assert.Nil(t, task.keys.(*auth.Keys).Trust(1))
+	requestBytes, _ := task.request.Bytes()
+	signed := key.Sign(append(requestBytes, uid.Bytes()...))
+	task.extensions = signed[ntp.PacketSizeBytes:]
+	protected, ok, err = task.protect(response)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	fields, mac := auth.SplitMAC(protected[ntp.PacketSizeBytes:])
+	assert.Equal(t, uid.Bytes(), fields)
+	assert.Nil(t, key.Verify(protected[:ntp.PacketSizeBytes+len(fields)], mac))
+}
+
+// testBatch records responses instead of sending them
+type testBatch struct {
+	written [][]byte
+}
+
+func (b *testBatch) ReadPackets() ([]ntp.ReceivedPacket, error) { return nil, nil }
+
+func (b *testBatch) QueueWrite(p []byte, addr net.Addr, local *ntp.PktInfo) error {
+	b.written = append(b.written, append([]byte{}, p...))
+	return nil
+}
+
+func (b *testBatch) WriteErrors() int { return 0 }
+
+func Test_serveRequireAuth(t *testing.T) {
+	key := &auth.Key{ID: 1, Algorithm: auth.MD5, Secret: []byte("secret")}
+	keys := auth.NewKeys(key)
+	assert.Nil(t, keys.Trust(1))
+	requireAuth := MultiPrefixes{}
+	assert.Nil(t, requireAuth.Set("10.0.0.0/8"))
+	request := &ntp.Packet{Settings: 0x23}
+	requestBytes, _ := request.Bytes()
+	signed := key.Sign(requestBytes)
+
+	batch := &testBatch{}
+	serve := func(ip string, extensions []byte) {
+		task := &task{
+			batch:       batch,
+			conn:        &net.UDPConn{},
+			addr:        &net.UDPAddr{IP: net.ParseIP(ip), Port: 123},
+			request:     request,
+			extensions:  extensions,
+			keys:        keys,
+			requireAuth: requireAuth,
+			stats:       &stats.JSONStats{},
+		}
+		task.serve(&responseTemplate{}, 0)
+	}
+	serve("192.168.0.1", nil)
+	assert.Equal(t, 1, len(batch.written), "other prefixes are served unauthenticated")
+	serve("10.0.0.1", nil)
+	assert.Equal(t, 1, len(batch.written))
+	serve("10.0.0.1", signed[ntp.PacketSizeBytes:])
+	assert.Equal(t, 2, len(batch.written))
+	assert.Equal(t, ntp.PacketSizeBytes+key.Size(), len(batch.written[1]))
+}
+
+func Test_serveCryptoLimit(t *testing.T) {
+	batch := &testBatch{}
+	limiter := newCryptoLimiter(1, 0)
+	uid, err := ntp.NewUniqueIdentifier()
+	assert.Nil(t, err)
+	serve := func(extensions []byte) {
+		task := &task{
+			batch:      batch,
+			conn:       &net.UDPConn{},
+			addr:       &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 123},
+			request:    &ntp.Packet{Settings: 0x23},
+			extensions: extensions,
+			limiter:    limiter,
+			stats:      &stats.JSONStats{},
+		}
+		task.serve(&responseTemplate{}, 0)
+	}
+	serve(uid.Bytes())
+	serve(uid.Bytes())
+	assert.Equal(t, 1, len(batch.written), "second request is over budget")
+	serve(nil)
+	assert.Equal(t, 2, len(batch.written), "plain requests need no budget")
+}
+
+func Test_serveClientRate(t *testing.T) {
+	batch := &testBatch{}
+	rates := newClientLimiter(1, 1, 0)
+	sys := newSysStats(time.Now())
+	serve := func() {
+		task := &task{
+			batch:   batch,
+			conn:    &net.UDPConn{},
+			addr:    &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 123},
+			request: &ntp.Packet{Settings: 0x23},
+			rates:   rates,
+			rateKoD: true,
+			sys:     sys,
+			stats:   &stats.JSONStats{},
+		}
+		task.serve(&responseTemplate{}, 0)
+	}
+	serve()
+	serve()
+	serve()
+	assert.Equal(t, 2, len(batch.written), "only the first request over the limit is answered")
+	kod, err := ntp.BytesToPacket(batch.written[1])
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0), kod.Stratum)
+	assert.Equal(t, uint8(3), kod.Settings>>6)
+	assert.Equal(t, []byte("RATE"), batch.written[1][12:16])
+	assert.Equal(t, uint64(2), sys.get(ssLimited))
+	assert.Equal(t, uint64(1), sys.get(ssKoDSent))
+}
+
+// testSink collects audit events
+type testSink struct {
+	events []audit.Event
+}
+
+func (s *testSink) Emit(e audit.Event) {
+	s.events = append(s.events, e)
+}
+
+func Test_serveAudit(t *testing.T) {
+	key := &auth.Key{ID: 1, Algorithm: auth.MD5, Secret: []byte("secret")}
+	keys := auth.NewKeys(key)
+	assert.Nil(t, keys.Trust(1))
+	request := &ntp.Packet{Settings: 0x23}
+	requestBytes, _ := request.Bytes()
+	wrong := &auth.Key{ID: 1, Algorithm: auth.MD5, Secret: []byte("wrong")}
+	signed := wrong.Sign(requestBytes)
+	events := &testSink{}
+	task := &task{
+		batch:      &testBatch{},
+		conn:       &net.UDPConn{},
+		addr:       &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 123},
+		request:    request,
+		extensions: signed[ntp.PacketSizeBytes:],
+		keys:       keys,
+		audit:      events,
+		stats:      &stats.JSONStats{},
+	}
+	task.serve(&responseTemplate{}, 0)
+	assert.Equal(t, 1, len(events.events))
+	assert.Equal(t, audit.AuthFailure, events.events[0].Kind)
+	assert.Equal(t, "192.168.0.1:123", events.events[0].Peer)
+	assert.Equal(t, uint32(1), events.events[0].KeyID)
+
+	task.cryptoNAK = true
+	task.serve(&responseTemplate{}, 0)
+	assert.Equal(t, 2, len(events.events))
+	assert.Equal(t, audit.CryptoNAK, events.events[1].Kind)
+}
+
+func Test_watchFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "keys")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	reloads := make(chan struct{}, 10)
+	go watchFile(logging.Discard, f.Name(), 10*time.Millisecond, func() error {
+		reloads <- struct{}{}
+		return nil
+	})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, len(reloads), "unchanged file is not reloaded")
+
+	assert.Nil(t, ioutil.WriteFile(f.Name(), []byte("1 MD5 secret\n"), 0600))
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("changed file is not reloaded")
+	}
+}
+
+func Benchmark_responseTemplate(b *testing.B) {
+	response := (&Server{}).newResponseTemplate()
+	request := &ntp.Packet{}
 	for i := 0; i < b.N; i++ {
-		request := &ntp.Packet{}
-		response := &ntp.Packet{}
-		generateResponse(timestamp, timestamp, request, response)
+		response.fill(timestamp, timestamp, request)
 	}
 }
 
@@ -0,0 +1,227 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/ntp/internal/counter"
+)
+
+// sysCounter is a counter of requests ntpq sysstats shows
+type sysCounter int
+
+// Counters of sysStats, in the order ntpd reports them
+const (
+	ssReceived sysCounter = iota
+	ssThisVersion
+	ssOldVersion
+	ssBadFormat
+	ssBadAuth
+	ssDeclined
+	ssRestricted
+	ssLimited
+	ssKoDSent
+	ssProcessed
+	numSysCounters
+)
+
+// sysCounterNames are names of system variables counters are reported as
+var sysCounterNames = [numSysCounters]string{
+	ssReceived:    "ss_received",
+	ssThisVersion: "ss_thisver",
+	ssOldVersion:  "ss_oldver",
+	ssBadFormat:   "ss_badformat",
+	ssBadAuth:     "ss_badauth",
+	ssDeclined:    "ss_declined",
+	ssRestricted:  "ss_restricted",
+	ssLimited:     "ss_limited",
+	ssKoDSent:     "ss_kodsent",
+	ssProcessed:   "ss_processed",
+}
+
+// ntpVersion is the current NTP version, requests of older ones are counted separately
+const ntpVersion = 4
+
+// sysStats counts requests the way ntpd does for ntpq sysstats. Counters are sharded, every request
+// counts a few of them. It is safe for concurrent use, nil one counts nothing
+type sysStats struct {
+	counters *counter.Set
+	started  time.Time
+}
+
+func newSysStats(now time.Time) *sysStats {
+	return &sysStats{counters: counter.NewSet(int(numSysCounters)), started: now}
+}
+
+func (s *sysStats) inc(c sysCounter) {
+	if s == nil {
+		return
+	}
+	s.counters.Inc(int(c))
+}
+
+// get returns value of the counter
+func (s *sysStats) get(c sysCounter) uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.counters.Load(int(c))
+}
+
+// receive counts received request of the NTP version
+func (s *sysStats) receive(version uint8) {
+	s.inc(ssReceived)
+	if version == ntpVersion {
+		s.inc(ssThisVersion)
+	} else {
+		s.inc(ssOldVersion)
+	}
+}
+
+// variables returns counters as ntpd system variables. Counters are never reset, so reset time is start time
+func (s *sysStats) variables(now time.Time) map[string]string {
+	vars := map[string]string{}
+	if s == nil {
+		return vars
+	}
+	uptime := strconv.FormatInt(int64(now.Sub(s.started).Seconds()), 10)
+	vars["ss_uptime"] = uptime
+	vars["ss_reset"] = uptime
+	for c, name := range sysCounterNames {
+		vars[name] = strconv.FormatUint(s.counters.Load(c), 10)
+	}
+	return vars
+}
+
+// Counters of ifStats
+const (
+	ifReceived = iota
+	ifSent
+	ifSendErrors
+	numIfCounters
+)
+
+// ifStats counts packets of an address the server listens on, with sharded counters.
+// It is safe for concurrent use, nil one counts nothing
+type ifStats struct {
+	counters *counter.Set
+	name     string
+	ip       net.IP
+	started  time.Time
+}
+
+func (i *ifStats) receive() {
+	if i == nil {
+		return
+	}
+	i.counters.Inc(ifReceived)
+}
+
+func (i *ifStats) send(err error) {
+	if i == nil {
+		return
+	}
+	if err != nil {
+		i.counters.Inc(ifSendErrors)
+		return
+	}
+	i.counters.Inc(ifSent)
+}
+
+// newInterfaceStats returns counters of the addresses the server listens on, by IP
+func newInterfaceStats(c *ListenConfig, now time.Time) map[string]*ifStats {
+	interfaces := map[string]*ifStats{}
+	for _, ip := range c.IPs {
+		interfaces[ip.String()] = &ifStats{counters: counter.NewSet(numIfCounters), name: c.Iface, ip: ip, started: now}
+	}
+	return interfaces
+}
+
+// ControlInterface is an IP address the server listens on, as ntpq ifstats shows it
+type ControlInterface struct {
+	Name       string
+	Addr       string
+	Enabled    bool
+	Received   uint64
+	Sent       uint64
+	SendErrors uint64
+	Uptime     time.Duration
+}
+
+// variables returns the interface as ntpd reports it in the ordered list of interfaces, at the index
+func (i *ControlInterface) variables(index int, vars map[string]string) []string {
+	suffix := "." + strconv.Itoa(index)
+	enabled := "0"
+	if i.Enabled {
+		enabled = "1"
+	}
+	values := []struct{ name, value string }{
+		{"addr", i.Addr},
+		{"en", enabled},
+		// INT_UP flag
+		{"flags", "0x1"},
+		{"mc", "0"},
+		{"name", i.Name},
+		{"pc", "0"},
+		{"rx", strconv.FormatUint(i.Received, 10)},
+		{"tl", "0"},
+		{"tx", strconv.FormatUint(i.Sent, 10)},
+		{"txerr", strconv.FormatUint(i.SendErrors, 10)},
+		{"up", strconv.FormatInt(int64(i.Uptime.Seconds()), 10)},
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		vars[v.name+suffix] = v.value
+		names = append(names, v.name+suffix)
+	}
+	return names
+}
+
+// ControlStats is ControlSource which reports system statistics and interface counters for ntpq sysstats and ifstats.
+// System statistics are only reported when asked for by name, like ntpd does
+type ControlStats interface {
+	SystemStats() map[string]string
+	Interfaces() []ControlInterface
+}
+
+// SystemStats returns counters of requests
+func (s *Server) SystemStats() map[string]string {
+	return s.sys.variables(time.Now())
+}
+
+// Interfaces returns counters of the addresses the server listens on
+func (s *Server) Interfaces() []ControlInterface {
+	now := time.Now()
+	result := make([]ControlInterface, 0, len(s.interfaces))
+	for _, i := range s.interfaces {
+		result = append(result, ControlInterface{
+			Name:       i.name,
+			Addr:       i.ip.String(),
+			Enabled:    true,
+			Received:   i.counters.Load(ifReceived),
+			Sent:       i.counters.Load(ifSent),
+			SendErrors: i.counters.Load(ifSendErrors),
+			Uptime:     now.Sub(i.started),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Addr < result[j].Addr })
+	return result
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/control"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sysStatsVariables(t *testing.T) {
+	started := time.Unix(1600000000, 0)
+	s := newSysStats(started)
+	s.receive(4)
+	s.receive(3)
+	s.inc(ssKoDSent)
+	vars := s.variables(started.Add(time.Minute))
+	assert.Equal(t, "60", vars["ss_uptime"])
+	assert.Equal(t, "2", vars["ss_received"])
+	assert.Equal(t, "1", vars["ss_thisver"])
+	assert.Equal(t, "1", vars["ss_oldver"])
+	assert.Equal(t, "1", vars["ss_kodsent"])
+	assert.Equal(t, "0", vars["ss_processed"])
+
+	var nilStats *sysStats
+	nilStats.inc(ssReceived)
+	assert.Empty(t, nilStats.variables(started))
+}
+
+func Test_serveSysStats(t *testing.T) {
+	s := &Server{ListenConfig: ListenConfig{Iface: "lo", Port: 123, IPs: MultiIPs{net.ParseIP("10.0.0.1")}}}
+	require.Nil(t, s.setup())
+	batch := &testBatch{}
+	serve := func(settings uint8) {
+		task := s.newTask(&net.UDPConn{}, ntp.ReceivedPacket{
+			Packet:  &ntp.Packet{Settings: settings},
+			RemAddr: &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 123},
+			Local:   &ntp.PktInfo{Addr: net.ParseIP("10.0.0.1")},
+		})
+		task.batch = batch
+		task.stats = &stats.JSONStats{}
+		task.serve(&responseTemplate{}, 0)
+	}
+	serve(0x23)
+	serve(0x1b)
+	serve(0x24)
+	serve(0x17)
+
+	vars := s.SystemStats()
+	assert.Equal(t, "4", vars["ss_received"])
+	assert.Equal(t, "2", vars["ss_thisver"])
+	assert.Equal(t, "2", vars["ss_oldver"], "v3 request and v2 mode 7 probe")
+	assert.Equal(t, "2", vars["ss_processed"])
+	assert.Equal(t, "1", vars["ss_badformat"])
+	assert.Equal(t, "1", vars["ss_restricted"], "mode 7 probe")
+
+	interfaces := s.Interfaces()
+	require.Len(t, interfaces, 1)
+	assert.Equal(t, "lo", interfaces[0].Name)
+	assert.Equal(t, "10.0.0.1", interfaces[0].Addr)
+	assert.Equal(t, uint64(4), interfaces[0].Received)
+	assert.Equal(t, uint64(2), interfaces[0].Sent)
+}
+
+type testStatsSource struct {
+	testControlSource
+}
+
+func (testStatsSource) SystemStats() map[string]string {
+	return map[string]string{"ss_received": "10", "ss_processed": "8"}
+}
+
+func (testStatsSource) Interfaces() []ControlInterface {
+	return []ControlInterface{
+		{Name: "eth0", Addr: "10.0.0.1", Enabled: true, Received: 10, Sent: 8, Uptime: time.Minute},
+		{Name: "eth0", Addr: "10.0.0.2", Enabled: true},
+	}
+}
+
+func Test_controlResponsesSysStats(t *testing.T) {
+	responses := controlResponses(testStatsSource{}, controlRequest(control.OpReadVariables, 0, ""))
+	vars, err := control.ParseVariables(parseResponse(t, responses[0]).Data)
+	require.Nil(t, err)
+	assert.NotContains(t, vars, "ss_received", "statistics are only reported by name")
+
+	responses = controlResponses(testStatsSource{}, controlRequest(control.OpReadVariables, 0, "ss_received, ss_processed, stratum"))
+	vars, err = control.ParseVariables(parseResponse(t, responses[0]).Data)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"ss_received": "10", "ss_processed": "8", "stratum": "2"}, vars)
+
+	responses = controlResponses(testControlSource{}, controlRequest(control.OpReadVariables, 0, "ss_received"))
+	assert.True(t, parseResponse(t, responses[0]).HasError(), "sources without statistics don't have them")
+}
+
+func Test_controlResponsesIfStats(t *testing.T) {
+	responses := controlResponses(testStatsSource{}, controlRequest(control.OpReadOrderedList, 0, "ifstats"))
+	require.Len(t, responses, 1)
+	vars, err := control.ParseVariables(parseResponse(t, responses[0]).Data)
+	require.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", vars["addr.0"])
+	assert.Equal(t, "eth0", vars["name.0"])
+	assert.Equal(t, "10", vars["rx.0"])
+	assert.Equal(t, "8", vars["tx.0"])
+	assert.Equal(t, "60", vars["up.0"])
+	assert.Equal(t, "10.0.0.2", vars["addr.1"])
+
+	responses = controlResponses(testStatsSource{}, controlRequest(control.OpReadOrderedList, 0, "addr_restrictions"))
+	assert.True(t, parseResponse(t, responses[0]).HasError())
+	responses = controlResponses(testControlSource{}, controlRequest(control.OpReadOrderedList, 0, "ifstats"))
+	assert.True(t, parseResponse(t, responses[0]).HasError())
+}
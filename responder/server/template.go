@@ -0,0 +1,116 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/facebookincubator/ntp/discipline"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+// referenceInterval is how often reference timestamp of responses moves
+const referenceInterval = 1000
+
+// Offsets of response fields patched per request
+const (
+	offsetSettings = 0
+	offsetPoll     = 2
+	offsetRootDisp = 8
+	offsetOrigTime = 24
+	offsetRxTime   = 32
+	offsetTxTime   = 40
+)
+
+// referenceTime returns reference timestamp of responses sent at now.
+// RFC: "Local time at which the local clock was last set or corrected."
+// Because we don't have this info (no access to chronyd/ntpd) we need to
+// come up with something. Just returning "now" will not fly and chronyd/ntpd
+// will exclude "inconsistent host". So once per 1000s sounds "consistent" enough
+func referenceTime(now time.Time) time.Time {
+	return time.Unix(now.Unix()/referenceInterval*referenceInterval, 0)
+}
+
+// responseTemplate is encoded response with fields which only change with server state filled in: stratum, precision,
+// root delay and dispersion, reference ID, and reference timestamp, which makes a new generation of the template
+// every referenceInterval. Responses only patch fields of the request into it: version, mode, poll, origin,
+// receive and transmit timestamps. With the loop, root dispersion grows by its dispersion, patched once a second.
+// Every worker has its own one, it is not safe for concurrent use
+type responseTemplate struct {
+	static ntp.Packet
+	// generation is reference timestamp b was encoded with, Unix seconds
+	generation int64
+	b          []byte
+	loop       *discipline.Loop
+	// dispersed is when root dispersion was last patched, Unix seconds
+	dispersed int64
+}
+
+// newResponseTemplate returns template of responses of the server
+func (s *Server) newResponseTemplate() *responseTemplate {
+	r := &responseTemplate{loop: s.Loop}
+	s.fillStaticHeaders(&r.static)
+	return r
+}
+
+// shortFormat encodes the duration in NTP short format, saturating at its maximum
+func shortFormat(d time.Duration) uint32 {
+	if d >= 1<<16*time.Second {
+		return math.MaxUint32
+	}
+	return uint32(uint64(d) << 16 / uint64(time.Second))
+}
+
+// fill makes response to the request received at the time and sent now. The response is the template buffer,
+// valid until the next fill
+func (r *responseTemplate) fill(now, received time.Time, request *ntp.Packet) []byte {
+	if ref := referenceTime(now); r.b == nil || ref.Unix() != r.generation {
+		r.static.RefTimeSec, r.static.RefTimeFrac = ntp.Time(ref)
+		// fixed size packet always encodes. Capacity is capped, so extensions appended to responses don't alias the template
+		b, _ := r.static.Bytes()
+		r.b = b[:len(b):len(b)]
+		r.generation = ref.Unix()
+		r.dispersed = 0
+	}
+	b := r.b
+	if r.loop != nil && now.Unix() != r.dispersed {
+		disp := uint64(r.static.RootDispersion) + uint64(shortFormat(r.loop.Dispersion(now)))
+		binary.BigEndian.PutUint32(b[offsetRootDisp:], uint32(min(disp, math.MaxUint32)))
+		r.dispersed = now.Unix()
+	}
+	b[offsetSettings] = request.Settings&0x38 + 4
+	b[offsetPoll] = byte(request.Poll)
+	// Originate Timestamp
+	// RFC: "Local time at which the request departed the client host for the service host."
+	putTime(b[offsetOrigTime:], request.TxTimeSec, request.TxTimeFrac)
+	// Receive Timestamp
+	// RFC: "Local time at which the request arrived at the service host."
+	sec, frac := ntp.Time(received)
+	putTime(b[offsetRxTime:], sec, frac)
+	// Transmit Timestamp
+	// RFC: "Local time at which the reply departed the service host for the client host."
+	sec, frac = ntp.Time(now)
+	putTime(b[offsetTxTime:], sec, frac)
+	return b
+}
+
+func putTime(b []byte, sec, frac uint32) {
+	binary.BigEndian.PutUint32(b, sec)
+	binary.BigEndian.PutUint32(b[4:], frac)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/discipline"
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_responseTemplate(t *testing.T) {
+	s := &Server{Stratum: 2, RefID: "GPS"}
+	r := s.newResponseTemplate()
+	request := &ntp.Packet{Settings: 0x23, Poll: 6, TxTimeSec: 3794210679, TxTimeFrac: 2718216404}
+	received := timestamp.Add(-time.Millisecond)
+
+	response, err := ntp.BytesToPacket(r.fill(timestamp, received, request))
+	require.Nil(t, err)
+	refSec, refFrac := ntp.Time(time.Unix(1585231000, 0))
+	rxSec, rxFrac := ntp.Time(received)
+	txSec, txFrac := ntp.Time(timestamp)
+	assert.Equal(t, &ntp.Packet{
+		Settings:       0x24,
+		Stratum:        2,
+		Poll:           6,
+		Precision:      -32,
+		RootDispersion: 10,
+		ReferenceID:    binary.BigEndian.Uint32([]byte("GPS ")),
+		RefTimeSec:     refSec,
+		RefTimeFrac:    refFrac,
+		OrigTimeSec:    request.TxTimeSec,
+		OrigTimeFrac:   request.TxTimeFrac,
+		RxTimeSec:      rxSec,
+		RxTimeFrac:     rxFrac,
+		TxTimeSec:      txSec,
+		TxTimeFrac:     txFrac,
+	}, response)
+
+	// the next generation moves reference timestamp, patched fields come from the next request
+	later := timestamp.Add(referenceInterval * time.Second)
+	response, err = ntp.BytesToPacket(r.fill(later, later, &ntp.Packet{Settings: 0x1b, Poll: 10}))
+	require.Nil(t, err)
+	refSec, _ = ntp.Time(time.Unix(1585232000, 0))
+	assert.Equal(t, refSec, response.RefTimeSec)
+	assert.Equal(t, uint8(0x1c), response.Settings)
+	assert.Equal(t, int8(10), response.Poll)
+	assert.Equal(t, uint32(0), response.OrigTimeSec)
+	assert.Equal(t, uint8(2), response.Stratum)
+
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() { r.fill(later, later, request) }))
+}
+
+func Test_responseTemplateLoop(t *testing.T) {
+	l := discipline.NewLoop(discipline.NewDryRun())
+	s := &Server{Loop: l}
+	r := s.newResponseTemplate()
+	request := &ntp.Packet{Settings: 0x23}
+
+	// the loop which is not set yet has maximum dispersion
+	response, err := ntp.BytesToPacket(r.fill(timestamp, timestamp, request))
+	require.Nil(t, err)
+	assert.Equal(t, 10+shortFormat(discipline.MaxDispersion), response.RootDispersion)
+
+	require.NoError(t, l.SetFrequencyPPB(0))
+	_, err = l.Update(0, timestamp)
+	require.NoError(t, err)
+	// dispersion is patched once a second
+	response, err = ntp.BytesToPacket(r.fill(timestamp, timestamp, request))
+	require.Nil(t, err)
+	assert.Equal(t, 10+shortFormat(discipline.MaxDispersion), response.RootDispersion)
+
+	// in holdover dispersion keeps growing
+	var prev uint32
+	for _, after := range []time.Duration{time.Second, time.Hour, 24 * time.Hour} {
+		now := timestamp.Add(after)
+		response, err = ntp.BytesToPacket(r.fill(now, now, request))
+		require.Nil(t, err)
+		assert.Equal(t, 10+shortFormat(l.Dispersion(now)), response.RootDispersion)
+		assert.Greater(t, response.RootDispersion, prev)
+		prev = response.RootDispersion
+	}
+	later := timestamp.Add(24 * time.Hour)
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() { r.fill(later, later, request) }))
+}
+
+func Test_shortFormat(t *testing.T) {
+	assert.Equal(t, uint32(0), shortFormat(0))
+	assert.Equal(t, uint32(1<<16), shortFormat(time.Second))
+	assert.Equal(t, uint32(1<<15), shortFormat(500*time.Millisecond))
+	assert.Equal(t, uint32(math.MaxUint32), shortFormat(1<<16*time.Second))
+}
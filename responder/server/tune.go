@@ -0,0 +1,190 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookincubator/ntp/logging"
+)
+
+const (
+	// DefaultTuneInterval is how often active workers are adjusted to the load
+	DefaultTuneInterval = time.Second
+	// DefaultMaxBatchSize is how large receive batches grow under load, unless BatchSize allows more
+	DefaultMaxBatchSize = 64
+)
+
+// batchTuner sizes receive batches after what previous reads got: full batch means more requests are waiting,
+// mostly empty one means they trickle in
+type batchTuner struct {
+	size int
+	max  int
+}
+
+func newBatchTuner(max int) *batchTuner {
+	return &batchTuner{size: 1, max: max}
+}
+
+// observe adjusts the size after a read got received packets
+func (b *batchTuner) observe(received int) {
+	if b == nil {
+		return
+	}
+	switch {
+	case received >= b.size && b.size < b.max:
+		b.size = min(b.size*2, b.max)
+	case received <= b.size/4:
+		b.size = max(b.size/2, 1)
+	}
+}
+
+// workerTuner keeps as many workers active as the load needs, the rest wait without touching the queue.
+// Listeners report how deep the queue is as they add requests, and whether they had to wait for room in it.
+// It is safe for concurrent use, nil one keeps all the workers active
+type workerTuner struct {
+	min int64
+	max int64
+
+	active int64
+	mu     sync.Mutex
+	cond   *sync.Cond
+
+	requests int64
+	depth    int64
+	stalls   int64
+}
+
+// newWorkerTuner returns tuner keeping between min and max workers active, min of them to begin with.
+// min is the number of CPUs if not positive
+func newWorkerTuner(min, max int) *workerTuner {
+	if min <= 0 {
+		min = runtime.NumCPU()
+	}
+	if min > max {
+		min = max
+	}
+	w := &workerTuner{min: int64(min), max: int64(max), active: int64(min)}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// wait blocks worker id until it's active
+func (w *workerTuner) wait(id int) {
+	if w == nil || int64(id) < atomic.LoadInt64(&w.active) {
+		return
+	}
+	w.mu.Lock()
+	for int64(id) >= atomic.LoadInt64(&w.active) {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+}
+
+// observe records request queued behind depth others, stalled if the queue was full
+func (w *workerTuner) observe(depth int, stalled bool) {
+	atomic.AddInt64(&w.requests, 1)
+	atomic.AddInt64(&w.depth, int64(depth))
+	if stalled {
+		atomic.AddInt64(&w.stalls, 1)
+	}
+}
+
+// tune adjusts active workers to what was observed since the previous call and returns their number.
+// Workers are doubled if the queue filled up or grew deeper than half of them, a quarter of them
+// is let go if requests rarely waited at all
+func (w *workerTuner) tune() int {
+	requests := atomic.SwapInt64(&w.requests, 0)
+	depth := atomic.SwapInt64(&w.depth, 0)
+	stalls := atomic.SwapInt64(&w.stalls, 0)
+	active := atomic.LoadInt64(&w.active)
+	next := active
+	switch {
+	case stalls > 0 || (requests > 0 && depth >= requests*max(active/2, 1)):
+		next = min(active*2, w.max)
+	case depth*2 < requests || requests == 0:
+		next = max(active-max(active/4, 1), w.min)
+	}
+	if next != active {
+		w.mu.Lock()
+		atomic.StoreInt64(&w.active, next)
+		w.mu.Unlock()
+		w.cond.Broadcast()
+	}
+	return int(next)
+}
+
+// activeWorkers returns how many workers are active, 0 if workers aren't tuned
+func (w *workerTuner) activeWorkers() int {
+	if w == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&w.active))
+}
+
+// run tunes workers every interval until ctx is done
+func (w *workerTuner) run(ctx context.Context, logger logging.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	active := w.activeWorkers()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if next := w.tune(); next != active {
+				logging.Or(logger).Debug("Adjusted active workers", "from", active, "to", next)
+				active = next
+			}
+		}
+	}
+}
+
+// enqueue hands request over to workers, telling tuner how busy they are
+func (s *Server) enqueue(t task) {
+	if s.tuner == nil {
+		s.tasks <- t
+		return
+	}
+	select {
+	case s.tasks <- t:
+		s.tuner.observe(len(s.tasks), false)
+	default:
+		s.tuner.observe(cap(s.tasks), true)
+		s.tasks <- t
+	}
+}
+
+// newReadBatcher returns tuner of receive batches of a listener, nil if they're of fixed BatchSize
+func (s *Server) newReadBatcher() *batchTuner {
+	if !s.AutoTune {
+		return nil
+	}
+	return newBatchTuner(max(s.BatchSize, DefaultMaxBatchSize))
+}
+
+// readBatchSize returns how many packets to read next
+func (s *Server) readBatchSize(b *batchTuner) int {
+	if b == nil {
+		return s.BatchSize
+	}
+	return b.size
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_batchTuner(t *testing.T) {
+	b := newBatchTuner(8)
+	assert.Equal(t, 1, b.size)
+	for _, want := range []int{2, 4, 8} {
+		b.observe(b.size)
+		assert.Equal(t, want, b.size, "full batches grow")
+	}
+	b.observe(8)
+	assert.Equal(t, 8, b.size, "up to the limit")
+	b.observe(5)
+	assert.Equal(t, 8, b.size)
+	b.observe(2)
+	assert.Equal(t, 4, b.size, "mostly empty ones shrink")
+	b.observe(1)
+	b.observe(0)
+	assert.Equal(t, 1, b.size)
+
+	var fixed *batchTuner
+	fixed.observe(10)
+	s := &Server{BatchSize: 32}
+	assert.Equal(t, 32, s.readBatchSize(s.newReadBatcher()))
+	s.AutoTune = true
+	assert.Equal(t, 1, s.readBatchSize(s.newReadBatcher()))
+	assert.Equal(t, DefaultMaxBatchSize, s.newReadBatcher().max)
+}
+
+func Test_workerTuner(t *testing.T) {
+	w := newWorkerTuner(2, 16)
+	assert.Equal(t, 2, w.activeWorkers())
+
+	w.observe(16, true)
+	assert.Equal(t, 4, w.tune(), "full queue doubles workers")
+	for i := 0; i < 10; i++ {
+		w.observe(2, false)
+	}
+	assert.Equal(t, 8, w.tune(), "so does queue deeper than half of them")
+	for i := 0; i < 10; i++ {
+		w.observe(1, false)
+	}
+	assert.Equal(t, 8, w.tune(), "shallow queue keeps them")
+	assert.Equal(t, 6, w.tune(), "idle ones are let go")
+	for i := 0; i < 10; i++ {
+		w.tune()
+	}
+	assert.Equal(t, 2, w.activeWorkers(), "down to the minimum")
+
+	w.observe(16, true)
+	w.tune()
+	w.observe(16, true)
+	w.tune()
+	w.observe(16, true)
+	w.tune()
+	w.observe(16, true)
+	assert.Equal(t, 16, w.tune(), "up to the maximum")
+
+	assert.Equal(t, min(runtime.NumCPU(), 4), newWorkerTuner(0, 4).activeWorkers())
+	assert.Equal(t, 0, (*workerTuner)(nil).activeWorkers())
+}
+
+func Test_workerTunerWait(t *testing.T) {
+	w := newWorkerTuner(1, 2)
+	w.wait(0)
+	woken := make(chan struct{})
+	go func() {
+		w.wait(1)
+		close(woken)
+	}()
+	select {
+	case <-woken:
+		t.Fatal("inactive worker is woken")
+	case <-time.After(10 * time.Millisecond):
+	}
+	w.observe(2, true)
+	w.tune()
+	select {
+	case <-woken:
+	case <-time.After(5 * time.Second):
+		t.Fatal("activated worker isn't woken")
+	}
+	var all *workerTuner
+	all.wait(100)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+const (
+	modeSymmetricActive  = 1
+	modeSymmetricPassive = 2
+	// minPoll and maxPoll bound poll exponent RFC 5905 allows
+	minPoll = 4
+	maxPoll = 17
+)
+
+// symmetricActive tells whether the request is well formed apart from being symmetric active,
+// which w32time sends in place of client mode unless its peers are flagged 0x8
+func symmetricActive(request *ntp.Packet) bool {
+	if request.Settings&0x7 != modeSymmetricActive {
+		return false
+	}
+	p := *request
+	p.Settings = p.Settings&^0x7 | 3
+	return p.ValidSettingsFormat()
+}
+
+// answerW32Time fixes up the response to w32time quirks of the request:
+// symmetric active requests are answered in symmetric passive mode like chrony does,
+// poll w32time derives from SpecialPollInterval is echoed back clamped to RFC 5905 range.
+// Every quirk observed is counted
+func (t *task) answerW32Time(response []byte) {
+	if t.request.Settings&0x7 == modeSymmetricActive {
+		response[offsetSettings] = response[offsetSettings]&^0x7 | modeSymmetricPassive
+		t.stats.IncW32TimeSymmetric()
+	}
+	if t.request.Poll < minPoll || t.request.Poll > maxPoll {
+		response[offsetPoll] = byte(clampPoll(t.request.Poll))
+		t.stats.IncW32TimePoll()
+	}
+}
+
+// clampPoll returns poll exponent within RFC 5905 range
+func clampPoll(poll int8) int8 {
+	if poll < minPoll {
+		return minPoll
+	}
+	if poll > maxPoll {
+		return maxPoll
+	}
+	return poll
+}
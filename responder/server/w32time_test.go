@@ -0,0 +1,101 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/facebookincubator/ntp/responder/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_symmetricActive(t *testing.T) {
+	assert.True(t, symmetricActive(&ntp.Packet{Settings: 0x19}))
+	assert.True(t, symmetricActive(&ntp.Packet{Settings: 0xd9}))
+	assert.False(t, symmetricActive(&ntp.Packet{Settings: 0x1b}), "client mode")
+	assert.False(t, symmetricActive(&ntp.Packet{Settings: 0x59}), "bad leap indicator")
+	assert.False(t, symmetricActive(&ntp.Packet{Settings: 0x29}), "bad version")
+}
+
+func Test_clampPoll(t *testing.T) {
+	assert.Equal(t, int8(4), clampPoll(0))
+	assert.Equal(t, int8(4), clampPoll(-6))
+	assert.Equal(t, int8(10), clampPoll(10))
+	assert.Equal(t, int8(17), clampPoll(25))
+}
+
+// quirkStats counts quirks observed
+type quirkStats struct {
+	stats.JSONStats
+	symmetric int
+	poll      int
+}
+
+func (s *quirkStats) IncW32TimeSymmetric() { s.symmetric++ }
+func (s *quirkStats) IncW32TimePoll()      { s.poll++ }
+
+func w32timeTask(request *ntp.Packet, quirks bool) (*task, *testBatch, *quirkStats) {
+	batch := &testBatch{}
+	st := &quirkStats{}
+	return &task{
+		batch:   batch,
+		conn:    &net.UDPConn{},
+		addr:    &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 123},
+		request: request,
+		stats:   st,
+		w32time: quirks,
+	}, batch, st
+}
+
+func Test_serveW32TimeSymmetricActive(t *testing.T) {
+	// w32time symmetric active request: version 3, mode 1, poll 17
+	task, batch, st := w32timeTask(&ntp.Packet{Settings: 0x19, Poll: 17}, false)
+	task.serve(&responseTemplate{}, 0)
+	assert.Equal(t, 0, len(batch.written), "symmetric active is invalid without quirks mode")
+
+	task, batch, st = w32timeTask(&ntp.Packet{Settings: 0x19, Poll: 17}, true)
+	task.serve(&responseTemplate{}, 0)
+	require.Equal(t, 1, len(batch.written))
+	response, err := ntp.BytesToPacket(batch.written[0])
+	require.Nil(t, err)
+	assert.Equal(t, uint8(0x1a), response.Settings, "answered in symmetric passive mode")
+	assert.Equal(t, int8(17), response.Poll)
+	assert.Equal(t, 1, st.symmetric)
+	assert.Equal(t, 0, st.poll)
+}
+
+func Test_serveW32TimePoll(t *testing.T) {
+	task, batch, st := w32timeTask(&ntp.Packet{Settings: 0x1b, Poll: 0}, true)
+	task.serve(&responseTemplate{}, 0)
+	require.Equal(t, 1, len(batch.written))
+	response, err := ntp.BytesToPacket(batch.written[0])
+	require.Nil(t, err)
+	assert.Equal(t, uint8(0x1c), response.Settings, "client mode is answered in server mode")
+	assert.Equal(t, int8(4), response.Poll)
+	assert.Equal(t, 0, st.symmetric)
+	assert.Equal(t, 1, st.poll)
+
+	task, batch, _ = w32timeTask(&ntp.Packet{Settings: 0x1b, Poll: 0}, false)
+	task.serve(&responseTemplate{}, 0)
+	require.Equal(t, 1, len(batch.written))
+	response, err = ntp.BytesToPacket(batch.written[0])
+	require.Nil(t, err)
+	assert.Equal(t, int8(0), response.Poll, "poll is echoed as is without quirks mode")
+}
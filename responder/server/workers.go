@@ -0,0 +1,121 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"golang.org/x/sys/unix"
+)
+
+// workerCPU returns CPU worker id is pinned to, -1 if workers aren't pinned
+func (s *Server) workerCPU(id int) int {
+	if len(s.WorkerCPUs) == 0 {
+		return -1
+	}
+	return s.WorkerCPUs[id%len(s.WorkerCPUs)]
+}
+
+// pinWorker binds the calling goroutine to CPU of worker id, if workers are pinned
+func (s *Server) pinWorker(id int) {
+	cpu := s.workerCPU(id)
+	if cpu < 0 {
+		return
+	}
+	if err := pinThread(cpu); err != nil {
+		s.logger().Error("Failed to pin worker", "worker", id, "cpu", cpu, "error", err)
+	}
+}
+
+// startSocketWorker serves worker's own socket for every listen IP right in the worker,
+// sockets of all the workers share addresses and the kernel spreads requests between them
+func (s *Server) startSocketWorker(id int) {
+	s.Checker.IncWorkers()
+	defer s.Checker.DecWorkers()
+	s.Stats.IncWorkers()
+	defer s.Stats.DecWorkers()
+
+	var wg sync.WaitGroup
+	for _, ip := range s.ListenConfig.IPs {
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+			s.Stats.IncListeners()
+			defer s.Stats.DecListeners()
+			s.serveWorkerSocket(id, ip, s.ListenConfig.Port)
+		}(ip)
+	}
+	wg.Wait()
+}
+
+// serveWorkerSocket serves requests arriving on ip:port with a socket of its own
+func (s *Server) serveWorkerSocket(id int, ip net.IP, port int) {
+	s.pinWorker(id)
+	s.Checker.IncListeners()
+	defer s.Checker.DecListeners()
+
+	conn := s.listen(ip, port, true)
+	defer conn.Close()
+	s.serveBatchDatapaths(conn)
+
+	response := s.newResponseTemplate()
+	batcher := s.newReadBatcher()
+	for {
+		packets, err := ntp.ReadPacketsWithKernelTimestamp(conn, s.readBatchSize(batcher))
+		if errors.Is(err, ntp.ErrControlTruncated) {
+			s.logger().Error("Dropping requests", "error", err)
+		} else if err != nil {
+			s.fatal("Failed to read requests", "ip", ip, "error", err)
+			continue
+		}
+		batcher.observe(len(packets))
+		for _, p := range packets {
+			s.Stats.IncRequests()
+			t := s.newTask(conn, p)
+			t.serve(response, s.ExtraOffset)
+		}
+	}
+}
+
+// listenUDP opens UDP socket on ip:port, reusePort lets several sockets share the address (SO_REUSEPORT)
+func listenUDP(ip net.IP, port int, reusePort bool) (*net.UDPConn, error) {
+	addr := &net.UDPAddr{IP: ip, Port: port}
+	if !reusePort {
+		return net.ListenUDP("udp", addr)
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
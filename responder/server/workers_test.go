@@ -0,0 +1,75 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_workerCPU(t *testing.T) {
+	s := &Server{}
+	assert.Equal(t, -1, s.workerCPU(0), "workers aren't pinned by default")
+
+	s.WorkerCPUs = CPUList{2, 5}
+	assert.Equal(t, 2, s.workerCPU(0))
+	assert.Equal(t, 5, s.workerCPU(1))
+	assert.Equal(t, 2, s.workerCPU(2), "CPUs are assigned round-robin")
+}
+
+func Test_listenUDPReusePort(t *testing.T) {
+	first, err := listenUDP(net.ParseIP("127.0.0.1"), 0, true)
+	require.Nil(t, err)
+	defer first.Close()
+	port := first.LocalAddr().(*net.UDPAddr).Port
+
+	second, err := listenUDP(net.ParseIP("127.0.0.1"), port, true)
+	require.Nil(t, err, "sockets of workers share the address")
+	defer second.Close()
+
+	_, err = listenUDP(net.ParseIP("127.0.0.1"), port, false)
+	assert.NotNil(t, err, "regular listener doesn't")
+
+	// The request reaches one of the sockets
+	client, err := net.DialUDP("udp", nil, first.LocalAddr().(*net.UDPAddr))
+	require.Nil(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("request"))
+	require.Nil(t, err)
+
+	received := make(chan string, 2)
+	for _, conn := range []*net.UDPConn{first, second} {
+		go func(conn *net.UDPConn) {
+			buf := make([]byte, 16)
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFromUDP(buf)
+			if err == nil {
+				received <- string(buf[:n])
+			}
+		}(conn)
+	}
+	select {
+	case r := <-received:
+		assert.Equal(t, "request", r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request wasn't received")
+	}
+}
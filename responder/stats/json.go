@@ -37,6 +37,15 @@ type JSONStats struct {
 	listeners     int64
 	workers       int64
 	announce      int64
+	ntsRequests   int64
+	ntsNAKs       int64
+	cryptoNAKs    int64
+	authenticated int64
+	authDrops     int64
+	cryptoRejects int64
+	mode7Probes   int64
+	w32Symmetric  int64
+	w32Poll       int64
 
 	prefix string
 }
@@ -50,6 +59,15 @@ func (j *JSONStats) toMap() (export map[string]int64) {
 	export[fmt.Sprintf("%slisteners", j.prefix)] = j.listeners
 	export[fmt.Sprintf("%sworkers", j.prefix)] = j.workers
 	export[fmt.Sprintf("%sannounce", j.prefix)] = j.announce
+	export[fmt.Sprintf("%snts.requests", j.prefix)] = j.ntsRequests
+	export[fmt.Sprintf("%snts.naks", j.prefix)] = j.ntsNAKs
+	export[fmt.Sprintf("%scryptonaks", j.prefix)] = j.cryptoNAKs
+	export[fmt.Sprintf("%sauth.requests", j.prefix)] = j.authenticated
+	export[fmt.Sprintf("%sauth.dropped", j.prefix)] = j.authDrops
+	export[fmt.Sprintf("%scrypto.rejected", j.prefix)] = j.cryptoRejects
+	export[fmt.Sprintf("%smode7.probes", j.prefix)] = j.mode7Probes
+	export[fmt.Sprintf("%sw32time.symmetric", j.prefix)] = j.w32Symmetric
+	export[fmt.Sprintf("%sw32time.poll", j.prefix)] = j.w32Poll
 
 	return export
 }
@@ -113,6 +131,51 @@ func (j *JSONStats) IncWorkers() {
 	atomic.AddInt64(&j.workers, 1)
 }
 
+// IncNTSRequests atomically add 1 to the counter
+func (j *JSONStats) IncNTSRequests() {
+	atomic.AddInt64(&j.ntsRequests, 1)
+}
+
+// IncNTSNAKs atomically add 1 to the counter
+func (j *JSONStats) IncNTSNAKs() {
+	atomic.AddInt64(&j.ntsNAKs, 1)
+}
+
+// IncCryptoNAKs atomically add 1 to the counter
+func (j *JSONStats) IncCryptoNAKs() {
+	atomic.AddInt64(&j.cryptoNAKs, 1)
+}
+
+// IncAuthenticated atomically add 1 to the counter
+func (j *JSONStats) IncAuthenticated() {
+	atomic.AddInt64(&j.authenticated, 1)
+}
+
+// IncUnauthenticatedDrops atomically add 1 to the counter
+func (j *JSONStats) IncUnauthenticatedDrops() {
+	atomic.AddInt64(&j.authDrops, 1)
+}
+
+// IncCryptoRejects atomically add 1 to the counter
+func (j *JSONStats) IncCryptoRejects() {
+	atomic.AddInt64(&j.cryptoRejects, 1)
+}
+
+// IncMode7Probes atomically add 1 to the counter
+func (j *JSONStats) IncMode7Probes() {
+	atomic.AddInt64(&j.mode7Probes, 1)
+}
+
+// IncW32TimeSymmetric atomically add 1 to the counter
+func (j *JSONStats) IncW32TimeSymmetric() {
+	atomic.AddInt64(&j.w32Symmetric, 1)
+}
+
+// IncW32TimePoll atomically add 1 to the counter
+func (j *JSONStats) IncW32TimePoll() {
+	atomic.AddInt64(&j.w32Poll, 1)
+}
+
 // DecListeners atomically removes 1 from the counter
 func (j *JSONStats) DecListeners() {
 	atomic.AddInt64(&j.listeners, -1)
@@ -63,6 +63,57 @@ func Test_JSONStatsWorkers(t *testing.T) {
 	assert.Equal(t, int64(0), stats.workers)
 }
 
+func Test_JSONStatsNTS(t *testing.T) {
+	stats := JSONStats{}
+
+	stats.IncNTSRequests()
+	stats.IncNTSNAKs()
+	stats.IncNTSNAKs()
+	assert.Equal(t, int64(1), stats.ntsRequests)
+	assert.Equal(t, int64(2), stats.ntsNAKs)
+}
+
+func Test_JSONStatsCryptoNAKs(t *testing.T) {
+	stats := JSONStats{}
+
+	stats.IncCryptoNAKs()
+	assert.Equal(t, int64(1), stats.cryptoNAKs)
+}
+
+func Test_JSONStatsAuthenticated(t *testing.T) {
+	stats := JSONStats{}
+
+	stats.IncAuthenticated()
+	stats.IncUnauthenticatedDrops()
+	stats.IncUnauthenticatedDrops()
+	assert.Equal(t, int64(1), stats.authenticated)
+	assert.Equal(t, int64(2), stats.authDrops)
+}
+
+func Test_JSONStatsCryptoRejects(t *testing.T) {
+	stats := JSONStats{}
+
+	stats.IncCryptoRejects()
+	assert.Equal(t, int64(1), stats.cryptoRejects)
+}
+
+func Test_JSONStatsMode7Probes(t *testing.T) {
+	stats := JSONStats{}
+
+	stats.IncMode7Probes()
+	assert.Equal(t, int64(1), stats.mode7Probes)
+}
+
+func Test_JSONStatsW32Time(t *testing.T) {
+	stats := JSONStats{}
+
+	stats.IncW32TimeSymmetric()
+	stats.IncW32TimePoll()
+	stats.IncW32TimePoll()
+	assert.Equal(t, int64(1), stats.w32Symmetric)
+	assert.Equal(t, int64(2), stats.w32Poll)
+}
+
 func Test_JSONStatsAnnounce(t *testing.T) {
 	stats := JSONStats{}
 
@@ -88,6 +139,15 @@ func Test_JSONStatsToMap(t *testing.T) {
 		listeners:     4,
 		workers:       5,
 		announce:      6,
+		ntsRequests:   7,
+		ntsNAKs:       8,
+		cryptoNAKs:    9,
+		authenticated: 10,
+		authDrops:     11,
+		cryptoRejects: 12,
+		mode7Probes:   13,
+		w32Symmetric:  14,
+		w32Poll:       15,
 	}
 	j.SetPrefix("test.")
 	result := j.toMap()
@@ -99,6 +159,15 @@ func Test_JSONStatsToMap(t *testing.T) {
 	expectedMap["test.listeners"] = 4
 	expectedMap["test.workers"] = 5
 	expectedMap["test.announce"] = 6
+	expectedMap["test.nts.requests"] = 7
+	expectedMap["test.nts.naks"] = 8
+	expectedMap["test.cryptonaks"] = 9
+	expectedMap["test.auth.requests"] = 10
+	expectedMap["test.auth.dropped"] = 11
+	expectedMap["test.crypto.rejected"] = 12
+	expectedMap["test.mode7.probes"] = 13
+	expectedMap["test.w32time.symmetric"] = 14
+	expectedMap["test.w32time.poll"] = 15
 
 	assert.Equal(t, expectedMap, result)
 }
@@ -0,0 +1,196 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xdp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Header sizes and constants of the frames we parse
+const (
+	ethHeaderLen   = 14
+	ipv4HeaderLen  = 20
+	ipv6HeaderLen  = 40
+	udpHeaderLen   = 8
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	protoUDP       = 17
+	ipv4FragMask   = 0x3fff
+	responseTTL    = 64
+	ethAddrLen     = 6
+	ipv4VersionIHL = 0x45
+)
+
+var errNotNTP = errors.New("not an NTP request")
+
+// Addr is a client address of the request received from AF_XDP socket.
+// Besides IP and port it keeps the frame request arrived in, response is sent from the same one
+type Addr struct {
+	IP    net.IP
+	Port  int
+	Local net.IP
+
+	// frame is umem address of the frame
+	frame uint64
+	// l3 and l4 are offsets of IP and UDP headers within the frame
+	l3   int
+	l4   int
+	ipv6 bool
+}
+
+// Network returns the address's network name
+func (a *Addr) Network() string {
+	return "udp"
+}
+
+// String returns the address in host:port form
+func (a *Addr) String() string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
+// parseFrame parses Ethernet, IP and UDP headers of the request sent to a given port.
+// Only untagged frames carrying IPv4 without options or IPv6 without extension headers are supported,
+// just like in the XDP program. It returns client address and UDP payload
+func parseFrame(frame []byte, port int) (*Addr, []byte, error) {
+	if len(frame) < ethHeaderLen {
+		return nil, nil, errNotNTP
+	}
+	a := &Addr{l3: ethHeaderLen}
+	var payloadLen int
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case etherTypeIPv4:
+		ip := frame[a.l3:]
+		if len(ip) < ipv4HeaderLen+udpHeaderLen || ip[0] != ipv4VersionIHL || ip[9] != protoUDP {
+			return nil, nil, errNotNTP
+		}
+		if binary.BigEndian.Uint16(ip[6:8])&ipv4FragMask != 0 {
+			return nil, nil, errNotNTP
+		}
+		a.IP = net.IP(append([]byte(nil), ip[12:16]...))
+		a.Local = net.IP(append([]byte(nil), ip[16:20]...))
+		a.l4 = a.l3 + ipv4HeaderLen
+		payloadLen = int(binary.BigEndian.Uint16(ip[2:4])) - ipv4HeaderLen - udpHeaderLen
+	case etherTypeIPv6:
+		ip := frame[a.l3:]
+		if len(ip) < ipv6HeaderLen+udpHeaderLen || ip[6] != protoUDP {
+			return nil, nil, errNotNTP
+		}
+		a.IP = net.IP(append([]byte(nil), ip[8:24]...))
+		a.Local = net.IP(append([]byte(nil), ip[24:40]...))
+		a.l4 = a.l3 + ipv6HeaderLen
+		a.ipv6 = true
+		payloadLen = int(binary.BigEndian.Uint16(ip[4:6])) - udpHeaderLen
+	default:
+		return nil, nil, errNotNTP
+	}
+
+	udp := frame[a.l4:]
+	if int(binary.BigEndian.Uint16(udp[2:4])) != port {
+		return nil, nil, errNotNTP
+	}
+	if udpLen := int(binary.BigEndian.Uint16(udp[4:6])) - udpHeaderLen; udpLen < payloadLen {
+		payloadLen = udpLen
+	}
+	payload := a.l4 + udpHeaderLen
+	if payloadLen < 0 || payload+payloadLen > len(frame) {
+		return nil, nil, fmt.Errorf("malformed frame: %d bytes of payload in %d bytes long frame", payloadLen, len(frame))
+	}
+	a.Port = int(binary.BigEndian.Uint16(udp[0:2]))
+	return a, frame[payload : payload+payloadLen], nil
+}
+
+// fillResponse turns the request frame into the response carrying payload.
+// frame must span till the end of the umem chunk. It returns length of the response frame
+func fillResponse(frame []byte, a *Addr, payload []byte) (int, error) {
+	payloadOff := a.l4 + udpHeaderLen
+	length := payloadOff + len(payload)
+	if length > len(frame) {
+		return 0, fmt.Errorf("response of %d bytes doesn't fit into the frame", len(payload))
+	}
+	udpLen := udpHeaderLen + len(payload)
+
+	// Ethernet: send it back where it came from
+	var mac [ethAddrLen]byte
+	copy(mac[:], frame[0:ethAddrLen])
+	copy(frame[0:ethAddrLen], frame[ethAddrLen:2*ethAddrLen])
+	copy(frame[ethAddrLen:2*ethAddrLen], mac[:])
+
+	// IP: swap addresses and fix up lengths
+	ip := frame[a.l3:a.l4]
+	var src, dst []byte
+	if a.ipv6 {
+		swapBytes(ip[8:24], ip[24:40])
+		binary.BigEndian.PutUint16(ip[4:6], uint16(udpLen))
+		ip[7] = responseTTL
+		src, dst = ip[8:24], ip[24:40]
+	} else {
+		swapBytes(ip[12:16], ip[16:20])
+		binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderLen+udpLen))
+		ip[8] = responseTTL
+		binary.BigEndian.PutUint16(ip[10:12], 0)
+		binary.BigEndian.PutUint16(ip[10:12], ^checksum(ip, 0))
+		src, dst = ip[12:16], ip[16:20]
+	}
+
+	// UDP: swap ports, checksum is mandatory for IPv6 and we fill it for IPv4 as well
+	udp := frame[a.l4:length]
+	swapBytes(udp[0:2], udp[2:4])
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpLen))
+	binary.BigEndian.PutUint16(udp[6:8], 0)
+	copy(udp[udpHeaderLen:], payload)
+
+	// pseudo header: addresses, protocol and UDP length
+	sum := checksum(src, 0)
+	sum = checksum(dst, sum)
+	sum = addChecksum(sum, protoUDP)
+	sum = addChecksum(sum, uint16(udpLen))
+	csum := ^checksum(udp, sum)
+	if csum == 0 {
+		// zero means no checksum
+		csum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:8], csum)
+	return length, nil
+}
+
+// swapBytes swaps contents of two equally sized slices
+func swapBytes(a, b []byte) {
+	for i := range a {
+		a[i], b[i] = b[i], a[i]
+	}
+}
+
+// checksum adds b to the ones' complement sum, see RFC 1071
+func checksum(b []byte, sum uint16) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum = addChecksum(sum, binary.BigEndian.Uint16(b[i:i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum = addChecksum(sum, uint16(b[len(b)-1])<<8)
+	}
+	return sum
+}
+
+// addChecksum adds a single 16-bit word to the ones' complement sum
+func addChecksum(sum, v uint16) uint16 {
+	s := uint32(sum) + uint32(v)
+	return uint16(s&0xffff + s>>16)
+}
@@ -0,0 +1,305 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xdp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"unsafe"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// eBPF bits from linux/bpf.h and linux/filter.h
+const (
+	bpfLdImm64  = 0x18
+	bpfLdxW     = 0x61
+	bpfLdxH     = 0x69
+	bpfLdxB     = 0x71
+	bpfMovImm   = 0xb7
+	bpfMovReg   = 0xbf
+	bpfAddImm   = 0x07
+	bpfJa       = 0x05
+	bpfJeqImm   = 0x15
+	bpfJneImm   = 0x55
+	bpfJsetImm  = 0x45
+	bpfJgtReg   = 0x2d
+	bpfCall     = 0x85
+	bpfExit     = 0x95
+	bpfFuncRMap = 51 // bpf_redirect_map
+
+	xdpPass = 2
+
+	// offsets of struct xdp_md fields
+	xdpMdData         = 0
+	xdpMdDataEnd      = 4
+	xdpMdRxQueueIndex = 16
+
+	bpfLogSize = 64 * 1024
+)
+
+// bpfInsn is struct bpf_insn
+type bpfInsn struct {
+	Code uint8
+	Regs uint8
+	Off  int16
+	Imm  int32
+}
+
+// bpfMapCreateAttr is BPF_MAP_CREATE part of union bpf_attr
+type bpfMapCreateAttr struct {
+	MapType    uint32
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	MapFlags   uint32
+}
+
+// bpfMapElemAttr is BPF_MAP_*_ELEM part of union bpf_attr
+type bpfMapElemAttr struct {
+	MapFd uint32
+	_     uint32
+	Key   uint64
+	Value uint64
+	Flags uint64
+}
+
+// bpfProgLoadAttr is BPF_PROG_LOAD part of union bpf_attr
+type bpfProgLoadAttr struct {
+	ProgType           uint32
+	InsnCnt            uint32
+	Insns              uint64
+	License            uint64
+	LogLevel           uint32
+	LogSize            uint32
+	LogBuf             uint64
+	KernVersion        uint32
+	ProgFlags          uint32
+	ProgName           [16]byte
+	ProgIfindex        uint32
+	ExpectedAttachType uint32
+}
+
+// bpfLinkCreateAttr is BPF_LINK_CREATE part of union bpf_attr
+type bpfLinkCreateAttr struct {
+	ProgFd        uint32
+	TargetIfindex uint32
+	AttachType    uint32
+	Flags         uint32
+}
+
+// bpf is a thin wrapper around bpf(2) syscall
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := syscall.Syscall(syscall.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// assembler builds eBPF program resolving jumps to labels
+type assembler struct {
+	insns  []bpfInsn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func newAssembler() *assembler {
+	return &assembler{labels: map[string]int{}, jumps: map[int]string{}}
+}
+
+func (a *assembler) emit(code, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, bpfInsn{Code: code, Regs: src<<4 | dst, Off: off, Imm: imm})
+}
+
+func (a *assembler) jump(code, dst, src uint8, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(code, dst, src, 0, imm)
+}
+
+func (a *assembler) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+func (a *assembler) program() ([]bpfInsn, error) {
+	for i, label := range a.jumps {
+		target, ok := a.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("undefined label %q", label)
+		}
+		a.insns[i].Off = int16(target - i - 1)
+	}
+	return a.insns, nil
+}
+
+// be16 returns value which loading big-endian v from the packet results in
+func be16(v uint16) int32 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return int32(*(*uint16)(unsafe.Pointer(&b[0])))
+}
+
+// ntpFilter returns XDP program which redirects UDP packets sent to a given port
+// to AF_XDP socket of the queue they arrived on and passes everything else to the kernel stack
+func ntpFilter(mapFd, port int) ([]bpfInsn, error) {
+	a := newAssembler()
+	// r6 = ctx, r2 = data, r3 = data_end
+	a.emit(bpfMovReg, 6, 1, 0, 0)
+	a.emit(bpfLdxW, 2, 6, xdpMdData, 0)
+	a.emit(bpfLdxW, 3, 6, xdpMdDataEnd, 0)
+	a.emit(bpfMovReg, 4, 2, 0, 0)
+	a.emit(bpfAddImm, 4, 0, 0, ethHeaderLen)
+	a.jump(bpfJgtReg, 4, 3, 0, "pass")
+	a.emit(bpfLdxH, 5, 2, 12, 0)
+	a.jump(bpfJeqImm, 5, 0, be16(etherTypeIPv4), "ipv4")
+	a.jump(bpfJeqImm, 5, 0, be16(etherTypeIPv6), "ipv6")
+	a.jump(bpfJa, 0, 0, 0, "pass")
+
+	a.label("ipv4")
+	a.emit(bpfMovReg, 4, 2, 0, 0)
+	a.emit(bpfAddImm, 4, 0, 0, ethHeaderLen+ipv4HeaderLen+udpHeaderLen)
+	a.jump(bpfJgtReg, 4, 3, 0, "pass")
+	a.emit(bpfLdxB, 5, 2, ethHeaderLen, 0)
+	a.jump(bpfJneImm, 5, 0, ipv4VersionIHL, "pass")
+	a.emit(bpfLdxB, 5, 2, ethHeaderLen+9, 0)
+	a.jump(bpfJneImm, 5, 0, protoUDP, "pass")
+	a.emit(bpfLdxH, 5, 2, ethHeaderLen+6, 0)
+	a.jump(bpfJsetImm, 5, 0, be16(ipv4FragMask), "pass")
+	a.emit(bpfLdxH, 5, 2, ethHeaderLen+ipv4HeaderLen+2, 0)
+	a.jump(bpfJneImm, 5, 0, be16(uint16(port)), "pass")
+	a.jump(bpfJa, 0, 0, 0, "redirect")
+
+	a.label("ipv6")
+	a.emit(bpfMovReg, 4, 2, 0, 0)
+	a.emit(bpfAddImm, 4, 0, 0, ethHeaderLen+ipv6HeaderLen+udpHeaderLen)
+	a.jump(bpfJgtReg, 4, 3, 0, "pass")
+	a.emit(bpfLdxB, 5, 2, ethHeaderLen+6, 0)
+	a.jump(bpfJneImm, 5, 0, protoUDP, "pass")
+	a.emit(bpfLdxH, 5, 2, ethHeaderLen+ipv6HeaderLen+2, 0)
+	a.jump(bpfJneImm, 5, 0, be16(uint16(port)), "pass")
+
+	// bpf_redirect_map(&xsks, rx_queue_index, XDP_PASS) passes the packet if there is no socket for the queue
+	a.label("redirect")
+	a.emit(bpfLdxW, 2, 6, xdpMdRxQueueIndex, 0)
+	a.emit(bpfLdImm64, 1, syscall.BPF_PSEUDO_MAP_FD, 0, int32(mapFd))
+	a.emit(0, 0, 0, 0, 0)
+	a.emit(bpfMovImm, 3, 0, 0, xdpPass)
+	a.emit(bpfCall, 0, 0, 0, bpfFuncRMap)
+	a.emit(bpfExit, 0, 0, 0, 0)
+
+	a.label("pass")
+	a.emit(bpfMovImm, 0, 0, 0, xdpPass)
+	a.emit(bpfExit, 0, 0, 0, 0)
+	return a.program()
+}
+
+// Program is an XDP program attached to the interface which redirects NTP requests to AF_XDP sockets
+type Program struct {
+	ifindex int
+	port    int
+	queues  int
+	mapFd   int
+	progFd  int
+	linkFd  int
+}
+
+// Attach loads XDP program redirecting requests to a given port arriving on the first queues of iface.
+// Program is detached once it's closed
+func Attach(iface string, port, queues int) (*Program, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	if queues <= 0 {
+		return nil, fmt.Errorf("invalid number of queues: %d", queues)
+	}
+	p := &Program{ifindex: ifi.Index, port: port, queues: queues, mapFd: -1, progFd: -1, linkFd: -1}
+
+	mapAttr := bpfMapCreateAttr{MapType: syscall.BPF_MAP_TYPE_XSKMAP, KeySize: 4, ValueSize: 4, MaxEntries: uint32(queues)}
+	if p.mapFd, err = bpf(syscall.BPF_MAP_CREATE, unsafe.Pointer(&mapAttr), unsafe.Sizeof(mapAttr)); err != nil {
+		return nil, fmt.Errorf("failed to create XSKMAP: %w", err)
+	}
+
+	insns, err := ntpFilter(p.mapFd, port)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	license := []byte("Apache-2.0\x00")
+	log := make([]byte, bpfLogSize)
+	progAttr := bpfProgLoadAttr{
+		ProgType:           syscall.BPF_PROG_TYPE_XDP,
+		InsnCnt:            uint32(len(insns)),
+		Insns:              uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		License:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		LogLevel:           1,
+		LogSize:            bpfLogSize,
+		LogBuf:             uint64(uintptr(unsafe.Pointer(&log[0]))),
+		ExpectedAttachType: syscall.BPF_XDP,
+	}
+	copy(progAttr.ProgName[:], "ntp_xsk")
+	p.progFd, err = bpf(syscall.BPF_PROG_LOAD, unsafe.Pointer(&progAttr), unsafe.Sizeof(progAttr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		p.Close()
+		return nil, fmt.Errorf("failed to load XDP program: %w: %s", err, bytes.TrimRight(log, "\x00"))
+	}
+
+	linkAttr := bpfLinkCreateAttr{ProgFd: uint32(p.progFd), TargetIfindex: uint32(p.ifindex), AttachType: syscall.BPF_XDP}
+	if p.linkFd, err = bpf(syscall.BPF_LINK_CREATE, unsafe.Pointer(&linkAttr), unsafe.Sizeof(linkAttr)); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("failed to attach XDP program to %s: %w", iface, err)
+	}
+	return p, nil
+}
+
+// register directs requests arriving on the queue to the socket
+func (p *Program) register(queue, fd int) error {
+	key := uint32(queue)
+	value := uint32(fd)
+	attr := bpfMapElemAttr{
+		MapFd: uint32(p.mapFd),
+		Key:   uint64(uintptr(unsafe.Pointer(&key))),
+		Value: uint64(uintptr(unsafe.Pointer(&value))),
+	}
+	_, err := bpf(syscall.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(&key)
+	runtime.KeepAlive(&value)
+	if err != nil {
+		return fmt.Errorf("failed to register socket for queue %d: %w", queue, err)
+	}
+	return nil
+}
+
+// Close detaches the program, requests go to the kernel stack again
+func (p *Program) Close() error {
+	var err error
+	for _, fd := range []*int{&p.linkFd, &p.progFd, &p.mapFd} {
+		if *fd < 0 {
+			continue
+		}
+		if cerr := syscall.Close(*fd); cerr != nil && err == nil {
+			err = cerr
+		}
+		*fd = -1
+	}
+	return err
+}
@@ -0,0 +1,335 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xdp
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	syscall "golang.org/x/sys/unix"
+)
+
+// umem and ring sizes, all must be powers of 2
+const (
+	frameSize = 2048
+	numFrames = 4096
+	ringSize  = 2048
+	// pollTimeoutMs bounds waiting for packets so completed transmits are recycled even when it's quiet
+	pollTimeoutMs = 10
+)
+
+// xskRing is a single-producer single-consumer ring shared with the kernel
+type xskRing struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	flags    *uint32
+	descs    uintptr
+	mask     uint32
+	size     uint32
+}
+
+// mapRing maps the ring of the socket at a given page offset
+func mapRing(fd int, off syscall.XDPRingOffset, pgoff int64, size uint32, entrySize uintptr) (xskRing, error) {
+	mem, err := syscall.Mmap(fd, pgoff, int(off.Desc+uint64(size)*uint64(entrySize)), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return xskRing{}, err
+	}
+	return xskRing{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Pointer(&mem[off.Producer])),
+		consumer: (*uint32)(unsafe.Pointer(&mem[off.Consumer])),
+		flags:    (*uint32)(unsafe.Pointer(&mem[off.Flags])),
+		descs:    uintptr(off.Desc),
+		mask:     size - 1,
+		size:     size,
+	}, nil
+}
+
+// free returns how many entries we can produce
+func (r *xskRing) free() uint32 {
+	return r.size - (atomic.LoadUint32(r.producer) - atomic.LoadUint32(r.consumer))
+}
+
+// available returns how many entries we can consume
+func (r *xskRing) available() uint32 {
+	return atomic.LoadUint32(r.producer) - atomic.LoadUint32(r.consumer)
+}
+
+// addr returns address of i-th entry of fill or completion ring
+func (r *xskRing) addr(i uint32) *uint64 {
+	return (*uint64)(unsafe.Pointer(&r.mem[r.descs+uintptr(i&r.mask)*8]))
+}
+
+// desc returns i-th entry of rx or tx ring
+func (r *xskRing) desc(i uint32) *syscall.XDPDesc {
+	return (*syscall.XDPDesc)(unsafe.Pointer(&r.mem[r.descs+uintptr(i&r.mask)*unsafe.Sizeof(syscall.XDPDesc{})]))
+}
+
+// Socket is an AF_XDP socket bound to a single receive queue.
+// Requests are parsed right from umem frames and responses are sent from the very same frames,
+// bypassing the kernel UDP stack. Socket is not safe for concurrent use
+type Socket struct {
+	fd      int
+	ifindex int
+	port    int
+	umem    []byte
+
+	fill xskRing
+	comp xskRing
+	rx   xskRing
+	tx   xskRing
+
+	// held are frames of the last batch, sent marks the ones queued for transmit
+	held []uint64
+	sent []bool
+}
+
+// NewSocket creates AF_XDP socket receiving requests redirected by p from a given queue
+func NewSocket(p *Program, queue int) (*Socket, error) {
+	if queue < 0 || queue >= p.queues {
+		return nil, fmt.Errorf("queue %d is out of range, program redirects %d queues", queue, p.queues)
+	}
+	fd, err := syscall.Socket(syscall.AF_XDP, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AF_XDP socket: %w", err)
+	}
+	s := &Socket{fd: fd, ifindex: p.ifindex, port: p.port, sent: make([]bool, numFrames)}
+	if err := s.setup(queue); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := p.register(queue, fd); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// setup registers umem, maps the rings and binds the socket
+func (s *Socket) setup(queue int) error {
+	var err error
+	if s.umem, err = syscall.Mmap(-1, 0, numFrames*frameSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS|syscall.MAP_POPULATE); err != nil {
+		return fmt.Errorf("failed to allocate umem: %w", err)
+	}
+	reg := syscall.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&s.umem[0]))),
+		Len:  uint64(len(s.umem)),
+		Size: frameSize,
+	}
+	if err := setsockopt(s.fd, syscall.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return fmt.Errorf("failed to register umem: %w", err)
+	}
+	for opt, size := range map[int]int{
+		syscall.XDP_UMEM_FILL_RING:       numFrames,
+		syscall.XDP_UMEM_COMPLETION_RING: numFrames,
+		syscall.XDP_RX_RING:              ringSize,
+		syscall.XDP_TX_RING:              ringSize,
+	} {
+		if err := syscall.SetsockoptInt(s.fd, syscall.SOL_XDP, opt, size); err != nil {
+			return fmt.Errorf("failed to set ring size: %w", err)
+		}
+	}
+
+	var off syscall.XDPMmapOffsets
+	offLen := uint32(unsafe.Sizeof(off))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(s.fd), syscall.SOL_XDP, syscall.XDP_MMAP_OFFSETS, uintptr(unsafe.Pointer(&off)), uintptr(unsafe.Pointer(&offLen)), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to get ring offsets: %w", errno)
+	}
+	if s.fill, err = mapRing(s.fd, off.Fr, syscall.XDP_UMEM_PGOFF_FILL_RING, numFrames, 8); err != nil {
+		return fmt.Errorf("failed to map fill ring: %w", err)
+	}
+	if s.comp, err = mapRing(s.fd, off.Cr, syscall.XDP_UMEM_PGOFF_COMPLETION_RING, numFrames, 8); err != nil {
+		return fmt.Errorf("failed to map completion ring: %w", err)
+	}
+	if s.rx, err = mapRing(s.fd, off.Rx, syscall.XDP_PGOFF_RX_RING, ringSize, unsafe.Sizeof(syscall.XDPDesc{})); err != nil {
+		return fmt.Errorf("failed to map rx ring: %w", err)
+	}
+	if s.tx, err = mapRing(s.fd, off.Tx, syscall.XDP_PGOFF_TX_RING, ringSize, unsafe.Sizeof(syscall.XDPDesc{})); err != nil {
+		return fmt.Errorf("failed to map tx ring: %w", err)
+	}
+
+	// All frames start in the fill ring, responses are sent from the frames requests arrived in
+	for i := uint32(0); i < numFrames; i++ {
+		*s.fill.addr(i) = uint64(i) * frameSize
+	}
+	atomic.StoreUint32(s.fill.producer, numFrames)
+
+	sa := &syscall.SockaddrXDP{Flags: syscall.XDP_USE_NEED_WAKEUP, Ifindex: uint32(s.ifindex), QueueID: uint32(queue)}
+	if err := syscall.Bind(s.fd, sa); err != nil {
+		return fmt.Errorf("failed to bind AF_XDP socket to queue %d: %w", queue, err)
+	}
+	return nil
+}
+
+// setsockopt sets SOL_XDP option which value is a struct
+func setsockopt(fd, opt int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_XDP, uintptr(opt), uintptr(val), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Close closes the socket, kernel removes it from the program's map
+func (s *Socket) Close() error {
+	err := syscall.Close(s.fd)
+	for _, m := range [][]byte{s.fill.mem, s.comp.mem, s.rx.mem, s.tx.mem, s.umem} {
+		if m != nil {
+			_ = syscall.Munmap(m)
+		}
+	}
+	s.fill, s.comp, s.rx, s.tx, s.umem = xskRing{}, xskRing{}, xskRing{}, xskRing{}, nil
+	return err
+}
+
+// ReadPackets submits queued responses and waits for at least one request.
+// There are no kernel timestamps here, packets are stamped right after they are taken from the ring
+func (s *Socket) ReadPackets() ([]ntp.ReceivedPacket, error) {
+	s.recycle()
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	for s.rx.available() == 0 {
+		fds := []syscall.PollFd{{Fd: int32(s.fd), Events: syscall.POLLIN}}
+		if _, err := syscall.Poll(fds, pollTimeoutMs); err != nil && err != syscall.EINTR {
+			return nil, fmt.Errorf("failed to poll AF_XDP socket: %w", err)
+		}
+		s.reapCompletions()
+	}
+	mono := ntp.MonotonicRaw()
+	rxTime := time.Now()
+
+	cons := atomic.LoadUint32(s.rx.consumer)
+	n := s.rx.available()
+	packets := make([]ntp.ReceivedPacket, 0, n)
+	for i := uint32(0); i < n; i++ {
+		desc := *s.rx.desc(cons + i)
+		frame := s.umem[desc.Addr : desc.Addr+uint64(desc.Len)]
+		a, payload, err := parseFrame(frame, s.port)
+		if err != nil || len(payload) < ntp.PacketSizeBytes {
+			s.refill(desc.Addr)
+			continue
+		}
+		packet, err := ntp.BytesToPacket(payload[:ntp.PacketSizeBytes])
+		if err != nil {
+			s.refill(desc.Addr)
+			continue
+		}
+		a.frame = desc.Addr
+		s.held = append(s.held, desc.Addr)
+		var extensions []byte
+		if len(payload) > ntp.PacketSizeBytes {
+			// frame is reused for the response, so extensions can't point into it
+			extensions = append(extensions, payload[ntp.PacketSizeBytes:]...)
+		}
+		packets = append(packets, ntp.ReceivedPacket{
+			Packet:     packet,
+			RxTime:     rxTime,
+			RxMono:     mono,
+			RemAddr:    a,
+			Local:      &ntp.PktInfo{Addr: a.Local, Ifindex: s.ifindex},
+			Extensions: extensions,
+		})
+	}
+	atomic.StoreUint32(s.rx.consumer, cons+n)
+	return packets, nil
+}
+
+// QueueWrite turns the request frame addr came with into response b and queues it for transmit.
+// local is ignored, response is always sent from the address request arrived to.
+// It's submitted with the next ReadPackets or Flush call
+func (s *Socket) QueueWrite(b []byte, addr net.Addr, local *ntp.PktInfo) error {
+	a, ok := addr.(*Addr)
+	if !ok {
+		return fmt.Errorf("unsupported address type %T", addr)
+	}
+	if s.tx.free() == 0 {
+		s.reapCompletions()
+		if err := s.Flush(); err != nil {
+			return err
+		}
+		if s.tx.free() == 0 {
+			return fmt.Errorf("tx ring is full")
+		}
+	}
+	chunkEnd := a.frame&^(frameSize-1) + frameSize
+	n, err := fillResponse(s.umem[a.frame:chunkEnd], a, b)
+	if err != nil {
+		return err
+	}
+	prod := atomic.LoadUint32(s.tx.producer)
+	*s.tx.desc(prod) = syscall.XDPDesc{Addr: a.frame, Len: uint32(n)}
+	atomic.StoreUint32(s.tx.producer, prod+1)
+	s.sent[a.frame/frameSize] = true
+	return nil
+}
+
+// Flush kicks the kernel to transmit queued responses
+func (s *Socket) Flush() error {
+	if atomic.LoadUint32(s.tx.flags)&syscall.XDP_RING_NEED_WAKEUP == 0 || s.tx.available() == 0 {
+		return nil
+	}
+	err := syscall.Sendto(s.fd, nil, syscall.MSG_DONTWAIT, nil)
+	switch err {
+	case nil, syscall.EAGAIN, syscall.EBUSY, syscall.ENOBUFS, syscall.ENETDOWN:
+		// transmit is retried with the next kick
+		return nil
+	}
+	return fmt.Errorf("failed to kick AF_XDP transmit: %w", err)
+}
+
+// WriteErrors always returns 0, AF_XDP doesn't report per-packet transmit errors
+func (s *Socket) WriteErrors() int {
+	return 0
+}
+
+// recycle returns frames of the previous batch which weren't used for responses to the fill ring
+func (s *Socket) recycle() {
+	for _, frame := range s.held {
+		idx := frame / frameSize
+		if !s.sent[idx] {
+			s.refill(frame)
+		}
+		s.sent[idx] = false
+	}
+	s.held = s.held[:0]
+	s.reapCompletions()
+}
+
+// reapCompletions returns frames of transmitted responses to the fill ring
+func (s *Socket) reapCompletions() {
+	cons := atomic.LoadUint32(s.comp.consumer)
+	n := s.comp.available()
+	for i := uint32(0); i < n; i++ {
+		s.refill(*s.comp.addr(cons + i))
+	}
+	atomic.StoreUint32(s.comp.consumer, cons+n)
+}
+
+// refill hands the frame back to the kernel for receiving.
+// Every frame is either in one of the rings or held by us, so fill ring always has room
+func (s *Socket) refill(frame uint64) {
+	prod := atomic.LoadUint32(s.fill.producer)
+	*s.fill.addr(prod) = frame &^ (frameSize - 1)
+	atomic.StoreUint32(s.fill.producer, prod+1)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xdp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+	"github.com/stretchr/testify/assert"
+	syscall "golang.org/x/sys/unix"
+)
+
+// capture is AF_PACKET socket receiving all the frames of the interface
+type capture struct {
+	fd int
+}
+
+func newCapture(iface string) (*capture, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	proto := int(htons(syscall.ETH_P_ALL))
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, err
+	}
+	c := &capture{fd: fd}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: uint16(proto), Ifindex: ifi.Index}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	tv := syscall.Timeval{Sec: 5}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *capture) Read(b []byte) (int, error) {
+	n, _, err := syscall.Recvfrom(c.fd, b, 0)
+	return n, err
+}
+
+func (c *capture) Close() error {
+	return syscall.Close(c.fd)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func TestSocket(t *testing.T) {
+	// pick a port nobody listens on, kernel would reject requests we don't redirect
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.Nil(t, err)
+	port := l.LocalAddr().(*net.UDPAddr).Port
+	l.Close()
+
+	p, err := Attach("lo", port, 1)
+	if err != nil {
+		t.Skipf("XDP is not available: %v", err)
+	}
+	defer p.Close()
+	s, err := NewSocket(p, 0)
+	if err != nil {
+		t.Skipf("AF_XDP is not available: %v", err)
+	}
+	defer s.Close()
+
+	// Kernel drops 127/8 frames injected into lo as martians, so catch the response on the wire
+	capture, err := newCapture("lo")
+	assert.Nil(t, err)
+	defer capture.Close()
+
+	cconn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	assert.Nil(t, err)
+	defer cconn.Close()
+	clientPort := cconn.LocalAddr().(*net.UDPAddr).Port
+
+	_, err = cconn.Write(request)
+	assert.Nil(t, err)
+	packets, err := s.ReadPackets()
+	assert.Nil(t, err)
+	assert.Len(t, packets, 1)
+	p0 := packets[0]
+	expected, err := ntp.BytesToPacket(request)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, p0.Packet)
+	assert.Equal(t, cconn.LocalAddr().String(), p0.RemAddr.String())
+	assert.True(t, net.ParseIP("127.0.0.1").Equal(p0.Local.Addr))
+
+	err = s.QueueWrite(response, p0.RemAddr, p0.Local)
+	assert.Nil(t, err)
+	err = s.Flush()
+	assert.Nil(t, err)
+
+	buf := make([]byte, frameSize)
+	for {
+		n, err := capture.Read(buf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		a, payload, err := parseFrame(buf[:n], clientPort)
+		if err != nil || a.Port != port {
+			continue
+		}
+		assert.True(t, net.ParseIP("127.0.0.1").Equal(a.IP))
+		assert.Equal(t, response, payload)
+		return
+	}
+}
@@ -0,0 +1,76 @@
+// +build !linux
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xdp
+
+import (
+	"errors"
+	"net"
+
+	"github.com/facebookincubator/ntp/protocol/ntp"
+)
+
+var errNotSupported = errors.New("AF_XDP is only supported on Linux")
+
+// Program is an XDP program attached to the interface which redirects NTP requests to AF_XDP sockets.
+// XDP is Linux-specific, here it's never available
+type Program struct{}
+
+// Attach always fails
+func Attach(iface string, port, queues int) (*Program, error) {
+	return nil, errNotSupported
+}
+
+// Close does nothing
+func (p *Program) Close() error {
+	return nil
+}
+
+// Socket is an AF_XDP socket bound to a single receive queue.
+// AF_XDP is Linux-specific, here it's never available
+type Socket struct{}
+
+// NewSocket always fails
+func NewSocket(p *Program, queue int) (*Socket, error) {
+	return nil, errNotSupported
+}
+
+// Close does nothing
+func (s *Socket) Close() error {
+	return nil
+}
+
+// ReadPackets always fails
+func (s *Socket) ReadPackets() ([]ntp.ReceivedPacket, error) {
+	return nil, errNotSupported
+}
+
+// QueueWrite always fails
+func (s *Socket) QueueWrite(b []byte, addr net.Addr, local *ntp.PktInfo) error {
+	return errNotSupported
+}
+
+// Flush always fails
+func (s *Socket) Flush() error {
+	return errNotSupported
+}
+
+// WriteErrors always returns 0
+func (s *Socket) WriteErrors() int {
+	return 0
+}
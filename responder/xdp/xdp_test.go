@@ -0,0 +1,150 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xdp
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	clientMAC = []byte{0x02, 0, 0, 0, 0, 1}
+	serverMAC = []byte{0x02, 0, 0, 0, 0, 2}
+	request   = []byte{227, 0, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 226, 39, 15, 119, 162, 4, 176, 212}
+	response  = []byte{36, 1, 3, 224, 0, 0, 0, 0, 0, 0, 0, 10, 70, 66, 32, 32, 226, 39, 12, 8, 0, 0, 0, 0, 226, 39, 15, 119, 162, 4, 176, 212, 226, 39, 15, 119, 162, 7, 30, 48, 226, 39, 15, 119, 162, 28, 37, 6}
+)
+
+// buildFrame builds Ethernet frame with UDP datagram from client to server
+func buildFrame(client, server net.IP, clientPort, serverPort int, payload []byte) []byte {
+	frame := append(append([]byte{}, serverMAC...), clientMAC...)
+	udp := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], uint16(clientPort))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(serverPort))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+	if client4 := client.To4(); client4 != nil {
+		frame = append(frame, 0x08, 0x00)
+		ip := make([]byte, ipv4HeaderLen)
+		ip[0] = ipv4VersionIHL
+		binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderLen+len(udp)))
+		ip[8] = 32
+		ip[9] = protoUDP
+		copy(ip[12:16], client4)
+		copy(ip[16:20], server.To4())
+		frame = append(frame, ip...)
+	} else {
+		frame = append(frame, 0x86, 0xdd)
+		ip := make([]byte, ipv6HeaderLen)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:6], uint16(len(udp)))
+		ip[6] = protoUDP
+		ip[7] = 32
+		copy(ip[8:24], client)
+		copy(ip[24:40], server)
+		frame = append(frame, ip...)
+	}
+	return append(frame, udp...)
+}
+
+func TestParseFrameIPv4(t *testing.T) {
+	frame := buildFrame(net.ParseIP("192.168.0.1"), net.ParseIP("10.0.0.1"), 40000, 123, request)
+	a, payload, err := parseFrame(frame, 123)
+	assert.Nil(t, err)
+	assert.True(t, net.ParseIP("192.168.0.1").Equal(a.IP))
+	assert.True(t, net.ParseIP("10.0.0.1").Equal(a.Local))
+	assert.Equal(t, 40000, a.Port)
+	assert.Equal(t, "192.168.0.1:40000", a.String())
+	assert.Equal(t, request, payload)
+}
+
+func TestParseFrameIPv6(t *testing.T) {
+	frame := buildFrame(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::123"), 40000, 123, request)
+	a, payload, err := parseFrame(frame, 123)
+	assert.Nil(t, err)
+	assert.True(t, net.ParseIP("2001:db8::1").Equal(a.IP))
+	assert.True(t, net.ParseIP("2001:db8::123").Equal(a.Local))
+	assert.Equal(t, "[2001:db8::1]:40000", a.String())
+	assert.Equal(t, request, payload)
+}
+
+func TestParseFrameNotNTP(t *testing.T) {
+	frame := buildFrame(net.ParseIP("192.168.0.1"), net.ParseIP("10.0.0.1"), 40000, 53, request)
+	_, _, err := parseFrame(frame, 123)
+	assert.Equal(t, errNotNTP, err)
+
+	// fragments are left to the kernel
+	frame = buildFrame(net.ParseIP("192.168.0.1"), net.ParseIP("10.0.0.1"), 40000, 123, request)
+	frame[ethHeaderLen+6] = 0x20
+	_, _, err = parseFrame(frame, 123)
+	assert.Equal(t, errNotNTP, err)
+
+	_, _, err = parseFrame(frame[:ethHeaderLen+10], 123)
+	assert.Equal(t, errNotNTP, err)
+}
+
+func TestParseFrameTruncated(t *testing.T) {
+	frame := buildFrame(net.ParseIP("192.168.0.1"), net.ParseIP("10.0.0.1"), 40000, 123, request)
+	_, _, err := parseFrame(frame[:len(frame)-10], 123)
+	assert.NotNil(t, err)
+}
+
+func TestFillResponse(t *testing.T) {
+	for _, ips := range [][]string{{"192.168.0.1", "10.0.0.1"}, {"2001:db8::1", "2001:db8::123"}} {
+		client, server := net.ParseIP(ips[0]), net.ParseIP(ips[1])
+		frame := buildFrame(client, server, 40000, 123, request[:40])
+		a, _, err := parseFrame(frame, 123)
+		assert.Nil(t, err)
+
+		// response is longer than request
+		buf := make([]byte, 2*len(frame))
+		copy(buf, frame)
+		n, err := fillResponse(buf, a, response)
+		assert.Nil(t, err)
+		buf = buf[:n]
+
+		assert.Equal(t, clientMAC, buf[0:6])
+		assert.Equal(t, serverMAC, buf[6:12])
+		r, payload, err := parseFrame(buf, 40000)
+		assert.Nil(t, err)
+		assert.True(t, server.Equal(r.IP))
+		assert.True(t, client.Equal(r.Local))
+		assert.Equal(t, 123, r.Port)
+		assert.Equal(t, response, payload)
+
+		// checksums over the data including checksum itself add up to all ones
+		src, dst := buf[r.l3+12:r.l3+16], buf[r.l3+16:r.l3+20]
+		if r.ipv6 {
+			src, dst = buf[r.l3+8:r.l3+24], buf[r.l3+24:r.l3+40]
+		} else {
+			assert.Equal(t, uint16(0xffff), checksum(buf[r.l3:r.l4], 0))
+		}
+		sum := checksum(dst, checksum(src, 0))
+		sum = addChecksum(addChecksum(sum, protoUDP), uint16(udpHeaderLen+len(response)))
+		assert.Equal(t, uint16(0xffff), checksum(buf[r.l4:], sum))
+	}
+}
+
+func TestFillResponseTooLong(t *testing.T) {
+	frame := buildFrame(net.ParseIP("192.168.0.1"), net.ParseIP("10.0.0.1"), 40000, 123, request)
+	a, _, err := parseFrame(frame, 123)
+	assert.Nil(t, err)
+	_, err = fillResponse(frame, a, append(response, 0))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statsfile writes loopstats, peerstats and clockstats statistics files in ntpd formats,
+// rotated daily the way ntpd filegen does, so tools like ntpviz can analyze them
+package statsfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/discipline"
+)
+
+// unixEpochMJD is Modified Julian Day of Unix epoch
+const unixEpochMJD = 40587
+
+// File is a statistics file rotated daily: records go to Name.YYYYMMDD in Dir, named after UTC day of the record,
+// and Name is a hard link to the current one, like ntpd filegen of type day does. It is safe for concurrent use
+type File struct {
+	Dir  string
+	Name string
+
+	mu  sync.Mutex
+	f   *os.File
+	day string
+}
+
+// NewFile returns statistics file. Nothing is created until the first record is written
+func NewFile(dir, name string) *File {
+	return &File{Dir: dir, Name: name}
+}
+
+// Timestamp returns Modified Julian Day and seconds past UTC midnight, which start every ntpd statistics record
+func Timestamp(t time.Time) string {
+	t = t.UTC()
+	sec := t.Unix()
+	day := sec / 86400
+	past := float64(sec-day*86400) + float64(t.Nanosecond())/1e9
+	return fmt.Sprintf("%d %.3f", day+unixEpochMJD, past)
+}
+
+// Write appends the record at t, rotating the file if the day changed. Timestamp is prepended to the record
+func (f *File) Write(t time.Time, record string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.rotate(t.UTC().Format("20060102")); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(f.f, "%s %s\n", Timestamp(t), record)
+	return err
+}
+
+func (f *File) rotate(day string) error {
+	if f.f != nil && f.day == day {
+		return nil
+	}
+	if f.f != nil {
+		if err := f.f.Close(); err != nil {
+			return err
+		}
+		f.f = nil
+	}
+	path := filepath.Join(f.Dir, f.Name+"."+day)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	link := filepath.Join(f.Dir, f.Name)
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		file.Close()
+		return err
+	}
+	if err := os.Link(path, link); err != nil {
+		file.Close()
+		return err
+	}
+	f.f = file
+	f.day = day
+	return nil
+}
+
+// Close closes the current file, the next record opens it again
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+// Peer is a peerstats record
+type Peer struct {
+	// Addr is the address of the server, or 127.127.t.u pseudo address of reference clock
+	Addr string
+	// Status is peer status word
+	Status     uint16
+	Offset     time.Duration
+	Delay      time.Duration
+	Dispersion time.Duration
+	Jitter     time.Duration
+}
+
+// Stats writes loopstats, peerstats and clockstats files to the directory, which must exist
+type Stats struct {
+	Loopstats  *File
+	Peerstats  *File
+	Clockstats *File
+}
+
+// New returns statistics files in dir
+func New(dir string) *Stats {
+	return &Stats{
+		Loopstats:  NewFile(dir, "loopstats"),
+		Peerstats:  NewFile(dir, "peerstats"),
+		Clockstats: NewFile(dir, "clockstats"),
+	}
+}
+
+// Loop writes loopstats record of the loop state at t: offset, frequency in PPM, jitter, wander in PPM and poll exponent
+func (s *Stats) Loop(t time.Time, l *discipline.Stats) error {
+	return s.Loopstats.Write(t, fmt.Sprintf("%.9f %.3f %.9f %.6f %d",
+		l.Offset.Seconds(), l.FrequencyPPB/1000, l.Jitter.Seconds(), l.WanderPPB/1000, l.Poll))
+}
+
+// Peer writes peerstats record of the peer at t: address, status word, offset, delay, dispersion and jitter
+func (s *Stats) Peer(t time.Time, p *Peer) error {
+	return s.Peerstats.Write(t, fmt.Sprintf("%s %x %.9f %.9f %.9f %.9f",
+		p.Addr, p.Status, p.Offset.Seconds(), p.Delay.Seconds(), p.Dispersion.Seconds(), p.Jitter.Seconds()))
+}
+
+// Clock writes clockstats record of reference clock at t: its address and the last timecode it received
+func (s *Stats) Clock(t time.Time, addr, timecode string) error {
+	return s.Clockstats.Write(t, addr+" "+timecode)
+}
+
+// Close closes all the files
+func (s *Stats) Close() error {
+	var first error
+	for _, f := range []*File{s.Loopstats, s.Peerstats, s.Clockstats} {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statsfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebookincubator/ntp/discipline"
+)
+
+func TestTimestamp(t *testing.T) {
+	// 2020-09-13 12:26:40.25 UTC
+	assert.Equal(t, "59105 44800.250", Timestamp(time.Unix(1600000000, 250000000)))
+	assert.Equal(t, "40587 0.000", Timestamp(time.Unix(0, 0)))
+}
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(dir)
+	defer s.Close()
+	at := time.Unix(1600000000, 0)
+	require.NoError(t, s.Loop(at, &discipline.Stats{
+		Offset:       6019 * time.Nanosecond,
+		FrequencyPPB: 13778.19,
+		Jitter:       351733 * time.Nanosecond,
+		WanderPPB:    13.3806,
+		Poll:         6,
+	}))
+	require.NoError(t, s.Peer(at, &Peer{
+		Addr:       "127.127.4.1",
+		Status:     0x9714,
+		Offset:     -1605376 * time.Nanosecond,
+		Dispersion: 1424877 * time.Nanosecond,
+		Jitter:     958674 * time.Nanosecond,
+	}))
+	require.NoError(t, s.Clock(at, "127.127.20.0", "$GPRMC,122640,A"))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "loopstats.20200913"))
+	require.NoError(t, err)
+	assert.Equal(t, "59105 44800.000 0.000006019 13.778 0.000351733 0.013381 6\n", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "peerstats"))
+	require.NoError(t, err)
+	assert.Equal(t, "59105 44800.000 127.127.4.1 9714 -0.001605376 0.000000000 0.001424877 0.000958674\n", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "clockstats"))
+	require.NoError(t, err)
+	assert.Equal(t, "59105 44800.000 127.127.20.0 $GPRMC,122640,A\n", string(data))
+}
+
+func TestFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f := NewFile(dir, "loopstats")
+	defer f.Close()
+	day := time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC)
+	require.NoError(t, f.Write(day, "first"))
+	require.NoError(t, f.Write(day.Add(time.Second), "second"))
+	require.NoError(t, f.Write(day.Add(2*time.Second), "third"))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "loopstats.20201231"))
+	require.NoError(t, err)
+	assert.Equal(t, "59214 86399.000 first\n", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "loopstats.20210101"))
+	require.NoError(t, err)
+	assert.Equal(t, "59215 0.000 second\n59215 1.000 third\n", string(data))
+	// the link follows the current file
+	data, err = ioutil.ReadFile(filepath.Join(dir, "loopstats"))
+	require.NoError(t, err)
+	assert.Equal(t, "59215 0.000 second\n59215 1.000 third\n", string(data))
+}
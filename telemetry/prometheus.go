@@ -0,0 +1,76 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// promDescs caches Prometheus descriptions of metric descriptions
+var promDescs sync.Map
+
+func promDesc(d *Desc) *prometheus.Desc {
+	if p, ok := promDescs.Load(d); ok {
+		return p.(*prometheus.Desc)
+	}
+	p, _ := promDescs.LoadOrStore(d, prometheus.NewDesc(d.Name, d.Help, d.Labels, nil))
+	return p.(*prometheus.Desc)
+}
+
+// Describe sends Prometheus descriptions of the source metrics, for its prometheus.Collector implementation
+func Describe(s Source, ch chan<- *prometheus.Desc) {
+	for _, d := range s.Descs() {
+		ch <- promDesc(d)
+	}
+}
+
+// Collect sends current values of the source metrics to Prometheus, for its prometheus.Collector implementation
+func Collect(s Source, ch chan<- prometheus.Metric) {
+	for _, m := range s.Metrics() {
+		t := prometheus.GaugeValue
+		if m.Desc.Type == Counter {
+			t = prometheus.CounterValue
+		}
+		ch <- prometheus.MustNewConstMetric(promDesc(m.Desc), t, m.Value, m.LabelValues...)
+	}
+}
+
+// Collector is Prometheus collector of the sources
+type Collector struct {
+	sources []Source
+}
+
+// NewCollector returns Prometheus collector of the sources
+func NewCollector(sources ...Source) *Collector {
+	return &Collector{sources: sources}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, s := range c.sources {
+		Describe(s, ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.sources {
+		Collect(s, ch)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxPacketSize keeps StatsD datagrams within Ethernet MTU
+const maxPacketSize = 1432
+
+// tagReplacer and nameReplacer replace characters StatsD uses as separators in label values
+var (
+	tagReplacer  = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+	nameReplacer = strings.NewReplacer(".", "_", ":", "_", "|", "_", "\n", "_")
+)
+
+// StatsD sends metrics to StatsD daemon or Datadog agent over UDP: gauges as they are and counters as increments
+// since the previous write. Labels are sent as DogStatsD tags. It is safe for concurrent use
+type StatsD struct {
+	// Prefix is prepended to metric names, e.g. "myservice."
+	Prefix string
+	// PlainLabels appends label values to metric names instead of sending tags,
+	// for StatsD daemons which don't understand DogStatsD tags
+	PlainLabels bool
+
+	conn io.WriteCloser
+	mu   sync.Mutex
+	// counters are values of counters written last, by line without value
+	counters map[string]float64
+}
+
+// NewStatsD returns sink sending metrics to StatsD daemon at host:port
+func NewStatsD(addr string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newStatsD(conn), nil
+}
+
+func newStatsD(conn io.WriteCloser) *StatsD {
+	return &StatsD{conn: conn, counters: map[string]float64{}}
+}
+
+// Write sends the metrics, as many of them in a datagram as fit. Counters which didn't change are skipped
+func (s *StatsD) Write(metrics []Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var packet bytes.Buffer
+	var err error
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, werr := s.conn.Write(packet.Bytes()); werr != nil && err == nil {
+			err = werr
+		}
+		packet.Reset()
+	}
+	for _, m := range metrics {
+		name, tags := s.name(m)
+		value, kind := m.Value, "g"
+		if m.Desc.Type == Counter {
+			key := name + tags
+			last, seen := s.counters[key]
+			s.counters[key] = m.Value
+			// counters only go down when the process restarts
+			if seen && m.Value >= last {
+				value -= last
+			}
+			if seen && value == 0 {
+				continue
+			}
+			kind = "c"
+		}
+		line := name + ":" + strconv.FormatFloat(value, 'g', -1, 64) + "|" + kind + tags
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	flush()
+	return err
+}
+
+// name returns name of the metric along with tags of its labels, which are empty if labels go into the name
+func (s *StatsD) name(m Metric) (string, string) {
+	name := s.Prefix + m.Desc.Name
+	if len(m.LabelValues) == 0 {
+		return name, ""
+	}
+	if s.PlainLabels {
+		for _, v := range m.LabelValues {
+			name += "." + nameReplacer.Replace(v)
+		}
+		return name, ""
+	}
+	tags := make([]string, len(m.LabelValues))
+	for i, v := range m.LabelValues {
+		tags[i] = m.Desc.Labels[i] + ":" + tagReplacer.Replace(v)
+	}
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+// Close closes connection to the daemon
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
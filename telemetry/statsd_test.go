@@ -0,0 +1,116 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn keeps datagrams written to it
+type fakeConn struct {
+	packets []string
+	err     error
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.packets = append(c.packets, string(b))
+	return len(b), nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+var (
+	testGauge   = NewDesc("ntp_test_offset_seconds", "Offset", Gauge, "source")
+	testCounter = NewDesc("ntp_test_polls_total", "Polls", Counter, "source", "mode")
+	testPlain   = NewDesc("ntp_test_up", "Up", Gauge)
+)
+
+func TestStatsDWrite(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn)
+	s.Prefix = "ntp."
+	require.NoError(t, s.Write([]Metric{
+		{Desc: testGauge, LabelValues: []string{"10.0.0.1:123"}, Value: -0.5},
+		{Desc: testCounter, LabelValues: []string{"a|b", "3"}, Value: 7},
+		{Desc: testPlain, Value: 1},
+	}))
+	require.Len(t, conn.packets, 1)
+	assert.Equal(t, "ntp.ntp_test_offset_seconds:-0.5|g|#source:10.0.0.1:123\n"+
+		"ntp.ntp_test_polls_total:7|c|#source:a_b,mode:3\n"+
+		"ntp.ntp_test_up:1|g", conn.packets[0])
+}
+
+func TestStatsDCounterDeltas(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn)
+	write := func(v float64) {
+		require.NoError(t, s.Write([]Metric{{Desc: testCounter, LabelValues: []string{"x", "3"}, Value: v}}))
+	}
+	write(5)
+	write(5)
+	write(8)
+	// counter restarted
+	write(2)
+	assert.Equal(t, []string{
+		"ntp_test_polls_total:5|c|#source:x,mode:3",
+		"ntp_test_polls_total:3|c|#source:x,mode:3",
+		"ntp_test_polls_total:2|c|#source:x,mode:3",
+	}, conn.packets)
+}
+
+func TestStatsDPlainLabels(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn)
+	s.PlainLabels = true
+	require.NoError(t, s.Write([]Metric{{Desc: testGauge, LabelValues: []string{"10.0.0.1:123"}, Value: 2}}))
+	assert.Equal(t, []string{"ntp_test_offset_seconds.10_0_0_1_123:2|g"}, conn.packets)
+}
+
+func TestStatsDPackets(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn)
+	var metrics []Metric
+	for i := 0; i < 100; i++ {
+		metrics = append(metrics, Metric{Desc: testGauge, LabelValues: []string{strings.Repeat("s", 40)}, Value: float64(i)})
+	}
+	require.NoError(t, s.Write(metrics))
+	require.Greater(t, len(conn.packets), 1)
+	lines := 0
+	for _, p := range conn.packets {
+		assert.LessOrEqual(t, len(p), maxPacketSize)
+		lines += bytes.Count([]byte(p), []byte("\n")) + 1
+	}
+	assert.Equal(t, len(metrics), lines)
+}
+
+func TestStatsDError(t *testing.T) {
+	conn := &fakeConn{err: errors.New("refused")}
+	s := newStatsD(conn)
+	assert.EqualError(t, s.Write([]Metric{{Desc: testPlain, Value: 1}}), "refused")
+	assert.NoError(t, s.Write(nil))
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package telemetry abstracts metrics of client, server and discipline from where they are sent, so the same
+// metrics are scraped by Prometheus or pushed to StatsD and Datadog agents
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/facebookincubator/ntp/logging"
+)
+
+// Type of metric
+type Type int
+
+// Types of metrics
+const (
+	// Counter only grows, its value is the total so far
+	Counter Type = iota
+	// Gauge goes up and down
+	Gauge
+)
+
+// Desc describes a metric: its full name, e.g. ntp_client_offset_seconds, and names of its labels
+type Desc struct {
+	Name   string
+	Help   string
+	Type   Type
+	Labels []string
+}
+
+// NewDesc returns description of the metric
+func NewDesc(name, help string, t Type, labels ...string) *Desc {
+	return &Desc{Name: name, Help: help, Type: t, Labels: labels}
+}
+
+// Metric is the value of the described metric as of some instant, with label values in the order of label names
+type Metric struct {
+	Desc        *Desc
+	LabelValues []string
+	Value       float64
+}
+
+// Source reports metrics of a component: all of them it may ever report, and their current values
+type Source interface {
+	Descs() []*Desc
+	Metrics() []Metric
+}
+
+// Sink receives current values of metrics to send them elsewhere, like StatsD does
+type Sink interface {
+	Write(metrics []Metric) error
+}
+
+// Report writes current values of metrics of the sources to the sink
+func Report(sink Sink, sources ...Source) error {
+	var metrics []Metric
+	for _, s := range sources {
+		metrics = append(metrics, s.Metrics()...)
+	}
+	return sink.Write(metrics)
+}
+
+// Run reports metrics of the sources to the sink every interval until ctx is done.
+// Failed writes are logged and don't stop it, fresh values are written on the next tick
+func Run(ctx context.Context, logger logging.Logger, sink Sink, interval time.Duration, sources ...Source) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := Report(sink, sources...); err != nil {
+				logging.Or(logger).Error("Failed to report metrics", "error", err)
+			}
+		}
+	}
+}
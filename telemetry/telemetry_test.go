@@ -0,0 +1,60 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource reports the same metrics every time
+type fakeSource []Metric
+
+func (s fakeSource) Descs() []*Desc {
+	return []*Desc{testGauge, testCounter, testPlain}
+}
+
+func (s fakeSource) Metrics() []Metric {
+	return s
+}
+
+func TestReport(t *testing.T) {
+	conn := &fakeConn{}
+	require.NoError(t, Report(newStatsD(conn),
+		fakeSource{{Desc: testPlain, Value: 1}},
+		fakeSource{{Desc: testGauge, LabelValues: []string{"a"}, Value: 2}}))
+	assert.Equal(t, []string{"ntp_test_up:1|g\nntp_test_offset_seconds:2|g|#source:a"}, conn.packets)
+}
+
+func TestCollector(t *testing.T) {
+	r := prometheus.NewRegistry()
+	require.NoError(t, r.Register(NewCollector(fakeSource{
+		{Desc: testGauge, LabelValues: []string{"a"}, Value: 0.25},
+		{Desc: testCounter, LabelValues: []string{"a", "3"}, Value: 7},
+	})))
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, `ntp_test_offset_seconds{source="a"} 0.25`)
+	assert.Contains(t, body, `ntp_test_polls_total{mode="3",source="a"} 7`)
+	assert.NotContains(t, body, "ntp_test_up")
+}